@@ -0,0 +1,85 @@
+// pica-signer owns the CA keys and signs for a PiCA host that must not hold
+// them. pica-web reaches it through the remote provider.
+package main
+
+import (
+	"crypto/tls"
+	"log"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+
+	"github.com/billchurch/PiCA/internal/audit"
+	"github.com/billchurch/PiCA/internal/config"
+	"github.com/billchurch/PiCA/internal/crypto"
+	"github.com/billchurch/PiCA/internal/signer"
+)
+
+func main() {
+	// Load configuration
+	cfg, err := config.Load(os.Args[1:], "")
+	if err != nil {
+		log.Fatalf("Error loading configuration: %v", err)
+	}
+	if cfg.SignerListen == "" {
+		log.Fatal("No listen address: set --signer-listen to unix:/path or host:port")
+	}
+	if crypto.ProviderType(cfg.ProviderType) == crypto.RemoteProviderType {
+		log.Fatal("pica-signer must hold the keys itself and cannot use the remote provider")
+	}
+
+	// Every signature is recorded in the audit log
+	if cfg.AuditLog != "" {
+		auditLog, err := audit.Open(cfg.AuditLog)
+		if err != nil {
+			log.Fatalf("Error opening audit log: %v", err)
+		}
+		audit.SetDefault(auditLog)
+	}
+
+	// Without a policy file only the configured key slot may sign
+	var policy *signer.Policy
+	if cfg.SignerPolicy != "" {
+		if policy, err = signer.LoadPolicy(cfg.SignerPolicy); err != nil {
+			log.Fatal(err)
+		}
+	} else {
+		slotVal, _ := strconv.ParseInt(cfg.KeySlot, 16, 64)
+		policy = signer.DefaultPolicy(crypto.Slot(slotVal))
+	}
+
+	provider, err := cfg.OpenProvider()
+	if err != nil {
+		log.Fatalf("Error creating crypto provider: %v", err)
+	}
+	defer provider.Close()
+	// The daemon runs unattended: PINs come from the environment only
+	crypto.SetCredentials(provider, crypto.EnvCredentials())
+	log.Printf("Using crypto provider: %s (Hardware: %t)", provider.Name(), provider.IsHardware())
+
+	var tlsConfig *tls.Config
+	if network, _ := crypto.SplitRemoteAddress(cfg.SignerListen); network == "tcp" {
+		if tlsConfig, err = signer.ServerTLSConfig(cfg.SignerTLSCert, cfg.SignerTLSKey, cfg.SignerClientCA); err != nil {
+			log.Fatal(err)
+		}
+	}
+	l, err := signer.Listen(cfg.SignerListen, tlsConfig)
+	if err != nil {
+		log.Fatalf("Error listening on %s: %v", cfg.SignerListen, err)
+	}
+
+	// Stop accepting clients on SIGINT or SIGTERM
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-stop
+		l.Close()
+	}()
+
+	log.Printf("Signing with slots %v on %s", policy.Slots, cfg.SignerListen)
+	if err := signer.NewServer(provider, policy).Serve(l); err != nil {
+		log.Fatalf("Error serving: %v", err)
+	}
+	log.Printf("Signer stopped")
+}
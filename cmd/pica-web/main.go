@@ -1,20 +1,30 @@
 package main
 
 import (
+	"context"
+	"crypto/x509"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"path/filepath"
 	"strconv"
+	"time"
 
+	"golang.org/x/crypto/ssh"
+
+	"github.com/billchurch/PiCA/internal/audit"
 	"github.com/billchurch/PiCA/internal/ca"
 	"github.com/billchurch/PiCA/internal/config"
 	"github.com/billchurch/PiCA/internal/crypto"
+	"github.com/billchurch/PiCA/internal/webhook"
 	"github.com/billchurch/PiCA/internal/yubikey"
 	"github.com/billchurch/PiCA/web/api"
 )
 
+// credentials supplies the PIN and management key to hardware providers
+var credentials crypto.CredentialCallback
+
 func main() {
 	// Load configuration
 	cfg, err := config.Load(os.Args[1:], "")
@@ -24,41 +34,53 @@ func main() {
 
 	// Configuration validation is handled by config.Validate()
 
-	// Parse YubiKey slot (format already validated in config.Validate)
-	slotVal, _ := strconv.ParseInt(cfg.KeySlot, 16, 64)
-	slot := yubikey.PIVSlot(slotVal)
-
-	// Determine CA type
-	caType := ca.SubCA
-	if cfg.CAType == "root" {
-		caType = ca.RootCA
+	// Record issuance and lint results in the audit log
+	if cfg.AuditLog != "" {
+		auditLog, err := audit.Open(cfg.AuditLog)
+		if err != nil {
+			log.Fatalf("Error opening audit log: %v", err)
+		}
+		audit.SetDefault(auditLog)
 	}
 
-	// Create CA instance
-	caInstance := ca.NewCA(
-		caType,
-		cfg.CAConfigFile,
-		"", // Key file not needed when using YubiKey
-		cfg.CACertFile,
-	)
+	// Hardware providers take the PIN from the environment or, once
+	// unlocked through /api/unlock, from the credential cache; pica-web
+	// never waits for input on its terminal
+	cache := crypto.NewCredentialCache(config.DurationOr(cfg.PINCacheTimeout, 15*time.Minute))
+	credentials = crypto.ChainCredentials(crypto.EnvCredentials(), cache.Callback)
 
-	// Set up crypto provider if specified
-	if cfg.ProviderType != "" {
-		// Force specific provider type
-		os.Setenv("PICA_PROVIDER", cfg.ProviderType)
+	// Serve several CAs when a hierarchy file is configured, otherwise the
+	// single CA described by the CA settings
+	var server *api.Server
+	if cfg.HierarchyFile != "" {
+		registry, closeProviders, err := newRegistry(cfg)
+		if err != nil {
+			log.Fatalf("Error setting up CAs: %v", err)
+		}
+		defer closeProviders()
+		server = api.NewServerWithRegistry(registry)
+	} else {
+		server = newSingleCAServer(cfg)
+		defer server.CA.Provider.Close()
 	}
+	server.Credentials = cache
 
-	// Initialize provider
-	provider, err := crypto.CreateDefaultProvider()
+	// Key attestations sent with CSRs are verified against the bundled
+	// Yubico roots and the configured ones
+	attestationRoots, err := ca.LoadAttestationRoots(cfg.AttestationRoots)
 	if err != nil {
-		log.Fatalf("Error creating crypto provider: %v", err)
+		log.Fatalf("Error loading attestation roots: %v", err)
+	}
+	if attestationRoots.Equal(x509.NewCertPool()) {
+		log.Printf("Warning: no attestation roots are trusted; profiles requiring attestation reject every request until attestation_roots is set")
+	}
+	if server.Registry != nil {
+		for _, entry := range server.Registry.Entries() {
+			entry.CA.AttestationRoots = attestationRoots
+		}
+	} else {
+		server.CA.AttestationRoots = attestationRoots
 	}
-	defer provider.Close()
-
-	log.Printf("Using crypto provider: %s (Hardware: %t)", provider.Name(), provider.IsHardware())
-
-	// Create API server
-	server := api.NewServer(caInstance, slot, cfg.CertDir, cfg.CSRDir)
 
 	// Create required directories
 	for _, dir := range []string{cfg.CertDir, cfg.CSRDir, cfg.LogDir} {
@@ -67,6 +89,57 @@ func main() {
 		}
 	}
 
+	// Set up webhook delivery if configured
+	if cfg.WebhooksFile != "" {
+		subs, err := webhook.LoadSubscriptions(cfg.WebhooksFile)
+		if err != nil {
+			log.Fatalf("Error loading webhooks: %v", err)
+		}
+		queue, err := webhook.OpenQueue(filepath.Join(cfg.DatabaseDir, "webhooks"))
+		if err != nil {
+			log.Fatalf("Error opening webhook queue: %v", err)
+		}
+		server.Webhooks = webhook.NewDispatcher(subs, queue)
+		go server.Webhooks.Run(context.Background())
+		log.Printf("Webhooks enabled: %d subscriptions, %d pending deliveries", len(subs), queue.Len())
+	}
+
+	// Issue SSH certificates when SSH profiles are configured
+	if cfg.SSHConfigFile != "" {
+		sshCA, err := newSSHCA(cfg, server)
+		if err != nil {
+			log.Fatalf("Error setting up SSH CA: %v", err)
+		}
+		if server.CA == nil {
+			defer sshCA.Provider.Close()
+		}
+		server.SSH = sshCA
+	}
+
+	// Answer time-stamp requests when a TSA certificate is configured
+	if cfg.TSACertFile != "" {
+		tsa, err := newTSA(cfg, server)
+		if err != nil {
+			log.Fatalf("Error setting up time-stamping authority: %v", err)
+		}
+		if server.CA == nil {
+			defer tsa.Provider.Close()
+		}
+		server.TSA = tsa
+	}
+
+	// Keep the CRLs fresh for online CAs; the offline root CA gets its CRL
+	// during a ceremony instead
+	if server.Registry != nil {
+		for _, entry := range server.Registry.Entries() {
+			if entry.CRLPublisher, err = startCRLPublisher(cfg, server, entry.Name, entry.CA); err != nil {
+				log.Fatalf("Error starting CRL publisher for %s: %v", entry.Name, err)
+			}
+		}
+	} else if server.CRLPublisher, err = startCRLPublisher(cfg, server, api.DefaultCAName, server.CA); err != nil {
+		log.Fatalf("Error starting CRL publisher: %v", err)
+	}
+
 	// Set up static file serving
 	webDir, err := filepath.Abs(cfg.WebRoot)
 	if err != nil {
@@ -86,7 +159,7 @@ func main() {
 	if cfg.EnableHTTPS {
 		// We can safely use the values here because they're validated in config.Validate()
 		log.Printf("HTTPS enabled with certificate: %s", cfg.WebTLSCert)
-		if err := http.ListenAndServeTLS(addr, cfg.WebTLSCert, cfg.WebTLSKey, nil); err != nil {
+		if err := server.StartServerTLS(addr, cfg.WebTLSCert, cfg.WebTLSKey); err != nil {
 			log.Fatalf("Error starting HTTPS server: %v", err)
 		}
 	} else {
@@ -96,3 +169,206 @@ func main() {
 		}
 	}
 }
+
+// newSingleCAServer creates the API server for the CA described by the
+// single-CA settings
+func newSingleCAServer(cfg *config.Config) *api.Server {
+	// Parse YubiKey slot (format already validated in config.Validate)
+	slotVal, _ := strconv.ParseInt(cfg.KeySlot, 16, 64)
+	slot := yubikey.PIVSlot(slotVal)
+
+	// Determine CA type
+	caType := ca.SubCA
+	if cfg.CAType == "root" {
+		caType = ca.RootCA
+	}
+
+	// Create CA instance
+	caInstance := ca.NewCA(
+		caType,
+		cfg.CAConfigFile,
+		"", // Key file not needed when using YubiKey
+		cfg.CACertFile,
+	)
+	caInstance.DatabaseDir = cfg.DatabaseDir
+	caInstance.CRLFile = cfg.CRLFile
+	caInstance.CRLDistributionPoints = config.SplitList(cfg.CRLURL)
+	caInstance.DeltaCRLDistributionPoints = config.SplitList(cfg.DeltaCRLURL)
+
+	// Set up crypto provider if specified
+	provider, err := cfg.OpenProvider()
+	if err != nil {
+		log.Fatalf("Error creating crypto provider: %v", err)
+	}
+
+	log.Printf("Using crypto provider: %s (Hardware: %t)", provider.Name(), provider.IsHardware())
+	crypto.SetCredentials(provider, credentials)
+
+	caInstance.Provider = provider
+	caInstance.Slot = crypto.FromYubiKeySlot(slot)
+
+	// Create API server
+	return api.NewServer(caInstance, slot, cfg.CertDir, cfg.CSRDir)
+}
+
+// newRegistry creates a registry with the CAs of the hierarchy file. Every
+// CA gets its own certificate, CSR and database directory below the
+// configured ones unless the hierarchy file sets a database directory.
+func newRegistry(cfg *config.Config) (*api.Registry, func() error, error) {
+	h, err := ca.LoadHierarchy(cfg.HierarchyFile)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Serve the listed CAs, or every CA except the (offline) roots
+	var defs []*ca.CADefinition
+	if names := config.SplitList(cfg.WebCAs); len(names) > 0 {
+		for _, name := range names {
+			def, err := h.Get(name)
+			if err != nil {
+				return nil, nil, err
+			}
+			defs = append(defs, def)
+		}
+	} else {
+		for _, def := range h.Ordered() {
+			if !def.IsRoot() {
+				defs = append(defs, def)
+			}
+		}
+	}
+	if len(defs) == 0 {
+		return nil, nil, fmt.Errorf("no CAs to serve in %s", cfg.HierarchyFile)
+	}
+
+	providers := ca.NewProviderPool()
+	providers.Credentials = credentials
+	registry := api.NewRegistry()
+	registry.Default = cfg.CAName
+	for _, def := range defs {
+		provider, err := providers.Get(def)
+		if err != nil {
+			providers.Close()
+			return nil, nil, err
+		}
+		caInstance, err := def.NewCA(provider, cfg.DatabaseDir)
+		if err != nil {
+			providers.Close()
+			return nil, nil, err
+		}
+
+		entry := &api.CAEntry{
+			Name:    def.Name,
+			CA:      caInstance,
+			Slot:    caInstance.Slot,
+			CertDir: filepath.Join(cfg.CertDir, def.Name),
+			CSRDir:  filepath.Join(cfg.CSRDir, def.Name),
+		}
+		if err := registry.Add(entry); err != nil {
+			providers.Close()
+			return nil, nil, err
+		}
+		log.Printf("Serving CA %s using %s slot %s", def.Name, provider.Name(), def.Slot)
+	}
+
+	if _, ok := registry.DefaultCA(); !ok {
+		providers.Close()
+		return nil, nil, fmt.Errorf("default CA %q is not served", registry.Default)
+	}
+	return registry, providers.Close, nil
+}
+
+// newSSHCA creates the SSH CA. It shares the provider of the single CA;
+// with a hierarchy file it opens the default provider.
+func newSSHCA(cfg *config.Config, server *api.Server) (*ca.SSHCA, error) {
+	var provider crypto.Provider
+	if server.CA != nil {
+		provider = server.CA.Provider
+	} else {
+		var err error
+		if provider, err = cfg.OpenProvider(); err != nil {
+			return nil, fmt.Errorf("error creating crypto provider: %w", err)
+		}
+		crypto.SetCredentials(provider, credentials)
+	}
+
+	slot := crypto.SlotSSH
+	if slotVal, err := strconv.ParseInt(cfg.SSHKeySlot, 16, 64); err == nil {
+		slot = crypto.Slot(slotVal)
+	}
+	sshCA := ca.NewSSHCA(provider, slot, cfg.SSHConfigFile, ca.SSHDatabaseDir(cfg.DatabaseDir))
+	sshCA.KRLFile = cfg.SSHKRLFile
+
+	// Fail at startup rather than on the first request
+	if _, err := sshCA.Config(); err != nil {
+		return nil, err
+	}
+	key, err := sshCA.PublicKey()
+	if err != nil {
+		return nil, fmt.Errorf("%w (create it with `pica ssh init`)", err)
+	}
+	log.Printf("SSH CA enabled: %s key %s in slot %X", key.Type(), ssh.FingerprintSHA256(key), int(slot))
+	return sshCA, nil
+}
+
+// newTSA creates the time-stamping authority, sharing the provider the way
+// newSSHCA does
+func newTSA(cfg *config.Config, server *api.Server) (*ca.TSA, error) {
+	var provider crypto.Provider
+	if server.CA != nil {
+		provider = server.CA.Provider
+	} else {
+		var err error
+		if provider, err = cfg.OpenProvider(); err != nil {
+			return nil, fmt.Errorf("error creating crypto provider: %w", err)
+		}
+		crypto.SetCredentials(provider, credentials)
+	}
+
+	slot := crypto.SlotTSA
+	if slotVal, err := strconv.ParseInt(cfg.TSAKeySlot, 16, 64); err == nil {
+		slot = crypto.Slot(slotVal)
+	}
+	tsa, err := ca.NewTSA(provider, slot, cfg.TSACertFile, cfg.TSAPolicy, ca.TSADatabaseDir(cfg.DatabaseDir))
+	if err != nil {
+		return nil, fmt.Errorf("%w (create the TSA certificate with `pica tsa init`)", err)
+	}
+	if cfg.TSAAccuracy != "" {
+		// Format already validated in config.Validate
+		if tsa.Accuracy, err = time.ParseDuration(cfg.TSAAccuracy); err != nil {
+			return nil, err
+		}
+	}
+	log.Printf("Time-stamping authority enabled: %s, policy %s, accuracy %s",
+		tsa.Certificate.Subject.CommonName, tsa.Policy, tsa.Accuracy)
+	return tsa, nil
+}
+
+// startCRLPublisher keeps the CRL of an online CA fresh, announcing every
+// new CRL through the webhooks
+func startCRLPublisher(cfg *config.Config, server *api.Server, name string, caInstance *ca.CA) (*ca.CRLPublisher, error) {
+	if caInstance.CRLFile == "" || caInstance.Type == ca.RootCA {
+		return nil, nil
+	}
+
+	publisher := ca.NewCRLPublisher(caInstance)
+	publisher.Validity = config.DurationOr(cfg.CRLValidity, ca.DefaultCRLValidity)
+	publisher.RenewBefore = config.DurationOr(cfg.CRLRenewBefore, ca.DefaultCRLRenewBefore)
+	publisher.DeltaInterval = config.DurationOr(cfg.DeltaCRLInterval, 0)
+	publisher.OnPublish = func(info *ca.CRLInfo) {
+		server.Webhooks.Publish(webhook.EventCRLPublished, map[string]interface{}{
+			"ca":         name,
+			"number":     info.Number,
+			"delta":      info.Delta,
+			"entries":    info.Entries,
+			"thisUpdate": info.ThisUpdate,
+			"nextUpdate": info.NextUpdate,
+		})
+	}
+	if err := publisher.Validate(); err != nil {
+		return nil, err
+	}
+	go publisher.Run(context.Background())
+	return publisher, nil
+}
+
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"flag"
+
+	"github.com/billchurch/PiCA/internal/ca"
+	"github.com/billchurch/PiCA/internal/ca/commands"
+)
+
+// runAttest implements `pica attest`
+func runAttest(args []string) error {
+	var out string
+
+	cfg, err := loadConfig(args, func(fs *flag.FlagSet) {
+		fs.StringVar(&out, "out", "", "Where to write the PEM attestation chain")
+	})
+	if err != nil {
+		return err
+	}
+
+	roots, err := ca.LoadAttestationRoots(cfg.AttestationRoots)
+	if err != nil {
+		return err
+	}
+
+	provider, err := openProvider(cfg)
+	if err != nil {
+		return err
+	}
+	defer provider.Close()
+
+	cmd := commands.NewAttestCommand(provider, keySlot(cfg), out)
+	cmd.Roots = roots
+	return cmd.Execute()
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/billchurch/PiCA/internal/ca/commands"
+	"github.com/billchurch/PiCA/internal/config"
+	"github.com/billchurch/PiCA/internal/crypto"
+)
+
+// listFlag collects the values of a flag given several times
+type listFlag []string
+
+func (l *listFlag) String() string { return strings.Join(*l, " ") }
+
+func (l *listFlag) Set(val string) error {
+	*l = append(*l, val)
+	return nil
+}
+
+// runCeremony implements `pica ceremony init|recover|verify`
+func runCeremony(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: pica ceremony <init|recover|verify> [flags]")
+	}
+
+	var csrFile, custodians, witnesses, out, backup, transcript string
+	var threshold int
+	var copyTo, shares listFlag
+	cfg, err := loadConfig(args[1:], func(fs *flag.FlagSet) {
+		fs.StringVar(&csrFile, "csr", "", "Root CA certificate request (init)")
+		fs.StringVar(&custodians, "custodians", "", "Comma-separated custodians receiving a backup share (init)")
+		fs.IntVar(&threshold, "threshold", 0, "Number of custodians needed to recover the key (init)")
+		fs.StringVar(&witnesses, "witnesses", "", "Comma-separated witnesses recorded in the transcript")
+		fs.StringVar(&out, "out", "", "Directory for the backup, shares and transcript")
+		fs.Var(&copyTo, "copy-to", "Additional provider receiving the key, as type[:key=value,...]; may be repeated")
+		fs.StringVar(&backup, "backup", "", "Ceremony backup (recover, verify)")
+		fs.Var(&shares, "share", "Custodian share file; may be repeated (recover)")
+		fs.StringVar(&transcript, "transcript", "", "Ceremony transcript (verify)")
+	})
+	if err != nil {
+		return err
+	}
+
+	if args[0] == "verify" {
+		if transcript == "" {
+			return fmt.Errorf("--transcript is required")
+		}
+		t, err := commands.VerifyTranscript(transcript, cfg.CACertFile, backup)
+		if err != nil {
+			return err
+		}
+		fingerprint, _ := t.Fingerprint()
+		fmt.Printf("Transcript of %s ceremony %s verified\n", t.Kind, t.ID)
+		fmt.Println("Fingerprint:", fingerprint)
+		for _, p := range t.Participants {
+			fmt.Printf("  %-10s %s\n", p.Role, p.Name)
+		}
+		for _, step := range t.Steps {
+			fmt.Printf("  %s %-12s %s\n", step.Time.Format("15:04:05"), step.Action, step.Detail)
+		}
+		return nil
+	}
+
+	if out == "" {
+		return fmt.Errorf("--out is required")
+	}
+	providers, err := ceremonyProviders(cfg, copyTo)
+	for _, p := range providers {
+		defer p.Close()
+	}
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "init":
+		if csrFile == "" || cfg.CACertFile == "" {
+			return fmt.Errorf("both --csr and --ca-cert are required")
+		}
+		cmd := commands.NewCeremonyCommand(csrFile, cfg.CACertFile, out, config.SplitList(custodians), threshold, providers, keySlot(cfg))
+		cmd.Witnesses = config.SplitList(witnesses)
+		cmd.CAName = cfg.CAName
+		return cmd.Execute()
+	case "recover":
+		if backup == "" || len(shares) == 0 {
+			return fmt.Errorf("--backup and at least one --share are required")
+		}
+		cmd := commands.NewRecoveryCommand(backup, shares, out, providers, keySlot(cfg))
+		cmd.Witnesses = config.SplitList(witnesses)
+		cmd.CertificateFile = cfg.CACertFile
+		cmd.CAName = cfg.CAName
+		return cmd.Execute()
+	default:
+		return fmt.Errorf("unknown ceremony command: %s", args[0])
+	}
+}
+
+// ceremonyProviders opens the configured provider followed by every
+// --copy-to provider. The providers opened so far are returned on error so
+// they can be closed.
+func ceremonyProviders(cfg *config.Config, specs []string) ([]crypto.Provider, error) {
+	primary, err := openProvider(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.ProviderType != "" && primary.Type() != crypto.ProviderType(cfg.ProviderType) {
+		primary.Close()
+		return nil, fmt.Errorf("the %s provider is not available; a ceremony does not fall back to another provider", cfg.ProviderType)
+	}
+	providers := []crypto.Provider{primary}
+	for _, spec := range specs {
+		p, err := openProviderSpec(spec)
+		if err != nil {
+			return providers, err
+		}
+		providers = append(providers, p)
+	}
+	return providers, nil
+}
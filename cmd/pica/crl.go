@@ -0,0 +1,56 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/billchurch/PiCA/internal/ca/commands"
+	"github.com/billchurch/PiCA/internal/config"
+)
+
+// runCRL implements `pica crl`
+func runCRL(args []string) error {
+	var daemon, delta bool
+	var validity string
+
+	cfg, err := loadConfig(args, func(fs *flag.FlagSet) {
+		fs.BoolVar(&daemon, "daemon", false, "Keep regenerating the CRL on a schedule")
+		fs.BoolVar(&delta, "delta", false, "Generate a delta CRL instead of a full CRL")
+		fs.StringVar(&validity, "validity", "", "Override the CRL validity period")
+	})
+	if err != nil {
+		return err
+	}
+
+	if cfg.CACertFile == "" || cfg.CRLFile == "" {
+		return fmt.Errorf("both --ca-cert and --crl-file are required")
+	}
+
+	provider, err := openProvider(cfg)
+	if err != nil {
+		return err
+	}
+	defer provider.Close()
+
+	caInstance := newCAFromConfig(cfg)
+	cmd := commands.NewCRLCommand(caInstance, provider, keySlot(cfg))
+	cmd.Daemon = daemon
+	cmd.Delta = delta
+
+	// Durations are validated in config.Validate
+	if cfg.CAType == "root" {
+		cmd.Validity = config.DurationOr(cfg.RootCRLValidity, cmd.Validity)
+	} else {
+		cmd.Validity = config.DurationOr(cfg.CRLValidity, cmd.Validity)
+		cmd.RenewBefore = config.DurationOr(cfg.CRLRenewBefore, 0)
+	}
+	cmd.DeltaInterval = config.DurationOr(cfg.DeltaCRLInterval, 0)
+	if validity != "" {
+		if cmd.Validity, err = time.ParseDuration(validity); err != nil {
+			return fmt.Errorf("invalid validity: %w", err)
+		}
+	}
+
+	return cmd.Execute()
+}
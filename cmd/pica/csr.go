@@ -0,0 +1,40 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/billchurch/PiCA/internal/ca"
+	"github.com/billchurch/PiCA/internal/ca/commands"
+)
+
+// runCSR implements `pica csr`
+func runCSR(args []string) error {
+	var csrFile, out string
+	var newKey bool
+
+	cfg, err := loadConfig(args, func(fs *flag.FlagSet) {
+		fs.StringVar(&csrFile, "csr", "", "cfssl JSON certificate request")
+		fs.StringVar(&out, "out", "", "Where to write the PEM-encoded CSR")
+		fs.BoolVar(&newKey, "new-key", false, "Generate a new key even if the slot already holds one")
+	})
+	if err != nil {
+		return err
+	}
+	if csrFile == "" || out == "" {
+		return fmt.Errorf("both --csr and --out are required")
+	}
+
+	provider, err := openProvider(cfg)
+	if err != nil {
+		return err
+	}
+	defer provider.Close()
+
+	cmd := commands.NewCSRCommand(csrFile, out, provider, keySlot(cfg))
+	cmd.GenerateKey = newKey
+	if cmd.AttestationRoots, err = ca.LoadAttestationRoots(cfg.AttestationRoots); err != nil {
+		return err
+	}
+	return cmd.Execute()
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/billchurch/PiCA/internal/audit"
+	"github.com/billchurch/PiCA/internal/ca"
+	"github.com/billchurch/PiCA/internal/config"
+	"github.com/billchurch/PiCA/internal/crypto"
+	"github.com/charmbracelet/x/term"
+)
+
+// loadConfig loads the configuration for a subcommand. When --ca-name
+// selects a CA from the hierarchy file, that CA's settings replace the
+// single-CA settings.
+func loadConfig(args []string, register func(*flag.FlagSet)) (*config.Config, error) {
+	cfg, _, err := config.LoadWithFlags(args, "", register)
+	if err != nil {
+		return nil, err
+	}
+	if err := openAuditLog(cfg); err != nil {
+		return nil, err
+	}
+	if cfg.CAName == "" {
+		return cfg, nil
+	}
+
+	h, def, err := caDefinition(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg.CAType = "sub"
+	if def.IsRoot() {
+		cfg.CAType = "root"
+	} else if parent, err := h.Get(def.Parent); err == nil {
+		cfg.RootCACertFile = parent.Certificate
+		cfg.RootCAConfigFile = parent.Config
+	}
+	cfg.CAConfigFile = def.Config
+	cfg.CACertFile = def.Certificate
+	cfg.KeySlot = def.Slot
+	if def.Provider != "" {
+		cfg.ProviderType = def.Provider
+	}
+	cfg.DatabaseDir = def.DatabasePath(cfg.DatabaseDir)
+	if def.CRLFile != "" {
+		cfg.CRLFile = def.CRLFile
+	}
+	if len(def.CRLURLs) > 0 {
+		cfg.CRLURL = strings.Join(def.CRLURLs, ",")
+	}
+	return cfg, nil
+}
+
+// openAuditLog makes the configured audit log the default for CA operations
+func openAuditLog(cfg *config.Config) error {
+	if cfg.AuditLog == "" {
+		return nil
+	}
+	l, err := audit.Open(cfg.AuditLog)
+	if err != nil {
+		return fmt.Errorf("error opening audit log: %w", err)
+	}
+	audit.SetDefault(l)
+	return nil
+}
+
+// caDefinition returns the hierarchy and the CA selected with --ca-name
+func caDefinition(cfg *config.Config) (*ca.Hierarchy, *ca.CADefinition, error) {
+	if cfg.HierarchyFile == "" {
+		return nil, nil, fmt.Errorf("--ca-name requires --hierarchy")
+	}
+	h, err := ca.LoadHierarchy(cfg.HierarchyFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	def, err := h.Get(cfg.CAName)
+	if err != nil {
+		return nil, nil, err
+	}
+	return h, def, nil
+}
+
+// newCAFromConfig creates a CA instance from the loaded configuration
+func newCAFromConfig(cfg *config.Config) *ca.CA {
+	caType := ca.SubCA
+	if cfg.CAType == "root" {
+		caType = ca.RootCA
+	}
+
+	caInstance := ca.NewCA(caType, cfg.CAConfigFile, "", cfg.CACertFile)
+	caInstance.DatabaseDir = cfg.DatabaseDir
+	caInstance.CRLFile = cfg.CRLFile
+	caInstance.CRLDistributionPoints = config.SplitList(cfg.CRLURL)
+	caInstance.DeltaCRLDistributionPoints = config.SplitList(cfg.DeltaCRLURL)
+	return caInstance
+}
+
+// openProvider creates the crypto provider selected by the configuration
+func openProvider(cfg *config.Config) (crypto.Provider, error) {
+	// A CA from the hierarchy file brings its own provider options
+	if cfg.CAName != "" {
+		_, def, err := caDefinition(cfg)
+		if err != nil {
+			return nil, err
+		}
+		pool := ca.NewProviderPool()
+		pool.Credentials = cliCredentials()
+		return pool.Get(def)
+	}
+
+	provider, err := cfg.OpenProvider()
+	if err != nil {
+		return nil, fmt.Errorf("error creating crypto provider: %w", err)
+	}
+	crypto.SetCredentials(provider, cliCredentials())
+	return provider, nil
+}
+
+// openProviderSpec opens a provider given as "type" or "type:key=value,...",
+// e.g. "yubikey:serial=12345678". Unlike the configured provider it never
+// falls back to software keys unless asked to.
+func openProviderSpec(spec string) (crypto.Provider, error) {
+	providerType, parsed, err := parseProviderSpec(spec)
+	if err != nil {
+		return nil, err
+	}
+	opts := map[string]interface{}{"fallback": false}
+	for k, v := range parsed {
+		opts[k] = v
+	}
+	provider, err := crypto.OpenProvider(providerType, opts)
+	if err != nil {
+		return nil, fmt.Errorf("error opening %s provider: %w", providerType, err)
+	}
+	crypto.SetCredentials(provider, cliCredentials())
+	return provider, nil
+}
+
+// parseProviderSpec splits "type:key=value,..." into the type and options
+func parseProviderSpec(spec string) (crypto.ProviderType, map[string]string, error) {
+	providerType, options, _ := strings.Cut(spec, ":")
+	if providerType == "" {
+		return "", nil, fmt.Errorf("invalid provider %q, expected type[:key=value,...]", spec)
+	}
+	parsed, err := config.ParseOptions(options)
+	if err != nil {
+		return "", nil, err
+	}
+	return crypto.ProviderType(providerType), parsed, nil
+}
+
+// cliCredentials supplies PINs and management keys from the environment,
+// prompting on the terminal when there is one
+func cliCredentials() crypto.CredentialCallback {
+	env := crypto.EnvCredentials()
+	if !term.IsTerminal(os.Stdin.Fd()) {
+		return env
+	}
+	return crypto.ChainCredentials(env, func(req crypto.CredentialRequest) (string, error) {
+		label := fmt.Sprintf("%s %s", req.Provider, req.Kind)
+		if req.Retries >= 0 {
+			label += fmt.Sprintf(" (%d retries left)", req.Retries)
+		}
+		return readSecret(label)
+	})
+}
+
+// readSecret reads a secret from the terminal without echoing it. An empty
+// answer cancels.
+func readSecret(label string) (string, error) {
+	if !term.IsTerminal(os.Stdin.Fd()) {
+		return "", fmt.Errorf("%w: reading the %s needs a terminal", crypto.ErrCredentialUnavailable, label)
+	}
+	fmt.Fprintf(os.Stderr, "%s: ", label)
+	secret, err := term.ReadPassword(os.Stdin.Fd())
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", err
+	}
+	if len(secret) == 0 {
+		return "", crypto.ErrCredentialUnavailable
+	}
+	return string(secret), nil
+}
+
+// keySlot returns the configured key slot, falling back to the CA default
+func keySlot(cfg *config.Config) crypto.Slot {
+	slot := crypto.SlotCA2
+	if cfg.CAType == "root" {
+		slot = crypto.SlotCA1
+	}
+	if cfg.KeySlot != "" {
+		// Format already validated in config.Validate
+		if slotVal, err := strconv.ParseInt(cfg.KeySlot, 16, 64); err == nil {
+			slot = crypto.Slot(slotVal)
+		}
+	}
+	return slot
+}
+
+// newSSHCAFromConfig creates the SSH CA from the loaded configuration
+func newSSHCAFromConfig(cfg *config.Config, provider crypto.Provider) *ca.SSHCA {
+	slot := crypto.SlotSSH
+	// Format already validated in config.Validate
+	if slotVal, err := strconv.ParseInt(cfg.SSHKeySlot, 16, 64); err == nil {
+		slot = crypto.Slot(slotVal)
+	}
+
+	sshCA := ca.NewSSHCA(provider, slot, cfg.SSHConfigFile, ca.SSHDatabaseDir(cfg.DatabaseDir))
+	sshCA.KRLFile = cfg.SSHKRLFile
+	return sshCA
+}
+
+// tsaKeySlot returns the configured slot of the time-stamping key
+func tsaKeySlot(cfg *config.Config) crypto.Slot {
+	slot := crypto.SlotTSA
+	// Format already validated in config.Validate
+	if slotVal, err := strconv.ParseInt(cfg.TSAKeySlot, 16, 64); err == nil {
+		slot = crypto.Slot(slotVal)
+	}
+	return slot
+}
+
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/billchurch/PiCA/internal/ca"
+	"github.com/billchurch/PiCA/internal/ca/commands"
+)
+
+// runHierarchy implements `pica hierarchy init|show`
+func runHierarchy(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: pica hierarchy <init|show> [flags]")
+	}
+
+	var name string
+	cfg, err := loadConfig(args[1:], func(fs *flag.FlagSet) {
+		fs.StringVar(&name, "name", "", "Only initialize this CA")
+	})
+	if err != nil {
+		return err
+	}
+	if cfg.HierarchyFile == "" {
+		return fmt.Errorf("--hierarchy is required")
+	}
+
+	h, err := ca.LoadHierarchy(cfg.HierarchyFile)
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "init":
+		providers := ca.NewProviderPool()
+		defer providers.Close()
+
+		cmd := commands.NewHierarchyInitCommand(h, providers)
+		cmd.Name = name
+		return cmd.Execute()
+	case "show":
+		commands.PrintHierarchy(os.Stdout, h)
+		return nil
+	default:
+		return fmt.Errorf("unknown hierarchy command: %s", args[0])
+	}
+}
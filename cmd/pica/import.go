@@ -0,0 +1,58 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/billchurch/PiCA/internal/ca/commands"
+)
+
+// runImport implements `pica import`
+func runImport(args []string) error {
+	var keyFile, certFile, chainFile, passwordFile, indexFile, certsDir string
+	var force bool
+
+	cfg, err := loadConfig(args, func(fs *flag.FlagSet) {
+		fs.StringVar(&keyFile, "key", "", "Private key (PEM, DER, PKCS#8 or PKCS#12)")
+		fs.StringVar(&certFile, "cert", "", "CA certificate, optionally followed by its chain")
+		fs.StringVar(&chainFile, "chain", "", "Issuer chain for the CA certificate")
+		fs.StringVar(&passwordFile, "password-file", "", "File holding the key or PKCS#12 password")
+		fs.StringVar(&indexFile, "index", "", "OpenSSL index.txt to import issuance history from")
+		fs.StringVar(&certsDir, "certs-dir", "", "OpenSSL new_certs_dir holding <serial>.pem copies")
+		fs.BoolVar(&force, "force", false, "Replace a key already present in the slot")
+	})
+	if err != nil {
+		return err
+	}
+	if keyFile == "" || cfg.CACertFile == "" {
+		return fmt.Errorf("both --key and --ca-cert are required")
+	}
+
+	// Passwords are never taken on the command line where they would end up
+	// in shell history
+	password := []byte(os.Getenv("PICA_IMPORT_PASSWORD"))
+	if passwordFile != "" {
+		data, err := os.ReadFile(passwordFile)
+		if err != nil {
+			return fmt.Errorf("error reading password file: %w", err)
+		}
+		password = []byte(strings.TrimRight(string(data), "\r\n"))
+	}
+
+	provider, err := openProvider(cfg)
+	if err != nil {
+		return err
+	}
+	defer provider.Close()
+
+	cmd := commands.NewImportCommand(keyFile, certFile, cfg.CACertFile, provider, keySlot(cfg))
+	cmd.ChainFile = chainFile
+	cmd.Password = password
+	cmd.IndexFile = indexFile
+	cmd.CertsDir = certsDir
+	cmd.DatabaseDir = cfg.DatabaseDir
+	cmd.Force = force
+	return cmd.Execute()
+}
@@ -10,12 +10,45 @@ import (
 	tea "github.com/charmbracelet/bubbletea"
 )
 
+// subcommands maps non-interactive command names to their handlers
+var subcommands = map[string]func(args []string) error{
+	"attest":      runAttest,
+	"ceremony":    runCeremony,
+	"crl":         runCRL,
+	"csr":         runCSR,
+	"hierarchy":   runHierarchy,
+	"import":      runImport,
+	"migrate-key": runMigrateKey,
+	"offline":     runOffline,
+	"pin":         runPIN,
+	"policy":      runPolicy,
+	"rollover":    runRollover,
+	"slots":       runSlots,
+	"ssh":         runSSH,
+	"template":    runTemplate,
+	"tsa":         runTSA,
+}
+
 func main() {
+	// Run a non-interactive subcommand if one was requested
+	if len(os.Args) > 1 {
+		if run, ok := subcommands[os.Args[1]]; ok {
+			if err := run(os.Args[2:]); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+
 	// Load configuration
 	cfg, err := config.Load(os.Args[1:], "")
 	if err != nil {
 		log.Fatalf("Error loading configuration: %v", err)
 	}
+	if err := openAuditLog(cfg); err != nil {
+		log.Fatal(err)
+	}
 
 	// Create UI model with configuration
 	model := ui.NewModelWithConfig(cfg)
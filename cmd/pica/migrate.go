@@ -0,0 +1,129 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strconv"
+
+	"github.com/billchurch/PiCA/internal/ca/commands"
+	"github.com/billchurch/PiCA/internal/config"
+	"github.com/billchurch/PiCA/internal/crypto"
+)
+
+// runMigrateKey implements `pica migrate-key`
+func runMigrateKey(args []string) error {
+	var to, toSlot string
+	var wipe, yes, noUpdate bool
+	cfg, err := loadConfig(args, func(fs *flag.FlagSet) {
+		fs.StringVar(&to, "to", "", "Target provider as type[:key=value,...] (default: the configured provider)")
+		fs.StringVar(&toSlot, "to-slot", "", "Target slot in hex (default: the key slot)")
+		fs.BoolVar(&wipe, "wipe", false, "Delete the source key once the copy is verified")
+		fs.BoolVar(&yes, "yes", false, "Wipe without asking for confirmation")
+		fs.BoolVar(&noUpdate, "no-update-config", false, "Leave the config or hierarchy file unchanged")
+	})
+	if err != nil {
+		return err
+	}
+	if to == "" && toSlot == "" {
+		return fmt.Errorf("--to or --to-slot is required")
+	}
+
+	source, err := openProvider(cfg)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+	sourceSlot := keySlot(cfg)
+
+	target := source
+	var targetType crypto.ProviderType
+	var targetOptions map[string]string
+	if to != "" {
+		if targetType, targetOptions, err = parseProviderSpec(to); err != nil {
+			return err
+		}
+		if target, err = openProviderSpec(to); err != nil {
+			return err
+		}
+		defer target.Close()
+	}
+	targetSlot := sourceSlot
+	if toSlot != "" {
+		val, err := strconv.ParseInt(toSlot, 16, 64)
+		if err != nil {
+			return fmt.Errorf("invalid slot %q", toSlot)
+		}
+		targetSlot = crypto.Slot(val)
+	}
+
+	cmd := commands.NewMigrateKeyCommand(source, sourceSlot, target, targetSlot)
+	cmd.Wipe = wipe
+	cmd.AssumeYes = yes
+	cmd.CAName = cfg.CAName
+	if err := cmd.Execute(); err != nil {
+		return err
+	}
+
+	if noUpdate {
+		return nil
+	}
+	// The config names one provider for all keys
+	if to != "" && cfg.CAName == "" {
+		if slots, err := source.ListSlots(); err == nil {
+			for _, s := range slots {
+				if s.HasKey && s.Slot != sourceSlot {
+					fmt.Printf("Warning: slot %X of %s also holds a key; migrate it too before using the updated config\n", int(s.Slot), source.Name())
+				}
+			}
+		}
+	}
+	return updateKeyLocation(cfg, to != "", targetType, targetOptions, targetSlot)
+}
+
+// updateKeyLocation points the hierarchy definition of --ca-name, or the
+// config file, at the migrated key. The provider is only changed when the
+// key moved to another one.
+func updateKeyLocation(cfg *config.Config, moved bool, providerType crypto.ProviderType, options map[string]string, slot crypto.Slot) error {
+	slotHex := fmt.Sprintf("%X", int(slot))
+
+	if cfg.CAName != "" {
+		h, def, err := caDefinition(cfg)
+		if err != nil {
+			return err
+		}
+		def.Slot = slotHex
+		if moved {
+			def.Provider = string(providerType)
+			def.ProviderOptions = nil
+			if len(options) > 0 {
+				def.ProviderOptions = map[string]interface{}{}
+				for k, v := range options {
+					def.ProviderOptions[k] = v
+				}
+			}
+		}
+		if err := h.Save(cfg.HierarchyFile); err != nil {
+			return err
+		}
+		fmt.Printf("Updated %s in %s\n", cfg.CAName, cfg.HierarchyFile)
+		return nil
+	}
+
+	if !moved {
+		providerType, options = crypto.ProviderType(cfg.ProviderType), cfg.ProviderOptions
+	}
+	file := config.FindConfigFile()
+	if file == "" {
+		fmt.Println("No config file found; point PiCA at the key with:")
+		fmt.Printf("  provider = %q\n  key_slot = %q\n", providerType, slotHex)
+		for k, v := range options {
+			fmt.Printf("  provider option %s = %q\n", k, v)
+		}
+		return nil
+	}
+	if err := config.UpdateKeyLocation(file, string(providerType), options, slotHex); err != nil {
+		return err
+	}
+	fmt.Printf("Updated %s (previous version in %s.bak)\n", file, file)
+	return nil
+}
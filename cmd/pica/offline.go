@@ -0,0 +1,133 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/billchurch/PiCA/internal/ca/commands"
+	"github.com/billchurch/PiCA/internal/config"
+)
+
+// runOffline implements `pica offline request|sign|import`
+func runOffline(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: pica offline <request|sign|import> [flags]")
+	}
+
+	switch args[0] {
+	case "request":
+		return runOfflineRequest(args[1:])
+	case "sign":
+		return runOfflineSign(args[1:])
+	case "import":
+		return runOfflineImport(args[1:])
+	default:
+		return fmt.Errorf("unknown offline command: %s", args[0])
+	}
+}
+
+// runOfflineRequest runs on the Sub CA and exports a request bundle
+func runOfflineRequest(args []string) error {
+	var csrFile, out, comment string
+	var newKey bool
+
+	cfg, err := loadConfig(args, func(fs *flag.FlagSet) {
+		fs.StringVar(&csrFile, "csr", "", "cfssl JSON certificate request for the Sub CA")
+		fs.StringVar(&out, "out", "", "Where to write the request bundle (e.g. on removable media)")
+		fs.StringVar(&comment, "comment", "", "Free-form comment shown to the Root CA operator")
+		fs.BoolVar(&newKey, "new-key", false, "Replace a key already in the slot")
+	})
+	if err != nil {
+		return err
+	}
+	if csrFile == "" || out == "" {
+		return fmt.Errorf("both --csr and --out are required")
+	}
+
+	provider, err := openProvider(cfg)
+	if err != nil {
+		return err
+	}
+	defer provider.Close()
+
+	cmd := commands.NewOfflineRequestCommand(csrFile, out, provider, keySlot(cfg))
+	cmd.Comment = comment
+	cmd.NewKey = newKey
+	return cmd.Execute()
+}
+
+// runOfflineSign runs on the air-gapped Root CA
+func runOfflineSign(args []string) error {
+	var in, out, expiry, passthrough string
+	var pathLen int
+	var yes bool
+
+	cfg, err := loadConfig(args, func(fs *flag.FlagSet) {
+		fs.StringVar(&in, "in", "", "Request bundle exported by the Sub CA")
+		fs.StringVar(&out, "out", "", "Where to write the response bundle")
+		fs.StringVar(&expiry, "expiry", "", "Sub CA certificate validity, overriding the one in the request (default: the requested one, or 5 years)")
+		fs.IntVar(&pathLen, "pathlen", 0, "Path length of the Sub CA certificate: 0 for an issuing CA, 1 or more for a policy CA, -1 for unconstrained")
+		fs.StringVar(&passthrough, "passthrough", "", "Comma-separated OIDs of requested extensions to copy into the Sub CA certificate")
+		fs.BoolVar(&yes, "yes", false, "Sign without asking for confirmation")
+	})
+	if err != nil {
+		return err
+	}
+	if in == "" || out == "" {
+		return fmt.Errorf("both --in and --out are required")
+	}
+	if cfg.CACertFile == "" {
+		return fmt.Errorf("--ca-cert must point at the Root CA certificate")
+	}
+
+	// This command always acts as the Root CA
+	cfg.CAType = "root"
+
+	provider, err := openProvider(cfg)
+	if err != nil {
+		return err
+	}
+	defer provider.Close()
+
+	cmd := commands.NewOfflineSignCommand(newCAFromConfig(cfg), in, out, provider, keySlot(cfg))
+	cmd.AssumeYes = yes
+	cmd.PathLen = pathLen
+	cmd.Passthrough = config.SplitList(passthrough)
+	cmd.CRLValidity = config.DurationOr(cfg.RootCRLValidity, cmd.CRLValidity)
+	if expiry != "" {
+		if cmd.Expiry, err = time.ParseDuration(expiry); err != nil {
+			return fmt.Errorf("invalid expiry: %w", err)
+		}
+	}
+	return cmd.Execute()
+}
+
+// runOfflineImport runs on the Sub CA and installs the signed certificate
+func runOfflineImport(args []string) error {
+	var in, rootCRL string
+
+	cfg, err := loadConfig(args, func(fs *flag.FlagSet) {
+		fs.StringVar(&in, "in", "", "Response bundle exported by the Root CA")
+		fs.StringVar(&rootCRL, "root-crl", "", "Where to save the Root CA CRL from the bundle")
+	})
+	if err != nil {
+		return err
+	}
+	if in == "" {
+		return fmt.Errorf("--in is required")
+	}
+	if cfg.RootCACertFile == "" || cfg.CACertFile == "" {
+		return fmt.Errorf("both --root-ca-cert and --ca-cert are required")
+	}
+
+	provider, err := openProvider(cfg)
+	if err != nil {
+		return err
+	}
+	defer provider.Close()
+
+	cmd := commands.NewOfflineImportCommand(in, cfg.RootCACertFile, cfg.CACertFile, provider, keySlot(cfg))
+	cmd.CRLFile = rootCRL
+	return cmd.Execute()
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/billchurch/PiCA/internal/ca/commands"
+)
+
+// runPIN implements `pica pin status|change|change-puk|unblock|change-management-key`
+func runPIN(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: pica pin <status|change|change-puk|unblock|change-management-key> [flags]")
+	}
+
+	cfg, err := loadConfig(args[1:], nil)
+	if err != nil {
+		return err
+	}
+
+	provider, err := openProvider(cfg)
+	if err != nil {
+		return err
+	}
+	defer provider.Close()
+
+	cmd := commands.NewPINCommand(provider, args[0])
+	switch args[0] {
+	case commands.PINChange:
+		if cmd.Current, err = readSecret("Current PIN"); err != nil {
+			return err
+		}
+		cmd.New, err = readNewSecret("New PIN")
+	case commands.PINChangePUK:
+		if cmd.Current, err = readSecret("Current PUK"); err != nil {
+			return err
+		}
+		cmd.New, err = readNewSecret("New PUK")
+	case commands.PINUnblock:
+		if cmd.Current, err = readSecret("PUK"); err != nil {
+			return err
+		}
+		cmd.New, err = readNewSecret("New PIN")
+	case commands.PINChangeManagementKey:
+		// The current key comes from the provider's credential prompt
+		cmd.New, err = readNewSecret("New management key (hex)")
+	}
+	if err != nil {
+		return err
+	}
+	return cmd.Execute()
+}
+
+// readNewSecret reads a new secret twice to rule out typos
+func readNewSecret(label string) (string, error) {
+	secret, err := readSecret(label)
+	if err != nil {
+		return "", err
+	}
+	again, err := readSecret("Repeat " + label)
+	if err != nil {
+		return "", err
+	}
+	if secret != again {
+		return "", fmt.Errorf("the values do not match")
+	}
+	return secret, nil
+}
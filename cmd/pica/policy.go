@@ -0,0 +1,29 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/billchurch/PiCA/internal/ca/commands"
+)
+
+// runPolicy implements `pica policy test`
+func runPolicy(args []string) error {
+	if len(args) == 0 || args[0] != "test" {
+		return fmt.Errorf("usage: pica policy test --csr <file> [--profile <name>]")
+	}
+
+	var csrFile, profile string
+	cfg, err := loadConfig(args[1:], func(fs *flag.FlagSet) {
+		fs.StringVar(&csrFile, "csr", "", "PEM-encoded CSR to evaluate")
+		fs.StringVar(&profile, "profile", "", "Signing profile whose policy to apply (defaults to the default profile)")
+	})
+	if err != nil {
+		return err
+	}
+	if csrFile == "" {
+		return fmt.Errorf("--csr is required")
+	}
+
+	return commands.NewPolicyTestCommand(newCAFromConfig(cfg), csrFile, profile).Execute()
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/billchurch/PiCA/internal/ca"
+	"github.com/billchurch/PiCA/internal/ca/commands"
+	"github.com/billchurch/PiCA/internal/config"
+	"github.com/billchurch/PiCA/internal/crypto"
+)
+
+// runRollover implements `pica rollover renew|rekey|cross-sign|show|chains`
+func runRollover(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: pica rollover <renew|rekey|cross-sign|show|chains> [flags]")
+	}
+
+	var expiry, newSlot, algo, subjectFile, out, parentSlot string
+	var size int
+	var noLink bool
+
+	cfg, err := loadConfig(args[1:], func(fs *flag.FlagSet) {
+		fs.StringVar(&expiry, "expiry", "", "Validity of the new certificate")
+		fs.StringVar(&newSlot, "new-slot", "", "Slot for the new key (rekey)")
+		fs.StringVar(&algo, "algo", "ecdsa", "Algorithm of the new key: ecdsa or rsa (rekey)")
+		fs.IntVar(&size, "size", 384, "Curve size or RSA modulus of the new key (rekey)")
+		fs.BoolVar(&noLink, "no-link", false, "Do not cross-sign the old and new root certificates (rekey)")
+		fs.StringVar(&subjectFile, "cert", "", "CA certificate to cross-sign (cross-sign)")
+		fs.StringVar(&out, "out", "", "Output file (cross-sign) or directory (chains)")
+		fs.StringVar(&parentSlot, "parent-slot", "", "Slot of the parent CA key for a sub CA (defaults to the root CA slot)")
+	})
+	if err != nil {
+		return err
+	}
+	if cfg.CACertFile == "" {
+		return fmt.Errorf("--ca-cert is required")
+	}
+
+	caInstance := newCAFromConfig(cfg)
+	caInstance.Slot = keySlot(cfg)
+
+	// Read-only commands do not need the key
+	switch args[0] {
+	case "show":
+		return commands.PrintCertificateSet(os.Stdout, caInstance)
+	case "chains":
+		if out == "" {
+			return fmt.Errorf("--out is required")
+		}
+		files, err := commands.WriteChains(caInstance, out)
+		for _, file := range files {
+			fmt.Println("Chain saved to:", file)
+		}
+		return err
+	}
+
+	validity := ca.DefaultIntermediateCAExpiry
+	if cfg.CAType == "root" {
+		validity = ca.DefaultRootCAExpiry
+	}
+	if expiry != "" {
+		if validity, err = time.ParseDuration(expiry); err != nil {
+			return fmt.Errorf("invalid expiry: %w", err)
+		}
+	}
+
+	provider, err := openProvider(cfg)
+	if err != nil {
+		return err
+	}
+	defer provider.Close()
+
+	switch args[0] {
+	case "renew":
+		cmd := commands.NewRenewCommand(caInstance, validity, provider, keySlot(cfg))
+		if cfg.CAType != "root" {
+			if cmd.Parent, err = parentKey(cfg, provider, parentSlot); err != nil {
+				return err
+			}
+		}
+		return cmd.Execute()
+	case "rekey":
+		if newSlot == "" {
+			return fmt.Errorf("--new-slot is required")
+		}
+		slot, err := strconv.ParseInt(newSlot, 16, 64)
+		if err != nil {
+			return fmt.Errorf("invalid slot %q", newSlot)
+		}
+		cmd := commands.NewRekeyCommand(caInstance, crypto.Slot(slot), validity, provider, keySlot(cfg))
+		cmd.Algorithm = strings.ToUpper(algo)
+		cmd.Bits = size
+		cmd.Link = !noLink
+		if cfg.CAType != "root" {
+			if cmd.Parent, err = parentKey(cfg, provider, parentSlot); err != nil {
+				return err
+			}
+		}
+		return cmd.Execute()
+	case "cross-sign":
+		if subjectFile == "" || out == "" {
+			return fmt.Errorf("both --cert and --out are required")
+		}
+		return commands.NewCrossSignCommand(caInstance, subjectFile, out, validity, provider, keySlot(cfg)).Execute()
+	default:
+		return fmt.Errorf("unknown rollover command: %s", args[0])
+	}
+}
+
+// parentKey returns the key of the CA above a sub CA. With --ca-name it is
+// the parent from the hierarchy file; otherwise the root CA certificate and
+// a slot in the same provider.
+func parentKey(cfg *config.Config, provider crypto.Provider, slot string) (*ca.CAKey, error) {
+	if cfg.CAName != "" {
+		h, def, err := caDefinition(cfg)
+		if err != nil {
+			return nil, err
+		}
+		parent, err := h.Get(def.Parent)
+		if err != nil {
+			return nil, err
+		}
+		cert, err := ca.LoadCertificate(parent.Certificate)
+		if err != nil {
+			return nil, err
+		}
+		parentSlot, err := parent.KeySlot()
+		if err != nil {
+			return nil, err
+		}
+		parentProvider, err := ca.NewProviderPool().Get(parent)
+		if err != nil {
+			return nil, err
+		}
+		return &ca.CAKey{Provider: parentProvider, Slot: parentSlot, Certificate: cert}, nil
+	}
+
+	if cfg.RootCACertFile == "" {
+		return nil, fmt.Errorf("--root-ca-cert is required for a sub CA")
+	}
+	cert, err := ca.LoadCertificate(cfg.RootCACertFile)
+	if err != nil {
+		return nil, err
+	}
+	parentSlot := crypto.SlotCA1
+	if slot != "" {
+		val, err := strconv.ParseInt(slot, 16, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid slot %q", slot)
+		}
+		parentSlot = crypto.Slot(val)
+	}
+	return &ca.CAKey{Provider: provider, Slot: parentSlot, Certificate: cert}, nil
+}
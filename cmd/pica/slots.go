@@ -0,0 +1,50 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strconv"
+
+	"github.com/billchurch/PiCA/internal/ca/commands"
+	"github.com/billchurch/PiCA/internal/crypto"
+)
+
+// runSlots implements `pica slots list|delete`
+func runSlots(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: pica slots <list|delete> [flags]")
+	}
+
+	var slot string
+	var yes bool
+	cfg, err := loadConfig(args[1:], func(fs *flag.FlagSet) {
+		fs.StringVar(&slot, "slot", "", "Slot to delete in hex, e.g. 82 (delete)")
+		fs.BoolVar(&yes, "yes", false, "Delete without asking for confirmation")
+	})
+	if err != nil {
+		return err
+	}
+
+	cmd := commands.NewSlotsCommand(nil, args[0])
+	cmd.AssumeYes = yes
+	if args[0] == commands.SlotsDelete {
+		// No default: the configured key slot is usually a live CA key
+		if slot == "" {
+			return fmt.Errorf("--slot is required")
+		}
+		slotVal, err := strconv.ParseInt(slot, 16, 64)
+		if err != nil {
+			return fmt.Errorf("invalid slot %q: %w", slot, err)
+		}
+		cmd.Slot = crypto.Slot(slotVal)
+	}
+
+	provider, err := openProvider(cfg)
+	if err != nil {
+		return err
+	}
+	defer provider.Close()
+
+	cmd.Provider = provider
+	return cmd.Execute()
+}
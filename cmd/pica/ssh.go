@@ -0,0 +1,75 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/billchurch/PiCA/internal/ca"
+	"github.com/billchurch/PiCA/internal/ca/commands"
+	"github.com/billchurch/PiCA/internal/config"
+)
+
+// runSSH implements `pica ssh init|sign|list|revoke|krl|pubkey`
+func runSSH(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: pica ssh <init|sign|list|revoke|krl|pubkey> [flags]")
+	}
+
+	var keyFile, keyID, principals, profile, validity, out string
+	var serial, reason, algo string
+	var size int
+
+	cfg, err := loadConfig(args[1:], func(fs *flag.FlagSet) {
+		fs.StringVar(&keyFile, "key", "", "Public key to certify (sign)")
+		fs.StringVar(&keyID, "key-id", "", "Certificate key ID, defaults to the first principal (sign)")
+		fs.StringVar(&principals, "principals", "", "Comma-separated user names or host names (sign)")
+		fs.StringVar(&profile, "profile", "", "SSH profile (sign)")
+		fs.StringVar(&validity, "validity", "", "Certificate validity, at most the profile's (sign)")
+		fs.StringVar(&out, "out", "", "Certificate file, defaults to <key>-cert.pub (sign); KRL file, defaults to stdout (krl)")
+		fs.StringVar(&serial, "serial", "", "Serial number of the certificate to revoke (revoke)")
+		fs.StringVar(&reason, "reason", "", "Revocation reason (revoke)")
+		fs.StringVar(&algo, "algo", "ecdsa", "Algorithm of the CA key: ecdsa or rsa (init)")
+		fs.IntVar(&size, "size", 256, "Curve size or RSA modulus of the CA key (init)")
+	})
+	if err != nil {
+		return err
+	}
+
+	provider, err := openProvider(cfg)
+	if err != nil {
+		return err
+	}
+	defer provider.Close()
+
+	cmd := commands.NewSSHCommand(newSSHCAFromConfig(cfg, provider), args[0])
+	cmd.Algorithm = strings.ToUpper(algo)
+	cmd.Bits = size
+	cmd.Serial = serial
+	cmd.Reason = reason
+	cmd.File = out
+
+	if args[0] == commands.SSHSign {
+		if keyFile == "" {
+			return fmt.Errorf("--key is required")
+		}
+		data, err := os.ReadFile(keyFile)
+		if err != nil {
+			return fmt.Errorf("error reading public key: %w", err)
+		}
+		cmd.Request = &ca.SSHRequest{
+			PublicKey:  string(data),
+			KeyID:      keyID,
+			Principals: config.SplitList(principals),
+			Profile:    profile,
+			Validity:   validity,
+		}
+		if cmd.File == "" {
+			// Where ssh and ssh-keygen look for the certificate
+			cmd.File = strings.TrimSuffix(keyFile, ".pub") + "-cert.pub"
+		}
+	}
+
+	return cmd.Execute()
+}
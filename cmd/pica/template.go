@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/billchurch/PiCA/internal/ca"
+	"github.com/billchurch/PiCA/internal/ca/commands"
+	"github.com/billchurch/PiCA/internal/config"
+)
+
+// runTemplate implements `pica template list|show|set|delete|history|rollback|export|import`
+func runTemplate(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: pica template <list|show|set|delete|history|rollback|export|import> [flags]")
+	}
+
+	var fs *flag.FlagSet
+	var name, file, comment string
+	var version int
+	var description, keyUsages, extKeyUsages, validity, policies, ocspURL, issuerURLs string
+	var organization, unit, country, province, locality string
+	var requireSAN bool
+	var sanTypes string
+	var maxSANs int
+
+	cfg, err := loadConfig(args[1:], func(f *flag.FlagSet) {
+		fs = f
+		f.StringVar(&name, "name", "", "Template name")
+		f.StringVar(&file, "file", "", "Template JSON (set), or cfssl config to read (import) or write (export)")
+		f.IntVar(&version, "version", 0, "Template version (show, rollback)")
+		f.StringVar(&comment, "comment", "", "Description of the change (set, import)")
+		f.StringVar(&description, "description", "", "Template description (set)")
+		f.StringVar(&keyUsages, "key-usages", "", "Comma-separated key usages (set)")
+		f.StringVar(&extKeyUsages, "ext-key-usages", "", "Comma-separated extended key usages (set)")
+		f.StringVar(&validity, "validity", "", "Certificate validity, e.g. 2160h (set)")
+		f.StringVar(&policies, "policies", "", "Comma-separated certificate policy OIDs (set)")
+		f.StringVar(&ocspURL, "ocsp-url", "", "OCSP responder URL (set)")
+		f.StringVar(&issuerURLs, "issuer-urls", "", "Comma-separated CA issuer URLs (set)")
+		f.StringVar(&organization, "o", "", "Default subject organization (set)")
+		f.StringVar(&unit, "ou", "", "Default subject organizational unit (set)")
+		f.StringVar(&country, "c", "", "Default subject country (set)")
+		f.StringVar(&province, "st", "", "Default subject state or province (set)")
+		f.StringVar(&locality, "l", "", "Default subject locality (set)")
+		f.BoolVar(&requireSAN, "require-san", false, "Require at least one SAN (set)")
+		f.StringVar(&sanTypes, "san-types", "", "Comma-separated allowed SAN types: dns, ip, email, uri (set)")
+		f.IntVar(&maxSANs, "max-sans", 0, "Maximum number of SANs (set)")
+	})
+	if err != nil {
+		return err
+	}
+
+	caInstance := newCAFromConfig(cfg)
+	cmd := commands.NewTemplateCommand(caInstance, args[0], name)
+	cmd.Version = version
+	cmd.Comment = comment
+	cmd.File = file
+
+	if args[0] == commands.TemplateSet {
+		cmd.File = ""
+		if cmd.Template, err = templateFromFlags(caInstance, name, file); err != nil {
+			return err
+		}
+
+		// Flags override the template file or the stored template
+		t := cmd.Template
+		fs.Visit(func(f *flag.Flag) {
+			switch f.Name {
+			case "description":
+				t.Description = description
+			case "key-usages":
+				t.KeyUsages = config.SplitList(keyUsages)
+			case "ext-key-usages":
+				t.ExtKeyUsages = config.SplitList(extKeyUsages)
+			case "validity":
+				t.Validity = validity
+			case "policies":
+				t.Policies = config.SplitList(policies)
+			case "ocsp-url":
+				t.OCSPURL = ocspURL
+			case "issuer-urls":
+				t.IssuerURLs = config.SplitList(issuerURLs)
+			case "o":
+				subjectDefaults(t).Organization = organization
+			case "ou":
+				subjectDefaults(t).OrganizationalUnit = unit
+			case "c":
+				subjectDefaults(t).Country = country
+			case "st":
+				subjectDefaults(t).Province = province
+			case "l":
+				subjectDefaults(t).Locality = locality
+			case "require-san", "san-types", "max-sans":
+				if t.SANs == nil {
+					t.SANs = &ca.SANRequirements{}
+				}
+				switch f.Name {
+				case "require-san":
+					t.SANs.Required = requireSAN
+				case "san-types":
+					t.SANs.Types = config.SplitList(sanTypes)
+				case "max-sans":
+					t.SANs.Max = maxSANs
+				}
+			}
+		})
+	}
+
+	return cmd.Execute()
+}
+
+// templateFromFlags returns the template to update with `pica template set`:
+// the template file if one is given, otherwise the stored template or a new
+// one
+func templateFromFlags(caInstance *ca.CA, name, file string) (*ca.Template, error) {
+	if file != "" {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("error reading template: %w", err)
+		}
+		var t ca.Template
+		if err := json.Unmarshal(data, &t); err != nil {
+			return nil, fmt.Errorf("error parsing template: %w", err)
+		}
+		if name != "" {
+			t.Name = name
+		}
+		return &t, nil
+	}
+
+	if name == "" {
+		return nil, fmt.Errorf("--name or --file is required")
+	}
+	store, err := caInstance.Templates()
+	if err != nil {
+		return nil, err
+	}
+	t, err := store.Get(name)
+	if errors.Is(err, ca.ErrTemplateNotFound) {
+		return &ca.Template{Name: name}, nil
+	}
+	return t, err
+}
+
+// subjectDefaults returns the subject defaults of a template, adding them
+// if missing
+func subjectDefaults(t *ca.Template) *ca.SubjectDefaults {
+	if t.Subject == nil {
+		t.Subject = &ca.SubjectDefaults{}
+	}
+	return t.Subject
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/cloudflare/cfssl/csr"
+
+	"github.com/billchurch/PiCA/internal/ca"
+	"github.com/billchurch/PiCA/internal/ca/commands"
+)
+
+// runTSA implements `pica tsa init|list`
+func runTSA(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: pica tsa <init|list> [flags]")
+	}
+
+	var cn, org, country, algo string
+	var size int
+	var newKey bool
+
+	cfg, err := loadConfig(args[1:], func(fs *flag.FlagSet) {
+		fs.StringVar(&cn, "cn", "PiCA Time Stamping Authority", "Common name of the TSA certificate (init)")
+		fs.StringVar(&org, "org", "", "Organization of the TSA certificate (init)")
+		fs.StringVar(&country, "country", "", "Country of the TSA certificate (init)")
+		fs.StringVar(&algo, "algo", "ecdsa", "Algorithm of the TSA key: ecdsa or rsa (init)")
+		fs.IntVar(&size, "size", 256, "Curve size or RSA modulus of the TSA key (init)")
+		fs.BoolVar(&newKey, "new-key", false, "Generate a new key even if the slot already holds one (init)")
+	})
+	if err != nil {
+		return err
+	}
+
+	caInstance := newCAFromConfig(cfg)
+	caInstance.Slot = keySlot(cfg)
+	cmd := commands.NewTSACommand(caInstance, args[0])
+	cmd.Slot = tsaKeySlot(cfg)
+	cmd.DatabaseDir = ca.TSADatabaseDir(cfg.DatabaseDir)
+
+	// Listing tokens does not need the key
+	if args[0] == commands.TSAList {
+		return cmd.Execute()
+	}
+
+	if cfg.TSACertFile == "" {
+		return fmt.Errorf("--tsa-cert is required")
+	}
+	if cfg.CACertFile == "" || cfg.CAConfigFile == "" {
+		return fmt.Errorf("--ca-cert and --ca-config are required")
+	}
+
+	provider, err := openProvider(cfg)
+	if err != nil {
+		return err
+	}
+	defer provider.Close()
+
+	caInstance.Provider = provider
+	cmd.Provider = provider
+	cmd.CertFile = cfg.TSACertFile
+	cmd.GenerateKey = newKey
+	cmd.Request = &csr.CertificateRequest{
+		CN:         cn,
+		KeyRequest: &csr.KeyRequest{A: strings.ToLower(algo), S: size},
+	}
+	if org != "" || country != "" {
+		cmd.Request.Names = []csr.Name{{O: org, C: country}}
+	}
+	return cmd.Execute()
+}
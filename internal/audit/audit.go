@@ -0,0 +1,205 @@
+// Package audit records CA operations in an append-only, hash-chained log.
+// Every entry is one JSON object per line carrying the SHA-256 hash of the
+// previous entry, so removing or editing an entry breaks the chain.
+package audit
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Event types
+const (
+	EventLint            = "certificate.lint"
+	EventIssued          = "certificate.issued"
+	EventRejected        = "certificate.rejected"
+	EventCAIssued        = "ca.issued"
+	EventCARejected      = "ca.rejected"
+	EventTemplateSaved   = "template.saved"
+	EventTemplateDeleted = "template.deleted"
+	EventSSHIssued       = "ssh.issued"
+	EventSSHRevoked      = "ssh.revoked"
+	EventTimestamp       = "timestamp.issued"
+	EventSignature       = "signer.signature"
+	EventSignRefused     = "signer.refused"
+	EventCeremony        = "ceremony.generation"
+	EventRecovery        = "ceremony.recovery"
+	EventKeyMigrated     = "key.migrated"
+)
+
+// Event is a single audit log entry
+type Event struct {
+	Time    time.Time              `json:"time"`
+	Type    string                 `json:"type"`
+	CA      string                 `json:"ca,omitempty"`
+	Subject string                 `json:"subject,omitempty"`
+	Serial  string                 `json:"serial,omitempty"`
+	Profile string                 `json:"profile,omitempty"`
+	Details map[string]interface{} `json:"details,omitempty"`
+
+	// Prev is the hash of the previous entry, Hash the hash of this one
+	Prev string `json:"prev"`
+	Hash string `json:"hash"`
+}
+
+// Log appends events to a file
+type Log struct {
+	mu   sync.Mutex
+	path string
+	last string
+}
+
+// Open opens or creates the audit log at path and verifies its chain
+func Open(path string) (*Log, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create audit log directory: %w", err)
+	}
+	last, err := verify(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	return &Log{path: path, last: last}, nil
+}
+
+// Path returns the file the log is written to
+func (l *Log) Path() string {
+	return l.path
+}
+
+// Record appends an event to the log
+func (l *Log) Record(e Event) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+	e.Prev = l.last
+	hash, err := e.digest()
+	if err != nil {
+		return err
+	}
+	e.Hash = hash
+
+	line, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("failed to encode audit event: %w", err)
+	}
+	f, err := os.OpenFile(l.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open audit log: %w", err)
+	}
+	defer f.Close()
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write audit log: %w", err)
+	}
+	l.last = e.Hash
+	return nil
+}
+
+// digest hashes the event without its own hash. The event is hashed in a
+// canonical form (sorted keys) so it can be verified after decoding.
+func (e Event) digest() (string, error) {
+	e.Hash = ""
+	data, err := json.Marshal(e)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode audit event: %w", err)
+	}
+	var canonical interface{}
+	if err := json.Unmarshal(data, &canonical); err != nil {
+		return "", fmt.Errorf("failed to encode audit event: %w", err)
+	}
+	if data, err = json.Marshal(canonical); err != nil {
+		return "", fmt.Errorf("failed to encode audit event: %w", err)
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// Read returns the events of an audit log after verifying its chain
+func Read(path string) ([]Event, error) {
+	var events []Event
+	_, err := walk(path, func(e Event) { events = append(events, e) })
+	return events, err
+}
+
+// Verify checks that no entry of the audit log was altered or removed
+func Verify(path string) error {
+	_, err := verify(path)
+	return err
+}
+
+func verify(path string) (string, error) {
+	return walk(path, nil)
+}
+
+// walk reads and verifies every entry, returning the hash of the last one
+func walk(path string, fn func(Event)) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	last := ""
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for n := 1; scanner.Scan(); n++ {
+		var e Event
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return "", fmt.Errorf("audit log line %d: %w", n, err)
+		}
+		if e.Prev != last {
+			return "", fmt.Errorf("audit log line %d: chain broken", n)
+		}
+		hash, err := e.digest()
+		if err != nil {
+			return "", err
+		}
+		if hash != e.Hash {
+			return "", fmt.Errorf("audit log line %d: entry was modified", n)
+		}
+		if fn != nil {
+			fn(e)
+		}
+		last = e.Hash
+	}
+	if err := scanner.Err(); err != nil {
+		return "", fmt.Errorf("failed to read audit log: %w", err)
+	}
+	return last, nil
+}
+
+var (
+	defaultMu  sync.RWMutex
+	defaultLog *Log
+)
+
+// SetDefault sets the log used by Record; nil disables auditing
+func SetDefault(l *Log) {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	defaultLog = l
+}
+
+// Record appends an event to the default log, if one is set. Failures are
+// reported on stderr rather than failing the audited operation.
+func Record(e Event) {
+	defaultMu.RLock()
+	l := defaultLog
+	defaultMu.RUnlock()
+	if l == nil {
+		return
+	}
+	if err := l.Record(e); err != nil {
+		fmt.Fprintf(os.Stderr, "audit: %v\n", err)
+	}
+}
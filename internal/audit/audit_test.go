@@ -0,0 +1,63 @@
+package audit
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAuditLogChain(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "logs", "audit.log")
+
+	l, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	for _, serial := range []string{"01", "02"} {
+		if err := l.Record(Event{Type: EventIssued, Serial: serial, Details: map[string]interface{}{
+			"lint": struct {
+				Severity string `json:"severity"`
+				Count    int    `json:"count"`
+			}{"warn", 2},
+		}}); err != nil {
+			t.Fatalf("Record failed: %v", err)
+		}
+	}
+
+	// Reopening continues the chain
+	l, err = Open(path)
+	if err != nil {
+		t.Fatalf("Reopen failed: %v", err)
+	}
+	if err := l.Record(Event{Type: EventRejected, Serial: "03"}); err != nil {
+		t.Fatalf("Record failed: %v", err)
+	}
+
+	events, err := Read(path)
+	if err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	if len(events) != 3 || events[2].Prev != events[1].Hash || events[0].Prev != "" {
+		t.Fatalf("Unexpected events: %+v", events)
+	}
+
+	// Editing an entry breaks verification
+	data, _ := os.ReadFile(path)
+	tampered := strings.Replace(string(data), `"serial":"02"`, `"serial":"04"`, 1)
+	if err := os.WriteFile(path, []byte(tampered), 0600); err != nil {
+		t.Fatalf("Failed to write log: %v", err)
+	}
+	if err := Verify(path); err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("Expected line 2 to fail verification, got %v", err)
+	}
+
+	// So does removing one
+	lines := strings.SplitAfter(string(data), "\n")
+	if err := os.WriteFile(path, []byte(lines[0]+lines[2]), 0600); err != nil {
+		t.Fatalf("Failed to write log: %v", err)
+	}
+	if err := Verify(path); err == nil {
+		t.Error("Expected removed entry to fail verification")
+	}
+}
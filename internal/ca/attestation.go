@@ -0,0 +1,188 @@
+package ca
+
+import (
+	gocrypto "crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/billchurch/PiCA/internal/yubikey"
+)
+
+// AttestationRequirement restricts a profile to keys a YubiKey attests it
+// generated. It is configured in the "pica" object of a cfssl signing
+// profile under "attestation". An attestation sent with a request is
+// always verified and recorded; the requirement decides whether one must
+// be sent.
+type AttestationRequirement struct {
+	// Required rejects requests without a valid attestation
+	Required bool `json:"required,omitempty"`
+	// PINPolicies lists the accepted PIN policies of the key: "never",
+	// "once" or "always"; empty accepts any
+	PINPolicies []string `json:"pin_policies,omitempty"`
+	// TouchPolicies lists the accepted touch policies of the key:
+	// "never", "always" or "cached"; empty accepts any
+	TouchPolicies []string `json:"touch_policies,omitempty"`
+}
+
+// AttestationError is returned by SignCertificate when a request's key
+// attestation is missing, invalid or not accepted by the profile
+type AttestationError struct {
+	Err error
+}
+
+func (e *AttestationError) Error() string {
+	return "key attestation rejected: " + e.Err.Error()
+}
+
+func (e *AttestationError) Unwrap() error {
+	return e.Err
+}
+
+// Validate checks the policy names of the requirement
+func (r *AttestationRequirement) Validate() error {
+	for _, p := range r.PINPolicies {
+		switch p {
+		case "never", "once", "always":
+		default:
+			return fmt.Errorf("unknown PIN policy %q in attestation requirement", p)
+		}
+	}
+	for _, p := range r.TouchPolicies {
+		switch p {
+		case "never", "always", "cached":
+		default:
+			return fmt.Errorf("unknown touch policy %q in attestation requirement", p)
+		}
+	}
+	return nil
+}
+
+// check reports why an attested key does not meet the requirement
+func (r *AttestationRequirement) check(a *yubikey.Attestation) error {
+	if pin := yubikey.PINPolicyName(a.PINPolicy); len(r.PINPolicies) > 0 && !contains(r.PINPolicies, pin) {
+		return fmt.Errorf("PIN policy %q is not allowed", pin)
+	}
+	if touch := yubikey.TouchPolicyName(a.TouchPolicy); len(r.TouchPolicies) > 0 && !contains(r.TouchPolicies, touch) {
+		return fmt.Errorf("touch policy %q is not allowed", touch)
+	}
+	return nil
+}
+
+// contains reports whether list holds s
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseAttestation decodes a PEM attestation: the slot attestation
+// certificate followed by the device certificate and any intermediates
+func ParseAttestation(data []byte) ([]*x509.Certificate, error) {
+	var chain []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse attestation certificate: %w", err)
+		}
+		chain = append(chain, cert)
+	}
+	if len(chain) < 2 {
+		return nil, errors.New("an attestation needs the slot and the device certificate")
+	}
+	return chain, nil
+}
+
+// EncodeAttestation PEM-encodes an attestation chain
+func EncodeAttestation(chain []*x509.Certificate) []byte {
+	var data []byte
+	for _, cert := range chain {
+		data = append(data, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})...)
+	}
+	return data
+}
+
+// VerifyKeyAttestation verifies an attestation chain against the roots,
+// the bundled Yubico roots when nil, and checks that it attests pub
+func VerifyKeyAttestation(chain []*x509.Certificate, pub gocrypto.PublicKey, roots *x509.CertPool) (*yubikey.Attestation, error) {
+	if len(chain) < 2 {
+		return nil, errors.New("an attestation needs the slot and the device certificate")
+	}
+	a, err := yubikey.VerifyAttestation(chain[0], chain[1:], roots)
+	if err != nil {
+		return nil, err
+	}
+	if !publicKeysMatch(a.PublicKey, pub) {
+		return nil, errors.New("the attestation is for a different key")
+	}
+	return a, nil
+}
+
+// AttestationMetadata returns the issuance record metadata of an attested key
+func AttestationMetadata(a *yubikey.Attestation) map[string]string {
+	return map[string]string{
+		"attestation_serial":       strconv.FormatUint(uint64(a.Serial), 10),
+		"attestation_firmware":     a.Version,
+		"attestation_slot":         fmt.Sprintf("%X", int(a.Slot)),
+		"attestation_pin_policy":   yubikey.PINPolicyName(a.PINPolicy),
+		"attestation_touch_policy": yubikey.TouchPolicyName(a.TouchPolicy),
+	}
+}
+
+// LoadAttestationRoots returns the bundled Yubico roots together with the
+// PEM certificates of file, if one is given
+func LoadAttestationRoots(file string) (*x509.CertPool, error) {
+	roots := yubikey.YubicoRoots()
+	if file == "" {
+		return roots, nil
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read attestation roots: %w", err)
+	}
+	if !roots.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificates in %s", file)
+	}
+	return roots, nil
+}
+
+// checkAttestation verifies the attestation sent with a request against
+// the profile's requirement and returns it, or nil when none was sent and
+// none is required
+func (ca *CA) checkAttestation(attestation []byte, pub gocrypto.PublicKey, req *AttestationRequirement) (*yubikey.Attestation, error) {
+	if len(attestation) == 0 {
+		if req != nil && req.Required {
+			return nil, &AttestationError{Err: errors.New("the profile requires a key attestation")}
+		}
+		return nil, nil
+	}
+
+	chain, err := ParseAttestation(attestation)
+	if err != nil {
+		return nil, &AttestationError{Err: err}
+	}
+	a, err := VerifyKeyAttestation(chain, pub, ca.AttestationRoots)
+	if err != nil {
+		return nil, &AttestationError{Err: err}
+	}
+	if req != nil {
+		if err := req.check(a); err != nil {
+			return nil, &AttestationError{Err: err}
+		}
+	}
+	return a, nil
+}
@@ -0,0 +1,212 @@
+package ca
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/billchurch/PiCA/internal/yubikey"
+)
+
+const testAttestationConfig = `{
+	"signing": {
+		"default": {"expiry": "8760h"},
+		"profiles": {
+			"device": {
+				"usages": ["signing", "client auth"],
+				"expiry": "8760h",
+				"pica": {
+					"attestation": {
+						"required": true,
+						"pin_policies": ["once", "always"],
+						"touch_policies": ["always", "cached"]
+					}
+				}
+			}
+		}
+	}
+}`
+
+// attestationFixture is an attestation laid out like a YubiKey's: a root,
+// a device attestation certificate that is not marked as a CA, and the
+// slot attestation certificate of key
+type attestationFixture struct {
+	roots *x509.CertPool
+	chain []*x509.Certificate
+	key   *ecdsa.PrivateKey
+}
+
+// newAttestationFixture creates an attestation of a new key with the
+// given PIN and touch policies
+func newAttestationFixture(t *testing.T, pinPolicy, touchPolicy byte) *attestationFixture {
+	t.Helper()
+
+	create := func(template, parent *x509.Certificate, pub, signer interface{}) *x509.Certificate {
+		der, err := x509.CreateCertificate(rand.Reader, template, parent, pub, signer)
+		if err != nil {
+			t.Fatalf("Failed to create certificate: %v", err)
+		}
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			t.Fatalf("Failed to parse certificate: %v", err)
+		}
+		return cert
+	}
+	newKey := func() *ecdsa.PrivateKey {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatalf("Failed to generate key: %v", err)
+		}
+		return key
+	}
+	notBefore, notAfter := time.Now().Add(-time.Hour), time.Now().Add(24*time.Hour)
+
+	rootKey := newKey()
+	rootTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test PIV Root CA"},
+		NotBefore:             notBefore,
+		NotAfter:              notAfter,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	root := create(rootTemplate, rootTemplate, &rootKey.PublicKey, rootKey)
+
+	deviceKey := newKey()
+	device := create(&x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "Yubico PIV Attestation"},
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+	}, root, &deviceKey.PublicKey, rootKey)
+
+	serial, err := asn1.Marshal(12345678)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := newKey()
+	slot := create(&x509.Certificate{
+		SerialNumber: big.NewInt(3),
+		Subject:      pkix.Name{CommonName: "YubiKey PIV Attestation 9a"},
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+		ExtraExtensions: []pkix.Extension{
+			{Id: asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 41482, 3, 3}, Value: []byte{5, 7, 1}},
+			{Id: asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 41482, 3, 7}, Value: serial},
+			{Id: asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 41482, 3, 8}, Value: []byte{pinPolicy, touchPolicy}},
+			{Id: asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 41482, 3, 9}, Value: []byte{0x03}},
+		},
+	}, device, &key.PublicKey, deviceKey)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(root)
+	return &attestationFixture{roots: roots, chain: []*x509.Certificate{slot, device}, key: key}
+}
+
+// csr returns a PEM-encoded CSR for the attested key
+func (f *attestationFixture) csr(t *testing.T, cn string) []byte {
+	t.Helper()
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject: pkix.Name{CommonName: cn},
+	}, f.key)
+	if err != nil {
+		t.Fatalf("Failed to create CSR: %v", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der})
+}
+
+func TestVerifyKeyAttestation(t *testing.T) {
+	f := newAttestationFixture(t, yubikey.PINPolicyOnce, yubikey.TouchPolicyAlways)
+
+	chain, err := ParseAttestation(EncodeAttestation(f.chain))
+	if err != nil {
+		t.Fatalf("Failed to parse attestation: %v", err)
+	}
+	a, err := VerifyKeyAttestation(chain, &f.key.PublicKey, f.roots)
+	if err != nil {
+		t.Fatalf("Failed to verify attestation: %v", err)
+	}
+	if a.Serial != 12345678 || a.Version != "5.7.1" || a.Slot != yubikey.SlotAuthentication ||
+		a.PINPolicy != yubikey.PINPolicyOnce || a.TouchPolicy != yubikey.TouchPolicyAlways {
+		t.Errorf("Unexpected attestation: %+v", a)
+	}
+	md := AttestationMetadata(a)
+	if md["attestation_serial"] != "12345678" || md["attestation_pin_policy"] != "once" ||
+		md["attestation_touch_policy"] != "always" || md["attestation_slot"] != "9A" {
+		t.Errorf("Unexpected metadata: %v", md)
+	}
+
+	// Another device's hierarchy is not trusted
+	other := newAttestationFixture(t, yubikey.PINPolicyOnce, yubikey.TouchPolicyAlways)
+	if _, err := VerifyKeyAttestation(f.chain, &f.key.PublicKey, other.roots); !errors.Is(err, yubikey.ErrInvalidAttestation) {
+		t.Errorf("Expected an untrusted root to be rejected, got %v", err)
+	}
+	if _, err := VerifyKeyAttestation([]*x509.Certificate{f.chain[0], other.chain[1]}, &f.key.PublicKey, other.roots); !errors.Is(err, yubikey.ErrInvalidAttestation) {
+		t.Errorf("Expected a slot certificate of another device to be rejected, got %v", err)
+	}
+
+	// The attestation must be for the request's key
+	if _, err := VerifyKeyAttestation(f.chain, &other.key.PublicKey, f.roots); err == nil {
+		t.Error("Expected a different key to be rejected")
+	}
+
+	if _, err := ParseAttestation(EncodeAttestation(f.chain[:1])); err == nil {
+		t.Error("Expected an attestation without the device certificate to be rejected")
+	}
+}
+
+func TestAttestationRequiredWhenSigning(t *testing.T) {
+	c := newTestCA(t)
+	if err := os.WriteFile(c.ConfigFile, []byte(testAttestationConfig), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	f := newAttestationFixture(t, yubikey.PINPolicyAlways, yubikey.TouchPolicyCached)
+	c.AttestationRoots = f.roots
+
+	var attErr *AttestationError
+	if _, err := c.SignCertificate(f.csr(t, "laptop"), "device"); !errors.As(err, &attErr) {
+		t.Fatalf("Expected a missing attestation to be rejected, got %v", err)
+	}
+
+	certPEM, _, err := c.SignCertificateWithOptions(f.csr(t, "laptop"), "device", &SignOptions{Attestation: EncodeAttestation(f.chain)})
+	if err != nil {
+		t.Fatalf("Failed to sign attested request: %v", err)
+	}
+	store, err := c.Store()
+	if err != nil {
+		t.Fatal(err)
+	}
+	record, err := store.Get(parsePEMCertificate(t, certPEM).SerialNumber.Text(16))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record.Metadata["attestation_serial"] != "12345678" || record.Metadata["attestation_firmware"] != "5.7.1" ||
+		record.Metadata["attestation_pin_policy"] != "always" || record.Metadata["attestation_touch_policy"] != "cached" {
+		t.Errorf("Attestation not recorded: %v", record.Metadata)
+	}
+
+	// A key that can be used without touch does not meet the profile
+	untouched := newAttestationFixture(t, yubikey.PINPolicyOnce, yubikey.TouchPolicyNever)
+	c.AttestationRoots = untouched.roots
+	if _, _, err := c.SignCertificateWithOptions(untouched.csr(t, "laptop"), "device",
+		&SignOptions{Attestation: EncodeAttestation(untouched.chain)}); !errors.As(err, &attErr) {
+		t.Errorf("Expected the touch policy to be rejected, got %v", err)
+	}
+
+	// An attestation sent with a profile that does not require one is
+	// still verified
+	if _, _, err := c.SignCertificateWithOptions(f.csr(t, "laptop"), "",
+		&SignOptions{Attestation: EncodeAttestation(f.chain)}); !errors.As(err, &attErr) {
+		t.Errorf("Expected an untrusted attestation to be rejected, got %v", err)
+	}
+}
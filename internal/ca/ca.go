@@ -4,17 +4,21 @@ import (
 	"crypto/rand"
 	"crypto/x509"
 	"crypto/x509/pkix"
+	"encoding/asn1"
 	"encoding/pem"
 	"errors"
 	"fmt"
 	"math/big"
 	"os"
 	"path/filepath"
+	"strconv"
+	"sync"
 	"time"
 
 	"github.com/cloudflare/cfssl/config"
 	"github.com/cloudflare/cfssl/csr"
 
+	"github.com/billchurch/PiCA/internal/audit"
 	"github.com/billchurch/PiCA/internal/crypto"
 )
 
@@ -34,6 +38,23 @@ type CA struct {
 	CertFile   string
 	Provider   crypto.Provider
 	Slot       crypto.Slot
+
+	// DatabaseDir holds the issuance and revocation records
+	DatabaseDir string
+	// CRLFile is where the DER-encoded CRL is published
+	CRLFile string
+	// CRLDistributionPoints are embedded in issued certificates
+	CRLDistributionPoints []string
+	// DeltaCRLDistributionPoints are advertised in full CRLs
+	DeltaCRLDistributionPoints []string
+	// AttestationRoots verify the key attestations sent with requests;
+	// the bundled Yubico roots when nil
+	AttestationRoots *x509.CertPool
+
+	store      *Store
+	storeMutex sync.Mutex
+	// crlMutex serializes CRL generation so numbers never repeat
+	crlMutex sync.Mutex
 }
 
 // NewCA creates a new CA instance
@@ -83,11 +104,44 @@ func (ca *CA) InitializeProvider() error {
 	return nil
 }
 
+// Store returns the CA's certificate database, opening it on first use
+func (ca *CA) Store() (*Store, error) {
+	ca.storeMutex.Lock()
+	defer ca.storeMutex.Unlock()
+
+	if ca.store != nil {
+		return ca.store, nil
+	}
+	if ca.DatabaseDir == "" {
+		return nil, errors.New("no database directory configured")
+	}
+
+	store, err := OpenStore(ca.DatabaseDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open certificate database: %w", err)
+	}
+	ca.store = store
+	return store, nil
+}
+
 // GenerateRootCA generates a new root CA certificate
 func GenerateRootCA(req *csr.CertificateRequest, provider crypto.Provider, slot crypto.Slot, certFile string, expiry time.Duration) error {
+	return GenerateRootCAWithOptions(req, nil, provider, slot, certFile, expiry, -1)
+}
+
+// GenerateRootCAWithOptions is like GenerateRootCA but also adds the custom
+// extensions of opts to the root certificate and limits its path length;
+// -1 leaves the path length unconstrained
+func GenerateRootCAWithOptions(req *csr.CertificateRequest, opts *RequestOptions, provider crypto.Provider, slot crypto.Slot, certFile string, expiry time.Duration, pathLen int) error {
 	if provider == nil {
 		return errors.New("crypto provider is required")
 	}
+	// Check the extensions before a key is generated for nothing
+	if opts != nil {
+		if _, err := EncodeExtensions(opts.Extensions); err != nil {
+			return err
+		}
+	}
 
 	// Generate key in the specified slot
 	algorithm := "ECDSA"
@@ -102,16 +156,36 @@ func GenerateRootCA(req *csr.CertificateRequest, provider crypto.Provider, slot
 		}
 	}
 
+	if err := checkSlotFree(provider, slot); err != nil {
+		return err
+	}
+
 	fmt.Printf("Generating %s key with size/curve %d\n", algorithm, bits)
 
 	if err := provider.GenerateKey(slot, algorithm, bits); err != nil {
 		return fmt.Errorf("failed to generate key: %w", err)
 	}
 
+	_, err := IssueRootCertificate(req, opts, provider, slot, certFile, expiry, pathLen)
+	return err
+}
+
+// IssueRootCertificate self-signs a root CA certificate with the key already
+// in a provider slot, stores it in the slot and writes it to certFile if set.
+// pathLen limits the CA levels below the root; -1 leaves it unconstrained.
+func IssueRootCertificate(req *csr.CertificateRequest, opts *RequestOptions, provider crypto.Provider, slot crypto.Slot, certFile string, expiry time.Duration, pathLen int) (*x509.Certificate, error) {
+	var extensions []pkix.Extension
+	if opts != nil {
+		var err error
+		if extensions, err = EncodeExtensions(opts.Extensions); err != nil {
+			return nil, err
+		}
+	}
+
 	// Get the public key
 	pubKey, err := provider.GetPublicKey(slot)
 	if err != nil {
-		return fmt.Errorf("failed to get public key: %w", err)
+		return nil, fmt.Errorf("failed to get public key: %w", err)
 	}
 
 	fmt.Printf("Generated public key of type: %T\n", pubKey)
@@ -132,14 +206,16 @@ func GenerateRootCA(req *csr.CertificateRequest, provider crypto.Provider, slot
 		ExtKeyUsage:           []x509.ExtKeyUsage{},
 		BasicConstraintsValid: true,
 		IsCA:                  true,
-		MaxPathLenZero:        false,
+		MaxPathLen:            pathLen,
+		MaxPathLenZero:        pathLen == 0,
+		ExtraExtensions:       extensions,
 	}
 
 	// Create directory if it doesn't exist
 	if certFile != "" {
 		certDir := filepath.Dir(certFile)
 		if err := os.MkdirAll(certDir, 0755); err != nil {
-			return fmt.Errorf("failed to create certificate directory: %w", err)
+			return nil, fmt.Errorf("failed to create certificate directory: %w", err)
 		}
 	}
 
@@ -155,21 +231,41 @@ func GenerateRootCA(req *csr.CertificateRequest, provider crypto.Provider, slot
 	// Let's add debug info about the template and key
 	fmt.Printf("Creating self-signed certificate with key type: %T\n", pubKey)
 
+	// Lint the certificate before and after signing
+	lintOptions := DefaultLintOptions
+	report, err := lintBeforeSigning(template, nil, pubKey, pubKey, &lintOptions)
+	if err != nil {
+		if report != nil {
+			report.Print(os.Stdout)
+		}
+		auditLint(audit.EventCARejected, report, req.CN, "")
+		return nil, err
+	}
+
 	// Sign the certificate
 	certDER, err := x509.CreateCertificate(rand.Reader, template, template, pubKey, signer)
 	if err != nil {
-		return fmt.Errorf("failed to create certificate: %w", err)
+		return nil, fmt.Errorf("failed to create certificate: %w", err)
+	}
+
+	report.Serial = fmt.Sprintf("%X", template.SerialNumber)
+	err = report.lintAfterSigning(certDER, &lintOptions)
+	report.Print(os.Stdout)
+	if err != nil {
+		auditLint(audit.EventCARejected, report, req.CN, "")
+		return nil, err
 	}
+	auditLint(audit.EventCAIssued, report, req.CN, "")
 
 	// Parse the certificate
 	cert, err := x509.ParseCertificate(certDER)
 	if err != nil {
-		return fmt.Errorf("failed to parse certificate: %w", err)
+		return nil, fmt.Errorf("failed to parse certificate: %w", err)
 	}
 
 	// Store the certificate in the provider
 	if err := provider.ImportCertificate(slot, cert); err != nil {
-		return fmt.Errorf("failed to import certificate: %w", err)
+		return nil, fmt.Errorf("failed to import certificate: %w", err)
 	}
 
 	// Save the certificate to disk if requested
@@ -181,50 +277,86 @@ func GenerateRootCA(req *csr.CertificateRequest, provider crypto.Provider, slot
 
 		certBytes := pem.EncodeToMemory(certPEM)
 		if err := os.WriteFile(certFile, certBytes, 0644); err != nil {
-			return fmt.Errorf("failed to write certificate file: %w", err)
+			return nil, fmt.Errorf("failed to write certificate file: %w", err)
 		}
 	}
 
+	return cert, nil
+}
+
+// checkSlotFree refuses to generate a key in a slot that already holds
+// one, which would destroy an existing CA key without warning
+func checkSlotFree(provider crypto.Provider, slot crypto.Slot) error {
+	if _, err := provider.GetPublicKey(slot); err == nil {
+		return fmt.Errorf("%w: slot %X of %s; delete it from the key inventory ('pica slots delete') if it is no longer needed",
+			crypto.ErrSlotInUse, int(slot), provider.Name())
+	}
 	return nil
 }
 
-// GenerateSubCA generates a new sub CA certificate
+// GenerateSubCA generates a new sub CA certificate that cannot issue
+// further CA certificates (path length zero)
 func GenerateSubCA(req *csr.CertificateRequest, parentProvider crypto.Provider, parentSlot crypto.Slot,
 	subProvider crypto.Provider, subSlot crypto.Slot,
 	parentCACertFile, certFile string, expiry time.Duration) error {
+	return GenerateIntermediateCA(req, parentProvider, parentSlot, subProvider, subSlot,
+		parentCACertFile, certFile, expiry, 0)
+}
+
+// GenerateIntermediateCA generates a CA certificate signed by a parent CA at
+// any depth of the hierarchy. pathLen limits how many CA levels may follow
+// below it; a negative value leaves the path length unconstrained.
+func GenerateIntermediateCA(req *csr.CertificateRequest, parentProvider crypto.Provider, parentSlot crypto.Slot,
+	subProvider crypto.Provider, subSlot crypto.Slot,
+	parentCACertFile, certFile string, expiry time.Duration, pathLen int) error {
+	return GenerateIntermediateCAWithOptions(req, nil, parentProvider, parentSlot, subProvider, subSlot,
+		parentCACertFile, certFile, expiry, pathLen)
+}
+
+// GenerateIntermediateCAWithOptions is like GenerateIntermediateCA but also
+// applies the name and policy constraints and the custom extensions of opts
+// to the CA certificate
+func GenerateIntermediateCAWithOptions(req *csr.CertificateRequest, opts *RequestOptions,
+	parentProvider crypto.Provider, parentSlot crypto.Slot,
+	subProvider crypto.Provider, subSlot crypto.Slot,
+	parentCACertFile, certFile string, expiry time.Duration, pathLen int) error {
 	if parentProvider == nil || subProvider == nil {
 		return errors.New("crypto providers are required")
 	}
 
 	// Read the parent CA certificate
-	parentCACertBytes, err := os.ReadFile(parentCACertFile)
+	parentCACert, err := loadCertificateFile(parentCACertFile)
 	if err != nil {
-		return fmt.Errorf("failed to read parent CA certificate: %w", err)
+		return fmt.Errorf("failed to load parent CA certificate: %w", err)
 	}
 
-	parentCACertBlock, _ := pem.Decode(parentCACertBytes)
-	if parentCACertBlock == nil || parentCACertBlock.Type != "CERTIFICATE" {
-		return errors.New("failed to decode parent CA certificate")
+	// Check the path length and constraints before creating a key that
+	// could not be certified
+	if err := checkPathLen(parentCACert, pathLen); err != nil {
+		return err
 	}
-
-	parentCACert, err := x509.ParseCertificate(parentCACertBlock.Bytes)
-	if err != nil {
-		return fmt.Errorf("failed to parse parent CA certificate: %w", err)
+	var constraints *CAConstraints
+	var extensions []pkix.Extension
+	if opts != nil {
+		if extensions, err = EncodeExtensions(opts.Extensions); err != nil {
+			return err
+		}
 	}
-
-	// Generate key for sub CA
-	algorithm := "ECDSA"
-	bits := 384
-	if req.KeyRequest != nil {
-		if req.KeyRequest.A == "rsa" {
-			algorithm = "RSA"
-			bits = req.KeyRequest.Size()
-		} else if req.KeyRequest.A == "ecdsa" {
-			algorithm = "ECDSA"
-			bits = req.KeyRequest.Size()
+	if opts != nil && opts.Constraints != nil {
+		constraints = opts.Constraints
+		if err := constraints.Validate(); err != nil {
+			return err
+		}
+		if err := constraints.CheckWithin(parentCACert); err != nil {
+			return err
 		}
 	}
 
+	// Generate key for sub CA
+	if err := checkSlotFree(subProvider, subSlot); err != nil {
+		return err
+	}
+	algorithm, bits := keyParameters(req)
 	if err := subProvider.GenerateKey(subSlot, algorithm, bits); err != nil {
 		return fmt.Errorf("failed to generate key: %w", err)
 	}
@@ -235,23 +367,121 @@ func GenerateSubCA(req *csr.CertificateRequest, parentProvider crypto.Provider,
 		return fmt.Errorf("failed to get public key: %w", err)
 	}
 
+	// The subject carries every Names entry of the request
+	subject, err := req.Name()
+	if err != nil {
+		return fmt.Errorf("invalid subject: %w", err)
+	}
+
+	// Let's add debug info about the keys
+	fmt.Printf("Creating sub CA certificate with key type: %T, signed by key type: %T\n", pubKey, parentCACert.PublicKey)
+
+	cert, err := issueSubCACertificate(subject, pubKey, parentProvider, parentSlot, parentCACert, expiry, pathLen, constraints, extensions)
+	if err != nil {
+		return err
+	}
+
+	// Store the certificate in the provider
+	if err := subProvider.ImportCertificate(subSlot, cert); err != nil {
+		return fmt.Errorf("failed to import certificate: %w", err)
+	}
+
+	// Save the certificate to disk if requested
+	if certFile != "" {
+		if err := WriteCertificateFile(certFile, cert); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// SignSubCACSR issues a sub CA certificate for a PKCS#10 request produced on
+// another machine, signing it with the parent CA key. This is the root CA
+// half of the offline signing workflow.
+func SignSubCACSR(csrPEM []byte, parentProvider crypto.Provider, parentSlot crypto.Slot,
+	parentCACert *x509.Certificate, expiry time.Duration) (*x509.Certificate, error) {
+	return SignIntermediateCSR(csrPEM, parentProvider, parentSlot, parentCACert, expiry, 0, nil)
+}
+
+// SignIntermediateCSR is like SignSubCACSR but lets the caller choose the
+// path length of the issued CA certificate and the OIDs of the requested
+// custom extensions it carries
+func SignIntermediateCSR(csrPEM []byte, parentProvider crypto.Provider, parentSlot crypto.Slot,
+	parentCACert *x509.Certificate, expiry time.Duration, pathLen int, passthrough []string) (*x509.Certificate, error) {
+	if parentProvider == nil {
+		return nil, errors.New("crypto provider is required")
+	}
+
+	request, err := ParseCSR(csrPEM)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := checkPathLen(parentCACert, pathLen); err != nil {
+		return nil, err
+	}
+
+	// Name and policy constraints travel in the request extensions
+	constraints, err := ConstraintsFromRequest(request)
+	if err != nil {
+		return nil, err
+	}
+	if constraints != nil {
+		if err := constraints.CheckWithin(parentCACert); err != nil {
+			return nil, err
+		}
+	}
+
+	// So do custom extensions, which the parent operator allows and reviews
+	// before signing offline (see RequestExtensions)
+	extensions, err := RequestExtensions(request, passthrough)
+	if err != nil {
+		return nil, err
+	}
+	return issueSubCACertificate(request.Subject, request.PublicKey, parentProvider, parentSlot, parentCACert, expiry, pathLen,
+		constraints, extensions)
+}
+
+// checkPathLen makes sure the parent CA may issue a CA certificate with the
+// requested path length
+func checkPathLen(parentCACert *x509.Certificate, pathLen int) error {
+	if !parentCACert.BasicConstraintsValid || parentCACert.MaxPathLen < 0 {
+		return nil
+	}
+	if parentCACert.MaxPathLen == 0 {
+		return fmt.Errorf("parent CA %q has path length 0 and cannot issue CA certificates", parentCACert.Subject.CommonName)
+	}
+	if pathLen < 0 || pathLen >= parentCACert.MaxPathLen {
+		return fmt.Errorf("path length must be below %d, the parent CA's path length", parentCACert.MaxPathLen)
+	}
+	return nil
+}
+
+// issueSubCACertificate creates a CA certificate for the given subject and
+// key, signed by the parent CA, limited by constraints, if any, and carrying
+// the given custom extensions
+func issueSubCACertificate(subject pkix.Name, pubKey interface{}, parentProvider crypto.Provider, parentSlot crypto.Slot,
+	parentCACert *x509.Certificate, expiry time.Duration, pathLen int, constraints *CAConstraints,
+	extensions []pkix.Extension) (*x509.Certificate, error) {
 	// Create a certificate for the sub CA
 	template := &x509.Certificate{
-		SerialNumber: big.NewInt(time.Now().UnixNano() / 1000000),
-		Subject: pkix.Name{
-			CommonName:   req.CN,
-			Organization: []string{req.Names[0].O},
-			Country:      []string{req.Names[0].C},
-			Province:     []string{req.Names[0].ST},
-			Locality:     []string{req.Names[0].L},
-		},
+		SerialNumber:          big.NewInt(time.Now().UnixNano() / 1000000),
+		Subject:               subject,
 		NotBefore:             time.Now().Add(-5 * time.Minute),
 		NotAfter:              time.Now().Add(expiry),
 		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
 		ExtKeyUsage:           []x509.ExtKeyUsage{},
 		BasicConstraintsValid: true,
 		IsCA:                  true,
-		MaxPathLenZero:        true,
+		MaxPathLen:            pathLen,
+		MaxPathLenZero:        pathLen == 0,
+		ExtraExtensions:       extensions,
+	}
+	if constraints != nil {
+		if err := constraints.Apply(template); err != nil {
+			return nil, err
+		}
 	}
 
 	// Create a signer that uses the parent provider
@@ -261,85 +491,123 @@ func GenerateSubCA(req *csr.CertificateRequest, parentProvider crypto.Provider,
 		PublicKey: parentCACert.PublicKey,
 	}
 
-	// Let's add debug info about the keys
-	fmt.Printf("Creating sub CA certificate with key type: %T, signed by key type: %T\n", pubKey, parentCACert.PublicKey)
+	// Lint the certificate before and after signing
+	lintOptions := DefaultLintOptions
+	report, err := lintBeforeSigning(template, parentCACert, pubKey, parentCACert.PublicKey, &lintOptions)
+	if err != nil {
+		if report != nil {
+			report.Print(os.Stdout)
+		}
+		auditLint(audit.EventCARejected, report, parentCACert.Subject.CommonName, "")
+		return nil, err
+	}
 
 	// Sign the certificate
 	certDER, err := x509.CreateCertificate(rand.Reader, template, parentCACert, pubKey, signer)
 	if err != nil {
-		return fmt.Errorf("failed to create certificate: %w", err)
+		return nil, fmt.Errorf("failed to create certificate: %w", err)
 	}
 
+	report.Serial = fmt.Sprintf("%X", template.SerialNumber)
+	err = report.lintAfterSigning(certDER, &lintOptions)
+	report.Print(os.Stdout)
+	if err != nil {
+		auditLint(audit.EventCARejected, report, parentCACert.Subject.CommonName, "")
+		return nil, err
+	}
+	auditLint(audit.EventCAIssued, report, parentCACert.Subject.CommonName, "")
+
 	// Parse the certificate
 	cert, err := x509.ParseCertificate(certDER)
 	if err != nil {
-		return fmt.Errorf("failed to parse certificate: %w", err)
+		return nil, fmt.Errorf("failed to parse certificate: %w", err)
 	}
 
-	// Store the certificate in the provider
-	if err := subProvider.ImportCertificate(subSlot, cert); err != nil {
-		return fmt.Errorf("failed to import certificate: %w", err)
+	return cert, nil
+}
+
+// WriteCertificateFile saves a certificate to disk in PEM format
+func WriteCertificateFile(certFile string, cert *x509.Certificate) error {
+	if err := os.MkdirAll(filepath.Dir(certFile), 0755); err != nil {
+		return fmt.Errorf("failed to create certificate directory: %w", err)
 	}
 
-	// Save the certificate to disk if requested
-	if certFile != "" {
-		certPEM := &pem.Block{
-			Type:  "CERTIFICATE",
-			Bytes: certDER,
-		}
+	certPEM := &pem.Block{
+		Type:  "CERTIFICATE",
+		Bytes: cert.Raw,
+	}
 
-		certBytes := pem.EncodeToMemory(certPEM)
-		if err := os.WriteFile(certFile, certBytes, 0644); err != nil {
-			return fmt.Errorf("failed to write certificate file: %w", err)
-		}
+	certBytes := pem.EncodeToMemory(certPEM)
+	if err := os.WriteFile(certFile, certBytes, 0644); err != nil {
+		return fmt.Errorf("failed to write certificate file: %w", err)
 	}
 
 	return nil
 }
 
+// LoadCertificate reads a PEM-encoded certificate from disk
+func LoadCertificate(certFile string) (*x509.Certificate, error) {
+	return loadCertificateFile(certFile)
+}
+
 // SignCertificate signs a CSR using the CA
 func (ca *CA) SignCertificate(csrBytes []byte, profile string) ([]byte, error) {
-	// Ensure the provider is initialized
-	if err := ca.InitializeProvider(); err != nil {
-		return nil, err
-	}
+	certPEM, _, err := ca.SignCertificateWithReport(csrBytes, profile)
+	return certPEM, err
+}
 
-	// Load CA certificate
-	caCertBytes, err := os.ReadFile(ca.CertFile)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read CA certificate: %w", err)
+// SignOptions holds what a request may carry besides the CSR
+type SignOptions struct {
+	// Attestation is a PEM key attestation: the slot attestation
+	// certificate followed by the device certificate
+	Attestation []byte
+}
+
+// SignCertificateWithReport signs a CSR like SignCertificate and returns the
+// zlint report of the certificate. The report is also returned alongside a
+// LintError when linting stops issuance.
+func (ca *CA) SignCertificateWithReport(csrBytes []byte, profile string) ([]byte, *LintReport, error) {
+	return ca.SignCertificateWithOptions(csrBytes, profile, nil)
+}
+
+// SignCertificateWithOptions signs a CSR like SignCertificateWithReport. A
+// key attestation in opts is verified and recorded with the certificate.
+func (ca *CA) SignCertificateWithOptions(csrBytes []byte, profile string, opts *SignOptions) ([]byte, *LintReport, error) {
+	if opts == nil {
+		opts = &SignOptions{}
 	}
 
-	caCertBlock, _ := pem.Decode(caCertBytes)
-	if caCertBlock == nil || caCertBlock.Type != "CERTIFICATE" {
-		return nil, errors.New("failed to decode CA certificate")
+	// Ensure the provider is initialized
+	if err := ca.InitializeProvider(); err != nil {
+		return nil, nil, err
 	}
 
-	caCert, err := x509.ParseCertificate(caCertBlock.Bytes)
+	// Load the CA certificate in use, which changes after a renewal or rekey
+	caCert, slot, err := ca.ActiveIssuer()
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse CA certificate: %w", err)
+		return nil, nil, err
 	}
 
 	// Parse CSR
 	csrBlock, _ := pem.Decode(csrBytes)
 	if csrBlock == nil || csrBlock.Type != "CERTIFICATE REQUEST" {
-		return nil, errors.New("failed to decode CSR")
+		return nil, nil, errors.New("failed to decode CSR")
 	}
 
 	csr, err := x509.ParseCertificateRequest(csrBlock.Bytes)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse CSR: %w", err)
+		return nil, nil, fmt.Errorf("failed to parse CSR: %w", err)
 	}
 
 	// Verify CSR signature
 	if err := csr.CheckSignature(); err != nil {
-		return nil, fmt.Errorf("invalid CSR signature: %w", err)
+		return nil, nil, fmt.Errorf("invalid CSR signature: %w", err)
 	}
 
 	// Load config
 	configData, err := ca.LoadConfig()
 	if err != nil {
-		return nil, fmt.Errorf("failed to load config: %w", err)
+		return nil, nil, fmt.Errorf("failed to load config: %w", err)
 	}
 
 	// Get signing profile
@@ -350,14 +618,41 @@ func (ca *CA) SignCertificate(csrBytes []byte, profile string) ([]byte, error) {
 		if p, ok := configData.Signing.Profiles[profile]; ok {
 			signingProfile = p
 		} else {
-			return nil, fmt.Errorf("profile '%s' not found", profile)
+			return nil, nil, fmt.Errorf("profile '%s' not found", profile)
 		}
 	}
 
+	profileOptions, err := ca.LoadProfileOptions(profile)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Refuse names the issuing CA is not allowed to certify
+	if err := CheckNameConstraints(caCert, csr.Subject.CommonName, csr.DNSNames, csr.IPAddresses,
+		csr.EmailAddresses, csr.URIs); err != nil {
+		return nil, nil, err
+	}
+
+	// Fill in the profile's subject defaults, then apply its issuance policy
+	profileOptions.Subject.Apply(&csr.Subject)
+	policyResult, err := ca.evaluatePolicy(csr, profile, signingProfile.Expiry, profileOptions)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !policyResult.Allowed {
+		return nil, nil, &PolicyError{Result: policyResult}
+	}
+
+	// Check that the key was generated on a YubiKey if the profile asks
+	attestation, err := ca.checkAttestation(opts.Attestation, csr.PublicKey, profileOptions.Attestation)
+	if err != nil {
+		return nil, nil, err
+	}
+
 	// Create a signer that uses our provider
 	signer := &crypto.ProviderSigner{
 		Provider:  ca.Provider,
-		Slot:      ca.Slot,
+		Slot:      slot,
 		PublicKey: caCert.PublicKey,
 	}
 
@@ -369,12 +664,31 @@ func (ca *CA) SignCertificate(csrBytes []byte, profile string) ([]byte, error) {
 		NotAfter:     time.Now().Add(signingProfile.Expiry),
 		SubjectKeyId: nil, // Will be calculated
 		ExtKeyUsage:  []x509.ExtKeyUsage{},
+
+		DNSNames:       csr.DNSNames,
+		IPAddresses:    csr.IPAddresses,
+		EmailAddresses: csr.EmailAddresses,
+		URIs:           csr.URIs,
+
+		CRLDistributionPoints: ca.CRLDistributionPoints,
+	}
+
+	// Authority information access and policies from the profile
+	if signingProfile.OCSP != "" {
+		template.OCSPServer = []string{signingProfile.OCSP}
+	}
+	template.IssuingCertificateURL = signingProfile.IssuerURL
+	for _, policy := range signingProfile.Policies {
+		template.PolicyIdentifiers = append(template.PolicyIdentifiers, asn1.ObjectIdentifier(policy.ID))
 	}
 
 	// Set key usage based on profile
 	ku, eku, _ := signingProfile.Usages()
 	template.KeyUsage = ku
 	template.ExtKeyUsage = eku
+	if ext, ok := timeStampingExtension(eku); ok {
+		template.ExtraExtensions = append(template.ExtraExtensions, ext)
+	}
 
 	// Set CA constraints if present
 	if signingProfile.CAConstraint.IsCA {
@@ -385,6 +699,31 @@ func (ca *CA) SignCertificate(csrBytes []byte, profile string) ([]byte, error) {
 		} else if signingProfile.CAConstraint.MaxPathLenZero {
 			template.MaxPathLenZero = true
 		}
+
+		// CA profiles may constrain the CAs they create
+		if c := profileOptions.Constraints; c != nil {
+			if err := c.CheckWithin(caCert); err != nil {
+				return nil, nil, err
+			}
+			if err := c.Apply(template); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+
+	// Custom extensions of the profile and the ones it copies from the CSR
+	extensions, err := profileExtensions(profileOptions, csr)
+	if err != nil {
+		return nil, nil, err
+	}
+	template.ExtraExtensions = append(template.ExtraExtensions, extensions...)
+
+	// Lint the certificate before signing it with the CA key
+	lintOptions := profileOptions.lintOptions()
+	report, err := lintBeforeSigning(template, caCert, csr.PublicKey, caCert.PublicKey, lintOptions)
+	if err != nil {
+		auditLint(audit.EventRejected, report, caCert.Subject.CommonName, profile)
+		return nil, report, err
 	}
 
 	// Add debug info
@@ -393,7 +732,40 @@ func (ca *CA) SignCertificate(csrBytes []byte, profile string) ([]byte, error) {
 	// Sign the certificate
 	certDER, err := x509.CreateCertificate(rand.Reader, template, caCert, csr.PublicKey, signer)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create certificate: %w", err)
+		return nil, nil, fmt.Errorf("failed to create certificate: %w", err)
+	}
+
+	// Lint the signed certificate; a failing certificate is not released
+	report.Serial = fmt.Sprintf("%X", template.SerialNumber)
+	if err := report.lintAfterSigning(certDER, lintOptions); err != nil {
+		auditLint(audit.EventRejected, report, caCert.Subject.CommonName, profile)
+		return nil, report, err
+	}
+	auditLint(audit.EventIssued, report, caCert.Subject.CommonName, profile)
+
+	// Record the issuance if a database is configured
+	if ca.DatabaseDir != "" {
+		cert, err := x509.ParseCertificate(certDER)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to parse certificate: %w", err)
+		}
+		store, err := ca.Store()
+		if err != nil {
+			return nil, nil, err
+		}
+		record := RecordFromCertificate(cert, profile)
+		if attestation != nil {
+			record.Metadata = AttestationMetadata(attestation)
+		}
+		if v := profileOptions.TemplateVersion; v > 0 {
+			if record.Metadata == nil {
+				record.Metadata = make(map[string]string)
+			}
+			record.Metadata["template_version"] = strconv.Itoa(v)
+		}
+		if err := store.Add(record); err != nil {
+			return nil, nil, fmt.Errorf("failed to record certificate: %w", err)
+		}
 	}
 
 	// Return the PEM-encoded certificate
@@ -402,19 +774,59 @@ func (ca *CA) SignCertificate(csrBytes []byte, profile string) ([]byte, error) {
 		Bytes: certDER,
 	}
 
-	return pem.EncodeToMemory(certPEM), nil
+	return pem.EncodeToMemory(certPEM), report, nil
 }
 
 // RevokeCertificate revokes a certificate
 func (ca *CA) RevokeCertificate(serialNumber string) error {
+	return ca.RevokeCertificateWithReason(serialNumber, "unspecified")
+}
+
+// RevokeCertificateWithReason records a revocation in the certificate
+// database. The revocation appears in the next published CRL.
+func (ca *CA) RevokeCertificateWithReason(serialNumber, reason string) error {
 	// Ensure the provider is initialized
 	if err := ca.InitializeProvider(); err != nil {
 		return err
 	}
 
-	// In a real implementation, this would create/update a CRL
-	// Need to implement CRL management
-	return errors.New("CRL management not yet implemented")
+	if _, ok := new(big.Int).SetString(NormalizeSerial(serialNumber), 16); !ok {
+		return fmt.Errorf("invalid serial number: %s", serialNumber)
+	}
+	if _, err := ParseRevocationReason(reason); err != nil {
+		return err
+	}
+
+	store, err := ca.Store()
+	if err != nil {
+		return err
+	}
+
+	if _, err := store.Revoke(serialNumber, reason, time.Now()); err != nil {
+		return fmt.Errorf("failed to revoke certificate: %w", err)
+	}
+
+	return nil
+}
+
+// loadCertificateFile reads a PEM-encoded certificate from disk
+func loadCertificateFile(filename string) (*x509.Certificate, error) {
+	certBytes, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA certificate: %w", err)
+	}
+
+	certBlock, _ := pem.Decode(certBytes)
+	if certBlock == nil || certBlock.Type != "CERTIFICATE" {
+		return nil, errors.New("failed to decode CA certificate")
+	}
+
+	cert, err := x509.ParseCertificate(certBlock.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse CA certificate: %w", err)
+	}
+
+	return cert, nil
 }
 
 // LoadConfig loads the CFSSL configuration file
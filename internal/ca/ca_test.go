@@ -0,0 +1,398 @@
+package ca
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cloudflare/cfssl/csr"
+
+	"github.com/billchurch/PiCA/internal/crypto"
+)
+
+const testSigningConfig = `{
+	"signing": {
+		"default": {"expiry": "8760h"},
+		"profiles": {
+			"server": {"usages": ["signing", "key encipherment", "server auth"], "expiry": "8760h"}
+		}
+	}
+}`
+
+// newTestCA creates a self-signed CA backed by a software provider in a
+// temporary directory
+func newTestCA(t *testing.T) *CA {
+	t.Helper()
+	dir := t.TempDir()
+
+	provider, err := crypto.NewSoftwareProvider(map[string]interface{}{"directory": filepath.Join(dir, "keys")})
+	if err != nil {
+		t.Fatalf("Failed to create provider: %v", err)
+	}
+	if err := provider.Connect(); err != nil {
+		t.Fatalf("Failed to connect provider: %v", err)
+	}
+
+	req := &csr.CertificateRequest{
+		CN:         "Test Root CA",
+		Names:      []csr.Name{{C: "US", O: "PiCA Test"}},
+		KeyRequest: &csr.KeyRequest{A: "ecdsa", S: 256},
+	}
+	certFile := filepath.Join(dir, "ca.pem")
+	if err := GenerateRootCA(req, provider, crypto.SlotCA1, certFile, 24*time.Hour); err != nil {
+		t.Fatalf("Failed to generate CA: %v", err)
+	}
+
+	configFile := filepath.Join(dir, "ca-config.json")
+	if err := os.WriteFile(configFile, []byte(testSigningConfig), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+
+	c := NewCAWithProvider(SubCA, configFile, "", certFile, provider, crypto.SlotCA1)
+	c.DatabaseDir = filepath.Join(dir, "db")
+	c.CRLFile = filepath.Join(dir, "ca.crl")
+	return c
+}
+
+// newTestCSR returns a PEM-encoded CSR for the given common name
+func newTestCSR(t *testing.T, cn string, dnsNames ...string) []byte {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: cn},
+		DNSNames: dnsNames,
+	}, key)
+	if err != nil {
+		t.Fatalf("Failed to create CSR: %v", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der})
+}
+
+// parsePEMCertificate decodes a PEM certificate returned by SignCertificate
+func parsePEMCertificate(t *testing.T, certPEM []byte) *x509.Certificate {
+	t.Helper()
+
+	block, _ := pem.Decode(certPEM)
+	if block == nil {
+		t.Fatalf("Failed to decode certificate PEM")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatalf("Failed to parse certificate: %v", err)
+	}
+	return cert
+}
+
+func TestRevocationAndCRL(t *testing.T) {
+	c := newTestCA(t)
+
+	certPEM, err := c.SignCertificate(newTestCSR(t, "www.example.com", "www.example.com"), "server")
+	if err != nil {
+		t.Fatalf("Failed to sign certificate: %v", err)
+	}
+	cert := parsePEMCertificate(t, certPEM)
+
+	store, err := c.Store()
+	if err != nil {
+		t.Fatalf("Failed to open store: %v", err)
+	}
+	if _, err := store.Get(cert.SerialNumber.Text(16)); err != nil {
+		t.Fatalf("Expected issued certificate to be recorded: %v", err)
+	}
+
+	base, err := c.GenerateCRL(time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to generate CRL: %v", err)
+	}
+	if base.Entries != 0 {
+		t.Errorf("Expected empty CRL, got %d entries", base.Entries)
+	}
+
+	if err := c.RevokeCertificateWithReason(cert.SerialNumber.Text(16), "keyCompromise"); err != nil {
+		t.Fatalf("Failed to revoke certificate: %v", err)
+	}
+
+	delta, err := c.GenerateDeltaCRL(time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to generate delta CRL: %v", err)
+	}
+	if delta.Number != base.Number+1 {
+		t.Errorf("Expected delta CRL number %d, got %d", base.Number+1, delta.Number)
+	}
+
+	crlDER, err := os.ReadFile(DeltaCRLFile(c.CRLFile))
+	if err != nil {
+		t.Fatalf("Failed to read delta CRL: %v", err)
+	}
+	crl, err := x509.ParseRevocationList(crlDER)
+	if err != nil {
+		t.Fatalf("Failed to parse delta CRL: %v", err)
+	}
+
+	caCert, _ := loadCertificateFile(c.CertFile)
+	if err := crl.CheckSignatureFrom(caCert); err != nil {
+		t.Errorf("CRL signature invalid: %v", err)
+	}
+	if len(crl.RevokedCertificateEntries) != 1 || crl.RevokedCertificateEntries[0].SerialNumber.Cmp(cert.SerialNumber) != 0 {
+		t.Fatalf("Expected revoked certificate in delta CRL")
+	}
+	if crl.RevokedCertificateEntries[0].ReasonCode != 1 {
+		t.Errorf("Expected keyCompromise reason, got %d", crl.RevokedCertificateEntries[0].ReasonCode)
+	}
+
+	foundIndicator := false
+	for _, ext := range crl.Extensions {
+		if ext.Id.Equal(oidDeltaCRLIndicator) {
+			foundIndicator = true
+		}
+	}
+	if !foundIndicator {
+		t.Errorf("Expected delta CRL indicator extension")
+	}
+
+	if err := c.RevokeCertificate(cert.SerialNumber.Text(16)); err == nil {
+		t.Errorf("Expected revoking twice to fail")
+	}
+}
+
+func TestCRLPublisherSchedule(t *testing.T) {
+	c := newTestCA(t)
+
+	p := NewCRLPublisher(c)
+	p.Validity = 10 * time.Hour
+	p.RenewBefore = 4 * time.Hour
+
+	published := 0
+	p.OnPublish = func(info *CRLInfo) { published++ }
+
+	now := time.Now()
+	if err := p.PublishIfDue(now); err != nil {
+		t.Fatalf("Failed initial publish: %v", err)
+	}
+	if err := p.PublishIfDue(now.Add(time.Hour)); err != nil {
+		t.Fatalf("Failed scheduled check: %v", err)
+	}
+	if published != 1 {
+		t.Fatalf("Expected 1 publication before renewal window, got %d", published)
+	}
+
+	if err := p.PublishIfDue(now.Add(7 * time.Hour)); err != nil {
+		t.Fatalf("Failed renewal publish: %v", err)
+	}
+	if published != 2 {
+		t.Errorf("Expected CRL to be renewed inside the renewal window, got %d publications", published)
+	}
+}
+
+func TestCreateCSRFromProvider(t *testing.T) {
+	c := newTestCA(t)
+
+	reqJSON := []byte(`{
+		"CN": "PiCA Issuing CA",
+		"names": [
+			{"C": "US", "ST": "Oregon", "L": "Portland", "O": "PiCA Test", "OU": "Infrastructure"},
+			{"OU": "Security"}
+		],
+		"hosts": ["ca.example.com", "10.0.0.5", "pki@example.com"],
+		"key": {"algo": "ecdsa", "size": 256},
+		"ca": {"pathlen": 0, "pathlenzero": true},
+		"usages": ["cert sign", "crl sign", "ocsp signing"]
+	}`)
+	req, opts, err := LoadCertificateRequest(reqJSON)
+	if err != nil {
+		t.Fatalf("LoadCertificateRequest failed: %v", err)
+	}
+
+	csrPEM, err := CreateCSRWithOptions(req, opts, c.Provider, crypto.SlotCA2, true)
+	if err != nil {
+		t.Fatalf("CreateCSR failed: %v", err)
+	}
+	request, err := ParseCSR(csrPEM)
+	if err != nil {
+		t.Fatalf("ParseCSR failed: %v", err)
+	}
+
+	// The request must be signed by the key held in the provider slot
+	pub, err := c.Provider.GetPublicKey(crypto.SlotCA2)
+	if err != nil {
+		t.Fatalf("GetPublicKey failed: %v", err)
+	}
+	if !pub.(*ecdsa.PublicKey).Equal(request.PublicKey) {
+		t.Error("CSR public key does not match provider key")
+	}
+
+	if got := strings.Join(request.Subject.OrganizationalUnit, ","); !strings.Contains(got, "Infrastructure") || !strings.Contains(got, "Security") {
+		t.Errorf("Unexpected OUs: %v", got)
+	}
+	if got := request.Subject.Locality; len(got) != 1 || got[0] != "Portland" {
+		t.Errorf("Unexpected locality: %v", got)
+	}
+	if len(request.DNSNames) != 1 || len(request.IPAddresses) != 1 || len(request.EmailAddresses) != 1 {
+		t.Errorf("Unexpected SANs: %v %v %v", request.DNSNames, request.IPAddresses, request.EmailAddresses)
+	}
+
+	// The requested usages must be carried as extensions that a signer honouring
+	// CSR extensions (e.g. AD CS) understands
+	tmpl := &x509.Certificate{}
+	for _, ext := range request.Extensions {
+		switch {
+		case ext.Id.Equal(oidExtensionKeyUsage):
+			tmpl.KeyUsage = parseKeyUsageForTest(t, ext.Value)
+		case ext.Id.Equal(oidExtensionExtKeyUsage):
+			if _, err := asn1.Unmarshal(ext.Value, &tmpl.UnknownExtKeyUsage); err != nil {
+				t.Fatalf("Failed to parse extended key usage: %v", err)
+			}
+		}
+	}
+	if tmpl.KeyUsage != x509.KeyUsageCertSign|x509.KeyUsageCRLSign {
+		t.Errorf("Unexpected key usage: %v", tmpl.KeyUsage)
+	}
+	if len(tmpl.UnknownExtKeyUsage) != 1 || !tmpl.UnknownExtKeyUsage[0].Equal(extKeyUsageOIDs[x509.ExtKeyUsageOCSPSigning]) {
+		t.Errorf("Unexpected extended key usage: %v", tmpl.UnknownExtKeyUsage)
+	}
+
+	// Without explicit usages a CA request still asks for certSign and cRLSign
+	req.CA = &csr.CAConfig{PathLength: 1}
+	csrPEM, err = CreateCSR(req, c.Provider, crypto.SlotCA2, false)
+	if err != nil {
+		t.Fatalf("CreateCSR with existing key failed: %v", err)
+	}
+	if request, err = ParseCSR(csrPEM); err != nil {
+		t.Fatalf("ParseCSR failed: %v", err)
+	}
+	if !pub.(*ecdsa.PublicKey).Equal(request.PublicKey) {
+		t.Error("Existing key was replaced")
+	}
+}
+
+// parseKeyUsageForTest decodes a key usage extension value
+func parseKeyUsageForTest(t *testing.T, value []byte) x509.KeyUsage {
+	t.Helper()
+	var bits asn1.BitString
+	if _, err := asn1.Unmarshal(value, &bits); err != nil {
+		t.Fatalf("Failed to parse key usage: %v", err)
+	}
+	var usage int
+	for i := 0; i < 9; i++ {
+		if bits.At(i) != 0 {
+			usage |= 1 << uint(i)
+		}
+	}
+	return x509.KeyUsage(usage)
+}
+
+func TestGenerateCARefusesUsedSlot(t *testing.T) {
+	c := newTestCA(t)
+	before, err := c.Provider.GetPublicKey(c.Slot)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := &csr.CertificateRequest{CN: "Replacement CA", Names: []csr.Name{{C: "US", O: "PiCA Test"}}, KeyRequest: &csr.KeyRequest{A: "ecdsa", S: 256}}
+	certFile := filepath.Join(t.TempDir(), "replacement.pem")
+	if err := GenerateRootCA(req, c.Provider, c.Slot, certFile, time.Hour); !errors.Is(err, crypto.ErrSlotInUse) {
+		t.Fatalf("Expected ErrSlotInUse for a root CA, got %v", err)
+	}
+	if err := GenerateSubCA(req, c.Provider, c.Slot, c.Provider, c.Slot, c.CertFile, certFile, time.Hour); !errors.Is(err, crypto.ErrSlotInUse) {
+		t.Fatalf("Expected ErrSlotInUse for a sub CA, got %v", err)
+	}
+	after, err := c.Provider.GetPublicKey(c.Slot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !publicKeysMatch(before, after) {
+		t.Error("The CA key was replaced")
+	}
+
+	// Once the key is deleted the slot can be used again
+	if err := c.Provider.DeleteKey(c.Slot); err != nil {
+		t.Fatalf("Failed to delete key: %v", err)
+	}
+	if err := GenerateRootCA(req, c.Provider, c.Slot, certFile, time.Hour); err != nil {
+		t.Errorf("Failed to generate CA in the freed slot: %v", err)
+	}
+}
+
+func TestCRLPublisherRejectsLongRenewal(t *testing.T) {
+	c := newTestCA(t)
+
+	p := NewCRLPublisher(c)
+	p.Validity = 48 * time.Hour
+	p.RenewBefore = 48 * time.Hour
+	if err := p.Validate(); err == nil {
+		t.Fatal("Expected a renewal window as long as the validity to be refused")
+	}
+	if err := p.PublishIfDue(time.Now()); err == nil {
+		t.Error("Expected PublishIfDue to refuse an invalid schedule")
+	}
+
+	p.RenewBefore = DefaultCRLRenewBefore
+	p.Validity = DefaultCRLValidity
+	if err := p.Validate(); err != nil {
+		t.Errorf("Default schedule refused: %v", err)
+	}
+}
+
+func TestCRLPublisherConcurrent(t *testing.T) {
+	c := newTestCA(t)
+
+	p := NewCRLPublisher(c)
+	p.DeltaInterval = time.Hour
+
+	var mutex sync.Mutex
+	numbers := map[int64]bool{}
+	p.OnPublish = func(info *CRLInfo) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if numbers[info.Number] {
+			t.Errorf("CRL number %d published twice", info.Number)
+		}
+		numbers[info.Number] = true
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			if err := p.Refresh(); err != nil {
+				t.Errorf("Refresh failed: %v", err)
+			}
+		}()
+		go func(i int) {
+			defer wg.Done()
+			// Far in the future, so every check renews the full CRL
+			if err := p.PublishIfDue(time.Now().Add(time.Duration(i+1) * 30 * 24 * time.Hour)); err != nil {
+				t.Errorf("PublishIfDue failed: %v", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	store, err := c.Store()
+	if err != nil {
+		t.Fatal(err)
+	}
+	state := store.CRLState()
+	if int(state.Number) != len(numbers) {
+		t.Errorf("Expected CRL number %d after %d publications, got %d", len(numbers), len(numbers), state.Number)
+	}
+	if state.BaseNumber == 0 || state.BaseNumber > state.Number {
+		t.Errorf("Unexpected base CRL number %d", state.BaseNumber)
+	}
+}
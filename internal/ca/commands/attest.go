@@ -0,0 +1,94 @@
+package commands
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/billchurch/PiCA/internal/ca"
+	"github.com/billchurch/PiCA/internal/crypto"
+	"github.com/billchurch/PiCA/internal/yubikey"
+)
+
+// AttestCommand fetches the attestation of a key generated on a hardware
+// provider, verifies it and saves it, proving the key never left the device
+type AttestCommand struct {
+	Provider crypto.Provider
+	Slot     crypto.Slot
+	// Roots verify the attestation; the bundled Yubico roots when nil
+	Roots *x509.CertPool
+	// OutFile receives the PEM attestation chain; empty skips writing it
+	OutFile string
+	Out     io.Writer
+
+	// Attestation holds what the device stated about the key after
+	// Execute
+	Attestation *yubikey.Attestation
+}
+
+// NewAttestCommand creates a new AttestCommand
+func NewAttestCommand(provider crypto.Provider, slot crypto.Slot, outFile string) *AttestCommand {
+	return &AttestCommand{
+		Provider: provider,
+		Slot:     slot,
+		OutFile:  outFile,
+		Out:      os.Stdout,
+	}
+}
+
+// Execute attests the key and reports the device and key policies. An
+// attestation that does not verify is still written, so it can be checked
+// against other roots later.
+func (cmd *AttestCommand) Execute() error {
+	chain, err := crypto.AttestKey(cmd.Provider, cmd.Slot)
+	if err != nil {
+		if errors.Is(err, crypto.ErrOperationNotSupported) {
+			return fmt.Errorf("%s cannot attest keys: %w", cmd.Provider.Name(), err)
+		}
+		return fmt.Errorf("error attesting key: %w", err)
+	}
+	if cmd.OutFile != "" {
+		if err := os.WriteFile(cmd.OutFile, ca.EncodeAttestation(chain), 0644); err != nil {
+			return fmt.Errorf("error writing attestation: %w", err)
+		}
+		fmt.Fprintln(cmd.Out, "Attestation saved to:", cmd.OutFile)
+	}
+
+	pub, err := cmd.Provider.GetPublicKey(cmd.Slot)
+	if err != nil {
+		return fmt.Errorf("error reading public key: %w", err)
+	}
+	cmd.Attestation, err = ca.VerifyKeyAttestation(chain, pub, cmd.Roots)
+	if err != nil {
+		return fmt.Errorf("attestation does not verify: %w", err)
+	}
+
+	a := cmd.Attestation
+	fmt.Fprintf(cmd.Out, "Key in slot %X was generated on YubiKey %d (firmware %s)\n", int(a.Slot), a.Serial, a.Version)
+	fmt.Fprintf(cmd.Out, "  PIN policy:   %s\n", yubikey.PINPolicyName(a.PINPolicy))
+	fmt.Fprintf(cmd.Out, "  Touch policy: %s\n", yubikey.TouchPolicyName(a.TouchPolicy))
+	return nil
+}
+
+// attestationFile names the attestation saved next to a certificate or CSR
+func attestationFile(file string) string {
+	return strings.TrimSuffix(file, filepath.Ext(file)) + ".attestation.pem"
+}
+
+// saveAttestation attests a freshly generated key on providers that
+// support it. Failures are reported as warnings; they do not undo the
+// operation that created the key.
+func saveAttestation(provider crypto.Provider, slot crypto.Slot, roots *x509.CertPool, file string) {
+	if _, ok := provider.(crypto.KeyAttester); !ok {
+		return
+	}
+	cmd := NewAttestCommand(provider, slot, attestationFile(file))
+	cmd.Roots = roots
+	if err := cmd.Execute(); err != nil {
+		fmt.Println("Warning:", err)
+	}
+}
@@ -0,0 +1,352 @@
+package commands
+
+import (
+	gocrypto "crypto"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/billchurch/PiCA/internal/ca"
+	"github.com/billchurch/PiCA/internal/ceremony"
+	"github.com/billchurch/PiCA/internal/crypto"
+)
+
+// File names written by a ceremony
+const (
+	CeremonyBackupFile     = "backup.json"
+	CeremonyTranscriptFile = "transcript.json"
+)
+
+// CeremonyCommand generates a root CA key in memory on the ceremony
+// machine, loads it into every provider, backs it up encrypted and splits
+// the backup key among the custodians
+type CeremonyCommand struct {
+	CSRFile         string
+	CertificateFile string
+	// OutDir receives the backup, one share file per custodian and the
+	// transcript. The share files must be handed out and removed before
+	// the machine is shut down.
+	OutDir     string
+	Custodians []string
+	Witnesses  []string
+	// Threshold is how many custodians are needed to recover the key
+	Threshold int
+	Slot      crypto.Slot
+	// Providers receive the key; the certificate is issued with the first
+	Providers []crypto.Provider
+	// CAName names the CA in the audit log
+	CAName string
+}
+
+// NewCeremonyCommand creates a new CeremonyCommand
+func NewCeremonyCommand(csrFile, certFile, outDir string, custodians []string, threshold int, providers []crypto.Provider, slot crypto.Slot) *CeremonyCommand {
+	return &CeremonyCommand{
+		CSRFile:         csrFile,
+		CertificateFile: certFile,
+		OutDir:          outDir,
+		Custodians:      custodians,
+		Threshold:       threshold,
+		Providers:       providers,
+		Slot:            slot,
+	}
+}
+
+// Execute runs the key generation ceremony
+func (cmd *CeremonyCommand) Execute() error {
+	if len(cmd.Providers) == 0 {
+		return errors.New("at least one crypto provider is required")
+	}
+	if cmd.Threshold < 2 || cmd.Threshold > len(cmd.Custodians) {
+		return fmt.Errorf("the threshold must be between 2 and the number of custodians (%d)", len(cmd.Custodians))
+	}
+	csrBytes, err := os.ReadFile(cmd.CSRFile)
+	if err != nil {
+		return fmt.Errorf("error reading CSR file: %w", err)
+	}
+	req, opts, err := ca.LoadCertificateRequest(csrBytes)
+	if err != nil {
+		return fmt.Errorf("error parsing CSR: %w", err)
+	}
+	expiry := ca.DefaultRootCAExpiry
+	if req.CA != nil && req.CA.Expiry != "" {
+		if expiry, err = time.ParseDuration(req.CA.Expiry); err != nil {
+			return fmt.Errorf("invalid expiry in CSR: %w", err)
+		}
+	}
+	algorithm, bits := "ECDSA", 384
+	if req.KeyRequest != nil && req.KeyRequest.Size() > 0 {
+		algorithm, bits = strings.ToUpper(req.KeyRequest.Algo()), req.KeyRequest.Size()
+	}
+	if err := checkTargets(cmd.Providers, cmd.Slot); err != nil {
+		return err
+	}
+
+	t := ceremony.NewTranscript(ceremony.NewID(), ceremony.KindGeneration, participants(cmd.Custodians, cmd.Witnesses))
+	fmt.Println("Key ceremony", t.ID)
+	t.Step("start", "%d of %d custodians required for recovery", cmd.Threshold, len(cmd.Custodians))
+	if err := t.HashFile(cmd.CSRFile); err != nil {
+		return err
+	}
+
+	fmt.Printf("Generating %s key with size/curve %d on this machine\n", algorithm, bits)
+	key, err := ceremony.GenerateKey(algorithm, bits)
+	if err != nil {
+		return err
+	}
+	fingerprint, err := ceremony.Fingerprint(key.Public())
+	if err != nil {
+		return err
+	}
+	t.Step("generate", "%s %d key %s", algorithm, bits, fingerprint)
+
+	if err := loadKey(t, cmd.Providers, cmd.Slot, key); err != nil {
+		return err
+	}
+
+	cert, err := ca.IssueRootCertificate(req, opts, cmd.Providers[0], cmd.Slot, cmd.CertificateFile, expiry, -1)
+	if err != nil {
+		return fmt.Errorf("error issuing root certificate: %w", err)
+	}
+	t.Step("certificate", "serial %X valid until %s", cert.SerialNumber, cert.NotAfter.Format("2006-01-02"))
+	t.Hash("certificate", cert.Raw)
+	for _, p := range cmd.Providers[1:] {
+		if err := p.ImportCertificate(cmd.Slot, cert); err != nil {
+			return fmt.Errorf("error storing the certificate in %s: %w", p.Name(), err)
+		}
+	}
+
+	backup, shares, err := ceremony.NewBackup(t.ID, key, cert, cmd.Custodians, cmd.Threshold)
+	if err != nil {
+		return fmt.Errorf("error creating backup: %w", err)
+	}
+	backupFile := filepath.Join(cmd.OutDir, CeremonyBackupFile)
+	if err := ceremony.WriteFile(backupFile, backup); err != nil {
+		return err
+	}
+	if err := t.HashFile(backupFile); err != nil {
+		return err
+	}
+	t.Step("backup", "key encrypted with %s, backup %s", backup.Cipher, backup.Fingerprint())
+	for _, share := range shares {
+		shareFile := filepath.Join(cmd.OutDir, shareFileName(share))
+		if err := ceremony.WriteFile(shareFile, share); err != nil {
+			return err
+		}
+		if err := t.HashFile(shareFile); err != nil {
+			return err
+		}
+		t.Step("share", "share %d of %d for %s", share.Index, share.Total, share.Custodian)
+	}
+
+	if err := finishTranscript(t, key, cmd.OutDir, cmd.CAName); err != nil {
+		return err
+	}
+	fmt.Println("Root CA certificate saved to:", cmd.CertificateFile)
+	fmt.Println("Backup saved to:", backupFile)
+	fmt.Println("Hand each custodian their share file, then remove the share files from", cmd.OutDir)
+	return nil
+}
+
+// RecoveryCommand reassembles a ceremony backup from custodian shares and
+// loads the key into new providers
+type RecoveryCommand struct {
+	BackupFile string
+	ShareFiles []string
+	Witnesses  []string
+	// OutDir receives the recovery transcript
+	OutDir string
+	// CertificateFile receives the certificate stored in the backup
+	CertificateFile string
+	Slot            crypto.Slot
+	Providers       []crypto.Provider
+	CAName          string
+}
+
+// NewRecoveryCommand creates a new RecoveryCommand
+func NewRecoveryCommand(backupFile string, shareFiles []string, outDir string, providers []crypto.Provider, slot crypto.Slot) *RecoveryCommand {
+	return &RecoveryCommand{
+		BackupFile: backupFile,
+		ShareFiles: shareFiles,
+		OutDir:     outDir,
+		Providers:  providers,
+		Slot:       slot,
+	}
+}
+
+// Execute runs the recovery ceremony
+func (cmd *RecoveryCommand) Execute() error {
+	if len(cmd.Providers) == 0 {
+		return errors.New("at least one crypto provider is required")
+	}
+	backup, err := ceremony.ReadBackup(cmd.BackupFile)
+	if err != nil {
+		return fmt.Errorf("error reading backup: %w", err)
+	}
+	var shares []*ceremony.Share
+	var custodians []string
+	for _, file := range cmd.ShareFiles {
+		share, err := ceremony.ReadShare(file)
+		if err != nil {
+			return err
+		}
+		shares = append(shares, share)
+		custodians = append(custodians, share.Custodian)
+	}
+	if err := checkTargets(cmd.Providers, cmd.Slot); err != nil {
+		return err
+	}
+
+	t := ceremony.NewTranscript(ceremony.NewID(), ceremony.KindRecovery, participants(custodians, cmd.Witnesses))
+	fmt.Println("Recovery ceremony", t.ID, "for key ceremony", backup.Ceremony)
+	t.Step("start", "recovering the key of ceremony %s with %d shares", backup.Ceremony, len(shares))
+	if err := t.HashFile(cmd.BackupFile); err != nil {
+		return err
+	}
+
+	key, err := backup.Recover(shares)
+	if err != nil {
+		return fmt.Errorf("error recovering key: %w", err)
+	}
+	fingerprint, err := ceremony.Fingerprint(key.Public())
+	if err != nil {
+		return err
+	}
+	t.Step("recover", "key %s", fingerprint)
+
+	if err := loadKey(t, cmd.Providers, cmd.Slot, key); err != nil {
+		return err
+	}
+	cert, err := backup.ParseCertificate()
+	if err != nil {
+		return fmt.Errorf("error parsing the certificate in the backup: %w", err)
+	}
+	if cert != nil {
+		for _, p := range cmd.Providers {
+			if err := p.ImportCertificate(cmd.Slot, cert); err != nil {
+				return fmt.Errorf("error storing the certificate in %s: %w", p.Name(), err)
+			}
+		}
+		t.Hash("certificate", cert.Raw)
+		if cmd.CertificateFile != "" {
+			if err := ca.WriteCertificateFile(cmd.CertificateFile, cert); err != nil {
+				return err
+			}
+			fmt.Println("Root CA certificate saved to:", cmd.CertificateFile)
+		}
+	}
+
+	return finishTranscript(t, key, cmd.OutDir, cmd.CAName)
+}
+
+// VerifyTranscript checks a ceremony transcript against the CA certificate
+// or, without one, the public key of the ceremony's backup
+func VerifyTranscript(transcriptFile, certFile, backupFile string) (*ceremony.Transcript, error) {
+	t, err := ceremony.ReadTranscript(transcriptFile)
+	if err != nil {
+		return nil, err
+	}
+	var pub gocrypto.PublicKey
+	switch {
+	case certFile != "":
+		cert, err := ca.LoadCertificate(certFile)
+		if err != nil {
+			return nil, err
+		}
+		pub = cert.PublicKey
+	case backupFile != "":
+		backup, err := ceremony.ReadBackup(backupFile)
+		if err != nil {
+			return nil, err
+		}
+		if pub, err = x509.ParsePKIXPublicKey(backup.PublicKey); err != nil {
+			return nil, fmt.Errorf("error parsing the public key in the backup: %w", err)
+		}
+	default:
+		return nil, errors.New("a certificate or backup is needed to verify the transcript")
+	}
+	return t, t.Verify(pub)
+}
+
+// checkTargets refuses providers that already hold a key in the slot
+func checkTargets(providers []crypto.Provider, slot crypto.Slot) error {
+	for _, p := range providers {
+		useProvider(p, nil)
+		if _, err := p.GetPublicKey(slot); err == nil {
+			return fmt.Errorf("%w: slot %X of %s", crypto.ErrSlotInUse, int(slot), p.Name())
+		}
+	}
+	return nil
+}
+
+// loadKey imports the key into every provider and checks each returns the
+// same public key
+func loadKey(t *ceremony.Transcript, providers []crypto.Provider, slot crypto.Slot, key gocrypto.Signer) error {
+	want, err := ceremony.Fingerprint(key.Public())
+	if err != nil {
+		return err
+	}
+	for _, p := range providers {
+		if err := crypto.ImportKey(p, slot, key); err != nil {
+			return fmt.Errorf("error loading the key into %s: %w", p.Name(), err)
+		}
+		pub, err := p.GetPublicKey(slot)
+		if err != nil {
+			return fmt.Errorf("error reading the key back from %s: %w", p.Name(), err)
+		}
+		if got, err := ceremony.Fingerprint(pub); err != nil || got != want {
+			return fmt.Errorf("the key in %s does not match the ceremony key", p.Name())
+		}
+		fmt.Printf("Key loaded into slot %X of %s\n", int(slot), p.Name())
+		t.Step("load", "slot %X of %s (%s)", int(slot), p.Name(), p.Type())
+	}
+	return nil
+}
+
+// finishTranscript signs the transcript with the CA key, writes it and
+// records it in the audit log
+func finishTranscript(t *ceremony.Transcript, key gocrypto.Signer, outDir, caName string) error {
+	t.Step("finish", "transcript signed with the CA key")
+	if err := t.Sign(key); err != nil {
+		return err
+	}
+	transcriptFile := filepath.Join(outDir, CeremonyTranscriptFile)
+	if err := ceremony.WriteFile(transcriptFile, t); err != nil {
+		return err
+	}
+	t.Record(caName)
+
+	fingerprint, err := t.Fingerprint()
+	if err != nil {
+		return err
+	}
+	fmt.Println("Transcript saved to:", transcriptFile)
+	fmt.Println("Transcript fingerprint:", fingerprint)
+	return nil
+}
+
+// participants lists the custodians followed by the witnesses
+func participants(custodians, witnesses []string) []ceremony.Participant {
+	var list []ceremony.Participant
+	for _, name := range custodians {
+		list = append(list, ceremony.Participant{Name: name, Role: ceremony.RoleCustodian})
+	}
+	for _, name := range witnesses {
+		list = append(list, ceremony.Participant{Name: name, Role: ceremony.RoleWitness})
+	}
+	return list
+}
+
+// shareFileName names a custodian's share file, e.g. share-2-bob.json
+func shareFileName(share *ceremony.Share) string {
+	name := strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' {
+			return r
+		}
+		return '_'
+	}, share.Custodian)
+	return fmt.Sprintf("share-%d-%s.json", share.Index, name)
+}
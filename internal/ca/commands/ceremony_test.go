@@ -0,0 +1,108 @@
+package commands
+
+import (
+	"crypto/ecdsa"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/billchurch/PiCA/internal/audit"
+	"github.com/billchurch/PiCA/internal/ca"
+	"github.com/billchurch/PiCA/internal/crypto"
+	"github.com/cloudflare/cfssl/csr"
+)
+
+func TestKeyCeremony(t *testing.T) {
+	dir := t.TempDir()
+	log, err := audit.Open(filepath.Join(dir, "audit.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	audit.SetDefault(log)
+	defer audit.SetDefault(nil)
+
+	req := csr.CertificateRequest{
+		CN:         "Ceremony Root",
+		Names:      []csr.Name{{C: "US", O: "PiCA Test"}},
+		KeyRequest: &csr.KeyRequest{A: "ecdsa", S: 256},
+		CA:         &csr.CAConfig{Expiry: "24h"},
+	}
+	reqJSON, _ := json.Marshal(req)
+	reqFile := filepath.Join(dir, "root-csr.json")
+	os.WriteFile(reqFile, reqJSON, 0644)
+
+	// Generation into two providers
+	primary := newSoftwareProvider(t, filepath.Join(dir, "primary"))
+	spare := newSoftwareProvider(t, filepath.Join(dir, "spare"))
+	certFile := filepath.Join(dir, "root-ca.pem")
+	out := filepath.Join(dir, "ceremony")
+	cmd := NewCeremonyCommand(reqFile, certFile, out, []string{"alice", "bob", "carol"}, 2,
+		[]crypto.Provider{primary, spare}, crypto.SlotCA1)
+	cmd.Witnesses = []string{"dave"}
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("Ceremony failed: %v", err)
+	}
+	cert, err := ca.LoadCertificate(certFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []crypto.Provider{primary, spare} {
+		stored, err := p.GetCertificate(crypto.SlotCA1)
+		if err != nil || !stored.Equal(cert) {
+			t.Errorf("Expected the root certificate in %s, got %v", p.Name(), err)
+		}
+	}
+	transcript, err := VerifyTranscript(filepath.Join(out, CeremonyTranscriptFile), certFile, "")
+	if err != nil {
+		t.Fatalf("Transcript does not verify: %v", err)
+	}
+	if len(transcript.Participants) != 4 || transcript.Hashes["share-2-bob.json"] == "" || transcript.Hashes[CeremonyBackupFile] == "" {
+		t.Errorf("Unexpected transcript: %+v", transcript)
+	}
+
+	// The slot is taken now
+	if err := cmd.Execute(); !errors.Is(err, crypto.ErrSlotInUse) {
+		t.Errorf("Expected ErrSlotInUse repeating the ceremony, got %v", err)
+	}
+
+	// Recovery into a fresh provider with two of three shares
+	restored := newSoftwareProvider(t, filepath.Join(dir, "restored"))
+	recoveredCert := filepath.Join(dir, "recovered-ca.pem")
+	recovery := NewRecoveryCommand(filepath.Join(out, CeremonyBackupFile),
+		[]string{filepath.Join(out, "share-3-carol.json")}, filepath.Join(dir, "recovery"),
+		[]crypto.Provider{restored}, crypto.SlotCA1)
+	if err := recovery.Execute(); err == nil {
+		t.Error("Expected recovery with one share to fail")
+	}
+	recovery.ShareFiles = append(recovery.ShareFiles, filepath.Join(out, "share-1-alice.json"))
+	recovery.CertificateFile = recoveredCert
+	if err := recovery.Execute(); err != nil {
+		t.Fatalf("Recovery failed: %v", err)
+	}
+	pub, err := restored.GetPublicKey(crypto.SlotCA1)
+	if err != nil || !cert.PublicKey.(*ecdsa.PublicKey).Equal(pub) {
+		t.Errorf("Recovered key does not match the root certificate: %v", err)
+	}
+	if recovered, err := ca.LoadCertificate(recoveredCert); err != nil || !recovered.Equal(cert) {
+		t.Errorf("Expected the root certificate from the backup, got %v", err)
+	}
+	if _, err := VerifyTranscript(filepath.Join(dir, "recovery", CeremonyTranscriptFile), "", filepath.Join(out, CeremonyBackupFile)); err != nil {
+		t.Errorf("Recovery transcript does not verify: %v", err)
+	}
+
+	events, err := audit.Read(filepath.Join(dir, "audit.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var kinds []string
+	for _, e := range events {
+		if e.Type == audit.EventCeremony || e.Type == audit.EventRecovery {
+			kinds = append(kinds, e.Type)
+		}
+	}
+	if len(kinds) != 2 || kinds[0] != audit.EventCeremony || kinds[1] != audit.EventRecovery {
+		t.Errorf("Unexpected ceremony audit events: %v", kinds)
+	}
+}
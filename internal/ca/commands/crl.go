@@ -0,0 +1,125 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/billchurch/PiCA/internal/ca"
+	"github.com/billchurch/PiCA/internal/crypto"
+)
+
+// CRLCommand represents the command to generate and publish a CRL
+type CRLCommand struct {
+	CA            *ca.CA
+	Validity      time.Duration
+	RenewBefore   time.Duration
+	DeltaInterval time.Duration
+	Delta         bool
+	Daemon        bool
+	Slot          crypto.Slot
+	Provider      crypto.Provider
+}
+
+// NewCRLCommand creates a new CRLCommand with default lifetimes
+func NewCRLCommand(ca *ca.CA, provider crypto.Provider, slot crypto.Slot) *CRLCommand {
+	return &CRLCommand{
+		CA:       ca,
+		Validity: defaultCRLValidity(ca),
+		Provider: provider,
+		Slot:     slot,
+	}
+}
+
+// defaultCRLValidity returns a long-lived CRL for the offline root CA and a
+// short-lived one for online CAs
+func defaultCRLValidity(caInstance *ca.CA) time.Duration {
+	if caInstance.Type == ca.RootCA {
+		return ca.DefaultRootCRLValidity
+	}
+	return ca.DefaultCRLValidity
+}
+
+// Execute generates a CRL once, or keeps it fresh in daemon mode
+func (cmd *CRLCommand) Execute() error {
+	// Use either the provided provider or initialize the CA's provider
+	if cmd.Provider != nil {
+		cmd.CA.Provider = cmd.Provider
+		cmd.CA.Slot = cmd.Slot
+	} else {
+		if err := cmd.CA.InitializeProvider(); err != nil {
+			return fmt.Errorf("error initializing provider: %w", err)
+		}
+	}
+
+	if cmd.Daemon {
+		return cmd.runDaemon()
+	}
+
+	if cmd.CA.Type == ca.RootCA {
+		fmt.Println("Generating Root CA CRL as part of a signing ceremony")
+		fmt.Printf("The CRL will be valid for %s; schedule the next ceremony before it expires\n", cmd.Validity)
+	}
+	fmt.Println("Using key storage:", cmd.CA.Provider.Name())
+
+	var info *ca.CRLInfo
+	var err error
+	if cmd.Delta {
+		info, err = cmd.CA.GenerateDeltaCRL(cmd.Validity)
+	} else {
+		info, err = cmd.CA.GenerateCRL(cmd.Validity)
+	}
+	if err != nil {
+		return fmt.Errorf("error generating CRL: %w", err)
+	}
+
+	printCRLInfo(info)
+	return nil
+}
+
+// runDaemon regenerates the CRL on a schedule until interrupted
+func (cmd *CRLCommand) runDaemon() error {
+	if cmd.CA.Type == ca.RootCA {
+		return errors.New("the offline Root CA does not run a CRL daemon; generate a long-lived CRL during a ceremony instead")
+	}
+
+	publisher := ca.NewCRLPublisher(cmd.CA)
+	publisher.Validity = cmd.Validity
+	publisher.DeltaInterval = cmd.DeltaInterval
+	if cmd.RenewBefore > 0 {
+		publisher.RenewBefore = cmd.RenewBefore
+	} else {
+		// Re-sign once half of the CRL lifetime has passed
+		publisher.RenewBefore = cmd.Validity / 2
+	}
+	if err := publisher.Validate(); err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	fmt.Printf("Publishing CRL to %s, checking every %s and renewing %s before nextUpdate (validity %s)\n",
+		cmd.CA.CRLFile, publisher.CheckInterval, publisher.RenewBefore, publisher.Validity)
+	publisher.Run(ctx)
+	return nil
+}
+
+// printCRLInfo prints a short summary of a generated CRL
+func printCRLInfo(info *ca.CRLInfo) {
+	kind := "CRL"
+	if info.Delta {
+		kind = "Delta CRL"
+	}
+	fmt.Printf("%s #%d published to %s\n", kind, info.Number, info.File)
+	fmt.Printf("  Revoked entries: %d\n", info.Entries)
+	fmt.Printf("  This update:     %s\n", info.ThisUpdate.Format(time.RFC3339))
+	fmt.Printf("  Next update:     %s\n", info.NextUpdate.Format(time.RFC3339))
+	for _, file := range info.KeyFiles {
+		fmt.Printf("  Earlier key CRL: %s\n", file)
+	}
+}
@@ -0,0 +1,94 @@
+package commands
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/billchurch/PiCA/internal/ca"
+	"github.com/billchurch/PiCA/internal/crypto"
+)
+
+// CSRCommand builds a PKCS#10 request for a key held by a crypto provider,
+// e.g. to have the sub CA certified by an external root
+type CSRCommand struct {
+	RequestFile string
+	OutFile     string
+	GenerateKey bool
+	Slot        crypto.Slot
+	Provider    crypto.Provider
+
+	// AttestationRoots verify the attestation of a key held by a YubiKey,
+	// which is saved next to the CSR for the signing CA; the bundled
+	// Yubico roots when nil
+	AttestationRoots *x509.CertPool
+}
+
+// NewCSRCommand creates a new CSRCommand
+func NewCSRCommand(requestFile, outFile string, provider crypto.Provider, slot crypto.Slot) *CSRCommand {
+	return &CSRCommand{
+		RequestFile: requestFile,
+		OutFile:     outFile,
+		Provider:    provider,
+		Slot:        slot,
+	}
+}
+
+// Execute creates the CSR and writes it to OutFile
+func (cmd *CSRCommand) Execute() error {
+	reqJSON, err := os.ReadFile(cmd.RequestFile)
+	if err != nil {
+		return fmt.Errorf("error reading request file: %w", err)
+	}
+
+	req, opts, err := ca.LoadCertificateRequest(reqJSON)
+	if err != nil {
+		return err
+	}
+
+	// Only create a key when asked to or when the slot is empty, so an
+	// existing CA key is never replaced by accident
+	generate := cmd.GenerateKey
+	if !generate {
+		if _, err := cmd.Provider.GetPublicKey(cmd.Slot); err != nil {
+			if !errors.Is(err, crypto.ErrKeyNotFound) {
+				return fmt.Errorf("failed to read key from slot: %w", err)
+			}
+			generate = true
+		}
+	}
+	if generate {
+		fmt.Println("Generating new key in", cmd.Provider.Name())
+	} else {
+		fmt.Println("Using existing key in", cmd.Provider.Name())
+	}
+
+	csrPEM, err := ca.CreateCSRWithOptions(req, opts, cmd.Provider, cmd.Slot, generate)
+	if err != nil {
+		return fmt.Errorf("error creating certificate request: %w", err)
+	}
+
+	if err := os.WriteFile(cmd.OutFile, csrPEM, 0644); err != nil {
+		return fmt.Errorf("error writing CSR: %w", err)
+	}
+
+	request, err := ca.ParseCSR(csrPEM)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("CSR written to", cmd.OutFile)
+	fmt.Println("  Subject:", request.Subject.String())
+	for _, name := range request.DNSNames {
+		fmt.Println("  DNS:", name)
+	}
+	for _, ip := range request.IPAddresses {
+		fmt.Println("  IP:", ip.String())
+	}
+	for _, email := range request.EmailAddresses {
+		fmt.Println("  Email:", email)
+	}
+	saveAttestation(cmd.Provider, cmd.Slot, cmd.AttestationRoots, cmd.OutFile)
+	return nil
+}
@@ -0,0 +1,142 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/billchurch/PiCA/internal/ca"
+)
+
+// HierarchyInitCommand creates the keys and certificates for every CA in a
+// hierarchy that does not have a certificate yet, parents first
+type HierarchyInitCommand struct {
+	Hierarchy *ca.Hierarchy
+	// Name limits initialization to a single CA whose parent already exists
+	Name      string
+	Providers *ca.ProviderPool
+}
+
+// NewHierarchyInitCommand creates a new HierarchyInitCommand
+func NewHierarchyInitCommand(h *ca.Hierarchy, providers *ca.ProviderPool) *HierarchyInitCommand {
+	return &HierarchyInitCommand{
+		Hierarchy: h,
+		Providers: providers,
+	}
+}
+
+// Execute initializes the missing CAs
+func (cmd *HierarchyInitCommand) Execute() error {
+	if cmd.Name != "" {
+		if _, err := cmd.Hierarchy.Get(cmd.Name); err != nil {
+			return err
+		}
+	}
+
+	for _, def := range cmd.Hierarchy.Ordered() {
+		if cmd.Name != "" && def.Name != cmd.Name {
+			continue
+		}
+		if _, err := os.Stat(def.Certificate); err == nil {
+			fmt.Printf("CA %s already initialized (%s), skipping\n", def.Name, def.Certificate)
+			continue
+		}
+		if err := cmd.initCA(def); err != nil {
+			return fmt.Errorf("error initializing CA %s: %w", def.Name, err)
+		}
+	}
+	return nil
+}
+
+// initCA creates the key and certificate of a single CA
+func (cmd *HierarchyInitCommand) initCA(def *ca.CADefinition) error {
+	reqJSON, err := os.ReadFile(def.CSR)
+	if err != nil {
+		return fmt.Errorf("error reading CSR file: %w", err)
+	}
+	req, opts, err := ca.LoadCertificateRequest(reqJSON)
+	if err != nil {
+		return err
+	}
+
+	provider, err := cmd.Providers.Get(def)
+	if err != nil {
+		return err
+	}
+	slot, err := def.KeySlot()
+	if err != nil {
+		return err
+	}
+	expiry, err := def.ExpiryDuration()
+	if err != nil {
+		return err
+	}
+
+	if def.IsRoot() {
+		fmt.Printf("Initializing root CA %s in %s\n", def.Name, provider.Name())
+		if err := ca.GenerateRootCAWithOptions(req, opts, provider, slot, def.Certificate, expiry, def.PathLength()); err != nil {
+			return err
+		}
+		fmt.Println("Certificate saved to:", def.Certificate)
+		return nil
+	}
+
+	parent, err := cmd.Hierarchy.Get(def.Parent)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(parent.Certificate); err != nil {
+		return fmt.Errorf("parent CA %s has not been initialized", parent.Name)
+	}
+	parentProvider, err := cmd.Providers.Get(parent)
+	if err != nil {
+		return err
+	}
+	parentSlot, err := parent.KeySlot()
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Initializing CA %s in %s, signed by %s\n", def.Name, provider.Name(), parent.Name)
+	if err := ca.GenerateIntermediateCAWithOptions(req, opts, parentProvider, parentSlot, provider, slot,
+		parent.Certificate, def.Certificate, expiry, def.PathLength()); err != nil {
+		return err
+	}
+	fmt.Println("Certificate saved to:", def.Certificate)
+	return nil
+}
+
+// PrintHierarchy writes the CA tree with each CA's slot, path length and
+// certificate status
+func PrintHierarchy(w io.Writer, h *ca.Hierarchy) {
+	var walk func(parent string, depth int)
+	walk = func(parent string, depth int) {
+		for _, def := range h.CAs {
+			if def.Parent != parent || def.Name == parent {
+				continue
+			}
+
+			pathLen := "unconstrained"
+			if def.PathLen != nil {
+				pathLen = fmt.Sprintf("%d", *def.PathLen)
+			}
+			status := "not initialized"
+			if cert, err := ca.LoadCertificate(def.Certificate); err == nil {
+				status = "expires " + cert.NotAfter.Format("2006-01-02")
+			} else if !errors.Is(err, os.ErrNotExist) {
+				status = "unreadable certificate"
+			}
+
+			provider := def.Provider
+			if provider == "" {
+				provider = "default"
+			}
+			fmt.Fprintf(w, "%s%s  [%s slot %s, pathlen %s, %s]\n",
+				strings.Repeat("  ", depth), def.Name, provider, def.Slot, pathLen, status)
+			walk(def.Name, depth+1)
+		}
+	}
+	walk("", 0)
+}
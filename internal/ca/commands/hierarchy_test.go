@@ -0,0 +1,126 @@
+package commands
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/billchurch/PiCA/internal/ca"
+	"github.com/cloudflare/cfssl/csr"
+)
+
+func TestHierarchyInitThreeTiers(t *testing.T) {
+	dir := t.TempDir()
+
+	writeJSON := func(name string, v interface{}) string {
+		data, _ := json.Marshal(v)
+		filename := filepath.Join(dir, name)
+		if err := os.WriteFile(filename, data, 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+		return filename
+	}
+	request := func(cn string) string {
+		return writeJSON(cn+"-csr.json", map[string]interface{}{
+			"CN":    cn,
+			"names": []map[string]string{{"C": "US", "O": "PiCA Test", "OU": cn}},
+			"key":   map[string]interface{}{"algo": "ecdsa", "size": 256},
+		})
+	}
+	software := func(name string) map[string]interface{} {
+		return map[string]interface{}{"directory": filepath.Join(dir, "keys", name)}
+	}
+	two, one, zero := 2, 1, 0
+
+	h := &ca.Hierarchy{CAs: []*ca.CADefinition{
+		// Children are listed before their parents on purpose
+		{Name: "servers", Parent: "policy", Provider: "software", ProviderOptions: software("issuing"), Slot: "84",
+			PathLen: &zero, CSR: request("Servers CA"), Certificate: filepath.Join(dir, "servers.pem")},
+		{Name: "users", Parent: "policy", Provider: "software", ProviderOptions: software("issuing"), Slot: "85",
+			PathLen: &zero, CSR: request("Users CA"), Certificate: filepath.Join(dir, "users.pem")},
+		{Name: "policy", Parent: "root", Provider: "software", ProviderOptions: software("policy"), Slot: "83",
+			PathLen: &one, CSR: request("Policy CA"), Certificate: filepath.Join(dir, "policy.pem")},
+		{Name: "root", Provider: "software", ProviderOptions: software("root"), Slot: "82", PathLen: &two,
+			CSR: request("Root CA"), Certificate: filepath.Join(dir, "root.pem"), Expiry: "87600h"},
+	}}
+	if err := h.Validate(); err != nil {
+		t.Fatalf("Validate failed: %v", err)
+	}
+
+	providers := ca.NewProviderPool()
+	defer providers.Close()
+	if err := NewHierarchyInitCommand(h, providers).Execute(); err != nil {
+		t.Fatalf("Hierarchy init failed: %v", err)
+	}
+
+	load := func(name string) *x509.Certificate {
+		def, _ := h.Get(name)
+		cert, err := ca.LoadCertificate(def.Certificate)
+		if err != nil {
+			t.Fatalf("Failed to load %s: %v", name, err)
+		}
+		return cert
+	}
+	root, policy, servers := load("root"), load("policy"), load("servers")
+
+	if root.MaxPathLen != 2 || policy.MaxPathLen != 1 || servers.MaxPathLen != 0 || !servers.MaxPathLenZero {
+		t.Errorf("Unexpected path lengths: root %d, policy %d, servers %d", root.MaxPathLen, policy.MaxPathLen, servers.MaxPathLen)
+	}
+	if got := strings.Join(servers.Subject.OrganizationalUnit, ","); got != "Servers CA" {
+		t.Errorf("Unexpected subject OU: %q", got)
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(root)
+	intermediates := x509.NewCertPool()
+	intermediates.AddCert(policy)
+	if _, err := servers.Verify(x509.VerifyOptions{Roots: roots, Intermediates: intermediates}); err != nil {
+		t.Errorf("Servers CA does not chain to the root: %v", err)
+	}
+
+	// Re-running is a no-op for existing CAs
+	before, _ := os.ReadFile(filepath.Join(dir, "servers.pem"))
+	if err := NewHierarchyInitCommand(h, providers).Execute(); err != nil {
+		t.Fatalf("Second init failed: %v", err)
+	}
+	after, _ := os.ReadFile(filepath.Join(dir, "servers.pem"))
+	if !bytes.Equal(before, after) {
+		t.Error("Existing CA certificate was replaced")
+	}
+
+	var out bytes.Buffer
+	PrintHierarchy(&out, h)
+	if !strings.Contains(out.String(), "root") || !strings.Contains(out.String(), "    servers") {
+		t.Errorf("Unexpected hierarchy output:\n%s", out.String())
+	}
+
+	// An issuing CA with path length zero cannot certify another CA
+	issuing, _ := h.Get("servers")
+	provider, err := providers.Get(issuing)
+	if err != nil {
+		t.Fatalf("Failed to get provider: %v", err)
+	}
+	err = ca.GenerateIntermediateCA(mustRequest(t, request("Rogue CA")), provider, 0x84, provider, 0x86,
+		issuing.Certificate, filepath.Join(dir, "rogue.pem"), 0, -1)
+	if err == nil {
+		t.Error("Expected a pathlen 0 CA to refuse issuing a CA certificate")
+	}
+}
+
+// mustRequest loads a cfssl JSON request file
+func mustRequest(t *testing.T, filename string) *csr.CertificateRequest {
+	t.Helper()
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("Failed to read request: %v", err)
+	}
+	req, _, err := ca.LoadCertificateRequest(data)
+	if err != nil {
+		t.Fatalf("Failed to parse request: %v", err)
+	}
+	return req
+}
@@ -0,0 +1,82 @@
+package commands
+
+import (
+	"fmt"
+
+	"github.com/billchurch/PiCA/internal/ca"
+	"github.com/billchurch/PiCA/internal/crypto"
+)
+
+// ImportCommand moves an existing CA (e.g. an OpenSSL-managed intermediate)
+// onto PiCA: the key is loaded into a provider slot, the certificate and
+// chain are written to CACertFile and the OpenSSL database is optionally
+// copied into the certificate store
+type ImportCommand struct {
+	KeyFile     string
+	CertFile    string
+	ChainFile   string
+	Password    []byte
+	CACertFile  string
+	IndexFile   string
+	CertsDir    string
+	DatabaseDir string
+	Force       bool
+	Slot        crypto.Slot
+	Provider    crypto.Provider
+}
+
+// NewImportCommand creates a new ImportCommand
+func NewImportCommand(keyFile, certFile, caCertFile string, provider crypto.Provider, slot crypto.Slot) *ImportCommand {
+	return &ImportCommand{
+		KeyFile:    keyFile,
+		CertFile:   certFile,
+		CACertFile: caCertFile,
+		Provider:   provider,
+		Slot:       slot,
+	}
+}
+
+// Execute validates and imports the key material and history
+func (cmd *ImportCommand) Execute() error {
+	material, err := ca.LoadKeyMaterial(cmd.KeyFile, cmd.CertFile, cmd.ChainFile, cmd.Password)
+	if err != nil {
+		return err
+	}
+	if err := material.Validate(); err != nil {
+		return err
+	}
+
+	// Open the store before touching the provider so a bad database path
+	// does not leave a half-finished import
+	var store *ca.Store
+	if cmd.IndexFile != "" {
+		if cmd.DatabaseDir == "" {
+			return fmt.Errorf("a database directory is required to import %s", cmd.IndexFile)
+		}
+		if store, err = ca.OpenStore(cmd.DatabaseDir); err != nil {
+			return err
+		}
+	}
+
+	fmt.Println("Importing CA:", material.Certificate.Subject.String())
+	for _, cert := range material.Chain {
+		fmt.Println("  Chain:", cert.Subject.String())
+	}
+
+	if err := ca.ImportCA(material, cmd.Provider, cmd.Slot, cmd.CACertFile, cmd.Force); err != nil {
+		return err
+	}
+	fmt.Printf("Key imported into %s slot %x\n", cmd.Provider.Name(), int(cmd.Slot))
+	fmt.Println("Certificate written to", cmd.CACertFile)
+
+	if store != nil {
+		result, err := ca.ImportOpenSSLIndex(store, cmd.IndexFile, cmd.CertsDir)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Imported %d certificates (%d revoked, %d already present)\n",
+			result.Imported, result.Revoked, result.Skipped)
+	}
+
+	return nil
+}
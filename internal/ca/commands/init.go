@@ -1,7 +1,7 @@
 package commands
 
 import (
-	"encoding/json"
+	"crypto/x509"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -9,7 +9,6 @@ import (
 
 	"github.com/billchurch/PiCA/internal/ca"
 	"github.com/billchurch/PiCA/internal/crypto"
-	"github.com/cloudflare/cfssl/csr"
 )
 
 // InitCommand represents the command to initialize a new CA
@@ -23,6 +22,27 @@ type InitCommand struct {
 	RootCACertFile   string
 	RootCAConfigFile string
 	Profile          string
+
+	// RootProvider and RootSlot hold the Root CA key when it is available
+	// locally. When RootProvider is nil the Sub CA provider is used, which
+	// only suits lab setups; production Root CAs should stay offline and use
+	// the offline request/sign/import workflow instead.
+	RootProvider crypto.Provider
+	RootSlot     crypto.Slot
+
+	// Credentials supplies the PIN and management key to hardware
+	// providers; unset, they use their default (environment variables)
+	Credentials crypto.CredentialCallback
+
+	// AttestationRoots verify the attestation of a key generated on a
+	// YubiKey, which is saved next to the certificate; the bundled Yubico
+	// roots when nil
+	AttestationRoots *x509.CertPool
+
+	// PathLen limits how many CA levels may follow below a Sub CA; -1
+	// leaves it unconstrained. Deeper hierarchies are easier to manage with
+	// a hierarchy file and HierarchyInitCommand.
+	PathLen int
 }
 
 // NewInitCommand creates a new InitCommand
@@ -33,6 +53,7 @@ func NewInitCommand(caType ca.CAType, configFile, csrFile, certFile string, slot
 		CSRFile:         csrFile,
 		CertificateFile: certFile,
 		Slot:            slot,
+		RootSlot:        crypto.SlotCA1,
 	}
 }
 
@@ -44,12 +65,12 @@ func (cmd *InitCommand) Execute() error {
 		return fmt.Errorf("error reading CSR file: %w", err)
 	}
 
-	// Parse the CSR
-	req := csr.CertificateRequest{}
-	err = json.Unmarshal(csrBytes, &req)
+	// Parse the CSR together with the PiCA request options
+	reqPtr, opts, err := ca.LoadCertificateRequest(csrBytes)
 	if err != nil {
 		return fmt.Errorf("error parsing CSR: %w", err)
 	}
+	req := *reqPtr
 
 	// Create or use the crypto provider
 	if cmd.Provider == nil {
@@ -72,13 +93,7 @@ func (cmd *InitCommand) Execute() error {
 		fmt.Println("Initializing Root CA")
 		fmt.Println("This operation will generate a self-signed certificate")
 
-		if cmd.Provider.IsHardware() {
-			fmt.Println("Using hardware security module:", cmd.Provider.Name())
-			fmt.Println("Please ensure your security device is inserted and press Enter to continue...")
-			fmt.Scanln()
-		} else {
-			fmt.Println("Using software-based key storage:", cmd.Provider.Name())
-		}
+		useProvider(cmd.Provider, cmd.Credentials)
 
 		// Set expiry from the CSR (default to 10 years if not specified)
 		expiry := 10 * 365 * 24 * time.Hour
@@ -90,13 +105,14 @@ func (cmd *InitCommand) Execute() error {
 		}
 
 		// Generate the Root CA certificate
-		err := ca.GenerateRootCA(&req, cmd.Provider, cmd.Slot, cmd.CertificateFile, expiry)
+		err := ca.GenerateRootCAWithOptions(&req, opts, cmd.Provider, cmd.Slot, cmd.CertificateFile, expiry, -1)
 		if err != nil {
 			return fmt.Errorf("error generating Root CA: %w", err)
 		}
 
 		fmt.Println("Root CA initialized successfully!")
 		fmt.Println("Certificate saved to:", cmd.CertificateFile)
+		saveAttestation(cmd.Provider, cmd.Slot, cmd.AttestationRoots, cmd.CertificateFile)
 		return nil
 
 	case ca.SubCA:
@@ -107,13 +123,7 @@ func (cmd *InitCommand) Execute() error {
 		fmt.Println("2. The Root CA certificate")
 		fmt.Println("3. Access to the Root CA security module for signing")
 
-		if cmd.Provider.IsHardware() {
-			fmt.Println("Using hardware security module:", cmd.Provider.Name())
-			fmt.Println("Please ensure your security device is inserted and press Enter to continue...")
-			fmt.Scanln()
-		} else {
-			fmt.Println("Using software-based key storage:", cmd.Provider.Name())
-		}
+		useProvider(cmd.Provider, cmd.Credentials)
 
 		// Use the provided Root CA certificate path or fall back to default
 		rootCACertFile := "./certs/root-ca.pem"
@@ -121,12 +131,23 @@ func (cmd *InitCommand) Execute() error {
 			rootCACertFile = cmd.RootCACertFile
 		}
 
-		// Create a root CA provider (can be the same as the sub CA provider)
-		rootProvider := cmd.Provider
+		// Use the Root CA provider if one was given, otherwise assume the
+		// Root CA key lives in the same provider
+		rootProvider := cmd.RootProvider
+		if rootProvider == nil {
+			fmt.Println("Warning: no separate Root CA provider given; using the Sub CA provider")
+			fmt.Println("For an offline Root CA use 'pica offline request' instead")
+			rootProvider = cmd.Provider
+		} else if cmd.Credentials != nil {
+			crypto.SetCredentials(rootProvider, cmd.Credentials)
+		}
 
 		// Set slots for root and sub CA
-		rootSlot := crypto.SlotCA1
-		subSlot := crypto.SlotCA2
+		rootSlot := cmd.RootSlot
+		subSlot := cmd.Slot
+		if rootProvider == cmd.Provider && rootSlot == subSlot {
+			return fmt.Errorf("Root CA and Sub CA cannot share slot %x in the same provider", subSlot)
+		}
 
 		// Set expiry from the CSR (default to 5 years if not specified)
 		expiry := 5 * 365 * 24 * time.Hour
@@ -138,14 +159,15 @@ func (cmd *InitCommand) Execute() error {
 		}
 
 		// Generate the Sub CA certificate
-		err := ca.GenerateSubCA(&req, rootProvider, rootSlot, cmd.Provider, subSlot,
-			rootCACertFile, cmd.CertificateFile, expiry)
+		err := ca.GenerateIntermediateCAWithOptions(&req, opts, rootProvider, rootSlot, cmd.Provider, subSlot,
+			rootCACertFile, cmd.CertificateFile, expiry, cmd.PathLen)
 		if err != nil {
 			return fmt.Errorf("error generating Sub CA: %w", err)
 		}
 
 		fmt.Println("Sub CA initialized successfully!")
 		fmt.Println("Certificate saved to:", cmd.CertificateFile)
+		saveAttestation(cmd.Provider, subSlot, cmd.AttestationRoots, cmd.CertificateFile)
 		return nil
 
 	default:
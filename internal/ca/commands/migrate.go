@@ -0,0 +1,167 @@
+package commands
+
+import (
+	gocrypto "crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/billchurch/PiCA/internal/audit"
+	"github.com/billchurch/PiCA/internal/crypto"
+)
+
+// MigrateKeyCommand copies a CA key and its certificate to another
+// provider or slot, optionally wiping the source afterwards
+type MigrateKeyCommand struct {
+	Source     crypto.Provider
+	SourceSlot crypto.Slot
+	// Target may be the source provider to renumber a slot
+	Target     crypto.Provider
+	TargetSlot crypto.Slot
+	// Wipe deletes the source key and certificate once the copy is
+	// verified, after confirmation on Input unless AssumeYes is set
+	Wipe      bool
+	AssumeYes bool
+	Input     io.Reader
+	// CAName names the CA in the audit log
+	CAName string
+}
+
+// NewMigrateKeyCommand creates a new MigrateKeyCommand that copies the key
+func NewMigrateKeyCommand(source crypto.Provider, sourceSlot crypto.Slot, target crypto.Provider, targetSlot crypto.Slot) *MigrateKeyCommand {
+	return &MigrateKeyCommand{
+		Source:     source,
+		SourceSlot: sourceSlot,
+		Target:     target,
+		TargetSlot: targetSlot,
+		Input:      os.Stdin,
+	}
+}
+
+// Execute migrates the key
+func (cmd *MigrateKeyCommand) Execute() error {
+	if cmd.Source == cmd.Target && cmd.SourceSlot == cmd.TargetSlot {
+		return errors.New("the source and target are the same slot")
+	}
+
+	pub, err := cmd.Source.GetPublicKey(cmd.SourceSlot)
+	if err != nil {
+		return fmt.Errorf("error reading slot %X of %s: %w", int(cmd.SourceSlot), cmd.Source.Name(), err)
+	}
+	cert, err := cmd.Source.GetCertificate(cmd.SourceSlot)
+	if err != nil && !errors.Is(err, crypto.ErrCertNotFound) {
+		return fmt.Errorf("error reading certificate: %w", err)
+	}
+	if _, err := cmd.Target.GetPublicKey(cmd.TargetSlot); err == nil {
+		return fmt.Errorf("%w: slot %X of %s", crypto.ErrSlotInUse, int(cmd.TargetSlot), cmd.Target.Name())
+	}
+
+	fmt.Printf("Migrating the key in slot %X of %s to slot %X of %s\n",
+		int(cmd.SourceSlot), cmd.Source.Name(), int(cmd.TargetSlot), cmd.Target.Name())
+	key, err := crypto.ExportKey(cmd.Source, cmd.SourceSlot)
+	if errors.Is(err, crypto.ErrOperationNotSupported) {
+		return fmt.Errorf("%w: keys cannot leave %s; load a key ceremony backup into the target instead", err, cmd.Source.Name())
+	}
+	if err != nil {
+		return fmt.Errorf("error exporting key: %w", err)
+	}
+	err = crypto.ImportKey(cmd.Target, cmd.TargetSlot, key)
+	if errors.Is(err, crypto.ErrOperationNotSupported) {
+		return fmt.Errorf("%w: %s does not accept imported keys", err, cmd.Target.Name())
+	}
+	if err != nil {
+		return fmt.Errorf("error importing key: %w", err)
+	}
+	if cert != nil {
+		if err := cmd.Target.ImportCertificate(cmd.TargetSlot, cert); err != nil {
+			cmd.Target.DeleteKey(cmd.TargetSlot)
+			return fmt.Errorf("error importing certificate: %w", err)
+		}
+	}
+
+	// Both must hold the same key before the source may go
+	if err := verifyMigration(pub, cmd.Source, cmd.SourceSlot, cmd.Target, cmd.TargetSlot); err != nil {
+		cmd.Target.DeleteKey(cmd.TargetSlot)
+		return fmt.Errorf("the migrated key failed verification and was removed from the target: %w", err)
+	}
+	der, _ := x509.MarshalPKIXPublicKey(pub)
+	sum := sha256.Sum256(der)
+	fingerprint := hex.EncodeToString(sum[:])
+	fmt.Println("Verified: both slots sign with public key", fingerprint)
+
+	wiped := false
+	if cmd.Wipe {
+		question := fmt.Sprintf("Delete the key in slot %X of %s, leaving slot %X of %s as the only copy?",
+			int(cmd.SourceSlot), cmd.Source.Name(), int(cmd.TargetSlot), cmd.Target.Name())
+		if cmd.AssumeYes || confirm(cmd.Input, question) {
+			if err := cmd.Source.DeleteKey(cmd.SourceSlot); err != nil {
+				return fmt.Errorf("the key was migrated but deleting the source failed: %w", err)
+			}
+			wiped = true
+			fmt.Println("Source key deleted")
+		} else {
+			fmt.Println("Source key kept")
+		}
+	}
+
+	audit.Record(audit.Event{
+		Type: audit.EventKeyMigrated,
+		CA:   cmd.CAName,
+		Details: map[string]interface{}{
+			"source":      fmt.Sprintf("%s slot %X", cmd.Source.Type(), int(cmd.SourceSlot)),
+			"target":      fmt.Sprintf("%s slot %X", cmd.Target.Type(), int(cmd.TargetSlot)),
+			"publicKey":   fingerprint,
+			"certificate": cert != nil,
+			"wiped":       wiped,
+		},
+	})
+	return nil
+}
+
+// verifyMigration checks that both slots report the expected public key
+// and produce valid signatures over the same random digest
+func verifyMigration(pub gocrypto.PublicKey, source crypto.Provider, sourceSlot crypto.Slot, target crypto.Provider, targetSlot crypto.Slot) error {
+	digest := make([]byte, sha256.Size)
+	if _, err := rand.Read(digest); err != nil {
+		return err
+	}
+	for _, s := range []struct {
+		provider crypto.Provider
+		slot     crypto.Slot
+	}{{source, sourceSlot}, {target, targetSlot}} {
+		got, err := s.provider.GetPublicKey(s.slot)
+		if err != nil {
+			return err
+		}
+		if !publicKeysEqual(pub, got) {
+			return fmt.Errorf("slot %X of %s holds a different public key", int(s.slot), s.provider.Name())
+		}
+		sig, err := s.provider.Sign(s.slot, digest, gocrypto.SHA256)
+		if err != nil {
+			return fmt.Errorf("signing with slot %X of %s: %w", int(s.slot), s.provider.Name(), err)
+		}
+		if !verifyDigest(pub, digest, sig) {
+			return fmt.Errorf("the signature of slot %X of %s does not verify", int(s.slot), s.provider.Name())
+		}
+	}
+	return nil
+}
+
+// verifyDigest checks an ECDSA or PKCS #1 v1.5 signature over a SHA-256
+// digest
+func verifyDigest(pub gocrypto.PublicKey, digest, sig []byte) bool {
+	switch key := pub.(type) {
+	case *ecdsa.PublicKey:
+		return ecdsa.VerifyASN1(key, digest, sig)
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(key, gocrypto.SHA256, digest, sig) == nil
+	}
+	return false
+}
@@ -0,0 +1,92 @@
+package commands
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/billchurch/PiCA/internal/ca"
+	"github.com/billchurch/PiCA/internal/crypto"
+	"github.com/cloudflare/cfssl/csr"
+)
+
+// noImportProvider hides ImportKey, like a provider whose keys must be
+// generated in place
+type noImportProvider struct {
+	crypto.Provider
+}
+
+func TestMigrateKey(t *testing.T) {
+	dir := t.TempDir()
+	source := newSoftwareProvider(t, filepath.Join(dir, "source"))
+	target := newSoftwareProvider(t, filepath.Join(dir, "target"))
+
+	req := &csr.CertificateRequest{
+		CN:         "Migrated Root",
+		Names:      []csr.Name{{C: "US", O: "PiCA Test"}},
+		KeyRequest: &csr.KeyRequest{A: "rsa", S: 2048},
+	}
+	if err := ca.GenerateRootCA(req, source, crypto.SlotCA1, "", 24*time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	pub, _ := source.GetPublicKey(crypto.SlotCA1)
+
+	// Copy to another provider, keeping the source
+	if err := NewMigrateKeyCommand(source, crypto.SlotCA1, target, crypto.SlotCA1).Execute(); err != nil {
+		t.Fatalf("Migration failed: %v", err)
+	}
+	if got, err := target.GetPublicKey(crypto.SlotCA1); err != nil || !publicKeysEqual(pub, got) {
+		t.Errorf("Expected the key in the target, got %v", err)
+	}
+	if cert, err := target.GetCertificate(crypto.SlotCA1); err != nil || cert.Subject.CommonName != "Migrated Root" {
+		t.Errorf("Expected the certificate in the target, got %v", err)
+	}
+	if _, err := source.GetPublicKey(crypto.SlotCA1); err != nil {
+		t.Errorf("Expected a copy to keep the source key: %v", err)
+	}
+
+	// The target slot is taken now
+	if err := NewMigrateKeyCommand(source, crypto.SlotCA1, target, crypto.SlotCA1).Execute(); !errors.Is(err, crypto.ErrSlotInUse) {
+		t.Errorf("Expected ErrSlotInUse, got %v", err)
+	}
+
+	// Renumber within the target, wiping the old slot after confirmation
+	renumber := NewMigrateKeyCommand(target, crypto.SlotCA1, target, crypto.SlotCA2)
+	renumber.Wipe = true
+	renumber.Input = strings.NewReader("yes\n")
+	if err := renumber.Execute(); err != nil {
+		t.Fatalf("Renumbering failed: %v", err)
+	}
+	if _, err := target.GetPublicKey(crypto.SlotCA1); !errors.Is(err, crypto.ErrKeyNotFound) {
+		t.Errorf("Expected the old slot to be wiped, got %v", err)
+	}
+	if got, err := target.GetPublicKey(crypto.SlotCA2); err != nil || !publicKeysEqual(pub, got) {
+		t.Errorf("Expected the key in the new slot, got %v", err)
+	}
+
+	// Declining the wipe keeps the source
+	keep := NewMigrateKeyCommand(target, crypto.SlotCA2, target, crypto.SlotSSH)
+	keep.Wipe = true
+	keep.Input = strings.NewReader("no\n")
+	if err := keep.Execute(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := target.GetPublicKey(crypto.SlotCA2); err != nil {
+		t.Errorf("Expected the source to be kept after declining: %v", err)
+	}
+
+	// Providers that cannot take or release keys
+	err := NewMigrateKeyCommand(source, crypto.SlotCA1, noImportProvider{target}, crypto.SlotTSA).Execute()
+	if !errors.Is(err, crypto.ErrOperationNotSupported) {
+		t.Errorf("Expected ErrOperationNotSupported for a target without import, got %v", err)
+	}
+	err = NewMigrateKeyCommand(noImportProvider{source}, crypto.SlotCA1, target, crypto.SlotTSA).Execute()
+	if !errors.Is(err, crypto.ErrOperationNotSupported) {
+		t.Errorf("Expected ErrOperationNotSupported for a source without export, got %v", err)
+	}
+	if err := NewMigrateKeyCommand(source, crypto.SlotCA1, source, crypto.SlotCA1).Execute(); err == nil {
+		t.Error("Expected an error migrating a slot onto itself")
+	}
+}
@@ -0,0 +1,442 @@
+package commands
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/billchurch/PiCA/internal/ca"
+	"github.com/billchurch/PiCA/internal/crypto"
+	"github.com/billchurch/PiCA/internal/transfer"
+	"github.com/cloudflare/cfssl/csr"
+)
+
+// OfflineRequestCommand creates a sub CA key and CSR and exports them as a
+// signed transfer bundle for the offline root CA
+type OfflineRequestCommand struct {
+	CSRFile    string
+	BundleFile string
+	Comment    string
+	Slot       crypto.Slot
+	Provider   crypto.Provider
+	// NewKey replaces a key already in the slot instead of refusing
+	NewKey bool
+}
+
+// NewOfflineRequestCommand creates a new OfflineRequestCommand
+func NewOfflineRequestCommand(csrFile, bundleFile string, provider crypto.Provider, slot crypto.Slot) *OfflineRequestCommand {
+	return &OfflineRequestCommand{
+		CSRFile:    csrFile,
+		BundleFile: bundleFile,
+		Provider:   provider,
+		Slot:       slot,
+	}
+}
+
+// Execute generates the key and CSR and writes the request bundle
+func (cmd *OfflineRequestCommand) Execute() error {
+	reqJSON, err := os.ReadFile(cmd.CSRFile)
+	if err != nil {
+		return fmt.Errorf("error reading CSR file: %w", err)
+	}
+
+	req, opts, err := ca.LoadCertificateRequest(reqJSON)
+	if err != nil {
+		return err
+	}
+
+	// The slot may hold the key of another CA
+	if _, err := cmd.Provider.GetPublicKey(cmd.Slot); err == nil && !cmd.NewKey {
+		return fmt.Errorf("%w: slot %X of %s; pass --new-key to replace the key", crypto.ErrSlotInUse, int(cmd.Slot), cmd.Provider.Name())
+	}
+
+	fmt.Println("Generating Sub CA key in", cmd.Provider.Name())
+	csrPEM, err := ca.CreateCSRWithOptions(req, opts, cmd.Provider, cmd.Slot, true)
+	if err != nil {
+		return fmt.Errorf("error creating certificate request: %w", err)
+	}
+
+	signer, err := crypto.CreateProviderSigner(cmd.Provider, cmd.Slot)
+	if err != nil {
+		return err
+	}
+
+	bundle := transfer.New(transfer.KindSubCARequest, cmd.Comment)
+	bundle.AddFile(transfer.FileCSR, csrPEM)
+	bundle.AddFile(transfer.FileRequestJSON, reqJSON)
+
+	// The request bundle is signed with the new Sub CA key itself; the root
+	// CA verifies it against the public key in the CSR
+	if err := bundle.Sign(signer); err != nil {
+		return err
+	}
+
+	if err := transfer.Write(cmd.BundleFile, bundle); err != nil {
+		return err
+	}
+
+	fingerprint, _ := bundle.Fingerprint()
+	fmt.Println("Request bundle written to:", cmd.BundleFile)
+	fmt.Println("Bundle fingerprint:", fingerprint)
+	fmt.Println("Compare this fingerprint with the one shown on the Root CA before signing.")
+	return nil
+}
+
+// DefaultSubCAExpiry is the validity of Sub CA certificates signed offline
+// when neither the operator nor the request sets one
+const DefaultSubCAExpiry = 5 * 365 * 24 * time.Hour
+
+// OfflineSignCommand imports a request bundle on the air-gapped root CA,
+// shows it for review, signs it and exports the response bundle
+type OfflineSignCommand struct {
+	RootCA       *ca.CA
+	RequestFile  string
+	ResponseFile string
+	// Expiry, when set, overrides the validity requested in the bundle
+	Expiry      time.Duration
+	CRLValidity time.Duration
+	// Passthrough lists the OIDs of custom extensions the request may carry
+	// into the Sub CA certificate; requests with any other are refused
+	Passthrough []string
+	// PathLen is the path length of the issued CA certificate: 0 for an
+	// issuing CA, higher for a policy CA, -1 for unconstrained
+	PathLen   int
+	AssumeYes bool
+	Input     io.Reader
+	Slot      crypto.Slot
+	Provider  crypto.Provider
+}
+
+// NewOfflineSignCommand creates a new OfflineSignCommand
+func NewOfflineSignCommand(rootCA *ca.CA, requestFile, responseFile string, provider crypto.Provider, slot crypto.Slot) *OfflineSignCommand {
+	return &OfflineSignCommand{
+		RootCA:       rootCA,
+		RequestFile:  requestFile,
+		ResponseFile: responseFile,
+		CRLValidity:  ca.DefaultRootCRLValidity,
+		Input:        os.Stdin,
+		Provider:     provider,
+		Slot:         slot,
+	}
+}
+
+// Execute verifies, reviews and signs a sub CA request
+func (cmd *OfflineSignCommand) Execute() error {
+	cmd.RootCA.Provider = cmd.Provider
+	cmd.RootCA.Slot = cmd.Slot
+
+	bundle, err := transfer.Read(cmd.RequestFile, transfer.KindSubCARequest)
+	if err != nil {
+		return err
+	}
+
+	csrPEM, err := bundle.File(transfer.FileCSR)
+	if err != nil {
+		return err
+	}
+
+	request, err := ca.ParseCSR(csrPEM)
+	if err != nil {
+		return err
+	}
+
+	// The bundle must be signed by the key it asks us to certify
+	if err := bundle.Verify(request.PublicKey); err != nil {
+		return fmt.Errorf("request bundle failed integrity check: %w", err)
+	}
+
+	// The expiry requested in the cfssl request, if any
+	var requested time.Duration
+	if reqJSON, err := bundle.File(transfer.FileRequestJSON); err == nil {
+		req := csr.CertificateRequest{}
+		if json.Unmarshal(reqJSON, &req) == nil && req.CA != nil && req.CA.Expiry != "" {
+			if parsed, err := time.ParseDuration(req.CA.Expiry); err == nil {
+				requested = parsed
+			}
+		}
+	}
+
+	rootCert, err := ca.LoadCertificate(cmd.RootCA.CertFile)
+	if err != nil {
+		return err
+	}
+
+	// The operator's --expiry wins over the request, and the Sub CA never
+	// outlives the root
+	expiry := cmd.Expiry
+	if expiry == 0 {
+		expiry = requested
+	}
+	if expiry == 0 {
+		expiry = DefaultSubCAExpiry
+	}
+	if remaining := time.Until(rootCert.NotAfter); expiry > remaining {
+		expiry = remaining
+	}
+
+	extensions, err := ca.RequestExtensions(request, cmd.Passthrough)
+	if err != nil {
+		return err
+	}
+
+	fingerprint, _ := bundle.Fingerprint()
+	printRequestReview(bundle, request, fingerprint, extensions, requested, expiry, cmd.PathLen, rootCert)
+
+	if !cmd.AssumeYes && !confirm(cmd.Input, "Sign this Sub CA request?") {
+		return errors.New("signing cancelled by operator")
+	}
+
+	cert, err := ca.SignIntermediateCSR(csrPEM, cmd.Provider, cmd.Slot, rootCert, expiry, cmd.PathLen, cmd.Passthrough)
+	if err != nil {
+		return fmt.Errorf("error signing Sub CA certificate: %w", err)
+	}
+
+	if cmd.RootCA.DatabaseDir != "" {
+		store, err := cmd.RootCA.Store()
+		if err != nil {
+			return err
+		}
+		if err := store.Add(ca.RecordFromCertificate(cert, "subca")); err != nil {
+			return fmt.Errorf("error recording certificate: %w", err)
+		}
+	}
+
+	response := transfer.New(transfer.KindSubCAResponse, "Response to bundle "+fingerprint)
+	response.AddFile(transfer.FileCertificate, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}))
+	response.AddFile(transfer.FileIssuer, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: rootCert.Raw}))
+
+	// Ship a fresh root CRL with every response
+	if cmd.RootCA.CRLFile != "" {
+		info, err := cmd.RootCA.GenerateCRL(cmd.CRLValidity)
+		if err != nil {
+			return fmt.Errorf("error generating root CRL: %w", err)
+		}
+		crlDER, err := os.ReadFile(info.File)
+		if err != nil {
+			return fmt.Errorf("error reading root CRL: %w", err)
+		}
+		response.AddFile(transfer.FileCRL, crlDER)
+		fmt.Printf("Root CRL #%d valid until %s\n", info.Number, info.NextUpdate.Format(time.RFC3339))
+	}
+
+	signer := &crypto.ProviderSigner{
+		Provider:  cmd.Provider,
+		Slot:      cmd.Slot,
+		PublicKey: rootCert.PublicKey,
+	}
+	if err := response.Sign(signer); err != nil {
+		return err
+	}
+
+	if err := transfer.Write(cmd.ResponseFile, response); err != nil {
+		return err
+	}
+
+	responseFingerprint, _ := response.Fingerprint()
+	fmt.Printf("Sub CA certificate issued with serial number %X\n", cert.SerialNumber)
+	fmt.Println("Response bundle written to:", cmd.ResponseFile)
+	fmt.Println("Response fingerprint:", responseFingerprint)
+	return nil
+}
+
+// OfflineImportCommand imports a response bundle from the root CA on the
+// sub CA, verifying it against the pinned root certificate
+type OfflineImportCommand struct {
+	ResponseFile   string
+	RootCACertFile string
+	CertFile       string
+	CRLFile        string
+	Slot           crypto.Slot
+	Provider       crypto.Provider
+}
+
+// NewOfflineImportCommand creates a new OfflineImportCommand
+func NewOfflineImportCommand(responseFile, rootCACertFile, certFile string, provider crypto.Provider, slot crypto.Slot) *OfflineImportCommand {
+	return &OfflineImportCommand{
+		ResponseFile:   responseFile,
+		RootCACertFile: rootCACertFile,
+		CertFile:       certFile,
+		Provider:       provider,
+		Slot:           slot,
+	}
+}
+
+// Execute verifies the response bundle and installs the Sub CA certificate
+func (cmd *OfflineImportCommand) Execute() error {
+	bundle, err := transfer.Read(cmd.ResponseFile, transfer.KindSubCAResponse)
+	if err != nil {
+		return err
+	}
+
+	// The root certificate must already be trusted locally; never take it
+	// from the bundle itself
+	rootCert, err := ca.LoadCertificate(cmd.RootCACertFile)
+	if err != nil {
+		return err
+	}
+
+	if err := bundle.VerifyWithCertificate(rootCert); err != nil {
+		return fmt.Errorf("response bundle failed integrity check: %w", err)
+	}
+
+	certPEM, err := bundle.File(transfer.FileCertificate)
+	if err != nil {
+		return err
+	}
+	block, _ := pem.Decode(certPEM)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return errors.New("failed to decode Sub CA certificate")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return fmt.Errorf("failed to parse Sub CA certificate: %w", err)
+	}
+
+	if err := cert.CheckSignatureFrom(rootCert); err != nil {
+		return fmt.Errorf("Sub CA certificate was not issued by the Root CA: %w", err)
+	}
+
+	// Make sure the certificate is for the key we generated
+	pubKey, err := cmd.Provider.GetPublicKey(cmd.Slot)
+	if err != nil {
+		return fmt.Errorf("failed to read Sub CA public key: %w", err)
+	}
+	if !publicKeysEqual(pubKey, cert.PublicKey) {
+		return fmt.Errorf("certificate does not match the key in slot %x", cmd.Slot)
+	}
+
+	if err := cmd.Provider.ImportCertificate(cmd.Slot, cert); err != nil {
+		return fmt.Errorf("failed to import certificate: %w", err)
+	}
+	if err := ca.WriteCertificateFile(cmd.CertFile, cert); err != nil {
+		return err
+	}
+	fmt.Println("Sub CA certificate saved to:", cmd.CertFile)
+
+	if crlDER, err := bundle.File(transfer.FileCRL); err == nil && cmd.CRLFile != "" {
+		crl, err := x509.ParseRevocationList(crlDER)
+		if err != nil {
+			return fmt.Errorf("failed to parse root CRL: %w", err)
+		}
+		if err := crl.CheckSignatureFrom(rootCert); err != nil {
+			return fmt.Errorf("root CRL signature invalid: %w", err)
+		}
+		if err := os.WriteFile(cmd.CRLFile, crlDER, 0644); err != nil {
+			return fmt.Errorf("failed to write root CRL: %w", err)
+		}
+		fmt.Printf("Root CRL saved to: %s (next update %s)\n", cmd.CRLFile, crl.NextUpdate.Format(time.RFC3339))
+	}
+
+	return nil
+}
+
+// printRequestReview shows everything the root CA operator needs to check
+// before signing
+func printRequestReview(bundle *transfer.Bundle, request *x509.CertificateRequest, fingerprint string,
+	extensions []pkix.Extension, requested, expiry time.Duration, pathLen int, rootCert *x509.Certificate) {
+	pubDER, _ := x509.MarshalPKIXPublicKey(request.PublicKey)
+	keyHash := sha256.Sum256(pubDER)
+
+	fmt.Println("Sub CA request review")
+	fmt.Println("---------------------")
+	fmt.Println("Bundle created: ", bundle.CreatedAt.Format(time.RFC3339))
+	if bundle.Comment != "" {
+		fmt.Println("Comment:        ", bundle.Comment)
+	}
+	fmt.Println("Fingerprint:    ", fingerprint)
+	fmt.Println("Subject:        ", request.Subject.String())
+	fmt.Printf("Key type:        %T\n", request.PublicKey)
+	fmt.Println("Key SHA-256:    ", hex.EncodeToString(keyHash[:]))
+	if len(request.DNSNames) > 0 {
+		fmt.Println("DNS names:      ", strings.Join(request.DNSNames, ", "))
+	}
+	if c, err := ca.ConstraintsFromRequest(request); err != nil {
+		fmt.Println("Constraints:     invalid:", err)
+	} else if c != nil {
+		printConstraints(c)
+	}
+	// Copied into the certificate as they are
+	for _, ext := range extensions {
+		critical := ""
+		if ext.Critical {
+			critical = " (critical)"
+		}
+		fmt.Printf("Extension:       %s%s\n", ext.Id, critical)
+		fmt.Println("  Value:        ", hex.EncodeToString(ext.Value))
+	}
+	if requested > 0 {
+		fmt.Println("Requested:      ", requested)
+	} else {
+		fmt.Println("Requested:       (none)")
+	}
+	fmt.Printf("Validity:        %s (until %s)\n", expiry.Round(time.Second), time.Now().Add(expiry).UTC().Format(time.RFC3339))
+	if pathLen < 0 {
+		fmt.Println("Path length:     unconstrained")
+	} else {
+		fmt.Println("Path length:    ", pathLen)
+	}
+	fmt.Println("Issuer:         ", rootCert.Subject.String())
+	fmt.Println()
+}
+
+// printConstraints lists the name and policy constraints requested for a CA
+func printConstraints(c *ca.CAConstraints) {
+	for _, item := range []struct {
+		label  string
+		values []string
+	}{
+		{"Permitted DNS:  ", c.PermittedDNS},
+		{"Excluded DNS:   ", c.ExcludedDNS},
+		{"Permitted IP:   ", c.PermittedIP},
+		{"Excluded IP:    ", c.ExcludedIP},
+		{"Permitted email:", c.PermittedEmail},
+		{"Excluded email: ", c.ExcludedEmail},
+		{"Permitted URI:  ", c.PermittedURI},
+		{"Excluded URI:   ", c.ExcludedURI},
+		{"Policies:       ", c.Policies},
+	} {
+		if len(item.values) > 0 {
+			fmt.Println(item.label, strings.Join(item.values, ", "))
+		}
+	}
+	if c.RequireExplicitPolicy != nil {
+		fmt.Println("Require explicit policy after:", *c.RequireExplicitPolicy)
+	}
+	if c.InhibitPolicyMapping != nil {
+		fmt.Println("Inhibit policy mapping after: ", *c.InhibitPolicyMapping)
+	}
+	if c.InhibitAnyPolicy != nil {
+		fmt.Println("Inhibit anyPolicy after:      ", *c.InhibitAnyPolicy)
+	}
+}
+
+// confirm asks a yes/no question on the given input
+func confirm(input io.Reader, question string) bool {
+	fmt.Printf("%s [y/N]: ", question)
+	answer, _ := bufio.NewReader(input).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+// publicKeysEqual compares two public keys by their PKIX encoding
+func publicKeysEqual(a, b interface{}) bool {
+	aDER, err := x509.MarshalPKIXPublicKey(a)
+	if err != nil {
+		return false
+	}
+	bDER, err := x509.MarshalPKIXPublicKey(b)
+	if err != nil {
+		return false
+	}
+	return string(aDER) == string(bDER)
+}
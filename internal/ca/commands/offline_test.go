@@ -0,0 +1,273 @@
+package commands
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/billchurch/PiCA/internal/ca"
+	"github.com/billchurch/PiCA/internal/crypto"
+	"github.com/billchurch/PiCA/internal/transfer"
+	"github.com/cloudflare/cfssl/csr"
+)
+
+// newSoftwareProvider returns a connected software provider in dir
+func newSoftwareProvider(t *testing.T, dir string) crypto.Provider {
+	t.Helper()
+
+	provider, err := crypto.NewSoftwareProvider(map[string]interface{}{"directory": dir})
+	if err != nil {
+		t.Fatalf("Failed to create provider: %v", err)
+	}
+	if err := provider.Connect(); err != nil {
+		t.Fatalf("Failed to connect provider: %v", err)
+	}
+	return provider
+}
+
+func TestOfflineSubCAWorkflow(t *testing.T) {
+	dir := t.TempDir()
+
+	// The root and sub CA keys live on different machines
+	rootProvider := newSoftwareProvider(t, filepath.Join(dir, "root"))
+	subProvider := newSoftwareProvider(t, filepath.Join(dir, "sub"))
+
+	rootCertFile := filepath.Join(dir, "root-ca.pem")
+	rootReq := &csr.CertificateRequest{
+		CN:         "Offline Root",
+		Names:      []csr.Name{{C: "US", O: "PiCA Test"}},
+		KeyRequest: &csr.KeyRequest{A: "ecdsa", S: 384},
+	}
+	if err := ca.GenerateRootCA(rootReq, rootProvider, crypto.SlotCA1, rootCertFile, 24*time.Hour); err != nil {
+		t.Fatalf("Failed to generate root CA: %v", err)
+	}
+
+	subReq := csr.CertificateRequest{
+		CN:         "Issuing CA",
+		Names:      []csr.Name{{C: "US", O: "PiCA Test"}},
+		KeyRequest: &csr.KeyRequest{A: "ecdsa", S: 256},
+	}
+	subReqJSON, _ := json.Marshal(subReq)
+	subReqFile := filepath.Join(dir, "sub-csr.json")
+	os.WriteFile(subReqFile, subReqJSON, 0644)
+
+	// Sub CA: generate key and export request bundle
+	requestFile := filepath.Join(dir, "media", "request.json")
+	if err := NewOfflineRequestCommand(subReqFile, requestFile, subProvider, crypto.SlotCA2).Execute(); err != nil {
+		t.Fatalf("Failed to export request: %v", err)
+	}
+
+	// Root CA: review and sign
+	rootCA := ca.NewCA(ca.RootCA, "", "", rootCertFile)
+	rootCA.DatabaseDir = filepath.Join(dir, "root-db")
+	rootCA.CRLFile = filepath.Join(dir, "root-ca.crl")
+
+	responseFile := filepath.Join(dir, "media", "response.json")
+	sign := NewOfflineSignCommand(rootCA, requestFile, responseFile, rootProvider, crypto.SlotCA1)
+	sign.Input = strings.NewReader("yes\n")
+	if err := sign.Execute(); err != nil {
+		t.Fatalf("Failed to sign request: %v", err)
+	}
+
+	// Sub CA: import the response
+	subCertFile := filepath.Join(dir, "sub-ca.pem")
+	imp := NewOfflineImportCommand(responseFile, rootCertFile, subCertFile, subProvider, crypto.SlotCA2)
+	imp.CRLFile = filepath.Join(dir, "imported-root.crl")
+	if err := imp.Execute(); err != nil {
+		t.Fatalf("Failed to import response: %v", err)
+	}
+
+	subCert, err := ca.LoadCertificate(subCertFile)
+	if err != nil {
+		t.Fatalf("Failed to load sub CA certificate: %v", err)
+	}
+	if subCert.Subject.CommonName != "Issuing CA" || !subCert.IsCA {
+		t.Errorf("Unexpected sub CA certificate: %s", subCert.Subject)
+	}
+	rootCert, _ := ca.LoadCertificate(rootCertFile)
+	if subCert.NotAfter.After(rootCert.NotAfter) {
+		t.Errorf("Sub CA expires %s, after the root at %s", subCert.NotAfter, rootCert.NotAfter)
+	}
+	if _, err := os.Stat(imp.CRLFile); err != nil {
+		t.Errorf("Expected root CRL to be imported: %v", err)
+	}
+}
+
+func TestOfflineSignRejectsTamperedBundle(t *testing.T) {
+	dir := t.TempDir()
+	subProvider := newSoftwareProvider(t, filepath.Join(dir, "sub"))
+
+	subReqFile := filepath.Join(dir, "sub-csr.json")
+	os.WriteFile(subReqFile, []byte(`{"CN":"Issuing CA","names":[{"O":"PiCA Test"}],"key":{"algo":"ecdsa","size":256}}`), 0644)
+
+	requestFile := filepath.Join(dir, "request.json")
+	if err := NewOfflineRequestCommand(subReqFile, requestFile, subProvider, crypto.SlotCA2).Execute(); err != nil {
+		t.Fatalf("Failed to export request: %v", err)
+	}
+
+	// The slot holds the Sub CA key now
+	if err := NewOfflineRequestCommand(subReqFile, requestFile, subProvider, crypto.SlotCA2).Execute(); !errors.Is(err, crypto.ErrSlotInUse) {
+		t.Errorf("Expected ErrSlotInUse for an occupied slot, got %v", err)
+	}
+	replace := NewOfflineRequestCommand(subReqFile, requestFile, subProvider, crypto.SlotCA2)
+	replace.NewKey = true
+	if err := replace.Execute(); err != nil {
+		t.Fatalf("Expected --new-key to replace the key: %v", err)
+	}
+
+	bundle, err := transfer.Read(requestFile, transfer.KindSubCARequest)
+	if err != nil {
+		t.Fatalf("Failed to read bundle: %v", err)
+	}
+	bundle.Files[transfer.FileRequestJSON] = []byte(`{"CN":"Evil CA"}`)
+	transfer.Write(requestFile, bundle)
+
+	rootCA := ca.NewCA(ca.RootCA, "", "", filepath.Join(dir, "missing.pem"))
+	sign := NewOfflineSignCommand(rootCA, requestFile, filepath.Join(dir, "response.json"), subProvider, crypto.SlotCA1)
+	sign.AssumeYes = true
+	err = sign.Execute()
+	if err == nil || !strings.Contains(err.Error(), "integrity") {
+		t.Fatalf("Expected integrity failure, got %v", err)
+	}
+}
+
+func TestOfflineSignExpiry(t *testing.T) {
+	dir := t.TempDir()
+	rootProvider := newSoftwareProvider(t, filepath.Join(dir, "root"))
+	subProvider := newSoftwareProvider(t, filepath.Join(dir, "sub"))
+
+	rootCertFile := filepath.Join(dir, "root-ca.pem")
+	rootReq := &csr.CertificateRequest{
+		CN:         "Offline Root",
+		Names:      []csr.Name{{C: "US", O: "PiCA Test"}},
+		KeyRequest: &csr.KeyRequest{A: "ecdsa", S: 256},
+	}
+	if err := ca.GenerateRootCA(rootReq, rootProvider, crypto.SlotCA1, rootCertFile, 24*time.Hour); err != nil {
+		t.Fatalf("Failed to generate root CA: %v", err)
+	}
+
+	reqFile := filepath.Join(dir, "sub-csr.json")
+	os.WriteFile(reqFile, []byte(`{"CN":"Issuing CA","key":{"algo":"ecdsa","size":256},"ca":{"expiry":"2h"}}`), 0644)
+	requestFile := filepath.Join(dir, "request.json")
+	if err := NewOfflineRequestCommand(reqFile, requestFile, subProvider, crypto.SlotCA2).Execute(); err != nil {
+		t.Fatalf("Failed to export request: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		expiry time.Duration
+		want   time.Duration
+	}{
+		{"requested", 0, 2 * time.Hour},
+		{"operator override", time.Hour, time.Hour},
+		{"capped at root", 48 * time.Hour, 24 * time.Hour},
+	}
+	for i, tt := range tests {
+		responseFile := filepath.Join(dir, fmt.Sprintf("response-%d.json", i))
+		sign := NewOfflineSignCommand(ca.NewCA(ca.RootCA, "", "", rootCertFile), requestFile, responseFile, rootProvider, crypto.SlotCA1)
+		sign.AssumeYes = true
+		sign.Expiry = tt.expiry
+		if err := sign.Execute(); err != nil {
+			t.Fatalf("%s: failed to sign request: %v", tt.name, err)
+		}
+
+		certFile := filepath.Join(dir, fmt.Sprintf("sub-%d.pem", i))
+		if err := NewOfflineImportCommand(responseFile, rootCertFile, certFile, subProvider, crypto.SlotCA2).Execute(); err != nil {
+			t.Fatalf("%s: failed to import response: %v", tt.name, err)
+		}
+		cert, err := ca.LoadCertificate(certFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := time.Until(cert.NotAfter); got < tt.want-2*time.Minute || got > tt.want+2*time.Minute {
+			t.Errorf("%s: validity %s, want about %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestOfflineThreeTierHierarchy(t *testing.T) {
+	dir := t.TempDir()
+	rootProvider := newSoftwareProvider(t, filepath.Join(dir, "root"))
+	policyProvider := newSoftwareProvider(t, filepath.Join(dir, "policy"))
+	issuingProvider := newSoftwareProvider(t, filepath.Join(dir, "issuing"))
+
+	rootCertFile := filepath.Join(dir, "root-ca.pem")
+	rootReq := &csr.CertificateRequest{
+		CN:         "Offline Root",
+		Names:      []csr.Name{{C: "US", O: "PiCA Test"}},
+		KeyRequest: &csr.KeyRequest{A: "ecdsa", S: 384},
+	}
+	if err := ca.GenerateRootCA(rootReq, rootProvider, crypto.SlotCA1, rootCertFile, 24*time.Hour); err != nil {
+		t.Fatalf("Failed to generate root CA: %v", err)
+	}
+
+	// certify runs the request, sign and import steps for one CA below parent
+	certify := func(name string, provider crypto.Provider, parentCertFile string, parentProvider crypto.Provider, pathLen int) string {
+		t.Helper()
+		reqFile := filepath.Join(dir, name+"-csr.json")
+		os.WriteFile(reqFile, []byte(`{"CN":"`+name+`","names":[{"O":"PiCA Test"}],"key":{"algo":"ecdsa","size":256}}`), 0644)
+		requestFile := filepath.Join(dir, name+"-request.json")
+		if err := NewOfflineRequestCommand(reqFile, requestFile, provider, crypto.SlotCA2).Execute(); err != nil {
+			t.Fatalf("Failed to export %s request: %v", name, err)
+		}
+
+		responseFile := filepath.Join(dir, name+"-response.json")
+		sign := NewOfflineSignCommand(ca.NewCA(ca.RootCA, "", "", parentCertFile), requestFile, responseFile, parentProvider, crypto.SlotCA1)
+		if parentProvider != rootProvider {
+			sign.Slot = crypto.SlotCA2
+		}
+		sign.AssumeYes = true
+		sign.Expiry = time.Hour
+		sign.PathLen = pathLen
+		if err := sign.Execute(); err != nil {
+			t.Fatalf("Failed to sign %s request: %v", name, err)
+		}
+
+		certFile := filepath.Join(dir, name+".pem")
+		if err := NewOfflineImportCommand(responseFile, parentCertFile, certFile, provider, crypto.SlotCA2).Execute(); err != nil {
+			t.Fatalf("Failed to import %s response: %v", name, err)
+		}
+		return certFile
+	}
+
+	policyCertFile := certify("Policy CA", policyProvider, rootCertFile, rootProvider, 1)
+	issuingCertFile := certify("Issuing CA", issuingProvider, policyCertFile, policyProvider, 0)
+
+	rootCert, _ := ca.LoadCertificate(rootCertFile)
+	policyCert, _ := ca.LoadCertificate(policyCertFile)
+	issuingCert, err := ca.LoadCertificate(issuingCertFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if policyCert.MaxPathLen != 1 || issuingCert.MaxPathLen != 0 || !issuingCert.MaxPathLenZero {
+		t.Errorf("Unexpected path lengths: policy %d, issuing %d", policyCert.MaxPathLen, issuingCert.MaxPathLen)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(rootCert)
+	intermediates := x509.NewCertPool()
+	intermediates.AddCert(policyCert)
+	if _, err := issuingCert.Verify(x509.VerifyOptions{Roots: roots, Intermediates: intermediates,
+		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny}}); err != nil {
+		t.Errorf("Issuing CA does not chain to the root: %v", err)
+	}
+
+	// The issuing CA cannot certify further CAs
+	reqFile := filepath.Join(dir, "deep-csr.json")
+	os.WriteFile(reqFile, []byte(`{"CN":"Too Deep","key":{"algo":"ecdsa","size":256}}`), 0644)
+	deepProvider := newSoftwareProvider(t, filepath.Join(dir, "deep"))
+	if err := NewOfflineRequestCommand(reqFile, filepath.Join(dir, "deep.json"), deepProvider, crypto.SlotCA2).Execute(); err != nil {
+		t.Fatal(err)
+	}
+	sign := NewOfflineSignCommand(ca.NewCA(ca.RootCA, "", "", issuingCertFile), filepath.Join(dir, "deep.json"),
+		filepath.Join(dir, "deep-response.json"), issuingProvider, crypto.SlotCA2)
+	sign.AssumeYes = true
+	if err := sign.Execute(); err == nil {
+		t.Error("Expected a path length 0 CA to refuse signing a CA certificate")
+	}
+}
@@ -0,0 +1,117 @@
+package commands
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/billchurch/PiCA/internal/crypto"
+)
+
+// PIN management actions
+const (
+	PINStatus              = "status"
+	PINChange              = "change"
+	PINChangePUK           = "change-puk"
+	PINUnblock             = "unblock"
+	PINChangeManagementKey = "change-management-key"
+)
+
+// PINCommand shows and changes the PIN, PUK and management key of a
+// hardware provider
+type PINCommand struct {
+	Provider crypto.Provider
+	Action   string
+
+	// Current is the PIN, PUK or management key authorizing the change;
+	// unblocking takes the PUK. Changing the management key asks the
+	// provider's credential callback for the current key instead.
+	Current string
+	// New is the value to set
+	New string
+	Out io.Writer
+}
+
+// NewPINCommand creates a new PINCommand
+func NewPINCommand(provider crypto.Provider, action string) *PINCommand {
+	return &PINCommand{
+		Provider: provider,
+		Action:   action,
+		Out:      os.Stdout,
+	}
+}
+
+// Execute runs the PIN action
+func (cmd *PINCommand) Execute() error {
+	manager, ok := cmd.Provider.(crypto.PINManager)
+	if !ok {
+		return fmt.Errorf("%s has no PIN: %w", cmd.Provider.Name(), crypto.ErrOperationNotSupported)
+	}
+
+	var err error
+	var done string
+	switch cmd.Action {
+	case PINStatus:
+		pin, puk, err := manager.PINRetries()
+		if err != nil {
+			return fmt.Errorf("error reading PIN retries: %w", err)
+		}
+		fmt.Fprintf(cmd.Out, "Provider:          %s\n", cmd.Provider.Name())
+		fmt.Fprintf(cmd.Out, "PIN retries left:  %s\n", retries(pin))
+		fmt.Fprintf(cmd.Out, "PUK retries left:  %s\n", retries(puk))
+		return nil
+	case PINChange:
+		err = manager.ChangePIN(cmd.Current, cmd.New)
+		done = "PIN changed."
+	case PINChangePUK:
+		err = manager.ChangePUK(cmd.Current, cmd.New)
+		done = "PUK changed."
+	case PINUnblock:
+		err = manager.UnblockPIN(cmd.Current, cmd.New)
+		done = "PIN unblocked."
+	case PINChangeManagementKey:
+		err = manager.ChangeManagementKey(cmd.New)
+		done = "Management key changed."
+	default:
+		return fmt.Errorf("unknown PIN action: %s", cmd.Action)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(cmd.Out, done)
+	return nil
+}
+
+// retries formats a retry counter, which is -1 when unknown
+func retries(n int) string {
+	switch {
+	case n < 0:
+		return "unknown"
+	case n == 0:
+		return "0 (blocked)"
+	}
+	return fmt.Sprint(n)
+}
+
+// useProvider installs a credential callback on a provider and reports
+// which key storage an operation uses, with the PIN retries left on
+// hardware
+func useProvider(provider crypto.Provider, credentials crypto.CredentialCallback) {
+	if credentials != nil {
+		crypto.SetCredentials(provider, credentials)
+	}
+	if !provider.IsHardware() {
+		fmt.Println("Using software-based key storage:", provider.Name())
+		return
+	}
+
+	fmt.Println("Using hardware security module:", provider.Name())
+	if manager, ok := provider.(crypto.PINManager); ok {
+		if pin, _, err := manager.PINRetries(); err == nil && pin >= 0 {
+			fmt.Println("PIN retries left:", retries(pin))
+			if pin == 0 {
+				fmt.Println("Warning: the PIN is blocked; unblock it with 'pica pin unblock'")
+			}
+		}
+	}
+}
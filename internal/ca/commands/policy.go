@@ -0,0 +1,79 @@
+package commands
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/billchurch/PiCA/internal/ca"
+)
+
+// PolicyTestCommand evaluates a CSR against a profile's issuance policy
+// without signing it
+type PolicyTestCommand struct {
+	CA      *ca.CA
+	CSRFile string
+	Profile string
+	Out     io.Writer
+}
+
+// NewPolicyTestCommand creates a new PolicyTestCommand
+func NewPolicyTestCommand(caInstance *ca.CA, csrFile, profile string) *PolicyTestCommand {
+	return &PolicyTestCommand{
+		CA:      caInstance,
+		CSRFile: csrFile,
+		Profile: profile,
+		Out:     os.Stdout,
+	}
+}
+
+// Execute prints every rule that was checked and why the request would be
+// rejected; it returns an error if the request is not allowed
+func (cmd *PolicyTestCommand) Execute() error {
+	csrPEM, err := os.ReadFile(cmd.CSRFile)
+	if err != nil {
+		return fmt.Errorf("error reading CSR: %w", err)
+	}
+
+	result, err := cmd.CA.EvaluatePolicy(csrPEM, cmd.Profile)
+	if err != nil {
+		return fmt.Errorf("error evaluating issuance policy: %w", err)
+	}
+	PrintPolicyResult(cmd.Out, result)
+
+	if !result.Allowed {
+		return fmt.Errorf("request rejected by %d policy rule(s)", len(result.Violations))
+	}
+	return nil
+}
+
+// PrintPolicyResult writes a policy result as one line per rule
+func PrintPolicyResult(w io.Writer, result *ca.PolicyResult) {
+	profile := result.Profile
+	if profile == "" {
+		profile = "default"
+	}
+	if len(result.Checked) == 0 {
+		fmt.Fprintf(w, "Profile %s has no issuance policy\n", profile)
+	}
+
+	failed := make(map[string]bool)
+	for _, v := range result.Violations {
+		failed[v.Rule] = true
+	}
+	for _, rule := range result.Checked {
+		if failed[rule] {
+			continue
+		}
+		fmt.Fprintf(w, "  PASS  %s\n", rule)
+	}
+	for _, v := range result.Violations {
+		fmt.Fprintf(w, "  FAIL  %s: %s\n", v.Rule, v.Message)
+	}
+
+	if result.Allowed {
+		fmt.Fprintf(w, "Request is allowed by profile %s\n", profile)
+	} else {
+		fmt.Fprintf(w, "Request is rejected by profile %s\n", profile)
+	}
+}
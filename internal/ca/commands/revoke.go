@@ -14,6 +14,10 @@ type RevokeCommand struct {
 	Reason       string
 	Slot         crypto.Slot
 	Provider     crypto.Provider
+
+	// Credentials supplies the PIN to hardware providers; unset, they use
+	// their default (environment variables)
+	Credentials crypto.CredentialCallback
 }
 
 // NewRevokeCommand creates a new RevokeCommand with default provider
@@ -49,18 +53,11 @@ func (cmd *RevokeCommand) Execute() error {
 		}
 	}
 
-	if cmd.CA.Provider.IsHardware() {
-		fmt.Println("Ready to revoke certificate with serial number:", cmd.SerialNumber)
-		fmt.Println("Using hardware security module:", cmd.CA.Provider.Name())
-		fmt.Println("Please ensure your security device is inserted and press Enter to continue...")
-		fmt.Scanln()
-	} else {
-		fmt.Println("Ready to revoke certificate with serial number:", cmd.SerialNumber)
-		fmt.Println("Using software-based key storage:", cmd.CA.Provider.Name())
-	}
+	fmt.Println("Ready to revoke certificate with serial number:", cmd.SerialNumber)
+	useProvider(cmd.CA.Provider, cmd.Credentials)
 
 	// Revoke the certificate
-	err := cmd.CA.RevokeCertificate(cmd.SerialNumber)
+	err := cmd.CA.RevokeCertificateWithReason(cmd.SerialNumber, cmd.Reason)
 	if err != nil {
 		return fmt.Errorf("error revoking certificate: %w", err)
 	}
@@ -0,0 +1,205 @@
+package commands
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/billchurch/PiCA/internal/ca"
+	"github.com/billchurch/PiCA/internal/crypto"
+)
+
+// RenewCommand re-issues a CA certificate for the same key
+type RenewCommand struct {
+	CA       *ca.CA
+	Expiry   time.Duration
+	Slot     crypto.Slot
+	Provider crypto.Provider
+	// Parent signs the renewed certificate of a subordinate CA
+	Parent *ca.CAKey
+}
+
+// NewRenewCommand creates a new RenewCommand
+func NewRenewCommand(caInstance *ca.CA, expiry time.Duration, provider crypto.Provider, slot crypto.Slot) *RenewCommand {
+	return &RenewCommand{
+		CA:       caInstance,
+		Expiry:   expiry,
+		Provider: provider,
+		Slot:     slot,
+	}
+}
+
+// Execute renews the CA certificate
+func (cmd *RenewCommand) Execute() error {
+	cmd.CA.Provider = cmd.Provider
+	cmd.CA.Slot = cmd.Slot
+
+	fmt.Println("Renewing CA certificate with its current key")
+	fmt.Println("Using key storage:", cmd.Provider.Name())
+
+	cert, err := cmd.CA.RenewCertificate(cmd.Expiry, cmd.Parent)
+	if err != nil {
+		return fmt.Errorf("error renewing CA certificate: %w", err)
+	}
+
+	fmt.Printf("Renewed certificate %X valid until %s is now active\n",
+		cert.SerialNumber, cert.NotAfter.Format("2006-01-02"))
+	return nil
+}
+
+// RekeyCommand moves a CA to a new key in another slot
+type RekeyCommand struct {
+	CA        *ca.CA
+	Expiry    time.Duration
+	Algorithm string
+	Bits      int
+	NewSlot   crypto.Slot
+	// Link cross-signs the old and new root certificates
+	Link     bool
+	Slot     crypto.Slot
+	Provider crypto.Provider
+	// Parent signs the new certificate of a subordinate CA
+	Parent *ca.CAKey
+}
+
+// NewRekeyCommand creates a new RekeyCommand producing link certificates
+func NewRekeyCommand(caInstance *ca.CA, newSlot crypto.Slot, expiry time.Duration, provider crypto.Provider, slot crypto.Slot) *RekeyCommand {
+	return &RekeyCommand{
+		CA:        caInstance,
+		Expiry:    expiry,
+		Algorithm: "ECDSA",
+		Bits:      384,
+		NewSlot:   newSlot,
+		Link:      true,
+		Provider:  provider,
+		Slot:      slot,
+	}
+}
+
+// Execute rekeys the CA
+func (cmd *RekeyCommand) Execute() error {
+	cmd.CA.Provider = cmd.Provider
+	cmd.CA.Slot = cmd.Slot
+
+	fmt.Printf("Generating new %s key (size/curve %d) in slot %X\n", cmd.Algorithm, cmd.Bits, int(cmd.NewSlot))
+	fmt.Println("Using key storage:", cmd.Provider.Name())
+
+	cert, err := cmd.CA.Rekey(cmd.NewSlot, cmd.Algorithm, cmd.Bits, cmd.Expiry, cmd.Parent, cmd.Link)
+	if err != nil {
+		return fmt.Errorf("error rekeying CA: %w", err)
+	}
+
+	fmt.Printf("New certificate %X valid until %s is now active\n",
+		cert.SerialNumber, cert.NotAfter.Format("2006-01-02"))
+	fmt.Println("Distribute the new certificate (and link certificates) before retiring the old one")
+	return nil
+}
+
+// CrossSignCommand signs another CA's certificate with this CA's key,
+// producing a link certificate
+type CrossSignCommand struct {
+	CA          *ca.CA
+	SubjectFile string
+	OutFile     string
+	Expiry      time.Duration
+	Slot        crypto.Slot
+	Provider    crypto.Provider
+}
+
+// NewCrossSignCommand creates a new CrossSignCommand
+func NewCrossSignCommand(caInstance *ca.CA, subjectFile, outFile string, expiry time.Duration, provider crypto.Provider, slot crypto.Slot) *CrossSignCommand {
+	return &CrossSignCommand{
+		CA:          caInstance,
+		SubjectFile: subjectFile,
+		OutFile:     outFile,
+		Expiry:      expiry,
+		Provider:    provider,
+		Slot:        slot,
+	}
+}
+
+// Execute cross-signs the subject certificate
+func (cmd *CrossSignCommand) Execute() error {
+	cmd.CA.Provider = cmd.Provider
+	cmd.CA.Slot = cmd.Slot
+
+	subject, err := ca.LoadCertificate(cmd.SubjectFile)
+	if err != nil {
+		return fmt.Errorf("error loading certificate to cross-sign: %w", err)
+	}
+	issuerCert, slot, err := cmd.CA.ActiveIssuer()
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Cross-signing %q with %q\n", subject.Subject.CommonName, issuerCert.Subject.CommonName)
+	link, err := ca.CrossSign(subject, &ca.CAKey{Provider: cmd.Provider, Slot: slot, Certificate: issuerCert}, cmd.Expiry)
+	if err != nil {
+		return fmt.Errorf("error cross-signing certificate: %w", err)
+	}
+	if err := ca.WriteCertificateFile(cmd.OutFile, link); err != nil {
+		return err
+	}
+
+	fmt.Println("Link certificate saved to:", cmd.OutFile)
+	fmt.Printf("Valid until %s\n", link.NotAfter.Format("2006-01-02"))
+	return nil
+}
+
+// PrintCertificateSet writes the certificates and link certificates of a CA,
+// marking the active one
+func PrintCertificateSet(w io.Writer, caInstance *ca.CA) error {
+	set, err := caInstance.LoadCertificateSet()
+	if err != nil {
+		return err
+	}
+	issuers, err := set.Issuers()
+	if err != nil {
+		return err
+	}
+	active, _ := set.Active(time.Now())
+
+	for _, issuer := range issuers {
+		marker := " "
+		if active != nil && issuer.Certificate.Equal(active.Certificate) {
+			marker = "*"
+		}
+		cert := issuer.Certificate
+		fmt.Fprintf(w, "%s %X  slot %X  %s - %s  %s\n", marker, cert.SerialNumber, int(issuer.Slot),
+			cert.NotBefore.Format("2006-01-02"), cert.NotAfter.Format("2006-01-02"), issuer.File)
+	}
+
+	links, err := set.LinkCertificates()
+	if err != nil {
+		return err
+	}
+	for i, link := range links {
+		fmt.Fprintf(w, "  link %X  %s, signed by key %X, until %s  %s\n", link.SerialNumber,
+			link.Subject.CommonName, link.AuthorityKeyId, link.NotAfter.Format("2006-01-02"), set.Links[i])
+	}
+	return nil
+}
+
+// WriteChains writes every issuing chain of the CA as one PEM bundle per
+// chain, named after the first certificate's serial number
+func WriteChains(caInstance *ca.CA, dir string) ([]string, error) {
+	chains, err := caInstance.Chains()
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("error creating chain directory: %w", err)
+	}
+
+	var files []string
+	for i, chain := range chains {
+		file := filepath.Join(dir, fmt.Sprintf("chain-%d-%X.pem", i, chain[0].SerialNumber))
+		if err := os.WriteFile(file, ca.EncodeChain(chain), 0644); err != nil {
+			return nil, fmt.Errorf("error writing chain: %w", err)
+		}
+		files = append(files, file)
+	}
+	return files, nil
+}
@@ -18,6 +18,18 @@ type SignCommand struct {
 	Profile  string
 	Slot     crypto.Slot
 	Provider crypto.Provider
+
+	// Credentials supplies the PIN to hardware providers; unset, they use
+	// their default (environment variables)
+	Credentials crypto.CredentialCallback
+
+	// Attestation is an optional PEM key attestation of the CSR's key,
+	// verified and recorded with the certificate
+	Attestation []byte
+
+	// LintReport holds the zlint findings after Execute, also when
+	// linting stopped issuance
+	LintReport *ca.LintReport
 }
 
 // NewSignCommand creates a new SignCommand with default provider
@@ -85,18 +97,16 @@ func (cmd *SignCommand) Execute() error {
 		}
 	}
 
-	if cmd.CA.Provider.IsHardware() {
-		fmt.Println("Ready to sign certificate.")
-		fmt.Println("Using hardware security module:", cmd.CA.Provider.Name())
-		fmt.Println("Please ensure your security device is inserted and press Enter to continue...")
-		fmt.Scanln()
-	} else {
-		fmt.Println("Ready to sign certificate.")
-		fmt.Println("Using software-based key storage:", cmd.CA.Provider.Name())
-	}
+	fmt.Println("Ready to sign certificate.")
+	useProvider(cmd.CA.Provider, cmd.Credentials)
 
 	// Sign the certificate
-	certPEM, err := cmd.CA.SignCertificate(csrBytes, cmd.Profile)
+	certPEM, report, err := cmd.CA.SignCertificateWithOptions(csrBytes, cmd.Profile,
+		&ca.SignOptions{Attestation: cmd.Attestation})
+	cmd.LintReport = report
+	if report != nil {
+		report.Print(os.Stdout)
+	}
 	if err != nil {
 		return fmt.Errorf("error signing certificate: %w", err)
 	}
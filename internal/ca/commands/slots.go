@@ -0,0 +1,147 @@
+package commands
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	"github.com/billchurch/PiCA/internal/crypto"
+)
+
+// Slot inventory actions
+const (
+	SlotsList   = "list"
+	SlotsDelete = "delete"
+)
+
+// SlotsCommand lists the keys and certificates held by a provider and
+// deletes keys that are no longer needed
+type SlotsCommand struct {
+	Provider crypto.Provider
+	Action   string
+	// Slot is the slot to delete
+	Slot crypto.Slot
+
+	// AssumeYes deletes without asking for confirmation on Input
+	AssumeYes bool
+	Input     io.Reader
+	Out       io.Writer
+
+	// Slots holds the inventory after listing
+	Slots []crypto.SlotInfo
+}
+
+// NewSlotsCommand creates a new SlotsCommand
+func NewSlotsCommand(provider crypto.Provider, action string) *SlotsCommand {
+	return &SlotsCommand{
+		Provider: provider,
+		Action:   action,
+		Input:    os.Stdin,
+		Out:      os.Stdout,
+	}
+}
+
+// Execute runs the inventory action
+func (cmd *SlotsCommand) Execute() error {
+	switch cmd.Action {
+	case SlotsList:
+		slots, err := cmd.Provider.ListSlots()
+		if err != nil {
+			return fmt.Errorf("error listing slots: %w", err)
+		}
+		cmd.Slots = slots
+		fmt.Fprintf(cmd.Out, "Provider: %s\n\n", cmd.Provider.Name())
+		PrintSlots(cmd.Out, slots)
+		return nil
+
+	case SlotsDelete:
+		slots, err := cmd.Provider.ListSlots()
+		if err != nil {
+			return fmt.Errorf("error listing slots: %w", err)
+		}
+		var info *crypto.SlotInfo
+		for i := range slots {
+			if slots[i].Slot == cmd.Slot {
+				info = &slots[i]
+			}
+		}
+		if info == nil {
+			return fmt.Errorf("slot %X of %s: %w", int(cmd.Slot), cmd.Provider.Name(), crypto.ErrKeyNotFound)
+		}
+
+		question := fmt.Sprintf("Delete the key and certificate in slot %X? This cannot be undone.", int(cmd.Slot))
+		if cert := info.Certificate; cert != nil && cert.IsCA {
+			question = fmt.Sprintf("Slot %X holds the key of CA %q. Delete it? This cannot be undone.",
+				int(cmd.Slot), cert.Subject.CommonName)
+		}
+		if !cmd.AssumeYes && !confirm(cmd.Input, question) {
+			return fmt.Errorf("deletion cancelled")
+		}
+
+		if err := cmd.Provider.DeleteKey(cmd.Slot); err != nil {
+			return fmt.Errorf("error deleting slot %X: %w", int(cmd.Slot), err)
+		}
+		fmt.Fprintf(cmd.Out, "Deleted the contents of slot %X\n", int(cmd.Slot))
+		return nil
+	}
+	return fmt.Errorf("unknown slots action: %s", cmd.Action)
+}
+
+// PrintSlots writes a slot inventory as a table
+func PrintSlots(w io.Writer, slots []crypto.SlotInfo) {
+	if len(slots) == 0 {
+		fmt.Fprintln(w, "No keys or certificates")
+		return
+	}
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "SLOT\tKEY\tCREATED\tORIGIN\tPIN\tTOUCH\tCERTIFICATE")
+	for _, s := range slots {
+		fmt.Fprintf(tw, "%X\t%s\t%s\t%s\t%s\t%s\t%s\n", int(s.Slot), SlotKey(s), orDash(slotCreated(s)),
+			orDash(s.Origin), orDash(s.PINPolicy), orDash(s.TouchPolicy), orDash(SlotCertificate(s)))
+	}
+	tw.Flush()
+}
+
+// SlotKey describes the key of a slot, e.g. "ECDSA 384"
+func SlotKey(s crypto.SlotInfo) string {
+	switch {
+	case !s.HasKey:
+		return "none"
+	case s.Algorithm == "":
+		return "unknown"
+	}
+	return fmt.Sprintf("%s %d", s.Algorithm, s.Bits)
+}
+
+// SlotCertificate describes the certificate of a slot by its subject,
+// marking CA certificates
+func SlotCertificate(s crypto.SlotInfo) string {
+	if s.Certificate == nil {
+		return ""
+	}
+	name := s.Certificate.Subject.CommonName
+	if name == "" {
+		name = s.Certificate.Subject.String()
+	}
+	if s.Certificate.IsCA {
+		return name + " (CA)"
+	}
+	return name
+}
+
+// slotCreated formats the creation time of a slot's key
+func slotCreated(s crypto.SlotInfo) string {
+	if s.Created.IsZero() {
+		return ""
+	}
+	return s.Created.Format("2006-01-02 15:04")
+}
+
+// orDash shows missing values as a dash
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
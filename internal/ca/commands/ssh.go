@@ -0,0 +1,146 @@
+package commands
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/billchurch/PiCA/internal/ca"
+)
+
+// SSH CA actions
+const (
+	SSHInit   = "init"
+	SSHSign   = "sign"
+	SSHList   = "list"
+	SSHRevoke = "revoke"
+	SSHKRL    = "krl"
+	SSHPubKey = "pubkey"
+)
+
+// SSHCommand issues and revokes OpenSSH certificates
+type SSHCommand struct {
+	SSHCA  *ca.SSHCA
+	Action string
+
+	// Request is the certificate to issue (sign)
+	Request *ca.SSHRequest
+	// Serial and Reason identify the certificate to revoke (revoke)
+	Serial string
+	Reason string
+	// Algorithm and Bits describe the CA key to create (init)
+	Algorithm string
+	Bits      int
+	// File receives the certificate (sign) or the KRL (krl); stdout when
+	// empty
+	File string
+	Out  io.Writer
+}
+
+// NewSSHCommand creates a new SSHCommand
+func NewSSHCommand(sshCA *ca.SSHCA, action string) *SSHCommand {
+	return &SSHCommand{
+		SSHCA:     sshCA,
+		Action:    action,
+		Algorithm: "ECDSA",
+		Bits:      256,
+		Out:       os.Stdout,
+	}
+}
+
+// Execute runs the SSH action
+func (cmd *SSHCommand) Execute() error {
+	switch cmd.Action {
+	case SSHInit:
+		key, err := cmd.SSHCA.GenerateKey(cmd.Algorithm, cmd.Bits)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(cmd.Out, "Generated SSH CA key in slot %X\n", int(cmd.SSHCA.Slot))
+		_, err = cmd.Out.Write(ssh.MarshalAuthorizedKey(key))
+		return err
+
+	case SSHPubKey:
+		key, err := cmd.SSHCA.PublicKey()
+		if err != nil {
+			return err
+		}
+		_, err = cmd.Out.Write(ssh.MarshalAuthorizedKey(key))
+		return err
+
+	case SSHSign:
+		if cmd.Request == nil {
+			return fmt.Errorf("no certificate request given")
+		}
+		cert, err := cmd.SSHCA.Sign(cmd.Request)
+		if err != nil {
+			return err
+		}
+		data := ssh.MarshalAuthorizedKey(cert)
+		if cmd.File == "" {
+			_, err = cmd.Out.Write(data)
+			return err
+		}
+		if err := os.WriteFile(cmd.File, data, 0644); err != nil {
+			return fmt.Errorf("error writing certificate: %w", err)
+		}
+		fmt.Fprintf(cmd.Out, "Issued certificate %s for %s to %s\n",
+			ca.FormatSSHSerial(cert.Serial), strings.Join(cert.ValidPrincipals, ", "), cmd.File)
+		return nil
+
+	case SSHList:
+		store, err := cmd.SSHCA.Store()
+		if err != nil {
+			return err
+		}
+		PrintSSHCertificates(cmd.Out, store.List())
+		return nil
+
+	case SSHRevoke:
+		if cmd.Serial == "" {
+			return fmt.Errorf("a serial number is required")
+		}
+		record, err := cmd.SSHCA.Revoke(cmd.Serial, cmd.Reason)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(cmd.Out, "Revoked SSH certificate %s (%s)\n", record.SerialNumber, record.Subject)
+		return nil
+
+	case SSHKRL:
+		data, err := cmd.SSHCA.KRL()
+		if err != nil {
+			return err
+		}
+		if cmd.File == "" {
+			_, err = cmd.Out.Write(data)
+			return err
+		}
+		if err := os.WriteFile(cmd.File, data, 0644); err != nil {
+			return fmt.Errorf("error writing KRL: %w", err)
+		}
+		fmt.Fprintf(cmd.Out, "Wrote KRL to %s\n", cmd.File)
+		return nil
+	}
+
+	return fmt.Errorf("unknown SSH action %q", cmd.Action)
+}
+
+// PrintSSHCertificates writes a table of SSH certificate records
+func PrintSSHCertificates(w io.Writer, records []*ca.CertificateRecord) {
+	if len(records) == 0 {
+		fmt.Fprintln(w, "No SSH certificates issued")
+		return
+	}
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "SERIAL\tTYPE\tKEY ID\tPRINCIPALS\tEXPIRES\tSTATUS")
+	for _, r := range records {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", r.SerialNumber, r.Metadata["type"], r.Subject,
+			r.Metadata["principals"], r.NotAfter.Format("2006-01-02 15:04"), r.Status)
+	}
+	tw.Flush()
+}
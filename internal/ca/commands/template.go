@@ -0,0 +1,201 @@
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/billchurch/PiCA/internal/ca"
+)
+
+// Template actions
+const (
+	TemplateList     = "list"
+	TemplateShow     = "show"
+	TemplateSet      = "set"
+	TemplateDelete   = "delete"
+	TemplateHistory  = "history"
+	TemplateRollback = "rollback"
+	TemplateExport   = "export"
+	TemplateImport   = "import"
+)
+
+// TemplateCommand manages the certificate templates of a CA
+type TemplateCommand struct {
+	CA     *ca.CA
+	Action string
+	Name   string
+
+	// Template is the template to save (set)
+	Template *ca.Template
+	// Version is the version to show or restore (show, rollback)
+	Version int
+	// File is the cfssl config to read (import) or write (export); stdout
+	// when empty
+	File    string
+	Comment string
+	Out     io.Writer
+}
+
+// NewTemplateCommand creates a new TemplateCommand
+func NewTemplateCommand(caInstance *ca.CA, action, name string) *TemplateCommand {
+	return &TemplateCommand{
+		CA:     caInstance,
+		Action: action,
+		Name:   name,
+		Out:    os.Stdout,
+	}
+}
+
+// Execute runs the template action. Changes are applied to the CA's cfssl
+// config right away.
+func (cmd *TemplateCommand) Execute() error {
+	store, err := cmd.CA.Templates()
+	if err != nil {
+		return err
+	}
+	if cmd.Name == "" && cmd.Action != TemplateList && cmd.Action != TemplateExport &&
+		cmd.Action != TemplateImport && cmd.Action != TemplateSet {
+		return fmt.Errorf("a template name is required")
+	}
+
+	switch cmd.Action {
+	case TemplateList:
+		templates, err := store.List()
+		if err != nil {
+			return err
+		}
+		PrintTemplates(cmd.Out, templates)
+		return nil
+
+	case TemplateShow:
+		var t *ca.Template
+		if cmd.Version > 0 {
+			t, err = store.Version(cmd.Name, cmd.Version)
+		} else {
+			t, err = store.Get(cmd.Name)
+		}
+		if err != nil {
+			return err
+		}
+		return printJSON(cmd.Out, t)
+
+	case TemplateSet:
+		if cmd.Template == nil {
+			return fmt.Errorf("no template given")
+		}
+		if cmd.Comment != "" {
+			cmd.Template.Comment = cmd.Comment
+		}
+		saved, err := store.Save(cmd.Template)
+		if err != nil {
+			return err
+		}
+		if err := cmd.CA.ApplyTemplates(); err != nil {
+			return err
+		}
+		fmt.Fprintf(cmd.Out, "Saved template %s version %d\n", saved.Name, saved.Version)
+		return nil
+
+	case TemplateDelete:
+		if err := store.Delete(cmd.Name); err != nil {
+			return err
+		}
+		if err := cmd.CA.ApplyTemplates(); err != nil {
+			return err
+		}
+		fmt.Fprintf(cmd.Out, "Deleted template %s\n", cmd.Name)
+		return nil
+
+	case TemplateHistory:
+		versions, err := store.Versions(cmd.Name)
+		if err != nil {
+			return err
+		}
+		tw := tabwriter.NewWriter(cmd.Out, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "VERSION\tUPDATED\tVALIDITY\tCOMMENT")
+		for _, t := range versions {
+			fmt.Fprintf(tw, "%d\t%s\t%s\t%s\n", t.Version, t.Updated.Format("2006-01-02 15:04:05"), t.Validity, t.Comment)
+		}
+		return tw.Flush()
+
+	case TemplateRollback:
+		if cmd.Version <= 0 {
+			return fmt.Errorf("a version to roll back to is required")
+		}
+		saved, err := store.Rollback(cmd.Name, cmd.Version)
+		if err != nil {
+			return err
+		}
+		if err := cmd.CA.ApplyTemplates(); err != nil {
+			return err
+		}
+		fmt.Fprintf(cmd.Out, "Restored version %d of template %s as version %d\n", cmd.Version, saved.Name, saved.Version)
+		return nil
+
+	case TemplateExport:
+		data, err := cmd.CA.ExportTemplates()
+		if err != nil {
+			return err
+		}
+		if cmd.File == "" {
+			_, err = cmd.Out.Write(append(data, '\n'))
+			return err
+		}
+		if err := os.WriteFile(cmd.File, data, 0644); err != nil {
+			return fmt.Errorf("error writing config: %w", err)
+		}
+		fmt.Fprintf(cmd.Out, "Exported templates to %s\n", cmd.File)
+		return nil
+
+	case TemplateImport:
+		if cmd.File == "" {
+			return fmt.Errorf("a cfssl config file to import is required")
+		}
+		data, err := os.ReadFile(cmd.File)
+		if err != nil {
+			return fmt.Errorf("error reading config: %w", err)
+		}
+		comment := cmd.Comment
+		if comment == "" {
+			comment = "imported from " + cmd.File
+		}
+		saved, err := cmd.CA.ImportTemplates(data, comment)
+		if err != nil {
+			return err
+		}
+		for _, t := range saved {
+			fmt.Fprintf(cmd.Out, "Imported template %s version %d\n", t.Name, t.Version)
+		}
+		return nil
+	}
+
+	return fmt.Errorf("unknown template action %q", cmd.Action)
+}
+
+// PrintTemplates writes a table of templates
+func PrintTemplates(w io.Writer, templates []*ca.Template) {
+	if len(templates) == 0 {
+		fmt.Fprintln(w, "No templates defined")
+		return
+	}
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tVERSION\tVALIDITY\tUSAGES\tDESCRIPTION")
+	for _, t := range templates {
+		usages := append(append([]string{}, t.KeyUsages...), t.ExtKeyUsages...)
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%s\t%s\n", t.Name, t.Version, t.Validity, strings.Join(usages, ", "), t.Description)
+	}
+	tw.Flush()
+}
+
+func printJSON(w io.Writer, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s\n", data)
+	return err
+}
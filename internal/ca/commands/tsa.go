@@ -0,0 +1,106 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	"github.com/cloudflare/cfssl/csr"
+
+	"github.com/billchurch/PiCA/internal/ca"
+	"github.com/billchurch/PiCA/internal/crypto"
+)
+
+// Time-stamping authority actions
+const (
+	TSAInit = "init"
+	TSAList = "list"
+)
+
+// TSACommand sets up the time-stamping authority and lists its tokens
+type TSACommand struct {
+	CA     *ca.CA
+	Action string
+
+	// Provider and Slot hold the TSA key (init)
+	Provider crypto.Provider
+	Slot     crypto.Slot
+	// Request is the subject of the TSA certificate (init)
+	Request *csr.CertificateRequest
+	// GenerateKey replaces a key already in the slot (init)
+	GenerateKey bool
+	// CertFile receives the TSA certificate and its chain (init)
+	CertFile string
+	// DatabaseDir holds the token log (list)
+	DatabaseDir string
+	Out         io.Writer
+}
+
+// NewTSACommand creates a new TSACommand
+func NewTSACommand(caInstance *ca.CA, action string) *TSACommand {
+	return &TSACommand{
+		CA:     caInstance,
+		Action: action,
+		Slot:   crypto.SlotTSA,
+		Out:    os.Stdout,
+	}
+}
+
+// Execute runs the TSA action
+func (cmd *TSACommand) Execute() error {
+	switch cmd.Action {
+	case TSAInit:
+		if cmd.Request == nil || cmd.CertFile == "" {
+			return fmt.Errorf("a subject and a certificate file are required")
+		}
+
+		// Only create a key when asked to or when the slot is empty, so a
+		// certified TSA key is never replaced by accident
+		generate := cmd.GenerateKey
+		if !generate {
+			if _, err := cmd.Provider.GetPublicKey(cmd.Slot); err != nil {
+				if !errors.Is(err, crypto.ErrKeyNotFound) {
+					return fmt.Errorf("failed to read key from slot: %w", err)
+				}
+				generate = true
+			}
+		}
+
+		cert, err := cmd.CA.IssueTimeStampingCertificate(cmd.Request, cmd.Provider, cmd.Slot, cmd.CertFile, generate)
+		if err != nil {
+			return err
+		}
+		if generate {
+			fmt.Fprintf(cmd.Out, "Generated TSA key in slot %X\n", int(cmd.Slot))
+		}
+		fmt.Fprintf(cmd.Out, "Issued TSA certificate %X (%s) to %s\n", cert.SerialNumber, cert.Subject.CommonName, cmd.CertFile)
+		return nil
+
+	case TSAList:
+		tokens, err := ca.TimeStampTokens(cmd.DatabaseDir)
+		if err != nil {
+			return err
+		}
+		PrintTimeStampTokens(cmd.Out, tokens)
+		return nil
+	}
+
+	return fmt.Errorf("unknown TSA action %q", cmd.Action)
+}
+
+// PrintTimeStampTokens writes a table of issued time-stamp tokens
+func PrintTimeStampTokens(w io.Writer, tokens []*ca.TimeStampToken) {
+	if len(tokens) == 0 {
+		fmt.Fprintln(w, "No time-stamp tokens issued")
+		return
+	}
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "SERIAL\tTIME\tHASH\tDIGEST\tCLIENT")
+	for _, t := range tokens {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", t.SerialNumber, t.GenTime.Format("2006-01-02 15:04:05"),
+			t.HashAlgorithm, t.HashedMessage, t.Client)
+	}
+	tw.Flush()
+}
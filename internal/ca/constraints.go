@@ -0,0 +1,589 @@
+package ca
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+var (
+	oidExtensionNameConstraints     = asn1.ObjectIdentifier{2, 5, 29, 30}
+	oidExtensionCertificatePolicies = asn1.ObjectIdentifier{2, 5, 29, 32}
+	oidExtensionPolicyConstraints   = asn1.ObjectIdentifier{2, 5, 29, 36}
+	oidExtensionInhibitAnyPolicy    = asn1.ObjectIdentifier{2, 5, 29, 54}
+)
+
+// GeneralName tags used in name constraints
+const (
+	nameTagEmail = 1
+	nameTagDNS   = 2
+	nameTagURI   = 6
+	nameTagIP    = 7
+)
+
+// CAConstraints restricts what a subordinate CA may certify: the names it
+// may issue for (RFC 5280 name constraints) and how certificate policies
+// are processed below it (policy constraints and inhibit anyPolicy)
+type CAConstraints struct {
+	PermittedDNS   []string `json:"permitted_dns,omitempty"`
+	ExcludedDNS    []string `json:"excluded_dns,omitempty"`
+	PermittedIP    []string `json:"permitted_ip,omitempty"`
+	ExcludedIP     []string `json:"excluded_ip,omitempty"`
+	PermittedEmail []string `json:"permitted_email,omitempty"`
+	ExcludedEmail  []string `json:"excluded_email,omitempty"`
+	PermittedURI   []string `json:"permitted_uri,omitempty"`
+	ExcludedURI    []string `json:"excluded_uri,omitempty"`
+
+	// Policies are the certificate policy OIDs asserted for the CA
+	Policies []string `json:"policies,omitempty"`
+	// RequireExplicitPolicy and InhibitPolicyMapping are skip counts for
+	// the policy constraints extension
+	RequireExplicitPolicy *int `json:"require_explicit_policy,omitempty"`
+	InhibitPolicyMapping  *int `json:"inhibit_policy_mapping,omitempty"`
+	// InhibitAnyPolicy is the skip count after which anyPolicy stops
+	// matching other policies
+	InhibitAnyPolicy *int `json:"inhibit_any_policy,omitempty"`
+}
+
+// hasNames reports whether any name constraint is set
+func (c *CAConstraints) hasNames() bool {
+	return len(c.PermittedDNS)+len(c.ExcludedDNS)+len(c.PermittedIP)+len(c.ExcludedIP)+
+		len(c.PermittedEmail)+len(c.ExcludedEmail)+len(c.PermittedURI)+len(c.ExcludedURI) > 0
+}
+
+// Validate checks the constraint values
+func (c *CAConstraints) Validate() error {
+	if _, err := parseCIDRs(c.PermittedIP); err != nil {
+		return err
+	}
+	if _, err := parseCIDRs(c.ExcludedIP); err != nil {
+		return err
+	}
+	if _, err := parseOIDs(c.Policies); err != nil {
+		return err
+	}
+	for name, v := range map[string]*int{
+		"require_explicit_policy": c.RequireExplicitPolicy,
+		"inhibit_policy_mapping":  c.InhibitPolicyMapping,
+		"inhibit_any_policy":      c.InhibitAnyPolicy,
+	} {
+		if v != nil && *v < 0 {
+			return fmt.Errorf("%s must not be negative", name)
+		}
+	}
+	return nil
+}
+
+// Apply adds the constraints to a CA certificate template
+func (c *CAConstraints) Apply(template *x509.Certificate) error {
+	if err := c.Validate(); err != nil {
+		return err
+	}
+
+	if c.hasNames() {
+		template.PermittedDNSDomainsCritical = true
+		template.PermittedDNSDomains = c.PermittedDNS
+		template.ExcludedDNSDomains = c.ExcludedDNS
+		template.PermittedIPRanges, _ = parseCIDRs(c.PermittedIP)
+		template.ExcludedIPRanges, _ = parseCIDRs(c.ExcludedIP)
+		template.PermittedEmailAddresses = c.PermittedEmail
+		template.ExcludedEmailAddresses = c.ExcludedEmail
+		template.PermittedURIDomains = c.PermittedURI
+		template.ExcludedURIDomains = c.ExcludedURI
+	}
+
+	policies, _ := parseOIDs(c.Policies)
+	template.PolicyIdentifiers = append(template.PolicyIdentifiers, policies...)
+
+	exts, err := c.policyExtensions()
+	if err != nil {
+		return err
+	}
+	template.ExtraExtensions = append(template.ExtraExtensions, exts...)
+	return nil
+}
+
+// Extensions encodes the constraints as extensions for a certificate
+// request, to be picked up by the signing CA
+func (c *CAConstraints) Extensions() ([]pkix.Extension, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+
+	var exts []pkix.Extension
+	if c.hasNames() {
+		value, err := c.marshalNameConstraints()
+		if err != nil {
+			return nil, err
+		}
+		exts = append(exts, pkix.Extension{Id: oidExtensionNameConstraints, Critical: true, Value: value})
+	}
+	if len(c.Policies) > 0 {
+		oids, _ := parseOIDs(c.Policies)
+		type policyInformation struct {
+			Policy asn1.ObjectIdentifier
+		}
+		var policies []policyInformation
+		for _, oid := range oids {
+			policies = append(policies, policyInformation{Policy: oid})
+		}
+		value, err := asn1.Marshal(policies)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode certificate policies: %w", err)
+		}
+		exts = append(exts, pkix.Extension{Id: oidExtensionCertificatePolicies, Value: value})
+	}
+
+	policyExts, err := c.policyExtensions()
+	if err != nil {
+		return nil, err
+	}
+	return append(exts, policyExts...), nil
+}
+
+// policyExtensions encodes the policy constraints and inhibit anyPolicy
+// extensions, which crypto/x509 does not support natively
+func (c *CAConstraints) policyExtensions() ([]pkix.Extension, error) {
+	var exts []pkix.Extension
+
+	if c.RequireExplicitPolicy != nil || c.InhibitPolicyMapping != nil {
+		var fields []byte
+		for tag, v := range []*int{c.RequireExplicitPolicy, c.InhibitPolicyMapping} {
+			if v == nil {
+				continue
+			}
+			field, err := marshalImplicitInt(tag, *v)
+			if err != nil {
+				return nil, err
+			}
+			fields = append(fields, field...)
+		}
+		value, err := asn1.Marshal(asn1.RawValue{Tag: asn1.TagSequence, IsCompound: true, Bytes: fields})
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode policy constraints: %w", err)
+		}
+		exts = append(exts, pkix.Extension{Id: oidExtensionPolicyConstraints, Critical: true, Value: value})
+	}
+
+	if c.InhibitAnyPolicy != nil {
+		value, err := asn1.Marshal(*c.InhibitAnyPolicy)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode inhibit anyPolicy: %w", err)
+		}
+		exts = append(exts, pkix.Extension{Id: oidExtensionInhibitAnyPolicy, Critical: true, Value: value})
+	}
+	return exts, nil
+}
+
+// marshalNameConstraints encodes the NameConstraints extension value
+func (c *CAConstraints) marshalNameConstraints() ([]byte, error) {
+	subtrees := func(tag int, dns, ips, emails, uris []string) ([]byte, error) {
+		var names []asn1.RawValue
+		for _, name := range dns {
+			names = append(names, asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: nameTagDNS, Bytes: []byte(name)})
+		}
+		nets, err := parseCIDRs(ips)
+		if err != nil {
+			return nil, err
+		}
+		for _, n := range nets {
+			ip := n.IP
+			if ip4 := ip.To4(); ip4 != nil {
+				ip = ip4
+			}
+			names = append(names, asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: nameTagIP,
+				Bytes: append(append([]byte{}, ip...), n.Mask...)})
+		}
+		for _, email := range emails {
+			names = append(names, asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: nameTagEmail, Bytes: []byte(email)})
+		}
+		for _, uri := range uris {
+			names = append(names, asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: nameTagURI, Bytes: []byte(uri)})
+		}
+		if len(names) == 0 {
+			return nil, nil
+		}
+
+		var trees []byte
+		for _, name := range names {
+			tree, err := asn1.Marshal(struct{ Base asn1.RawValue }{name})
+			if err != nil {
+				return nil, err
+			}
+			trees = append(trees, tree...)
+		}
+		return asn1.Marshal(asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: tag, IsCompound: true, Bytes: trees})
+	}
+
+	permitted, err := subtrees(0, c.PermittedDNS, c.PermittedIP, c.PermittedEmail, c.PermittedURI)
+	if err != nil {
+		return nil, err
+	}
+	excluded, err := subtrees(1, c.ExcludedDNS, c.ExcludedIP, c.ExcludedEmail, c.ExcludedURI)
+	if err != nil {
+		return nil, err
+	}
+	value, err := asn1.Marshal(asn1.RawValue{Tag: asn1.TagSequence, IsCompound: true, Bytes: append(permitted, excluded...)})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode name constraints: %w", err)
+	}
+	return value, nil
+}
+
+// ConstraintsFromRequest reads the CA constraints requested through the
+// extensions of a certificate request. It returns nil when none are present.
+func ConstraintsFromRequest(request *x509.CertificateRequest) (*CAConstraints, error) {
+	c := &CAConstraints{}
+	found := false
+
+	for _, ext := range request.Extensions {
+		switch {
+		case ext.Id.Equal(oidExtensionNameConstraints):
+			if err := c.unmarshalNameConstraints(ext.Value); err != nil {
+				return nil, err
+			}
+		case ext.Id.Equal(oidExtensionCertificatePolicies):
+			var policies []struct {
+				Policy     asn1.ObjectIdentifier
+				Qualifiers asn1.RawValue `asn1:"optional"`
+			}
+			if _, err := asn1.Unmarshal(ext.Value, &policies); err != nil {
+				return nil, fmt.Errorf("invalid certificate policies: %w", err)
+			}
+			for _, p := range policies {
+				c.Policies = append(c.Policies, p.Policy.String())
+			}
+		case ext.Id.Equal(oidExtensionPolicyConstraints):
+			var seq asn1.RawValue
+			if _, err := asn1.Unmarshal(ext.Value, &seq); err != nil {
+				return nil, fmt.Errorf("invalid policy constraints: %w", err)
+			}
+			for rest := seq.Bytes; len(rest) > 0; {
+				var field asn1.RawValue
+				var err error
+				if rest, err = asn1.Unmarshal(rest, &field); err != nil {
+					return nil, fmt.Errorf("invalid policy constraints: %w", err)
+				}
+				v := unmarshalSkipCerts(field.Bytes)
+				switch field.Tag {
+				case 0:
+					c.RequireExplicitPolicy = &v
+				case 1:
+					c.InhibitPolicyMapping = &v
+				}
+			}
+		case ext.Id.Equal(oidExtensionInhibitAnyPolicy):
+			var v int
+			if _, err := asn1.Unmarshal(ext.Value, &v); err != nil {
+				return nil, fmt.Errorf("invalid inhibit anyPolicy: %w", err)
+			}
+			c.InhibitAnyPolicy = &v
+		default:
+			continue
+		}
+		found = true
+	}
+
+	if !found {
+		return nil, nil
+	}
+	return c, c.Validate()
+}
+
+// unmarshalNameConstraints decodes a NameConstraints extension value
+func (c *CAConstraints) unmarshalNameConstraints(der []byte) error {
+	var seq asn1.RawValue
+	if _, err := asn1.Unmarshal(der, &seq); err != nil {
+		return fmt.Errorf("invalid name constraints: %w", err)
+	}
+
+	for rest := seq.Bytes; len(rest) > 0; {
+		var subtrees asn1.RawValue
+		var err error
+		if rest, err = asn1.Unmarshal(rest, &subtrees); err != nil {
+			return fmt.Errorf("invalid name constraints: %w", err)
+		}
+		permitted := subtrees.Tag == 0
+
+		for trees := subtrees.Bytes; len(trees) > 0; {
+			var tree, base asn1.RawValue
+			var err error
+			if trees, err = asn1.Unmarshal(trees, &tree); err != nil {
+				return fmt.Errorf("invalid name constraint: %w", err)
+			}
+			// The minimum and maximum fields are not used in practice
+			if _, err := asn1.Unmarshal(tree.Bytes, &base); err != nil {
+				return fmt.Errorf("invalid name constraint: %w", err)
+			}
+
+			value := string(base.Bytes)
+			var list *[]string
+			switch base.Tag {
+			case nameTagDNS:
+				list = pick(permitted, &c.PermittedDNS, &c.ExcludedDNS)
+			case nameTagEmail:
+				list = pick(permitted, &c.PermittedEmail, &c.ExcludedEmail)
+			case nameTagURI:
+				list = pick(permitted, &c.PermittedURI, &c.ExcludedURI)
+			case nameTagIP:
+				b := base.Bytes
+				if len(b) != 8 && len(b) != 32 {
+					return fmt.Errorf("invalid IP name constraint of %d bytes", len(b))
+				}
+				n := net.IPNet{IP: b[:len(b)/2], Mask: b[len(b)/2:]}
+				value = n.String()
+				list = pick(permitted, &c.PermittedIP, &c.ExcludedIP)
+			default:
+				return fmt.Errorf("unsupported name constraint type %d", base.Tag)
+			}
+			*list = append(*list, value)
+		}
+	}
+	return nil
+}
+
+// pick returns the permitted or excluded list
+func pick(permitted bool, p, e *[]string) *[]string {
+	if permitted {
+		return p
+	}
+	return e
+}
+
+// CheckWithin makes sure the constraints do not widen those of the parent
+// CA certificate: every permitted subtree must lie inside the parent's
+// permitted subtrees and outside its excluded ones
+func (c *CAConstraints) CheckWithin(parent *x509.Certificate) error {
+	for _, name := range c.PermittedDNS {
+		d := strings.TrimPrefix(name, ".")
+		if !matchesAny(d, parent.PermittedDNSDomains, dnsMatches, true) || matchesAny(d, parent.ExcludedDNSDomains, dnsMatches, false) {
+			return fmt.Errorf("permitted DNS name %q is outside the parent CA's name constraints", name)
+		}
+	}
+	for _, name := range c.PermittedEmail {
+		var ok, excluded bool
+		if strings.Contains(name, "@") {
+			ok = matchesAny(name, parent.PermittedEmailAddresses, emailMatches, true)
+			excluded = matchesAny(name, parent.ExcludedEmailAddresses, emailMatches, false)
+		} else {
+			d := strings.TrimPrefix(name, ".")
+			ok = matchesAny(d, parent.PermittedEmailAddresses, emailDomainMatches, true)
+			excluded = matchesAny(d, parent.ExcludedEmailAddresses, emailDomainMatches, false)
+		}
+		if !ok || excluded {
+			return fmt.Errorf("permitted email %q is outside the parent CA's name constraints", name)
+		}
+	}
+	for _, name := range c.PermittedURI {
+		d := strings.TrimPrefix(name, ".")
+		if !matchesAny(d, parent.PermittedURIDomains, dnsMatches, true) || matchesAny(d, parent.ExcludedURIDomains, dnsMatches, false) {
+			return fmt.Errorf("permitted URI domain %q is outside the parent CA's name constraints", name)
+		}
+	}
+
+	nets, err := parseCIDRs(c.PermittedIP)
+	if err != nil {
+		return err
+	}
+	for _, n := range nets {
+		inside := len(parent.PermittedIPRanges) == 0
+		for _, p := range parent.PermittedIPRanges {
+			if netContains(p, n) {
+				inside = true
+			}
+		}
+		for _, e := range parent.ExcludedIPRanges {
+			if e.Contains(n.IP) || netContains(n, e) {
+				inside = false
+			}
+		}
+		if !inside {
+			return fmt.Errorf("permitted IP range %s is outside the parent CA's name constraints", n)
+		}
+	}
+	return nil
+}
+
+// CheckNameConstraints rejects names a CA certificate is not allowed to
+// certify. The common name is checked as well when it looks like a host
+// name, as many clients still fall back to it.
+func CheckNameConstraints(caCert *x509.Certificate, commonName string, dnsNames []string, ips []net.IP, emails []string, uris []*url.URL) error {
+	if strings.Contains(commonName, ".") && !strings.ContainsAny(commonName, " @/:") && net.ParseIP(commonName) == nil {
+		dnsNames = append([]string{commonName}, dnsNames...)
+	}
+
+	for _, name := range dnsNames {
+		if !matchesAny(name, caCert.PermittedDNSDomains, dnsMatches, true) {
+			return fmt.Errorf("DNS name %q is not permitted by the issuing CA", name)
+		}
+		if matchesAny(name, caCert.ExcludedDNSDomains, dnsMatches, false) {
+			return fmt.Errorf("DNS name %q is excluded by the issuing CA", name)
+		}
+	}
+	for _, email := range emails {
+		if !matchesAny(email, caCert.PermittedEmailAddresses, emailMatches, true) {
+			return fmt.Errorf("email address %q is not permitted by the issuing CA", email)
+		}
+		if matchesAny(email, caCert.ExcludedEmailAddresses, emailMatches, false) {
+			return fmt.Errorf("email address %q is excluded by the issuing CA", email)
+		}
+	}
+	for _, uri := range uris {
+		host := uri.Hostname()
+		if len(caCert.PermittedURIDomains)+len(caCert.ExcludedURIDomains) > 0 && (host == "" || net.ParseIP(host) != nil) {
+			return fmt.Errorf("URI %q has no domain to check against the issuing CA's constraints", uri)
+		}
+		if !matchesAny(host, caCert.PermittedURIDomains, dnsMatches, true) {
+			return fmt.Errorf("URI %q is not permitted by the issuing CA", uri)
+		}
+		if matchesAny(host, caCert.ExcludedURIDomains, dnsMatches, false) {
+			return fmt.Errorf("URI %q is excluded by the issuing CA", uri)
+		}
+	}
+	for _, ip := range ips {
+		permitted := len(caCert.PermittedIPRanges) == 0
+		for _, n := range caCert.PermittedIPRanges {
+			if n.Contains(ip) {
+				permitted = true
+			}
+		}
+		if !permitted {
+			return fmt.Errorf("IP address %s is not permitted by the issuing CA", ip)
+		}
+		for _, n := range caCert.ExcludedIPRanges {
+			if n.Contains(ip) {
+				return fmt.Errorf("IP address %s is excluded by the issuing CA", ip)
+			}
+		}
+	}
+	return nil
+}
+
+// matchesAny reports whether name matches one of the constraints; an empty
+// constraint list yields empty
+func matchesAny(name string, constraints []string, match func(name, constraint string) bool, empty bool) bool {
+	if len(constraints) == 0 {
+		return empty
+	}
+	for _, constraint := range constraints {
+		if match(name, constraint) {
+			return true
+		}
+	}
+	return false
+}
+
+// dnsMatches applies a DNS name constraint: "example.com" covers the domain
+// and its subdomains, ".example.com" only the subdomains
+func dnsMatches(name, constraint string) bool {
+	name = strings.TrimSuffix(strings.ToLower(name), ".")
+	constraint = strings.ToLower(constraint)
+	if constraint == "" {
+		return true
+	}
+	if strings.HasPrefix(constraint, ".") {
+		return strings.HasSuffix(name, constraint)
+	}
+	return name == constraint || strings.HasSuffix(name, "."+constraint)
+}
+
+// emailMatches applies an email constraint: a full mailbox, a host, or
+// ".domain" for any host below the domain
+func emailMatches(email, constraint string) bool {
+	if strings.Contains(constraint, "@") {
+		return strings.EqualFold(email, constraint)
+	}
+	_, host, ok := strings.Cut(email, "@")
+	if !ok {
+		return false
+	}
+	return emailDomainMatches(host, constraint)
+}
+
+// emailDomainMatches applies a host or ".domain" email constraint to a host
+func emailDomainMatches(host, constraint string) bool {
+	if strings.Contains(constraint, "@") {
+		return false
+	}
+	if strings.HasPrefix(constraint, ".") {
+		return strings.HasSuffix(strings.ToLower(host), strings.ToLower(constraint))
+	}
+	return strings.EqualFold(host, constraint)
+}
+
+// netContains reports whether inner lies completely inside outer
+func netContains(outer, inner *net.IPNet) bool {
+	outerOnes, outerBits := outer.Mask.Size()
+	innerOnes, innerBits := inner.Mask.Size()
+	return outerBits == innerBits && outerOnes <= innerOnes && outer.Contains(inner.IP)
+}
+
+// parseCIDRs parses IP ranges in CIDR notation
+func parseCIDRs(values []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, v := range values {
+		_, n, err := net.ParseCIDR(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid IP range %q: %w", v, err)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// parseOIDs parses dotted object identifiers
+func parseOIDs(values []string) ([]asn1.ObjectIdentifier, error) {
+	var oids []asn1.ObjectIdentifier
+	for _, v := range values {
+		oid, err := parseOID(v)
+		if err != nil {
+			return nil, err
+		}
+		oids = append(oids, oid)
+	}
+	return oids, nil
+}
+
+// parseOID parses a dotted object identifier
+func parseOID(value string) (asn1.ObjectIdentifier, error) {
+	parts := strings.Split(value, ".")
+	if len(parts) < 2 {
+		return nil, fmt.Errorf("invalid OID %q", value)
+	}
+	oid := make(asn1.ObjectIdentifier, len(parts))
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid OID %q", value)
+		}
+		oid[i] = n
+	}
+	return oid, nil
+}
+
+// marshalImplicitInt encodes a [tag] IMPLICIT INTEGER
+func marshalImplicitInt(tag, v int) ([]byte, error) {
+	der, err := asn1.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var raw asn1.RawValue
+	if _, err := asn1.Unmarshal(der, &raw); err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: tag, Bytes: raw.Bytes})
+}
+
+// unmarshalSkipCerts decodes the content octets of a small INTEGER
+func unmarshalSkipCerts(b []byte) int {
+	v := 0
+	for _, c := range b {
+		v = v<<8 | int(c)
+	}
+	return v
+}
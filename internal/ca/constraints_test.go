@@ -0,0 +1,185 @@
+package ca
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cloudflare/cfssl/csr"
+
+	"github.com/billchurch/PiCA/internal/crypto"
+)
+
+func intPtr(v int) *int { return &v }
+
+// newConstrainedCA creates a sub CA below a test root, limited by c
+func newConstrainedCA(t *testing.T, c *CAConstraints) (*CA, *x509.Certificate) {
+	t.Helper()
+
+	root := newTestCA(t)
+	rootCert, _ := LoadCertificate(root.CertFile)
+	dir := filepath.Dir(root.CertFile)
+
+	req := &csr.CertificateRequest{
+		CN:         "Constrained CA",
+		Names:      []csr.Name{{C: "US", O: "PiCA Test"}},
+		KeyRequest: &csr.KeyRequest{A: "ecdsa", S: 256},
+	}
+	subCertFile := filepath.Join(dir, "sub-ca.pem")
+	if err := GenerateIntermediateCAWithOptions(req, &RequestOptions{Constraints: c}, root.Provider, root.Slot,
+		root.Provider, crypto.SlotCA2, root.CertFile, subCertFile, time.Hour, 0); err != nil {
+		t.Fatalf("Failed to generate constrained CA: %v", err)
+	}
+
+	sub := NewCAWithProvider(SubCA, root.ConfigFile, "", subCertFile, root.Provider, crypto.SlotCA2)
+	return sub, rootCert
+}
+
+func TestConstrainedSubCA(t *testing.T) {
+	sub, rootCert := newConstrainedCA(t, &CAConstraints{
+		PermittedDNS:          []string{"example.com"},
+		ExcludedDNS:           []string{"secret.example.com"},
+		PermittedIP:           []string{"10.0.0.0/8"},
+		PermittedEmail:        []string{".example.com"},
+		Policies:              []string{"1.3.6.1.4.1.99999.1"},
+		RequireExplicitPolicy: intPtr(0),
+		InhibitAnyPolicy:      intPtr(0),
+	})
+	subCert, err := LoadCertificate(sub.CertFile)
+	if err != nil {
+		t.Fatalf("Failed to load CA certificate: %v", err)
+	}
+
+	if !subCert.PermittedDNSDomainsCritical || !reflect.DeepEqual(subCert.PermittedDNSDomains, []string{"example.com"}) ||
+		!reflect.DeepEqual(subCert.ExcludedDNSDomains, []string{"secret.example.com"}) ||
+		len(subCert.PermittedIPRanges) != 1 || subCert.PermittedIPRanges[0].String() != "10.0.0.0/8" {
+		t.Errorf("Unexpected name constraints in CA certificate")
+	}
+	var policyConstraints, inhibitAnyPolicy bool
+	for _, ext := range subCert.Extensions {
+		policyConstraints = policyConstraints || (ext.Id.Equal(oidExtensionPolicyConstraints) && ext.Critical)
+		inhibitAnyPolicy = inhibitAnyPolicy || (ext.Id.Equal(oidExtensionInhibitAnyPolicy) && ext.Critical)
+	}
+	if !policyConstraints || !inhibitAnyPolicy || len(subCert.PolicyIdentifiers) != 1 {
+		t.Errorf("Expected critical policy constraints, inhibit anyPolicy and a certificate policy")
+	}
+	if subCert.CheckSignatureFrom(rootCert) != nil {
+		t.Errorf("CA certificate not signed by the root")
+	}
+
+	leaf := parsePEMCertificate(t, mustSign(t, sub, "www.example.com"))
+	if !reflect.DeepEqual(leaf.DNSNames, []string{"www.example.com"}) {
+		t.Errorf("Expected the CSR names to be copied, got %v", leaf.DNSNames)
+	}
+
+	for _, tc := range []struct {
+		cn    string
+		names []string
+		ips   []net.IP
+	}{
+		{cn: "www.example.org"},
+		{cn: "app", names: []string{"app.example.org"}},
+		{cn: "db.secret.example.com"},
+		{cn: "internal", names: []string{"internal.example.com"}, ips: []net.IP{net.ParseIP("192.168.1.10")}},
+	} {
+		key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		der, _ := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+			Subject:     pkix.Name{CommonName: tc.cn},
+			DNSNames:    tc.names,
+			IPAddresses: tc.ips,
+		}, key)
+		csrPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der})
+		if _, err := sub.SignCertificate(csrPEM, "server"); err == nil {
+			t.Errorf("Expected %s %v %v to be rejected", tc.cn, tc.names, tc.ips)
+		}
+	}
+}
+
+func TestConstraintsMustNarrowParent(t *testing.T) {
+	sub, _ := newConstrainedCA(t, &CAConstraints{PermittedDNS: []string{"example.com"}, PermittedIP: []string{"10.0.0.0/8"}})
+	subCert, _ := LoadCertificate(sub.CertFile)
+
+	for _, c := range []*CAConstraints{
+		{PermittedDNS: []string{"example.org"}},
+		{PermittedIP: []string{"0.0.0.0/0"}},
+		{PermittedDNS: []string{"notexample.com"}},
+	} {
+		if err := c.CheckWithin(subCert); err == nil {
+			t.Errorf("Expected %+v to be rejected", c)
+		}
+	}
+	for _, c := range []*CAConstraints{
+		{PermittedDNS: []string{"dev.example.com", ".example.com"}},
+		{PermittedIP: []string{"10.1.0.0/16"}},
+	} {
+		if err := c.CheckWithin(subCert); err != nil {
+			t.Errorf("Expected %+v to be allowed: %v", c, err)
+		}
+	}
+}
+
+func TestConstraintsRequestRoundTrip(t *testing.T) {
+	c := &CAConstraints{
+		PermittedDNS:          []string{"example.com"},
+		ExcludedDNS:           []string{".internal.example.com"},
+		PermittedIP:           []string{"10.0.0.0/8", "2001:db8::/32"},
+		ExcludedEmail:         []string{"root@example.com"},
+		PermittedURI:          []string{".example.com"},
+		Policies:              []string{"2.23.140.1.2.1"},
+		RequireExplicitPolicy: intPtr(0),
+		InhibitPolicyMapping:  intPtr(1),
+		InhibitAnyPolicy:      intPtr(2),
+	}
+	exts, err := c.Extensions()
+	if err != nil {
+		t.Fatalf("Extensions failed: %v", err)
+	}
+
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:         pkix.Name{CommonName: "Requested CA"},
+		ExtraExtensions: exts,
+	}, key)
+	if err != nil {
+		t.Fatalf("Failed to create CSR: %v", err)
+	}
+	request, _ := x509.ParseCertificateRequest(der)
+
+	got, err := ConstraintsFromRequest(request)
+	if err != nil {
+		t.Fatalf("ConstraintsFromRequest failed: %v", err)
+	}
+	if !reflect.DeepEqual(got, c) {
+		t.Errorf("Round trip mismatch:\n got %+v\nwant %+v", got, c)
+	}
+}
+
+func TestProfileConstraints(t *testing.T) {
+	c := newTestCA(t)
+	config := strings.Replace(testSigningConfig, `"profiles": {`, `"profiles": {
+			"intermediate": {"usages": ["cert sign", "crl sign"], "expiry": "8760h",
+				"ca_constraint": {"is_ca": true, "max_path_len_zero": true},
+				"pica": {"constraints": {"permitted_dns": ["corp.example.com"]}}},`, 1)
+	if err := os.WriteFile(c.ConfigFile, []byte(config), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+
+	certPEM, err := c.SignCertificate(newTestCSR(t, "Corp Issuing CA"), "intermediate")
+	if err != nil {
+		t.Fatalf("Failed to sign CA certificate: %v", err)
+	}
+	cert := parsePEMCertificate(t, certPEM)
+	if !cert.IsCA || !reflect.DeepEqual(cert.PermittedDNSDomains, []string{"corp.example.com"}) {
+		t.Errorf("Expected a CA certificate limited to corp.example.com, got %v", cert.PermittedDNSDomains)
+	}
+}
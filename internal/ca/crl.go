@@ -0,0 +1,422 @@
+package ca
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/billchurch/PiCA/internal/crypto"
+)
+
+// Default CRL lifetimes
+const (
+	DefaultCRLValidity     = 7 * 24 * time.Hour
+	DefaultCRLRenewBefore  = 2 * 24 * time.Hour
+	DefaultRootCRLValidity = 365 * 24 * time.Hour
+)
+
+var (
+	oidDeltaCRLIndicator = asn1.ObjectIdentifier{2, 5, 29, 27}
+	oidFreshestCRL       = asn1.ObjectIdentifier{2, 5, 29, 46}
+)
+
+// revocationReasons maps reason names to RFC 5280 CRLReason codes
+var revocationReasons = map[string]int{
+	"unspecified":          0,
+	"keyCompromise":        1,
+	"cACompromise":         2,
+	"affiliationChanged":   3,
+	"superseded":           4,
+	"cessationOfOperation": 5,
+	"certificateHold":      6,
+	"removeFromCRL":        8,
+	"privilegeWithdrawn":   9,
+	"aACompromise":         10,
+}
+
+// ParseRevocationReason converts a reason name to its CRLReason code
+func ParseRevocationReason(reason string) (int, error) {
+	if reason == "" {
+		return 0, nil
+	}
+	for name, code := range revocationReasons {
+		if strings.EqualFold(name, reason) {
+			return code, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown revocation reason: %s", reason)
+}
+
+// CRLInfo describes a generated CRL
+type CRLInfo struct {
+	Number     int64
+	Delta      bool
+	ThisUpdate time.Time
+	NextUpdate time.Time
+	Entries    int
+	File       string
+	// KeyFiles are the full CRLs signed by the keys of earlier generations
+	// that are still valid
+	KeyFiles []string
+}
+
+// DeltaCRLFile returns the delta CRL path that accompanies a full CRL file
+func DeltaCRLFile(crlFile string) string {
+	ext := filepath.Ext(crlFile)
+	return strings.TrimSuffix(crlFile, ext) + "-delta" + ext
+}
+
+// KeyCRLFile returns the path of the CRL signed by the CA key with the given
+// key identifier, published next to the CA's CRL file after a rekey
+func KeyCRLFile(crlFile string, keyID []byte) string {
+	ext := filepath.Ext(crlFile)
+	return strings.TrimSuffix(crlFile, ext) + "-" + hex.EncodeToString(keyID) + ext
+}
+
+// GenerateCRL creates and signs a full CRL covering every revoked certificate
+// and publishes it to the CA's CRL file
+func (ca *CA) GenerateCRL(validity time.Duration) (*CRLInfo, error) {
+	return ca.generateCRL(validity, false)
+}
+
+// GenerateDeltaCRL creates and signs a delta CRL listing revocations since
+// the last full CRL and publishes it next to the CA's CRL file
+func (ca *CA) GenerateDeltaCRL(validity time.Duration) (*CRLInfo, error) {
+	return ca.generateCRL(validity, true)
+}
+
+func (ca *CA) generateCRL(validity time.Duration, delta bool) (*CRLInfo, error) {
+	ca.crlMutex.Lock()
+	defer ca.crlMutex.Unlock()
+
+	if err := ca.InitializeProvider(); err != nil {
+		return nil, err
+	}
+	if ca.CRLFile == "" {
+		return nil, errors.New("no CRL file configured")
+	}
+
+	store, err := ca.Store()
+	if err != nil {
+		return nil, err
+	}
+
+	caCert, slot, err := ca.ActiveIssuer()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load CA certificate: %w", err)
+	}
+
+	state := store.CRLState()
+	if delta && state.BaseNumber == 0 {
+		return nil, errors.New("a full CRL must be published before a delta CRL")
+	}
+
+	now := time.Now().UTC()
+	state.Number++
+	template := &x509.RevocationList{
+		Number:     big.NewInt(state.Number),
+		ThisUpdate: now,
+		NextUpdate: now.Add(validity),
+	}
+
+	since := time.Time{}
+	if delta {
+		since = state.BaseThisUpdate
+		baseNumber, err := asn1.Marshal(big.NewInt(state.BaseNumber))
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode base CRL number: %w", err)
+		}
+		template.ExtraExtensions = append(template.ExtraExtensions, pkix.Extension{
+			Id:       oidDeltaCRLIndicator,
+			Critical: true,
+			Value:    baseNumber,
+		})
+	} else if len(ca.DeltaCRLDistributionPoints) > 0 {
+		freshest, err := marshalDistributionPoints(ca.DeltaCRLDistributionPoints)
+		if err != nil {
+			return nil, err
+		}
+		template.ExtraExtensions = append(template.ExtraExtensions, pkix.Extension{
+			Id:    oidFreshestCRL,
+			Value: freshest,
+		})
+	}
+
+	template.RevokedCertificateEntries = revokedEntries(store, since)
+
+	signer := &crypto.ProviderSigner{
+		Provider:  ca.Provider,
+		Slot:      slot,
+		PublicKey: caCert.PublicKey,
+	}
+
+	crlDER, err := x509.CreateRevocationList(rand.Reader, template, caCert, signer)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create CRL: %w", err)
+	}
+
+	file := ca.CRLFile
+	if delta {
+		file = DeltaCRLFile(ca.CRLFile)
+	}
+	if err := writeFileAtomic(file, crlDER, 0644); err != nil {
+		return nil, fmt.Errorf("failed to publish CRL: %w", err)
+	}
+
+	if delta {
+		state.DeltaThisUpdate = template.ThisUpdate
+		state.DeltaNextUpdate = template.NextUpdate
+	} else {
+		state.BaseNumber = state.Number
+		state.BaseThisUpdate = template.ThisUpdate
+		state.BaseNextUpdate = template.NextUpdate
+	}
+	if err := store.UpdateCRLState(state); err != nil {
+		return nil, fmt.Errorf("failed to save CRL state: %w", err)
+	}
+
+	info := &CRLInfo{
+		Number:     state.Number,
+		Delta:      delta,
+		ThisUpdate: template.ThisUpdate,
+		NextUpdate: template.NextUpdate,
+		Entries:    len(template.RevokedCertificateEntries),
+		File:       file,
+	}
+
+	// Certificates issued under an earlier key stay valid after a rekey, so
+	// each such key keeps signing a full CRL with the same number
+	previous, err := ca.previousIssuers(caCert, now)
+	if err != nil {
+		return nil, err
+	}
+	for _, issuer := range previous {
+		keyTemplate := &x509.RevocationList{
+			Number:                    template.Number,
+			ThisUpdate:                template.ThisUpdate,
+			NextUpdate:                template.NextUpdate,
+			RevokedCertificateEntries: revokedEntries(store, time.Time{}),
+		}
+		keySigner := &crypto.ProviderSigner{
+			Provider:  ca.Provider,
+			Slot:      issuer.Slot,
+			PublicKey: issuer.Certificate.PublicKey,
+		}
+		keyDER, err := x509.CreateRevocationList(rand.Reader, keyTemplate, issuer.Certificate, keySigner)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create CRL for slot %X: %w", int(issuer.Slot), err)
+		}
+		keyFile := KeyCRLFile(ca.CRLFile, issuer.Certificate.SubjectKeyId)
+		if err := writeFileAtomic(keyFile, keyDER, 0644); err != nil {
+			return nil, fmt.Errorf("failed to publish CRL: %w", err)
+		}
+		info.KeyFiles = append(info.KeyFiles, keyFile)
+	}
+
+	return info, nil
+}
+
+// revokedEntries lists the certificates revoked since the given time
+func revokedEntries(store *Store, since time.Time) []x509.RevocationListEntry {
+	var entries []x509.RevocationListEntry
+	for _, r := range store.Revoked(since) {
+		serial, ok := new(big.Int).SetString(r.SerialNumber, 16)
+		if !ok {
+			log.Printf("Skipping revoked record with invalid serial number %q", r.SerialNumber)
+			continue
+		}
+		code, _ := ParseRevocationReason(r.RevocationReason)
+		entries = append(entries, x509.RevocationListEntry{
+			SerialNumber:   serial,
+			RevocationTime: r.RevokedAt,
+			ReasonCode:     code,
+		})
+	}
+	return entries
+}
+
+// previousIssuers returns, for every key other than the active one, the
+// most recent valid generation certifying it. Such keys may have issued
+// certificates that are still valid.
+func (ca *CA) previousIssuers(active *x509.Certificate, now time.Time) ([]*Issuer, error) {
+	if _, err := os.Stat(CertificateSetFile(ca.CertFile)); errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	set, err := ca.LoadCertificateSet()
+	if err != nil {
+		return nil, err
+	}
+	issuers, err := set.Issuers()
+	if err != nil {
+		return nil, err
+	}
+
+	var previous []*Issuer
+	for _, issuer := range issuers {
+		cert := issuer.Certificate
+		if now.Before(cert.NotBefore) || now.After(cert.NotAfter) || sameKey(cert, active) {
+			continue
+		}
+		seen := false
+		for i, p := range previous {
+			if sameKey(p.Certificate, cert) {
+				if !cert.NotBefore.Before(p.Certificate.NotBefore) {
+					previous[i] = issuer
+				}
+				seen = true
+			}
+		}
+		if !seen {
+			previous = append(previous, issuer)
+		}
+	}
+	return previous, nil
+}
+
+// marshalDistributionPoints encodes a CRLDistributionPoints-style sequence of
+// full-name URIs, as used by the freshest CRL extension
+func marshalDistributionPoints(urls []string) ([]byte, error) {
+	type distributionPointName struct {
+		FullName []asn1.RawValue `asn1:"optional,tag:0"`
+	}
+	type distributionPoint struct {
+		DistributionPoint distributionPointName `asn1:"optional,tag:0"`
+	}
+
+	var points []distributionPoint
+	for _, url := range urls {
+		points = append(points, distributionPoint{
+			DistributionPoint: distributionPointName{
+				FullName: []asn1.RawValue{{Tag: 6, Class: asn1.ClassContextSpecific, Bytes: []byte(url)}},
+			},
+		})
+	}
+
+	value, err := asn1.Marshal(points)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode distribution points: %w", err)
+	}
+	return value, nil
+}
+
+// CRLPublisher keeps a CA's CRL fresh by re-signing it well before its
+// nextUpdate, and optionally publishes delta CRLs in between
+type CRLPublisher struct {
+	CA            *CA
+	Validity      time.Duration
+	RenewBefore   time.Duration
+	DeltaInterval time.Duration
+	CheckInterval time.Duration
+
+	// OnPublish is called after every successful publication
+	OnPublish func(info *CRLInfo)
+}
+
+// NewCRLPublisher creates a publisher with default lifetimes
+func NewCRLPublisher(ca *CA) *CRLPublisher {
+	return &CRLPublisher{
+		CA:            ca,
+		Validity:      DefaultCRLValidity,
+		RenewBefore:   DefaultCRLRenewBefore,
+		CheckInterval: time.Minute,
+	}
+}
+
+// Validate checks that the CRL is renewed before it expires; a renewal
+// window as long as the validity would re-sign the CRL on every check
+func (p *CRLPublisher) Validate() error {
+	if p.Validity <= 0 {
+		return fmt.Errorf("CRL validity must be positive, got %s", p.Validity)
+	}
+	if p.RenewBefore >= p.Validity {
+		return fmt.Errorf("CRL renewal window (%s) must be shorter than its validity (%s)",
+			p.RenewBefore, p.Validity)
+	}
+	return nil
+}
+
+// PublishIfDue regenerates the full CRL when it is within RenewBefore of its
+// nextUpdate, and the delta CRL when DeltaInterval has elapsed
+func (p *CRLPublisher) PublishIfDue(now time.Time) error {
+	if err := p.Validate(); err != nil {
+		return err
+	}
+	store, err := p.CA.Store()
+	if err != nil {
+		return err
+	}
+	state := store.CRLState()
+
+	if state.BaseNumber == 0 || !now.Before(state.BaseNextUpdate.Add(-p.RenewBefore)) {
+		return p.publish(false)
+	}
+
+	if p.DeltaInterval > 0 && !now.Before(state.DeltaThisUpdate.Add(p.DeltaInterval)) {
+		return p.publish(true)
+	}
+
+	return nil
+}
+
+// Refresh publishes a new CRL immediately, for example after a revocation.
+// When delta CRLs are enabled only the delta is regenerated.
+func (p *CRLPublisher) Refresh() error {
+	store, err := p.CA.Store()
+	if err != nil {
+		return err
+	}
+	if p.DeltaInterval > 0 && store.CRLState().BaseNumber != 0 {
+		return p.publish(true)
+	}
+	return p.publish(false)
+}
+
+// Run checks the schedule until the context is cancelled
+func (p *CRLPublisher) Run(ctx context.Context) {
+	ticker := time.NewTicker(p.CheckInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := p.PublishIfDue(time.Now()); err != nil {
+			log.Printf("Error publishing CRL: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (p *CRLPublisher) publish(delta bool) error {
+	var info *CRLInfo
+	var err error
+	if delta {
+		// Delta CRLs must not outlive the next scheduled delta by much
+		info, err = p.CA.GenerateDeltaCRL(2 * p.DeltaInterval)
+	} else {
+		info, err = p.CA.GenerateCRL(p.Validity)
+	}
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Published CRL #%d (delta: %t, entries: %d, nextUpdate: %s)",
+		info.Number, info.Delta, info.Entries, info.NextUpdate.Format(time.RFC3339))
+	if p.OnPublish != nil {
+		p.OnPublish(info)
+	}
+	return nil
+}
@@ -0,0 +1,257 @@
+package ca
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/cloudflare/cfssl/config"
+	"github.com/cloudflare/cfssl/csr"
+
+	"github.com/billchurch/PiCA/internal/crypto"
+)
+
+var (
+	oidExtensionKeyUsage    = asn1.ObjectIdentifier{2, 5, 29, 15}
+	oidExtensionExtKeyUsage = asn1.ObjectIdentifier{2, 5, 29, 37}
+)
+
+// extKeyUsageOIDs maps extended key usages to their object identifiers
+var extKeyUsageOIDs = map[x509.ExtKeyUsage]asn1.ObjectIdentifier{
+	x509.ExtKeyUsageAny:             {2, 5, 29, 37, 0},
+	x509.ExtKeyUsageServerAuth:      {1, 3, 6, 1, 5, 5, 7, 3, 1},
+	x509.ExtKeyUsageClientAuth:      {1, 3, 6, 1, 5, 5, 7, 3, 2},
+	x509.ExtKeyUsageCodeSigning:     {1, 3, 6, 1, 5, 5, 7, 3, 3},
+	x509.ExtKeyUsageEmailProtection: {1, 3, 6, 1, 5, 5, 7, 3, 4},
+	x509.ExtKeyUsageIPSECEndSystem:  {1, 3, 6, 1, 5, 5, 7, 3, 5},
+	x509.ExtKeyUsageIPSECTunnel:     {1, 3, 6, 1, 5, 5, 7, 3, 6},
+	x509.ExtKeyUsageIPSECUser:       {1, 3, 6, 1, 5, 5, 7, 3, 7},
+	x509.ExtKeyUsageTimeStamping:    {1, 3, 6, 1, 5, 5, 7, 3, 8},
+	x509.ExtKeyUsageOCSPSigning:     {1, 3, 6, 1, 5, 5, 7, 3, 9},
+}
+
+// RequestOptions holds PiCA-specific fields read from the same JSON file as
+// the cfssl certificate request
+type RequestOptions struct {
+	// Usages are cfssl usage names ("cert sign", "server auth", ...) that are
+	// requested through the key usage and extended key usage extensions
+	Usages []string `json:"usages,omitempty"`
+	// Constraints are name and policy constraints requested for a CA
+	Constraints *CAConstraints `json:"constraints,omitempty"`
+	// Extensions are custom extensions requested for the certificate
+	Extensions []Extension `json:"extensions,omitempty"`
+}
+
+// LoadCertificateRequest reads a cfssl JSON certificate request together
+// with the PiCA request options stored alongside it
+func LoadCertificateRequest(data []byte) (*csr.CertificateRequest, *RequestOptions, error) {
+	req := csr.New()
+	if err := json.Unmarshal(data, req); err != nil {
+		return nil, nil, fmt.Errorf("error parsing certificate request: %w", err)
+	}
+
+	opts := &RequestOptions{}
+	if err := json.Unmarshal(data, opts); err != nil {
+		return nil, nil, fmt.Errorf("error parsing request options: %w", err)
+	}
+
+	return req, opts, nil
+}
+
+// CreateCSR builds a PKCS#10 certificate request for the key held in a
+// provider slot. The request is signed by the provider, so the private key
+// never leaves it. When generateKey is true a new key is created first.
+func CreateCSR(req *csr.CertificateRequest, provider crypto.Provider, slot crypto.Slot, generateKey bool) ([]byte, error) {
+	return CreateCSRWithOptions(req, nil, provider, slot, generateKey)
+}
+
+// CreateCSRWithOptions is like CreateCSR but also requests the key usages,
+// CA constraints and custom extensions listed in opts. Every Names entry becomes part of the subject and every
+// host becomes a DNS, IP, email or URI subject alternative name.
+func CreateCSRWithOptions(req *csr.CertificateRequest, opts *RequestOptions, provider crypto.Provider, slot crypto.Slot, generateKey bool) ([]byte, error) {
+	if provider == nil {
+		return nil, errors.New("crypto provider is required")
+	}
+	if req.CN == "" && len(req.Names) == 0 {
+		return nil, errors.New("certificate request has an empty subject")
+	}
+
+	if generateKey {
+		algorithm, bits := keyParameters(req)
+		if err := provider.GenerateKey(slot, algorithm, bits); err != nil {
+			return nil, fmt.Errorf("failed to generate key: %w", err)
+		}
+	}
+
+	signer, err := crypto.CreateProviderSigner(provider, slot)
+	if err != nil {
+		return nil, err
+	}
+
+	usages := []string{}
+	if opts != nil {
+		usages = opts.Usages
+	}
+	if len(usages) == 0 && req.CA != nil {
+		usages = []string{"cert sign", "crl sign"}
+	}
+
+	// Work on a copy so the caller's request is not modified
+	request := *req
+	request.Extensions = append([]pkix.Extension{}, req.Extensions...)
+	if len(usages) > 0 {
+		exts, err := usageExtensions(usages, req.CA != nil)
+		if err != nil {
+			return nil, err
+		}
+		request.Extensions = append(request.Extensions, exts...)
+	}
+
+	if opts != nil && opts.Constraints != nil {
+		exts, err := opts.Constraints.Extensions()
+		if err != nil {
+			return nil, err
+		}
+		request.Extensions = append(request.Extensions, exts...)
+	}
+
+	if opts != nil && len(opts.Extensions) > 0 {
+		exts, err := EncodeExtensions(opts.Extensions)
+		if err != nil {
+			return nil, err
+		}
+		request.Extensions = append(request.Extensions, exts...)
+	}
+
+	csrPEM, err := csr.Generate(signer, &request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create certificate request: %w", err)
+	}
+
+	return csrPEM, nil
+}
+
+// usageExtensions encodes cfssl usage names as key usage and extended key
+// usage extensions
+func usageExtensions(usages []string, isCA bool) ([]pkix.Extension, error) {
+	var ku x509.KeyUsage
+	var ekuOIDs []asn1.ObjectIdentifier
+
+	for _, usage := range usages {
+		name := strings.ToLower(strings.TrimSpace(usage))
+		if k, ok := config.KeyUsage[name]; ok {
+			ku |= k
+		} else if e, ok := config.ExtKeyUsage[name]; ok {
+			oid, ok := extKeyUsageOIDs[e]
+			if !ok {
+				return nil, fmt.Errorf("unsupported extended key usage: %s", usage)
+			}
+			ekuOIDs = append(ekuOIDs, oid)
+		} else {
+			return nil, fmt.Errorf("unknown key usage: %s", usage)
+		}
+	}
+
+	var exts []pkix.Extension
+	if ku != 0 {
+		value, err := marshalKeyUsage(ku)
+		if err != nil {
+			return nil, err
+		}
+		exts = append(exts, pkix.Extension{Id: oidExtensionKeyUsage, Critical: isCA, Value: value})
+	}
+	if len(ekuOIDs) > 0 {
+		value, err := asn1.Marshal(ekuOIDs)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode extended key usage: %w", err)
+		}
+		exts = append(exts, pkix.Extension{Id: oidExtensionExtKeyUsage, Value: value})
+	}
+	return exts, nil
+}
+
+// marshalKeyUsage encodes a key usage bit string the same way crypto/x509
+// does for certificates
+func marshalKeyUsage(ku x509.KeyUsage) ([]byte, error) {
+	var a [2]byte
+	a[0] = reverseBitsInAByte(byte(ku))
+	a[1] = reverseBitsInAByte(byte(ku >> 8))
+
+	l := 1
+	if a[1] != 0 {
+		l = 2
+	}
+
+	bitString := a[:l]
+	value, err := asn1.Marshal(asn1.BitString{Bytes: bitString, BitLength: asn1BitLength(bitString)})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode key usage: %w", err)
+	}
+	return value, nil
+}
+
+func reverseBitsInAByte(in byte) byte {
+	b1 := in>>4 | in<<4
+	b2 := b1>>2&0x33 | b1<<2&0xcc
+	b3 := b2>>1&0x55 | b2<<1&0xaa
+	return b3
+}
+
+// asn1BitLength returns the bit-length of bitString by considering the
+// most-significant bit in a byte to be the "first" bit
+func asn1BitLength(bitString []byte) int {
+	bitLen := len(bitString) * 8
+
+	for i := range bitString {
+		b := bitString[len(bitString)-i-1]
+
+		for bit := uint(0); bit < 8; bit++ {
+			if (b>>bit)&1 == 1 {
+				return bitLen
+			}
+			bitLen--
+		}
+	}
+
+	return 0
+}
+
+// ParseCSR decodes a PEM-encoded PKCS#10 request and verifies its signature
+func ParseCSR(csrPEM []byte) (*x509.CertificateRequest, error) {
+	block, _ := pem.Decode(csrPEM)
+	if block == nil || block.Type != "CERTIFICATE REQUEST" {
+		return nil, errors.New("failed to decode CSR")
+	}
+
+	request, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse CSR: %w", err)
+	}
+
+	if err := request.CheckSignature(); err != nil {
+		return nil, fmt.Errorf("invalid CSR signature: %w", err)
+	}
+
+	return request, nil
+}
+
+// keyParameters maps a cfssl key request to provider algorithm parameters
+func keyParameters(req *csr.CertificateRequest) (string, int) {
+	algorithm := "ECDSA"
+	bits := 384
+	if req.KeyRequest != nil {
+		if req.KeyRequest.A == "rsa" {
+			algorithm = "RSA"
+			bits = req.KeyRequest.Size()
+		} else if req.KeyRequest.A == "ecdsa" {
+			algorithm = "ECDSA"
+			bits = req.KeyRequest.Size()
+		}
+	}
+	return algorithm, bits
+}
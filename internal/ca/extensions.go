@@ -0,0 +1,222 @@
+package ca
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// Extension value types
+const (
+	ExtensionHex        = "hex"
+	ExtensionBase64     = "base64"
+	ExtensionUTF8String = "utf8string"
+	ExtensionIA5String  = "ia5string"
+	ExtensionInteger    = "integer"
+	ExtensionOID        = "oid"
+)
+
+// managedExtensions are set by PiCA from the profile, the request or the
+// issuer and cannot be declared as custom extensions
+var managedExtensions = map[string]string{
+	"2.5.29.14":         "subject key identifier",
+	"2.5.29.15":         "key usage",
+	"2.5.29.17":         "subject alternative name",
+	"2.5.29.19":         "basic constraints",
+	"2.5.29.30":         "name constraints",
+	"2.5.29.31":         "CRL distribution points",
+	"2.5.29.32":         "certificate policies",
+	"2.5.29.35":         "authority key identifier",
+	"2.5.29.36":         "policy constraints",
+	"2.5.29.37":         "extended key usage",
+	"2.5.29.54":         "inhibit any policy",
+	"1.3.6.1.5.5.7.1.1": "authority information access",
+}
+
+// Extension is a custom X.509 extension added to issued certificates. The
+// value is DER given as hex or base64, or a typed value PiCA encodes:
+//
+//	{"oid": "1.3.6.1.4.1.99999.1", "type": "utf8string", "value": "building 4"}
+//	{"oid": "1.3.6.1.4.1.99999.2", "critical": true, "type": "hex", "value": "0500"}
+type Extension struct {
+	OID      string `json:"oid"`
+	Critical bool   `json:"critical,omitempty"`
+	// Type is hex, base64, utf8string, ia5string, integer or oid
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+// Encode returns the extension in the form crypto/x509 adds to certificates
+func (e *Extension) Encode() (pkix.Extension, error) {
+	oid, err := extensionOID(e.OID)
+	if err != nil {
+		return pkix.Extension{}, err
+	}
+
+	var value []byte
+	switch strings.ToLower(e.Type) {
+	case ExtensionHex:
+		value, err = hex.DecodeString(strings.NewReplacer(":", "", " ", "").Replace(e.Value))
+		if err == nil {
+			err = checkDER(value)
+		}
+	case ExtensionBase64:
+		value, err = base64.StdEncoding.DecodeString(e.Value)
+		if err == nil {
+			err = checkDER(value)
+		}
+	case ExtensionUTF8String:
+		value, err = asn1.MarshalWithParams(e.Value, "utf8")
+	case ExtensionIA5String:
+		value, err = asn1.MarshalWithParams(e.Value, "ia5")
+	case ExtensionInteger:
+		n, ok := new(big.Int).SetString(e.Value, 0)
+		if !ok {
+			return pkix.Extension{}, fmt.Errorf("extension %s: invalid integer %q", e.OID, e.Value)
+		}
+		value, err = asn1.Marshal(n)
+	case ExtensionOID:
+		var v asn1.ObjectIdentifier
+		if v, err = parseOID(e.Value); err == nil {
+			value, err = asn1.Marshal(v)
+		}
+	default:
+		return pkix.Extension{}, fmt.Errorf("extension %s: unknown value type %q", e.OID, e.Type)
+	}
+	if err != nil {
+		return pkix.Extension{}, fmt.Errorf("extension %s: invalid %s value: %w", e.OID, e.Type, err)
+	}
+
+	return pkix.Extension{Id: oid, Critical: e.Critical, Value: value}, nil
+}
+
+// extensionOID parses the OID of a custom extension, refusing the ones PiCA
+// sets itself
+func extensionOID(value string) (asn1.ObjectIdentifier, error) {
+	oid, err := parseOID(value)
+	if err != nil {
+		return nil, err
+	}
+	if name, ok := managedExtensions[oid.String()]; ok {
+		return nil, fmt.Errorf("extension %s (%s) is set by PiCA and cannot be customized", value, name)
+	}
+	return oid, nil
+}
+
+// checkDER makes sure value holds exactly one DER element
+func checkDER(value []byte) error {
+	var raw asn1.RawValue
+	rest, err := asn1.Unmarshal(value, &raw)
+	if err != nil {
+		return err
+	}
+	if len(rest) > 0 {
+		return fmt.Errorf("%d trailing bytes after DER value", len(rest))
+	}
+	return nil
+}
+
+// EncodeExtensions encodes custom extensions, refusing duplicate OIDs
+func EncodeExtensions(exts []Extension) ([]pkix.Extension, error) {
+	var encoded []pkix.Extension
+	seen := make(map[string]bool)
+	for i := range exts {
+		ext, err := exts[i].Encode()
+		if err != nil {
+			return nil, err
+		}
+		if seen[ext.Id.String()] {
+			return nil, fmt.Errorf("extension %s is declared more than once", ext.Id)
+		}
+		seen[ext.Id.String()] = true
+		encoded = append(encoded, ext)
+	}
+	return encoded, nil
+}
+
+// validatePassthrough checks the OIDs of CSR extensions a profile copies
+func validatePassthrough(oids []string) error {
+	for _, oid := range oids {
+		if _, err := extensionOID(oid); err != nil {
+			return fmt.Errorf("passthrough %w", err)
+		}
+	}
+	return nil
+}
+
+// profileExtensions returns the custom extensions of a profile followed by
+// the CSR extensions it passes through. Extensions the profile declares
+// take precedence over requested ones with the same OID.
+func profileExtensions(opts *ProfileOptions, csr *x509.CertificateRequest) ([]pkix.Extension, error) {
+	exts, err := EncodeExtensions(opts.Extensions)
+	if err != nil {
+		return nil, err
+	}
+
+	allowed := make(map[string]bool)
+	for _, oid := range opts.PassthroughExtensions {
+		if o, err := parseOID(oid); err == nil {
+			allowed[o.String()] = true
+		}
+	}
+	for _, ext := range exts {
+		delete(allowed, ext.Id.String())
+	}
+	for _, ext := range csr.Extensions {
+		if allowed[ext.Id.String()] {
+			exts = append(exts, ext)
+			delete(allowed, ext.Id.String())
+		}
+	}
+	return exts, nil
+}
+
+// RequestExtensions returns the extensions of a CA request that
+// SignIntermediateCSR copies into the CA certificate, for review before
+// signing. Only the OIDs listed in passthrough are copied; a request with
+// any other custom extension, or with an OID twice, is refused.
+func RequestExtensions(request *x509.CertificateRequest, passthrough []string) ([]pkix.Extension, error) {
+	if err := validatePassthrough(passthrough); err != nil {
+		return nil, err
+	}
+	allowed := make(map[string]bool)
+	for _, oid := range passthrough {
+		if o, err := parseOID(oid); err == nil {
+			allowed[o.String()] = true
+		}
+	}
+
+	seen := make(map[string]bool)
+	for _, ext := range request.Extensions {
+		if seen[ext.Id.String()] {
+			return nil, fmt.Errorf("request carries extension %s more than once", ext.Id)
+		}
+		seen[ext.Id.String()] = true
+	}
+
+	exts := customExtensions(request.Extensions)
+	for _, ext := range exts {
+		if !allowed[ext.Id.String()] {
+			return nil, fmt.Errorf("request carries extension %s, which is not listed for passthrough", ext.Id)
+		}
+	}
+	return exts, nil
+}
+
+// customExtensions returns the extensions PiCA does not set itself, so
+// they can be carried from a CA request or an old CA certificate into a new
+// CA certificate
+func customExtensions(extensions []pkix.Extension) []pkix.Extension {
+	var exts []pkix.Extension
+	for _, ext := range extensions {
+		if _, ok := managedExtensions[ext.Id.String()]; !ok {
+			exts = append(exts, ext)
+		}
+	}
+	return exts
+}
@@ -0,0 +1,227 @@
+package ca
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cloudflare/cfssl/csr"
+
+	"github.com/billchurch/PiCA/internal/crypto"
+)
+
+// findExtension returns the extension with the given dotted OID
+func findExtension(cert *x509.Certificate, oid string) *pkix.Extension {
+	for i, ext := range cert.Extensions {
+		if ext.Id.String() == oid {
+			return &cert.Extensions[i]
+		}
+	}
+	return nil
+}
+
+func TestEncodeExtension(t *testing.T) {
+	utf8, _ := asn1.MarshalWithParams("building 4", "utf8")
+	ia5, _ := asn1.MarshalWithParams("ops@example.com", "ia5")
+	integer, _ := asn1.Marshal(255)
+	oid, _ := asn1.Marshal(asn1.ObjectIdentifier{1, 2, 3})
+
+	tests := []struct {
+		ext  Extension
+		want []byte
+	}{
+		{Extension{OID: "1.3.6.1.4.1.99999.1", Type: "hex", Value: "05:00"}, []byte{0x05, 0x00}},
+		{Extension{OID: "1.3.6.1.4.1.99999.1", Type: "base64", Value: "BQA="}, []byte{0x05, 0x00}},
+		{Extension{OID: "1.3.6.1.4.1.99999.1", Type: "utf8string", Value: "building 4"}, utf8},
+		{Extension{OID: "1.3.6.1.4.1.99999.1", Type: "IA5String", Value: "ops@example.com"}, ia5},
+		{Extension{OID: "1.3.6.1.4.1.99999.1", Type: "integer", Value: "0xff"}, integer},
+		{Extension{OID: "1.3.6.1.4.1.99999.1", Type: "oid", Value: "1.2.3"}, oid},
+	}
+	for _, tt := range tests {
+		ext, err := tt.ext.Encode()
+		if err != nil {
+			t.Errorf("Encode(%+v) failed: %v", tt.ext, err)
+			continue
+		}
+		if !bytes.Equal(ext.Value, tt.want) {
+			t.Errorf("Encode(%+v) = %X, want %X", tt.ext, ext.Value, tt.want)
+		}
+	}
+
+	for _, bad := range []Extension{
+		{OID: "2.5.29.17", Type: "hex", Value: "0500"},
+		{OID: "not-an-oid", Type: "hex", Value: "0500"},
+		{OID: "1.3.6.1.4.1.99999.1", Type: "hex", Value: "050000"},
+		{OID: "1.3.6.1.4.1.99999.1", Type: "hex", Value: "zz"},
+		{OID: "1.3.6.1.4.1.99999.1", Type: "ia5string", Value: "café"},
+		{OID: "1.3.6.1.4.1.99999.1", Type: "integer", Value: "ten"},
+		{OID: "1.3.6.1.4.1.99999.1", Type: "bool", Value: "true"},
+	} {
+		if _, err := bad.Encode(); err == nil {
+			t.Errorf("Expected %+v to be rejected", bad)
+		}
+	}
+
+	dup := []Extension{
+		{OID: "1.3.6.1.4.1.99999.1", Type: "hex", Value: "0500"},
+		{OID: "1.3.6.1.4.1.99999.1", Type: "utf8string", Value: "x"},
+	}
+	if _, err := EncodeExtensions(dup); err == nil {
+		t.Error("Expected duplicate extensions to be rejected")
+	}
+}
+
+const testExtensionConfig = `{
+	"signing": {
+		"default": {"expiry": "8760h"},
+		"profiles": {
+			"device": {
+				"usages": ["digital signature", "client auth"],
+				"expiry": "8760h",
+				"pica": {
+					"extensions": [
+						{"oid": "1.3.6.1.4.1.99999.1", "type": "utf8string", "value": "building 4"},
+						{"oid": "1.3.6.1.4.1.99999.2", "critical": true, "type": "hex", "value": "0500"}
+					],
+					"passthrough_extensions": ["1.3.6.1.4.1.99999.2", "1.3.6.1.4.1.99999.3"]
+				}
+			},
+			"broken": {
+				"usages": ["digital signature"],
+				"expiry": "8760h",
+				"pica": {"extensions": [{"oid": "2.5.29.19", "type": "hex", "value": "3000"}]}
+			}
+		}
+	}
+}`
+
+func TestSignWithExtensions(t *testing.T) {
+	c := newTestCA(t)
+	if err := os.WriteFile(c.ConfigFile, []byte(testExtensionConfig), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	requested := func(oid asn1.ObjectIdentifier, value string) pkix.Extension {
+		der, _ := asn1.MarshalWithParams(value, "utf8")
+		return pkix.Extension{Id: oid, Value: der}
+	}
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject: pkix.Name{CommonName: "sensor-17"},
+		ExtraExtensions: []pkix.Extension{
+			requested(asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 99999, 2}, "from csr"),
+			requested(asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 99999, 3}, "serial 17"),
+			requested(asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 99999, 4}, "not allowed"),
+		},
+	}, key)
+	if err != nil {
+		t.Fatalf("Failed to create CSR: %v", err)
+	}
+	csrPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der})
+
+	certPEM, err := c.SignCertificate(csrPEM, "device")
+	if err != nil {
+		t.Fatalf("SignCertificate failed: %v", err)
+	}
+	cert := parsePEMCertificate(t, certPEM)
+
+	if ext := findExtension(cert, "1.3.6.1.4.1.99999.1"); ext == nil || ext.Critical {
+		t.Errorf("Expected non-critical profile extension, got %+v", ext)
+	}
+	// The profile's value wins over the requested one
+	if ext := findExtension(cert, "1.3.6.1.4.1.99999.2"); ext == nil || !ext.Critical || !bytes.Equal(ext.Value, []byte{0x05, 0x00}) {
+		t.Errorf("Expected critical profile extension, got %+v", ext)
+	}
+	serial, _ := asn1.MarshalWithParams("serial 17", "utf8")
+	if ext := findExtension(cert, "1.3.6.1.4.1.99999.3"); ext == nil || !bytes.Equal(ext.Value, serial) {
+		t.Errorf("Expected passed-through extension, got %+v", ext)
+	}
+	if ext := findExtension(cert, "1.3.6.1.4.1.99999.4"); ext != nil {
+		t.Errorf("Extension not listed for passthrough was copied: %+v", ext)
+	}
+
+	if _, err := c.SignCertificate(newTestCSR(t, "sensor-18"), "broken"); err == nil {
+		t.Error("Expected profile overriding basic constraints to be rejected")
+	}
+}
+
+func TestCAExtensions(t *testing.T) {
+	root := newTestCA(t)
+	dir := filepath.Dir(root.CertFile)
+	opts := &RequestOptions{Extensions: []Extension{
+		{OID: "1.3.6.1.4.1.99999.10", Type: "ia5string", Value: "https://pki.example.com/cps"},
+	}}
+
+	// Root certificate
+	req := &csr.CertificateRequest{
+		CN:         "Extension Root CA",
+		Names:      []csr.Name{{C: "US", O: "PiCA Test"}},
+		KeyRequest: &csr.KeyRequest{A: "ecdsa", S: 256},
+	}
+	rootFile := filepath.Join(dir, "ext-root.pem")
+	if err := GenerateRootCAWithOptions(req, opts, root.Provider, crypto.Slot(0x84), rootFile, time.Hour, -1); err != nil {
+		t.Fatalf("GenerateRootCAWithOptions failed: %v", err)
+	}
+	rootCert, _ := LoadCertificate(rootFile)
+	if findExtension(rootCert, "1.3.6.1.4.1.99999.10") == nil {
+		t.Error("Root certificate lacks the custom extension")
+	}
+
+	// Intermediate generated directly
+	req.CN = "Extension Sub CA"
+	subFile := filepath.Join(dir, "ext-sub.pem")
+	if err := GenerateIntermediateCAWithOptions(req, opts, root.Provider, root.Slot,
+		root.Provider, crypto.SlotCA2, root.CertFile, subFile, time.Hour, 0); err != nil {
+		t.Fatalf("GenerateIntermediateCAWithOptions failed: %v", err)
+	}
+	subCert, _ := LoadCertificate(subFile)
+	if findExtension(subCert, "1.3.6.1.4.1.99999.10") == nil {
+		t.Error("Sub CA certificate lacks the custom extension")
+	}
+
+	// Intermediate signed offline from a CSR carrying the extension
+	csrPEM, err := CreateCSRWithOptions(req, opts, root.Provider, crypto.SlotCA2, true)
+	if err != nil {
+		t.Fatalf("CreateCSRWithOptions failed: %v", err)
+	}
+	rootCACert, _ := LoadCertificate(root.CertFile)
+	request, err := ParseCSR(csrPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Only the custom extension is listed for review, not the managed ones
+	passthrough := []string{"1.3.6.1.4.1.99999.10"}
+	if exts, err := RequestExtensions(request, passthrough); err != nil || len(exts) != 1 || exts[0].Id.String() != "1.3.6.1.4.1.99999.10" {
+		t.Errorf("Unexpected extensions for review: %v, %v", exts, err)
+	}
+	// Extensions that are not allowed are refused rather than dropped
+	if _, err := SignIntermediateCSR(csrPEM, root.Provider, root.Slot, rootCACert, time.Hour, 0, nil); err == nil {
+		t.Error("Expected a request with an extension not listed for passthrough to be refused")
+	}
+	offline, err := SignIntermediateCSR(csrPEM, root.Provider, root.Slot, rootCACert, time.Hour, 0, passthrough)
+	if err != nil {
+		t.Fatalf("SignIntermediateCSR failed: %v", err)
+	}
+	if findExtension(offline, "1.3.6.1.4.1.99999.10") == nil {
+		t.Error("Offline-signed CA certificate lacks the custom extension")
+	}
+}
+
+func TestRequestExtensionsDuplicates(t *testing.T) {
+	// crypto/x509 refuses such CSRs when parsing, but RequestExtensions does
+	// not rely on it
+	ext := pkix.Extension{Id: asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 99999, 10}, Value: []byte{0x05, 0x00}}
+	request := &x509.CertificateRequest{Extensions: []pkix.Extension{ext, ext}}
+	if _, err := RequestExtensions(request, []string{"1.3.6.1.4.1.99999.10"}); err == nil {
+		t.Error("Expected a request with a duplicate extension to be refused")
+	}
+}
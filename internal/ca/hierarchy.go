@@ -0,0 +1,358 @@
+package ca
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/billchurch/PiCA/internal/crypto"
+)
+
+// Default lifetimes for CAs created from a hierarchy file
+const (
+	DefaultRootCAExpiry         = 10 * 365 * 24 * time.Hour
+	DefaultIntermediateCAExpiry = 5 * 365 * 24 * time.Hour
+)
+
+// ErrUnknownCA is returned when a CA name is not part of the hierarchy
+var ErrUnknownCA = errors.New("unknown CA")
+
+// CADefinition describes one named CA in a hierarchy. A CA without a parent
+// is a self-signed root; every other CA is signed by its parent.
+type CADefinition struct {
+	Name   string `json:"name"`
+	Parent string `json:"parent,omitempty"`
+
+	// Provider is the registered crypto provider type, e.g. "yubikey";
+	// when empty the default provider is used
+	Provider        string                 `json:"provider,omitempty"`
+	ProviderOptions map[string]interface{} `json:"provider_options,omitempty"`
+	// Slot is the hex key slot, e.g. "82"
+	Slot string `json:"slot"`
+
+	// PathLen limits how many CA levels may follow below this CA; nil
+	// leaves it unconstrained
+	PathLen *int `json:"pathlen,omitempty"`
+
+	Config      string `json:"config"`
+	CSR         string `json:"csr"`
+	Certificate string `json:"certificate"`
+	Expiry      string `json:"expiry,omitempty"`
+
+	DatabaseDir string   `json:"db_dir,omitempty"`
+	CRLFile     string   `json:"crl_file,omitempty"`
+	CRLURLs     []string `json:"crl_urls,omitempty"`
+}
+
+// IsRoot reports whether the CA is self-signed
+func (d *CADefinition) IsRoot() bool {
+	return d.Parent == ""
+}
+
+// KeySlot returns the parsed key slot
+func (d *CADefinition) KeySlot() (crypto.Slot, error) {
+	slot, err := strconv.ParseInt(d.Slot, 16, 64)
+	if err != nil {
+		return 0, fmt.Errorf("CA %q: invalid slot %q", d.Name, d.Slot)
+	}
+	return crypto.Slot(slot), nil
+}
+
+// ExpiryDuration returns the CA certificate lifetime
+func (d *CADefinition) ExpiryDuration() (time.Duration, error) {
+	if d.Expiry == "" {
+		if d.IsRoot() {
+			return DefaultRootCAExpiry, nil
+		}
+		return DefaultIntermediateCAExpiry, nil
+	}
+	expiry, err := time.ParseDuration(d.Expiry)
+	if err != nil {
+		return 0, fmt.Errorf("CA %q: invalid expiry: %w", d.Name, err)
+	}
+	return expiry, nil
+}
+
+// PathLength returns the path length constraint, or -1 when unconstrained
+func (d *CADefinition) PathLength() int {
+	if d.PathLen == nil {
+		return -1
+	}
+	return *d.PathLen
+}
+
+// ProviderConfig returns the options used to open the CA's provider
+func (d *CADefinition) ProviderConfig() map[string]interface{} {
+	config := map[string]interface{}{}
+	for k, v := range d.ProviderOptions {
+		config[k] = v
+	}
+	if d.Provider != "" {
+		config["type"] = d.Provider
+		// A CA must never silently fall back to software keys
+		config["fallback"] = false
+	}
+	return config
+}
+
+// DatabasePath returns the CA's db_dir, or a subdirectory named after the
+// CA in the shared databaseDir when it has none
+func (d *CADefinition) DatabasePath(databaseDir string) string {
+	if d.DatabaseDir != "" {
+		return d.DatabaseDir
+	}
+	return filepath.Join(databaseDir, d.Name)
+}
+
+// NewCA creates a CA instance for the definition using the given provider,
+// keeping its records under DatabasePath(databaseDir)
+func (d *CADefinition) NewCA(provider crypto.Provider, databaseDir string) (*CA, error) {
+	slot, err := d.KeySlot()
+	if err != nil {
+		return nil, err
+	}
+
+	caType := SubCA
+	if d.IsRoot() {
+		caType = RootCA
+	}
+
+	c := NewCAWithProvider(caType, d.Config, "", d.Certificate, provider, slot)
+	c.DatabaseDir = d.DatabasePath(databaseDir)
+	c.CRLFile = d.CRLFile
+	c.CRLDistributionPoints = d.CRLURLs
+	return c, nil
+}
+
+// Hierarchy is a set of named CAs forming one or more trees
+type Hierarchy struct {
+	CAs []*CADefinition `json:"cas"`
+}
+
+// LoadHierarchy reads and validates a hierarchy file
+func LoadHierarchy(filename string) (*Hierarchy, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read hierarchy file: %w", err)
+	}
+
+	h := &Hierarchy{}
+	if err := json.Unmarshal(data, h); err != nil {
+		return nil, fmt.Errorf("failed to parse hierarchy file: %w", err)
+	}
+
+	if err := h.Validate(); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// Save validates and writes the hierarchy file, keeping the previous
+// version as <filename>.bak
+func (h *Hierarchy) Save(filename string) error {
+	if err := h.Validate(); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(h, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode hierarchy: %w", err)
+	}
+	if old, err := os.ReadFile(filename); err == nil {
+		if err := os.WriteFile(filename+".bak", old, 0644); err != nil {
+			return fmt.Errorf("failed to back up hierarchy file: %w", err)
+		}
+	}
+	if err := os.WriteFile(filename, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write hierarchy file: %w", err)
+	}
+	return nil
+}
+
+// Validate checks names, parents, slots and path length constraints
+func (h *Hierarchy) Validate() error {
+	if len(h.CAs) == 0 {
+		return errors.New("hierarchy defines no CAs")
+	}
+
+	names := make(map[string]*CADefinition)
+	keys := make(map[string]string)
+	for _, d := range h.CAs {
+		if d.Name == "" {
+			return errors.New("every CA needs a name")
+		}
+		if _, ok := names[d.Name]; ok {
+			return fmt.Errorf("duplicate CA name %q", d.Name)
+		}
+		names[d.Name] = d
+
+		slot, err := d.KeySlot()
+		if err != nil {
+			return err
+		}
+		if _, err := d.ExpiryDuration(); err != nil {
+			return err
+		}
+		if d.Certificate == "" {
+			return fmt.Errorf("CA %q: certificate path is required", d.Name)
+		}
+		if d.PathLen != nil && *d.PathLen < 0 {
+			return fmt.Errorf("CA %q: pathlen cannot be negative", d.Name)
+		}
+		if d.Provider != "" {
+			if err := crypto.ValidateProviderConfig(crypto.ProviderType(d.Provider), d.ProviderConfig()); err != nil {
+				return fmt.Errorf("CA %q: %w", d.Name, err)
+			}
+		}
+
+		// Two CAs must not share a key
+		dir, _ := d.ProviderOptions["directory"].(string)
+		key := fmt.Sprintf("%s|%s|%x", d.Provider, dir, int(slot))
+		if other, ok := keys[key]; ok {
+			return fmt.Errorf("CAs %q and %q use the same key slot", other, d.Name)
+		}
+		keys[key] = d.Name
+	}
+
+	for _, d := range h.CAs {
+		if d.IsRoot() {
+			continue
+		}
+		parent, ok := names[d.Parent]
+		if !ok {
+			return fmt.Errorf("CA %q: unknown parent %q", d.Name, d.Parent)
+		}
+		if parent.PathLen != nil {
+			if *parent.PathLen == 0 {
+				return fmt.Errorf("CA %q: parent %q has pathlen 0 and cannot sign CAs", d.Name, parent.Name)
+			}
+			if d.PathLen == nil || *d.PathLen >= *parent.PathLen {
+				return fmt.Errorf("CA %q: pathlen must be below %d, the pathlen of %q", d.Name, *parent.PathLen, parent.Name)
+			}
+		}
+	}
+
+	// Every chain must end at a root
+	for _, d := range h.CAs {
+		seen := map[string]bool{}
+		for cur := d; !cur.IsRoot(); cur = names[cur.Parent] {
+			if seen[cur.Name] {
+				return fmt.Errorf("CA %q is part of a parent cycle", d.Name)
+			}
+			seen[cur.Name] = true
+		}
+	}
+
+	return nil
+}
+
+// Get returns the definition of a named CA
+func (h *Hierarchy) Get(name string) (*CADefinition, error) {
+	for _, d := range h.CAs {
+		if d.Name == name {
+			return d, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnknownCA, name)
+}
+
+// Children returns the CAs directly signed by the named CA
+func (h *Hierarchy) Children(name string) []*CADefinition {
+	var children []*CADefinition
+	for _, d := range h.CAs {
+		if d.Parent == name && d.Name != name {
+			children = append(children, d)
+		}
+	}
+	return children
+}
+
+// Chain returns the named CA followed by its ancestors up to the root
+func (h *Hierarchy) Chain(name string) ([]*CADefinition, error) {
+	var chain []*CADefinition
+	for name != "" {
+		d, err := h.Get(name)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, d)
+		name = d.Parent
+	}
+	return chain, nil
+}
+
+// Ordered returns every CA with parents before their children, which is
+// the order in which they have to be created
+func (h *Hierarchy) Ordered() []*CADefinition {
+	var ordered []*CADefinition
+	var visit func(parent string)
+	visit = func(parent string) {
+		for _, d := range h.CAs {
+			if d.Parent == parent && d.Name != parent {
+				ordered = append(ordered, d)
+				visit(d.Name)
+			}
+		}
+	}
+	visit("")
+	return ordered
+}
+
+// ProviderPool opens each distinct provider configuration once, so CAs that
+// live on the same device share one connection
+type ProviderPool struct {
+	// Credentials, when set, is installed on every provider the pool opens
+	Credentials crypto.CredentialCallback
+
+	providers map[string]crypto.Provider
+}
+
+// NewProviderPool creates an empty provider pool
+func NewProviderPool() *ProviderPool {
+	return &ProviderPool{providers: make(map[string]crypto.Provider)}
+}
+
+// Get returns the connected provider for a CA definition
+func (p *ProviderPool) Get(d *CADefinition) (crypto.Provider, error) {
+	config := d.ProviderConfig()
+	keyBytes, err := json.Marshal(config)
+	if err != nil {
+		return nil, fmt.Errorf("CA %q: invalid provider options: %w", d.Name, err)
+	}
+	key := string(keyBytes)
+
+	if provider, ok := p.providers[key]; ok {
+		return provider, nil
+	}
+
+	var provider crypto.Provider
+	if d.Provider == "" {
+		provider, err = crypto.CreateDefaultProvider()
+	} else {
+		provider, err = crypto.CreateProviderFromConfig(config)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("CA %q: failed to open provider: %w", d.Name, err)
+	}
+
+	if p.Credentials != nil {
+		crypto.SetCredentials(provider, p.Credentials)
+	}
+	p.providers[key] = provider
+	return provider, nil
+}
+
+// Close closes every provider opened by the pool
+func (p *ProviderPool) Close() error {
+	var firstErr error
+	for key, provider := range p.providers {
+		if err := provider.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		delete(p.providers, key)
+	}
+	return firstErr
+}
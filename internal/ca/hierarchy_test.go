@@ -0,0 +1,107 @@
+package ca
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestHierarchyValidate(t *testing.T) {
+	zero, one := 0, 1
+	tests := []struct {
+		name    string
+		cas     []*CADefinition
+		wantErr string
+	}{
+		{
+			name: "valid",
+			cas: []*CADefinition{
+				{Name: "root", Slot: "82", Certificate: "root.pem"},
+				{Name: "policy", Parent: "root", Slot: "83", Certificate: "policy.pem", PathLen: &one},
+				{Name: "issuing", Parent: "policy", Slot: "84", Certificate: "issuing.pem", PathLen: &zero},
+			},
+		},
+		{
+			name: "unknown parent",
+			cas: []*CADefinition{
+				{Name: "issuing", Parent: "missing", Slot: "84", Certificate: "issuing.pem"},
+			},
+			wantErr: "unknown parent",
+		},
+		{
+			name: "cycle",
+			cas: []*CADefinition{
+				{Name: "a", Parent: "b", Slot: "82", Certificate: "a.pem"},
+				{Name: "b", Parent: "a", Slot: "83", Certificate: "b.pem"},
+			},
+			wantErr: "cycle",
+		},
+		{
+			name: "parent pathlen zero",
+			cas: []*CADefinition{
+				{Name: "root", Slot: "82", Certificate: "root.pem", PathLen: &zero},
+				{Name: "issuing", Parent: "root", Slot: "83", Certificate: "issuing.pem"},
+			},
+			wantErr: "pathlen 0",
+		},
+		{
+			name: "child pathlen too large",
+			cas: []*CADefinition{
+				{Name: "root", Slot: "82", Certificate: "root.pem", PathLen: &one},
+				{Name: "issuing", Parent: "root", Slot: "83", Certificate: "issuing.pem", PathLen: &one},
+			},
+			wantErr: "must be below",
+		},
+		{
+			name: "shared slot",
+			cas: []*CADefinition{
+				{Name: "root", Slot: "82", Certificate: "root.pem"},
+				{Name: "issuing", Parent: "root", Slot: "82", Certificate: "issuing.pem"},
+			},
+			wantErr: "same key slot",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := (&Hierarchy{CAs: tt.cas}).Validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("Unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("Expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+
+	h := &Hierarchy{CAs: tests[0].cas}
+	chain, err := h.Chain("issuing")
+	if err != nil || len(chain) != 3 || chain[2].Name != "root" {
+		t.Errorf("Unexpected chain: %v, %v", chain, err)
+	}
+	if ordered := h.Ordered(); ordered[0].Name != "root" || ordered[2].Name != "issuing" {
+		t.Errorf("Unexpected order: %v", ordered)
+	}
+}
+
+func TestCADefinitionNewCADatabase(t *testing.T) {
+	shared := &CADefinition{Name: "policy", Parent: "root", Slot: "83", Certificate: "policy.pem"}
+	c, err := shared.NewCA(nil, "db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join("db", "policy"); c.DatabaseDir != want {
+		t.Errorf("Expected database %s, got %s", want, c.DatabaseDir)
+	}
+
+	own := &CADefinition{Name: "servers", Parent: "root", Slot: "84", Certificate: "servers.pem", DatabaseDir: "db/servers-ca"}
+	if c, err = own.NewCA(nil, "db"); err != nil {
+		t.Fatal(err)
+	}
+	if c.DatabaseDir != "db/servers-ca" {
+		t.Errorf("Expected the CA's own db_dir, got %s", c.DatabaseDir)
+	}
+}
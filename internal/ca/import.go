@@ -0,0 +1,377 @@
+package ca
+
+import (
+	"bytes"
+	gocrypto "crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"hash"
+	"os"
+
+	"golang.org/x/crypto/pbkdf2"
+	"software.sslmate.com/src/go-pkcs12"
+
+	"github.com/billchurch/PiCA/internal/crypto"
+)
+
+// ErrPasswordRequired is returned when key material is encrypted and no
+// password was supplied
+var ErrPasswordRequired = errors.New("key is encrypted and requires a password")
+
+// KeyMaterial is an existing CA key with its certificate and chain, as
+// exported from OpenSSL or another CA
+type KeyMaterial struct {
+	Key         gocrypto.Signer
+	Certificate *x509.Certificate
+	Chain       []*x509.Certificate
+}
+
+// LoadKeyMaterial reads a private key, certificate and optional chain. The
+// key file may be PEM (PKCS#1, SEC1, PKCS#8 or encrypted PKCS#8), DER or
+// PKCS#12; for PKCS#12 the certificate and chain are taken from the bundle
+// unless certFile is given. certFile may hold the certificate followed by
+// its chain.
+func LoadKeyMaterial(keyFile, certFile, chainFile string, password []byte) (*KeyMaterial, error) {
+	keyData, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key file: %w", err)
+	}
+
+	material := &KeyMaterial{}
+	if isPKCS12(keyData) {
+		if material, err = ParsePKCS12(keyData, password); err != nil {
+			return nil, err
+		}
+	} else if material.Key, err = ParsePrivateKey(keyData, password); err != nil {
+		return nil, err
+	}
+
+	if certFile != "" {
+		certs, err := readCertificates(certFile)
+		if err != nil {
+			return nil, err
+		}
+		material.Certificate = certs[0]
+		material.Chain = certs[1:]
+	}
+	if material.Certificate == nil {
+		return nil, errors.New("no certificate provided")
+	}
+
+	if chainFile != "" {
+		chain, err := readCertificates(chainFile)
+		if err != nil {
+			return nil, err
+		}
+		material.Chain = append(material.Chain, chain...)
+	}
+
+	return material, nil
+}
+
+// Validate checks that the key belongs to the certificate, that the
+// certificate is a CA and that each chain entry issued the one before it
+func (m *KeyMaterial) Validate() error {
+	if !publicKeysMatch(m.Key.Public(), m.Certificate.PublicKey) {
+		return errors.New("private key does not match certificate")
+	}
+	if !m.Certificate.IsCA {
+		return errors.New("certificate is not a CA certificate")
+	}
+
+	child := m.Certificate
+	for _, parent := range m.Chain {
+		if err := child.CheckSignatureFrom(parent); err != nil {
+			return fmt.Errorf("chain is broken at %q: %w", child.Subject.CommonName, err)
+		}
+		child = parent
+	}
+	return nil
+}
+
+// ImportCA loads validated key material into a provider slot and writes the
+// certificate and chain to certFile. An existing key in the slot is only
+// replaced when overwrite is set.
+func ImportCA(m *KeyMaterial, provider crypto.Provider, slot crypto.Slot, certFile string, overwrite bool) error {
+	if err := m.Validate(); err != nil {
+		return err
+	}
+
+	if _, err := provider.GetPublicKey(slot); err == nil && !overwrite {
+		return fmt.Errorf("slot %x already holds a key", int(slot))
+	}
+
+	if err := crypto.ImportKey(provider, slot, m.Key); err != nil {
+		return fmt.Errorf("failed to import key: %w", err)
+	}
+
+	// Make sure the provider now signs with the imported key
+	pub, err := provider.GetPublicKey(slot)
+	if err != nil {
+		return fmt.Errorf("failed to read imported key: %w", err)
+	}
+	if !publicKeysMatch(pub, m.Certificate.PublicKey) {
+		return errors.New("provider key does not match certificate after import")
+	}
+
+	if err := provider.ImportCertificate(slot, m.Certificate); err != nil {
+		return fmt.Errorf("failed to import certificate: %w", err)
+	}
+
+	var buf bytes.Buffer
+	for _, cert := range append([]*x509.Certificate{m.Certificate}, m.Chain...) {
+		pem.Encode(&buf, &pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+	}
+	return writeFileAtomic(certFile, buf.Bytes(), 0644)
+}
+
+// ParsePKCS12 decodes a PKCS#12 bundle holding a key, certificate and chain
+func ParsePKCS12(data, password []byte) (*KeyMaterial, error) {
+	key, cert, chain, err := pkcs12.DecodeChain(data, string(password))
+	if err != nil {
+		if errors.Is(err, pkcs12.ErrIncorrectPassword) && len(password) == 0 {
+			return nil, ErrPasswordRequired
+		}
+		return nil, fmt.Errorf("failed to decode PKCS#12: %w", err)
+	}
+
+	signer, ok := key.(gocrypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported key type: %T", key)
+	}
+	return &KeyMaterial{Key: signer, Certificate: cert, Chain: chain}, nil
+}
+
+// ParsePrivateKey decodes a PEM or DER private key, decrypting it with
+// password when needed
+func ParsePrivateKey(data, password []byte) (gocrypto.Signer, error) {
+	der := data
+	if block, _ := pem.Decode(data); block != nil {
+		der = block.Bytes
+		switch {
+		case block.Type == "ENCRYPTED PRIVATE KEY":
+			if len(password) == 0 {
+				return nil, ErrPasswordRequired
+			}
+			var err error
+			if der, err = decryptPKCS8(block.Bytes, password); err != nil {
+				return nil, err
+			}
+		case x509.IsEncryptedPEMBlock(block): // legacy OpenSSL "Proc-Type: 4,ENCRYPTED" keys
+			if len(password) == 0 {
+				return nil, ErrPasswordRequired
+			}
+			var err error
+			if der, err = x509.DecryptPEMBlock(block, password); err != nil {
+				return nil, fmt.Errorf("failed to decrypt key: %w", err)
+			}
+		}
+	}
+
+	var key interface{}
+	var err error
+	if key, err = x509.ParsePKCS8PrivateKey(der); err != nil {
+		if key, err = x509.ParsePKCS1PrivateKey(der); err != nil {
+			if key, err = x509.ParseECPrivateKey(der); err != nil {
+				return nil, errors.New("failed to parse private key")
+			}
+		}
+	}
+
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		return k, nil
+	case *ecdsa.PrivateKey:
+		return k, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type: %T", key)
+	}
+}
+
+// ParseCertificates decodes one or more PEM or DER certificates
+func ParseCertificates(data []byte) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	rest := data
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse certificate: %w", err)
+		}
+		certs = append(certs, cert)
+	}
+
+	if len(certs) == 0 {
+		parsed, err := x509.ParseCertificates(data)
+		if err != nil || len(parsed) == 0 {
+			return nil, errors.New("no certificates found")
+		}
+		certs = parsed
+	}
+	return certs, nil
+}
+
+// readCertificates reads and parses a certificate file
+func readCertificates(filename string) ([]*x509.Certificate, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read certificate file: %w", err)
+	}
+	certs, err := ParseCertificates(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	return certs, nil
+}
+
+// isPKCS12 reports whether data looks like a DER PKCS#12 PFX rather than a
+// PEM or DER private key
+func isPKCS12(data []byte) bool {
+	if block, _ := pem.Decode(data); block != nil {
+		return false
+	}
+	var pfx struct {
+		Version  int
+		AuthSafe asn1.RawValue
+		MacData  asn1.RawValue `asn1:"optional"`
+	}
+	if _, err := asn1.Unmarshal(data, &pfx); err != nil {
+		return false
+	}
+	return pfx.Version == 3
+}
+
+// publicKeysMatch compares two public keys
+func publicKeysMatch(a, b gocrypto.PublicKey) bool {
+	k, ok := a.(interface{ Equal(gocrypto.PublicKey) bool })
+	return ok && k.Equal(b)
+}
+
+var (
+	oidPBES2          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 13}
+	oidPBKDF2         = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 12}
+	oidHMACWithSHA1   = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 7}
+	oidHMACWithSHA256 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 9}
+	oidHMACWithSHA512 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 11}
+	oidAES128CBC      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 2}
+	oidAES192CBC      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 22}
+	oidAES256CBC      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+	oidDESEDE3CBC     = asn1.ObjectIdentifier{1, 2, 840, 113549, 3, 7}
+)
+
+type encryptedPrivateKeyInfo struct {
+	Algorithm     pkix.AlgorithmIdentifier
+	EncryptedData []byte
+}
+
+type pbes2Params struct {
+	KeyDerivationFunc pkix.AlgorithmIdentifier
+	EncryptionScheme  pkix.AlgorithmIdentifier
+}
+
+type pbkdf2Params struct {
+	Salt           []byte
+	IterationCount int
+	KeyLength      int                      `asn1:"optional"`
+	PRF            pkix.AlgorithmIdentifier `asn1:"optional"`
+}
+
+// decryptPKCS8 decrypts a PBES2 encrypted PKCS#8 key as written by
+// `openssl pkcs8 -topk8` and `openssl genpkey -aes256`
+func decryptPKCS8(der, password []byte) ([]byte, error) {
+	var info encryptedPrivateKeyInfo
+	if _, err := asn1.Unmarshal(der, &info); err != nil {
+		return nil, fmt.Errorf("failed to parse encrypted key: %w", err)
+	}
+	if !info.Algorithm.Algorithm.Equal(oidPBES2) {
+		return nil, fmt.Errorf("unsupported key encryption: %v", info.Algorithm.Algorithm)
+	}
+
+	var params pbes2Params
+	if _, err := asn1.Unmarshal(info.Algorithm.Parameters.FullBytes, &params); err != nil {
+		return nil, fmt.Errorf("failed to parse PBES2 parameters: %w", err)
+	}
+	if !params.KeyDerivationFunc.Algorithm.Equal(oidPBKDF2) {
+		return nil, fmt.Errorf("unsupported key derivation: %v", params.KeyDerivationFunc.Algorithm)
+	}
+
+	var kdf pbkdf2Params
+	if _, err := asn1.Unmarshal(params.KeyDerivationFunc.Parameters.FullBytes, &kdf); err != nil {
+		return nil, fmt.Errorf("failed to parse PBKDF2 parameters: %w", err)
+	}
+
+	var prf func() hash.Hash
+	switch {
+	case len(kdf.PRF.Algorithm) == 0, kdf.PRF.Algorithm.Equal(oidHMACWithSHA1):
+		prf = sha1.New
+	case kdf.PRF.Algorithm.Equal(oidHMACWithSHA256):
+		prf = sha256.New
+	case kdf.PRF.Algorithm.Equal(oidHMACWithSHA512):
+		prf = sha512.New
+	default:
+		return nil, fmt.Errorf("unsupported PBKDF2 hash: %v", kdf.PRF.Algorithm)
+	}
+
+	var keyLen int
+	var newCipher func([]byte) (cipher.Block, error)
+	scheme := params.EncryptionScheme.Algorithm
+	switch {
+	case scheme.Equal(oidAES128CBC):
+		keyLen, newCipher = 16, aes.NewCipher
+	case scheme.Equal(oidAES192CBC):
+		keyLen, newCipher = 24, aes.NewCipher
+	case scheme.Equal(oidAES256CBC):
+		keyLen, newCipher = 32, aes.NewCipher
+	case scheme.Equal(oidDESEDE3CBC):
+		keyLen, newCipher = 24, des.NewTripleDESCipher
+	default:
+		return nil, fmt.Errorf("unsupported key cipher: %v", scheme)
+	}
+
+	var iv []byte
+	if _, err := asn1.Unmarshal(params.EncryptionScheme.Parameters.FullBytes, &iv); err != nil {
+		return nil, fmt.Errorf("failed to parse cipher parameters: %w", err)
+	}
+
+	block, err := newCipher(pbkdf2.Key(password, kdf.Salt, kdf.IterationCount, keyLen, prf))
+	if err != nil {
+		return nil, err
+	}
+	if len(iv) != block.BlockSize() || len(info.EncryptedData)%block.BlockSize() != 0 || len(info.EncryptedData) == 0 {
+		return nil, errors.New("malformed encrypted key")
+	}
+
+	plain := make([]byte, len(info.EncryptedData))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(plain, info.EncryptedData)
+
+	// A wrong password almost always shows up as invalid padding
+	pad := int(plain[len(plain)-1])
+	if pad == 0 || pad > block.BlockSize() {
+		return nil, errors.New("failed to decrypt key: incorrect password")
+	}
+	for _, b := range plain[len(plain)-pad:] {
+		if int(b) != pad {
+			return nil, errors.New("failed to decrypt key: incorrect password")
+		}
+	}
+	return plain[:len(plain)-pad], nil
+}
@@ -0,0 +1,82 @@
+package ca
+
+import (
+	"encoding/binary"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// OpenSSH KRL format constants, see PROTOCOL.krl in the OpenSSH sources
+const (
+	krlMagic         = 0x5353484b524c0a00
+	krlFormatVersion = 1
+
+	krlSectionCertificates = 1
+	krlCertSerialList      = 0x20
+	krlCertKeyID           = 0x23
+)
+
+// KRL is an OpenSSH key revocation list for certificates issued by one CA,
+// as read by sshd's RevokedKeys option and `ssh-keygen -Q`
+type KRL struct {
+	Version   uint64
+	Generated time.Time
+	Comment   string
+	// CAKey is the key of the CA that issued the revoked certificates
+	CAKey ssh.PublicKey
+	// Serials are the revoked serial numbers in ascending order
+	Serials []uint64
+	// KeyIDs revoke every certificate carrying one of these key IDs
+	KeyIDs []string
+}
+
+// Marshal encodes the KRL in the binary OpenSSH format
+func (k *KRL) Marshal() []byte {
+	generated := k.Generated
+	if generated.IsZero() {
+		generated = time.Now()
+	}
+
+	var b []byte
+	b = binary.BigEndian.AppendUint64(b, krlMagic)
+	b = binary.BigEndian.AppendUint32(b, krlFormatVersion)
+	b = binary.BigEndian.AppendUint64(b, k.Version)
+	b = binary.BigEndian.AppendUint64(b, uint64(generated.Unix()))
+	b = binary.BigEndian.AppendUint64(b, 0) // flags
+	b = appendSSHString(b, nil)             // reserved
+	b = appendSSHString(b, []byte(k.Comment))
+
+	if k.CAKey == nil || (len(k.Serials) == 0 && len(k.KeyIDs) == 0) {
+		return b
+	}
+
+	var certs []byte
+	certs = appendSSHString(certs, k.CAKey.Marshal())
+	certs = appendSSHString(certs, nil) // reserved
+	if len(k.Serials) > 0 {
+		var list []byte
+		for _, serial := range k.Serials {
+			list = binary.BigEndian.AppendUint64(list, serial)
+		}
+		certs = append(certs, krlCertSerialList)
+		certs = appendSSHString(certs, list)
+	}
+	if len(k.KeyIDs) > 0 {
+		var ids []byte
+		for _, id := range k.KeyIDs {
+			ids = appendSSHString(ids, []byte(id))
+		}
+		certs = append(certs, krlCertKeyID)
+		certs = appendSSHString(certs, ids)
+	}
+
+	b = append(b, krlSectionCertificates)
+	return appendSSHString(b, certs)
+}
+
+// appendSSHString appends s as an SSH wire-format string
+func appendSSHString(b, s []byte) []byte {
+	b = binary.BigEndian.AppendUint32(b, uint32(len(s)))
+	return append(b, s...)
+}
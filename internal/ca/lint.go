@@ -0,0 +1,325 @@
+package ca
+
+import (
+	gocrypto "crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+
+	zx509 "github.com/zmap/zcrypto/x509"
+	"github.com/zmap/zlint/v3"
+	"github.com/zmap/zlint/v3/lint"
+
+	"github.com/billchurch/PiCA/internal/audit"
+)
+
+// Lint stages
+const (
+	LintStagePre  = "pre-signing"
+	LintStagePost = "post-signing"
+)
+
+// LintOptions configure zlint checks of issued certificates. They are set
+// per profile in the "pica" object under "lint".
+type LintOptions struct {
+	// FailOn is the lowest severity that stops issuance: notice, warn,
+	// error, fatal (the default), or none to only report findings
+	FailOn string `json:"fail_on,omitempty"`
+	// Skip lists lint names that are not run
+	Skip []string `json:"skip,omitempty"`
+	// ExcludeSources lists lint sources that are not run, such as CABF_BR
+	// or Mozilla for a private PKI
+	ExcludeSources []string `json:"exclude_sources,omitempty"`
+}
+
+// DefaultLintOptions apply to CA certificates and to profiles without lint
+// settings. Only fatal findings block issuance by default, as many CA/B
+// Forum lints report errors for certificates of a private PKI.
+var DefaultLintOptions = LintOptions{FailOn: "fatal"}
+
+// LintFinding is a single lint that did not pass
+type LintFinding struct {
+	Stage    string `json:"stage"`
+	Lint     string `json:"lint"`
+	Severity string `json:"severity"`
+	Source   string `json:"source,omitempty"`
+	Details  string `json:"details,omitempty"`
+}
+
+// LintReport holds the findings of both lint stages of one certificate
+type LintReport struct {
+	Subject  string        `json:"subject"`
+	Serial   string        `json:"serial,omitempty"`
+	FailOn   string        `json:"failOn"`
+	Findings []LintFinding `json:"findings"`
+	Failed   bool          `json:"failed"`
+
+	threshold lint.LintStatus
+}
+
+// LintError is returned when a certificate has findings at or above the
+// fail-on severity
+type LintError struct {
+	Report *LintReport
+}
+
+func (e *LintError) Error() string {
+	var msgs []string
+	for _, f := range e.Report.blocking() {
+		msgs = append(msgs, fmt.Sprintf("%s (%s)", f.Lint, f.Severity))
+	}
+	return fmt.Sprintf("certificate for %q failed linting: %s", e.Report.Subject, strings.Join(msgs, ", "))
+}
+
+// severities maps fail-on names to zlint statuses
+var severities = map[string]lint.LintStatus{
+	"notice": lint.Notice,
+	"info":   lint.Notice,
+	"warn":   lint.Warn,
+	"error":  lint.Error,
+	"fatal":  lint.Fatal,
+}
+
+// Validate checks the lint options
+func (o *LintOptions) Validate() error {
+	if _, err := o.threshold(); err != nil {
+		return err
+	}
+	_, err := o.registry()
+	return err
+}
+
+// threshold returns the fail-on status; Reserved means never fail
+func (o *LintOptions) threshold() (lint.LintStatus, error) {
+	name := strings.ToLower(o.FailOn)
+	switch name {
+	case "":
+		return lint.Fatal, nil
+	case "none":
+		return lint.Reserved, nil
+	}
+	status, ok := severities[name]
+	if !ok {
+		return 0, fmt.Errorf("unknown lint severity %q", o.FailOn)
+	}
+	return status, nil
+}
+
+// registry returns the lints selected by the options
+func (o *LintOptions) registry() (lint.Registry, error) {
+	if len(o.Skip) == 0 && len(o.ExcludeSources) == 0 {
+		return lint.GlobalRegistry(), nil
+	}
+	var sources lint.SourceList
+	for _, s := range o.ExcludeSources {
+		sources = append(sources, lint.LintSource(s))
+	}
+	registry, err := lint.GlobalRegistry().Filter(lint.FilterOptions{
+		ExcludeNames:   o.Skip,
+		ExcludeSources: sources,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid lint options: %w", err)
+	}
+	return registry, nil
+}
+
+// LintCertificate runs zlint on a DER-encoded certificate and returns the
+// lints that did not pass
+func LintCertificate(der []byte, opts *LintOptions, stage string) ([]LintFinding, error) {
+	cert, err := zx509.ParseCertificate(der)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse certificate for linting: %w", err)
+	}
+	registry, err := opts.registry()
+	if err != nil {
+		return nil, err
+	}
+
+	results := zlint.LintCertificateEx(cert, registry)
+	var findings []LintFinding
+	for name, result := range results.Results {
+		if result.Status < lint.Notice {
+			continue
+		}
+		finding := LintFinding{
+			Stage:    stage,
+			Lint:     name,
+			Severity: result.Status.String(),
+			Details:  result.Details,
+		}
+		if l := registry.CertificateLints().ByName(name); l != nil {
+			finding.Source = string(l.Source)
+			if finding.Details == "" {
+				finding.Details = l.Description
+			}
+		}
+		findings = append(findings, finding)
+	}
+	sort.Slice(findings, func(i, j int) bool { return findings[i].Lint < findings[j].Lint })
+	return findings, nil
+}
+
+// Print writes the findings of the report, one per line
+func (r *LintReport) Print(w io.Writer) {
+	if len(r.Findings) == 0 {
+		fmt.Fprintf(w, "Lint: no findings for %q\n", r.Subject)
+		return
+	}
+	fmt.Fprintf(w, "Lint findings for %q (fail on %s):\n", r.Subject, r.FailOn)
+	for _, f := range r.Findings {
+		fmt.Fprintf(w, "  %-6s %-13s %s: %s\n", f.Severity, f.Stage, f.Lint, f.Details)
+	}
+}
+
+// newLintReport starts a report for a certificate about to be issued
+func newLintReport(subject string, opts *LintOptions) (*LintReport, error) {
+	threshold, err := opts.threshold()
+	if err != nil {
+		return nil, err
+	}
+	failOn := strings.ToLower(opts.FailOn)
+	if failOn == "" {
+		failOn = "fatal"
+	}
+	return &LintReport{Subject: subject, FailOn: failOn, Findings: []LintFinding{}, threshold: threshold}, nil
+}
+
+// add records findings and marks the report failed if any is blocking
+func (r *LintReport) add(findings []LintFinding) {
+	r.Findings = append(r.Findings, findings...)
+	r.Failed = len(r.blocking()) > 0
+}
+
+// blocking returns the findings at or above the fail-on severity
+func (r *LintReport) blocking() []LintFinding {
+	if r.threshold == lint.Reserved {
+		return nil
+	}
+	var blocking []LintFinding
+	for _, f := range r.Findings {
+		if lint.StatusLabelToLintStatus[f.Severity] >= r.threshold {
+			blocking = append(blocking, f)
+		}
+	}
+	return blocking
+}
+
+// lintBeforeSigning runs the pre-signing stage. It returns a LintError if
+// the certificate must not be signed.
+func lintBeforeSigning(template, parent *x509.Certificate, pub, issuerPub interface{}, opts *LintOptions) (*LintReport, error) {
+	report, err := newLintReport(template.Subject.CommonName, opts)
+	if err != nil {
+		return nil, err
+	}
+	findings, err := lintTemplate(template, parent, pub, issuerPub, opts)
+	if err != nil {
+		return nil, err
+	}
+	report.add(findings)
+	if report.Failed {
+		return report, &LintError{Report: report}
+	}
+	return report, nil
+}
+
+// lintAfterSigning runs the post-signing stage on the issued certificate.
+// It returns a LintError if the certificate must not be released.
+func (r *LintReport) lintAfterSigning(der []byte, opts *LintOptions) error {
+	findings, err := LintCertificate(der, opts, LintStagePost)
+	if err != nil {
+		return err
+	}
+	r.add(findings)
+	if r.Failed {
+		return &LintError{Report: r}
+	}
+	return nil
+}
+
+// auditLint records a lint report in the audit log
+func auditLint(eventType string, report *LintReport, caName, profile string) {
+	if report == nil {
+		return
+	}
+	audit.Record(audit.Event{
+		Type:    eventType,
+		CA:      caName,
+		Subject: report.Subject,
+		Serial:  report.Serial,
+		Profile: profile,
+		Details: map[string]interface{}{
+			"lint": report,
+		},
+	})
+}
+
+// lintTemplate lints a certificate before it is signed by creating it with
+// a throwaway key of the same type as the issuer's. For a self-signed
+// certificate parent is nil and the subject key is replaced as well.
+func lintTemplate(template, parent *x509.Certificate, pub interface{}, issuerPub interface{}, opts *LintOptions) ([]LintFinding, error) {
+	throwaway, err := throwawayKey(issuerPub)
+	if err != nil {
+		return nil, err
+	}
+
+	issuer := template
+	if parent != nil {
+		issuer = new(x509.Certificate)
+		*issuer = *parent
+		issuer.PublicKey = throwaway.Public()
+	} else {
+		pub = throwaway.Public()
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, issuer, pub, throwaway)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create certificate for linting: %w", err)
+	}
+	return LintCertificate(der, opts, LintStagePre)
+}
+
+// throwawayKeys caches throwaway keys by type and size, as large RSA keys
+// are slow to generate
+var throwawayKeys sync.Map
+
+// throwawayKey returns a key matching the type and size of pub
+func throwawayKey(pub interface{}) (gocrypto.Signer, error) {
+	var id string
+	var generate func() (gocrypto.Signer, error)
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		id = fmt.Sprintf("rsa-%d", k.N.BitLen())
+		generate = func() (gocrypto.Signer, error) { return rsa.GenerateKey(rand.Reader, k.N.BitLen()) }
+	case *ecdsa.PublicKey:
+		id = "ecdsa-" + k.Curve.Params().Name
+		generate = func() (gocrypto.Signer, error) { return ecdsa.GenerateKey(k.Curve, rand.Reader) }
+	case ed25519.PublicKey:
+		id = "ed25519"
+		generate = func() (gocrypto.Signer, error) {
+			_, priv, err := ed25519.GenerateKey(rand.Reader)
+			return priv, err
+		}
+	default:
+		id = "ecdsa-P-256"
+		generate = func() (gocrypto.Signer, error) { return ecdsa.GenerateKey(elliptic.P256(), rand.Reader) }
+	}
+
+	if key, ok := throwawayKeys.Load(id); ok {
+		return key.(gocrypto.Signer), nil
+	}
+	key, err := generate()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate throwaway key: %w", err)
+	}
+	throwawayKeys.Store(id, key)
+	return key, nil
+}
@@ -0,0 +1,94 @@
+package ca
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/billchurch/PiCA/internal/audit"
+)
+
+// lintConfig returns a signing config whose server profile lints with opts
+func lintConfig(failOn string) string {
+	return `{
+	"signing": {
+		"default": {"expiry": "8760h"},
+		"profiles": {
+			"server": {
+				"usages": ["signing", "key encipherment", "server auth"],
+				"expiry": "8760h",
+				"pica": {"lint": {"fail_on": "` + failOn + `", "skip": ["w_ct_sct_policy_count_unsatisfied"]}}
+			}
+		}
+	}
+}`
+}
+
+func TestLintOnIssuance(t *testing.T) {
+	c := newTestCA(t)
+
+	auditPath := filepath.Join(t.TempDir(), "audit.log")
+	auditLog, err := audit.Open(auditPath)
+	if err != nil {
+		t.Fatalf("Failed to open audit log: %v", err)
+	}
+	audit.SetDefault(auditLog)
+	defer audit.SetDefault(nil)
+
+	// Findings are reported but only block issuance at the fail-on severity
+	if err := os.WriteFile(c.ConfigFile, []byte(lintConfig("fatal")), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	certPEM, report, err := c.SignCertificateWithReport(newTestCSR(t, "www.example.com", "www.example.com"), "server")
+	if err != nil {
+		t.Fatalf("SignCertificate failed: %v", err)
+	}
+	if report == nil || report.Failed || report.Serial == "" {
+		t.Fatalf("Unexpected report: %+v", report)
+	}
+	stages := map[string]bool{}
+	for _, f := range report.Findings {
+		stages[f.Stage] = true
+		if f.Lint == "w_ct_sct_policy_count_unsatisfied" {
+			t.Errorf("Skipped lint was run")
+		}
+	}
+	if !stages[LintStagePre] || !stages[LintStagePost] {
+		t.Errorf("Expected findings from both stages: %+v", report.Findings)
+	}
+	if cert := parsePEMCertificate(t, certPEM); report.Serial != fmt.Sprintf("%X", cert.SerialNumber) {
+		t.Errorf("Report serial %s does not match certificate", report.Serial)
+	}
+
+	// The server profile's key usage is an error for ECDSA keys
+	if err := os.WriteFile(c.ConfigFile, []byte(lintConfig("error")), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	_, report, err = c.SignCertificateWithReport(newTestCSR(t, "api.example.com", "api.example.com"), "server")
+	var lintErr *LintError
+	if !errors.As(err, &lintErr) || !report.Failed || report.Serial != "" {
+		t.Fatalf("Expected pre-signing lint failure, got %v (%+v)", err, report)
+	}
+	store, err := c.Store()
+	if err != nil {
+		t.Fatalf("Failed to open store: %v", err)
+	}
+	if records := store.List(); len(records) != 1 {
+		t.Errorf("Rejected certificate was recorded: %d records", len(records))
+	}
+
+	events, err := audit.Read(auditPath)
+	if err != nil {
+		t.Fatalf("Failed to read audit log: %v", err)
+	}
+	if len(events) != 2 || events[0].Type != audit.EventIssued || events[1].Type != audit.EventRejected ||
+		events[0].Details["lint"] == nil {
+		t.Errorf("Unexpected audit events: %+v", events)
+	}
+
+	if err := (&LintOptions{FailOn: "sometimes"}).Validate(); err == nil {
+		t.Error("Expected unknown severity to be rejected")
+	}
+}
@@ -0,0 +1,174 @@
+package ca
+
+import (
+	"bufio"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// IndexImportResult summarises an OpenSSL index.txt import
+type IndexImportResult struct {
+	Imported int
+	Revoked  int
+	Skipped  int
+}
+
+// ImportOpenSSLIndex copies the issuance and revocation history from an
+// OpenSSL `ca` database (index.txt) into the store. When certsDir is set,
+// issued certificates are read from <certsDir>/<SERIAL>.pem to fill in the
+// validity period and DNS names. Serial numbers already in the store are
+// left untouched. The records are saved in one write, so a malformed index
+// imports nothing.
+func ImportOpenSSLIndex(store *Store, indexFile, certsDir string) (*IndexImportResult, error) {
+	f, err := os.Open(indexFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open index file: %w", err)
+	}
+	defer f.Close()
+
+	result := &IndexImportResult{}
+	var records []*CertificateRecord
+	seen := make(map[string]bool)
+	scanner := bufio.NewScanner(f)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		record, err := parseIndexLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", indexFile, lineNo, err)
+		}
+
+		if _, err := store.Get(record.SerialNumber); err == nil || seen[record.SerialNumber] {
+			result.Skipped++
+			continue
+		}
+		seen[record.SerialNumber] = true
+
+		if certsDir != "" {
+			// parseIndexLine checked the field count
+			fields := strings.Split(line, "\t")
+			enrichFromCertificate(record, certsDir, fields[3], fields[4])
+		}
+
+		records = append(records, record)
+		result.Imported++
+		if record.Status == StatusRevoked {
+			result.Revoked++
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read index file: %w", err)
+	}
+
+	if err := store.AddAll(records); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// parseIndexLine parses one tab-separated index.txt entry:
+// status, expiry, revocation[,reason], serial, filename, subject
+func parseIndexLine(line string) (*CertificateRecord, error) {
+	fields := strings.Split(line, "\t")
+	if len(fields) != 6 {
+		return nil, fmt.Errorf("expected 6 fields, got %d", len(fields))
+	}
+
+	notAfter, err := parseOpenSSLTime(fields[1])
+	if err != nil {
+		return nil, fmt.Errorf("invalid expiry date: %w", err)
+	}
+
+	record := &CertificateRecord{
+		SerialNumber: NormalizeSerial(fields[3]),
+		Subject:      subjectCommonName(fields[5]),
+		NotAfter:     notAfter,
+		Status:       StatusValid,
+		Metadata:     map[string]string{"imported_from": "openssl", "openssl_subject": fields[5]},
+	}
+
+	switch fields[0] {
+	case "V", "E":
+	case "R":
+		revocation := strings.Split(fields[2], ",")
+		revokedAt, err := parseOpenSSLTime(revocation[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid revocation date: %w", err)
+		}
+		record.Status = StatusRevoked
+		record.RevokedAt = revokedAt
+		if len(revocation) > 1 {
+			// Reasons PiCA cannot express in its CRLs are kept as unspecified
+			if _, err := ParseRevocationReason(revocation[1]); err == nil {
+				record.RevocationReason = revocation[1]
+			}
+		}
+	default:
+		return nil, fmt.Errorf("unknown status %q", fields[0])
+	}
+
+	return record, nil
+}
+
+// parseOpenSSLTime parses the UTCTime or GeneralizedTime strings used in
+// index.txt
+func parseOpenSSLTime(value string) (time.Time, error) {
+	switch len(value) {
+	case 13:
+		return time.Parse("060102150405Z", value)
+	case 15:
+		return time.Parse("20060102150405Z", value)
+	default:
+		return time.Time{}, errors.New(value)
+	}
+}
+
+// subjectCommonName extracts the CN from an OpenSSL one-line subject such as
+// /C=US/O=Example/CN=host.example.com, falling back to the whole subject
+func subjectCommonName(subject string) string {
+	for _, part := range strings.Split(subject, "/") {
+		if strings.HasPrefix(part, "CN=") {
+			return strings.TrimPrefix(part, "CN=")
+		}
+	}
+	return subject
+}
+
+// enrichFromCertificate fills in details from the issued certificate file,
+// if OpenSSL kept a copy. The file is named after the serial as written in
+// the index, e.g. 01.pem, unless the index names it.
+func enrichFromCertificate(record *CertificateRecord, certsDir, serial, filename string) {
+	candidates := []string{filepath.Join(certsDir, serial+".pem")}
+	if filename != "" && filename != "unknown" {
+		if !filepath.IsAbs(filename) {
+			filename = filepath.Join(certsDir, filepath.Base(filename))
+		}
+		candidates = append([]string{filename}, candidates...)
+	}
+
+	var cert *x509.Certificate
+	for _, file := range candidates {
+		if certs, err := readCertificates(file); err == nil {
+			cert = certs[0]
+			break
+		}
+	}
+	if cert == nil {
+		return
+	}
+	record.NotBefore = cert.NotBefore
+	record.DNSNames = cert.DNSNames
+	if cert.Subject.CommonName != "" {
+		record.Subject = cert.Subject.CommonName
+	}
+}
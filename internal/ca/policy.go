@@ -0,0 +1,388 @@
+package ca
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// IssuancePolicy is a declarative set of rules a CSR must satisfy before a
+// profile signs it. It is configured in the "pica" object of a cfssl
+// signing profile under "policy".
+type IssuancePolicy struct {
+	// CommonName restricts the subject common name
+	CommonName *NameRule `json:"common_name,omitempty"`
+	// SANs restricts every subject alternative name
+	SANs *NameRule `json:"sans,omitempty"`
+	// AllowedDomains lists the domains DNS names (and host name common
+	// names) must fall under; "example.com" includes its subdomains
+	AllowedDomains []string `json:"allowed_domains,omitempty"`
+	// AllowWildcards permits "*.example.com" style DNS names. A wildcard
+	// must be the complete left-most label and cover at least two labels.
+	AllowWildcards bool `json:"allow_wildcards,omitempty"`
+	// AllowedKeyAlgorithms lists "rsa", "ecdsa" and/or "ed25519"
+	AllowedKeyAlgorithms []string `json:"allowed_key_algorithms,omitempty"`
+	// MinRSABits and MinECDSABits are the smallest accepted key sizes
+	MinRSABits   int `json:"min_rsa_bits,omitempty"`
+	MinECDSABits int `json:"min_ecdsa_bits,omitempty"`
+	// RequiredSubjectFields lists subject attributes that must be present:
+	// CN, O, OU, C, ST, L, or SAN for at least one alternative name
+	RequiredSubjectFields []string `json:"required_subject_fields,omitempty"`
+	// MaxValidity caps the lifetime of certificates issued with the profile
+	MaxValidity string `json:"max_validity,omitempty"`
+	// ForbidDuplicates rejects a request when a valid certificate for the
+	// same common name and DNS names already exists
+	ForbidDuplicates bool `json:"forbid_duplicates,omitempty"`
+}
+
+// NameRule accepts names that are listed in Allow or fully match one of the
+// Patterns (regular expressions). An empty rule accepts every name.
+type NameRule struct {
+	Allow    []string `json:"allow,omitempty"`
+	Patterns []string `json:"patterns,omitempty"`
+}
+
+// PolicyViolation names the rule a request broke and why
+type PolicyViolation struct {
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// PolicyResult is the outcome of evaluating a request against a policy
+type PolicyResult struct {
+	Profile    string            `json:"profile"`
+	Allowed    bool              `json:"allowed"`
+	Checked    []string          `json:"checked"`
+	Violations []PolicyViolation `json:"violations,omitempty"`
+}
+
+// PolicyError is returned by SignCertificate when a request is rejected
+type PolicyError struct {
+	Result *PolicyResult
+}
+
+func (e *PolicyError) Error() string {
+	var msgs []string
+	for _, v := range e.Result.Violations {
+		msgs = append(msgs, fmt.Sprintf("%s: %s", v.Rule, v.Message))
+	}
+	return "request rejected by issuance policy: " + strings.Join(msgs, "; ")
+}
+
+// Validate checks that the policy can be evaluated
+func (p *IssuancePolicy) Validate() error {
+	for _, rule := range []*NameRule{p.CommonName, p.SANs} {
+		if rule == nil {
+			continue
+		}
+		for _, pattern := range rule.Patterns {
+			if _, err := regexp.Compile(pattern); err != nil {
+				return fmt.Errorf("invalid policy pattern %q: %w", pattern, err)
+			}
+		}
+	}
+	for _, algo := range p.AllowedKeyAlgorithms {
+		switch strings.ToLower(algo) {
+		case "rsa", "ecdsa", "ed25519":
+		default:
+			return fmt.Errorf("unknown key algorithm %q in policy", algo)
+		}
+	}
+	for _, field := range p.RequiredSubjectFields {
+		if _, ok := subjectFields[strings.ToUpper(field)]; !ok {
+			return fmt.Errorf("unknown subject field %q in policy", field)
+		}
+	}
+	if p.MaxValidity != "" {
+		if _, err := time.ParseDuration(p.MaxValidity); err != nil {
+			return fmt.Errorf("invalid max_validity: %w", err)
+		}
+	}
+	return nil
+}
+
+// subjectFields reports whether a request has a subject field
+var subjectFields = map[string]func(*x509.CertificateRequest) bool{
+	"CN":  func(r *x509.CertificateRequest) bool { return r.Subject.CommonName != "" },
+	"O":   func(r *x509.CertificateRequest) bool { return len(r.Subject.Organization) > 0 },
+	"OU":  func(r *x509.CertificateRequest) bool { return len(r.Subject.OrganizationalUnit) > 0 },
+	"C":   func(r *x509.CertificateRequest) bool { return len(r.Subject.Country) > 0 },
+	"ST":  func(r *x509.CertificateRequest) bool { return len(r.Subject.Province) > 0 },
+	"L":   func(r *x509.CertificateRequest) bool { return len(r.Subject.Locality) > 0 },
+	"SAN": func(r *x509.CertificateRequest) bool { return len(requestNames(r)) > 0 },
+}
+
+// PolicyRequest is what a policy is evaluated against
+type PolicyRequest struct {
+	CSR *x509.CertificateRequest
+	// Validity is the lifetime the profile would give the certificate
+	Validity time.Duration
+	// Existing are the certificates already issued by the CA
+	Existing []*CertificateRecord
+}
+
+// Evaluate checks a request against every rule of the policy and reports
+// all violations, not just the first
+func (p *IssuancePolicy) Evaluate(req *PolicyRequest) *PolicyResult {
+	result := &PolicyResult{Checked: []string{}}
+	csr := req.CSR
+	fail := func(rule, format string, args ...interface{}) {
+		result.Violations = append(result.Violations, PolicyViolation{Rule: rule, Message: fmt.Sprintf(format, args...)})
+	}
+	check := func(rule string) {
+		result.Checked = append(result.Checked, rule)
+	}
+
+	if p.CommonName != nil {
+		check("common_name")
+		if msg := p.CommonName.check(csr.Subject.CommonName); msg != "" {
+			fail("common_name", "common name %q %s", csr.Subject.CommonName, msg)
+		}
+	}
+
+	if p.SANs != nil {
+		check("sans")
+		for _, name := range requestNames(csr) {
+			if msg := p.SANs.check(name); msg != "" {
+				fail("sans", "subject alternative name %q %s", name, msg)
+			}
+		}
+	}
+
+	dnsNames := csr.DNSNames
+	if isHostName(csr.Subject.CommonName) {
+		dnsNames = append([]string{csr.Subject.CommonName}, dnsNames...)
+	}
+
+	if len(p.AllowedDomains) > 0 {
+		check("allowed_domains")
+		for _, name := range dnsNames {
+			if !matchesAny(strings.TrimPrefix(name, "*."), p.AllowedDomains, dnsMatches, false) {
+				fail("allowed_domains", "%q is not in an allowed domain (%s)", name, strings.Join(p.AllowedDomains, ", "))
+			}
+		}
+	}
+
+	check("wildcards")
+	for _, name := range dnsNames {
+		if !strings.Contains(name, "*") {
+			continue
+		}
+		switch {
+		case !p.AllowWildcards:
+			fail("wildcards", "wildcard name %q is not allowed by this profile", name)
+		case !strings.HasPrefix(name, "*.") || strings.Contains(name[2:], "*"):
+			fail("wildcards", "%q: a wildcard must be the complete left-most label", name)
+		case strings.Count(name, ".") < 2:
+			fail("wildcards", "%q: a wildcard must cover at least two labels", name)
+		}
+	}
+
+	algorithm, bits := keyInfo(csr.PublicKey)
+	if len(p.AllowedKeyAlgorithms) > 0 {
+		check("allowed_key_algorithms")
+		allowed := false
+		for _, a := range p.AllowedKeyAlgorithms {
+			allowed = allowed || strings.EqualFold(a, algorithm)
+		}
+		if !allowed {
+			fail("allowed_key_algorithms", "%s keys are not allowed (allowed: %s)", algorithm, strings.Join(p.AllowedKeyAlgorithms, ", "))
+		}
+	}
+	if p.MinRSABits > 0 && algorithm == "rsa" {
+		check("min_rsa_bits")
+		if bits < p.MinRSABits {
+			fail("min_rsa_bits", "RSA key of %d bits is below the minimum of %d", bits, p.MinRSABits)
+		}
+	}
+	if p.MinECDSABits > 0 && algorithm == "ecdsa" {
+		check("min_ecdsa_bits")
+		if bits < p.MinECDSABits {
+			fail("min_ecdsa_bits", "ECDSA key of %d bits is below the minimum of %d", bits, p.MinECDSABits)
+		}
+	}
+
+	if len(p.RequiredSubjectFields) > 0 {
+		check("required_subject_fields")
+	}
+	for _, field := range p.RequiredSubjectFields {
+		name := strings.ToUpper(field)
+		if has, ok := subjectFields[name]; ok && !has(csr) {
+			fail("required_subject_fields", "required subject field %s is missing", name)
+		}
+	}
+
+	if p.MaxValidity != "" {
+		check("max_validity")
+		if max, err := time.ParseDuration(p.MaxValidity); err == nil && req.Validity > max {
+			fail("max_validity", "validity of %s exceeds the maximum of %s", req.Validity, max)
+		}
+	}
+
+	if p.ForbidDuplicates {
+		check("forbid_duplicates")
+		now := time.Now()
+		want := sortedNames(csr.DNSNames)
+		for _, r := range req.Existing {
+			if r.Status != StatusValid || now.After(r.NotAfter) {
+				continue
+			}
+			if r.Subject == csr.Subject.CommonName && sortedNames(r.DNSNames) == want {
+				fail("forbid_duplicates", "certificate %s for %q is still valid until %s",
+					r.SerialNumber, r.Subject, r.NotAfter.Format("2006-01-02"))
+			}
+		}
+	}
+
+	result.Allowed = len(result.Violations) == 0
+	return result
+}
+
+// check returns why a name does not satisfy the rule, or "" if it does
+func (r *NameRule) check(name string) string {
+	if len(r.Allow) == 0 && len(r.Patterns) == 0 {
+		return ""
+	}
+	for _, allowed := range r.Allow {
+		if strings.EqualFold(allowed, name) {
+			return ""
+		}
+	}
+	for _, pattern := range r.Patterns {
+		if re, err := regexp.Compile("^(?:" + pattern + ")$"); err == nil && re.MatchString(name) {
+			return ""
+		}
+	}
+	return "is neither allowed nor matches a permitted pattern"
+}
+
+// requestNames returns every subject alternative name of a request
+func requestNames(csr *x509.CertificateRequest) []string {
+	names := append([]string{}, csr.DNSNames...)
+	for _, ip := range csr.IPAddresses {
+		names = append(names, ip.String())
+	}
+	names = append(names, csr.EmailAddresses...)
+	for _, uri := range csr.URIs {
+		names = append(names, uri.String())
+	}
+	return names
+}
+
+// isHostName reports whether a common name looks like a DNS name
+func isHostName(name string) bool {
+	return strings.Contains(name, ".") && !strings.ContainsAny(name, " @/:")
+}
+
+// sortedNames returns a canonical form of a set of names
+func sortedNames(names []string) string {
+	sorted := make([]string, len(names))
+	for i, name := range names {
+		sorted[i] = strings.ToLower(name)
+	}
+	sort.Strings(sorted)
+	return strings.Join(sorted, ",")
+}
+
+// keyInfo returns the algorithm name and size of a public key
+func keyInfo(pub interface{}) (string, int) {
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		return "rsa", k.N.BitLen()
+	case *ecdsa.PublicKey:
+		return "ecdsa", k.Curve.Params().BitSize
+	case ed25519.PublicKey:
+		return "ed25519", 256
+	default:
+		return fmt.Sprintf("%T", pub), 0
+	}
+}
+
+// EvaluatePolicy checks a PEM-encoded CSR against the issuance policy of a
+// profile without signing anything. Profiles without a policy allow every
+// request.
+func (ca *CA) EvaluatePolicy(csrPEM []byte, profile string) (*PolicyResult, error) {
+	csr, err := ParseCSR(csrPEM)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg, err := ca.LoadConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load config: %w", err)
+	}
+	signingProfile := cfg.Signing.Default
+	if profile != "" {
+		p, ok := cfg.Signing.Profiles[profile]
+		if !ok {
+			return nil, fmt.Errorf("profile '%s' not found", profile)
+		}
+		signingProfile = p
+	}
+
+	opts, err := ca.LoadProfileOptions(profile)
+	if err != nil {
+		return nil, err
+	}
+	opts.Subject.Apply(&csr.Subject)
+	return ca.evaluatePolicy(csr, profile, signingProfile.Expiry, opts)
+}
+
+// evaluatePolicy runs the profile's policy against a parsed request
+func (ca *CA) evaluatePolicy(csr *x509.CertificateRequest, profile string, validity time.Duration, opts *ProfileOptions) (*PolicyResult, error) {
+	result := &PolicyResult{Allowed: true, Checked: []string{}}
+	if opts.Policy != nil {
+		req := &PolicyRequest{CSR: csr, Validity: validity}
+		if opts.Policy.ForbidDuplicates && ca.DatabaseDir != "" {
+			store, err := ca.Store()
+			if err != nil {
+				return nil, err
+			}
+			req.Existing = store.List()
+		}
+		result = opts.Policy.Evaluate(req)
+	}
+
+	if opts.SANs != nil {
+		result.Checked = append(result.Checked, "san_requirements")
+		for _, msg := range opts.SANs.check(csr) {
+			result.Violations = append(result.Violations, PolicyViolation{Rule: "san_requirements", Message: msg})
+		}
+		result.Allowed = len(result.Violations) == 0
+	}
+
+	result.Profile = profile
+	return result, nil
+}
+
+// check returns why a request does not meet the SAN requirements
+func (r *SANRequirements) check(csr *x509.CertificateRequest) []string {
+	var msgs []string
+	names := requestNames(csr)
+	if r.Required && len(names) == 0 {
+		msgs = append(msgs, "at least one subject alternative name is required")
+	}
+	if r.Max > 0 && len(names) > r.Max {
+		msgs = append(msgs, fmt.Sprintf("%d subject alternative names exceed the maximum of %d", len(names), r.Max))
+	}
+	if len(r.Types) > 0 {
+		allowed := make(map[string]bool)
+		for _, t := range r.Types {
+			allowed[t] = true
+		}
+		present := map[string]int{"dns": len(csr.DNSNames), "ip": len(csr.IPAddresses),
+			"email": len(csr.EmailAddresses), "uri": len(csr.URIs)}
+		for _, t := range []string{"dns", "ip", "email", "uri"} {
+			if present[t] > 0 && !allowed[t] {
+				msgs = append(msgs, fmt.Sprintf("%s names are not allowed (allowed: %s)", t, strings.Join(r.Types, ", ")))
+			}
+		}
+	}
+	return msgs
+}
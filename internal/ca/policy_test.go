@@ -0,0 +1,157 @@
+package ca
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testPolicyConfig = `{
+	"signing": {
+		"default": {"expiry": "8760h"},
+		"profiles": {
+			"server": {
+				"usages": ["signing", "key encipherment", "server auth"],
+				"expiry": "2160h",
+				"pica": {
+					"policy": {
+						"common_name": {"patterns": ["[a-z0-9-]+\\.example\\.com"]},
+						"allowed_domains": ["example.com"],
+						"allowed_key_algorithms": ["ecdsa"],
+						"min_ecdsa_bits": 256,
+						"max_validity": "2160h",
+						"forbid_duplicates": true
+					}
+				}
+			}
+		}
+	}
+}`
+
+// violatedRules returns the rule names of a result's violations
+func violatedRules(result *PolicyResult) []string {
+	var rules []string
+	for _, v := range result.Violations {
+		rules = append(rules, v.Rule)
+	}
+	return rules
+}
+
+func TestPolicyEvaluate(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	bigKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+
+	policy := &IssuancePolicy{
+		SANs:                  &NameRule{Patterns: []string{`.*\.example\.com`}},
+		AllowedDomains:        []string{"example.com"},
+		AllowWildcards:        true,
+		MinRSABits:            2048,
+		RequiredSubjectFields: []string{"CN", "O"},
+		MaxValidity:           "720h",
+	}
+	if err := policy.Validate(); err != nil {
+		t.Fatalf("Validate failed: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		csr      *x509.CertificateRequest
+		validity time.Duration
+		want     []string
+	}{
+		{
+			name: "allowed",
+			csr: &x509.CertificateRequest{
+				Subject:   pkix.Name{CommonName: "www.example.com", Organization: []string{"PiCA"}},
+				DNSNames:  []string{"www.example.com", "*.api.example.com"},
+				PublicKey: &bigKey.PublicKey,
+			},
+			validity: time.Hour,
+		},
+		{
+			name: "every rule reported",
+			csr: &x509.CertificateRequest{
+				Subject:   pkix.Name{CommonName: "www.other.org"},
+				DNSNames:  []string{"*.com"},
+				PublicKey: &rsaKey.PublicKey,
+			},
+			validity: 8760 * time.Hour,
+			want: []string{"sans", "allowed_domains", "allowed_domains", "wildcards", "min_rsa_bits",
+				"required_subject_fields", "max_validity"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := policy.Evaluate(&PolicyRequest{CSR: tt.csr, Validity: tt.validity})
+			if got := violatedRules(result); strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("Violations = %v, want %v (%+v)", got, tt.want, result.Violations)
+			}
+			if result.Allowed != (len(tt.want) == 0) {
+				t.Errorf("Allowed = %v", result.Allowed)
+			}
+		})
+	}
+
+	if err := (&IssuancePolicy{CommonName: &NameRule{Patterns: []string{"("}}}).Validate(); err == nil {
+		t.Error("Expected invalid pattern to be rejected")
+	}
+	if err := (&IssuancePolicy{AllowedKeyAlgorithms: []string{"dsa"}}).Validate(); err == nil {
+		t.Error("Expected unknown algorithm to be rejected")
+	}
+}
+
+func TestPolicyEnforcedWhenSigning(t *testing.T) {
+	c := newTestCA(t)
+	if err := os.WriteFile(c.ConfigFile, []byte(testPolicyConfig), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+
+	// Dry run explains the rejection without signing
+	result, err := c.EvaluatePolicy(newTestCSR(t, "www.example.org", "www.example.org"), "server")
+	if err != nil {
+		t.Fatalf("EvaluatePolicy failed: %v", err)
+	}
+	if result.Allowed || strings.Join(violatedRules(result), ",") != "common_name,allowed_domains,allowed_domains" {
+		t.Errorf("Unexpected dry-run result: %+v", result)
+	}
+
+	// Profiles without a policy accept anything
+	if result, err := c.EvaluatePolicy(newTestCSR(t, "anything"), ""); err != nil || !result.Allowed {
+		t.Errorf("Default profile should allow the request: %+v, %v", result, err)
+	}
+
+	if _, err := c.SignCertificate(newTestCSR(t, "www.example.org"), "server"); err == nil {
+		t.Fatal("Expected policy violation")
+	} else {
+		var policyErr *PolicyError
+		if !errors.As(err, &policyErr) || policyErr.Result.Allowed {
+			t.Fatalf("Expected PolicyError, got %v", err)
+		}
+	}
+
+	if _, err := c.SignCertificate(newTestCSR(t, "www.example.com", "www.example.com"), "server"); err != nil {
+		t.Fatalf("Expected request to be allowed: %v", err)
+	}
+
+	// A second certificate for the same names is a duplicate
+	result, err = c.EvaluatePolicy(newTestCSR(t, "www.example.com", "www.example.com"), "server")
+	if err != nil {
+		t.Fatalf("EvaluatePolicy failed: %v", err)
+	}
+	if result.Allowed || strings.Join(violatedRules(result), ",") != "forbid_duplicates" {
+		t.Errorf("Expected duplicate to be rejected: %+v", result)
+	}
+}
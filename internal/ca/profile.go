@@ -0,0 +1,113 @@
+package ca
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// ProfileOptions holds PiCA-specific settings of a cfssl signing profile.
+// They live in a "pica" object inside the profile, which cfssl ignores:
+//
+//	"profiles": {"intermediate": {"usages": [...], "pica": {"constraints": {...}}}}
+type ProfileOptions struct {
+	// Constraints are applied to CA certificates issued with the profile
+	Constraints *CAConstraints `json:"constraints,omitempty"`
+	// Policy is checked against every CSR before it is signed
+	Policy *IssuancePolicy `json:"policy,omitempty"`
+	// Lint configures zlint checks of certificates issued with the profile
+	Lint *LintOptions `json:"lint,omitempty"`
+	// Subject fills in subject fields the CSR leaves empty
+	Subject *SubjectDefaults `json:"subject,omitempty"`
+	// SANs restricts the subject alternative names of requests
+	SANs *SANRequirements `json:"sans,omitempty"`
+	// Extensions are custom extensions added to every certificate
+	Extensions []Extension `json:"extensions,omitempty"`
+	// PassthroughExtensions lists OIDs of CSR extensions that are copied
+	// into the certificate
+	PassthroughExtensions []string `json:"passthrough_extensions,omitempty"`
+	// Attestation requires keys to be attested by a YubiKey
+	Attestation *AttestationRequirement `json:"attestation,omitempty"`
+	// TemplateVersion marks profiles generated from a template
+	TemplateVersion int `json:"template_version,omitempty"`
+}
+
+// lintOptions returns the profile's lint settings or the defaults
+func (o *ProfileOptions) lintOptions() *LintOptions {
+	if o.Lint != nil {
+		return o.Lint
+	}
+	opts := DefaultLintOptions
+	return &opts
+}
+
+// profileConfig mirrors the parts of a cfssl config file holding the
+// PiCA profile settings
+type profileConfig struct {
+	Signing struct {
+		Default  *profileEntry           `json:"default"`
+		Profiles map[string]profileEntry `json:"profiles"`
+	} `json:"signing"`
+}
+
+type profileEntry struct {
+	PiCA *ProfileOptions `json:"pica"`
+}
+
+// LoadProfileOptions reads the PiCA settings of a signing profile from the
+// CA's config file; an empty name selects the default profile. A profile
+// without settings yields empty options.
+func (ca *CA) LoadProfileOptions(profile string) (*ProfileOptions, error) {
+	data, err := os.ReadFile(ca.ConfigFile)
+	if err != nil {
+		return nil, err
+	}
+
+	var cfg profileConfig
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse profile settings: %w", err)
+	}
+
+	var entry *profileEntry
+	if profile == "" {
+		entry = cfg.Signing.Default
+	} else if p, ok := cfg.Signing.Profiles[profile]; ok {
+		entry = &p
+	}
+	if entry == nil || entry.PiCA == nil {
+		return &ProfileOptions{}, nil
+	}
+
+	if c := entry.PiCA.Constraints; c != nil {
+		if err := c.Validate(); err != nil {
+			return nil, fmt.Errorf("profile %q: %w", profile, err)
+		}
+	}
+	if p := entry.PiCA.Policy; p != nil {
+		if err := p.Validate(); err != nil {
+			return nil, fmt.Errorf("profile %q: %w", profile, err)
+		}
+	}
+	if l := entry.PiCA.Lint; l != nil {
+		if err := l.Validate(); err != nil {
+			return nil, fmt.Errorf("profile %q: %w", profile, err)
+		}
+	}
+	if r := entry.PiCA.SANs; r != nil {
+		if err := r.Validate(); err != nil {
+			return nil, fmt.Errorf("profile %q: %w", profile, err)
+		}
+	}
+	if a := entry.PiCA.Attestation; a != nil {
+		if err := a.Validate(); err != nil {
+			return nil, fmt.Errorf("profile %q: %w", profile, err)
+		}
+	}
+	if _, err := EncodeExtensions(entry.PiCA.Extensions); err != nil {
+		return nil, fmt.Errorf("profile %q: %w", profile, err)
+	}
+	if err := validatePassthrough(entry.PiCA.PassthroughExtensions); err != nil {
+		return nil, fmt.Errorf("profile %q: %w", profile, err)
+	}
+	return entry.PiCA, nil
+}
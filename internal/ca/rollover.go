@@ -0,0 +1,472 @@
+package ca
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/billchurch/PiCA/internal/crypto"
+)
+
+// Generation is one certificate a CA has used, with the slot holding its key
+type Generation struct {
+	Certificate string `json:"certificate"`
+	Slot        string `json:"slot"`
+}
+
+// CertificateSet tracks the certificates of a CA across renewals and rekeys
+// together with the link certificates that join old and new keys
+type CertificateSet struct {
+	Generations []*Generation `json:"generations"`
+	Links       []string      `json:"links,omitempty"`
+
+	file string
+}
+
+// Issuer is a CA certificate together with the slot holding its key
+type Issuer struct {
+	Certificate *x509.Certificate
+	Slot        crypto.Slot
+	File        string
+}
+
+// CAKey is a CA certificate and the provider slot signing with it, used as
+// the parent when renewing or rekeying a CA and when cross-signing
+type CAKey struct {
+	Provider    crypto.Provider
+	Slot        crypto.Slot
+	Certificate *x509.Certificate
+}
+
+// CertificateSetFile returns where the certificate set of a CA is kept
+func CertificateSetFile(certFile string) string {
+	ext := filepath.Ext(certFile)
+	return strings.TrimSuffix(certFile, ext) + "-rollover.json"
+}
+
+// LoadCertificateSet reads the CA's certificate set. A CA that was never
+// renewed or rekeyed has a single generation: its certificate file and slot.
+func (ca *CA) LoadCertificateSet() (*CertificateSet, error) {
+	file := CertificateSetFile(ca.CertFile)
+	set := &CertificateSet{file: file}
+
+	data, err := os.ReadFile(file)
+	if errors.Is(err, os.ErrNotExist) {
+		set.Add(ca.CertFile, ca.Slot)
+		return set, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read certificate set: %w", err)
+	}
+	if err := json.Unmarshal(data, set); err != nil {
+		return nil, fmt.Errorf("failed to parse certificate set: %w", err)
+	}
+	if len(set.Generations) == 0 {
+		return nil, fmt.Errorf("certificate set %s has no certificates", file)
+	}
+	return set, nil
+}
+
+// Save writes the certificate set next to the CA certificate
+func (s *CertificateSet) Save() error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.file, data, 0644)
+}
+
+// Add records a new certificate generation
+func (s *CertificateSet) Add(certFile string, slot crypto.Slot) {
+	s.Generations = append(s.Generations, &Generation{
+		Certificate: certFile,
+		Slot:        fmt.Sprintf("%X", int(slot)),
+	})
+}
+
+// Issuers loads every generation of the CA, oldest first
+func (s *CertificateSet) Issuers() ([]*Issuer, error) {
+	issuers := make([]*Issuer, 0, len(s.Generations))
+	for _, g := range s.Generations {
+		cert, err := loadCertificateFile(g.Certificate)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", g.Certificate, err)
+		}
+		slot, err := strconv.ParseInt(g.Slot, 16, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s: invalid slot %q", g.Certificate, g.Slot)
+		}
+		issuers = append(issuers, &Issuer{Certificate: cert, Slot: crypto.Slot(slot), File: g.Certificate})
+	}
+	return issuers, nil
+}
+
+// Active returns the issuer used for new certificates at the given time:
+// the most recent generation whose certificate is valid
+func (s *CertificateSet) Active(now time.Time) (*Issuer, error) {
+	issuers, err := s.Issuers()
+	if err != nil {
+		return nil, err
+	}
+
+	var active *Issuer
+	for _, issuer := range issuers {
+		cert := issuer.Certificate
+		if now.Before(cert.NotBefore) || now.After(cert.NotAfter) {
+			continue
+		}
+		if active == nil || !cert.NotBefore.Before(active.Certificate.NotBefore) {
+			active = issuer
+		}
+	}
+	if active == nil {
+		return nil, errors.New("the CA has no valid certificate")
+	}
+	return active, nil
+}
+
+// LinkCertificates loads the cross-signed certificates of the set
+func (s *CertificateSet) LinkCertificates() ([]*x509.Certificate, error) {
+	var links []*x509.Certificate
+	for _, file := range s.Links {
+		cert, err := loadCertificateFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		links = append(links, cert)
+	}
+	return links, nil
+}
+
+// ActiveIssuer returns the certificate and key slot the CA signs with now
+func (ca *CA) ActiveIssuer() (*x509.Certificate, crypto.Slot, error) {
+	if _, err := os.Stat(CertificateSetFile(ca.CertFile)); errors.Is(err, os.ErrNotExist) {
+		cert, err := loadCertificateFile(ca.CertFile)
+		return cert, ca.Slot, err
+	}
+
+	set, err := ca.LoadCertificateSet()
+	if err != nil {
+		return nil, 0, err
+	}
+	active, err := set.Active(time.Now())
+	if err != nil {
+		return nil, 0, err
+	}
+	return active.Certificate, active.Slot, nil
+}
+
+// Chains returns the issuing chains of every valid CA certificate. Besides
+// the certificate itself, each link certificate for the same key gives an
+// alternative chain to the generation that signed it, so relying parties
+// trusting either the old or the new certificate can build a path during
+// the overlap period. The active chain comes first.
+func (ca *CA) Chains() ([][]*x509.Certificate, error) {
+	set, err := ca.LoadCertificateSet()
+	if err != nil {
+		return nil, err
+	}
+	issuers, err := set.Issuers()
+	if err != nil {
+		return nil, err
+	}
+	links, err := set.LinkCertificates()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	valid := func(cert *x509.Certificate) bool {
+		return !now.Before(cert.NotBefore) && !now.After(cert.NotAfter)
+	}
+
+	var chains [][]*x509.Certificate
+	if active, err := set.Active(now); err == nil {
+		chains = append(chains, []*x509.Certificate{active.Certificate})
+	}
+	for i := len(issuers) - 1; i >= 0; i-- {
+		cert := issuers[i].Certificate
+		if !valid(cert) {
+			continue
+		}
+		if len(chains) == 0 || !chains[0][0].Equal(cert) {
+			chains = append(chains, []*x509.Certificate{cert})
+		}
+	}
+
+	// Follow each link to a valid generation of this CA that signed it
+	var linked [][]*x509.Certificate
+	for _, chain := range chains {
+		for _, link := range links {
+			if !valid(link) || !sameKey(link, chain[0]) {
+				continue
+			}
+			for _, issuer := range issuers {
+				if valid(issuer.Certificate) && link.CheckSignatureFrom(issuer.Certificate) == nil &&
+					!sameKey(issuer.Certificate, link) {
+					linked = append(linked, []*x509.Certificate{link, issuer.Certificate})
+				}
+			}
+		}
+	}
+	return append(chains, linked...), nil
+}
+
+// RenewCertificate issues a new certificate for the CA's current key with
+// the same subject and constraints. Root CAs renew self-signed; other CAs
+// need their parent's key. The new certificate becomes the active one.
+func (ca *CA) RenewCertificate(expiry time.Duration, parent *CAKey) (*x509.Certificate, error) {
+	if err := ca.InitializeProvider(); err != nil {
+		return nil, err
+	}
+
+	set, err := ca.LoadCertificateSet()
+	if err != nil {
+		return nil, err
+	}
+	current, err := set.Active(time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	issuer := parent
+	if issuer == nil {
+		if !isSelfSigned(current.Certificate) {
+			return nil, errors.New("renewing a subordinate CA requires its parent CA key")
+		}
+		issuer = &CAKey{Provider: ca.Provider, Slot: current.Slot}
+	}
+
+	cert, err := reissueCertificate(current.Certificate, current.Certificate.PublicKey, issuer, expiry)
+	if err != nil {
+		return nil, err
+	}
+	return cert, ca.addGeneration(set, cert, current.Slot)
+}
+
+// Rekey generates a new CA key in slot and issues a certificate for it with
+// the CA's subject and constraints. A root CA self-signs the new certificate
+// and, when link is set, cross-signs the old and new certificates with each
+// other's keys so either one can be used as a trust anchor.
+func (ca *CA) Rekey(slot crypto.Slot, algorithm string, bits int, expiry time.Duration, parent *CAKey, link bool) (*x509.Certificate, error) {
+	if err := ca.InitializeProvider(); err != nil {
+		return nil, err
+	}
+
+	set, err := ca.LoadCertificateSet()
+	if err != nil {
+		return nil, err
+	}
+	current, err := set.Active(time.Now())
+	if err != nil {
+		return nil, err
+	}
+	for _, g := range set.Generations {
+		if g.Slot == fmt.Sprintf("%X", int(slot)) {
+			return nil, fmt.Errorf("slot %X already holds a key of this CA", int(slot))
+		}
+	}
+
+	root := isSelfSigned(current.Certificate)
+	if !root && parent == nil {
+		return nil, errors.New("rekeying a subordinate CA requires its parent CA key")
+	}
+
+	// The slot may hold the key of another CA, the SSH CA or the TSA
+	if err := checkSlotFree(ca.Provider, slot); err != nil {
+		return nil, err
+	}
+	if err := ca.Provider.GenerateKey(slot, algorithm, bits); err != nil {
+		return nil, fmt.Errorf("failed to generate key: %w", err)
+	}
+	pubKey, err := ca.Provider.GetPublicKey(slot)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get public key: %w", err)
+	}
+
+	issuer := parent
+	if root {
+		issuer = &CAKey{Provider: ca.Provider, Slot: slot}
+	}
+	cert, err := reissueCertificate(current.Certificate, pubKey, issuer, expiry)
+	if err != nil {
+		return nil, err
+	}
+
+	if root && link {
+		oldKey := &CAKey{Provider: ca.Provider, Slot: current.Slot, Certificate: current.Certificate}
+		newKey := &CAKey{Provider: ca.Provider, Slot: slot, Certificate: cert}
+		// The link certificates only need to last as long as the overlap
+		for _, pair := range []struct {
+			subject *x509.Certificate
+			issuer  *CAKey
+		}{{cert, oldKey}, {current.Certificate, newKey}} {
+			linkCert, err := CrossSign(pair.subject, pair.issuer, time.Until(current.Certificate.NotAfter))
+			if err != nil {
+				return nil, err
+			}
+			file := ca.generationFile(fmt.Sprintf("link-%X", linkCert.SerialNumber))
+			if err := WriteCertificateFile(file, linkCert); err != nil {
+				return nil, err
+			}
+			set.Links = append(set.Links, file)
+			fmt.Println("Link certificate saved to:", file)
+		}
+	}
+
+	return cert, ca.addGeneration(set, cert, slot)
+}
+
+// CrossSign issues a certificate for another CA's subject and key, signed by
+// issuer. The certificate never outlives the issuer's own certificate.
+func CrossSign(subject *x509.Certificate, issuer *CAKey, expiry time.Duration) (*x509.Certificate, error) {
+	if issuer == nil || issuer.Certificate == nil {
+		return nil, errors.New("cross-signing requires the issuing CA certificate")
+	}
+	if !subject.IsCA {
+		return nil, fmt.Errorf("%q is not a CA certificate", subject.Subject.CommonName)
+	}
+	if err := checkPathLen(issuer.Certificate, pathLength(subject)); err != nil {
+		return nil, err
+	}
+	return reissueCertificate(subject, subject.PublicKey, issuer, expiry)
+}
+
+// addGeneration saves a new CA certificate, installs it in its slot and
+// records it in the certificate set
+func (ca *CA) addGeneration(set *CertificateSet, cert *x509.Certificate, slot crypto.Slot) error {
+	file := ca.generationFile(fmt.Sprintf("%X", cert.SerialNumber))
+	if err := WriteCertificateFile(file, cert); err != nil {
+		return err
+	}
+	if err := ca.Provider.ImportCertificate(slot, cert); err != nil {
+		return fmt.Errorf("failed to import certificate: %w", err)
+	}
+	set.Add(file, slot)
+	if err := set.Save(); err != nil {
+		return fmt.Errorf("failed to save certificate set: %w", err)
+	}
+	fmt.Println("Certificate saved to:", file)
+	return nil
+}
+
+// generationFile names an additional certificate file next to the CA's
+func (ca *CA) generationFile(suffix string) string {
+	ext := filepath.Ext(ca.CertFile)
+	return strings.TrimSuffix(ca.CertFile, ext) + "-" + suffix + ext
+}
+
+// reissueCertificate creates a CA certificate for pubKey that copies the
+// subject and constraints of template. A CAKey without certificate
+// self-signs.
+func reissueCertificate(template *x509.Certificate, pubKey interface{}, issuer *CAKey, expiry time.Duration) (*x509.Certificate, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 127))
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate serial number: %w", err)
+	}
+
+	now := time.Now()
+	cert := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               template.Subject,
+		NotBefore:             now.Add(-5 * time.Minute),
+		NotAfter:              now.Add(expiry),
+		KeyUsage:              template.KeyUsage,
+		ExtKeyUsage:           template.ExtKeyUsage,
+		UnknownExtKeyUsage:    template.UnknownExtKeyUsage,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLen:            template.MaxPathLen,
+		MaxPathLenZero:        template.MaxPathLenZero,
+		PolicyIdentifiers:     template.PolicyIdentifiers,
+
+		PermittedDNSDomainsCritical: template.PermittedDNSDomainsCritical,
+		PermittedDNSDomains:         template.PermittedDNSDomains,
+		ExcludedDNSDomains:          template.ExcludedDNSDomains,
+		PermittedIPRanges:           template.PermittedIPRanges,
+		ExcludedIPRanges:            template.ExcludedIPRanges,
+		PermittedEmailAddresses:     template.PermittedEmailAddresses,
+		ExcludedEmailAddresses:      template.ExcludedEmailAddresses,
+		PermittedURIDomains:         template.PermittedURIDomains,
+		ExcludedURIDomains:          template.ExcludedURIDomains,
+	}
+	// Keep the policy constraints, which crypto/x509 does not model, and
+	// any custom extensions
+	cert.ExtraExtensions = append(cert.ExtraExtensions, customExtensions(template.Extensions)...)
+	for _, ext := range template.Extensions {
+		if ext.Id.Equal(oidExtensionPolicyConstraints) || ext.Id.Equal(oidExtensionInhibitAnyPolicy) {
+			cert.ExtraExtensions = append(cert.ExtraExtensions, ext)
+		}
+	}
+	if sameKeyAs(template, pubKey) {
+		cert.SubjectKeyId = template.SubjectKeyId
+	}
+
+	parent := cert
+	signerKey := pubKey
+	if issuer.Certificate != nil {
+		parent = issuer.Certificate
+		signerKey = issuer.Certificate.PublicKey
+		if cert.NotAfter.After(parent.NotAfter) {
+			cert.NotAfter = parent.NotAfter
+		}
+	}
+
+	signer := &crypto.ProviderSigner{
+		Provider:  issuer.Provider,
+		Slot:      issuer.Slot,
+		PublicKey: signerKey,
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, cert, parent, pubKey, signer)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create certificate: %w", err)
+	}
+	return x509.ParseCertificate(certDER)
+}
+
+// isSelfSigned reports whether a certificate is signed by its own key
+func isSelfSigned(cert *x509.Certificate) bool {
+	return bytes.Equal(cert.RawSubject, cert.RawIssuer) && cert.CheckSignatureFrom(cert) == nil
+}
+
+// pathLength returns the path length constraint of a CA certificate, -1
+// meaning unconstrained
+func pathLength(cert *x509.Certificate) int {
+	if cert.MaxPathLen > 0 || cert.MaxPathLenZero {
+		return cert.MaxPathLen
+	}
+	return -1
+}
+
+// sameKey reports whether two certificates certify the same public key
+func sameKey(a, b *x509.Certificate) bool {
+	return sameKeyAs(a, b.PublicKey)
+}
+
+// sameKeyAs reports whether a certificate certifies pubKey
+func sameKeyAs(cert *x509.Certificate, pubKey interface{}) bool {
+	der, err := x509.MarshalPKIXPublicKey(pubKey)
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(cert.RawSubjectPublicKeyInfo, der)
+}
+
+// EncodeChain PEM-encodes a certificate chain
+func EncodeChain(chain []*x509.Certificate) []byte {
+	var buf bytes.Buffer
+	for _, cert := range chain {
+		pem.Encode(&buf, &pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+	}
+	return buf.Bytes()
+}
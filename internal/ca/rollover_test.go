@@ -0,0 +1,187 @@
+package ca
+
+import (
+	"crypto/x509"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/billchurch/PiCA/internal/crypto"
+)
+
+// verifyLeaf checks that a leaf certificate chains to root
+func verifyLeaf(t *testing.T, leaf, root *x509.Certificate, intermediates ...*x509.Certificate) error {
+	t.Helper()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(root)
+	pool := x509.NewCertPool()
+	for _, cert := range intermediates {
+		pool.AddCert(cert)
+	}
+	_, err := leaf.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: pool,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	return err
+}
+
+func TestRenewCACertificate(t *testing.T) {
+	c := newTestCA(t)
+	original, err := LoadCertificate(c.CertFile)
+	if err != nil {
+		t.Fatalf("Failed to load CA certificate: %v", err)
+	}
+
+	renewed, err := c.RenewCertificate(48*time.Hour, nil)
+	if err != nil {
+		t.Fatalf("Renew failed: %v", err)
+	}
+	if !sameKey(renewed, original) || renewed.Subject.String() != original.Subject.String() {
+		t.Errorf("Renewed certificate must keep the subject and key")
+	}
+	if !renewed.NotAfter.After(original.NotAfter) || renewed.SerialNumber.Cmp(original.SerialNumber) == 0 {
+		t.Errorf("Expected a new, longer-lived certificate")
+	}
+
+	active, slot, err := c.ActiveIssuer()
+	if err != nil || !active.Equal(renewed) || slot != crypto.SlotCA1 {
+		t.Fatalf("Expected the renewed certificate to be active: %v", err)
+	}
+
+	// Certificates issued now chain to either certificate
+	leaf := parsePEMCertificate(t, mustSign(t, c, "renewed.example.com"))
+	for _, root := range []*x509.Certificate{original, renewed} {
+		if err := verifyLeaf(t, leaf, root); err != nil {
+			t.Errorf("Leaf does not verify against %X: %v", root.SerialNumber, err)
+		}
+	}
+}
+
+func TestRekeyRootWithLinkCertificates(t *testing.T) {
+	c := newTestCA(t)
+	oldRoot, _ := LoadCertificate(c.CertFile)
+	oldLeaf := parsePEMCertificate(t, mustSign(t, c, "old.example.com"))
+
+	if _, err := c.Rekey(crypto.SlotCA1, "ECDSA", 256, 48*time.Hour, nil, true); err == nil {
+		t.Errorf("Expected rekeying into the current slot to fail")
+	}
+	if err := c.Provider.GenerateKey(crypto.SlotSSH, "ECDSA", 256); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Rekey(crypto.SlotSSH, "ECDSA", 256, 48*time.Hour, nil, true); !errors.Is(err, crypto.ErrSlotInUse) {
+		t.Errorf("Expected ErrSlotInUse rekeying into another key's slot, got %v", err)
+	}
+	newRoot, err := c.Rekey(crypto.SlotCA2, "ECDSA", 384, 48*time.Hour, nil, true)
+	if err != nil {
+		t.Fatalf("Rekey failed: %v", err)
+	}
+	if sameKey(newRoot, oldRoot) || !isSelfSigned(newRoot) {
+		t.Fatalf("Expected a self-signed certificate for a new key")
+	}
+
+	active, slot, err := c.ActiveIssuer()
+	if err != nil || !active.Equal(newRoot) || slot != crypto.SlotCA2 {
+		t.Fatalf("Expected the new key to be active: %v", err)
+	}
+	newLeaf := parsePEMCertificate(t, mustSign(t, c, "new.example.com"))
+	if err := verifyLeaf(t, newLeaf, newRoot); err != nil {
+		t.Errorf("New leaf does not verify against the new root: %v", err)
+	}
+
+	// Both chains are served: the new root, and the link to the old root
+	chains, err := c.Chains()
+	if err != nil {
+		t.Fatalf("Chains failed: %v", err)
+	}
+	var toOld, toNew []*x509.Certificate
+	for _, chain := range chains {
+		switch {
+		case len(chain) == 2 && chain[1].Equal(oldRoot) && sameKey(chain[0], newRoot):
+			toOld = chain
+		case len(chain) == 2 && chain[1].Equal(newRoot) && sameKey(chain[0], oldRoot):
+			toNew = chain
+		}
+	}
+	if !chains[0][0].Equal(newRoot) || toOld == nil || toNew == nil {
+		t.Fatalf("Unexpected chains: %d", len(chains))
+	}
+	if err := verifyLeaf(t, newLeaf, oldRoot, toOld[0]); err != nil {
+		t.Errorf("New leaf does not verify against the old root through the link: %v", err)
+	}
+	if err := verifyLeaf(t, oldLeaf, newRoot, toNew[0]); err != nil {
+		t.Errorf("Old leaf does not verify against the new root through the link: %v", err)
+	}
+
+	// The CRL is signed with the active key, and the old key keeps signing
+	// one for the certificates it issued
+	if err := c.RevokeCertificate(oldLeaf.SerialNumber.Text(16)); err != nil {
+		t.Fatalf("Failed to revoke: %v", err)
+	}
+	info, err := c.GenerateCRL(time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to generate CRL: %v", err)
+	}
+	oldCRLFile := KeyCRLFile(c.CRLFile, oldRoot.SubjectKeyId)
+	if len(info.KeyFiles) != 1 || info.KeyFiles[0] != oldCRLFile {
+		t.Fatalf("Expected a CRL for the old key, got %v", info.KeyFiles)
+	}
+	for _, tc := range []struct {
+		file   string
+		issuer *x509.Certificate
+	}{{c.CRLFile, newRoot}, {oldCRLFile, oldRoot}} {
+		crlDER, _ := os.ReadFile(tc.file)
+		crl, err := x509.ParseRevocationList(crlDER)
+		if err != nil {
+			t.Fatalf("Failed to parse %s: %v", tc.file, err)
+		}
+		if err := crl.CheckSignatureFrom(tc.issuer); err != nil {
+			t.Errorf("%s not signed by its key: %v", tc.file, err)
+		}
+		if crl.Number.Int64() != info.Number || len(crl.RevokedCertificateEntries) != 1 ||
+			crl.RevokedCertificateEntries[0].SerialNumber.Cmp(oldLeaf.SerialNumber) != 0 {
+			t.Errorf("Unexpected entries in %s", tc.file)
+		}
+	}
+}
+
+func TestRolloverSubCARequiresParent(t *testing.T) {
+	root := newTestCA(t)
+	rootCert, _ := LoadCertificate(root.CertFile)
+
+	// Turn the test CA into a sub CA certified by the root
+	sub := newTestCA(t)
+	subCert, _ := LoadCertificate(sub.CertFile)
+	parent := &CAKey{Provider: root.Provider, Slot: root.Slot, Certificate: rootCert}
+	issued, err := CrossSign(subCert, parent, time.Hour)
+	if err != nil {
+		t.Fatalf("Cross-sign failed: %v", err)
+	}
+	if err := WriteCertificateFile(sub.CertFile, issued); err != nil {
+		t.Fatalf("Failed to write sub CA certificate: %v", err)
+	}
+
+	if _, err := sub.RenewCertificate(time.Hour, nil); err == nil {
+		t.Errorf("Expected renewing a sub CA without its parent to fail")
+	}
+	renewed, err := sub.RenewCertificate(48*time.Hour, parent)
+	if err != nil {
+		t.Fatalf("Renew failed: %v", err)
+	}
+	if renewed.CheckSignatureFrom(rootCert) != nil || renewed.NotAfter.After(rootCert.NotAfter) {
+		t.Errorf("Renewed sub CA must be signed by and not outlive its parent")
+	}
+}
+
+// mustSign issues a server certificate from c
+func mustSign(t *testing.T, c *CA, cn string) []byte {
+	t.Helper()
+
+	certPEM, err := c.SignCertificate(newTestCSR(t, cn, cn), "server")
+	if err != nil {
+		t.Fatalf("Failed to sign %s: %v", cn, err)
+	}
+	return certPEM
+}
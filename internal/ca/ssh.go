@@ -0,0 +1,554 @@
+package ca
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/billchurch/PiCA/internal/audit"
+	"github.com/billchurch/PiCA/internal/crypto"
+)
+
+// SSH certificate types used in profiles
+const (
+	SSHUserCert = "user"
+	SSHHostCert = "host"
+)
+
+// sshClockSkew backdates certificates so slightly slow clocks accept them
+const sshClockSkew = 5 * time.Minute
+
+// sshCriticalOptions are the critical options OpenSSH understands
+var sshCriticalOptions = map[string]bool{
+	"force-command":   true,
+	"source-address":  true,
+	"verify-required": true,
+}
+
+// sshExtensions are the extensions OpenSSH understands
+var sshExtensions = map[string]bool{
+	"no-touch-required":       true,
+	"permit-X11-forwarding":   true,
+	"permit-agent-forwarding": true,
+	"permit-port-forwarding":  true,
+	"permit-pty":              true,
+	"permit-user-rc":          true,
+}
+
+// sshDefaultExtensions are granted to user certificates whose profile does
+// not list extensions, matching ssh-keygen
+var sshDefaultExtensions = map[string]string{
+	"permit-X11-forwarding":   "",
+	"permit-agent-forwarding": "",
+	"permit-port-forwarding":  "",
+	"permit-pty":              "",
+	"permit-user-rc":          "",
+}
+
+// ErrSSHProfileNotFound is returned when a request names an unknown profile
+var ErrSSHProfileNotFound = errors.New("SSH profile not found")
+
+// SSHProfile defines the certificates issued for one kind of request:
+//
+//	{
+//	  "type": "user",
+//	  "validity": "16h",
+//	  "allowed_principals": ["*"],
+//	  "critical_options": {"source-address": "10.0.0.0/8"},
+//	  "extensions": {"permit-pty": "", "permit-agent-forwarding": ""}
+//	}
+type SSHProfile struct {
+	// Type is user or host
+	Type string `json:"type"`
+	// Validity is the longest lifetime a certificate can have
+	Validity string `json:"validity"`
+	// Principals are used when the request does not name any
+	Principals []string `json:"principals,omitempty"`
+	// AllowedPrincipals are glob patterns the requested principals must
+	// match. When empty only the profile's principals can be requested.
+	AllowedPrincipals []string          `json:"allowed_principals,omitempty"`
+	CriticalOptions   map[string]string `json:"critical_options,omitempty"`
+	// Extensions default to the ssh-keygen set for user certificates when
+	// omitted; an empty object grants none
+	Extensions map[string]string `json:"extensions,omitempty"`
+}
+
+// SSHConfig is the SSH profile file
+type SSHConfig struct {
+	// Default is the profile used when a request names none
+	Default  string                 `json:"default,omitempty"`
+	Profiles map[string]*SSHProfile `json:"profiles"`
+}
+
+// LoadSSHConfig reads and validates an SSH profile file
+func LoadSSHConfig(filename string) (*SSHConfig, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read SSH config: %w", err)
+	}
+	var cfg SSHConfig
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse SSH config: %w", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// Validate checks the profiles
+func (cfg *SSHConfig) Validate() error {
+	if len(cfg.Profiles) == 0 {
+		return fmt.Errorf("SSH config defines no profiles")
+	}
+	if cfg.Default != "" && cfg.Profiles[cfg.Default] == nil {
+		return fmt.Errorf("default SSH profile %q is not defined", cfg.Default)
+	}
+	for name, p := range cfg.Profiles {
+		if p == nil {
+			return fmt.Errorf("SSH profile %s is empty", name)
+		}
+		if err := p.Validate(); err != nil {
+			return fmt.Errorf("SSH profile %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// Validate checks the profile's type, validity, principals and options
+func (p *SSHProfile) Validate() error {
+	if p.Type != SSHUserCert && p.Type != SSHHostCert {
+		return fmt.Errorf("type must be %s or %s, not %q", SSHUserCert, SSHHostCert, p.Type)
+	}
+	if d, err := time.ParseDuration(p.Validity); err != nil || d <= 0 {
+		return fmt.Errorf("invalid validity %q", p.Validity)
+	}
+	for _, pattern := range p.AllowedPrincipals {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid principal pattern %q", pattern)
+		}
+	}
+
+	// OpenSSH ignores options and extensions on host certificates
+	if p.Type == SSHHostCert && (len(p.CriticalOptions) > 0 || len(p.Extensions) > 0) {
+		return fmt.Errorf("host certificates cannot carry critical options or extensions")
+	}
+	for name, value := range p.CriticalOptions {
+		if !sshCriticalOptions[name] && !strings.Contains(name, "@") {
+			return fmt.Errorf("unknown critical option %q", name)
+		}
+		if name == "source-address" {
+			if err := checkSourceAddress(value); err != nil {
+				return err
+			}
+		}
+	}
+	for name := range p.Extensions {
+		if !sshExtensions[name] && !strings.Contains(name, "@") {
+			return fmt.Errorf("unknown extension %q", name)
+		}
+	}
+	return nil
+}
+
+// checkSourceAddress validates the comma-separated addresses and CIDR
+// blocks of a source-address option
+func checkSourceAddress(value string) error {
+	for _, addr := range strings.Split(value, ",") {
+		if net.ParseIP(addr) != nil {
+			continue
+		}
+		if _, _, err := net.ParseCIDR(addr); err != nil {
+			return fmt.Errorf("invalid source-address %q", addr)
+		}
+	}
+	return nil
+}
+
+// SSHRequest asks for an SSH certificate
+type SSHRequest struct {
+	// PublicKey is the key to certify in authorized_keys format
+	PublicKey string `json:"publicKey"`
+	// KeyID identifies the certificate in server logs; defaults to the
+	// first principal
+	KeyID      string   `json:"keyId,omitempty"`
+	Principals []string `json:"principals,omitempty"`
+	Profile    string   `json:"profile,omitempty"`
+	// Validity shortens the profile's validity
+	Validity string `json:"validity,omitempty"`
+}
+
+// SSHCA issues OpenSSH user and host certificates with a key held in a
+// provider slot
+type SSHCA struct {
+	Provider crypto.Provider
+	Slot     crypto.Slot
+	// ConfigFile holds the SSH profiles
+	ConfigFile string
+	// DatabaseDir holds the issuance and revocation records
+	DatabaseDir string
+	// KRLFile is where the key revocation list is published after every
+	// revocation
+	KRLFile string
+
+	store *Store
+}
+
+// NewSSHCA creates an SSH CA using the key in slot
+func NewSSHCA(provider crypto.Provider, slot crypto.Slot, configFile, databaseDir string) *SSHCA {
+	return &SSHCA{
+		Provider:    provider,
+		Slot:        slot,
+		ConfigFile:  configFile,
+		DatabaseDir: databaseDir,
+	}
+}
+
+// Store returns the SSH certificate database, opening it on first use
+func (c *SSHCA) Store() (*Store, error) {
+	if c.store != nil {
+		return c.store, nil
+	}
+	if c.DatabaseDir == "" {
+		return nil, errors.New("no database directory configured")
+	}
+
+	store, err := OpenStore(c.DatabaseDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open SSH certificate database: %w", err)
+	}
+	c.store = store
+	return store, nil
+}
+
+// Config loads the SSH profiles
+func (c *SSHCA) Config() (*SSHConfig, error) {
+	if c.ConfigFile == "" {
+		return nil, errors.New("no SSH config file configured")
+	}
+	return LoadSSHConfig(c.ConfigFile)
+}
+
+// GenerateKey creates the SSH CA key. An existing key is never replaced.
+func (c *SSHCA) GenerateKey(algorithm string, bits int) (ssh.PublicKey, error) {
+	if _, err := c.Provider.GetPublicKey(c.Slot); err == nil {
+		return nil, fmt.Errorf("slot %X already holds a key", int(c.Slot))
+	}
+	if err := c.Provider.GenerateKey(c.Slot, algorithm, bits); err != nil {
+		return nil, fmt.Errorf("failed to generate SSH CA key: %w", err)
+	}
+	return c.PublicKey()
+}
+
+// PublicKey returns the CA key that servers and clients trust
+func (c *SSHCA) PublicKey() (ssh.PublicKey, error) {
+	pub, err := c.Provider.GetPublicKey(c.Slot)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get SSH CA key: %w", err)
+	}
+	return ssh.NewPublicKey(pub)
+}
+
+// signer returns an SSH signer backed by the provider
+func (c *SSHCA) signer() (ssh.Signer, error) {
+	signer, err := crypto.CreateProviderSigner(c.Provider, c.Slot)
+	if err != nil {
+		return nil, err
+	}
+	return ssh.NewSignerFromSigner(signer)
+}
+
+// Sign issues a certificate for the request and records it
+func (c *SSHCA) Sign(req *SSHRequest) (*ssh.Certificate, error) {
+	cfg, err := c.Config()
+	if err != nil {
+		return nil, err
+	}
+	name := req.Profile
+	if name == "" {
+		name = cfg.Default
+	}
+	profile := cfg.Profiles[name]
+	if profile == nil {
+		return nil, fmt.Errorf("%w: %q", ErrSSHProfileNotFound, name)
+	}
+
+	key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(req.PublicKey))
+	if err != nil {
+		return nil, fmt.Errorf("invalid public key: %w", err)
+	}
+	if _, ok := key.(*ssh.Certificate); ok {
+		return nil, fmt.Errorf("invalid public key: got a certificate")
+	}
+
+	validity, _ := time.ParseDuration(profile.Validity)
+	if req.Validity != "" {
+		d, err := time.ParseDuration(req.Validity)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid validity %q", req.Validity)
+		}
+		if d > validity {
+			return nil, fmt.Errorf("validity %s exceeds the %s allowed by profile %s", d, validity, name)
+		}
+		validity = d
+	}
+
+	principals, err := profile.principals(req.Principals)
+	if err != nil {
+		return nil, err
+	}
+	keyID := req.KeyID
+	if keyID == "" {
+		keyID = principals[0]
+	}
+
+	store, err := c.Store()
+	if err != nil {
+		return nil, err
+	}
+	serial, err := c.newSerial(store)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	cert := &ssh.Certificate{
+		Key:             key,
+		Serial:          serial,
+		CertType:        ssh.UserCert,
+		KeyId:           keyID,
+		ValidPrincipals: principals,
+		ValidAfter:      uint64(now.Add(-sshClockSkew).Unix()),
+		ValidBefore:     uint64(now.Add(validity).Unix()),
+		Permissions: ssh.Permissions{
+			CriticalOptions: copyOptions(profile.CriticalOptions),
+			Extensions:      copyOptions(profile.Extensions),
+		},
+	}
+	if profile.Type == SSHHostCert {
+		cert.CertType = ssh.HostCert
+	} else if profile.Extensions == nil {
+		cert.Permissions.Extensions = copyOptions(sshDefaultExtensions)
+	}
+
+	signer, err := c.signer()
+	if err != nil {
+		return nil, err
+	}
+	if err := cert.SignCert(rand.Reader, signer); err != nil {
+		return nil, fmt.Errorf("failed to sign SSH certificate: %w", err)
+	}
+
+	record := &CertificateRecord{
+		SerialNumber: FormatSSHSerial(serial),
+		Subject:      keyID,
+		Profile:      name,
+		NotBefore:    time.Unix(int64(cert.ValidAfter), 0).UTC(),
+		NotAfter:     time.Unix(int64(cert.ValidBefore), 0).UTC(),
+		Status:       StatusValid,
+		Metadata: map[string]string{
+			"type":        profile.Type,
+			"principals":  strings.Join(principals, ","),
+			"fingerprint": ssh.FingerprintSHA256(key),
+		},
+	}
+	if profile.Type == SSHHostCert {
+		record.DNSNames = principals
+	}
+	if err := store.Add(record); err != nil {
+		return nil, fmt.Errorf("failed to record SSH certificate: %w", err)
+	}
+
+	audit.Record(audit.Event{
+		Type:    audit.EventSSHIssued,
+		Subject: keyID,
+		Serial:  record.SerialNumber,
+		Profile: name,
+		Details: map[string]interface{}{
+			"type":       profile.Type,
+			"principals": principals,
+		},
+	})
+	return cert, nil
+}
+
+// principals returns the principals to certify for a request
+func (p *SSHProfile) principals(requested []string) ([]string, error) {
+	if len(requested) == 0 {
+		if len(p.Principals) == 0 {
+			// A certificate without principals is valid for any of them
+			return nil, fmt.Errorf("at least one principal is required")
+		}
+		return append([]string{}, p.Principals...), nil
+	}
+
+	allowed := p.AllowedPrincipals
+	if len(allowed) == 0 {
+		allowed = p.Principals
+	}
+	for _, principal := range requested {
+		if principal == "" {
+			return nil, fmt.Errorf("empty principal")
+		}
+		if !matchesPattern(allowed, principal) {
+			return nil, fmt.Errorf("principal %q is not allowed by the profile", principal)
+		}
+	}
+	return append([]string{}, requested...), nil
+}
+
+// matchesPattern reports whether name matches one of the glob patterns
+func matchesPattern(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// copyOptions copies a profile's options so certificates never share maps
+func copyOptions(options map[string]string) map[string]string {
+	if options == nil {
+		return nil
+	}
+	c := make(map[string]string, len(options))
+	for k, v := range options {
+		c[k] = v
+	}
+	return c
+}
+
+// newSerial returns a random non-zero serial not used by a recorded
+// certificate. OpenSSH KRLs cannot revoke serial 0.
+func (c *SSHCA) newSerial(store *Store) (uint64, error) {
+	var b [8]byte
+	for {
+		if _, err := rand.Read(b[:]); err != nil {
+			return 0, fmt.Errorf("failed to generate serial number: %w", err)
+		}
+		serial := binary.BigEndian.Uint64(b[:]) >> 1
+		if serial == 0 {
+			continue
+		}
+		if _, err := store.Get(FormatSSHSerial(serial)); errors.Is(err, ErrCertificateNotFound) {
+			return serial, nil
+		}
+	}
+}
+
+// Revoke revokes a certificate by serial number (hex, as listed) and
+// republishes the KRL
+func (c *SSHCA) Revoke(serial, reason string) (*CertificateRecord, error) {
+	if _, err := parseSSHSerial(serial); err != nil {
+		return nil, err
+	}
+	store, err := c.Store()
+	if err != nil {
+		return nil, err
+	}
+	record, err := store.Revoke(serial, reason, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	// The KRL version increases with every revocation
+	state := store.CRLState()
+	state.Number++
+	state.BaseThisUpdate = record.RevokedAt
+	if err := store.UpdateCRLState(state); err != nil {
+		return nil, err
+	}
+
+	audit.Record(audit.Event{
+		Type:    audit.EventSSHRevoked,
+		Subject: record.Subject,
+		Serial:  record.SerialNumber,
+		Profile: record.Profile,
+		Details: map[string]interface{}{"reason": reason},
+	})
+
+	if c.KRLFile != "" {
+		if err := c.PublishKRL(); err != nil {
+			return record, err
+		}
+	}
+	return record, nil
+}
+
+// FormatSSHSerial returns a serial number as it is listed in the database
+func FormatSSHSerial(serial uint64) string {
+	return fmt.Sprintf("%X", serial)
+}
+
+// parseSSHSerial parses a hex serial number as listed in the database
+func parseSSHSerial(serial string) (uint64, error) {
+	n, err := strconv.ParseUint(NormalizeSerial(serial), 16, 64)
+	if err != nil || n == 0 {
+		return 0, fmt.Errorf("invalid SSH certificate serial %q", serial)
+	}
+	return n, nil
+}
+
+// KRL returns the OpenSSH key revocation list of the revoked certificates
+func (c *SSHCA) KRL() ([]byte, error) {
+	key, err := c.PublicKey()
+	if err != nil {
+		return nil, err
+	}
+	store, err := c.Store()
+	if err != nil {
+		return nil, err
+	}
+
+	var serials []uint64
+	for _, r := range store.Revoked(time.Time{}) {
+		if serial, err := parseSSHSerial(r.SerialNumber); err == nil {
+			serials = append(serials, serial)
+		}
+	}
+	sort.Slice(serials, func(i, j int) bool { return serials[i] < serials[j] })
+
+	state := store.CRLState()
+	krl := &KRL{
+		Version:   uint64(state.Number),
+		Generated: state.BaseThisUpdate,
+		Comment:   "PiCA SSH CA",
+		CAKey:     key,
+		Serials:   serials,
+	}
+	return krl.Marshal(), nil
+}
+
+// PublishKRL writes the KRL to KRLFile
+func (c *SSHCA) PublishKRL() error {
+	if c.KRLFile == "" {
+		return errors.New("no KRL file configured")
+	}
+	data, err := c.KRL()
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(c.KRLFile, data, 0644); err != nil {
+		return fmt.Errorf("failed to publish KRL: %w", err)
+	}
+	return nil
+}
+
+// SSHDatabaseDir returns where SSH certificates are recorded for a CA
+// database directory, keeping them apart from X.509 serial numbers
+func SSHDatabaseDir(databaseDir string) string {
+	return filepath.Join(databaseDir, "ssh")
+}
@@ -0,0 +1,229 @@
+package ca
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/billchurch/PiCA/internal/crypto"
+)
+
+const testSSHConfig = `{
+	"default": "user",
+	"profiles": {
+		"user": {
+			"type": "user",
+			"validity": "16h",
+			"allowed_principals": ["alice", "deploy-*"],
+			"critical_options": {"source-address": "10.0.0.0/8,192.168.1.1"}
+		},
+		"host": {
+			"type": "host",
+			"validity": "8760h",
+			"allowed_principals": ["*.example.com"]
+		},
+		"ci": {
+			"type": "user",
+			"validity": "1h",
+			"principals": ["ci"],
+			"extensions": {}
+		}
+	}
+}`
+
+// newTestSSHCA returns an SSH CA with a fresh key in the software provider
+func newTestSSHCA(t *testing.T) *SSHCA {
+	t.Helper()
+	dir := t.TempDir()
+
+	provider, err := crypto.NewSoftwareProvider(map[string]interface{}{"directory": filepath.Join(dir, "keys")})
+	if err != nil {
+		t.Fatalf("Failed to create provider: %v", err)
+	}
+	if err := provider.Connect(); err != nil {
+		t.Fatalf("Failed to connect provider: %v", err)
+	}
+
+	configFile := filepath.Join(dir, "ssh.json")
+	if err := os.WriteFile(configFile, []byte(testSSHConfig), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+
+	c := NewSSHCA(provider, crypto.SlotSSH, configFile, SSHDatabaseDir(filepath.Join(dir, "db")))
+	c.KRLFile = filepath.Join(dir, "ssh.krl")
+	if _, err := c.GenerateKey("ECDSA", 256); err != nil {
+		t.Fatalf("GenerateKey failed: %v", err)
+	}
+	return c
+}
+
+// newTestSSHKey returns a fresh public key in authorized_keys format
+func newTestSSHKey(t *testing.T) string {
+	t.Helper()
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	key, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatalf("Failed to convert key: %v", err)
+	}
+	return string(ssh.MarshalAuthorizedKey(key))
+}
+
+func TestSSHSign(t *testing.T) {
+	c := newTestSSHCA(t)
+	caKey, err := c.PublicKey()
+	if err != nil {
+		t.Fatalf("PublicKey failed: %v", err)
+	}
+	if _, err := c.GenerateKey("ECDSA", 256); err == nil {
+		t.Error("Expected GenerateKey to refuse replacing the CA key")
+	}
+
+	cert, err := c.Sign(&SSHRequest{PublicKey: newTestSSHKey(t), Principals: []string{"alice", "deploy-web"}, Validity: "1h"})
+	if err != nil {
+		t.Fatalf("Sign failed: %v", err)
+	}
+	if cert.CertType != ssh.UserCert || cert.KeyId != "alice" || len(cert.ValidPrincipals) != 2 {
+		t.Errorf("Unexpected certificate: type %d, key ID %q, principals %v", cert.CertType, cert.KeyId, cert.ValidPrincipals)
+	}
+	if cert.CriticalOptions["source-address"] != "10.0.0.0/8,192.168.1.1" {
+		t.Errorf("Critical options not applied: %v", cert.CriticalOptions)
+	}
+	if _, ok := cert.Extensions["permit-pty"]; !ok {
+		t.Errorf("Expected default extensions, got %v", cert.Extensions)
+	}
+	if lifetime := time.Duration(cert.ValidBefore-cert.ValidAfter) * time.Second; lifetime > time.Hour+sshClockSkew {
+		t.Errorf("Requested validity ignored: %s", lifetime)
+	}
+
+	// The certificate verifies against the CA key
+	checker := &ssh.CertChecker{
+		IsUserAuthority: func(auth ssh.PublicKey) bool {
+			return string(auth.Marshal()) == string(caKey.Marshal())
+		},
+	}
+	if err := checker.CheckCert("deploy-web", cert); err != nil {
+		t.Errorf("Certificate does not verify: %v", err)
+	}
+
+	host, err := c.Sign(&SSHRequest{PublicKey: newTestSSHKey(t), Principals: []string{"web1.example.com"}, Profile: "host"})
+	if err != nil {
+		t.Fatalf("Sign host failed: %v", err)
+	}
+	if host.CertType != ssh.HostCert || len(host.Extensions) != 0 {
+		t.Errorf("Unexpected host certificate: type %d, extensions %v", host.CertType, host.Extensions)
+	}
+
+	ci, err := c.Sign(&SSHRequest{PublicKey: newTestSSHKey(t), Profile: "ci"})
+	if err != nil {
+		t.Fatalf("Sign ci failed: %v", err)
+	}
+	if len(ci.ValidPrincipals) != 1 || ci.ValidPrincipals[0] != "ci" || len(ci.Extensions) != 0 {
+		t.Errorf("Unexpected ci certificate: principals %v, extensions %v", ci.ValidPrincipals, ci.Extensions)
+	}
+
+	store, _ := c.Store()
+	if records := store.List(); len(records) != 3 {
+		t.Errorf("Expected 3 records, got %d", len(records))
+	}
+
+	for name, req := range map[string]*SSHRequest{
+		"principal not allowed": {PublicKey: newTestSSHKey(t), Principals: []string{"root"}},
+		"no principals":         {PublicKey: newTestSSHKey(t)},
+		"validity too long":     {PublicKey: newTestSSHKey(t), Principals: []string{"alice"}, Validity: "17h"},
+		"unknown profile":       {PublicKey: newTestSSHKey(t), Principals: []string{"alice"}, Profile: "admin"},
+		"fixed principals":      {PublicKey: newTestSSHKey(t), Principals: []string{"alice"}, Profile: "ci"},
+		"invalid key":           {PublicKey: "ssh-ed25519 AAAA", Principals: []string{"alice"}},
+		"certificate as key":    {PublicKey: string(ssh.MarshalAuthorizedKey(cert)), Principals: []string{"alice"}},
+	} {
+		if _, err := c.Sign(req); err == nil {
+			t.Errorf("%s: expected request to be rejected", name)
+		}
+	}
+}
+
+func TestSSHConfigValidation(t *testing.T) {
+	for name, profile := range map[string]SSHProfile{
+		"unknown type":       {Type: "robot", Validity: "1h"},
+		"bad validity":       {Type: "user", Validity: "forever"},
+		"host options":       {Type: "host", Validity: "1h", Extensions: map[string]string{"permit-pty": ""}},
+		"unknown option":     {Type: "user", Validity: "1h", CriticalOptions: map[string]string{"no-pty": ""}},
+		"unknown extension":  {Type: "user", Validity: "1h", Extensions: map[string]string{"permit-everything": ""}},
+		"bad source address": {Type: "user", Validity: "1h", CriticalOptions: map[string]string{"source-address": "10.0.0.0/33"}},
+		"bad pattern":        {Type: "user", Validity: "1h", AllowedPrincipals: []string{"["}},
+	} {
+		if err := profile.Validate(); err == nil {
+			t.Errorf("%s: expected profile to be rejected", name)
+		}
+	}
+
+	vendor := SSHProfile{Type: "user", Validity: "1h", Extensions: map[string]string{"login@example.com": "ops"}}
+	if err := vendor.Validate(); err != nil {
+		t.Errorf("Vendor extension rejected: %v", err)
+	}
+}
+
+func TestSSHRevokeAndKRL(t *testing.T) {
+	c := newTestSSHCA(t)
+
+	revoked, err := c.Sign(&SSHRequest{PublicKey: newTestSSHKey(t), Principals: []string{"alice"}})
+	if err != nil {
+		t.Fatalf("Sign failed: %v", err)
+	}
+	kept, err := c.Sign(&SSHRequest{PublicKey: newTestSSHKey(t), Principals: []string{"deploy-api"}})
+	if err != nil {
+		t.Fatalf("Sign failed: %v", err)
+	}
+
+	serial := FormatSSHSerial(revoked.Serial)
+	record, err := c.Revoke(serial, "keyCompromise")
+	if err != nil {
+		t.Fatalf("Revoke failed: %v", err)
+	}
+	if record.Status != StatusRevoked {
+		t.Errorf("Expected revoked record, got %s", record.Status)
+	}
+	if _, err := c.Revoke(serial, ""); err == nil {
+		t.Error("Expected revoking twice to fail")
+	}
+	if _, err := c.Revoke("zz", ""); err == nil {
+		t.Error("Expected invalid serial to be rejected")
+	}
+
+	krl, err := os.ReadFile(c.KRLFile)
+	if err != nil {
+		t.Fatalf("KRL not published: %v", err)
+	}
+	if len(krl) < 44 || string(krl[:7]) != "SSHKRL\n" {
+		t.Fatalf("KRL lacks the OpenSSH header")
+	}
+
+	// Let OpenSSH itself check the KRL when it is installed
+	sshKeygen, err := exec.LookPath("ssh-keygen")
+	if err != nil {
+		t.Skip("ssh-keygen not installed")
+	}
+	dir := t.TempDir()
+	for _, tc := range []struct {
+		cert    *ssh.Certificate
+		revoked bool
+	}{{revoked, true}, {kept, false}} {
+		certFile := filepath.Join(dir, "cert.pub")
+		if err := os.WriteFile(certFile, ssh.MarshalAuthorizedKey(tc.cert), 0644); err != nil {
+			t.Fatalf("Failed to write certificate: %v", err)
+		}
+		out, err := exec.Command(sshKeygen, "-Q", "-f", c.KRLFile, certFile).CombinedOutput()
+		if tc.revoked != strings.Contains(string(out), "REVOKED") || (err != nil) != tc.revoked {
+			t.Errorf("ssh-keygen -Q for serial %d (revoked %v): %v: %s", tc.cert.Serial, tc.revoked, err, out)
+		}
+	}
+}
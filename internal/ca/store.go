@@ -0,0 +1,300 @@
+package ca
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Certificate status values stored in the database
+const (
+	StatusValid   = "Valid"
+	StatusRevoked = "Revoked"
+)
+
+// ErrCertificateNotFound is returned when a serial number is not in the store
+var ErrCertificateNotFound = errors.New("certificate not found in store")
+
+// CertificateRecord is the issuance record kept for every certificate
+type CertificateRecord struct {
+	SerialNumber     string            `json:"serialNumber"`
+	Subject          string            `json:"subject"`
+	DNSNames         []string          `json:"dnsNames,omitempty"`
+	Profile          string            `json:"profile,omitempty"`
+	NotBefore        time.Time         `json:"notBefore"`
+	NotAfter         time.Time         `json:"notAfter"`
+	Status           string            `json:"status"`
+	RevokedAt        time.Time         `json:"revokedAt,omitempty"`
+	RevocationReason string            `json:"revocationReason,omitempty"`
+	Metadata         map[string]string `json:"metadata,omitempty"`
+}
+
+// CRLState tracks CRL numbering and publication times
+type CRLState struct {
+	Number          int64     `json:"number"`
+	BaseNumber      int64     `json:"baseNumber"`
+	BaseThisUpdate  time.Time `json:"baseThisUpdate"`
+	BaseNextUpdate  time.Time `json:"baseNextUpdate"`
+	DeltaThisUpdate time.Time `json:"deltaThisUpdate,omitempty"`
+	DeltaNextUpdate time.Time `json:"deltaNextUpdate,omitempty"`
+}
+
+// Store is a small JSON-file-backed certificate database
+type Store struct {
+	dir     string
+	records map[string]*CertificateRecord
+	crl     CRLState
+	mutex   sync.Mutex
+}
+
+// OpenStore opens (or creates) the certificate database in dir
+func OpenStore(dir string) (*Store, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create database directory: %w", err)
+	}
+
+	s := &Store{
+		dir:     dir,
+		records: make(map[string]*CertificateRecord),
+	}
+
+	var records []*CertificateRecord
+	if err := readJSON(filepath.Join(dir, "certificates.json"), &records); err != nil {
+		return nil, err
+	}
+	for _, r := range records {
+		s.records[NormalizeSerial(r.SerialNumber)] = r
+	}
+
+	if err := readJSON(filepath.Join(dir, "crl.json"), &s.crl); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// NormalizeSerial returns the canonical upper-case hex form of a serial number
+func NormalizeSerial(serial string) string {
+	serial = strings.TrimPrefix(strings.TrimPrefix(serial, "0x"), "0X")
+	serial = strings.ReplaceAll(serial, ":", "")
+	n, ok := new(big.Int).SetString(serial, 16)
+	if !ok {
+		return strings.ToUpper(serial)
+	}
+	return fmt.Sprintf("%X", n)
+}
+
+// RecordFromCertificate builds a valid record for a freshly issued certificate
+func RecordFromCertificate(cert *x509.Certificate, profile string) *CertificateRecord {
+	return &CertificateRecord{
+		SerialNumber: fmt.Sprintf("%X", cert.SerialNumber),
+		Subject:      cert.Subject.CommonName,
+		DNSNames:     cert.DNSNames,
+		Profile:      profile,
+		NotBefore:    cert.NotBefore,
+		NotAfter:     cert.NotAfter,
+		Status:       StatusValid,
+	}
+}
+
+// Add adds or replaces a record
+func (s *Store) Add(record *CertificateRecord) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	record.SerialNumber = NormalizeSerial(record.SerialNumber)
+	s.records[record.SerialNumber] = record
+	return s.saveRecords()
+}
+
+// AddAll inserts or replaces several records with a single write. Nothing
+// is stored when the write fails.
+func (s *Store) AddAll(records []*CertificateRecord) error {
+	if len(records) == 0 {
+		return nil
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	previous := make(map[string]*CertificateRecord, len(records))
+	for _, record := range records {
+		record.SerialNumber = NormalizeSerial(record.SerialNumber)
+		if _, ok := previous[record.SerialNumber]; !ok {
+			previous[record.SerialNumber] = s.records[record.SerialNumber]
+		}
+		s.records[record.SerialNumber] = record
+	}
+	if err := s.saveRecords(); err != nil {
+		for serial, record := range previous {
+			if record == nil {
+				delete(s.records, serial)
+			} else {
+				s.records[serial] = record
+			}
+		}
+		return err
+	}
+	return nil
+}
+
+// Get returns a copy of the record for a serial number
+func (s *Store) Get(serial string) (*CertificateRecord, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	r, ok := s.records[NormalizeSerial(serial)]
+	if !ok {
+		return nil, ErrCertificateNotFound
+	}
+	c := *r
+	return &c, nil
+}
+
+// List returns copies of all records ordered by issuance time
+func (s *Store) List() []*CertificateRecord {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	list := make([]*CertificateRecord, 0, len(s.records))
+	for _, r := range s.records {
+		c := *r
+		list = append(list, &c)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].NotBefore.Before(list[j].NotBefore)
+	})
+	return list
+}
+
+// Revoke marks a certificate as revoked. Serial numbers that were issued
+// before the store existed are recorded with only the revocation details.
+func (s *Store) Revoke(serial, reason string, at time.Time) (*CertificateRecord, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	serial = NormalizeSerial(serial)
+	r, ok := s.records[serial]
+	if !ok {
+		r = &CertificateRecord{SerialNumber: serial}
+		s.records[serial] = r
+	}
+	if r.Status == StatusRevoked {
+		return nil, fmt.Errorf("certificate %s is already revoked", serial)
+	}
+
+	r.Status = StatusRevoked
+	r.RevokedAt = at.UTC()
+	r.RevocationReason = reason
+
+	if err := s.saveRecords(); err != nil {
+		return nil, err
+	}
+	c := *r
+	return &c, nil
+}
+
+// Revoked returns the revoked records, optionally only those revoked after since
+func (s *Store) Revoked(since time.Time) []*CertificateRecord {
+	var revoked []*CertificateRecord
+	for _, r := range s.List() {
+		if r.Status == StatusRevoked && r.RevokedAt.After(since) {
+			revoked = append(revoked, r)
+		}
+	}
+	return revoked
+}
+
+// CRLState returns the current CRL numbering state
+func (s *Store) CRLState() CRLState {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.crl
+}
+
+// UpdateCRLState replaces the CRL numbering state
+func (s *Store) UpdateCRLState(state CRLState) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.crl = state
+	return writeJSON(filepath.Join(s.dir, "crl.json"), s.crl)
+}
+
+// saveRecords persists the records. Callers must hold the mutex.
+func (s *Store) saveRecords() error {
+	records := make([]*CertificateRecord, 0, len(s.records))
+	for _, r := range s.records {
+		records = append(records, r)
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].SerialNumber < records[j].SerialNumber
+	})
+	return writeJSON(filepath.Join(s.dir, "certificates.json"), records)
+}
+
+// readJSON decodes a JSON file, leaving v untouched if it does not exist
+func readJSON(filename string, v interface{}) error {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to read %s: %w", filename, err)
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("failed to parse %s: %w", filename, err)
+	}
+	return nil
+}
+
+// writeJSON encodes v to a file atomically
+func writeJSON(filename string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode %s: %w", filename, err)
+	}
+	return writeFileAtomic(filename, data, 0600)
+}
+
+// writeFileAtomic writes data to a temporary file and renames it into place
+// so readers never observe a partially written file
+func writeFileAtomic(filename string, data []byte, perm os.FileMode) error {
+	dir := filepath.Dir(filename)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(filename)+".*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write temporary file: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to sync temporary file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close temporary file: %w", err)
+	}
+	if err := os.Chmod(tmp.Name(), perm); err != nil {
+		return fmt.Errorf("failed to set permissions: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), filename); err != nil {
+		return fmt.Errorf("failed to replace %s: %w", filename, err)
+	}
+	return nil
+}
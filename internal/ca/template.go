@@ -0,0 +1,588 @@
+package ca
+
+import (
+	"crypto/x509/pkix"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cloudflare/cfssl/config"
+
+	"github.com/billchurch/PiCA/internal/audit"
+)
+
+// DefaultTemplate is the template name that maps to the default signing
+// profile of the cfssl config
+const DefaultTemplate = "default"
+
+// ErrTemplateNotFound is returned for unknown templates or versions
+var ErrTemplateNotFound = errors.New("template not found")
+
+// Template describes a kind of certificate the CA issues. Templates are
+// stored by PiCA with their history and written to the cfssl config as
+// signing profiles of the same name.
+type Template struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+
+	// KeyUsages and ExtKeyUsages use the cfssl usage names, such as
+	// "digital signature" and "server auth"
+	KeyUsages    []string `json:"key_usages,omitempty"`
+	ExtKeyUsages []string `json:"ext_key_usages,omitempty"`
+	// Validity is the lifetime of issued certificates, e.g. "8760h"
+	Validity string `json:"validity"`
+
+	// Subject fills in subject fields the CSR leaves empty
+	Subject *SubjectDefaults `json:"subject,omitempty"`
+	// SANs restricts the subject alternative names of requests
+	SANs *SANRequirements `json:"sans,omitempty"`
+	// Policies are certificate policy OIDs added to issued certificates
+	Policies []string `json:"policies,omitempty"`
+	// OCSPURL and IssuerURLs fill the authority information access extension
+	OCSPURL    string   `json:"ocsp_url,omitempty"`
+	IssuerURLs []string `json:"issuer_urls,omitempty"`
+
+	// IsCA issues CA certificates limited to MaxPathLen further levels
+	IsCA       bool `json:"is_ca,omitempty"`
+	MaxPathLen *int `json:"max_path_len,omitempty"`
+
+	// Options carries the other PiCA profile settings: constraints, issuance
+	// policy and linting
+	Options *ProfileOptions `json:"pica,omitempty"`
+
+	// Version is incremented by every saved change
+	Version int       `json:"version"`
+	Updated time.Time `json:"updated"`
+	// Comment describes the change that produced this version
+	Comment string `json:"comment,omitempty"`
+}
+
+// SubjectDefaults are subject fields added to certificates whose CSR does
+// not set them
+type SubjectDefaults struct {
+	Organization       string `json:"o,omitempty"`
+	OrganizationalUnit string `json:"ou,omitempty"`
+	Country            string `json:"c,omitempty"`
+	Province           string `json:"st,omitempty"`
+	Locality           string `json:"l,omitempty"`
+}
+
+// Apply fills the empty fields of name
+func (d *SubjectDefaults) Apply(name *pkix.Name) {
+	if d == nil {
+		return
+	}
+	fill := func(field *[]string, value string) {
+		if len(*field) == 0 && value != "" {
+			*field = []string{value}
+		}
+	}
+	fill(&name.Organization, d.Organization)
+	fill(&name.OrganizationalUnit, d.OrganizationalUnit)
+	fill(&name.Country, d.Country)
+	fill(&name.Province, d.Province)
+	fill(&name.Locality, d.Locality)
+}
+
+// SANRequirements restrict the subject alternative names of a request
+type SANRequirements struct {
+	// Required rejects requests without any subject alternative name
+	Required bool `json:"required,omitempty"`
+	// Types lists the allowed kinds of names: dns, ip, email, uri. An empty
+	// list allows all of them.
+	Types []string `json:"types,omitempty"`
+	// Max limits the number of names; 0 means no limit
+	Max int `json:"max,omitempty"`
+}
+
+// Validate checks the requirements
+func (r *SANRequirements) Validate() error {
+	for _, t := range r.Types {
+		switch t {
+		case "dns", "ip", "email", "uri":
+		default:
+			return fmt.Errorf("unknown SAN type %q", t)
+		}
+	}
+	if r.Max < 0 {
+		return errors.New("SAN maximum must not be negative")
+	}
+	return nil
+}
+
+// templateName restricts names to what is safe as a file and profile name
+var templateName = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+// Validate checks that the template can be turned into a signing profile
+func (t *Template) Validate() error {
+	if !templateName.MatchString(t.Name) {
+		return fmt.Errorf("invalid template name %q", t.Name)
+	}
+	if t.Name == "import" || t.Name == "export" {
+		return fmt.Errorf("template name %q is reserved", t.Name)
+	}
+	if t.Validity == "" {
+		return errors.New("template validity is required")
+	}
+	if d, err := time.ParseDuration(t.Validity); err != nil || d <= 0 {
+		return fmt.Errorf("invalid template validity %q", t.Validity)
+	}
+	for _, u := range t.KeyUsages {
+		if _, ok := config.KeyUsage[u]; !ok {
+			return fmt.Errorf("unknown key usage %q", u)
+		}
+	}
+	for _, u := range t.ExtKeyUsages {
+		if _, ok := config.ExtKeyUsage[u]; !ok {
+			return fmt.Errorf("unknown extended key usage %q", u)
+		}
+	}
+	if _, err := parseOIDs(t.Policies); err != nil {
+		return err
+	}
+	if t.MaxPathLen != nil && (!t.IsCA || *t.MaxPathLen < 0) {
+		return errors.New("max_path_len requires is_ca and must not be negative")
+	}
+	if t.SANs != nil {
+		if err := t.SANs.Validate(); err != nil {
+			return err
+		}
+	}
+	if o := t.Options; o != nil {
+		if o.Constraints != nil {
+			if err := o.Constraints.Validate(); err != nil {
+				return err
+			}
+		}
+		if o.Policy != nil {
+			if err := o.Policy.Validate(); err != nil {
+				return err
+			}
+		}
+		if o.Lint != nil {
+			if err := o.Lint.Validate(); err != nil {
+				return err
+			}
+		}
+		if _, err := EncodeExtensions(o.Extensions); err != nil {
+			return err
+		}
+		if err := validatePassthrough(o.PassthroughExtensions); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Profile returns the template as a cfssl signing profile, including the
+// PiCA settings under "pica"
+func (t *Template) Profile() map[string]interface{} {
+	profile := map[string]interface{}{
+		"usages": append(append([]string{}, t.KeyUsages...), t.ExtKeyUsages...),
+		"expiry": t.Validity,
+	}
+	if t.IsCA {
+		constraint := map[string]interface{}{"is_ca": true}
+		if t.MaxPathLen != nil {
+			constraint["max_path_len"] = *t.MaxPathLen
+			constraint["max_path_len_zero"] = *t.MaxPathLen == 0
+		}
+		profile["ca_constraint"] = constraint
+	}
+	if len(t.Policies) > 0 {
+		var policies []map[string]string
+		for _, oid := range t.Policies {
+			policies = append(policies, map[string]string{"id": oid})
+		}
+		profile["policies"] = policies
+	}
+	if t.OCSPURL != "" {
+		profile["ocsp_url"] = t.OCSPURL
+	}
+	if len(t.IssuerURLs) > 0 {
+		profile["issuer_urls"] = t.IssuerURLs
+	}
+
+	opts := ProfileOptions{}
+	if t.Options != nil {
+		opts = *t.Options
+	}
+	opts.Subject = t.Subject
+	opts.SANs = t.SANs
+	opts.TemplateVersion = t.Version
+	profile["pica"] = opts
+	return profile
+}
+
+// templateProfile mirrors the parts of a cfssl signing profile a template
+// is built from
+type templateProfile struct {
+	Usages       []string             `json:"usages"`
+	Expiry       string               `json:"expiry"`
+	CAConstraint *config.CAConstraint `json:"ca_constraint"`
+	Policies     []struct {
+		ID config.OID `json:"id"`
+	} `json:"policies"`
+	OCSP       string          `json:"ocsp_url"`
+	IssuerURLs []string        `json:"issuer_urls"`
+	PiCA       *ProfileOptions `json:"pica"`
+}
+
+// TemplateFromProfile converts a cfssl signing profile into a template
+func TemplateFromProfile(name string, data []byte) (*Template, error) {
+	var p templateProfile
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("profile %q: %w", name, err)
+	}
+
+	t := &Template{
+		Name:       name,
+		Validity:   p.Expiry,
+		OCSPURL:    p.OCSP,
+		IssuerURLs: p.IssuerURLs,
+	}
+	for _, u := range p.Usages {
+		if _, ok := config.ExtKeyUsage[u]; ok {
+			t.ExtKeyUsages = append(t.ExtKeyUsages, u)
+		} else {
+			t.KeyUsages = append(t.KeyUsages, u)
+		}
+	}
+	if c := p.CAConstraint; c != nil && c.IsCA {
+		t.IsCA = true
+		if c.MaxPathLen > 0 || c.MaxPathLenZero {
+			pathLen := c.MaxPathLen
+			t.MaxPathLen = &pathLen
+		}
+	}
+	for _, policy := range p.Policies {
+		t.Policies = append(t.Policies, oidString(policy.ID))
+	}
+	if opts := p.PiCA; opts != nil {
+		t.Subject = opts.Subject
+		t.SANs = opts.SANs
+		t.Version = opts.TemplateVersion
+		opts.Subject, opts.SANs, opts.TemplateVersion = nil, nil, 0
+		if !reflect.DeepEqual(*opts, ProfileOptions{}) {
+			t.Options = opts
+		}
+	}
+	return t, nil
+}
+
+// oidString formats an OID in dotted form
+func oidString(oid config.OID) string {
+	parts := make([]string, len(oid))
+	for i, n := range oid {
+		parts[i] = strconv.Itoa(n)
+	}
+	return strings.Join(parts, ".")
+}
+
+// TemplateStore keeps templates and every earlier version of them
+type TemplateStore struct {
+	dir string
+}
+
+// OpenTemplateStore opens the template store in dir, creating it if needed
+func OpenTemplateStore(dir string) (*TemplateStore, error) {
+	if err := os.MkdirAll(filepath.Join(dir, "history"), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create template directory: %w", err)
+	}
+	return &TemplateStore{dir: dir}, nil
+}
+
+// Templates returns the CA's template store, kept in its database directory
+func (ca *CA) Templates() (*TemplateStore, error) {
+	if ca.DatabaseDir == "" {
+		return nil, errors.New("no database directory configured")
+	}
+	return OpenTemplateStore(filepath.Join(ca.DatabaseDir, "templates"))
+}
+
+// List returns the current version of every template, sorted by name
+func (s *TemplateStore) List() ([]*Template, error) {
+	files, err := filepath.Glob(filepath.Join(s.dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	templates := make([]*Template, 0, len(files))
+	for _, file := range files {
+		var t Template
+		if err := readJSON(file, &t); err != nil {
+			return nil, err
+		}
+		templates = append(templates, &t)
+	}
+	sort.Slice(templates, func(i, j int) bool { return templates[i].Name < templates[j].Name })
+	return templates, nil
+}
+
+// Get returns the current version of a template
+func (s *TemplateStore) Get(name string) (*Template, error) {
+	if !templateName.MatchString(name) {
+		return nil, ErrTemplateNotFound
+	}
+	return s.read(filepath.Join(s.dir, name+".json"))
+}
+
+// Version returns an earlier version of a template
+func (s *TemplateStore) Version(name string, version int) (*Template, error) {
+	if !templateName.MatchString(name) {
+		return nil, ErrTemplateNotFound
+	}
+	return s.read(s.historyFile(name, version))
+}
+
+// Versions returns every saved version of a template, oldest first
+func (s *TemplateStore) Versions(name string) ([]*Template, error) {
+	if !templateName.MatchString(name) {
+		return nil, ErrTemplateNotFound
+	}
+	files, err := filepath.Glob(filepath.Join(s.dir, "history", name, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, ErrTemplateNotFound
+	}
+	versions := make([]*Template, 0, len(files))
+	for _, file := range files {
+		t, err := s.read(file)
+		if err != nil {
+			return nil, err
+		}
+		versions = append(versions, t)
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i].Version < versions[j].Version })
+	return versions, nil
+}
+
+// Save stores a new version of a template, numbered after the latest
+// version ever saved under its name
+func (s *TemplateStore) Save(t *Template) (*Template, error) {
+	if err := t.Validate(); err != nil {
+		return nil, err
+	}
+
+	saved := *t
+	saved.Version = 1
+	if versions, err := s.Versions(t.Name); err == nil {
+		saved.Version = versions[len(versions)-1].Version + 1
+	}
+	saved.Updated = time.Now().UTC()
+
+	if err := writeJSON(s.historyFile(saved.Name, saved.Version), &saved); err != nil {
+		return nil, err
+	}
+	if err := writeJSON(filepath.Join(s.dir, saved.Name+".json"), &saved); err != nil {
+		return nil, err
+	}
+
+	audit.Record(audit.Event{
+		Type:    audit.EventTemplateSaved,
+		Profile: saved.Name,
+		Details: map[string]interface{}{"version": saved.Version, "comment": saved.Comment},
+	})
+	return &saved, nil
+}
+
+// Delete removes a template. Its history is kept, so it can be restored
+// with Rollback.
+func (s *TemplateStore) Delete(name string) error {
+	if _, err := s.Get(name); err != nil {
+		return err
+	}
+	if err := os.Remove(filepath.Join(s.dir, name+".json")); err != nil {
+		return fmt.Errorf("failed to delete template: %w", err)
+	}
+	audit.Record(audit.Event{Type: audit.EventTemplateDeleted, Profile: name})
+	return nil
+}
+
+// Rollback saves an earlier version of a template as its newest version
+func (s *TemplateStore) Rollback(name string, version int) (*Template, error) {
+	old, err := s.Version(name, version)
+	if err != nil {
+		return nil, err
+	}
+	old.Comment = fmt.Sprintf("rollback to version %d", version)
+	return s.Save(old)
+}
+
+func (s *TemplateStore) historyFile(name string, version int) string {
+	return filepath.Join(s.dir, "history", name, fmt.Sprintf("%06d.json", version))
+}
+
+func (s *TemplateStore) read(file string) (*Template, error) {
+	if _, err := os.Stat(file); os.IsNotExist(err) {
+		return nil, ErrTemplateNotFound
+	}
+	var t Template
+	if err := readJSON(file, &t); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// ExportConfig writes templates into a cfssl config document. Profiles of
+// base that did not come from a template are kept, as are other settings
+// such as auth keys; profiles generated from templates that no longer exist
+// are removed. An empty base starts a new config.
+func ExportConfig(base []byte, templates []*Template) ([]byte, error) {
+	doc := map[string]interface{}{}
+	if len(base) > 0 {
+		if err := json.Unmarshal(base, &doc); err != nil {
+			return nil, fmt.Errorf("failed to parse cfssl config: %w", err)
+		}
+	}
+	signing, _ := doc["signing"].(map[string]interface{})
+	if signing == nil {
+		signing = map[string]interface{}{}
+	}
+	profiles, _ := signing["profiles"].(map[string]interface{})
+	if profiles == nil {
+		profiles = map[string]interface{}{}
+	}
+
+	// Drop profiles of deleted templates
+	for name, p := range profiles {
+		if fromTemplate(p) {
+			delete(profiles, name)
+		}
+	}
+	if fromTemplate(signing["default"]) {
+		delete(signing, "default")
+	}
+
+	for _, t := range templates {
+		if t.Name == DefaultTemplate {
+			signing["default"] = t.Profile()
+		} else {
+			profiles[t.Name] = t.Profile()
+		}
+	}
+	if _, ok := signing["default"]; !ok {
+		signing["default"] = map[string]interface{}{"expiry": "8760h"}
+	}
+	signing["profiles"] = profiles
+	doc["signing"] = signing
+
+	data, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode cfssl config: %w", err)
+	}
+	if _, err := config.LoadConfig(data); err != nil {
+		return nil, fmt.Errorf("generated cfssl config is invalid: %w", err)
+	}
+	return append(data, '\n'), nil
+}
+
+// fromTemplate reports whether a decoded profile was generated from a
+// template
+func fromTemplate(profile interface{}) bool {
+	p, _ := profile.(map[string]interface{})
+	pica, _ := p["pica"].(map[string]interface{})
+	_, ok := pica["template_version"]
+	return ok
+}
+
+// ImportConfig converts the profiles of a cfssl config document into
+// templates; the default profile becomes the "default" template
+func ImportConfig(data []byte) ([]*Template, error) {
+	if _, err := config.LoadConfig(data); err != nil {
+		return nil, fmt.Errorf("invalid cfssl config: %w", err)
+	}
+	var doc struct {
+		Signing struct {
+			Default  json.RawMessage            `json:"default"`
+			Profiles map[string]json.RawMessage `json:"profiles"`
+		} `json:"signing"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("invalid cfssl config: %w", err)
+	}
+
+	var templates []*Template
+	if len(doc.Signing.Default) > 0 {
+		t, err := TemplateFromProfile(DefaultTemplate, doc.Signing.Default)
+		if err != nil {
+			return nil, err
+		}
+		templates = append(templates, t)
+	}
+	names := make([]string, 0, len(doc.Signing.Profiles))
+	for name := range doc.Signing.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		t, err := TemplateFromProfile(name, doc.Signing.Profiles[name])
+		if err != nil {
+			return nil, err
+		}
+		templates = append(templates, t)
+	}
+	return templates, nil
+}
+
+// ExportTemplates returns the CA's cfssl config with its templates merged
+// in
+func (ca *CA) ExportTemplates() ([]byte, error) {
+	store, err := ca.Templates()
+	if err != nil {
+		return nil, err
+	}
+	templates, err := store.List()
+	if err != nil {
+		return nil, err
+	}
+
+	base, err := os.ReadFile(ca.ConfigFile)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read cfssl config: %w", err)
+	}
+	return ExportConfig(base, templates)
+}
+
+// ApplyTemplates writes the CA's templates to its cfssl config file, so
+// they take effect for the next signing request
+func (ca *CA) ApplyTemplates() error {
+	data, err := ca.ExportTemplates()
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(ca.ConfigFile, data, 0644)
+}
+
+// ImportTemplates saves every profile of a cfssl config as a template of
+// the CA and applies them
+func (ca *CA) ImportTemplates(data []byte, comment string) ([]*Template, error) {
+	templates, err := ImportConfig(data)
+	if err != nil {
+		return nil, err
+	}
+	store, err := ca.Templates()
+	if err != nil {
+		return nil, err
+	}
+
+	var saved []*Template
+	for _, t := range templates {
+		t.Comment = comment
+		s, err := store.Save(t)
+		if err != nil {
+			return nil, fmt.Errorf("template %q: %w", t.Name, err)
+		}
+		saved = append(saved, s)
+	}
+	return saved, ca.ApplyTemplates()
+}
@@ -0,0 +1,266 @@
+package ca
+
+import (
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/json"
+	"errors"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTemplateStoreVersions(t *testing.T) {
+	store, err := OpenTemplateStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("OpenTemplateStore failed: %v", err)
+	}
+
+	tmpl := &Template{
+		Name:         "web",
+		KeyUsages:    []string{"digital signature"},
+		ExtKeyUsages: []string{"server auth"},
+		Validity:     "2160h",
+	}
+	if _, err := store.Save(tmpl); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	tmpl.Validity = "720h"
+	tmpl.Comment = "shorter lifetime"
+	saved, err := store.Save(tmpl)
+	if err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	if saved.Version != 2 {
+		t.Errorf("Expected version 2, got %d", saved.Version)
+	}
+
+	restored, err := store.Rollback("web", 1)
+	if err != nil {
+		t.Fatalf("Rollback failed: %v", err)
+	}
+	if restored.Version != 3 || restored.Validity != "2160h" {
+		t.Errorf("Unexpected rollback result: %+v", restored)
+	}
+
+	if err := store.Delete("web"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if _, err := store.Get("web"); !errors.Is(err, ErrTemplateNotFound) {
+		t.Errorf("Expected deleted template to be gone, got %v", err)
+	}
+	versions, err := store.Versions("web")
+	if err != nil || len(versions) != 3 {
+		t.Fatalf("Expected history to survive deletion: %d versions, %v", len(versions), err)
+	}
+
+	// Saving again continues the numbering
+	if saved, err := store.Save(tmpl); err != nil || saved.Version != 4 {
+		t.Errorf("Expected version 4 after recreation, got %+v, %v", saved, err)
+	}
+
+	for _, bad := range []*Template{
+		{Name: "../web", Validity: "1h"},
+		{Name: "export", Validity: "1h"},
+		{Name: "web", Validity: "1h", KeyUsages: []string{"server auth"}},
+		{Name: "web", Validity: "1h", SANs: &SANRequirements{Types: []string{"dn"}}},
+		{Name: "web"},
+	} {
+		if _, err := store.Save(bad); err == nil {
+			t.Errorf("Expected %+v to be rejected", bad)
+		}
+	}
+}
+
+func TestTemplateExportImport(t *testing.T) {
+	pathLen := 0
+	templates := []*Template{
+		{Name: DefaultTemplate, Validity: "8760h", Version: 1},
+		{
+			Name:         "web",
+			KeyUsages:    []string{"digital signature"},
+			ExtKeyUsages: []string{"server auth"},
+			Validity:     "2160h",
+			Subject:      &SubjectDefaults{Organization: "PiCA"},
+			SANs:         &SANRequirements{Required: true, Types: []string{"dns"}},
+			Policies:     []string{"1.3.6.1.4.1.99999.2"},
+			OCSPURL:      "http://ocsp.example.com",
+			Version:      3,
+		},
+		{
+			Name:       "issuing-ca",
+			KeyUsages:  []string{"cert sign", "crl sign"},
+			Validity:   "43800h",
+			IsCA:       true,
+			MaxPathLen: &pathLen,
+			Options:    &ProfileOptions{Constraints: &CAConstraints{PermittedDNS: []string{"example.com"}}},
+			Version:    1,
+		},
+	}
+
+	// Hand-written profiles and other settings survive an export
+	base := []byte(`{"signing": {"default": {"expiry": "1h"}, "profiles": {
+		"legacy": {"usages": ["signing"], "expiry": "1h"},
+		"old": {"usages": ["signing"], "expiry": "1h", "pica": {"template_version": 2}}
+	}}, "auth_keys": {"key1": {"type": "standard", "key": "0123456789ABCDEF0123456789ABCDEF"}}}`)
+	data, err := ExportConfig(base, templates)
+	if err != nil {
+		t.Fatalf("ExportConfig failed: %v", err)
+	}
+
+	var doc map[string]interface{}
+	json.Unmarshal(data, &doc)
+	profiles := doc["signing"].(map[string]interface{})["profiles"].(map[string]interface{})
+	if _, ok := profiles["legacy"]; !ok {
+		t.Error("Hand-written profile was dropped")
+	}
+	if _, ok := profiles["old"]; ok {
+		t.Error("Profile of a deleted template was kept")
+	}
+	if _, ok := doc["auth_keys"]; !ok {
+		t.Error("Other settings were dropped")
+	}
+
+	imported, err := ImportConfig(data)
+	if err != nil {
+		t.Fatalf("ImportConfig failed: %v", err)
+	}
+	byName := map[string]*Template{}
+	for _, tmpl := range imported {
+		byName[tmpl.Name] = tmpl
+	}
+	web := byName["web"]
+	if web == nil || strings.Join(web.ExtKeyUsages, ",") != "server auth" ||
+		strings.Join(web.KeyUsages, ",") != "digital signature" || web.Subject.Organization != "PiCA" ||
+		!web.SANs.Required || web.Policies[0] != "1.3.6.1.4.1.99999.2" || web.OCSPURL == "" || web.Version != 3 {
+		t.Errorf("Unexpected imported template: %+v", web)
+	}
+	ca := byName["issuing-ca"]
+	if ca == nil || !ca.IsCA || ca.MaxPathLen == nil || *ca.MaxPathLen != 0 ||
+		ca.Options == nil || ca.Options.Constraints.PermittedDNS[0] != "example.com" {
+		t.Errorf("Unexpected imported CA template: %+v", ca)
+	}
+	if byName[DefaultTemplate] == nil || byName["legacy"] == nil {
+		t.Errorf("Expected default and legacy templates: %v", byName)
+	}
+}
+
+func TestTemplateOptionsRoundTrip(t *testing.T) {
+	// Each profile option on its own survives an export and import
+	cases := map[string]*Template{
+		"constraints": {Options: &ProfileOptions{Constraints: &CAConstraints{ExcludedDNS: []string{"internal.example.com"}}}},
+		"policy":      {Options: &ProfileOptions{Policy: &IssuancePolicy{AllowedDomains: []string{"example.com"}}}},
+		"lint":        {Options: &ProfileOptions{Lint: &LintOptions{FailOn: "warn"}}},
+		"extensions":  {Options: &ProfileOptions{Extensions: []Extension{{OID: "1.3.6.1.4.1.99999.1", Type: "utf8string", Value: "x"}}}},
+		"passthrough": {Options: &ProfileOptions{PassthroughExtensions: []string{"1.3.6.1.4.1.99999.2"}}},
+		"attestation": {Options: &ProfileOptions{Attestation: &AttestationRequirement{Required: true, TouchPolicies: []string{"always"}}}},
+		"subject":     {Subject: &SubjectDefaults{Organization: "PiCA"}},
+		"sans":        {SANs: &SANRequirements{Types: []string{"dns"}}},
+		"version":     {},
+	}
+
+	var templates []*Template
+	for name, tmpl := range cases {
+		tmpl.Name = name
+		tmpl.Validity = "8760h"
+		tmpl.KeyUsages = []string{"digital signature"}
+		tmpl.Version = 2
+		templates = append(templates, tmpl)
+	}
+	data, err := ExportConfig(nil, templates)
+	if err != nil {
+		t.Fatalf("ExportConfig failed: %v", err)
+	}
+	imported, err := ImportConfig(data)
+	if err != nil {
+		t.Fatalf("ImportConfig failed: %v", err)
+	}
+
+	for _, got := range imported {
+		want, ok := cases[got.Name]
+		if !ok {
+			continue
+		}
+		if !reflect.DeepEqual(got, want) {
+			gotJSON, _ := json.Marshal(got)
+			wantJSON, _ := json.Marshal(want)
+			t.Errorf("%s: got %s, want %s", got.Name, gotJSON, wantJSON)
+		}
+		delete(cases, got.Name)
+	}
+	if len(cases) > 0 {
+		t.Errorf("Templates missing after import: %v", cases)
+	}
+}
+
+func TestSignWithTemplate(t *testing.T) {
+	c := newTestCA(t)
+	store, err := c.Templates()
+	if err != nil {
+		t.Fatalf("Templates failed: %v", err)
+	}
+	if _, err := store.Save(&Template{
+		Name:         "web",
+		KeyUsages:    []string{"digital signature"},
+		ExtKeyUsages: []string{"server auth"},
+		Validity:     "720h",
+		Subject:      &SubjectDefaults{Organization: "PiCA", Country: "US"},
+		SANs:         &SANRequirements{Required: true, Types: []string{"dns"}},
+		Policies:     []string{"1.3.6.1.4.1.99999.2"},
+		OCSPURL:      "http://ocsp.example.com",
+	}); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	if err := c.ApplyTemplates(); err != nil {
+		t.Fatalf("ApplyTemplates failed: %v", err)
+	}
+
+	// The hand-written server profile is still there
+	if _, err := c.SignCertificate(newTestCSR(t, "legacy.example.com"), "server"); err != nil {
+		t.Fatalf("Signing with the existing profile failed: %v", err)
+	}
+
+	if _, err := c.SignCertificate(newTestCSR(t, "nosan.example.com"), "web"); err == nil {
+		t.Error("Expected request without SANs to be rejected")
+	}
+
+	certPEM, err := c.SignCertificate(newTestCSR(t, "www.example.com", "www.example.com"), "web")
+	if err != nil {
+		t.Fatalf("SignCertificate failed: %v", err)
+	}
+	cert := parsePEMCertificate(t, certPEM)
+	if cert.Subject.Organization[0] != "PiCA" || cert.Subject.Country[0] != "US" {
+		t.Errorf("Subject defaults not applied: %v", cert.Subject)
+	}
+	if len(cert.ExtKeyUsage) != 1 || cert.ExtKeyUsage[0] != x509.ExtKeyUsageServerAuth {
+		t.Errorf("Unexpected extended key usage: %v", cert.ExtKeyUsage)
+	}
+	if len(cert.PolicyIdentifiers) != 1 || !cert.PolicyIdentifiers[0].Equal(asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 99999, 2}) {
+		t.Errorf("Unexpected policies: %v", cert.PolicyIdentifiers)
+	}
+	if len(cert.OCSPServer) != 1 || cert.OCSPServer[0] != "http://ocsp.example.com" {
+		t.Errorf("Unexpected OCSP servers: %v", cert.OCSPServer)
+	}
+	if d := cert.NotAfter.Sub(cert.NotBefore).Hours(); d > 721 {
+		t.Errorf("Template validity not applied: %vh", d)
+	}
+
+	store2, _ := c.Store()
+	record, err := store2.Get(cert.SerialNumber.Text(16))
+	if err != nil || record.Metadata["template_version"] != "1" {
+		t.Errorf("Expected template version to be recorded: %+v, %v", record, err)
+	}
+
+	// Deleting the template removes the profile
+	if err := store.Delete("web"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if err := c.ApplyTemplates(); err != nil {
+		t.Fatalf("ApplyTemplates failed: %v", err)
+	}
+	data, _ := os.ReadFile(c.ConfigFile)
+	if strings.Contains(string(data), `"web"`) || !strings.Contains(string(data), `"server"`) {
+		t.Errorf("Unexpected config after deletion:\n%s", data)
+	}
+}
@@ -0,0 +1,630 @@
+package ca
+
+import (
+	"bufio"
+	"bytes"
+	gocrypto "crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/cloudflare/cfssl/csr"
+
+	"github.com/billchurch/PiCA/internal/audit"
+	"github.com/billchurch/PiCA/internal/crypto"
+)
+
+// Media types of RFC 3161 requests and responses over HTTP
+const (
+	TimeStampQueryType = "application/timestamp-query"
+	TimeStampReplyType = "application/timestamp-reply"
+)
+
+// TimeStampingProfile is the cfssl profile that issues TSA certificates
+const TimeStampingProfile = "timestamping"
+
+// DefaultTSAAccuracy is used when no accuracy is configured
+const DefaultTSAAccuracy = time.Second
+
+// Object identifiers used in time-stamp tokens
+var (
+	oidSignedData        = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	oidTSTInfo           = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 1, 4}
+	oidAttrContentType   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 3}
+	oidAttrMessageDigest = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+	oidAttrSigningCertV2 = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 2, 47}
+	oidSHA256            = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidSHA384            = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 2}
+	oidSHA512            = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 3}
+	oidRSAEncryption     = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}
+	oidECDSAWithSHA256   = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}
+)
+
+// tsaHashes are the message imprint algorithms the TSA accepts
+var tsaHashes = map[string]gocrypto.Hash{
+	oidSHA256.String(): gocrypto.SHA256,
+	oidSHA384.String(): gocrypto.SHA384,
+	oidSHA512.String(): gocrypto.SHA512,
+}
+
+// PKIStatus values of a time-stamp response
+const (
+	tsaStatusGranted   = 0
+	tsaStatusRejection = 2
+)
+
+// PKIFailureInfo bits of a rejected time-stamp request
+const (
+	tsaFailBadAlg              = 0
+	tsaFailBadRequest          = 2
+	tsaFailBadDataFormat       = 5
+	tsaFailUnacceptedPolicy    = 15
+	tsaFailUnacceptedExtension = 16
+	tsaFailSystemFailure       = 25
+)
+
+// TSARejection is returned when a time-stamp request is refused. The
+// response sent to the client carries the same failure.
+type TSARejection struct {
+	failure int
+	reason  string
+}
+
+func (e *TSARejection) Error() string {
+	return "time-stamp request rejected: " + e.reason
+}
+
+// ASN.1 structures of RFC 3161 and RFC 5652
+
+type messageImprint struct {
+	HashAlgorithm pkix.AlgorithmIdentifier
+	HashedMessage []byte
+}
+
+type timeStampReq struct {
+	Version        int
+	MessageImprint messageImprint
+	ReqPolicy      asn1.ObjectIdentifier `asn1:"optional"`
+	Nonce          *big.Int              `asn1:"optional"`
+	CertReq        bool                  `asn1:"optional"`
+	Extensions     []pkix.Extension      `asn1:"tag:0,optional"`
+}
+
+type tsaAccuracy struct {
+	Seconds int `asn1:"optional"`
+	Millis  int `asn1:"tag:0,optional"`
+	Micros  int `asn1:"tag:1,optional"`
+}
+
+type tstInfo struct {
+	Version        int
+	Policy         asn1.ObjectIdentifier
+	MessageImprint messageImprint
+	SerialNumber   *big.Int
+	GenTime        asn1.RawValue
+	Accuracy       tsaAccuracy   `asn1:"optional"`
+	Nonce          *big.Int      `asn1:"optional"`
+	TSA            asn1.RawValue `asn1:"tag:0,optional"`
+}
+
+type pkiStatusInfo struct {
+	Status       int
+	StatusString []asn1.RawValue `asn1:"optional"`
+	FailInfo     asn1.BitString  `asn1:"optional"`
+}
+
+type timeStampResp struct {
+	Status         pkiStatusInfo
+	TimeStampToken asn1.RawValue `asn1:"optional"`
+}
+
+type contentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue
+}
+
+type encapsulatedContentInfo struct {
+	EContentType asn1.ObjectIdentifier
+	EContent     []byte `asn1:"explicit,tag:0"`
+}
+
+type issuerAndSerialNumber struct {
+	Issuer       asn1.RawValue
+	SerialNumber *big.Int
+}
+
+type signerInfo struct {
+	Version            int
+	SID                issuerAndSerialNumber
+	DigestAlgorithm    pkix.AlgorithmIdentifier
+	SignedAttrs        asn1.RawValue
+	SignatureAlgorithm pkix.AlgorithmIdentifier
+	Signature          []byte
+}
+
+type signedData struct {
+	Version          int
+	DigestAlgorithms []pkix.AlgorithmIdentifier `asn1:"set"`
+	EncapContentInfo encapsulatedContentInfo
+	Certificates     asn1.RawValue `asn1:"tag:0,optional"`
+	SignerInfos      []signerInfo  `asn1:"set"`
+}
+
+type attribute struct {
+	Type   asn1.ObjectIdentifier
+	Values []asn1.RawValue `asn1:"set"`
+}
+
+type issuerSerial struct {
+	Issuer       []asn1.RawValue
+	SerialNumber *big.Int
+}
+
+type essCertIDv2 struct {
+	CertHash     []byte
+	IssuerSerial issuerSerial
+}
+
+type signingCertificateV2 struct {
+	Certs []essCertIDv2
+}
+
+// TimeStampToken is the record kept for every token issued
+type TimeStampToken struct {
+	SerialNumber  string    `json:"serialNumber"`
+	GenTime       time.Time `json:"genTime"`
+	Policy        string    `json:"policy"`
+	HashAlgorithm string    `json:"hashAlgorithm"`
+	HashedMessage string    `json:"hashedMessage"`
+	Nonce         string    `json:"nonce,omitempty"`
+	// Client is the address the request came from, when known
+	Client string `json:"client,omitempty"`
+}
+
+// tsaState is the persisted serial number counter
+type tsaState struct {
+	Serial uint64 `json:"serial"`
+}
+
+// TSA is an RFC 3161 time-stamping authority signing with a key held in a
+// provider slot
+type TSA struct {
+	Provider crypto.Provider
+	Slot     crypto.Slot
+	// Certificate is the time-stamping certificate; Chain holds the CA
+	// certificates above it
+	Certificate *x509.Certificate
+	Chain       []*x509.Certificate
+	// Policy is the TSA policy stated in every token
+	Policy asn1.ObjectIdentifier
+	// Accuracy is the stated accuracy of the token time
+	Accuracy time.Duration
+	// DatabaseDir holds the serial counter and the token log
+	DatabaseDir string
+
+	mutex sync.Mutex
+}
+
+// NewTSA creates a TSA from a PEM file holding the time-stamping
+// certificate followed by its chain
+func NewTSA(provider crypto.Provider, slot crypto.Slot, certFile, policy, databaseDir string) (*TSA, error) {
+	certs, err := readCertificates(certFile)
+	if err != nil {
+		return nil, err
+	}
+	if policy == "" {
+		return nil, errors.New("a TSA policy OID is required")
+	}
+	oid, err := parseOID(policy)
+	if err != nil {
+		return nil, fmt.Errorf("invalid TSA policy: %w", err)
+	}
+	if err := checkTimeStampingCertificate(certs[0]); err != nil {
+		return nil, err
+	}
+	if err := checkSlotKey(provider, slot, certs[0]); err != nil {
+		return nil, err
+	}
+
+	return &TSA{
+		Provider:    provider,
+		Slot:        slot,
+		Certificate: certs[0],
+		Chain:       certs[1:],
+		Policy:      oid,
+		Accuracy:    DefaultTSAAccuracy,
+		DatabaseDir: databaseDir,
+	}, nil
+}
+
+// checkTimeStampingCertificate makes sure a certificate may sign
+// time-stamp tokens: RFC 3161 requires time stamping as the only, critical
+// extended key usage
+func checkTimeStampingCertificate(cert *x509.Certificate) error {
+	if len(cert.ExtKeyUsage) != 1 || cert.ExtKeyUsage[0] != x509.ExtKeyUsageTimeStamping || len(cert.UnknownExtKeyUsage) > 0 {
+		return fmt.Errorf("certificate %s is not a time-stamping certificate", cert.Subject.CommonName)
+	}
+	for _, ext := range cert.Extensions {
+		if ext.Id.Equal(oidExtensionExtKeyUsage) && !ext.Critical {
+			return fmt.Errorf("certificate %s does not mark its extended key usage critical", cert.Subject.CommonName)
+		}
+	}
+	return nil
+}
+
+// IssueTimeStampingCertificate has the CA certify the TSA key in a provider
+// slot with the timestamping profile, generating the key first when
+// generateKey is true. The certificate is written to certFile followed by
+// the CA certificate.
+func (ca *CA) IssueTimeStampingCertificate(req *csr.CertificateRequest, provider crypto.Provider, slot crypto.Slot, certFile string, generateKey bool) (*x509.Certificate, error) {
+	csrPEM, err := CreateCSR(req, provider, slot, generateKey)
+	if err != nil {
+		return nil, err
+	}
+	certPEM, err := ca.SignCertificate(csrPEM, TimeStampingProfile)
+	if err != nil {
+		return nil, err
+	}
+	certs, err := ParseCertificates(certPEM)
+	if err != nil {
+		return nil, err
+	}
+	caCert, _, err := ca.ActiveIssuer()
+	if err != nil {
+		return nil, err
+	}
+
+	chain := append(certPEM, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caCert.Raw})...)
+	if err := writeFileAtomic(certFile, chain, 0644); err != nil {
+		return nil, err
+	}
+	return certs[0], nil
+}
+
+// checkSlotKey makes sure the key in a provider slot is the certified key
+func checkSlotKey(provider crypto.Provider, slot crypto.Slot, cert *x509.Certificate) error {
+	pub, err := provider.GetPublicKey(slot)
+	if err != nil {
+		return fmt.Errorf("no TSA key in slot %X: %w", int(slot), err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(der, cert.RawSubjectPublicKeyInfo) {
+		return fmt.Errorf("key in slot %X does not match the TSA certificate", int(slot))
+	}
+	return nil
+}
+
+// timeStampingExtension returns a critical extended key usage extension
+// for profiles that only issue time-stamping certificates. x509 would
+// otherwise mark it non-critical, which TSA clients refuse.
+func timeStampingExtension(eku []x509.ExtKeyUsage) (pkix.Extension, bool) {
+	if len(eku) != 1 || eku[0] != x509.ExtKeyUsageTimeStamping {
+		return pkix.Extension{}, false
+	}
+	value, err := asn1.Marshal([]asn1.ObjectIdentifier{extKeyUsageOIDs[x509.ExtKeyUsageTimeStamping]})
+	if err != nil {
+		return pkix.Extension{}, false
+	}
+	return pkix.Extension{Id: oidExtensionExtKeyUsage, Critical: true, Value: value}, true
+}
+
+// Respond answers a DER-encoded TimeStampReq with a DER-encoded
+// TimeStampResp. Refused requests get a rejection response and a
+// *TSARejection error; the response is always safe to send.
+func (t *TSA) Respond(request []byte, client string) ([]byte, *TimeStampToken, error) {
+	token, resp, err := t.respond(request, client)
+	if err == nil {
+		return resp, token, nil
+	}
+
+	var rejection *TSARejection
+	if !errors.As(err, &rejection) {
+		rejection = &TSARejection{failure: tsaFailSystemFailure, reason: "internal error"}
+	}
+	data, merr := asn1.Marshal(timeStampResp{Status: rejectionStatus(rejection)})
+	if merr != nil {
+		return nil, nil, merr
+	}
+	return data, nil, err
+}
+
+// rejectionStatus encodes a rejection as a PKIStatusInfo
+func rejectionStatus(r *TSARejection) pkiStatusInfo {
+	bits := make([]byte, r.failure/8+1)
+	bits[r.failure/8] = 0x80 >> uint(r.failure%8)
+	return pkiStatusInfo{
+		Status:       tsaStatusRejection,
+		StatusString: []asn1.RawValue{{Tag: asn1.TagUTF8String, Bytes: []byte(r.reason)}},
+		FailInfo:     asn1.BitString{Bytes: bits, BitLength: r.failure + 1},
+	}
+}
+
+// respond checks a request and creates its token
+func (t *TSA) respond(request []byte, client string) (*TimeStampToken, []byte, error) {
+	var req timeStampReq
+	rest, err := asn1.Unmarshal(request, &req)
+	if err != nil || len(rest) > 0 {
+		return nil, nil, &TSARejection{failure: tsaFailBadDataFormat, reason: "malformed request"}
+	}
+	if req.Version != 1 {
+		return nil, nil, &TSARejection{failure: tsaFailBadRequest, reason: fmt.Sprintf("unsupported version %d", req.Version)}
+	}
+	hash, ok := tsaHashes[req.MessageImprint.HashAlgorithm.Algorithm.String()]
+	if !ok {
+		return nil, nil, &TSARejection{failure: tsaFailBadAlg, reason: "unsupported hash algorithm"}
+	}
+	if len(req.MessageImprint.HashedMessage) != hash.Size() {
+		return nil, nil, &TSARejection{failure: tsaFailBadDataFormat, reason: "message imprint has the wrong length"}
+	}
+	if len(req.ReqPolicy) > 0 && !req.ReqPolicy.Equal(t.Policy) {
+		return nil, nil, &TSARejection{failure: tsaFailUnacceptedPolicy, reason: "unsupported policy " + req.ReqPolicy.String()}
+	}
+	if len(req.Extensions) > 0 {
+		return nil, nil, &TSARejection{failure: tsaFailUnacceptedExtension, reason: "extensions are not supported"}
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	serial, err := t.nextSerial()
+	if err != nil {
+		return nil, nil, err
+	}
+	genTime := time.Now().UTC()
+
+	info := tstInfo{
+		Version: 1,
+		Policy:  t.Policy,
+		MessageImprint: messageImprint{
+			HashAlgorithm: pkix.AlgorithmIdentifier{Algorithm: req.MessageImprint.HashAlgorithm.Algorithm},
+			HashedMessage: req.MessageImprint.HashedMessage,
+		},
+		SerialNumber: new(big.Int).SetUint64(serial),
+		GenTime:      generalizedTime(genTime, t.Accuracy),
+		Accuracy:     accuracyOf(t.Accuracy),
+		Nonce:        req.Nonce,
+		TSA: asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true,
+			Bytes: directoryName(t.Certificate.RawSubject)},
+	}
+	infoDER, err := asn1.Marshal(info)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to encode TSTInfo: %w", err)
+	}
+
+	token, err := t.sign(infoDER, req.CertReq)
+	if err != nil {
+		return nil, nil, err
+	}
+	resp, err := asn1.Marshal(timeStampResp{
+		Status:         pkiStatusInfo{Status: tsaStatusGranted},
+		TimeStampToken: asn1.RawValue{FullBytes: token},
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to encode response: %w", err)
+	}
+
+	record := &TimeStampToken{
+		SerialNumber:  fmt.Sprintf("%X", serial),
+		GenTime:       genTime,
+		Policy:        t.Policy.String(),
+		HashAlgorithm: hash.String(),
+		HashedMessage: hex.EncodeToString(req.MessageImprint.HashedMessage),
+		Client:        client,
+	}
+	if req.Nonce != nil {
+		record.Nonce = fmt.Sprintf("%X", req.Nonce)
+	}
+	if err := t.logToken(record); err != nil {
+		return nil, nil, err
+	}
+	return record, resp, nil
+}
+
+// generalizedTime encodes the token time with as many fractional digits as
+// the accuracy calls for
+func generalizedTime(at time.Time, accuracy time.Duration) asn1.RawValue {
+	layout := "20060102150405Z"
+	switch {
+	case accuracy < time.Millisecond:
+		layout = "20060102150405.999999Z"
+	case accuracy < time.Second:
+		layout = "20060102150405.999Z"
+	}
+	return asn1.RawValue{Tag: asn1.TagGeneralizedTime, Bytes: []byte(at.Format(layout))}
+}
+
+// accuracyOf splits a duration into the fields of an Accuracy
+func accuracyOf(d time.Duration) tsaAccuracy {
+	return tsaAccuracy{
+		Seconds: int(d / time.Second),
+		Millis:  int(d % time.Second / time.Millisecond),
+		Micros:  int(d % time.Millisecond / time.Microsecond),
+	}
+}
+
+// directoryName encodes a raw Name as a directoryName GeneralName
+func directoryName(rawName []byte) []byte {
+	der, _ := asn1.Marshal(asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 4, IsCompound: true, Bytes: rawName})
+	return der
+}
+
+// sign wraps an encoded TSTInfo in a CMS SignedData signed with the TSA key
+func (t *TSA) sign(infoDER []byte, includeCert bool) ([]byte, error) {
+	cert := t.Certificate
+	digest := sha256.Sum256(infoDER)
+	certHash := sha256.Sum256(cert.Raw)
+
+	essCert, err := asn1.Marshal(signingCertificateV2{Certs: []essCertIDv2{{
+		CertHash: certHash[:],
+		IssuerSerial: issuerSerial{
+			Issuer:       []asn1.RawValue{{FullBytes: directoryName(cert.RawIssuer)}},
+			SerialNumber: cert.SerialNumber,
+		},
+	}}})
+	if err != nil {
+		return nil, err
+	}
+	contentType, _ := asn1.Marshal(oidTSTInfo)
+	messageDigest, _ := asn1.Marshal(digest[:])
+
+	var attrs [][]byte
+	for _, a := range []attribute{
+		{Type: oidAttrContentType, Values: []asn1.RawValue{{FullBytes: contentType}}},
+		{Type: oidAttrMessageDigest, Values: []asn1.RawValue{{FullBytes: messageDigest}}},
+		{Type: oidAttrSigningCertV2, Values: []asn1.RawValue{{FullBytes: essCert}}},
+	} {
+		der, err := asn1.Marshal(a)
+		if err != nil {
+			return nil, err
+		}
+		attrs = append(attrs, der)
+	}
+	// DER orders the members of a SET OF by their encoding
+	sort.Slice(attrs, func(i, j int) bool { return bytes.Compare(attrs[i], attrs[j]) < 0 })
+	attrBytes := bytes.Join(attrs, nil)
+
+	// The signature covers the attributes encoded as a SET
+	signedAttrs, err := asn1.Marshal(asn1.RawValue{Tag: asn1.TagSet, IsCompound: true, Bytes: attrBytes})
+	if err != nil {
+		return nil, err
+	}
+	attrsDigest := sha256.Sum256(signedAttrs)
+
+	signer := &crypto.ProviderSigner{Provider: t.Provider, Slot: t.Slot, PublicKey: cert.PublicKey}
+	signature, err := signer.Sign(rand.Reader, attrsDigest[:], gocrypto.SHA256)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign time-stamp token: %w", err)
+	}
+
+	var sigAlg pkix.AlgorithmIdentifier
+	switch cert.PublicKey.(type) {
+	case *ecdsa.PublicKey:
+		sigAlg = pkix.AlgorithmIdentifier{Algorithm: oidECDSAWithSHA256}
+	case *rsa.PublicKey:
+		sigAlg = pkix.AlgorithmIdentifier{Algorithm: oidRSAEncryption, Parameters: asn1.NullRawValue}
+	default:
+		return nil, fmt.Errorf("unsupported TSA key type %T", cert.PublicKey)
+	}
+
+	sd := signedData{
+		Version:          3,
+		DigestAlgorithms: []pkix.AlgorithmIdentifier{{Algorithm: oidSHA256}},
+		EncapContentInfo: encapsulatedContentInfo{EContentType: oidTSTInfo, EContent: infoDER},
+		SignerInfos: []signerInfo{{
+			Version:            1,
+			SID:                issuerAndSerialNumber{Issuer: asn1.RawValue{FullBytes: cert.RawIssuer}, SerialNumber: cert.SerialNumber},
+			DigestAlgorithm:    pkix.AlgorithmIdentifier{Algorithm: oidSHA256},
+			SignedAttrs:        asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: attrBytes},
+			SignatureAlgorithm: sigAlg,
+			Signature:          signature,
+		}},
+	}
+	if includeCert {
+		sd.Certificates = asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: cert.Raw}
+	}
+	sdDER, err := asn1.Marshal(sd)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode SignedData: %w", err)
+	}
+
+	return asn1.Marshal(contentInfo{
+		ContentType: oidSignedData,
+		Content:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: sdDER},
+	})
+}
+
+// nextSerial persists and returns the next token serial number. Callers
+// must hold the mutex. The counter is saved before the token is issued, so
+// a serial is never reused.
+func (t *TSA) nextSerial() (uint64, error) {
+	if t.DatabaseDir == "" {
+		return 0, errors.New("no TSA database directory configured")
+	}
+	filename := filepath.Join(t.DatabaseDir, "serial.json")
+	var state tsaState
+	if err := readJSON(filename, &state); err != nil {
+		return 0, err
+	}
+	state.Serial++
+	if err := writeJSON(filename, state); err != nil {
+		return 0, err
+	}
+	return state.Serial, nil
+}
+
+// logToken appends a token to the token log and the audit log. Callers must
+// hold the mutex.
+func (t *TSA) logToken(token *TimeStampToken) error {
+	data, err := json.Marshal(token)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(filepath.Join(t.DatabaseDir, "tokens.log"), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open token log: %w", err)
+	}
+	defer f.Close()
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write token log: %w", err)
+	}
+
+	audit.Record(audit.Event{
+		Type:    audit.EventTimestamp,
+		Subject: t.Certificate.Subject.CommonName,
+		Serial:  token.SerialNumber,
+		Details: map[string]interface{}{
+			"policy":        token.Policy,
+			"hashAlgorithm": token.HashAlgorithm,
+			"hashedMessage": token.HashedMessage,
+			"client":        token.Client,
+		},
+	})
+	return nil
+}
+
+// TimeStampTokens returns the tokens logged in a TSA database directory
+func TimeStampTokens(databaseDir string) ([]*TimeStampToken, error) {
+	f, err := os.Open(filepath.Join(databaseDir, "tokens.log"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to open token log: %w", err)
+	}
+	defer f.Close()
+
+	var tokens []*TimeStampToken
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var token TimeStampToken
+		if err := json.Unmarshal(scanner.Bytes(), &token); err != nil {
+			return nil, fmt.Errorf("failed to parse token log: %w", err)
+		}
+		tokens = append(tokens, &token)
+	}
+	return tokens, scanner.Err()
+}
+
+// TSADatabaseDir returns where the TSA keeps its state for a CA database
+// directory
+func TSADatabaseDir(databaseDir string) string {
+	return filepath.Join(databaseDir, "tsa")
+}
@@ -0,0 +1,257 @@
+package ca
+
+import (
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cloudflare/cfssl/csr"
+
+	"github.com/billchurch/PiCA/internal/crypto"
+)
+
+const testTSAConfig = `{
+	"signing": {
+		"default": {"expiry": "8760h"},
+		"profiles": {
+			"timestamping": {"usages": ["digital signature", "timestamping"], "expiry": "8760h"}
+		}
+	}
+}`
+
+const testTSAPolicy = "1.3.6.1.4.1.99999.1"
+
+// newTestTSA returns a TSA whose certificate was issued by a test CA, and
+// the CA certificate file
+func newTestTSA(t *testing.T) (*TSA, string) {
+	t.Helper()
+	c := newTestCA(t)
+	if err := os.WriteFile(c.ConfigFile, []byte(testTSAConfig), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+
+	dir := filepath.Dir(c.CertFile)
+	certFile := filepath.Join(dir, "tsa.pem")
+	req := &csr.CertificateRequest{
+		CN:         "Test TSA",
+		Names:      []csr.Name{{C: "US", O: "PiCA Test"}},
+		KeyRequest: &csr.KeyRequest{A: "ecdsa", S: 256},
+	}
+	cert, err := c.IssueTimeStampingCertificate(req, c.Provider, crypto.SlotTSA, certFile, true)
+	if err != nil {
+		t.Fatalf("Failed to issue TSA certificate: %v", err)
+	}
+	for _, ext := range cert.Extensions {
+		if ext.Id.Equal(oidExtensionExtKeyUsage) && !ext.Critical {
+			t.Errorf("Expected the extended key usage to be critical")
+		}
+	}
+
+	tsa, err := NewTSA(c.Provider, crypto.SlotTSA, certFile, testTSAPolicy, filepath.Join(dir, "tsa"))
+	if err != nil {
+		t.Fatalf("Failed to create TSA: %v", err)
+	}
+	if len(tsa.Chain) != 1 {
+		t.Errorf("Expected the CA certificate in the chain, got %d certificates", len(tsa.Chain))
+	}
+	return tsa, c.CertFile
+}
+
+// newTestTimeStampReq encodes a request for the SHA-256 digest of data
+func newTestTimeStampReq(t *testing.T, data []byte, nonce int64) []byte {
+	t.Helper()
+	digest := sha256.Sum256(data)
+	der, err := asn1.Marshal(timeStampReq{
+		Version: 1,
+		MessageImprint: messageImprint{
+			HashAlgorithm: pkix.AlgorithmIdentifier{Algorithm: oidSHA256},
+			HashedMessage: digest[:],
+		},
+		Nonce:   big.NewInt(nonce),
+		CertReq: true,
+	})
+	if err != nil {
+		t.Fatalf("Failed to encode request: %v", err)
+	}
+	return der
+}
+
+// parseTestToken decodes a granted response into its SignedData and TSTInfo
+func parseTestToken(t *testing.T, resp []byte) (*signedData, *tstInfo) {
+	t.Helper()
+	var r timeStampResp
+	if _, err := asn1.Unmarshal(resp, &r); err != nil {
+		t.Fatalf("Failed to parse response: %v", err)
+	}
+	if r.Status.Status != tsaStatusGranted {
+		t.Fatalf("Expected status granted, got %d", r.Status.Status)
+	}
+	var ci contentInfo
+	if _, err := asn1.Unmarshal(r.TimeStampToken.FullBytes, &ci); err != nil {
+		t.Fatalf("Failed to parse token: %v", err)
+	}
+	var sd signedData
+	if _, err := asn1.Unmarshal(ci.Content.Bytes, &sd); err != nil {
+		t.Fatalf("Failed to parse SignedData: %v", err)
+	}
+	var info tstInfo
+	if _, err := asn1.Unmarshal(sd.EncapContentInfo.EContent, &info); err != nil {
+		t.Fatalf("Failed to parse TSTInfo: %v", err)
+	}
+	return &sd, &info
+}
+
+func TestTSARespond(t *testing.T) {
+	tsa, _ := newTestTSA(t)
+	tsa.Accuracy = 1500 * time.Millisecond
+
+	var serials []int64
+	for i, data := range []string{"first document", "second document"} {
+		resp, token, err := tsa.Respond(newTestTimeStampReq(t, []byte(data), int64(1000+i)), "127.0.0.1")
+		if err != nil {
+			t.Fatalf("Failed to time-stamp: %v", err)
+		}
+		sd, info := parseTestToken(t, resp)
+
+		if !info.Policy.Equal(tsa.Policy) {
+			t.Errorf("Expected policy %s, got %s", tsa.Policy, info.Policy)
+		}
+		if info.Nonce == nil || info.Nonce.Int64() != int64(1000+i) {
+			t.Errorf("Expected the nonce to be echoed, got %v", info.Nonce)
+		}
+		if info.Accuracy.Seconds != 1 || info.Accuracy.Millis != 500 {
+			t.Errorf("Unexpected accuracy %+v", info.Accuracy)
+		}
+		if token.SerialNumber != fmt.Sprintf("%X", info.SerialNumber) {
+			t.Errorf("Expected logged serial %X, got %s", info.SerialNumber, token.SerialNumber)
+		}
+		serials = append(serials, info.SerialNumber.Int64())
+
+		// The certificate is included on request and signs the attributes
+		cert, err := x509.ParseCertificate(sd.Certificates.Bytes)
+		if err != nil {
+			t.Fatalf("Failed to parse included certificate: %v", err)
+		}
+		signed, _ := asn1.Marshal(asn1.RawValue{Tag: asn1.TagSet, IsCompound: true, Bytes: sd.SignerInfos[0].SignedAttrs.Bytes})
+		digest := sha256.Sum256(signed)
+		if !ecdsa.VerifyASN1(cert.PublicKey.(*ecdsa.PublicKey), digest[:], sd.SignerInfos[0].Signature) {
+			t.Errorf("Token signature does not verify")
+		}
+	}
+	if serials[1] <= serials[0] {
+		t.Errorf("Expected increasing serials, got %v", serials)
+	}
+
+	tokens, err := TimeStampTokens(tsa.DatabaseDir)
+	if err != nil {
+		t.Fatalf("Failed to read token log: %v", err)
+	}
+	if len(tokens) != 2 || tokens[0].Client != "127.0.0.1" || tokens[1].Nonce != "3E9" {
+		t.Errorf("Unexpected token log %+v", tokens)
+	}
+}
+
+func TestTSARejections(t *testing.T) {
+	tsa, _ := newTestTSA(t)
+	digest := sha256.Sum256([]byte("data"))
+
+	tests := []struct {
+		name    string
+		req     interface{}
+		failure int
+	}{
+		{"malformed", asn1.RawValue{Tag: asn1.TagOctetString, Bytes: []byte("x")}, tsaFailBadDataFormat},
+		{"sha1", timeStampReq{Version: 1, MessageImprint: messageImprint{
+			HashAlgorithm: pkix.AlgorithmIdentifier{Algorithm: asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}},
+			HashedMessage: digest[:20]}}, tsaFailBadAlg},
+		{"short digest", timeStampReq{Version: 1, MessageImprint: messageImprint{
+			HashAlgorithm: pkix.AlgorithmIdentifier{Algorithm: oidSHA256},
+			HashedMessage: digest[:16]}}, tsaFailBadDataFormat},
+		{"policy", timeStampReq{Version: 1, MessageImprint: messageImprint{
+			HashAlgorithm: pkix.AlgorithmIdentifier{Algorithm: oidSHA256},
+			HashedMessage: digest[:]}, ReqPolicy: asn1.ObjectIdentifier{1, 2, 3}}, tsaFailUnacceptedPolicy},
+	}
+	for _, tt := range tests {
+		der, err := asn1.Marshal(tt.req)
+		if err != nil {
+			t.Fatalf("%s: failed to encode request: %v", tt.name, err)
+		}
+		resp, token, err := tsa.Respond(der, "")
+		var rejection *TSARejection
+		if !errors.As(err, &rejection) || token != nil {
+			t.Errorf("%s: expected a rejection, got %v", tt.name, err)
+			continue
+		}
+		var r timeStampResp
+		if _, err := asn1.Unmarshal(resp, &r); err != nil {
+			t.Fatalf("%s: failed to parse response: %v", tt.name, err)
+		}
+		if r.Status.Status != tsaStatusRejection || r.Status.FailInfo.At(tt.failure) != 1 {
+			t.Errorf("%s: expected failure bit %d, got %+v", tt.name, tt.failure, r.Status)
+		}
+	}
+
+	if tokens, _ := TimeStampTokens(tsa.DatabaseDir); len(tokens) != 0 {
+		t.Errorf("Expected rejected requests not to be logged, got %d tokens", len(tokens))
+	}
+}
+
+func TestTSAOpenSSLVerify(t *testing.T) {
+	if _, err := exec.LookPath("openssl"); err != nil {
+		t.Skip("openssl not installed")
+	}
+	tsa, caFile := newTestTSA(t)
+	dir := t.TempDir()
+
+	dataFile := filepath.Join(dir, "data.txt")
+	queryFile := filepath.Join(dir, "req.tsq")
+	replyFile := filepath.Join(dir, "resp.tsr")
+	tsaFile := filepath.Join(dir, "tsa.pem")
+	if err := os.WriteFile(dataFile, []byte("document to time-stamp"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(tsaFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: tsa.Certificate.Raw}), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		name string
+		args []string
+	}{
+		{"with certificate", []string{"-cert"}},
+		{"without certificate", nil},
+	} {
+		args := append([]string{"ts", "-query", "-data", dataFile, "-sha256", "-out", queryFile}, tt.args...)
+		if out, err := exec.Command("openssl", args...).CombinedOutput(); err != nil {
+			t.Fatalf("%s: openssl ts -query failed: %v\n%s", tt.name, err, out)
+		}
+		query, err := os.ReadFile(queryFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, _, err := tsa.Respond(query, "")
+		if err != nil {
+			t.Fatalf("%s: failed to time-stamp: %v", tt.name, err)
+		}
+		if err := os.WriteFile(replyFile, resp, 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		out, err := exec.Command("openssl", "ts", "-verify", "-data", dataFile, "-in", replyFile,
+			"-CAfile", caFile, "-untrusted", tsaFile).CombinedOutput()
+		if err != nil {
+			t.Errorf("%s: openssl ts -verify failed: %v\n%s", tt.name, err, out)
+		}
+	}
+}
@@ -0,0 +1,312 @@
+// Package ceremony implements key ceremonies for the root CA: the key is
+// generated on an air-gapped machine, loaded into hardware providers and
+// backed up encrypted, with the backup key split among custodians so that
+// a quorum of them can recover it. Every ceremony produces a transcript
+// signed with the CA key.
+package ceremony
+
+import (
+	"bytes"
+	gocrypto "crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// FormatVersion is the version of backup, share and transcript files
+const FormatVersion = 1
+
+// backupCipher is the only cipher backups use
+const backupCipher = "AES-256-GCM"
+
+// Backup is the CA key encrypted with a random key that only exists split
+// into shares
+type Backup struct {
+	Version   int       `json:"version"`
+	Ceremony  string    `json:"ceremony"`
+	CreatedAt time.Time `json:"createdAt"`
+	Cipher    string    `json:"cipher"`
+	Nonce     []byte    `json:"nonce"`
+	// Ciphertext is the PKCS #8 encoded key, sealed with the ceremony and
+	// the public key as additional data
+	Ciphertext []byte `json:"ciphertext"`
+	// PublicKey is the PKIX encoded public key
+	PublicKey   []byte   `json:"publicKey"`
+	Certificate []byte   `json:"certificate,omitempty"`
+	Threshold   int      `json:"threshold"`
+	Custodians  []string `json:"custodians"`
+}
+
+// Share is one custodian's share of the backup key
+type Share struct {
+	Version   int    `json:"version"`
+	Ceremony  string `json:"ceremony"`
+	Custodian string `json:"custodian"`
+	// Index is the x coordinate of the share, 1 to Total
+	Index     int `json:"index"`
+	Threshold int `json:"threshold"`
+	Total     int `json:"total"`
+	// Backup is the fingerprint of the backup the share opens
+	Backup string `json:"backup"`
+	Value  []byte `json:"value"`
+}
+
+// NewID returns a ceremony identifier such as 20261018T101500Z-9f2c41ab
+func NewID() string {
+	random := make([]byte, 4)
+	rand.Read(random)
+	return time.Now().UTC().Format("20060102T150405Z") + "-" + hex.EncodeToString(random)
+}
+
+// GenerateKey generates a CA key in memory, e.g. "ECDSA" 384
+func GenerateKey(algorithm string, bits int) (gocrypto.Signer, error) {
+	switch algorithm {
+	case "ECDSA":
+		var curve elliptic.Curve
+		switch bits {
+		case 256:
+			curve = elliptic.P256()
+		case 384:
+			curve = elliptic.P384()
+		case 521:
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported ECDSA curve size %d", bits)
+		}
+		return ecdsa.GenerateKey(curve, rand.Reader)
+	case "RSA":
+		if bits != 2048 && bits != 3072 && bits != 4096 {
+			return nil, fmt.Errorf("unsupported RSA key size %d", bits)
+		}
+		return rsa.GenerateKey(rand.Reader, bits)
+	}
+	return nil, fmt.Errorf("unsupported key algorithm %s", algorithm)
+}
+
+// Fingerprint returns the SHA-256 of a public key's PKIX encoding
+func Fingerprint(pub gocrypto.PublicKey) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(der)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// NewBackup encrypts a key under a random backup key and splits that among
+// the custodians, any threshold of whom can recover the key
+func NewBackup(id string, key gocrypto.Signer, cert *x509.Certificate, custodians []string, threshold int) (*Backup, []*Share, error) {
+	seen := make(map[string]bool)
+	for _, c := range custodians {
+		if c == "" || seen[c] {
+			return nil, nil, fmt.Errorf("custodian names must be unique and not empty")
+		}
+		seen[c] = true
+	}
+
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to encode key: %w", err)
+	}
+	defer zero(pkcs8)
+	pub, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, nil, err
+	}
+	defer zero(dataKey)
+	values, err := SplitSecret(dataKey, len(custodians), threshold)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	b := &Backup{
+		Version:    FormatVersion,
+		Ceremony:   id,
+		CreatedAt:  time.Now().UTC(),
+		Cipher:     backupCipher,
+		PublicKey:  pub,
+		Threshold:  threshold,
+		Custodians: custodians,
+	}
+	if cert != nil {
+		b.Certificate = cert.Raw
+	}
+	gcm, err := newGCM(dataKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	b.Nonce = make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(b.Nonce); err != nil {
+		return nil, nil, err
+	}
+	b.Ciphertext = gcm.Seal(nil, b.Nonce, pkcs8, b.additionalData())
+
+	fingerprint := b.Fingerprint()
+	shares := make([]*Share, len(custodians))
+	for i, custodian := range custodians {
+		shares[i] = &Share{
+			Version:   FormatVersion,
+			Ceremony:  id,
+			Custodian: custodian,
+			Index:     i + 1,
+			Threshold: threshold,
+			Total:     len(custodians),
+			Backup:    fingerprint,
+			Value:     values[i],
+		}
+	}
+	return b, shares, nil
+}
+
+// Fingerprint returns the SHA-256 of the encrypted key, which shares and
+// transcripts refer to
+func (b *Backup) Fingerprint() string {
+	sum := sha256.Sum256(b.Ciphertext)
+	return hex.EncodeToString(sum[:])
+}
+
+// additionalData binds the ciphertext to its ceremony and public key
+func (b *Backup) additionalData() []byte {
+	return append([]byte(b.Ceremony+"\x00"), b.PublicKey...)
+}
+
+// ParseCertificate returns the CA certificate stored with the backup, or
+// nil when there is none
+func (b *Backup) ParseCertificate() (*x509.Certificate, error) {
+	if len(b.Certificate) == 0 {
+		return nil, nil
+	}
+	return x509.ParseCertificate(b.Certificate)
+}
+
+// Recover reassembles the backup key from at least Threshold shares and
+// decrypts the CA key
+func (b *Backup) Recover(shares []*Share) (gocrypto.Signer, error) {
+	if b.Version != FormatVersion || b.Cipher != backupCipher {
+		return nil, fmt.Errorf("unsupported backup version %d with cipher %q", b.Version, b.Cipher)
+	}
+	fingerprint := b.Fingerprint()
+	values := make(map[int][]byte)
+	for _, s := range shares {
+		if s.Ceremony != b.Ceremony || s.Backup != fingerprint {
+			return nil, fmt.Errorf("the share of %s belongs to another backup", s.Custodian)
+		}
+		if _, dup := values[s.Index]; dup {
+			return nil, fmt.Errorf("share %d was given twice", s.Index)
+		}
+		values[s.Index] = s.Value
+	}
+	if len(values) < b.Threshold {
+		return nil, fmt.Errorf("%d shares given, %d are needed", len(values), b.Threshold)
+	}
+
+	dataKey, err := CombineShares(values)
+	if err != nil {
+		return nil, err
+	}
+	defer zero(dataKey)
+	gcm, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	pkcs8, err := gcm.Open(nil, b.Nonce, b.Ciphertext, b.additionalData())
+	if err != nil {
+		return nil, errors.New("the shares do not open the backup: a share is corrupt or from another split")
+	}
+	defer zero(pkcs8)
+
+	key, err := x509.ParsePKCS8PrivateKey(pkcs8)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse recovered key: %w", err)
+	}
+	signer, ok := key.(gocrypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported recovered key type %T", key)
+	}
+	pub, err := x509.MarshalPKIXPublicKey(signer.Public())
+	if err != nil || !bytes.Equal(pub, b.PublicKey) {
+		return nil, errors.New("the recovered key does not match the backup's public key")
+	}
+	return signer, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// zero overwrites key material that is no longer needed
+func zero(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
+
+// WriteFile writes a backup, share or transcript as JSON, readable by its
+// owner only
+func WriteFile(filename string, v interface{}) error {
+	if err := os.MkdirAll(filepath.Dir(filename), 0700); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filename, append(data, '\n'), 0600); err != nil {
+		return fmt.Errorf("failed to write %s: %w", filename, err)
+	}
+	return nil
+}
+
+// readFile reads a JSON file written by WriteFile
+func readFile(filename string, v interface{}) error {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("failed to parse %s: %w", filename, err)
+	}
+	return nil
+}
+
+// ReadBackup reads a backup file
+func ReadBackup(filename string) (*Backup, error) {
+	b := &Backup{}
+	if err := readFile(filename, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// ReadShare reads a custodian's share file
+func ReadShare(filename string) (*Share, error) {
+	s := &Share{}
+	if err := readFile(filename, s); err != nil {
+		return nil, err
+	}
+	if s.Version != FormatVersion || len(s.Value) == 0 {
+		return nil, fmt.Errorf("%s is not a key share", filename)
+	}
+	return s, nil
+}
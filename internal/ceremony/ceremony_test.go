@@ -0,0 +1,150 @@
+package ceremony
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"path/filepath"
+	"testing"
+)
+
+func TestShamir(t *testing.T) {
+	secret := []byte("a 32 byte key for the CA backup!")
+	shares, err := SplitSecret(secret, 5, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Every 3-subset recovers the secret
+	for a := 0; a < 5; a++ {
+		for b := a + 1; b < 5; b++ {
+			for c := b + 1; c < 5; c++ {
+				got, err := CombineShares(map[int][]byte{a + 1: shares[a], b + 1: shares[b], c + 1: shares[c]})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(got, secret) {
+					t.Errorf("Shares %d, %d and %d did not recover the secret", a+1, b+1, c+1)
+				}
+			}
+		}
+	}
+	all := map[int][]byte{}
+	for i, s := range shares {
+		all[i+1] = s
+	}
+	if got, _ := CombineShares(all); !bytes.Equal(got, secret) {
+		t.Error("All shares did not recover the secret")
+	}
+
+	// Two shares reveal nothing
+	if got, _ := CombineShares(map[int][]byte{1: shares[0], 4: shares[3]}); bytes.Equal(got, secret) {
+		t.Error("Expected two of three shares not to recover the secret")
+	}
+
+	for _, split := range [][2]int{{3, 1}, {3, 4}, {256, 2}} {
+		if _, err := SplitSecret(secret, split[0], split[1]); err == nil {
+			t.Errorf("Expected an error splitting into %d shares with threshold %d", split[0], split[1])
+		}
+	}
+	if _, err := CombineShares(map[int][]byte{1: shares[0]}); err == nil {
+		t.Error("Expected an error combining a single share")
+	}
+}
+
+func TestBackup(t *testing.T) {
+	dir := t.TempDir()
+	key, err := GenerateKey("ECDSA", 256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	backup, shares, err := NewBackup(NewID(), key, nil, []string{"alice", "bob", "carol"}, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := NewBackup("dup", key, nil, []string{"alice", "alice"}, 2); err == nil {
+		t.Error("Expected an error for duplicate custodians")
+	}
+
+	// Round trip through files
+	backupFile := filepath.Join(dir, "backup.json")
+	if err := WriteFile(backupFile, backup); err != nil {
+		t.Fatal(err)
+	}
+	var files []string
+	for _, s := range shares {
+		file := filepath.Join(dir, s.Custodian+".json")
+		if err := WriteFile(file, s); err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, file)
+	}
+	backup, err = ReadBackup(backupFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob, _ := ReadShare(files[1])
+	carol, _ := ReadShare(files[2])
+
+	recovered, err := backup.Recover([]*Share{carol, bob})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !recovered.(*ecdsa.PrivateKey).Equal(key) {
+		t.Error("Recovered key differs from the original")
+	}
+
+	if _, err := backup.Recover([]*Share{bob}); err == nil {
+		t.Error("Expected an error below the threshold")
+	}
+	if _, err := backup.Recover([]*Share{bob, bob}); err == nil {
+		t.Error("Expected an error for a repeated share")
+	}
+	corrupt := *carol
+	corrupt.Value = append([]byte(nil), carol.Value...)
+	corrupt.Value[0] ^= 1
+	if _, err := backup.Recover([]*Share{bob, &corrupt}); err == nil {
+		t.Error("Expected an error for a corrupt share")
+	}
+	other, otherShares, _ := NewBackup(backup.Ceremony, key, nil, []string{"alice", "bob"}, 2)
+	if _, err := other.Recover([]*Share{bob, otherShares[0]}); err == nil {
+		t.Error("Expected an error for a share of another backup")
+	}
+}
+
+func TestTranscript(t *testing.T) {
+	key, err := GenerateKey("RSA", 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := NewTranscript(NewID(), KindGeneration, []Participant{{Name: "alice", Role: RoleCustodian}, {Name: "dave", Role: RoleWitness}})
+	tr.Step("generate", "RSA %d", 2048)
+	tr.Hash("certificate", []byte("cert"))
+	if err := tr.Verify(key.Public()); err == nil {
+		t.Error("Expected an unsigned transcript not to verify")
+	}
+	if err := tr.Sign(key); err != nil {
+		t.Fatal(err)
+	}
+
+	file := filepath.Join(t.TempDir(), "transcript.json")
+	if err := WriteFile(file, tr); err != nil {
+		t.Fatal(err)
+	}
+	read, err := ReadTranscript(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := read.Verify(key.Public()); err != nil {
+		t.Errorf("Transcript does not verify after a round trip: %v", err)
+	}
+
+	read.Participants = append(read.Participants, Participant{Name: "mallory", Role: RoleWitness})
+	if err := read.Verify(key.Public()); err == nil {
+		t.Error("Expected a tampered transcript not to verify")
+	}
+	other, _ := GenerateKey("RSA", 2048)
+	if err := tr.Verify(other.(*rsa.PrivateKey).Public()); err == nil {
+		t.Error("Expected the transcript not to verify with another key")
+	}
+}
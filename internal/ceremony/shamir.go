@@ -0,0 +1,124 @@
+package ceremony
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+)
+
+// Shamir's secret sharing over GF(2^8) with the AES polynomial
+// x^8 + x^4 + x^3 + x + 1. Every byte of the secret is the constant term of
+// its own random polynomial of degree threshold-1; share i holds the
+// polynomials evaluated at x = i.
+
+var (
+	gfExp [510]byte
+	gfLog [256]byte
+)
+
+func init() {
+	// 3 generates the multiplicative group of the field
+	x := byte(1)
+	for i := 0; i < 255; i++ {
+		gfExp[i] = x
+		gfExp[i+255] = x
+		gfLog[x] = byte(i)
+		x ^= gfDouble(x)
+	}
+}
+
+// gfDouble multiplies by x in GF(2^8)
+func gfDouble(b byte) byte {
+	if b&0x80 != 0 {
+		return b<<1 ^ 0x1b
+	}
+	return b << 1
+}
+
+func gfMul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return gfExp[int(gfLog[a])+int(gfLog[b])]
+}
+
+func gfDiv(a, b byte) byte {
+	if a == 0 {
+		return 0
+	}
+	return gfExp[int(gfLog[a])+255-int(gfLog[b])]
+}
+
+// SplitSecret splits a secret into n shares of which any threshold
+// reconstruct it. Share i is evaluated at x = i+1, which CombineShares
+// needs to know.
+func SplitSecret(secret []byte, n, threshold int) ([][]byte, error) {
+	if threshold < 2 || threshold > n || n > 255 {
+		return nil, fmt.Errorf("invalid sharing: %d of %d shares (need 2 <= threshold <= shares <= 255)", threshold, n)
+	}
+	if len(secret) == 0 {
+		return nil, errors.New("cannot share an empty secret")
+	}
+
+	shares := make([][]byte, n)
+	for i := range shares {
+		shares[i] = make([]byte, len(secret))
+	}
+	coefficients := make([]byte, threshold)
+	for b, s := range secret {
+		coefficients[0] = s
+		if _, err := rand.Read(coefficients[1:]); err != nil {
+			return nil, err
+		}
+		for i := range shares {
+			// Horner's rule at x = i+1
+			x := byte(i + 1)
+			var y byte
+			for c := threshold - 1; c >= 0; c-- {
+				y = gfMul(y, x) ^ coefficients[c]
+			}
+			shares[i][b] = y
+		}
+	}
+	for i := range coefficients {
+		coefficients[i] = 0
+	}
+	return shares, nil
+}
+
+// CombineShares reconstructs a secret from shares keyed by their x
+// coordinate. Fewer shares than the threshold yield a wrong secret, which
+// the caller must detect.
+func CombineShares(shares map[int][]byte) ([]byte, error) {
+	if len(shares) < 2 {
+		return nil, errors.New("at least two shares are needed")
+	}
+	size := -1
+	for x, share := range shares {
+		if x < 1 || x > 255 {
+			return nil, fmt.Errorf("invalid share index %d", x)
+		}
+		if size >= 0 && len(share) != size {
+			return nil, errors.New("shares differ in length")
+		}
+		size = len(share)
+	}
+
+	// Lagrange interpolation at x = 0
+	secret := make([]byte, size)
+	for xi, share := range shares {
+		var num, den byte = 1, 1
+		for xj := range shares {
+			if xj == xi {
+				continue
+			}
+			num = gfMul(num, byte(xj))
+			den = gfMul(den, byte(xi)^byte(xj))
+		}
+		basis := gfDiv(num, den)
+		for b := range secret {
+			secret[b] ^= gfMul(share[b], basis)
+		}
+	}
+	return secret, nil
+}
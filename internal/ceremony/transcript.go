@@ -0,0 +1,201 @@
+package ceremony
+
+import (
+	gocrypto "crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/billchurch/PiCA/internal/audit"
+)
+
+// Ceremony kinds
+const (
+	// KindGeneration is the ceremony that creates the root key
+	KindGeneration = "generation"
+	// KindRecovery restores the root key from its backup
+	KindRecovery = "recovery"
+)
+
+// Participant roles
+const (
+	RoleCustodian = "custodian"
+	RoleWitness   = "witness"
+)
+
+// Participant is a person present at a ceremony
+type Participant struct {
+	Name string `json:"name"`
+	Role string `json:"role"`
+}
+
+// Step is one action taken during a ceremony
+type Step struct {
+	Time   time.Time `json:"time"`
+	Action string    `json:"action"`
+	Detail string    `json:"detail,omitempty"`
+}
+
+// Transcript records who attended a ceremony, what was done and the hashes
+// of everything it produced, signed with the CA key
+type Transcript struct {
+	Version      int               `json:"version"`
+	ID           string            `json:"id"`
+	Kind         string            `json:"kind"`
+	StartedAt    time.Time         `json:"startedAt"`
+	FinishedAt   time.Time         `json:"finishedAt"`
+	Participants []Participant     `json:"participants"`
+	Steps        []Step            `json:"steps"`
+	Hashes       map[string]string `json:"hashes"`
+	Signature    []byte            `json:"signature,omitempty"`
+}
+
+// NewTranscript starts the transcript of a ceremony
+func NewTranscript(id, kind string, participants []Participant) *Transcript {
+	return &Transcript{
+		Version:      FormatVersion,
+		ID:           id,
+		Kind:         kind,
+		StartedAt:    time.Now().UTC(),
+		Participants: participants,
+		Hashes:       make(map[string]string),
+	}
+}
+
+// Step records an action
+func (t *Transcript) Step(action, format string, args ...interface{}) {
+	t.Steps = append(t.Steps, Step{
+		Time:   time.Now().UTC(),
+		Action: action,
+		Detail: fmt.Sprintf(format, args...),
+	})
+}
+
+// Hash records the SHA-256 of an artifact
+func (t *Transcript) Hash(name string, data []byte) {
+	sum := sha256.Sum256(data)
+	t.Hashes[name] = hex.EncodeToString(sum[:])
+}
+
+// HashFile records the SHA-256 of a file the ceremony wrote, under its base
+// name
+func (t *Transcript) HashFile(filename string) error {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	t.Hash(filepath.Base(filename), data)
+	return nil
+}
+
+// Fingerprint returns the SHA-256 of the signed content, suitable for
+// reading aloud at the end of the ceremony
+func (t *Transcript) Fingerprint() (string, error) {
+	digest, err := t.digest()
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(digest), nil
+}
+
+// Sign closes the transcript and signs it
+func (t *Transcript) Sign(signer gocrypto.Signer) error {
+	t.FinishedAt = time.Now().UTC()
+	digest, err := t.digest()
+	if err != nil {
+		return err
+	}
+	sig, err := signer.Sign(rand.Reader, digest, gocrypto.SHA256)
+	if err != nil {
+		return fmt.Errorf("failed to sign transcript: %w", err)
+	}
+	t.Signature = sig
+	return nil
+}
+
+// Verify checks the transcript signature against the CA public key
+func (t *Transcript) Verify(pub gocrypto.PublicKey) error {
+	if t.Version != FormatVersion {
+		return fmt.Errorf("unsupported transcript version: %d", t.Version)
+	}
+	if len(t.Signature) == 0 {
+		return errors.New("transcript is not signed")
+	}
+	digest, err := t.digest()
+	if err != nil {
+		return err
+	}
+
+	switch key := pub.(type) {
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(key, digest, t.Signature) {
+			return errors.New("transcript signature verification failed")
+		}
+	case *rsa.PublicKey:
+		if err := rsa.VerifyPKCS1v15(key, gocrypto.SHA256, digest, t.Signature); err != nil {
+			return fmt.Errorf("transcript signature verification failed: %w", err)
+		}
+	default:
+		return fmt.Errorf("unsupported public key type: %T", pub)
+	}
+	return nil
+}
+
+// digest hashes everything but the signature
+func (t *Transcript) digest() ([]byte, error) {
+	unsigned := *t
+	unsigned.Signature = nil
+	data, err := json.Marshal(unsigned)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode transcript: %w", err)
+	}
+	sum := sha256.Sum256(data)
+	return sum[:], nil
+}
+
+// Record adds the signed transcript to the audit log of the CA
+func (t *Transcript) Record(caName string) {
+	eventType := audit.EventCeremony
+	if t.Kind == KindRecovery {
+		eventType = audit.EventRecovery
+	}
+	fingerprint, _ := t.Fingerprint()
+	participants := make([]string, len(t.Participants))
+	for i, p := range t.Participants {
+		participants[i] = p.Name + " (" + p.Role + ")"
+	}
+	audit.Record(audit.Event{
+		Type: eventType,
+		CA:   caName,
+		Details: map[string]interface{}{
+			"ceremony":     t.ID,
+			"transcript":   fingerprint,
+			"signature":    hex.EncodeToString(t.Signature),
+			"participants": participants,
+			"hashes":       t.Hashes,
+			"startedAt":    t.StartedAt,
+			"finishedAt":   t.FinishedAt,
+		},
+	})
+}
+
+// ReadTranscript reads a transcript file. The caller must still call Verify
+// with the CA key.
+func ReadTranscript(filename string) (*Transcript, error) {
+	t := &Transcript{}
+	if err := readFile(filename, t); err != nil {
+		return nil, err
+	}
+	if t.Kind != KindGeneration && t.Kind != KindRecovery {
+		return nil, fmt.Errorf("%s is not a ceremony transcript", filename)
+	}
+	return t, nil
+}
@@ -13,6 +13,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/billchurch/PiCA/internal/crypto"
 	"github.com/pelletier/go-toml"
 )
 
@@ -27,13 +28,49 @@ type Config struct {
 	CAConfigFile     string `env:"CA_CONFIG" flag:"ca-config" config:"ca_config" default:""`
 	CACertFile       string `env:"CA_CERT" flag:"ca-cert" config:"ca_cert" default:""`
 	CRLFile          string `env:"CRL_FILE" flag:"crl-file" config:"crl_file" default:""`
+	CRLURL           string `env:"CRL_URL" flag:"crl-url" config:"crl_url" default:""`
+	DeltaCRLURL      string `env:"DELTA_CRL_URL" flag:"delta-crl-url" config:"delta_crl_url" default:""`
+	CRLValidity      string `env:"CRL_VALIDITY" flag:"crl-validity" config:"crl_validity" default:"168h"`
+	CRLRenewBefore   string `env:"CRL_RENEW_BEFORE" flag:"crl-renew-before" config:"crl_renew_before" default:"48h"`
+	DeltaCRLInterval string `env:"DELTA_CRL_INTERVAL" flag:"delta-crl-interval" config:"delta_crl_interval" default:""`
+	RootCRLValidity  string `env:"ROOT_CRL_VALIDITY" flag:"root-crl-validity" config:"root_crl_validity" default:"8760h"`
 	RootCACertFile   string `env:"ROOT_CA_CERT" flag:"root-ca-cert" config:"root_ca_cert" default:""`
 	RootCAConfigFile string `env:"ROOT_CA_CONFIG" flag:"root-ca-config" config:"root_ca_config" default:""`
 	CAProfile        string `env:"CA_PROFILE" flag:"ca-profile" config:"ca_profile" default:""`
+	HierarchyFile    string `env:"HIERARCHY_FILE" flag:"hierarchy" config:"hierarchy_file" default:""`
+	CAName           string `env:"CA_NAME" flag:"ca-name" config:"ca_name" default:""`
+
+	// SSH CA settings
+	SSHConfigFile string `env:"SSH_CONFIG" flag:"ssh-config" config:"ssh_config" default:""`
+	SSHKeySlot    string `env:"SSH_KEY_SLOT" flag:"ssh-key-slot" config:"ssh_key_slot" default:"84"`
+	SSHKRLFile    string `env:"SSH_KRL_FILE" flag:"ssh-krl-file" config:"ssh_krl_file" default:""`
+
+	// Time-stamping authority settings
+	TSACertFile string `env:"TSA_CERT" flag:"tsa-cert" config:"tsa_cert" default:""`
+	TSAKeySlot  string `env:"TSA_KEY_SLOT" flag:"tsa-key-slot" config:"tsa_key_slot" default:"85"`
+	TSAPolicy   string `env:"TSA_POLICY" flag:"tsa-policy" config:"tsa_policy" default:""`
+	TSAAccuracy string `env:"TSA_ACCURACY" flag:"tsa-accuracy" config:"tsa_accuracy" default:"1s"`
 
 	// Provider settings
 	ProviderType string `env:"PICA_PROVIDER" flag:"provider" config:"provider" default:""`
+	// ProviderOptions are passed to the provider, e.g. the socket of a
+	// plugin provider; "key=value,key=value" in the environment and on
+	// the command line
+	ProviderOptions map[string]string `env:"PICA_PROVIDER_OPTIONS" flag:"provider-options" config:"provider_options"`
 	KeySlot      string `env:"KEY_SLOT" flag:"key-slot" config:"key_slot" default:"82"`
+	// PINCacheTimeout is how long pica-web keeps a PIN entered through
+	// /api/unlock; zero keeps it until locked again
+	PINCacheTimeout string `env:"PIN_CACHE_TIMEOUT" flag:"pin-cache-timeout" config:"pin_cache_timeout" default:"15m"`
+	// AttestationRoots is a PEM file of attestation roots trusted in
+	// addition to the bundled Yubico roots
+	AttestationRoots string `env:"ATTESTATION_ROOTS" flag:"attestation-roots" config:"attestation_roots" default:""`
+
+	// Remote signer settings, used by pica-signer
+	SignerListen   string `env:"SIGNER_LISTEN" flag:"signer-listen" config:"signer_listen" default:""`
+	SignerTLSCert  string `env:"SIGNER_TLS_CERT" flag:"signer-tls-cert" config:"signer_tls_cert" default:""`
+	SignerTLSKey   string `env:"SIGNER_TLS_KEY" flag:"signer-tls-key" config:"signer_tls_key" default:""`
+	SignerClientCA string `env:"SIGNER_CLIENT_CA" flag:"signer-client-ca" config:"signer_client_ca" default:""`
+	SignerPolicy   string `env:"SIGNER_POLICY" flag:"signer-policy" config:"signer_policy" default:""`
 
 	// Web server settings
 	WebPort      int    `env:"WEB_PORT" flag:"port" config:"web_port" default:"8080"`
@@ -42,12 +79,17 @@ type Config struct {
 	WebTLSKey    string `env:"WEB_TLS_KEY" flag:"tls-key" config:"web_tls_key" default:""`
 	EnableHTTPS  bool   `env:"ENABLE_HTTPS" flag:"https" config:"enable_https" default:"false"`
 	RedirectHTTP bool   `env:"REDIRECT_HTTP" flag:"redirect-http" config:"redirect_http" default:"true"`
+	WebCAs       string `env:"WEB_CAS" flag:"cas" config:"web_cas" default:""`
 
 	// Storage settings
 	CertDir     string `env:"CERT_DIR" flag:"certdir" config:"cert_dir" default:"./certs"`
 	CSRDir      string `env:"CSR_DIR" flag:"csrdir" config:"csr_dir" default:"./csrs"`
 	LogDir      string `env:"LOG_DIR" flag:"logdir" config:"log_dir" default:"./logs"`
 	DatabaseDir string `env:"DB_DIR" flag:"dbdir" config:"db_dir" default:"./db"`
+	AuditLog    string `env:"AUDIT_LOG" flag:"audit-log" config:"audit_log" default:""`
+
+	// Integration settings
+	WebhooksFile string `env:"WEBHOOKS_FILE" flag:"webhooks" config:"webhooks_file" default:""`
 }
 
 // DefaultConfig returns a new Config with default values
@@ -86,7 +128,8 @@ func DefaultConfig() *Config {
 	return cfg
 }
 
-// LoadConfigFromFile loads configuration from a file
+// LoadConfigFromFile loads configuration from a file. Keys are the
+// `config` tags of the Config fields.
 func (cfg *Config) LoadConfigFromFile(configFile string) error {
 	if configFile == "" {
 		return nil
@@ -98,23 +141,150 @@ func (cfg *Config) LoadConfigFromFile(configFile string) error {
 	}
 
 	// Determine file type based on extension
+	var values map[string]interface{}
 	ext := strings.ToLower(filepath.Ext(configFile))
 	switch ext {
 	case ".json":
-		if err := json.Unmarshal(data, cfg); err != nil {
+		if err := json.Unmarshal(data, &values); err != nil {
 			return fmt.Errorf("error parsing JSON config: %w", err)
 		}
 	case ".toml":
-		if err := toml.Unmarshal(data, cfg); err != nil {
+		tree, err := toml.LoadBytes(data)
+		if err != nil {
 			return fmt.Errorf("error parsing TOML config: %w", err)
 		}
+		values = tree.ToMap()
 	default:
 		return fmt.Errorf("unsupported config file format: %s", ext)
 	}
 
+	return cfg.setFileValues(values)
+}
+
+// setFileValues assigns the values of a config file to the fields. A key
+// matches a field's `config` tag or, case-insensitively, its name.
+func (cfg *Config) setFileValues(values map[string]interface{}) error {
+	// The provider may be a section holding the type and its options
+	if section, ok := values["provider"].(map[string]interface{}); ok {
+		values = copyValues(values)
+		delete(values, "provider")
+		if typ, ok := section["type"]; ok {
+			values["provider"] = typ
+		}
+		options := map[string]interface{}{}
+		if o, ok := values["provider_options"].(map[string]interface{}); ok {
+			options = copyValues(o)
+		}
+		for k, v := range section {
+			if k != "type" {
+				options[k] = v
+			}
+		}
+		values["provider_options"] = options
+	}
+
+	t := reflect.TypeOf(*cfg)
+	v := reflect.ValueOf(cfg).Elem()
+
+	for key, value := range values {
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if field.Tag.Get("config") != key && !strings.EqualFold(field.Name, key) {
+				continue
+			}
+			if err := setValue(v.Field(i), value); err != nil {
+				return fmt.Errorf("invalid value for %s: %w", key, err)
+			}
+			break
+		}
+	}
 	return nil
 }
 
+// setValue assigns a decoded config file value to a field
+func setValue(fieldValue reflect.Value, value interface{}) error {
+	switch fieldValue.Kind() {
+	case reflect.String:
+		s, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("expected a string, got %v", value)
+		}
+		fieldValue.SetString(s)
+	case reflect.Int:
+		switch n := value.(type) {
+		case float64:
+			fieldValue.SetInt(int64(n))
+		case int64:
+			fieldValue.SetInt(n)
+		default:
+			return fmt.Errorf("expected a number, got %v", value)
+		}
+	case reflect.Bool:
+		b, ok := value.(bool)
+		if !ok {
+			return fmt.Errorf("expected true or false, got %v", value)
+		}
+		fieldValue.SetBool(b)
+	case reflect.Float64:
+		switch n := value.(type) {
+		case float64:
+			fieldValue.SetFloat(n)
+		case int64:
+			fieldValue.SetFloat(float64(n))
+		default:
+			return fmt.Errorf("expected a number, got %v", value)
+		}
+	case reflect.Map:
+		section, ok := value.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("expected a section, got %v", value)
+		}
+		options := make(map[string]string, len(section))
+		for k, v := range section {
+			options[k] = fmt.Sprint(v)
+		}
+		mergeOptions(fieldValue, options)
+	}
+	return nil
+}
+
+// copyValues returns a shallow copy of a decoded section
+func copyValues(values map[string]interface{}) map[string]interface{} {
+	c := make(map[string]interface{}, len(values))
+	for k, v := range values {
+		c[k] = v
+	}
+	return c
+}
+
+// ParseOptions parses options in the "key=value,key=value" form
+func ParseOptions(s string) (map[string]string, error) {
+	options := map[string]string{}
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		k, v, ok := strings.Cut(pair, "=")
+		if !ok || strings.TrimSpace(k) == "" {
+			return nil, fmt.Errorf("invalid option %q, expected key=value", pair)
+		}
+		options[strings.TrimSpace(k)] = strings.TrimSpace(v)
+	}
+	return options, nil
+}
+
+// mergeOptions adds options to a map[string]string field, overriding
+// existing keys
+func mergeOptions(fieldValue reflect.Value, options map[string]string) {
+	if fieldValue.IsNil() {
+		fieldValue.Set(reflect.ValueOf(map[string]string{}))
+	}
+	for k, v := range options {
+		fieldValue.SetMapIndex(reflect.ValueOf(k), reflect.ValueOf(v))
+	}
+}
+
 // LoadFromEnvironment loads configuration from environment variables
 func (cfg *Config) LoadFromEnvironment() {
 	t := reflect.TypeOf(*cfg)
@@ -143,6 +313,10 @@ func (cfg *Config) LoadFromEnvironment() {
 					if floatVal, err := strconv.ParseFloat(envValue, 64); err == nil {
 						fieldValue.SetFloat(floatVal)
 					}
+				case reflect.Map:
+					if options, err := ParseOptions(envValue); err == nil {
+						mergeOptions(fieldValue, options)
+					}
 				}
 			}
 		}
@@ -171,8 +345,9 @@ func (cfg *Config) LoadDefaults() error {
 // Validate checks if the configuration is valid
 func (cfg *Config) Validate() error {
 	// Validate required CA settings based on if we need a web server
-	if os.Args[0] == "pica-web" || strings.Contains(os.Args[0], "pica-web") {
+	if (os.Args[0] == "pica-web" || strings.Contains(os.Args[0], "pica-web")) && cfg.HierarchyFile == "" {
 		// For the web server, we definitely need CA config and certificate
+		// unless the CAs come from a hierarchy file
 		if cfg.CAConfigFile == "" {
 			return fmt.Errorf("CA config file is required for the web server")
 		}
@@ -189,6 +364,44 @@ func (cfg *Config) Validate() error {
 		}
 	}
 	
+	if cfg.SSHKeySlot != "" {
+		if _, err := strconv.ParseInt(cfg.SSHKeySlot, 16, 64); err != nil {
+			return fmt.Errorf("invalid SSH key slot format (must be hex): %s", cfg.SSHKeySlot)
+		}
+	}
+
+	if cfg.TSAKeySlot != "" {
+		if _, err := strconv.ParseInt(cfg.TSAKeySlot, 16, 64); err != nil {
+			return fmt.Errorf("invalid TSA key slot format (must be hex): %s", cfg.TSAKeySlot)
+		}
+	}
+
+	// Validate CRL lifetimes
+	for name, val := range map[string]string{
+		"CRL validity":       cfg.CRLValidity,
+		"CRL renew before":   cfg.CRLRenewBefore,
+		"delta CRL interval": cfg.DeltaCRLInterval,
+		"root CRL validity":  cfg.RootCRLValidity,
+		"TSA accuracy":       cfg.TSAAccuracy,
+		"PIN cache timeout":  cfg.PINCacheTimeout,
+	} {
+		if val == "" {
+			continue
+		}
+		if _, err := time.ParseDuration(val); err != nil {
+			return fmt.Errorf("invalid %s: %s", name, val)
+		}
+	}
+
+	// Validate the provider and its options
+	if cfg.ProviderType != "" {
+		if err := crypto.ValidateProviderConfig(crypto.ProviderType(cfg.ProviderType), cfg.ProviderConfig()); err != nil {
+			return fmt.Errorf("invalid provider configuration: %w", err)
+		}
+	} else if len(cfg.ProviderOptions) > 0 {
+		return fmt.Errorf("provider options require a provider type")
+	}
+
 	// Validate HTTPS settings
 	if cfg.EnableHTTPS {
 		if cfg.WebTLSCert == "" {
@@ -202,7 +415,47 @@ func (cfg *Config) Validate() error {
 	return nil
 }
 
+// ProviderConfig returns the provider options in the form the crypto
+// package takes them
+func (cfg *Config) ProviderConfig() map[string]interface{} {
+	opts := make(map[string]interface{}, len(cfg.ProviderOptions))
+	for k, v := range cfg.ProviderOptions {
+		opts[k] = v
+	}
+	return opts
+}
+
+// OpenProvider opens the configured provider, or the default provider
+// when none is configured
+func (cfg *Config) OpenProvider() (crypto.Provider, error) {
+	return crypto.OpenProvider(crypto.ProviderType(cfg.ProviderType), cfg.ProviderConfig())
+}
+
 // Duration is a helper for parsing time.Duration from strings
 func Duration(val string) (time.Duration, error) {
 	return time.ParseDuration(val)
 }
+
+// DurationOr parses a configuration duration, returning def when it is
+// unset or invalid
+func DurationOr(val string, def time.Duration) time.Duration {
+	if val == "" {
+		return def
+	}
+	d, err := time.ParseDuration(val)
+	if err != nil {
+		return def
+	}
+	return d
+}
+
+// SplitList splits a comma-separated configuration value
+func SplitList(val string) []string {
+	var list []string
+	for _, item := range strings.Split(val, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
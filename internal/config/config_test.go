@@ -3,6 +3,7 @@ package config
 import (
 	"os"
 	"testing"
+	"time"
 )
 
 func TestDefaultConfig(t *testing.T) {
@@ -152,3 +153,127 @@ func TestFullConfigLoad(t *testing.T) {
 		t.Errorf("Expected ProviderType to be 'yubikey', got '%s'", cfg.ProviderType)
 	}
 }
+
+func TestProviderSection(t *testing.T) {
+	dir := t.TempDir()
+	file := dir + "/pica.toml"
+	testConfig := `
+log_level = "debug"
+web_port = 7070
+
+[provider]
+type = "plugin"
+socket = "/run/pica/signer.sock"
+timeout = "10s"
+`
+	if err := os.WriteFile(file, []byte(testConfig), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := DefaultConfig()
+	if err := cfg.LoadConfigFromFile(file); err != nil {
+		t.Fatalf("Failed to load config file: %v", err)
+	}
+	if cfg.ProviderType != "plugin" {
+		t.Errorf("Expected ProviderType to be 'plugin', got '%s'", cfg.ProviderType)
+	}
+	if cfg.ProviderOptions["socket"] != "/run/pica/signer.sock" || cfg.ProviderOptions["timeout"] != "10s" {
+		t.Errorf("Unexpected provider options: %v", cfg.ProviderOptions)
+	}
+	if cfg.WebPort != 7070 {
+		t.Errorf("Expected WebPort to be 7070, got %d", cfg.WebPort)
+	}
+
+	// Flags add to and override the options of the file
+	fs := cfg.RegisterFlags()
+	if err := fs.Parse([]string{"--provider-options", "timeout=1m, name=Signer"}); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.ProviderOptions["timeout"] != "1m" || cfg.ProviderOptions["name"] != "Signer" {
+		t.Errorf("Unexpected provider options after flags: %v", cfg.ProviderOptions)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Valid provider configuration rejected: %v", err)
+	}
+
+	// The configuration survives a round trip
+	for _, saved := range []string{dir + "/saved.json", dir + "/saved.toml"} {
+		if err := cfg.SaveConfig(saved); err != nil {
+			t.Fatal(err)
+		}
+		loaded := DefaultConfig()
+		if err := loaded.LoadConfigFromFile(saved); err != nil {
+			t.Fatal(err)
+		}
+		if loaded.ProviderType != "plugin" || loaded.ProviderOptions["socket"] != "/run/pica/signer.sock" || loaded.WebPort != 7070 {
+			t.Errorf("Configuration changed in a round trip through %s: %+v", saved, loaded)
+		}
+	}
+
+	// Options are checked against the provider's schema
+	cfg.ProviderOptions["pin"] = "123456"
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected an error for an option the provider does not take")
+	}
+	cfg.ProviderType = ""
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected an error for options without a provider")
+	}
+	cfg.ProviderType = "hsm"
+	delete(cfg.ProviderOptions, "pin")
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected an error for an unknown provider")
+	}
+}
+
+func TestUpdateKeyLocation(t *testing.T) {
+	dir := t.TempDir()
+	flat := dir + "/pica.json"
+	if err := os.WriteFile(flat, []byte(`{"provider": "software", "key_slot": "83", "web_port": 7070}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := UpdateKeyLocation(flat, "yubikey", map[string]string{"serial": "12345678"}, "82"); err != nil {
+		t.Fatal(err)
+	}
+	cfg := DefaultConfig()
+	if err := cfg.LoadConfigFromFile(flat); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.ProviderType != "yubikey" || cfg.ProviderOptions["serial"] != "12345678" || cfg.KeySlot != "82" || cfg.WebPort != 7070 {
+		t.Errorf("Unexpected updated config: %+v", cfg)
+	}
+	if old, err := os.ReadFile(flat + ".bak"); err != nil || len(old) == 0 {
+		t.Errorf("Expected a backup of the old config: %v", err)
+	}
+
+	// A [provider] section stays a section
+	section := dir + "/pica.toml"
+	if err := os.WriteFile(section, []byte("log_level = \"debug\"\n\n[provider]\ntype = \"software\"\ndirectory = \"/var/lib/pica\"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := UpdateKeyLocation(section, "plugin", map[string]string{"socket": "/run/hsm.sock"}, "84"); err != nil {
+		t.Fatal(err)
+	}
+	cfg = DefaultConfig()
+	if err := cfg.LoadConfigFromFile(section); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.ProviderType != "plugin" || cfg.ProviderOptions["socket"] != "/run/hsm.sock" || cfg.ProviderOptions["directory"] != "" ||
+		cfg.KeySlot != "84" || cfg.LogLevel != "debug" {
+		t.Errorf("Unexpected updated config: %+v", cfg)
+	}
+}
+
+func TestListAndDurationValues(t *testing.T) {
+	if list := SplitList(" http://a/crl, ,http://b/crl "); len(list) != 2 || list[0] != "http://a/crl" || list[1] != "http://b/crl" {
+		t.Errorf("Unexpected list: %q", list)
+	}
+	if list := SplitList(""); list != nil {
+		t.Errorf("Expected no items, got %q", list)
+	}
+	for val, want := range map[string]time.Duration{"": time.Hour, "90m": 90 * time.Minute, "soon": time.Hour} {
+		if got := DurationOr(val, time.Hour); got != want {
+			t.Errorf("DurationOr(%q) = %s, want %s", val, got, want)
+		}
+	}
+}
@@ -3,7 +3,9 @@ package config
 import (
 	"flag"
 	"reflect"
+	"sort"
 	"strconv"
+	"strings"
 )
 
 // RegisterFlags registers command-line flags based on the Config struct tags
@@ -36,6 +38,8 @@ func (cfg *Config) RegisterFlags() *flag.FlagSet {
 			case reflect.Float64:
 				current := fieldValue.Float()
 				fs.Float64Var((*float64)(fieldValue.Addr().UnsafePointer()), flagName, current, description)
+			case reflect.Map:
+				fs.Var(optionsValue{fieldValue}, flagName, description)
 			}
 		}
 	}
@@ -82,3 +86,35 @@ func (cfg *Config) LoadFlagDefaults(fs *flag.FlagSet) {
 		}
 	}
 }
+
+// optionsValue is a flag.Value adding "key=value,key=value" options to a
+// map[string]string field
+type optionsValue struct {
+	field reflect.Value
+}
+
+func (o optionsValue) String() string {
+	if !o.field.IsValid() || o.field.Len() == 0 {
+		return ""
+	}
+	m := o.field.Interface().(map[string]string)
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, len(keys))
+	for i, k := range keys {
+		pairs[i] = k + "=" + m[k]
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (o optionsValue) Set(s string) error {
+	options, err := ParseOptions(s)
+	if err != nil {
+		return err
+	}
+	mergeOptions(o.field, options)
+	return nil
+}
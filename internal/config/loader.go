@@ -2,9 +2,11 @@ package config
 
 import (
 	"encoding/json"
+	"flag"
 	"fmt"
 	"os"
 	"path/filepath"
+	"reflect"
 
 	"github.com/pelletier/go-toml"
 )
@@ -15,26 +17,25 @@ import (
 // 3. Config file
 // 4. Default values
 func Load(args []string, configFile string) (*Config, error) {
+	cfg, _, err := LoadWithFlags(args, configFile, nil)
+	return cfg, err
+}
+
+// LoadWithFlags works like Load but lets the caller register additional
+// command-specific flags on the same flag set. It returns the remaining
+// positional arguments.
+func LoadWithFlags(args []string, configFile string, register func(fs *flag.FlagSet)) (*Config, []string, error) {
 	// Start with default configuration
 	cfg := DefaultConfig()
 
 	// Load from config file if specified
 	if configFile != "" {
 		if err := cfg.LoadConfigFromFile(configFile); err != nil {
-			return nil, fmt.Errorf("error loading config file: %w", err)
+			return nil, nil, fmt.Errorf("error loading config file: %w", err)
 		}
 	} else {
 		// Check default config locations
-		configLocations := []string{
-			"./pica.json",
-			"./pica.toml",
-			"./configs/pica.json",
-			"./configs/pica.toml",
-			filepath.Join(os.Getenv("HOME"), ".pica/config.json"),
-			filepath.Join(os.Getenv("HOME"), ".pica/config.toml"),
-		}
-
-		for _, location := range configLocations {
+		for _, location := range configLocations() {
 			if _, err := os.Stat(location); err == nil {
 				if err := cfg.LoadConfigFromFile(location); err == nil {
 					break
@@ -47,21 +48,122 @@ func Load(args []string, configFile string) (*Config, error) {
 	cfg.LoadFromEnvironment()
 
 	// Parse command-line flags (overrides environment and config file)
-	if err := cfg.ParseFlags(args); err != nil {
-		return nil, fmt.Errorf("error parsing command-line flags: %w", err)
+	fs := cfg.RegisterFlags()
+	if register != nil {
+		register(fs)
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, nil, fmt.Errorf("error parsing command-line flags: %w", err)
 	}
 
 	// Ensure directories exist
 	if err := cfg.LoadDefaults(); err != nil {
-		return nil, fmt.Errorf("error loading defaults: %w", err)
+		return nil, nil, fmt.Errorf("error loading defaults: %w", err)
 	}
 
 	// Validate configuration
 	if err := cfg.Validate(); err != nil {
-		return nil, fmt.Errorf("invalid configuration: %w", err)
+		return nil, nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+
+	return cfg, fs.Args(), nil
+}
+
+// configLocations are the config files tried in order when none is given
+func configLocations() []string {
+	return []string{
+		"./pica.json",
+		"./pica.toml",
+		"./configs/pica.json",
+		"./configs/pica.toml",
+		filepath.Join(os.Getenv("HOME"), ".pica/config.json"),
+		filepath.Join(os.Getenv("HOME"), ".pica/config.toml"),
+	}
+}
+
+// FindConfigFile returns the config file Load uses when none is given, or
+// an empty string when there is none
+func FindConfigFile() string {
+	for _, location := range configLocations() {
+		if _, err := os.Stat(location); err == nil {
+			return location
+		}
+	}
+	return ""
+}
+
+// UpdateKeyLocation points a config file at a key's new provider and slot,
+// keeping the other settings. The file is rewritten in its format, without
+// comments; the previous version is kept as <filename>.bak.
+func UpdateKeyLocation(filename, providerType string, options map[string]string, slot string) error {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return fmt.Errorf("error reading config file: %w", err)
+	}
+	fi, err := os.Stat(filename)
+	if err != nil {
+		return err
+	}
+
+	var values map[string]interface{}
+	ext := filepath.Ext(filename)
+	switch ext {
+	case ".json":
+		if err := json.Unmarshal(data, &values); err != nil {
+			return fmt.Errorf("error parsing JSON config: %w", err)
+		}
+	case ".toml":
+		tree, err := toml.LoadBytes(data)
+		if err != nil {
+			return fmt.Errorf("error parsing TOML config: %w", err)
+		}
+		values = tree.ToMap()
+	default:
+		return fmt.Errorf("unsupported config file format: %s", ext)
+	}
+	if values == nil {
+		values = map[string]interface{}{}
+	}
+
+	providerOptions := map[string]interface{}{}
+	for k, v := range options {
+		providerOptions[k] = v
+	}
+	if _, ok := values["provider"].(map[string]interface{}); ok {
+		// Keep the [provider] section form
+		providerOptions["type"] = providerType
+		values["provider"] = providerOptions
+		delete(values, "provider_options")
+	} else {
+		values["provider"] = providerType
+		if len(providerOptions) > 0 {
+			values["provider_options"] = providerOptions
+		} else {
+			delete(values, "provider_options")
+		}
+	}
+	values["key_slot"] = slot
+
+	var updated []byte
+	if ext == ".json" {
+		updated, err = json.MarshalIndent(values, "", "  ")
+	} else {
+		var tree *toml.Tree
+		if tree, err = toml.TreeFromMap(values); err == nil {
+			updated, err = tree.Marshal()
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("error encoding config: %w", err)
 	}
 
-	return cfg, nil
+	if err := os.WriteFile(filename+".bak", data, fi.Mode().Perm()); err != nil {
+		return fmt.Errorf("error writing config backup: %w", err)
+	}
+	if err := os.WriteFile(filename, updated, fi.Mode().Perm()); err != nil {
+		return fmt.Errorf("error writing config file: %w", err)
+	}
+	return nil
 }
 
 // SaveConfig saves the current configuration to a file
@@ -73,17 +175,21 @@ func (cfg *Config) SaveConfig(filename string) error {
 
 	var data []byte
 	var err error
+	values := cfg.fileValues()
 
 	// Determine file type based on extension
 	ext := filepath.Ext(filename)
 	switch ext {
 	case ".json":
-		data, err = json.MarshalIndent(cfg, "", "  ")
+		data, err = json.MarshalIndent(values, "", "  ")
 		if err != nil {
 			return fmt.Errorf("error encoding JSON config: %w", err)
 		}
 	case ".toml":
-		data, err = toml.Marshal(cfg)
+		tree, err := toml.TreeFromMap(values)
+		if err == nil {
+			data, err = tree.Marshal()
+		}
 		if err != nil {
 			return fmt.Errorf("error encoding TOML config: %w", err)
 		}
@@ -97,3 +203,36 @@ func (cfg *Config) SaveConfig(filename string) error {
 
 	return nil
 }
+
+// fileValues returns the settings keyed by their `config` tags
+func (cfg *Config) fileValues() map[string]interface{} {
+	values := map[string]interface{}{}
+	t := reflect.TypeOf(*cfg)
+	v := reflect.ValueOf(cfg).Elem()
+
+	for i := 0; i < t.NumField(); i++ {
+		key := t.Field(i).Tag.Get("config")
+		if key == "" {
+			continue
+		}
+		fieldValue := v.Field(i)
+		switch fieldValue.Kind() {
+		case reflect.Map:
+			if fieldValue.Len() == 0 {
+				continue
+			}
+			section := map[string]interface{}{}
+			iter := fieldValue.MapRange()
+			for iter.Next() {
+				section[iter.Key().String()] = iter.Value().Interface()
+			}
+			values[key] = section
+		case reflect.Int:
+			// TOML encodes integers from int64
+			values[key] = fieldValue.Int()
+		default:
+			values[key] = fieldValue.Interface()
+		}
+	}
+	return values
+}
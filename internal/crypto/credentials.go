@@ -0,0 +1,234 @@
+package crypto
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CredentialKind identifies a secret a hardware provider asks for
+type CredentialKind int
+
+const (
+	// CredentialPIN unlocks private key operations
+	CredentialPIN CredentialKind = iota
+	// CredentialPUK unblocks the PIN
+	CredentialPUK
+	// CredentialManagementKey authorizes key generation and certificate
+	// import; it is hex encoded
+	CredentialManagementKey
+)
+
+// String returns the human-readable name of a credential kind
+func (k CredentialKind) String() string {
+	switch k {
+	case CredentialPIN:
+		return "PIN"
+	case CredentialPUK:
+		return "PUK"
+	case CredentialManagementKey:
+		return "management key"
+	}
+	return fmt.Sprintf("credential %d", int(k))
+}
+
+// CredentialRequest describes a secret a provider needs
+type CredentialRequest struct {
+	Kind     CredentialKind
+	Provider string
+	// Retries is the number of attempts left, -1 when unknown
+	Retries int
+	// Retry is set when the device rejected the previous value
+	Retry bool
+}
+
+// CredentialCallback supplies the secret for a request. It returns
+// ErrCredentialUnavailable when it has none, so a provider gives up
+// instead of retrying.
+type CredentialCallback func(req CredentialRequest) (string, error)
+
+// CredentialProvider is implemented by providers that ask for credentials
+// when an operation needs them
+type CredentialProvider interface {
+	SetCredentialCallback(cb CredentialCallback)
+}
+
+// PINManager is implemented by providers whose keys are protected by a PIN
+type PINManager interface {
+	// PINRetries returns the attempts left for the PIN and the PUK, -1
+	// when unknown
+	PINRetries() (pin, puk int, err error)
+	VerifyPIN(pin string) error
+	ChangePIN(oldPIN, newPIN string) error
+	ChangePUK(oldPUK, newPUK string) error
+	UnblockPIN(puk, newPIN string) error
+	// ChangeManagementKey replaces the management key with a hex encoded
+	// one of the same algorithm
+	ChangeManagementKey(newKey string) error
+}
+
+// CredentialError is returned when a device rejects a PIN or PUK
+type CredentialError struct {
+	Kind    CredentialKind
+	Retries int
+}
+
+func (e *CredentialError) Error() string {
+	if e.Retries == 0 {
+		return fmt.Sprintf("%s blocked", e.Kind)
+	}
+	return fmt.Sprintf("wrong %s, %d retries left", e.Kind, e.Retries)
+}
+
+// SetCredentials installs a credential callback on providers that take one
+// and reports whether the provider did
+func SetCredentials(p Provider, cb CredentialCallback) bool {
+	cp, ok := p.(CredentialProvider)
+	if ok {
+		cp.SetCredentialCallback(cb)
+	}
+	return ok
+}
+
+// credentialEnv names the environment variables of each credential kind
+var credentialEnv = map[CredentialKind]string{
+	CredentialPIN:           "PICA_YUBIKEY_PIN",
+	CredentialPUK:           "PICA_YUBIKEY_PUK",
+	CredentialManagementKey: "PICA_YUBIKEY_MANAGEMENT_KEY",
+}
+
+// EnvCredentials supplies credentials for headless use from
+// PICA_YUBIKEY_PIN, PICA_YUBIKEY_PUK and PICA_YUBIKEY_MANAGEMENT_KEY, or
+// from the files named by the same variables with a _FILE suffix. A
+// rejected value is never offered twice, so a wrong setting cannot use up
+// the retries.
+func EnvCredentials() CredentialCallback {
+	return func(req CredentialRequest) (string, error) {
+		if req.Retry {
+			return "", ErrCredentialUnavailable
+		}
+		name, ok := credentialEnv[req.Kind]
+		if !ok {
+			return "", ErrCredentialUnavailable
+		}
+		if v := os.Getenv(name); v != "" {
+			return v, nil
+		}
+		if file := os.Getenv(name + "_FILE"); file != "" {
+			data, err := os.ReadFile(file)
+			if err != nil {
+				return "", fmt.Errorf("failed to read %s: %w", req.Kind, err)
+			}
+			return strings.TrimSpace(string(data)), nil
+		}
+		return "", ErrCredentialUnavailable
+	}
+}
+
+// StaticCredentials supplies fixed values, such as a PIN entered in a form,
+// once per operation
+func StaticCredentials(values map[CredentialKind]string) CredentialCallback {
+	return func(req CredentialRequest) (string, error) {
+		if v := values[req.Kind]; v != "" && !req.Retry {
+			return v, nil
+		}
+		return "", ErrCredentialUnavailable
+	}
+}
+
+// ChainCredentials asks each callback in turn until one supplies the
+// credential
+func ChainCredentials(callbacks ...CredentialCallback) CredentialCallback {
+	return func(req CredentialRequest) (string, error) {
+		for _, cb := range callbacks {
+			v, err := cb(req)
+			if errors.Is(err, ErrCredentialUnavailable) {
+				continue
+			}
+			return v, err
+		}
+		return "", ErrCredentialUnavailable
+	}
+}
+
+// CredentialCache holds credentials entered once, such as when unlocking
+// pica-web, until they expire. A zero timeout keeps them until Lock.
+type CredentialCache struct {
+	Timeout time.Duration
+
+	mutex  sync.Mutex
+	values map[CredentialKind]cachedCredential
+}
+
+// cachedCredential is a cached secret and its expiry
+type cachedCredential struct {
+	value   string
+	expires time.Time
+}
+
+// NewCredentialCache creates an empty credential cache
+func NewCredentialCache(timeout time.Duration) *CredentialCache {
+	return &CredentialCache{
+		Timeout: timeout,
+		values:  make(map[CredentialKind]cachedCredential),
+	}
+}
+
+// Unlock caches a credential
+func (c *CredentialCache) Unlock(kind CredentialKind, value string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	var expires time.Time
+	if c.Timeout > 0 {
+		expires = time.Now().Add(c.Timeout)
+	}
+	c.values[kind] = cachedCredential{value: value, expires: expires}
+}
+
+// Lock forgets every cached credential
+func (c *CredentialCache) Lock() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.values = make(map[CredentialKind]cachedCredential)
+}
+
+// Unlocked reports whether a credential is cached and when it expires; the
+// expiry is zero when it does not
+func (c *CredentialCache) Unlocked(kind CredentialKind) (bool, time.Time) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	cached, ok := c.lookup(kind)
+	return ok, cached.expires
+}
+
+// lookup returns an unexpired credential, dropping an expired one
+func (c *CredentialCache) lookup(kind CredentialKind) (cachedCredential, bool) {
+	cached, ok := c.values[kind]
+	if ok && !cached.expires.IsZero() && time.Now().After(cached.expires) {
+		delete(c.values, kind)
+		return cachedCredential{}, false
+	}
+	return cached, ok
+}
+
+// Callback supplies cached credentials. A rejected credential is dropped
+// from the cache.
+func (c *CredentialCache) Callback(req CredentialRequest) (string, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if req.Retry {
+		delete(c.values, req.Kind)
+		return "", ErrCredentialUnavailable
+	}
+	cached, ok := c.lookup(req.Kind)
+	if !ok {
+		return "", fmt.Errorf("%w: %s not unlocked", ErrCredentialUnavailable, req.Kind)
+	}
+	return cached.value, nil
+}
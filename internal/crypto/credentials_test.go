@@ -0,0 +1,162 @@
+package crypto
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/billchurch/PiCA/internal/yubikey"
+)
+
+// newVirtualYubiKey returns a connected YubiKey provider on a virtual card
+func newVirtualYubiKey(t *testing.T) *YubiKeyProvider {
+	t.Helper()
+	t.Setenv("PICA_YUBIKEY_PIN", "")
+	t.Setenv("PICA_YUBIKEY_PIN_FILE", "")
+	p, err := NewYubiKeyProvider(map[string]interface{}{"reader": yubikey.VirtualReader})
+	if err != nil {
+		t.Fatalf("Failed to create provider: %v", err)
+	}
+	if err := p.Connect(); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	t.Cleanup(func() { p.Close() })
+	return p.(*YubiKeyProvider)
+}
+
+func TestYubiKeyProviderCredentials(t *testing.T) {
+	p := newVirtualYubiKey(t)
+	if err := p.GenerateKey(SlotCA1, "ECDSA", 256); err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	pub, err := p.GetPublicKey(SlotCA1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest := sha256.Sum256([]byte("PiCA"))
+
+	// Without a PIN the provider gives up
+	if _, err := p.Sign(SlotCA1, digest[:], crypto.SHA256); !errors.Is(err, ErrCredentialUnavailable) {
+		t.Errorf("Expected ErrCredentialUnavailable, got %v", err)
+	}
+
+	// A wrong PIN is followed by a retry with the retries left
+	var requests []CredentialRequest
+	p.SetCredentialCallback(func(req CredentialRequest) (string, error) {
+		requests = append(requests, req)
+		if req.Retry {
+			return yubikey.DefaultPIN, nil
+		}
+		return "000000", nil
+	})
+	sig, err := p.Sign(SlotCA1, digest[:], crypto.SHA256)
+	if err != nil {
+		t.Fatalf("Failed to sign: %v", err)
+	}
+	if !ecdsa.VerifyASN1(pub.(*ecdsa.PublicKey), digest[:], sig) {
+		t.Errorf("Signature does not verify")
+	}
+	if len(requests) != 2 || requests[0].Kind != CredentialPIN || requests[0].Retry ||
+		!requests[1].Retry || requests[1].Retries != 2 {
+		t.Errorf("Unexpected credential requests: %+v", requests)
+	}
+	if pin, puk, err := p.PINRetries(); err != nil || pin != 3 || puk != 3 {
+		t.Errorf("Expected the retries to be reset, got %d, %d, %v", pin, puk, err)
+	}
+
+	// A static value that the card rejects is not offered twice
+	p.SetCredentialCallback(StaticCredentials(map[CredentialKind]string{CredentialPIN: "000000"}))
+	var credErr *CredentialError
+	if _, err := p.Sign(SlotCA1, digest[:], crypto.SHA256); !errors.As(err, &credErr) || credErr.Retries != 2 {
+		t.Errorf("Expected a CredentialError with 2 retries, got %v", err)
+	}
+}
+
+func TestYubiKeyProviderManagementKey(t *testing.T) {
+	p := newVirtualYubiKey(t)
+	newKey := "0102030405060708010203040506070801020304050607ff"
+	if err := p.ChangeManagementKey(newKey); err != nil {
+		t.Fatalf("Failed to change management key: %v", err)
+	}
+
+	// A provider that does not know the new key asks for it
+	p.managementKey = nil
+	asked := 0
+	p.SetCredentialCallback(func(req CredentialRequest) (string, error) {
+		if req.Kind != CredentialManagementKey {
+			return "", ErrCredentialUnavailable
+		}
+		asked++
+		return newKey, nil
+	})
+	if err := p.GenerateKey(SlotCA2, "ECDSA", 384); err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	if asked != 1 {
+		t.Errorf("Expected one management key request, got %d", asked)
+	}
+}
+
+func TestEnvCredentials(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "puk")
+	if err := os.WriteFile(file, []byte("12345678\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PICA_YUBIKEY_PIN", "654321")
+	t.Setenv("PICA_YUBIKEY_PUK", "")
+	t.Setenv("PICA_YUBIKEY_PUK_FILE", file)
+	t.Setenv("PICA_YUBIKEY_MANAGEMENT_KEY", "")
+	t.Setenv("PICA_YUBIKEY_MANAGEMENT_KEY_FILE", "")
+
+	cb := EnvCredentials()
+	if v, err := cb(CredentialRequest{Kind: CredentialPIN}); err != nil || v != "654321" {
+		t.Errorf("Expected the PIN from the environment, got %q, %v", v, err)
+	}
+	if v, err := cb(CredentialRequest{Kind: CredentialPUK}); err != nil || v != "12345678" {
+		t.Errorf("Expected the PUK from the file, got %q, %v", v, err)
+	}
+	if _, err := cb(CredentialRequest{Kind: CredentialPIN, Retry: true}); !errors.Is(err, ErrCredentialUnavailable) {
+		t.Errorf("Expected a rejected PIN not to be offered again, got %v", err)
+	}
+	if _, err := cb(CredentialRequest{Kind: CredentialManagementKey}); !errors.Is(err, ErrCredentialUnavailable) {
+		t.Errorf("Expected ErrCredentialUnavailable, got %v", err)
+	}
+}
+
+func TestCredentialCache(t *testing.T) {
+	c := NewCredentialCache(50 * time.Millisecond)
+	if _, err := c.Callback(CredentialRequest{Kind: CredentialPIN}); !errors.Is(err, ErrCredentialUnavailable) {
+		t.Errorf("Expected a locked cache, got %v", err)
+	}
+
+	c.Unlock(CredentialPIN, "123456")
+	if v, err := c.Callback(CredentialRequest{Kind: CredentialPIN}); err != nil || v != "123456" {
+		t.Errorf("Expected the cached PIN, got %q, %v", v, err)
+	}
+	if ok, expires := c.Unlocked(CredentialPIN); !ok || expires.IsZero() {
+		t.Errorf("Expected the PIN to be cached with an expiry")
+	}
+
+	// A rejected PIN is dropped
+	c.Callback(CredentialRequest{Kind: CredentialPIN, Retry: true})
+	if ok, _ := c.Unlocked(CredentialPIN); ok {
+		t.Errorf("Expected a rejected PIN to be dropped")
+	}
+
+	c.Unlock(CredentialPIN, "123456")
+	time.Sleep(60 * time.Millisecond)
+	if ok, _ := c.Unlocked(CredentialPIN); ok {
+		t.Errorf("Expected the PIN to expire")
+	}
+
+	c.Unlock(CredentialPIN, "123456")
+	c.Lock()
+	if ok, _ := c.Unlocked(CredentialPIN); ok {
+		t.Errorf("Expected Lock to forget the PIN")
+	}
+}
@@ -55,22 +55,13 @@ func IsYubiKeyPresent() bool {
 
 // GetPreferredProviderType returns the preferred provider type based on:
 // 1. Environment variable PICA_PROVIDER if set
-// 2. Presence of YubiKey if env var is not set
+// 2. The first registered provider that detects its device
 func GetPreferredProviderType() ProviderType {
 	// Check environment variable first
-	providerEnv := os.Getenv("PICA_PROVIDER")
-	switch providerEnv {
-	case "software":
-		return SoftwareProviderType
-	case "yubikey":
-		return YubiKeyProviderType
-	default:
-		// Auto-detect based on YubiKey presence
-		if IsYubiKeyPresent() {
-			return YubiKeyProviderType
-		}
-		return SoftwareProviderType
+	if providerEnv := os.Getenv("PICA_PROVIDER"); providerEnv != "" {
+		return ProviderType(providerEnv)
 	}
+	return DetectProviderType()
 }
 
 // GetProviderNameByType returns a human-readable name for a provider type
@@ -80,7 +71,9 @@ func GetProviderNameByType(providerType ProviderType) string {
 		return "Software Provider"
 	case YubiKeyProviderType:
 		return "YubiKey Provider"
-	default:
-		return "Unknown Provider"
 	}
+	if _, err := LookupProvider(providerType); err == nil {
+		return string(providerType) + " provider"
+	}
+	return "Unknown Provider"
 }
@@ -18,6 +18,10 @@ var (
 	// ErrKeyNotFound is returned when a key is not found in the requested slot
 	ErrKeyNotFound = errors.New("key not found in slot")
 	
+	// ErrSlotInUse is returned when a key would be generated over one a
+	// slot already holds
+	ErrSlotInUse = errors.New("slot already holds a key")
+	
 	// ErrCertNotFound is returned when a certificate is not found in the requested slot
 	ErrCertNotFound = errors.New("certificate not found in slot")
 	
@@ -32,4 +36,12 @@ var (
 	
 	// ErrInvalidAlgorithm is returned when an invalid algorithm is specified
 	ErrInvalidAlgorithm = errors.New("invalid algorithm")
+	
+	// ErrNotPermitted is returned when a remote signer's policy refuses an
+	// operation
+	ErrNotPermitted = errors.New("operation not permitted by the signer policy")
+	
+	// ErrCredentialUnavailable is returned when no PIN or other credential
+	// was supplied for an operation that needs one
+	ErrCredentialUnavailable = errors.New("credential not available")
 )
@@ -2,6 +2,7 @@ package crypto
 
 import (
 	"fmt"
+	"os"
 )
 
 // DefaultProviderType determines the default provider type to use
@@ -12,45 +13,9 @@ func DefaultProviderType() ProviderType {
 // CreateDefaultProvider creates a provider of the default type
 func CreateDefaultProvider() (Provider, error) {
 	providerType := DefaultProviderType()
-	
-	// Default options for each provider type
-	var opts map[string]interface{}
-	
-	switch providerType {
-	case SoftwareProviderType:
-		// Use default options for software provider
-		opts = map[string]interface{}{
-			"name": "Default Software Provider",
-		}
-	case YubiKeyProviderType:
-		// Use default options for YubiKey provider
-		opts = map[string]interface{}{
-			"name": "Default YubiKey Provider",
-		}
-	default:
-		return nil, fmt.Errorf("unsupported default provider type: %v", providerType)
-	}
-	
-	provider, err := NewProvider(providerType, opts)
-	if err != nil {
-		return nil, err
-	}
-	
-	// Connect to the provider
-	if err := provider.Connect(); err != nil {
-		// If the preferred provider fails to connect (e.g., YubiKey not available),
-		// try falling back to the software provider
-		if providerType == YubiKeyProviderType {
-			fmt.Println("Warning: Failed to connect to YubiKey provider, falling back to software provider")
-			return CreateProviderFromConfig(map[string]interface{}{
-				"type": "software",
-				"name": "Fallback Software Provider",
-			})
-		}
-		return nil, fmt.Errorf("failed to connect to provider: %w", err)
-	}
-	
-	return provider, nil
+	return OpenProvider(providerType, map[string]interface{}{
+		"name": "Default " + GetProviderNameByType(providerType),
+	})
 }
 
 // CreateProviderFromConfig creates a provider based on a configuration
@@ -61,36 +26,48 @@ func CreateProviderFromConfig(config map[string]interface{}) (Provider, error) {
 		// Use default provider if not specified
 		return CreateDefaultProvider()
 	}
-	
-	var providerType ProviderType
-	switch providerTypeStr {
-	case "software":
-		providerType = SoftwareProviderType
-	case "yubikey":
-		providerType = YubiKeyProviderType
-	default:
-		return nil, fmt.Errorf("unsupported provider type: %s", providerTypeStr)
+	return OpenProvider(ProviderType(providerTypeStr), config)
+}
+
+// OpenProvider creates and connects a provider of the given type. An empty
+// type selects the default provider. When a provider that detects a device
+// fails to connect, the software provider is used instead unless the
+// "fallback" option is false; other providers fall back only when it is
+// true.
+func OpenProvider(providerType ProviderType, opts map[string]interface{}) (Provider, error) {
+	if providerType == "" {
+		providerType = DefaultProviderType()
 	}
-	
-	// Create provider with config options
-	provider, err := NewProvider(providerType, config)
+
+	reg, err := LookupProvider(providerType)
 	if err != nil {
 		return nil, err
 	}
-	
+	valid, err := reg.ValidateOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	provider, err := reg.Factory(valid)
+	if err != nil {
+		return nil, err
+	}
+
 	// Connect to the provider
 	if err := provider.Connect(); err != nil {
-		// If this is a YubiKey provider and it failed to connect,
-		// we could try falling back to software provider
-		if providerType == YubiKeyProviderType && config["fallback"] != "false" {
-			fmt.Println("Warning: Failed to connect to YubiKey provider, falling back to software provider")
-			return CreateProviderFromConfig(map[string]interface{}{
-				"type": "software",
+		fallback, ok := valid["fallback"].(bool)
+		if !ok {
+			fallback = reg.Detect != nil
+		}
+		if fallback && providerType != SoftwareProviderType {
+			fmt.Fprintf(os.Stderr, "Warning: Failed to connect to %s, falling back to software provider\n",
+				GetProviderNameByType(providerType))
+			return OpenProvider(SoftwareProviderType, map[string]interface{}{
 				"name": "Fallback Software Provider",
 			})
 		}
 		return nil, fmt.Errorf("failed to connect to provider: %w", err)
 	}
-	
+
 	return provider, nil
 }
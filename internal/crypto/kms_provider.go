@@ -0,0 +1,396 @@
+package crypto
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// KMSProviderType is the provider type of keys held by a key management
+// service
+const KMSProviderType ProviderType = "kms"
+
+// defaultKMSKeyPrefix names the KMS key of a slot that is not mapped
+// explicitly: slot 82 uses the key "pica-slot-82"
+const defaultKMSKeyPrefix = "pica-slot-"
+
+// KMSKey describes a key held by a key management service
+type KMSKey struct {
+	Name      string
+	PublicKey crypto.PublicKey
+	// Created is when the current version of the key was created
+	Created time.Time
+}
+
+// KMSBackend is the API of a key management service with asymmetric keys.
+// Keys never leave the service; it signs digests on request.
+type KMSBackend interface {
+	// CreateKey creates a key, e.g. "ECDSA" 384
+	CreateKey(name, algorithm string, bits int) error
+	// GetKey returns the current version of a key, or ErrKeyNotFound
+	GetKey(name string) (*KMSKey, error)
+	// Sign signs a digest with the current version of a key
+	Sign(name string, digest []byte, opts crypto.SignerOpts) ([]byte, error)
+	// DeleteKey destroys a key and all its versions
+	DeleteKey(name string) error
+	// ListKeys returns the names of all keys
+	ListKeys() ([]string, error)
+}
+
+// KMSProvider implements the Provider interface with keys held by a key
+// management service. Slots map to key names; certificates, which the
+// service does not store, are kept in a local directory.
+type KMSProvider struct {
+	name      string
+	backend   KMSBackend
+	keys      map[Slot]string
+	prefix    string
+	certDir   string
+	connected bool
+	mutex     sync.RWMutex
+}
+
+// NewKMSProvider creates a provider for a key management service. Options:
+// "backend" selects the service ("vault", the default), "keys" maps slots to
+// key names ("82:pica-root 83:pica-sub"), "key_prefix" names the keys of
+// other slots and "directory" holds the certificates. The backend takes
+// its own options.
+func NewKMSProvider(opts map[string]interface{}) (Provider, error) {
+	var backend KMSBackend
+	var err error
+	switch b, _ := opts["backend"].(string); b {
+	case "", "vault":
+		backend, err = NewVaultTransit(opts)
+	default:
+		return nil, fmt.Errorf("unknown KMS backend %q", b)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return NewKMSProviderWithBackend(backend, opts)
+}
+
+// NewKMSProviderWithBackend creates a KMS provider for a backend
+func NewKMSProviderWithBackend(backend KMSBackend, opts map[string]interface{}) (*KMSProvider, error) {
+	p := &KMSProvider{
+		name:    "KMS Provider",
+		backend: backend,
+		keys:    make(map[Slot]string),
+		prefix:  defaultKMSKeyPrefix,
+	}
+	if n, ok := opts["name"].(string); ok && n != "" {
+		p.name = n
+	}
+	if prefix, ok := opts["key_prefix"].(string); ok && prefix != "" {
+		p.prefix = prefix
+	}
+	if mapping, ok := opts["keys"].(string); ok {
+		keys, err := parseKMSKeys(mapping)
+		if err != nil {
+			return nil, err
+		}
+		p.keys = keys
+	}
+
+	if dir, ok := opts["directory"].(string); ok && dir != "" {
+		p.certDir = filepath.Join(dir, "certs")
+	} else {
+		homeDir, err := os.UserHomeDir()
+		if err != nil {
+			homeDir = "."
+		}
+		p.certDir = filepath.Join(homeDir, ".pica", "kms", "certs")
+	}
+	return p, nil
+}
+
+// parseKMSKeys parses a slot to key name mapping such as
+// "82:pica-root 83:pica-sub"; entries may also be separated by semicolons
+func parseKMSKeys(mapping string) (map[Slot]string, error) {
+	keys := make(map[Slot]string)
+	entries := strings.FieldsFunc(mapping, func(r rune) bool { return r == ';' || r == ' ' || r == '\n' || r == '\t' })
+	for _, entry := range entries {
+		slotStr, name, ok := strings.Cut(entry, ":")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid KMS key mapping %q, expected slot:name", entry)
+		}
+		slot, err := strconv.ParseInt(slotStr, 16, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid slot %q in KMS key mapping (must be hex)", slotStr)
+		}
+		keys[Slot(slot)] = name
+	}
+	return keys, nil
+}
+
+// KeyName returns the name of the KMS key of a slot
+func (p *KMSProvider) KeyName(slot Slot) string {
+	if name, ok := p.keys[slot]; ok {
+		return name
+	}
+	return fmt.Sprintf("%s%X", p.prefix, int(slot))
+}
+
+// slotOfKey returns the slot a KMS key belongs to
+func (p *KMSProvider) slotOfKey(name string) (Slot, bool) {
+	for slot, n := range p.keys {
+		if n == name {
+			return slot, true
+		}
+	}
+	hexSlot, ok := strings.CutPrefix(name, p.prefix)
+	if !ok {
+		return 0, false
+	}
+	slot, err := strconv.ParseInt(hexSlot, 16, 64)
+	if err != nil {
+		return 0, false
+	}
+	// A mapped slot does not also use its prefixed name
+	if _, mapped := p.keys[Slot(slot)]; mapped {
+		return 0, false
+	}
+	return Slot(slot), true
+}
+
+// Type returns the type of the provider
+func (p *KMSProvider) Type() ProviderType {
+	return KMSProviderType
+}
+
+// Name returns a human-readable name for the provider
+func (p *KMSProvider) Name() string {
+	return p.name
+}
+
+// Connect checks that the service can be reached
+func (p *KMSProvider) Connect() error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if err := os.MkdirAll(p.certDir, 0700); err != nil {
+		return fmt.Errorf("failed to create certificate directory: %w", err)
+	}
+	if _, err := p.backend.ListKeys(); err != nil {
+		return fmt.Errorf("failed to reach the KMS: %w", err)
+	}
+	p.connected = true
+	return nil
+}
+
+// Close terminates the connection to the provider
+func (p *KMSProvider) Close() error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.connected = false
+	return nil
+}
+
+// GenerateKey creates the slot's key in the service. A KMS key cannot be
+// replaced, so a slot that already has one returns ErrSlotInUse.
+func (p *KMSProvider) GenerateKey(slot Slot, algorithm string, bits int) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if !p.connected {
+		return ErrNotConnected
+	}
+	name := p.KeyName(slot)
+	if _, err := p.backend.GetKey(name); err == nil {
+		return fmt.Errorf("%w: KMS key %s", ErrSlotInUse, name)
+	} else if !errors.Is(err, ErrKeyNotFound) {
+		return err
+	}
+	return p.backend.CreateKey(name, algorithm, bits)
+}
+
+// GetPublicKey retrieves the public key from a slot
+func (p *KMSProvider) GetPublicKey(slot Slot) (crypto.PublicKey, error) {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	if !p.connected {
+		return nil, ErrNotConnected
+	}
+	key, err := p.backend.GetKey(p.KeyName(slot))
+	if err != nil {
+		return nil, err
+	}
+	return key.PublicKey, nil
+}
+
+// Sign signs a digest with the slot's key in the service
+func (p *KMSProvider) Sign(slot Slot, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	if !p.connected {
+		return nil, ErrNotConnected
+	}
+	return p.backend.Sign(p.KeyName(slot), digest, opts)
+}
+
+// ImportCertificate stores the certificate of a slot locally
+func (p *KMSProvider) ImportCertificate(slot Slot, cert *x509.Certificate) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if !p.connected {
+		return ErrNotConnected
+	}
+	if cert == nil {
+		return ErrInvalidCertificate
+	}
+	data := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+	if err := os.WriteFile(p.certFile(slot), data, 0644); err != nil {
+		return fmt.Errorf("failed to save certificate: %w", err)
+	}
+	return nil
+}
+
+// GetCertificate retrieves the certificate of a slot
+func (p *KMSProvider) GetCertificate(slot Slot) (*x509.Certificate, error) {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	if !p.connected {
+		return nil, ErrNotConnected
+	}
+	return p.readCertificate(slot)
+}
+
+func (p *KMSProvider) readCertificate(slot Slot) (*x509.Certificate, error) {
+	data, err := os.ReadFile(p.certFile(slot))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrCertNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%w: no PEM data in %s", ErrInvalidCertificate, p.certFile(slot))
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+// certFile returns the certificate file of a slot, named after its key
+func (p *KMSProvider) certFile(slot Slot) string {
+	return filepath.Join(p.certDir, p.KeyName(slot)+".pem")
+}
+
+// ListSlots reports the slots whose keys exist in the service or whose
+// certificates are stored locally
+func (p *KMSProvider) ListSlots() ([]SlotInfo, error) {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	if !p.connected {
+		return nil, ErrNotConnected
+	}
+	names, err := p.backend.ListKeys()
+	if err != nil {
+		return nil, err
+	}
+
+	infos := make(map[Slot]*SlotInfo)
+	for _, name := range names {
+		slot, ok := p.slotOfKey(name)
+		if !ok {
+			continue
+		}
+		key, err := p.backend.GetKey(name)
+		if err != nil {
+			return nil, err
+		}
+		info := &SlotInfo{Slot: slot, HasKey: true, Created: key.Created, Origin: "generated"}
+		info.Algorithm, info.Bits = keyParameters(key.PublicKey)
+		infos[slot] = info
+	}
+
+	entries, err := os.ReadDir(p.certDir)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	for _, e := range entries {
+		slot, ok := p.slotOfKey(strings.TrimSuffix(e.Name(), ".pem"))
+		if !ok || !strings.HasSuffix(e.Name(), ".pem") {
+			continue
+		}
+		cert, err := p.readCertificate(slot)
+		if err != nil {
+			continue
+		}
+		info, ok := infos[slot]
+		if !ok {
+			info = &SlotInfo{Slot: slot}
+			infos[slot] = info
+		}
+		info.Certificate = cert
+	}
+
+	slots := make([]SlotInfo, 0, len(infos))
+	for _, info := range infos {
+		slots = append(slots, *info)
+	}
+	sort.Slice(slots, func(i, j int) bool { return slots[i].Slot < slots[j].Slot })
+	return slots, nil
+}
+
+// DeleteKey destroys the slot's key in the service and removes its
+// certificate
+func (p *KMSProvider) DeleteKey(slot Slot) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if !p.connected {
+		return ErrNotConnected
+	}
+	keyErr := p.backend.DeleteKey(p.KeyName(slot))
+	if keyErr != nil && !errors.Is(keyErr, ErrKeyNotFound) {
+		return keyErr
+	}
+	certErr := os.Remove(p.certFile(slot))
+	if certErr != nil && !errors.Is(certErr, os.ErrNotExist) {
+		return certErr
+	}
+	if keyErr != nil && certErr != nil {
+		return ErrKeyNotFound
+	}
+	return nil
+}
+
+// IsHardware returns false: whether the service keeps its keys in an HSM
+// cannot be told from here
+func (p *KMSProvider) IsHardware() bool {
+	return false
+}
+
+// Register the provider
+func init() {
+	Register(Registration{
+		Type:        KMSProviderType,
+		Description: "Keys held by a key management service such as the Vault Transit engine",
+		Options: []Option{
+			{Name: "backend", Type: OptionString, Description: "Key management service: vault (default)"},
+			{Name: "keys", Type: OptionString, Description: "Slot to key name mapping, e.g. \"82:pica-root 83:pica-sub\""},
+			{Name: "key_prefix", Type: OptionString, Description: "Prefix of the key names of unmapped slots (default pica-slot-)"},
+			{Name: "directory", Type: OptionString, Description: "Directory holding the certificates (default ~/.pica/kms)"},
+			{Name: "address", Type: OptionString, Description: "Vault address (default VAULT_ADDR)"},
+			{Name: "token", Type: OptionString, Description: "Vault token (default VAULT_TOKEN)", Secret: true},
+			{Name: "namespace", Type: OptionString, Description: "Vault namespace"},
+			{Name: "mount", Type: OptionString, Description: "Mount path of the Transit engine (default transit)"},
+			{Name: "ca", Type: OptionString, Description: "PEM file of the CA that issued Vault's certificate"},
+			{Name: "timeout", Type: OptionDuration, Description: "Time limit of a single request (default 30s)"},
+		},
+		Factory: NewKMSProvider,
+	})
+}
@@ -0,0 +1,190 @@
+package crypto
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// openKMS opens a KMS provider against an in-process Transit emulator
+func openKMS(t *testing.T, opts map[string]interface{}) (Provider, *VaultTransitEmulator) {
+	t.Helper()
+	emulator := NewVaultTransitEmulator("test-token")
+	server := httptest.NewServer(emulator)
+	t.Cleanup(server.Close)
+
+	all := map[string]interface{}{
+		"address":   server.URL,
+		"token":     "test-token",
+		"directory": t.TempDir(),
+		"timeout":   "10s",
+	}
+	for k, v := range opts {
+		all[k] = v
+	}
+	p, err := OpenProvider(KMSProviderType, all)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { p.Close() })
+	return p, emulator
+}
+
+func TestKMSProvider(t *testing.T) {
+	p, emulator := openKMS(t, map[string]interface{}{"keys": "82:pica-root"})
+
+	if p.Type() != KMSProviderType || p.IsHardware() {
+		t.Errorf("Unexpected KMS provider identity: %s, hardware %v", p.Type(), p.IsHardware())
+	}
+	if _, err := p.GetPublicKey(SlotCA1); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("Expected ErrKeyNotFound for a missing key, got %v", err)
+	}
+
+	// ECDSA under the mapped name
+	if err := p.GenerateKey(SlotCA1, "ECDSA", 384); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := emulator.keys["pica-root"]; !ok {
+		t.Error("Expected slot 82 to use the mapped key name")
+	}
+	if err := p.GenerateKey(SlotCA1, "ECDSA", 384); !errors.Is(err, ErrSlotInUse) {
+		t.Errorf("Expected ErrSlotInUse regenerating a KMS key, got %v", err)
+	}
+	pub, err := p.GetPublicKey(SlotCA1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest := sha256.Sum256([]byte("kms"))
+	sig, err := p.Sign(SlotCA1, digest[:], crypto.SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ecdsa.VerifyASN1(pub.(*ecdsa.PublicKey), digest[:], sig) {
+		t.Error("ECDSA signature from the KMS does not verify")
+	}
+
+	// RSA under the prefixed name, with both paddings
+	if err := p.GenerateKey(SlotCA2, "RSA", 2048); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := emulator.keys["pica-slot-83"]; !ok {
+		t.Error("Expected slot 83 to use the prefixed key name")
+	}
+	rsaPub, err := p.GetPublicKey(SlotCA2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, err = p.Sign(SlotCA2, digest[:], crypto.SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rsa.VerifyPKCS1v15(rsaPub.(*rsa.PublicKey), crypto.SHA256, digest[:], sig); err != nil {
+		t.Errorf("PKCS#1 v1.5 signature from the KMS does not verify: %v", err)
+	}
+	pss := &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: crypto.SHA256}
+	sig, err = p.Sign(SlotCA2, digest[:], pss)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rsa.VerifyPSS(rsaPub.(*rsa.PublicKey), crypto.SHA256, digest[:], sig, pss); err != nil {
+		t.Errorf("PSS signature from the KMS does not verify: %v", err)
+	}
+
+	if err := p.GenerateKey(SlotSSH, "Ed25519", 256); !errors.Is(err, ErrInvalidAlgorithm) {
+		t.Errorf("Expected ErrInvalidAlgorithm for Ed25519, got %v", err)
+	}
+
+	// Certificates and inventory
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "KMS CA"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, pub, &ProviderSigner{Provider: p, Slot: SlotCA1, PublicKey: pub})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	if err := cert.CheckSignatureFrom(cert); err != nil {
+		t.Errorf("Certificate signed by the KMS does not verify: %v", err)
+	}
+	if err := p.ImportCertificate(SlotCA1, cert); err != nil {
+		t.Fatal(err)
+	}
+	got, err := p.GetCertificate(SlotCA1)
+	if err != nil || got.Subject.CommonName != "KMS CA" {
+		t.Fatalf("Unexpected certificate: %v, %v", got, err)
+	}
+
+	// Keys outside the slot naming are not reported
+	emulator.keys["unrelated"] = emulator.keys["pica-root"]
+	slots, err := p.ListSlots()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(slots) != 2 || slots[0].Slot != SlotCA1 || slots[0].Bits != 384 || slots[0].Certificate == nil ||
+		slots[1].Slot != SlotCA2 || slots[1].Algorithm != "RSA" {
+		t.Errorf("Unexpected inventory: %+v", slots)
+	}
+
+	// Signatures use the latest version after a rotation
+	if err := emulator.RotateKey("pica-slot-83"); err != nil {
+		t.Fatal(err)
+	}
+	rotated, err := p.GetPublicKey(SlotCA2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rotated.(*rsa.PublicKey).Equal(rsaPub) {
+		t.Error("Expected a new public key after rotation")
+	}
+
+	if err := p.DeleteKey(SlotCA2); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.DeleteKey(SlotCA2); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("Expected ErrKeyNotFound deleting an empty slot, got %v", err)
+	}
+	if err := p.DeleteKey(SlotCA1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.GetCertificate(SlotCA1); !errors.Is(err, ErrCertNotFound) {
+		t.Errorf("Expected the certificate to be deleted with the key, got %v", err)
+	}
+}
+
+func TestKMSProviderErrors(t *testing.T) {
+	emulator := NewVaultTransitEmulator("test-token")
+	server := httptest.NewServer(emulator)
+	defer server.Close()
+
+	if _, err := OpenProvider(KMSProviderType, map[string]interface{}{
+		"address":   server.URL,
+		"token":     "wrong",
+		"directory": t.TempDir(),
+	}); err == nil {
+		t.Error("Expected an error with a wrong Vault token")
+	}
+	if _, err := OpenProvider(KMSProviderType, map[string]interface{}{
+		"address":   server.URL,
+		"backend":   "unknown",
+		"directory": t.TempDir(),
+	}); err == nil {
+		t.Error("Expected an error for an unknown KMS backend")
+	}
+	if _, err := NewKMSProviderWithBackend(nil, map[string]interface{}{"keys": "zz:root"}); err == nil {
+		t.Error("Expected an error for an invalid key mapping")
+	}
+}
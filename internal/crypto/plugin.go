@@ -0,0 +1,345 @@
+package crypto
+
+import (
+	"bufio"
+	"crypto"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// PluginProtocolVersion is the version of the plugin protocol
+const PluginProtocolVersion = 1
+
+// maxPluginMessage limits the size of a protocol message
+const maxPluginMessage = 1 << 20
+
+// Plugin protocol methods
+const (
+	PluginHello             = "hello"
+	PluginGenerateKey       = "generate_key"
+	PluginPublicKey         = "public_key"
+	PluginSign              = "sign"
+	PluginImportCertificate = "import_certificate"
+	PluginCertificate       = "certificate"
+	PluginListSlots         = "list_slots"
+	PluginDeleteKey         = "delete_key"
+	PluginImportKey         = "import_key"
+)
+
+// PluginRequest is a request of the plugin protocol. Requests and
+// responses are JSON objects, one per line; byte fields are base64.
+type PluginRequest struct {
+	ID     uint64 `json:"id"`
+	Method string `json:"method"`
+	Slot   Slot   `json:"slot,omitempty"`
+
+	// generate_key
+	Algorithm string `json:"algorithm,omitempty"`
+	Bits      int    `json:"bits,omitempty"`
+
+	// sign: Hash names the digest algorithm, e.g. "SHA-256"
+	Digest     []byte `json:"digest,omitempty"`
+	Hash       string `json:"hash,omitempty"`
+	PSS        bool   `json:"pss,omitempty"`
+	SaltLength int    `json:"salt_length,omitempty"`
+
+	// import_certificate: DER
+	Certificate []byte `json:"certificate,omitempty"`
+
+	// import_key: PKCS #8 DER
+	Key []byte `json:"key,omitempty"`
+}
+
+// PluginResponse is a response of the plugin protocol. Error is set when
+// the request failed, with Code naming the kind of failure.
+type PluginResponse struct {
+	ID    uint64 `json:"id"`
+	Error string `json:"error,omitempty"`
+	Code  string `json:"code,omitempty"`
+
+	// hello
+	Version  int    `json:"version,omitempty"`
+	Name     string `json:"name,omitempty"`
+	Hardware bool   `json:"hardware,omitempty"`
+
+	// public_key: PKIX DER
+	PublicKey []byte `json:"public_key,omitempty"`
+	// sign
+	Signature []byte `json:"signature,omitempty"`
+	// certificate: DER
+	Certificate []byte `json:"certificate,omitempty"`
+	// list_slots
+	Slots []PluginSlot `json:"slots,omitempty"`
+}
+
+// PluginSlot describes a slot in a list_slots response
+type PluginSlot struct {
+	Slot        Slot       `json:"slot"`
+	HasKey      bool       `json:"has_key"`
+	Algorithm   string     `json:"algorithm,omitempty"`
+	Bits        int        `json:"bits,omitempty"`
+	Created     *time.Time `json:"created,omitempty"`
+	Origin      string     `json:"origin,omitempty"`
+	PINPolicy   string     `json:"pin_policy,omitempty"`
+	TouchPolicy string     `json:"touch_policy,omitempty"`
+	Certificate []byte     `json:"certificate,omitempty"`
+}
+
+// pluginErrors maps the error codes of the protocol to the package errors,
+// in the order they are checked
+var pluginErrors = []struct {
+	code string
+	err  error
+}{
+	{"key_not_found", ErrKeyNotFound},
+	{"cert_not_found", ErrCertNotFound},
+	{"slot_not_found", ErrSlotNotFound},
+	{"slot_in_use", ErrSlotInUse},
+	{"not_supported", ErrOperationNotSupported},
+	{"invalid_algorithm", ErrInvalidAlgorithm},
+	{"invalid_key_type", ErrInvalidKeyType},
+	{"invalid_certificate", ErrInvalidCertificate},
+	{"credential_unavailable", ErrCredentialUnavailable},
+	{"not_connected", ErrNotConnected},
+	{"not_permitted", ErrNotPermitted},
+}
+
+// pluginErrorCode returns the protocol code of an error
+func pluginErrorCode(err error) string {
+	for _, e := range pluginErrors {
+		if errors.Is(err, e.err) {
+			return e.code
+		}
+	}
+	return ""
+}
+
+// remoteError is an error reported by the other end of a plugin
+// connection, matching the package error of its code
+type remoteError struct {
+	msg string
+	err error
+}
+
+func (e *remoteError) Error() string { return e.msg }
+func (e *remoteError) Unwrap() error { return e.err }
+
+// pluginError converts an error response to an error
+func pluginError(resp *PluginResponse) error {
+	for _, e := range pluginErrors {
+		if e.code == resp.Code {
+			return &remoteError{msg: resp.Error, err: e.err}
+		}
+	}
+	return errors.New(resp.Error)
+}
+
+// hashByName finds a hash function by the name crypto.Hash.String returns
+func hashByName(name string) (crypto.Hash, error) {
+	if name == "" {
+		return 0, nil
+	}
+	for h := crypto.MD4; h <= crypto.BLAKE2b_512; h++ {
+		if h.String() == name {
+			return h, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown hash %q", name)
+}
+
+// setSignerOpts encodes signer options into a sign request
+func (req *PluginRequest) setSignerOpts(opts crypto.SignerOpts) {
+	if opts == nil {
+		return
+	}
+	if h := opts.HashFunc(); h != 0 {
+		req.Hash = h.String()
+	}
+	if pss, ok := opts.(*rsa.PSSOptions); ok {
+		req.PSS = true
+		req.SaltLength = pss.SaltLength
+	}
+}
+
+// SignerOpts decodes the signer options of a sign request
+func (req *PluginRequest) SignerOpts() (crypto.SignerOpts, error) {
+	h, err := hashByName(req.Hash)
+	if err != nil {
+		return nil, err
+	}
+	if req.PSS {
+		return &rsa.PSSOptions{SaltLength: req.SaltLength, Hash: h}, nil
+	}
+	return h, nil
+}
+
+// PluginServer serves a provider over the plugin protocol, so another
+// process can use its keys through a PluginProvider
+type PluginServer struct {
+	Provider Provider
+
+	// Authorize, when set, is asked before every request; a request it
+	// returns an error for is refused with that error
+	Authorize func(conn net.Conn, req *PluginRequest) error
+	// Signed, when set, is told about every sign request and its outcome,
+	// including refused ones
+	Signed func(conn net.Conn, req *PluginRequest, err error)
+
+	// mutex serializes calls into the provider
+	mutex sync.Mutex
+}
+
+// NewPluginServer creates a server for a connected provider
+func NewPluginServer(provider Provider) *PluginServer {
+	return &PluginServer{Provider: provider}
+}
+
+// Serve accepts connections until the listener is closed
+func (s *PluginServer) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		go s.ServeConn(conn)
+	}
+}
+
+// ServeConn answers the requests of one connection until it is closed
+func (s *PluginServer) ServeConn(conn net.Conn) error {
+	defer conn.Close()
+
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxPluginMessage)
+	enc := json.NewEncoder(conn)
+	for scanner.Scan() {
+		var req PluginRequest
+		var resp *PluginResponse
+		if err := json.Unmarshal(scanner.Bytes(), &req); err != nil {
+			resp = &PluginResponse{Error: fmt.Sprintf("invalid request: %v", err)}
+		} else {
+			resp = s.respond(conn, &req)
+		}
+		if err := enc.Encode(resp); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// respond answers a single request
+func (s *PluginServer) respond(conn net.Conn, req *PluginRequest) *PluginResponse {
+	var resp *PluginResponse
+	var err error
+	if s.Authorize != nil {
+		err = s.Authorize(conn, req)
+	}
+	if err == nil {
+		s.mutex.Lock()
+		resp, err = s.handle(req)
+		s.mutex.Unlock()
+	}
+	if req.Method == PluginSign && s.Signed != nil {
+		s.Signed(conn, req, err)
+	}
+
+	if err != nil {
+		resp = &PluginResponse{Error: err.Error(), Code: pluginErrorCode(err)}
+	}
+	resp.ID = req.ID
+	return resp
+}
+
+func (s *PluginServer) handle(req *PluginRequest) (*PluginResponse, error) {
+	p := s.Provider
+	switch req.Method {
+	case PluginHello:
+		return &PluginResponse{Version: PluginProtocolVersion, Name: p.Name(), Hardware: p.IsHardware()}, nil
+
+	case PluginGenerateKey:
+		return &PluginResponse{}, p.GenerateKey(req.Slot, req.Algorithm, req.Bits)
+
+	case PluginPublicKey:
+		pub, err := p.GetPublicKey(req.Slot)
+		if err != nil {
+			return nil, err
+		}
+		der, err := x509.MarshalPKIXPublicKey(pub)
+		if err != nil {
+			return nil, err
+		}
+		return &PluginResponse{PublicKey: der}, nil
+
+	case PluginSign:
+		opts, err := req.SignerOpts()
+		if err != nil {
+			return nil, err
+		}
+		sig, err := p.Sign(req.Slot, req.Digest, opts)
+		if err != nil {
+			return nil, err
+		}
+		return &PluginResponse{Signature: sig}, nil
+
+	case PluginImportCertificate:
+		cert, err := x509.ParseCertificate(req.Certificate)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidCertificate, err)
+		}
+		return &PluginResponse{}, p.ImportCertificate(req.Slot, cert)
+
+	case PluginCertificate:
+		cert, err := p.GetCertificate(req.Slot)
+		if err != nil {
+			return nil, err
+		}
+		return &PluginResponse{Certificate: cert.Raw}, nil
+
+	case PluginListSlots:
+		slots, err := p.ListSlots()
+		if err != nil {
+			return nil, err
+		}
+		resp := &PluginResponse{Slots: make([]PluginSlot, len(slots))}
+		for i, info := range slots {
+			ps := PluginSlot{
+				Slot:        info.Slot,
+				HasKey:      info.HasKey,
+				Algorithm:   info.Algorithm,
+				Bits:        info.Bits,
+				Origin:      info.Origin,
+				PINPolicy:   info.PINPolicy,
+				TouchPolicy: info.TouchPolicy,
+			}
+			if !info.Created.IsZero() {
+				created := info.Created
+				ps.Created = &created
+			}
+			if info.Certificate != nil {
+				ps.Certificate = info.Certificate.Raw
+			}
+			resp.Slots[i] = ps
+		}
+		return resp, nil
+
+	case PluginDeleteKey:
+		return &PluginResponse{}, p.DeleteKey(req.Slot)
+
+	case PluginImportKey:
+		key, err := x509.ParsePKCS8PrivateKey(req.Key)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidKeyType, err)
+		}
+		return &PluginResponse{}, ImportKey(p, req.Slot, key)
+	}
+	return nil, fmt.Errorf("%w: unknown method %q", ErrOperationNotSupported, req.Method)
+}
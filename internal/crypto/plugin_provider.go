@@ -0,0 +1,315 @@
+package crypto
+
+import (
+	"bufio"
+	"crypto"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// PluginProviderType is the provider type of out-of-process providers
+const PluginProviderType ProviderType = "plugin"
+
+// defaultPluginTimeout bounds a single plugin call. Hardware behind a
+// plugin may wait for a touch, so it is generous.
+const defaultPluginTimeout = 30 * time.Second
+
+// PluginProvider implements the Provider interface by forwarding every
+// operation to another process over the plugin protocol
+type PluginProvider struct {
+	typ      ProviderType
+	name     string
+	hardware bool
+	timeout  time.Duration
+	// dial opens the connection to the plugin
+	dial func() (net.Conn, error)
+
+	// connected is set between Connect and Close; a connection lost in
+	// between is dialed again on the next call
+	connected bool
+	conn      net.Conn
+	scanner   *bufio.Scanner
+	nextID    uint64
+	mutex     sync.Mutex
+}
+
+// NewPluginProvider creates a provider for a plugin listening on a Unix
+// socket. Options: "socket" is the socket path and "timeout" bounds each
+// call.
+func NewPluginProvider(opts map[string]interface{}) (Provider, error) {
+	socket, _ := opts["socket"].(string)
+	if socket == "" {
+		return nil, fmt.Errorf("plugin provider needs a socket")
+	}
+	timeout := defaultPluginTimeout
+	if t, ok := opts["timeout"].(time.Duration); ok && t > 0 {
+		timeout = t
+	}
+	name, _ := opts["name"].(string)
+
+	return &PluginProvider{
+		typ:     PluginProviderType,
+		name:    name,
+		timeout: timeout,
+		dial: func() (net.Conn, error) {
+			return net.DialTimeout("unix", socket, timeout)
+		},
+	}, nil
+}
+
+// Type returns the type of the provider
+func (p *PluginProvider) Type() ProviderType {
+	return p.typ
+}
+
+// Name returns the configured name, or the name the plugin reports
+func (p *PluginProvider) Name() string {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.name == "" {
+		return "Plugin Provider"
+	}
+	return p.name
+}
+
+// Connect connects to the plugin and checks its protocol version
+func (p *PluginProvider) Connect() error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.conn != nil {
+		return nil
+	}
+	if err := p.open(); err != nil {
+		return err
+	}
+	p.connected = true
+	return nil
+}
+
+// open dials the plugin and says hello. The caller holds the mutex.
+func (p *PluginProvider) open() error {
+	conn, err := p.dial()
+	if err != nil {
+		return fmt.Errorf("failed to connect to plugin: %w", err)
+	}
+	p.conn = conn
+	p.scanner = bufio.NewScanner(conn)
+	p.scanner.Buffer(make([]byte, 0, 64*1024), maxPluginMessage)
+
+	resp, err := p.call(&PluginRequest{Method: PluginHello})
+	if err != nil {
+		p.closeConn()
+		return err
+	}
+	if resp.Version != PluginProtocolVersion {
+		p.closeConn()
+		return fmt.Errorf("plugin speaks protocol version %d, want %d", resp.Version, PluginProtocolVersion)
+	}
+	if p.name == "" {
+		p.name = resp.Name
+	}
+	p.hardware = resp.Hardware
+	return nil
+}
+
+// Close closes the connection to the plugin
+func (p *PluginProvider) Close() error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.connected = false
+	return p.closeConn()
+}
+
+func (p *PluginProvider) closeConn() error {
+	if p.conn == nil {
+		return nil
+	}
+	err := p.conn.Close()
+	p.conn = nil
+	p.scanner = nil
+	return err
+}
+
+// call sends a request and reads its response. The caller holds the mutex.
+// A connection that fails mid-call is closed, as responses could no longer
+// be matched to requests.
+func (p *PluginProvider) call(req *PluginRequest) (*PluginResponse, error) {
+	if p.conn == nil {
+		return nil, ErrNotConnected
+	}
+
+	p.nextID++
+	req.ID = p.nextID
+	data, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	p.conn.SetDeadline(time.Now().Add(p.timeout))
+	if _, err := p.conn.Write(append(data, '\n')); err != nil {
+		p.closeConn()
+		return nil, fmt.Errorf("plugin %s: %w", req.Method, err)
+	}
+	if !p.scanner.Scan() {
+		err := p.scanner.Err()
+		if err == nil {
+			err = fmt.Errorf("connection closed")
+		}
+		p.closeConn()
+		return nil, fmt.Errorf("plugin %s: %w", req.Method, err)
+	}
+
+	var resp PluginResponse
+	if err := json.Unmarshal(p.scanner.Bytes(), &resp); err != nil {
+		p.closeConn()
+		return nil, fmt.Errorf("plugin %s: invalid response: %w", req.Method, err)
+	}
+	if resp.ID != req.ID {
+		p.closeConn()
+		return nil, fmt.Errorf("plugin %s: response %d does not match request %d", req.Method, resp.ID, req.ID)
+	}
+	if resp.Error != "" {
+		return nil, pluginError(&resp)
+	}
+	return &resp, nil
+}
+
+// do makes a call holding the mutex, reconnecting first if the connection
+// was lost
+func (p *PluginProvider) do(req *PluginRequest) (*PluginResponse, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.conn == nil && p.connected {
+		if err := p.open(); err != nil {
+			return nil, err
+		}
+	}
+	return p.call(req)
+}
+
+// GenerateKey generates a new key pair in the specified slot
+func (p *PluginProvider) GenerateKey(slot Slot, algorithm string, bits int) error {
+	_, err := p.do(&PluginRequest{Method: PluginGenerateKey, Slot: slot, Algorithm: algorithm, Bits: bits})
+	return err
+}
+
+// GetPublicKey retrieves the public key from a slot
+func (p *PluginProvider) GetPublicKey(slot Slot) (crypto.PublicKey, error) {
+	resp, err := p.do(&PluginRequest{Method: PluginPublicKey, Slot: slot})
+	if err != nil {
+		return nil, err
+	}
+	pub, err := x509.ParsePKIXPublicKey(resp.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("plugin returned an invalid public key: %w", err)
+	}
+	return pub, nil
+}
+
+// Sign signs a digest with the key in the specified slot
+func (p *PluginProvider) Sign(slot Slot, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	req := &PluginRequest{Method: PluginSign, Slot: slot, Digest: digest}
+	req.setSignerOpts(opts)
+	resp, err := p.do(req)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Signature, nil
+}
+
+// ImportCertificate imports a certificate into a slot
+func (p *PluginProvider) ImportCertificate(slot Slot, cert *x509.Certificate) error {
+	if cert == nil {
+		return ErrInvalidCertificate
+	}
+	_, err := p.do(&PluginRequest{Method: PluginImportCertificate, Slot: slot, Certificate: cert.Raw})
+	return err
+}
+
+// GetCertificate retrieves a certificate from a slot
+func (p *PluginProvider) GetCertificate(slot Slot) (*x509.Certificate, error) {
+	resp, err := p.do(&PluginRequest{Method: PluginCertificate, Slot: slot})
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(resp.Certificate)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCertificate, err)
+	}
+	return cert, nil
+}
+
+// ListSlots reports the slots that hold a key or a certificate
+func (p *PluginProvider) ListSlots() ([]SlotInfo, error) {
+	resp, err := p.do(&PluginRequest{Method: PluginListSlots})
+	if err != nil {
+		return nil, err
+	}
+	slots := make([]SlotInfo, len(resp.Slots))
+	for i, ps := range resp.Slots {
+		info := SlotInfo{
+			Slot:        ps.Slot,
+			HasKey:      ps.HasKey,
+			Algorithm:   ps.Algorithm,
+			Bits:        ps.Bits,
+			Origin:      ps.Origin,
+			PINPolicy:   ps.PINPolicy,
+			TouchPolicy: ps.TouchPolicy,
+		}
+		if ps.Created != nil {
+			info.Created = *ps.Created
+		}
+		if len(ps.Certificate) > 0 {
+			if info.Certificate, err = x509.ParseCertificate(ps.Certificate); err != nil {
+				return nil, fmt.Errorf("%w: slot %X: %v", ErrInvalidCertificate, int(ps.Slot), err)
+			}
+		}
+		slots[i] = info
+	}
+	return slots, nil
+}
+
+// DeleteKey removes the key and the certificate of a slot
+func (p *PluginProvider) DeleteKey(slot Slot) error {
+	_, err := p.do(&PluginRequest{Method: PluginDeleteKey, Slot: slot})
+	return err
+}
+
+// ImportKey sends a private key to the plugin, which refuses it with
+// ErrOperationNotSupported unless its backend accepts imported keys
+func (p *PluginProvider) ImportKey(slot Slot, key crypto.PrivateKey) error {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidKeyType, err)
+	}
+	_, err = p.do(&PluginRequest{Method: PluginImportKey, Slot: slot, Key: der})
+	for i := range der {
+		der[i] = 0
+	}
+	return err
+}
+
+// IsHardware reports whether the plugin keeps its keys in hardware
+func (p *PluginProvider) IsHardware() bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.hardware
+}
+
+// Register the provider
+func init() {
+	Register(Registration{
+		Type:        PluginProviderType,
+		Description: "Keys held by another process speaking the plugin protocol over a Unix socket",
+		Options: []Option{
+			{Name: "socket", Type: OptionString, Description: "Path of the plugin's Unix socket", Required: true},
+			{Name: "timeout", Type: OptionDuration, Description: "Time limit of a single call (default 30s)"},
+		},
+		Factory: NewPluginProvider,
+	})
+}
@@ -0,0 +1,160 @@
+package crypto
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// servePlugin serves a software provider on a Unix socket and returns the
+// socket path
+func servePlugin(t *testing.T) string {
+	t.Helper()
+	backend, err := NewSoftwareProvider(map[string]interface{}{"directory": t.TempDir(), "name": "Backend"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := backend.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	socket := filepath.Join(t.TempDir(), "plugin.sock")
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go NewPluginServer(backend).Serve(l)
+	return socket
+}
+
+func TestPluginProvider(t *testing.T) {
+	socket := servePlugin(t)
+	p, err := OpenProvider(PluginProviderType, map[string]interface{}{"socket": socket, "timeout": "10s"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	if p.Name() != "Backend" || p.IsHardware() {
+		t.Errorf("Unexpected plugin identity: %q, hardware %v", p.Name(), p.IsHardware())
+	}
+
+	if _, err := p.GetPublicKey(SlotCA1); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("Expected ErrKeyNotFound over the plugin, got %v", err)
+	}
+
+	// ECDSA
+	if err := p.GenerateKey(SlotCA1, "ECDSA", 384); err != nil {
+		t.Fatal(err)
+	}
+	pub, err := p.GetPublicKey(SlotCA1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest := sha256.Sum256([]byte("plugin"))
+	sig, err := p.Sign(SlotCA1, digest[:], crypto.SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ecdsa.VerifyASN1(pub.(*ecdsa.PublicKey), digest[:], sig) {
+		t.Error("ECDSA signature from the plugin does not verify")
+	}
+
+	// RSA-PSS
+	if err := p.GenerateKey(SlotCA2, "RSA", 2048); err != nil {
+		t.Fatal(err)
+	}
+	rsaPub, err := p.GetPublicKey(SlotCA2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pss := &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: crypto.SHA256}
+	sig, err = p.Sign(SlotCA2, digest[:], pss)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rsa.VerifyPSS(rsaPub.(*rsa.PublicKey), crypto.SHA256, digest[:], sig, pss); err != nil {
+		t.Errorf("PSS signature from the plugin does not verify: %v", err)
+	}
+
+	// Certificates and inventory
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Plugin CA"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, pub, &ProviderSigner{Provider: p, Slot: SlotCA1, PublicKey: pub})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	if err := p.ImportCertificate(SlotCA1, cert); err != nil {
+		t.Fatal(err)
+	}
+	got, err := p.GetCertificate(SlotCA1)
+	if err != nil || got.Subject.CommonName != "Plugin CA" {
+		t.Fatalf("Unexpected certificate: %v, %v", got, err)
+	}
+
+	slots, err := p.ListSlots()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(slots) != 2 || slots[0].Slot != SlotCA1 || slots[0].Bits != 384 || slots[0].Certificate == nil {
+		t.Errorf("Unexpected inventory: %+v", slots)
+	}
+
+	if err := p.DeleteKey(SlotCA2); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.DeleteKey(SlotCA2); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("Expected ErrKeyNotFound deleting an empty slot, got %v", err)
+	}
+
+	// Imported keys reach the backend
+	imported, _ := ecdsa.GenerateKey(pub.(*ecdsa.PublicKey).Curve, rand.Reader)
+	if err := ImportKey(p, SlotSSH, imported); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := p.GetPublicKey(SlotSSH); err != nil || !imported.PublicKey.Equal(got) {
+		t.Errorf("Expected the imported key in the plugin, got %v", err)
+	}
+
+	p.Close()
+	if _, err := p.GetPublicKey(SlotCA1); !errors.Is(err, ErrNotConnected) {
+		t.Errorf("Expected ErrNotConnected after Close, got %v", err)
+	}
+}
+
+func TestPluginProviderUnavailable(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "missing.sock")
+	if _, err := OpenProvider(PluginProviderType, map[string]interface{}{"socket": socket}); err == nil {
+		t.Error("Expected an error connecting to a missing plugin")
+	}
+	// The fallback keeps its keys in the home directory
+	t.Setenv("HOME", t.TempDir())
+	p, err := OpenProvider(PluginProviderType, map[string]interface{}{
+		"socket":   socket,
+		"fallback": true,
+	})
+	if err != nil {
+		t.Fatalf("Expected fallback to the software provider: %v", err)
+	}
+	p.Close()
+	if p.Type() != SoftwareProviderType {
+		t.Errorf("Expected the software provider, got %s", p.Type())
+	}
+}
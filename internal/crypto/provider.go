@@ -4,17 +4,21 @@ package crypto
 
 import (
 	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
 	"crypto/x509"
+	"time"
 )
 
-// ProviderType represents the type of crypto provider
-type ProviderType int
+// ProviderType names a provider implementation. Providers are registered
+// and selected by this name, e.g. "yubikey" in the PiCA configuration.
+type ProviderType string
 
 const (
 	// YubiKeyProviderType represents a YubiKey hardware-based provider
-	YubiKeyProviderType ProviderType = iota
+	YubiKeyProviderType ProviderType = "yubikey"
 	// SoftwareProviderType represents a software-based provider
-	SoftwareProviderType
+	SoftwareProviderType ProviderType = "software"
 )
 
 // Slot represents a key slot in a provider
@@ -29,6 +33,10 @@ const (
 	// 0x82-0x95 are retirement slots that can be used for CA keys
 	SlotCA1 Slot = 0x82
 	SlotCA2 Slot = 0x83
+	// SlotSSH holds the SSH certificate authority key
+	SlotSSH Slot = 0x84
+	// SlotTSA holds the time-stamping authority key
+	SlotTSA Slot = 0x85
 )
 
 // Provider defines the interface for cryptographic operations
@@ -61,27 +69,95 @@ type Provider interface {
 	// GetCertificate retrieves a certificate from a slot
 	GetCertificate(slot Slot) (*x509.Certificate, error)
 	
+	// ListSlots reports the slots that hold a key or a certificate, in
+	// slot order
+	ListSlots() ([]SlotInfo, error)
+	
+	// DeleteKey removes the key and the certificate of a slot
+	DeleteKey(slot Slot) error
+	
 	// IsHardware returns true if this is a hardware-based provider
 	IsHardware() bool
 }
 
-// ProviderFactory is a function that creates a new Provider
-type ProviderFactory func(opts map[string]interface{}) (Provider, error)
+// SlotInfo describes what a provider slot holds
+type SlotInfo struct {
+	Slot Slot
+	// HasKey is false for slots that only hold a certificate
+	HasKey bool
+	// Algorithm ("RSA" or "ECDSA") and Bits describe the key the way
+	// GenerateKey takes them
+	Algorithm string
+	Bits      int
+	// Created is when the key was generated or imported; zero when the
+	// provider does not record it
+	Created time.Time
+	// Origin is "generated" or "imported" where the provider knows it
+	Origin string
+	// PINPolicy and TouchPolicy apply to hardware keys, e.g. "once" and
+	// "never"; empty where the provider has no such policies
+	PINPolicy   string
+	TouchPolicy string
+	Certificate *x509.Certificate
+}
 
-var (
-	// registeredProviders holds the registered provider factories
-	registeredProviders = make(map[ProviderType]ProviderFactory)
-)
+// keyParameters returns the algorithm and size of a public key
+func keyParameters(pub crypto.PublicKey) (string, int) {
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		return "RSA", k.N.BitLen()
+	case *ecdsa.PublicKey:
+		return "ECDSA", k.Curve.Params().BitSize
+	}
+	return "", 0
+}
+
+// KeyImporter is implemented by providers that can load an existing private
+// key into a slot, e.g. when migrating a CA from OpenSSL
+type KeyImporter interface {
+	// ImportKey stores a private key in the specified slot
+	ImportKey(slot Slot, key crypto.PrivateKey) error
+}
+
+// ImportKey loads a private key into a provider slot if the provider supports it
+func ImportKey(p Provider, slot Slot, key crypto.PrivateKey) error {
+	importer, ok := p.(KeyImporter)
+	if !ok {
+		return ErrOperationNotSupported
+	}
+	return importer.ImportKey(slot, key)
+}
+
+// KeyExporter is implemented by providers whose keys can leave them, which
+// only software keys can
+type KeyExporter interface {
+	// ExportKey returns the private key of a slot
+	ExportKey(slot Slot) (crypto.PrivateKey, error)
+}
+
+// ExportKey returns the private key of a provider slot if the provider
+// releases keys
+func ExportKey(p Provider, slot Slot) (crypto.PrivateKey, error) {
+	exporter, ok := p.(KeyExporter)
+	if !ok {
+		return nil, ErrOperationNotSupported
+	}
+	return exporter.ExportKey(slot)
+}
 
-// RegisterProvider registers a provider factory for the given type
-func RegisterProvider(providerType ProviderType, factory ProviderFactory) {
-	registeredProviders[providerType] = factory
+// KeyAttester is implemented by providers that can prove a key was
+// generated on the device and never left it
+type KeyAttester interface {
+	// AttestKey returns the attestation certificate of a slot's key
+	// followed by the device certificate that signed it
+	AttestKey(slot Slot) ([]*x509.Certificate, error)
 }
 
-// NewProvider creates a new provider of the given type
-func NewProvider(providerType ProviderType, opts map[string]interface{}) (Provider, error) {
-	if factory, ok := registeredProviders[providerType]; ok {
-		return factory(opts)
+// AttestKey returns the attestation of a slot's key if the provider supports it
+func AttestKey(p Provider, slot Slot) ([]*x509.Certificate, error) {
+	attester, ok := p.(KeyAttester)
+	if !ok {
+		return nil, ErrOperationNotSupported
 	}
-	return nil, ErrProviderNotFound
+	return attester.AttestKey(slot)
 }
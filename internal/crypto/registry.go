@@ -0,0 +1,249 @@
+package crypto
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// OptionType is the type of a provider option value
+type OptionType int
+
+const (
+	OptionString OptionType = iota
+	OptionBool
+	OptionInt
+	OptionDuration
+)
+
+// String returns the name of the option type
+func (t OptionType) String() string {
+	switch t {
+	case OptionString:
+		return "string"
+	case OptionBool:
+		return "bool"
+	case OptionInt:
+		return "int"
+	case OptionDuration:
+		return "duration"
+	}
+	return fmt.Sprintf("OptionType(%d)", int(t))
+}
+
+// Option describes an option a provider accepts
+type Option struct {
+	Name        string
+	Type        OptionType
+	Description string
+	Required    bool
+	// Secret options, such as PINs, are never printed
+	Secret bool
+}
+
+// ProviderFactory creates a provider from validated options
+type ProviderFactory func(opts map[string]interface{}) (Provider, error)
+
+// Registration describes a provider implementation
+type Registration struct {
+	Type        ProviderType
+	Description string
+	// Options lists the options the provider accepts besides the common
+	// ones; nil accepts any option unchecked
+	Options []Option
+	Factory ProviderFactory
+	// Detect reports whether the provider's device is present. When no
+	// provider is configured, one that detects its device is preferred
+	// over the software provider.
+	Detect func() bool
+	// Priority orders providers that detect their device, highest first;
+	// the YubiKey is preferred over a TPM
+	Priority int
+}
+
+// commonOptions are accepted by every provider
+var commonOptions = []Option{
+	{Name: "type", Type: OptionString, Description: "Provider type"},
+	{Name: "name", Type: OptionString, Description: "Display name of the provider"},
+	{Name: "fallback", Type: OptionBool, Description: "Use the software provider when this one fails to connect"},
+}
+
+var (
+	registryMutex sync.RWMutex
+	registry      = make(map[ProviderType]Registration)
+)
+
+// Register makes a provider available under its type name, replacing an
+// earlier registration of the same name
+func Register(r Registration) {
+	if r.Type == "" || r.Factory == nil {
+		panic("crypto: a provider registration needs a type and a factory")
+	}
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+	registry[r.Type] = r
+}
+
+// RegisterProvider registers a provider factory without an option schema
+func RegisterProvider(providerType ProviderType, factory ProviderFactory) {
+	Register(Registration{Type: providerType, Factory: factory})
+}
+
+// Providers returns the registered providers sorted by type
+func Providers() []Registration {
+	registryMutex.RLock()
+	defer registryMutex.RUnlock()
+	regs := make([]Registration, 0, len(registry))
+	for _, r := range registry {
+		regs = append(regs, r)
+	}
+	sort.Slice(regs, func(i, j int) bool { return regs[i].Type < regs[j].Type })
+	return regs
+}
+
+// LookupProvider returns the registration of a provider type
+func LookupProvider(providerType ProviderType) (Registration, error) {
+	registryMutex.RLock()
+	defer registryMutex.RUnlock()
+	r, ok := registry[providerType]
+	if !ok {
+		return Registration{}, fmt.Errorf("%w: %q", ErrProviderNotFound, string(providerType))
+	}
+	return r, nil
+}
+
+// ValidateOptions checks options against the provider's schema and returns
+// them converted to the option types. String values, as environment
+// variables and flags supply them, are parsed.
+func (r Registration) ValidateOptions(opts map[string]interface{}) (map[string]interface{}, error) {
+	schema := make(map[string]Option)
+	for _, o := range commonOptions {
+		schema[o.Name] = o
+	}
+	for _, o := range r.Options {
+		schema[o.Name] = o
+	}
+
+	valid := make(map[string]interface{}, len(opts))
+	for name, value := range opts {
+		o, ok := schema[name]
+		if !ok {
+			if r.Options == nil {
+				valid[name] = value
+				continue
+			}
+			return nil, fmt.Errorf("unknown option %q for provider %s", name, r.Type)
+		}
+		v, err := convertOption(o, value)
+		if err != nil {
+			return nil, fmt.Errorf("provider %s: %w", r.Type, err)
+		}
+		valid[name] = v
+	}
+	for _, o := range r.Options {
+		if _, ok := valid[o.Name]; o.Required && !ok {
+			return nil, fmt.Errorf("provider %s: option %q is required", r.Type, o.Name)
+		}
+	}
+	return valid, nil
+}
+
+// convertOption converts an option value to the option's type
+func convertOption(o Option, value interface{}) (interface{}, error) {
+	invalid := func(err error) error {
+		if err != nil {
+			return fmt.Errorf("option %q must be a %s: %v", o.Name, o.Type, err)
+		}
+		return fmt.Errorf("option %q must be a %s, not %T", o.Name, o.Type, value)
+	}
+
+	switch o.Type {
+	case OptionString:
+		if s, ok := value.(string); ok {
+			return s, nil
+		}
+	case OptionBool:
+		switch v := value.(type) {
+		case bool:
+			return v, nil
+		case string:
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				return nil, invalid(err)
+			}
+			return b, nil
+		}
+	case OptionInt:
+		switch v := value.(type) {
+		case int:
+			return v, nil
+		case int64:
+			return int(v), nil
+		case float64:
+			// JSON numbers
+			if v != math.Trunc(v) {
+				return nil, invalid(nil)
+			}
+			return int(v), nil
+		case string:
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return nil, invalid(err)
+			}
+			return n, nil
+		}
+	case OptionDuration:
+		switch v := value.(type) {
+		case time.Duration:
+			return v, nil
+		case string:
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				return nil, invalid(err)
+			}
+			return d, nil
+		}
+	}
+	return nil, invalid(nil)
+}
+
+// ValidateProviderConfig checks that a provider type is registered and
+// accepts the options
+func ValidateProviderConfig(providerType ProviderType, opts map[string]interface{}) error {
+	r, err := LookupProvider(providerType)
+	if err != nil {
+		return err
+	}
+	_, err = r.ValidateOptions(opts)
+	return err
+}
+
+// NewProvider creates a provider of the given type from validated options.
+// The provider is not connected yet.
+func NewProvider(providerType ProviderType, opts map[string]interface{}) (Provider, error) {
+	r, err := LookupProvider(providerType)
+	if err != nil {
+		return nil, err
+	}
+	valid, err := r.ValidateOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	return r.Factory(valid)
+}
+
+// DetectProviderType returns the registered provider of highest priority
+// that detects its device, or the software provider
+func DetectProviderType() ProviderType {
+	regs := Providers()
+	sort.SliceStable(regs, func(i, j int) bool { return regs[i].Priority > regs[j].Priority })
+	for _, r := range regs {
+		if r.Detect != nil && r.Detect() {
+			return r.Type
+		}
+	}
+	return SoftwareProviderType
+}
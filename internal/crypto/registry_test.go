@@ -0,0 +1,93 @@
+package crypto
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestValidateOptions(t *testing.T) {
+	reg, err := LookupProvider(PluginProviderType)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts, err := reg.ValidateOptions(map[string]interface{}{
+		"socket":   "/run/signer.sock",
+		"timeout":  "5s",
+		"fallback": "false",
+		"name":     "Signer",
+	})
+	if err != nil {
+		t.Fatalf("Valid options rejected: %v", err)
+	}
+	if opts["timeout"] != 5*time.Second {
+		t.Errorf("Expected timeout of 5s, got %v", opts["timeout"])
+	}
+	if opts["fallback"] != false {
+		t.Errorf("Expected fallback to be converted to false, got %#v", opts["fallback"])
+	}
+
+	tests := []struct {
+		name string
+		opts map[string]interface{}
+	}{
+		{"missing required option", map[string]interface{}{"timeout": "5s"}},
+		{"unknown option", map[string]interface{}{"socket": "/s", "pin": "123456"}},
+		{"invalid duration", map[string]interface{}{"socket": "/s", "timeout": "soon"}},
+		{"wrong type", map[string]interface{}{"socket": 5}},
+	}
+	for _, tt := range tests {
+		if _, err := reg.ValidateOptions(tt.opts); err == nil {
+			t.Errorf("%s: expected an error", tt.name)
+		}
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	for _, typ := range []ProviderType{SoftwareProviderType, YubiKeyProviderType, PluginProviderType} {
+		if _, err := LookupProvider(typ); err != nil {
+			t.Errorf("Provider %s is not registered: %v", typ, err)
+		}
+	}
+	if err := ValidateProviderConfig("hsm", nil); !errors.Is(err, ErrProviderNotFound) {
+		t.Errorf("Expected ErrProviderNotFound for an unknown type, got %v", err)
+	}
+
+	// A provider registered by name is created through the registry
+	Register(Registration{
+		Type:    "test-registry",
+		Options: []Option{{Name: "directory", Type: OptionString, Required: true}},
+		Factory: NewSoftwareProvider,
+	})
+	if _, err := NewProvider("test-registry", nil); err == nil {
+		t.Error("Expected an error without the required option")
+	}
+	p, err := OpenProvider("test-registry", map[string]interface{}{"directory": t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	if err := p.GenerateKey(SlotCA1, "ECDSA", 256); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestDetectProviderPriority(t *testing.T) {
+	present := func() bool { return true }
+	for _, r := range []Registration{
+		{Type: "test-detect-a", Factory: NewSoftwareProvider, Detect: present, Priority: 100},
+		{Type: "test-detect-b", Factory: NewSoftwareProvider, Detect: present, Priority: 101},
+	} {
+		Register(r)
+		typ := r.Type
+		t.Cleanup(func() {
+			registryMutex.Lock()
+			delete(registry, typ)
+			registryMutex.Unlock()
+		})
+	}
+	if typ := DetectProviderType(); typ != "test-detect-b" {
+		t.Errorf("Expected the detected provider of highest priority, got %s", typ)
+	}
+}
@@ -0,0 +1,116 @@
+package crypto
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// RemoteProviderType is the provider type of keys held by a pica-signer
+// daemon on another host
+const RemoteProviderType ProviderType = "remote"
+
+// SplitRemoteAddress splits a signer address into network and address:
+// "unix:/path" is a Unix socket, anything else a TCP host:port
+func SplitRemoteAddress(addr string) (network, address string) {
+	if path, ok := strings.CutPrefix(addr, "unix:"); ok {
+		return "unix", path
+	}
+	return "tcp", addr
+}
+
+// NewRemoteProvider creates a provider for a pica-signer daemon. Options:
+// "address" is "unix:/path" or host:port; over TCP the daemon is reached
+// with mutually authenticated TLS, using "ca" to verify it and "cert" and
+// "key" to authenticate to it.
+func NewRemoteProvider(opts map[string]interface{}) (Provider, error) {
+	addr, _ := opts["address"].(string)
+	if addr == "" {
+		return nil, fmt.Errorf("remote provider needs an address")
+	}
+	timeout := defaultPluginTimeout
+	if t, ok := opts["timeout"].(time.Duration); ok && t > 0 {
+		timeout = t
+	}
+	name, _ := opts["name"].(string)
+
+	network, address := SplitRemoteAddress(addr)
+	dialer := &net.Dialer{Timeout: timeout}
+	dial := func() (net.Conn, error) {
+		return dialer.Dial(network, address)
+	}
+	if network == "tcp" {
+		tlsConfig, err := remoteTLSConfig(address, opts)
+		if err != nil {
+			return nil, err
+		}
+		dial = func() (net.Conn, error) {
+			return tls.DialWithDialer(dialer, network, address, tlsConfig)
+		}
+	}
+
+	return &PluginProvider{
+		typ:     RemoteProviderType,
+		name:    name,
+		timeout: timeout,
+		dial:    dial,
+	}, nil
+}
+
+// remoteTLSConfig builds the client side of the mutual TLS connection to a
+// signer
+func remoteTLSConfig(address string, opts map[string]interface{}) (*tls.Config, error) {
+	caFile, _ := opts["ca"].(string)
+	certFile, _ := opts["cert"].(string)
+	keyFile, _ := opts["key"].(string)
+	if caFile == "" || certFile == "" || keyFile == "" {
+		return nil, fmt.Errorf("a remote signer reached over TCP needs the ca, cert and key options")
+	}
+
+	pem, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read signer CA: %w", err)
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates in signer CA file %s", caFile)
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load client certificate: %w", err)
+	}
+
+	serverName, _ := opts["server_name"].(string)
+	if serverName == "" {
+		if host, _, err := net.SplitHostPort(address); err == nil {
+			serverName = host
+		}
+	}
+	return &tls.Config{
+		RootCAs:      roots,
+		Certificates: []tls.Certificate{cert},
+		ServerName:   serverName,
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+// Register the provider
+func init() {
+	Register(Registration{
+		Type:        RemoteProviderType,
+		Description: "Keys held by a pica-signer daemon, over mutual TLS or a Unix socket",
+		Options: []Option{
+			{Name: "address", Type: OptionString, Description: "unix:/path of the signer's socket, or host:port", Required: true},
+			{Name: "ca", Type: OptionString, Description: "PEM file of the CA that issued the signer's certificate"},
+			{Name: "cert", Type: OptionString, Description: "Client certificate presented to the signer"},
+			{Name: "key", Type: OptionString, Description: "Key of the client certificate"},
+			{Name: "server_name", Type: OptionString, Description: "Name expected in the signer's certificate (default the host of address)"},
+			{Name: "timeout", Type: OptionDuration, Description: "Time limit of a single call (default 30s)"},
+		},
+		Factory: NewRemoteProvider,
+	})
+}
@@ -11,6 +11,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
 	"sync"
 )
 
@@ -205,6 +206,45 @@ func (p *SoftwareProvider) Sign(slot Slot, digest []byte, opts crypto.SignerOpts
 	}
 }
 
+// ImportKey stores an existing RSA or ECDSA private key in a slot
+func (p *SoftwareProvider) ImportKey(slot Slot, key crypto.PrivateKey) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	
+	if !p.connected {
+		return ErrNotConnected
+	}
+	
+	switch key.(type) {
+	case *rsa.PrivateKey, *ecdsa.PrivateKey:
+	default:
+		return fmt.Errorf("%w: %T", ErrInvalidKeyType, key)
+	}
+	
+	if err := p.saveKey(slot, key); err != nil {
+		return err
+	}
+	p.keys[slot] = key
+	return nil
+}
+
+// ExportKey returns the private key of a slot, e.g. to migrate it to a
+// hardware provider
+func (p *SoftwareProvider) ExportKey(slot Slot) (crypto.PrivateKey, error) {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	
+	if !p.connected {
+		return nil, ErrNotConnected
+	}
+	
+	key, ok := p.keys[slot]
+	if !ok {
+		return nil, ErrKeyNotFound
+	}
+	return key, nil
+}
+
 // ImportCertificate imports a certificate into a slot
 func (p *SoftwareProvider) ImportCertificate(slot Slot, cert *x509.Certificate) error {
 	p.mutex.Lock()
@@ -242,6 +282,73 @@ func (p *SoftwareProvider) GetCertificate(slot Slot) (*x509.Certificate, error)
 	return cert, nil
 }
 
+// ListSlots reports the slots that hold a key or a certificate. The key
+// file's modification time stands in for its creation time.
+func (p *SoftwareProvider) ListSlots() ([]SlotInfo, error) {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	
+	if !p.connected {
+		return nil, ErrNotConnected
+	}
+	
+	infos := make(map[Slot]*SlotInfo)
+	for slot, key := range p.keys {
+		info := &SlotInfo{Slot: slot, HasKey: true}
+		if signer, ok := key.(crypto.Signer); ok {
+			info.Algorithm, info.Bits = keyParameters(signer.Public())
+		}
+		if fi, err := os.Stat(p.keyFile(slot)); err == nil {
+			info.Created = fi.ModTime()
+		}
+		infos[slot] = info
+	}
+	for slot, cert := range p.certificates {
+		info, ok := infos[slot]
+		if !ok {
+			info = &SlotInfo{Slot: slot}
+			infos[slot] = info
+		}
+		info.Certificate = cert
+	}
+	
+	slots := make([]SlotInfo, 0, len(infos))
+	for _, info := range infos {
+		slots = append(slots, *info)
+	}
+	sort.Slice(slots, func(i, j int) bool { return slots[i].Slot < slots[j].Slot })
+	return slots, nil
+}
+
+// DeleteKey removes the key and the certificate of a slot from memory and
+// disk. The key file is overwritten before it is removed.
+func (p *SoftwareProvider) DeleteKey(slot Slot) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	
+	if !p.connected {
+		return ErrNotConnected
+	}
+	
+	_, hasKey := p.keys[slot]
+	_, hasCert := p.certificates[slot]
+	if !hasKey && !hasCert {
+		return ErrKeyNotFound
+	}
+	
+	if err := wipeFile(p.keyFile(slot)); err != nil {
+		return fmt.Errorf("failed to wipe key: %w", err)
+	}
+	for _, file := range []string{p.keyFile(slot), p.certFile(slot)} {
+		if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to delete %s: %w", file, err)
+		}
+	}
+	delete(p.keys, slot)
+	delete(p.certificates, slot)
+	return nil
+}
+
 // IsHardware returns false for software-based provider
 func (p *SoftwareProvider) IsHardware() bool {
 	return false
@@ -332,6 +439,16 @@ func (p *SoftwareProvider) loadKeysAndCertificates() error {
 	return nil
 }
 
+// keyFile returns the path of a slot's key
+func (p *SoftwareProvider) keyFile(slot Slot) string {
+	return filepath.Join(p.keyDir, fmt.Sprintf("slot_%x.key", slot))
+}
+
+// certFile returns the path of a slot's certificate
+func (p *SoftwareProvider) certFile(slot Slot) string {
+	return filepath.Join(p.certDir, fmt.Sprintf("slot_%x.crt", slot))
+}
+
 // saveKey saves a private key to disk
 func (p *SoftwareProvider) saveKey(slot Slot, privateKey crypto.PrivateKey) error {
 	var keyPEM *pem.Block
@@ -356,8 +473,7 @@ func (p *SoftwareProvider) saveKey(slot Slot, privateKey crypto.PrivateKey) erro
 	}
 	
 	// Write the key to disk
-	filename := filepath.Join(p.keyDir, fmt.Sprintf("slot_%x.key", slot))
-	file, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	file, err := os.OpenFile(p.keyFile(slot), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
 	if err != nil {
 		return fmt.Errorf("failed to open key file: %w", err)
 	}
@@ -370,11 +486,37 @@ func (p *SoftwareProvider) saveKey(slot Slot, privateKey crypto.PrivateKey) erro
 	return nil
 }
 
+// wipeFile overwrites a file with random bytes and flushes it to disk. On
+// flash storage and copy-on-write file systems old blocks may survive, so
+// this complements rather than replaces disk encryption.
+func wipeFile(filename string) error {
+	file, err := os.OpenFile(filename, os.O_WRONLY, 0)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	
+	fi, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	random := make([]byte, fi.Size())
+	if _, err := rand.Read(random); err != nil {
+		return err
+	}
+	if _, err := file.WriteAt(random, 0); err != nil {
+		return err
+	}
+	return file.Sync()
+}
+
 // saveCertificate saves a certificate to disk
 func (p *SoftwareProvider) saveCertificate(slot Slot, cert *x509.Certificate) error {
 	// Write the certificate to disk
-	filename := filepath.Join(p.certDir, fmt.Sprintf("slot_%x.crt", slot))
-	file, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	file, err := os.OpenFile(p.certFile(slot), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
 	if err != nil {
 		return fmt.Errorf("failed to open certificate file: %w", err)
 	}
@@ -394,5 +536,12 @@ func (p *SoftwareProvider) saveCertificate(slot Slot, cert *x509.Certificate) er
 
 // Register the provider
 func init() {
-	RegisterProvider(SoftwareProviderType, NewSoftwareProvider)
+	Register(Registration{
+		Type:        SoftwareProviderType,
+		Description: "Keys in files on disk",
+		Options: []Option{
+			{Name: "directory", Type: OptionString, Description: "Directory holding the keys and certificates (default ~/.pica)"},
+		},
+		Factory: NewSoftwareProvider,
+	})
 }
\ No newline at end of file
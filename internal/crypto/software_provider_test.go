@@ -0,0 +1,112 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestSoftwareProviderSlots(t *testing.T) {
+	dir := t.TempDir()
+	p, err := NewSoftwareProvider(map[string]interface{}{"directory": dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Connect(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := p.GenerateKey(SlotCA1, "ECDSA", 384); err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	if err := p.GenerateKey(SlotCA2, "RSA", 2048); err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	pub, err := p.GetPublicKey(SlotCA2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, pub, &ProviderSigner{Provider: p, Slot: SlotCA2, PublicKey: pub})
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	if err := p.ImportCertificate(SlotCA2, cert); err != nil {
+		t.Fatal(err)
+	}
+
+	// A new provider on the same directory sees the same inventory
+	reopened, err := NewSoftwareProvider(map[string]interface{}{"directory": dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := reopened.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	slots, err := reopened.ListSlots()
+	if err != nil {
+		t.Fatalf("Failed to list slots: %v", err)
+	}
+	if len(slots) != 2 {
+		t.Fatalf("Expected 2 slots, got %+v", slots)
+	}
+	if s := slots[0]; s.Slot != SlotCA1 || !s.HasKey || s.Algorithm != "ECDSA" || s.Bits != 384 || s.Created.IsZero() {
+		t.Errorf("Unexpected slot: %+v", s)
+	}
+	if s := slots[1]; s.Slot != SlotCA2 || s.Algorithm != "RSA" || s.Bits != 2048 || s.Certificate == nil {
+		t.Errorf("Unexpected slot: %+v", s)
+	}
+
+	// Software keys can be exported for migration
+	exported, err := ExportKey(reopened, SlotCA1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ca1, _ := reopened.GetPublicKey(SlotCA1); !exported.(*ecdsa.PrivateKey).PublicKey.Equal(ca1) {
+		t.Error("Exported key does not match the slot's public key")
+	}
+	if _, err := ExportKey(reopened, SlotSSH); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("Expected ErrKeyNotFound exporting an empty slot, got %v", err)
+	}
+
+	// The key file is overwritten before it is removed
+	keyFile := reopened.(*SoftwareProvider).keyFile(SlotCA2)
+	original, _ := os.ReadFile(keyFile)
+	if err := wipeFile(keyFile); err != nil {
+		t.Fatal(err)
+	}
+	if wiped, _ := os.ReadFile(keyFile); len(wiped) != len(original) || bytes.Equal(wiped, original) {
+		t.Error("Expected the key file to be overwritten")
+	}
+	if err := reopened.DeleteKey(SlotCA2); err != nil {
+		t.Fatalf("Failed to delete key: %v", err)
+	}
+	if err := reopened.DeleteKey(SlotCA2); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("Expected ErrKeyNotFound for an empty slot, got %v", err)
+	}
+	again, _ := NewSoftwareProvider(map[string]interface{}{"directory": dir})
+	if err := again.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := again.GetPublicKey(SlotCA2); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("Deleted key still on disk: %v", err)
+	}
+	if _, err := again.GetCertificate(SlotCA2); !errors.Is(err, ErrCertNotFound) {
+		t.Errorf("Deleted certificate still on disk: %v", err)
+	}
+}
@@ -0,0 +1,15 @@
+//go:build !windows
+
+package crypto
+
+import (
+	"io"
+
+	"github.com/google/go-tpm/tpmutil"
+)
+
+// openTPMDevice opens a TPM character device or the Unix socket of a
+// simulator
+func openTPMDevice(path string) (io.ReadWriteCloser, error) {
+	return tpmutil.OpenTPM(path)
+}
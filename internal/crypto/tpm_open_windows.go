@@ -0,0 +1,13 @@
+package crypto
+
+import (
+	"io"
+
+	"github.com/google/go-tpm/tpmutil"
+)
+
+// openTPMDevice opens the TPM through TBS; Windows has no device path, so
+// path is ignored
+func openTPMDevice(path string) (io.ReadWriteCloser, error) {
+	return tpmutil.OpenTPM()
+}
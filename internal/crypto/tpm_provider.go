@@ -0,0 +1,581 @@
+package crypto
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/google/go-tpm/legacy/tpm2"
+	"github.com/google/go-tpm/tpmutil"
+)
+
+// TPMProviderType is the provider type of keys held by a TPM 2.0
+const TPMProviderType ProviderType = "tpm"
+
+// defaultTPMHandleBase is the persistent handle of slot 0: slot 82 is kept
+// at 0x81500082
+const defaultTPMHandleBase = 0x81500000
+
+// tpmDevices are the device files tried when none is configured, the
+// kernel's resource manager first
+var tpmDevices = []string{"/dev/tpmrm0", "/dev/tpm0"}
+
+// tpmSRKTemplate is the ECC storage root key template of the TCG provisioning
+// guidance. The TPM derives the same key from it every time, so the parent
+// of the slot keys need not be persisted.
+var tpmSRKTemplate = tpm2.Public{
+	Type:       tpm2.AlgECC,
+	NameAlg:    tpm2.AlgSHA256,
+	Attributes: tpm2.FlagStorageDefault | tpm2.FlagNoDA,
+	ECCParameters: &tpm2.ECCParams{
+		Symmetric: &tpm2.SymScheme{Alg: tpm2.AlgAES, KeyBits: 128, Mode: tpm2.AlgCFB},
+		CurveID:   tpm2.CurveNISTP256,
+	},
+}
+
+// TPMProvider implements the Provider interface with keys that never leave
+// a TPM 2.0. Each slot's key is kept at its own persistent handle; keys may
+// be bound to PCR values so they only sign while the system boots as it
+// did when they were created. Certificates are kept in a local directory.
+type TPMProvider struct {
+	name          string
+	device        string
+	emulator      bool
+	handleBase    tpmutil.Handle
+	pcrs          []int
+	ownerPassword string
+	certDir       string
+	rw            io.ReadWriteCloser
+	connected     bool
+	mutex         sync.Mutex
+}
+
+// NewTPMProvider creates a TPM provider. Options: "device" is the TPM device
+// (/dev/tpmrm0 or /dev/tpm0 when unset) or the Unix socket of a simulator
+// such as swtpm, "handle_base" the persistent handle of slot 0, "pcrs" the
+// SHA-256 PCRs new keys are bound to, e.g. "0,2,4,7", "owner_password" the
+// owner hierarchy authorization and "directory" holds the certificates.
+func NewTPMProvider(opts map[string]interface{}) (Provider, error) {
+	p := &TPMProvider{
+		name:       "TPM Provider",
+		handleBase: defaultTPMHandleBase,
+	}
+	if n, ok := opts["name"].(string); ok && n != "" {
+		p.name = n
+	}
+	p.device, _ = opts["device"].(string)
+	p.ownerPassword, _ = opts["owner_password"].(string)
+
+	if base, ok := opts["handle_base"].(string); ok && base != "" {
+		h, err := strconv.ParseUint(strings.TrimPrefix(strings.ToLower(base), "0x"), 16, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid TPM handle base %q (must be hex)", base)
+		}
+		if h < 0x81000000 || h+0xFF > 0x817FFFFF {
+			return nil, fmt.Errorf("TPM handle base %#x is outside the owner's persistent handles", h)
+		}
+		p.handleBase = tpmutil.Handle(h)
+	}
+	if pcrs, ok := opts["pcrs"].(string); ok {
+		var err error
+		if p.pcrs, err = parsePCRs(pcrs); err != nil {
+			return nil, err
+		}
+	}
+
+	if dir, ok := opts["directory"].(string); ok && dir != "" {
+		p.certDir = filepath.Join(dir, "certs")
+	} else {
+		homeDir, err := os.UserHomeDir()
+		if err != nil {
+			homeDir = "."
+		}
+		p.certDir = filepath.Join(homeDir, ".pica", "tpm", "certs")
+	}
+	return p, nil
+}
+
+// parsePCRs parses a list of PCR indexes such as "0,2,4,7"
+func parsePCRs(list string) ([]int, error) {
+	var pcrs []int
+	for _, s := range strings.FieldsFunc(list, func(r rune) bool { return r == ',' || r == ' ' }) {
+		pcr, err := strconv.Atoi(s)
+		if err != nil || pcr < 0 || pcr > 23 {
+			return nil, fmt.Errorf("invalid PCR %q, expected 0 to 23", s)
+		}
+		pcrs = append(pcrs, pcr)
+	}
+	sort.Ints(pcrs)
+	return pcrs, nil
+}
+
+// IsTPMPresent checks whether the kernel exposes a TPM
+func IsTPMPresent() bool {
+	for _, dev := range tpmDevices {
+		if _, err := os.Stat(dev); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// Handle returns the persistent handle of a slot's key
+func (p *TPMProvider) Handle(slot Slot) tpmutil.Handle {
+	return p.handleBase + tpmutil.Handle(slot)
+}
+
+// Type returns the type of the provider
+func (p *TPMProvider) Type() ProviderType {
+	return TPMProviderType
+}
+
+// Name returns a human-readable name for the provider
+func (p *TPMProvider) Name() string {
+	return p.name
+}
+
+// Connect opens the TPM device or the simulator's socket
+func (p *TPMProvider) Connect() error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.connected {
+		return nil
+	}
+	if err := os.MkdirAll(p.certDir, 0700); err != nil {
+		return fmt.Errorf("failed to create certificate directory: %w", err)
+	}
+
+	devices := tpmDevices
+	if p.device != "" {
+		devices = []string{p.device}
+	}
+	var err error
+	for _, dev := range devices {
+		if p.rw, err = openTPMDevice(dev); err == nil {
+			fi, _ := os.Stat(dev)
+			p.emulator = fi != nil && fi.Mode()&os.ModeSocket != 0
+			break
+		}
+	}
+	if err != nil {
+		if p.device == "" && errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("no TPM found at %s", strings.Join(tpmDevices, " or "))
+		}
+		return fmt.Errorf("failed to open TPM: %w", err)
+	}
+
+	// The kernel starts a real TPM; a simulator may just have been powered on
+	if p.emulator {
+		if err := tpm2.Startup(p.rw, tpm2.StartupClear); err != nil && !isTPMInitialized(err) {
+			return fmt.Errorf("failed to start TPM simulator: %w", err)
+		}
+	}
+	p.connected = true
+	return nil
+}
+
+// isTPMInitialized reports whether Startup failed because the TPM had
+// already been started
+func isTPMInitialized(err error) bool {
+	var tpmErr tpm2.Error
+	return errors.As(err, &tpmErr) && tpmErr.Code == tpm2.RCInitialize
+}
+
+// Close terminates the connection to the provider
+func (p *TPMProvider) Close() error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if !p.connected {
+		return nil
+	}
+	p.connected = false
+	if p.emulator {
+		// Simulator connections last one command and are already closed
+		return nil
+	}
+	return p.rw.Close()
+}
+
+// persistentHandles returns the slot handles that hold a key
+func (p *TPMProvider) persistentHandles() (map[tpmutil.Handle]bool, error) {
+	handles := make(map[tpmutil.Handle]bool)
+	next := uint32(p.handleBase)
+	for {
+		vals, more, err := tpm2.GetCapability(p.rw, tpm2.CapabilityHandles, 64, next)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list TPM handles: %w", err)
+		}
+		for _, v := range vals {
+			h, ok := v.(tpmutil.Handle)
+			if !ok || h > p.handleBase+0xFF {
+				return handles, nil
+			}
+			handles[h] = true
+			next = uint32(h) + 1
+		}
+		if !more || len(vals) == 0 {
+			return handles, nil
+		}
+	}
+}
+
+// pcrSelection returns the PCRs keys are bound to
+func (p *TPMProvider) pcrSelection() tpm2.PCRSelection {
+	return tpm2.PCRSelection{Hash: tpm2.AlgSHA256, PCRs: p.pcrs}
+}
+
+// policySession starts a session satisfying the PCR policy of the keys; a
+// trial session computes the policy digest instead
+func (p *TPMProvider) policySession(trial bool) (tpmutil.Handle, error) {
+	sessionType := tpm2.SessionPolicy
+	if trial {
+		sessionType = tpm2.SessionTrial
+	}
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return tpm2.HandleNull, err
+	}
+	session, _, err := tpm2.StartAuthSession(p.rw, tpm2.HandleNull, tpm2.HandleNull,
+		nonce, nil, sessionType, tpm2.AlgNull, tpm2.AlgSHA256)
+	if err != nil {
+		return tpm2.HandleNull, fmt.Errorf("failed to start TPM session: %w", err)
+	}
+	if err := tpm2.PolicyPCR(p.rw, session, nil, p.pcrSelection()); err != nil {
+		tpm2.FlushContext(p.rw, session)
+		return tpm2.HandleNull, fmt.Errorf("PCR policy not satisfied: %w", err)
+	}
+	return session, nil
+}
+
+// tpmKeyTemplate returns the public area of a signing key. The signature
+// scheme is left open so RSA keys sign with PKCS#1 v1.5 or PSS as asked.
+func tpmKeyTemplate(algorithm string, bits int) (tpm2.Public, error) {
+	public := tpm2.Public{
+		NameAlg: tpm2.AlgSHA256,
+		Attributes: tpm2.FlagSign | tpm2.FlagFixedTPM | tpm2.FlagFixedParent |
+			tpm2.FlagSensitiveDataOrigin | tpm2.FlagUserWithAuth | tpm2.FlagNoDA,
+	}
+	switch {
+	case algorithm == "ECDSA" && bits == 256:
+		public.Type = tpm2.AlgECC
+		public.ECCParameters = &tpm2.ECCParams{CurveID: tpm2.CurveNISTP256}
+	case algorithm == "ECDSA" && bits == 384:
+		public.Type = tpm2.AlgECC
+		public.ECCParameters = &tpm2.ECCParams{CurveID: tpm2.CurveNISTP384}
+	case algorithm == "RSA" && (bits == 2048 || bits == 3072):
+		public.Type = tpm2.AlgRSA
+		public.RSAParameters = &tpm2.RSAParams{KeyBits: uint16(bits)}
+	default:
+		return public, fmt.Errorf("%w: %s %d is not supported by the TPM provider", ErrInvalidAlgorithm, algorithm, bits)
+	}
+	return public, nil
+}
+
+// GenerateKey creates a key under the storage root key and makes it
+// persistent at the slot's handle. With PCRs configured, the key only
+// signs while they hold their current values.
+func (p *TPMProvider) GenerateKey(slot Slot, algorithm string, bits int) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if !p.connected {
+		return ErrNotConnected
+	}
+	public, err := tpmKeyTemplate(algorithm, bits)
+	if err != nil {
+		return err
+	}
+	handles, err := p.persistentHandles()
+	if err != nil {
+		return err
+	}
+	if handles[p.Handle(slot)] {
+		return fmt.Errorf("%w: TPM handle %#x", ErrSlotInUse, uint32(p.Handle(slot)))
+	}
+
+	if len(p.pcrs) > 0 {
+		session, err := p.policySession(true)
+		if err != nil {
+			return err
+		}
+		digest, err := tpm2.PolicyGetDigest(p.rw, session)
+		tpm2.FlushContext(p.rw, session)
+		if err != nil {
+			return fmt.Errorf("failed to compute PCR policy: %w", err)
+		}
+		public.AuthPolicy = digest
+		public.Attributes &^= tpm2.FlagUserWithAuth
+	}
+
+	srk, _, err := tpm2.CreatePrimary(p.rw, tpm2.HandleOwner, tpm2.PCRSelection{}, p.ownerPassword, "", tpmSRKTemplate)
+	if err != nil {
+		return fmt.Errorf("failed to create TPM storage root key: %w", err)
+	}
+	defer tpm2.FlushContext(p.rw, srk)
+
+	private, publicBlob, _, _, _, err := tpm2.CreateKey(p.rw, srk, tpm2.PCRSelection{}, "", "", public)
+	if err != nil {
+		return fmt.Errorf("failed to create TPM key: %w", err)
+	}
+	key, _, err := tpm2.Load(p.rw, srk, "", publicBlob, private)
+	if err != nil {
+		return fmt.Errorf("failed to load TPM key: %w", err)
+	}
+	defer tpm2.FlushContext(p.rw, key)
+
+	if err := tpm2.EvictControl(p.rw, p.ownerPassword, tpm2.HandleOwner, key, p.Handle(slot)); err != nil {
+		return fmt.Errorf("failed to persist TPM key: %w", err)
+	}
+	return nil
+}
+
+// readPublic returns the public area of a slot's key
+func (p *TPMProvider) readPublic(slot Slot) (tpm2.Public, error) {
+	handles, err := p.persistentHandles()
+	if err != nil {
+		return tpm2.Public{}, err
+	}
+	if !handles[p.Handle(slot)] {
+		return tpm2.Public{}, ErrKeyNotFound
+	}
+	public, _, _, err := tpm2.ReadPublic(p.rw, p.Handle(slot))
+	if err != nil {
+		return tpm2.Public{}, fmt.Errorf("failed to read TPM key: %w", err)
+	}
+	return public, nil
+}
+
+// GetPublicKey retrieves the public key from a slot
+func (p *TPMProvider) GetPublicKey(slot Slot) (crypto.PublicKey, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if !p.connected {
+		return nil, ErrNotConnected
+	}
+	public, err := p.readPublic(slot)
+	if err != nil {
+		return nil, err
+	}
+	return public.Key()
+}
+
+// Sign signs a digest with the slot's key. RSA-PSS signatures use the salt
+// length of the TPM, which is the digest size on current TPMs.
+func (p *TPMProvider) Sign(slot Slot, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if !p.connected {
+		return nil, ErrNotConnected
+	}
+	public, err := p.readPublic(slot)
+	if err != nil {
+		return nil, err
+	}
+	hash, err := tpm2.HashToAlgorithm(opts.HashFunc())
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidAlgorithm, err)
+	}
+
+	scheme := &tpm2.SigScheme{Alg: tpm2.AlgECDSA, Hash: hash}
+	if public.Type == tpm2.AlgRSA {
+		scheme.Alg = tpm2.AlgRSASSA
+		if _, ok := opts.(*rsa.PSSOptions); ok {
+			scheme.Alg = tpm2.AlgRSAPSS
+		}
+	}
+
+	var sig *tpm2.Signature
+	if len(public.AuthPolicy) > 0 {
+		session, err := p.policySession(false)
+		if err != nil {
+			return nil, err
+		}
+		defer tpm2.FlushContext(p.rw, session)
+		sig, err = tpm2.SignWithSession(p.rw, session, p.Handle(slot), "", digest, nil, scheme)
+		if err != nil {
+			return nil, fmt.Errorf("TPM signing failed: %w", err)
+		}
+	} else {
+		sig, err = tpm2.Sign(p.rw, p.Handle(slot), "", digest, nil, scheme)
+		if err != nil {
+			return nil, fmt.Errorf("TPM signing failed: %w", err)
+		}
+	}
+
+	if sig.ECC != nil {
+		return asn1.Marshal(struct{ R, S *big.Int }{sig.ECC.R, sig.ECC.S})
+	}
+	if sig.RSA != nil {
+		return sig.RSA.Signature, nil
+	}
+	return nil, fmt.Errorf("unexpected TPM signature algorithm %v", sig.Alg)
+}
+
+// ImportCertificate stores the certificate of a slot locally
+func (p *TPMProvider) ImportCertificate(slot Slot, cert *x509.Certificate) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if !p.connected {
+		return ErrNotConnected
+	}
+	if cert == nil {
+		return ErrInvalidCertificate
+	}
+	data := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+	if err := os.WriteFile(p.certFile(slot), data, 0644); err != nil {
+		return fmt.Errorf("failed to save certificate: %w", err)
+	}
+	return nil
+}
+
+// GetCertificate retrieves the certificate of a slot
+func (p *TPMProvider) GetCertificate(slot Slot) (*x509.Certificate, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if !p.connected {
+		return nil, ErrNotConnected
+	}
+	return p.readCertificate(slot)
+}
+
+func (p *TPMProvider) readCertificate(slot Slot) (*x509.Certificate, error) {
+	data, err := os.ReadFile(p.certFile(slot))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrCertNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%w: no PEM data in %s", ErrInvalidCertificate, p.certFile(slot))
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+// certFile returns the certificate file of a slot, named after its handle
+func (p *TPMProvider) certFile(slot Slot) string {
+	return filepath.Join(p.certDir, fmt.Sprintf("%08x.pem", uint32(p.Handle(slot))))
+}
+
+// ListSlots reports the slots whose keys are persistent in the TPM or whose
+// certificates are stored locally
+func (p *TPMProvider) ListSlots() ([]SlotInfo, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if !p.connected {
+		return nil, ErrNotConnected
+	}
+	handles, err := p.persistentHandles()
+	if err != nil {
+		return nil, err
+	}
+
+	infos := make(map[Slot]*SlotInfo)
+	for h := range handles {
+		slot := Slot(h - p.handleBase)
+		public, _, _, err := tpm2.ReadPublic(p.rw, h)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read TPM key %#x: %w", uint32(h), err)
+		}
+		info := &SlotInfo{Slot: slot, HasKey: true, Origin: "generated"}
+		if pub, err := public.Key(); err == nil {
+			info.Algorithm, info.Bits = keyParameters(pub)
+		}
+		if len(public.AuthPolicy) > 0 {
+			info.PINPolicy = "pcr"
+		}
+		infos[slot] = info
+	}
+
+	for slot := Slot(0); slot <= 0xFF; slot++ {
+		cert, err := p.readCertificate(slot)
+		if err != nil {
+			continue
+		}
+		info, ok := infos[slot]
+		if !ok {
+			info = &SlotInfo{Slot: slot}
+			infos[slot] = info
+		}
+		info.Certificate = cert
+	}
+
+	slots := make([]SlotInfo, 0, len(infos))
+	for _, info := range infos {
+		slots = append(slots, *info)
+	}
+	sort.Slice(slots, func(i, j int) bool { return slots[i].Slot < slots[j].Slot })
+	return slots, nil
+}
+
+// DeleteKey evicts the slot's key from the TPM and removes its certificate
+func (p *TPMProvider) DeleteKey(slot Slot) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if !p.connected {
+		return ErrNotConnected
+	}
+	handles, err := p.persistentHandles()
+	if err != nil {
+		return err
+	}
+	hadKey := handles[p.Handle(slot)]
+	if hadKey {
+		h := p.Handle(slot)
+		if err := tpm2.EvictControl(p.rw, p.ownerPassword, tpm2.HandleOwner, h, h); err != nil {
+			return fmt.Errorf("failed to evict TPM key: %w", err)
+		}
+	}
+	certErr := os.Remove(p.certFile(slot))
+	if certErr != nil && !errors.Is(certErr, os.ErrNotExist) {
+		return certErr
+	}
+	if !hadKey && certErr != nil {
+		return ErrKeyNotFound
+	}
+	return nil
+}
+
+// IsHardware returns true unless the provider talks to a simulator
+func (p *TPMProvider) IsHardware() bool {
+	return !p.emulator
+}
+
+// Register the provider
+func init() {
+	Register(Registration{
+		Type:        TPMProviderType,
+		Description: "Keys in a TPM 2.0, optionally bound to PCR values",
+		Options: []Option{
+			{Name: "device", Type: OptionString, Description: "TPM device or simulator socket (default /dev/tpmrm0, then /dev/tpm0)"},
+			{Name: "handle_base", Type: OptionString, Description: "Persistent handle of slot 0, in hex (default 81500000)"},
+			{Name: "pcrs", Type: OptionString, Description: "SHA-256 PCRs new keys are bound to, e.g. \"0,2,4,7\""},
+			{Name: "owner_password", Type: OptionString, Description: "Authorization of the owner hierarchy", Secret: true},
+			{Name: "directory", Type: OptionString, Description: "Directory holding the certificates (default ~/.pica/tpm)"},
+		},
+		Factory:  NewTPMProvider,
+		Detect:   IsTPMPresent,
+		Priority: 5,
+	})
+}
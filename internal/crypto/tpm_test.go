@@ -0,0 +1,207 @@
+package crypto
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/google/go-tpm/legacy/tpm2"
+	"github.com/google/go-tpm/tpmutil"
+)
+
+func TestTPMProviderOptions(t *testing.T) {
+	p, err := NewTPMProvider(map[string]interface{}{"handle_base": "0x81600000", "pcrs": "7, 0,4"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tp := p.(*TPMProvider)
+	if tp.Handle(SlotCA1) != 0x81600082 {
+		t.Errorf("Unexpected handle of slot 82: %#x", uint32(tp.Handle(SlotCA1)))
+	}
+	if !reflect.DeepEqual(tp.pcrs, []int{0, 4, 7}) {
+		t.Errorf("Unexpected PCRs: %v", tp.pcrs)
+	}
+
+	for _, opts := range []map[string]interface{}{
+		{"handle_base": "zz"},
+		{"handle_base": "80000000"},
+		{"handle_base": "817FFFF0"},
+		{"pcrs": "24"},
+		{"pcrs": "seven"},
+	} {
+		if _, err := NewTPMProvider(opts); err == nil {
+			t.Errorf("Expected an error for options %v", opts)
+		}
+	}
+
+	for _, key := range []struct {
+		algorithm string
+		bits      int
+	}{{"ECDSA", 521}, {"RSA", 1024}, {"Ed25519", 256}} {
+		if _, err := tpmKeyTemplate(key.algorithm, key.bits); !errors.Is(err, ErrInvalidAlgorithm) {
+			t.Errorf("Expected ErrInvalidAlgorithm for %s %d, got %v", key.algorithm, key.bits, err)
+		}
+	}
+}
+
+// TestTPMProvider runs against a TPM simulator when PICA_TPM_SIMULATOR names
+// its socket, e.g. after
+//
+//	swtpm socket --tpm2 --tpmstate dir=/tmp/swtpm --flags startup-clear \
+//	  --server type=unixio,path=/tmp/swtpm.sock --ctrl type=unixio,path=/tmp/swtpm.ctrl
+func TestTPMProvider(t *testing.T) {
+	socket := os.Getenv("PICA_TPM_SIMULATOR")
+	if socket == "" {
+		t.Skip("PICA_TPM_SIMULATOR not set")
+	}
+	p, err := OpenProvider(TPMProviderType, map[string]interface{}{
+		"device":      socket,
+		"handle_base": "81500100",
+		"directory":   t.TempDir(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	// Leftovers of an interrupted run
+	for _, slot := range []Slot{SlotCA1, SlotCA2, SlotSSH} {
+		p.DeleteKey(slot)
+	}
+
+	if p.IsHardware() {
+		t.Error("Expected a simulator not to be reported as hardware")
+	}
+	if _, err := p.GetPublicKey(SlotCA1); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("Expected ErrKeyNotFound for an empty slot, got %v", err)
+	}
+
+	// ECDSA P-384
+	if err := p.GenerateKey(SlotCA1, "ECDSA", 384); err != nil {
+		t.Fatal(err)
+	}
+	defer p.DeleteKey(SlotCA1)
+	if err := p.GenerateKey(SlotCA1, "ECDSA", 384); !errors.Is(err, ErrSlotInUse) {
+		t.Errorf("Expected ErrSlotInUse regenerating a TPM key, got %v", err)
+	}
+	pub, err := p.GetPublicKey(SlotCA1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest := sha512.Sum384([]byte("tpm"))
+	sig, err := p.Sign(SlotCA1, digest[:], crypto.SHA384)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ecdsa.VerifyASN1(pub.(*ecdsa.PublicKey), digest[:], sig) {
+		t.Error("ECDSA signature from the TPM does not verify")
+	}
+
+	// RSA with both paddings
+	if err := p.GenerateKey(SlotCA2, "RSA", 2048); err != nil {
+		t.Fatal(err)
+	}
+	defer p.DeleteKey(SlotCA2)
+	rsaPub, err := p.GetPublicKey(SlotCA2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest256 := sha256.Sum256([]byte("tpm"))
+	sig, err = p.Sign(SlotCA2, digest256[:], crypto.SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rsa.VerifyPKCS1v15(rsaPub.(*rsa.PublicKey), crypto.SHA256, digest256[:], sig); err != nil {
+		t.Errorf("PKCS#1 v1.5 signature from the TPM does not verify: %v", err)
+	}
+	pss := &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: crypto.SHA256}
+	sig, err = p.Sign(SlotCA2, digest256[:], pss)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rsa.VerifyPSS(rsaPub.(*rsa.PublicKey), crypto.SHA256, digest256[:], sig, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthAuto}); err != nil {
+		t.Errorf("PSS signature from the TPM does not verify: %v", err)
+	}
+
+	// Certificates and inventory
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "TPM CA"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, pub, &ProviderSigner{Provider: p, Slot: SlotCA1, PublicKey: pub})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	if err := p.ImportCertificate(SlotCA1, cert); err != nil {
+		t.Fatal(err)
+	}
+	slots, err := p.ListSlots()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(slots) != 2 || slots[0].Slot != SlotCA1 || slots[0].Bits != 384 || slots[0].Certificate == nil ||
+		slots[1].Slot != SlotCA2 || slots[1].Algorithm != "RSA" {
+		t.Errorf("Unexpected inventory: %+v", slots)
+	}
+
+	if err := p.DeleteKey(SlotCA2); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.DeleteKey(SlotCA2); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("Expected ErrKeyNotFound deleting an empty slot, got %v", err)
+	}
+}
+
+func TestTPMProviderPCRPolicy(t *testing.T) {
+	socket := os.Getenv("PICA_TPM_SIMULATOR")
+	if socket == "" {
+		t.Skip("PICA_TPM_SIMULATOR not set")
+	}
+	// PCR 16 is the debug PCR, which may be reset
+	p, err := OpenProvider(TPMProviderType, map[string]interface{}{
+		"device":      socket,
+		"handle_base": "81500100",
+		"pcrs":        "16",
+		"directory":   t.TempDir(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	rw := p.(*TPMProvider).rw
+	p.DeleteKey(SlotSSH)
+	if err := tpm2.PCRReset(rw, tpmutil.Handle(16)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := p.GenerateKey(SlotSSH, "ECDSA", 256); err != nil {
+		t.Fatal(err)
+	}
+	defer p.DeleteKey(SlotSSH)
+	digest := sha256.Sum256([]byte("sealed"))
+	if _, err := p.Sign(SlotSSH, digest[:], crypto.SHA256); err != nil {
+		t.Fatalf("Expected the key to sign with unchanged PCRs: %v", err)
+	}
+
+	if err := tpm2.PCRExtend(rw, tpmutil.Handle(16), tpm2.AlgSHA256, digest[:], ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.Sign(SlotSSH, digest[:], crypto.SHA256); err == nil {
+		t.Error("Expected signing to fail after the PCR changed")
+	}
+}
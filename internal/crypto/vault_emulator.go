@@ -0,0 +1,315 @@
+package crypto
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// VaultTransitEmulator serves the parts of the Vault Transit API that
+// VaultTransit uses, with keys in memory. It stands in for Vault in tests
+// and development; serve it with net/http or httptest.
+type VaultTransitEmulator struct {
+	// Token, when set, must be presented in X-Vault-Token
+	Token string
+	// Mount is the mount path of the engine, "transit" when empty
+	Mount string
+
+	mu   sync.Mutex
+	keys map[string]*emulatedKey
+}
+
+// emulatedKey is a Transit key with its versions
+type emulatedKey struct {
+	keyType         string
+	versions        []emulatedVersion
+	deletionAllowed bool
+}
+
+type emulatedVersion struct {
+	key     crypto.Signer
+	created time.Time
+}
+
+// NewVaultTransitEmulator creates an emulator without keys
+func NewVaultTransitEmulator(token string) *VaultTransitEmulator {
+	return &VaultTransitEmulator{Token: token, keys: make(map[string]*emulatedKey)}
+}
+
+// RotateKey adds a new version to a key, as Vault's rotate endpoint does
+func (e *VaultTransitEmulator) RotateKey(name string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	k, ok := e.keys[name]
+	if !ok {
+		return ErrKeyNotFound
+	}
+	signer, err := newEmulatedSigner(k.keyType)
+	if err != nil {
+		return err
+	}
+	k.versions = append(k.versions, emulatedVersion{key: signer, created: time.Now().UTC()})
+	return nil
+}
+
+// newEmulatedSigner generates a key of a Transit key type
+func newEmulatedSigner(keyType string) (crypto.Signer, error) {
+	switch keyType {
+	case "ecdsa-p256":
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case "ecdsa-p384":
+		return ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	case "ecdsa-p521":
+		return ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+	case "rsa-2048", "rsa-3072", "rsa-4096":
+		bits, _ := strconv.Atoi(strings.TrimPrefix(keyType, "rsa-"))
+		return rsa.GenerateKey(rand.Reader, bits)
+	}
+	return nil, fmt.Errorf("unsupported key type %q", keyType)
+}
+
+// ServeHTTP implements http.Handler
+func (e *VaultTransitEmulator) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if e.Token != "" && r.Header.Get("X-Vault-Token") != e.Token {
+		vaultReply(w, http.StatusForbidden, nil, "permission denied")
+		return
+	}
+	mount := e.Mount
+	if mount == "" {
+		mount = "transit"
+	}
+	path, ok := strings.CutPrefix(r.URL.Path, "/v1/"+mount+"/")
+	if !ok {
+		vaultReply(w, http.StatusNotFound, nil, "no handler for route")
+		return
+	}
+
+	var body map[string]interface{}
+	if r.Body != nil && r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			vaultReply(w, http.StatusBadRequest, nil, "failed to parse JSON input: "+err.Error())
+			return
+		}
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.keys == nil {
+		e.keys = make(map[string]*emulatedKey)
+	}
+
+	parts := strings.Split(path, "/")
+	switch {
+	case path == "keys" && (r.Method == "LIST" || r.URL.Query().Get("list") == "true"):
+		e.listKeys(w)
+	case len(parts) == 2 && parts[0] == "keys":
+		switch r.Method {
+		case http.MethodPost, http.MethodPut:
+			e.createKey(w, parts[1], body)
+		case http.MethodGet:
+			e.readKey(w, parts[1])
+		case http.MethodDelete:
+			e.deleteKey(w, parts[1])
+		default:
+			vaultReply(w, http.StatusMethodNotAllowed, nil, "unsupported operation")
+		}
+	case len(parts) == 3 && parts[0] == "keys" && parts[2] == "config" && r.Method != http.MethodGet:
+		e.configureKey(w, parts[1], body)
+	case (len(parts) == 2 || len(parts) == 3) && parts[0] == "sign" && r.Method != http.MethodGet:
+		hash := "sha2-256"
+		if len(parts) == 3 {
+			hash = parts[2]
+		}
+		e.sign(w, parts[1], hash, body)
+	default:
+		vaultReply(w, http.StatusNotFound, nil, "no handler for route")
+	}
+}
+
+// vaultReply writes a Transit response: data on success, errors otherwise
+func vaultReply(w http.ResponseWriter, status int, data interface{}, errs ...string) {
+	w.Header().Set("Content-Type", "application/json")
+	if status == http.StatusNoContent {
+		w.WriteHeader(status)
+		return
+	}
+	w.WriteHeader(status)
+	if len(errs) > 0 {
+		json.NewEncoder(w).Encode(map[string]interface{}{"errors": errs})
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"data": data})
+}
+
+func (e *VaultTransitEmulator) listKeys(w http.ResponseWriter) {
+	if len(e.keys) == 0 {
+		vaultReply(w, http.StatusNotFound, nil)
+		return
+	}
+	names := make([]string, 0, len(e.keys))
+	for name := range e.keys {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	vaultReply(w, http.StatusOK, map[string]interface{}{"keys": names})
+}
+
+func (e *VaultTransitEmulator) createKey(w http.ResponseWriter, name string, body map[string]interface{}) {
+	if _, ok := e.keys[name]; ok {
+		// Creating an existing key is a no-op in Vault
+		vaultReply(w, http.StatusNoContent, nil)
+		return
+	}
+	keyType, _ := body["type"].(string)
+	if keyType == "" {
+		keyType = "aes256-gcm96"
+	}
+	signer, err := newEmulatedSigner(keyType)
+	if err != nil {
+		vaultReply(w, http.StatusBadRequest, nil, err.Error())
+		return
+	}
+	e.keys[name] = &emulatedKey{
+		keyType:  keyType,
+		versions: []emulatedVersion{{key: signer, created: time.Now().UTC()}},
+	}
+	vaultReply(w, http.StatusNoContent, nil)
+}
+
+func (e *VaultTransitEmulator) readKey(w http.ResponseWriter, name string) {
+	k, ok := e.keys[name]
+	if !ok {
+		vaultReply(w, http.StatusNotFound, nil)
+		return
+	}
+	versions := make(map[string]interface{})
+	for i, v := range k.versions {
+		der, err := x509.MarshalPKIXPublicKey(v.key.Public())
+		if err != nil {
+			vaultReply(w, http.StatusInternalServerError, nil, err.Error())
+			return
+		}
+		versions[strconv.Itoa(i+1)] = map[string]interface{}{
+			"public_key":    string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})),
+			"creation_time": v.created.Format(time.RFC3339Nano),
+			"name":          k.keyType,
+		}
+	}
+	vaultReply(w, http.StatusOK, map[string]interface{}{
+		"name":                name,
+		"type":                k.keyType,
+		"latest_version":      len(k.versions),
+		"deletion_allowed":    k.deletionAllowed,
+		"supports_signing":    true,
+		"supports_encryption": false,
+		"keys":                versions,
+	})
+}
+
+func (e *VaultTransitEmulator) configureKey(w http.ResponseWriter, name string, body map[string]interface{}) {
+	k, ok := e.keys[name]
+	if !ok {
+		vaultReply(w, http.StatusBadRequest, nil, "no existing key named "+name+" could be found")
+		return
+	}
+	if allowed, ok := body["deletion_allowed"].(bool); ok {
+		k.deletionAllowed = allowed
+	}
+	vaultReply(w, http.StatusNoContent, nil)
+}
+
+func (e *VaultTransitEmulator) deleteKey(w http.ResponseWriter, name string) {
+	k, ok := e.keys[name]
+	if !ok {
+		vaultReply(w, http.StatusNoContent, nil)
+		return
+	}
+	if !k.deletionAllowed {
+		vaultReply(w, http.StatusBadRequest, nil, "deletion is not allowed for this key")
+		return
+	}
+	delete(e.keys, name)
+	vaultReply(w, http.StatusNoContent, nil)
+}
+
+func (e *VaultTransitEmulator) sign(w http.ResponseWriter, name, hashName string, body map[string]interface{}) {
+	k, ok := e.keys[name]
+	if !ok {
+		vaultReply(w, http.StatusBadRequest, nil, "signing key not found")
+		return
+	}
+	var hash crypto.Hash
+	for h, n := range vaultHashes {
+		if n == hashName {
+			hash = h
+		}
+	}
+	if hash == 0 {
+		vaultReply(w, http.StatusBadRequest, nil, "unsupported hash algorithm "+hashName)
+		return
+	}
+	if m, _ := body["marshaling_algorithm"].(string); m != "" && m != "asn1" {
+		vaultReply(w, http.StatusBadRequest, nil, "unsupported marshaling algorithm "+m)
+		return
+	}
+
+	input, err := base64.StdEncoding.DecodeString(fmt.Sprint(body["input"]))
+	if err != nil {
+		vaultReply(w, http.StatusBadRequest, nil, "unable to decode input as base64")
+		return
+	}
+	digest := input
+	if prehashed, _ := body["prehashed"].(bool); !prehashed {
+		h := hash.New()
+		h.Write(input)
+		digest = h.Sum(nil)
+	}
+	if len(digest) != hash.Size() {
+		vaultReply(w, http.StatusBadRequest, nil, "input is not a "+hashName+" digest")
+		return
+	}
+
+	version := len(k.versions)
+	signer := k.versions[version-1].key
+	var opts crypto.SignerOpts = hash
+	if _, isRSA := signer.(*rsa.PrivateKey); isRSA {
+		// Vault signs with PSS unless asked for PKCS#1 v1.5
+		if alg, _ := body["signature_algorithm"].(string); alg != "pkcs1v15" {
+			pss := &rsa.PSSOptions{Hash: hash, SaltLength: rsa.PSSSaltLengthAuto}
+			switch salt, _ := body["salt_length"].(string); salt {
+			case "", "auto":
+			case "hash":
+				pss.SaltLength = rsa.PSSSaltLengthEqualsHash
+			default:
+				if pss.SaltLength, err = strconv.Atoi(salt); err != nil {
+					vaultReply(w, http.StatusBadRequest, nil, "invalid salt length "+salt)
+					return
+				}
+			}
+			opts = pss
+		}
+	}
+
+	sig, err := signer.Sign(rand.Reader, digest, opts)
+	if err != nil {
+		vaultReply(w, http.StatusBadRequest, nil, err.Error())
+		return
+	}
+	vaultReply(w, http.StatusOK, map[string]interface{}{
+		"signature":   fmt.Sprintf("vault:v%d:%s", version, base64.StdEncoding.EncodeToString(sig)),
+		"key_version": version,
+	})
+}
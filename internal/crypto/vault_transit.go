@@ -0,0 +1,280 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// VaultTransit is a KMSBackend for the Transit secrets engine of HashiCorp
+// Vault and services implementing its API
+type VaultTransit struct {
+	// Address is the base URL of Vault, e.g. https://vault.example.com:8200
+	Address   string
+	Token     string
+	Namespace string
+	// Mount is the mount path of the Transit engine
+	Mount  string
+	Client *http.Client
+}
+
+// NewVaultTransit creates a Transit backend. Options: "address" and "token"
+// default to VAULT_ADDR and VAULT_TOKEN, "namespace" to VAULT_NAMESPACE,
+// "mount" to "transit"; "ca" verifies Vault's certificate and "timeout"
+// bounds each request.
+func NewVaultTransit(opts map[string]interface{}) (*VaultTransit, error) {
+	v := &VaultTransit{
+		Address:   os.Getenv("VAULT_ADDR"),
+		Token:     os.Getenv("VAULT_TOKEN"),
+		Namespace: os.Getenv("VAULT_NAMESPACE"),
+		Mount:     "transit",
+	}
+	for opt, field := range map[string]*string{
+		"address":   &v.Address,
+		"token":     &v.Token,
+		"namespace": &v.Namespace,
+		"mount":     &v.Mount,
+	} {
+		if s, ok := opts[opt].(string); ok && s != "" {
+			*field = s
+		}
+	}
+	if v.Address == "" {
+		return nil, errors.New("the Vault KMS backend needs an address")
+	}
+	v.Address = strings.TrimSuffix(v.Address, "/")
+	v.Mount = strings.Trim(v.Mount, "/")
+
+	timeout := defaultPluginTimeout
+	if t, ok := opts["timeout"].(time.Duration); ok && t > 0 {
+		timeout = t
+	}
+	v.Client = &http.Client{Timeout: timeout}
+	if caFile, ok := opts["ca"].(string); ok && caFile != "" {
+		data, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read Vault CA: %w", err)
+		}
+		roots := x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificates in Vault CA file %s", caFile)
+		}
+		v.Client.Transport = &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots, MinVersion: tls.VersionTLS12}}
+	}
+	return v, nil
+}
+
+// vaultError is an error response of Vault
+type vaultError struct {
+	status int
+	errors []string
+}
+
+func (e *vaultError) Error() string {
+	if len(e.errors) == 0 {
+		return fmt.Sprintf("vault: HTTP %d", e.status)
+	}
+	return "vault: " + strings.Join(e.errors, "; ")
+}
+
+// request calls the Transit API. out receives the "data" of the response.
+func (v *VaultTransit) request(method, path string, in, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, v.Address+"/v1/"+v.Mount+"/"+path, body)
+	if err != nil {
+		return err
+	}
+	if v.Token != "" {
+		req.Header.Set("X-Vault-Token", v.Token)
+	}
+	if v.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", v.Namespace)
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := v.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("vault: %w", err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxPluginMessage))
+	if err != nil {
+		return fmt.Errorf("vault: %w", err)
+	}
+
+	if resp.StatusCode >= 300 {
+		verr := &vaultError{status: resp.StatusCode}
+		var e struct {
+			Errors []string `json:"errors"`
+		}
+		if json.Unmarshal(data, &e) == nil {
+			verr.errors = e.Errors
+		}
+		if resp.StatusCode == http.StatusNotFound {
+			return fmt.Errorf("%w: %v", ErrKeyNotFound, verr)
+		}
+		return verr
+	}
+	if out == nil || len(data) == 0 {
+		return nil
+	}
+	envelope := struct {
+		Data interface{} `json:"data"`
+	}{out}
+	if err := json.Unmarshal(data, &envelope); err != nil {
+		return fmt.Errorf("vault: invalid response: %w", err)
+	}
+	return nil
+}
+
+// vaultKeyType returns the Transit key type of an algorithm and size
+func vaultKeyType(algorithm string, bits int) (string, error) {
+	switch {
+	case algorithm == "ECDSA" && (bits == 256 || bits == 384 || bits == 521):
+		return fmt.Sprintf("ecdsa-p%d", bits), nil
+	case algorithm == "RSA" && (bits == 2048 || bits == 3072 || bits == 4096):
+		return fmt.Sprintf("rsa-%d", bits), nil
+	}
+	return "", fmt.Errorf("%w: %s %d is not a Vault Transit key type", ErrInvalidAlgorithm, algorithm, bits)
+}
+
+// vaultHashes maps hash functions to the hash algorithm names of Transit
+var vaultHashes = map[crypto.Hash]string{
+	crypto.SHA1:     "sha1",
+	crypto.SHA224:   "sha2-224",
+	crypto.SHA256:   "sha2-256",
+	crypto.SHA384:   "sha2-384",
+	crypto.SHA512:   "sha2-512",
+	crypto.SHA3_224: "sha3-224",
+	crypto.SHA3_256: "sha3-256",
+	crypto.SHA3_384: "sha3-384",
+	crypto.SHA3_512: "sha3-512",
+}
+
+// CreateKey creates a Transit key
+func (v *VaultTransit) CreateKey(name, algorithm string, bits int) error {
+	keyType, err := vaultKeyType(algorithm, bits)
+	if err != nil {
+		return err
+	}
+	return v.request(http.MethodPost, "keys/"+url.PathEscape(name), map[string]interface{}{"type": keyType}, nil)
+}
+
+// GetKey returns the latest version of a Transit key
+func (v *VaultTransit) GetKey(name string) (*KMSKey, error) {
+	var data struct {
+		Type          string `json:"type"`
+		LatestVersion int    `json:"latest_version"`
+		Keys          map[string]struct {
+			PublicKey    string    `json:"public_key"`
+			CreationTime time.Time `json:"creation_time"`
+		} `json:"keys"`
+	}
+	if err := v.request(http.MethodGet, "keys/"+url.PathEscape(name), nil, &data); err != nil {
+		return nil, err
+	}
+	version, ok := data.Keys[strconv.Itoa(data.LatestVersion)]
+	if !ok {
+		return nil, fmt.Errorf("vault: key %s has no version %d", name, data.LatestVersion)
+	}
+	block, _ := pem.Decode([]byte(version.PublicKey))
+	if block == nil {
+		return nil, fmt.Errorf("%w: key %s of type %s has no PEM public key", ErrInvalidKeyType, name, data.Type)
+	}
+	pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("vault: invalid public key of %s: %w", name, err)
+	}
+	return &KMSKey{Name: name, PublicKey: pub, Created: version.CreationTime}, nil
+}
+
+// Sign signs a digest with the latest version of a Transit key
+func (v *VaultTransit) Sign(name string, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	hash, ok := vaultHashes[opts.HashFunc()]
+	if !ok {
+		return nil, fmt.Errorf("%w: Vault Transit cannot sign %v digests", ErrInvalidAlgorithm, opts.HashFunc())
+	}
+	req := map[string]interface{}{
+		"input":                base64.StdEncoding.EncodeToString(digest),
+		"prehashed":            true,
+		"marshaling_algorithm": "asn1",
+		"signature_algorithm":  "pkcs1v15",
+	}
+	if pss, ok := opts.(*rsa.PSSOptions); ok {
+		req["signature_algorithm"] = "pss"
+		switch pss.SaltLength {
+		case rsa.PSSSaltLengthAuto:
+			req["salt_length"] = "auto"
+		case rsa.PSSSaltLengthEqualsHash:
+			req["salt_length"] = "hash"
+		default:
+			req["salt_length"] = strconv.Itoa(pss.SaltLength)
+		}
+	}
+
+	var data struct {
+		Signature string `json:"signature"`
+	}
+	if err := v.request(http.MethodPost, "sign/"+url.PathEscape(name)+"/"+hash, req, &data); err != nil {
+		return nil, err
+	}
+	// Signatures look like vault:v1:<base64>
+	parts := strings.SplitN(data.Signature, ":", 3)
+	if len(parts) != 3 || parts[0] != "vault" {
+		return nil, fmt.Errorf("vault: unexpected signature format %q", data.Signature)
+	}
+	sig, err := base64.StdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("vault: invalid signature: %w", err)
+	}
+	return sig, nil
+}
+
+// DeleteKey allows deletion of a Transit key and deletes it
+func (v *VaultTransit) DeleteKey(name string) error {
+	// Vault reports a missing key as a bad request here
+	if _, err := v.GetKey(name); err != nil {
+		return err
+	}
+	if err := v.request(http.MethodPost, "keys/"+url.PathEscape(name)+"/config",
+		map[string]interface{}{"deletion_allowed": true}, nil); err != nil {
+		return err
+	}
+	return v.request(http.MethodDelete, "keys/"+url.PathEscape(name), nil, nil)
+}
+
+// ListKeys returns the names of the Transit keys
+func (v *VaultTransit) ListKeys() ([]string, error) {
+	var data struct {
+		Keys []string `json:"keys"`
+	}
+	err := v.request("LIST", "keys", nil, &data)
+	if errors.Is(err, ErrKeyNotFound) {
+		// Vault answers 404 when there are no keys at all
+		return nil, nil
+	}
+	return data.Keys, err
+}
@@ -3,32 +3,73 @@ package crypto
 import (
 	"crypto"
 	"crypto/x509"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"os"
+	"sync"
 
 	"github.com/billchurch/PiCA/internal/yubikey"
 )
 
 // YubiKeyProvider implements the Provider interface using a YubiKey
 type YubiKeyProvider struct {
-	name      string
-	yubikey   *yubikey.YubiKey
-	connected bool
+	name          string
+	reader        string
+	pin           string
+	managementKey []byte
+	credentials   CredentialCallback
+	yubikey       *yubikey.YubiKey
+	connected     bool
+
+	// mutex serializes operations that present credentials
+	mutex sync.Mutex
 }
 
-// NewYubiKeyProvider creates a new YubiKey-based provider
+// NewYubiKeyProvider creates a new YubiKey-based provider. Options:
+// "reader" names the PC/SC reader ("virtual" for the built-in virtual
+// card, PICA_YUBIKEY_READER when unset), "pin" unlocks signing and
+// "management_key" is the hex encoded management key. Credentials that
+// are not configured come from EnvCredentials until a callback is set.
 func NewYubiKeyProvider(opts map[string]interface{}) (Provider, error) {
 	name := "YubiKey Provider"
 	if n, ok := opts["name"].(string); ok && n != "" {
 		name = n
 	}
 
+	reader, _ := opts["reader"].(string)
+	if reader == "" {
+		reader = os.Getenv("PICA_YUBIKEY_READER")
+	}
+	pin, _ := opts["pin"].(string)
+
+	var managementKey []byte
+	if key, ok := opts["management_key"].(string); ok && key != "" {
+		var err error
+		if managementKey, err = hex.DecodeString(key); err != nil {
+			return nil, fmt.Errorf("invalid management key: %w", err)
+		}
+	}
+
 	return &YubiKeyProvider{
-		name:      name,
-		yubikey:   nil,
-		connected: false,
+		name:          name,
+		reader:        reader,
+		pin:           pin,
+		managementKey: managementKey,
+		credentials:   EnvCredentials(),
+		yubikey:       nil,
+		connected:     false,
 	}, nil
 }
 
+// SetCredentialCallback sets where the provider gets the PIN and the
+// management key when they are not configured
+func (p *YubiKeyProvider) SetCredentialCallback(cb CredentialCallback) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.credentials = cb
+}
+
 // Type returns the type of the provider
 func (p *YubiKeyProvider) Type() ProviderType {
 	return YubiKeyProviderType
@@ -42,11 +83,22 @@ func (p *YubiKeyProvider) Name() string {
 // Connect establishes a connection to the provider
 func (p *YubiKeyProvider) Connect() error {
 	// Connect to the YubiKey
-	yk, err := yubikey.Connect()
+	var yk *yubikey.YubiKey
+	var err error
+	switch p.reader {
+	case "":
+		yk, err = yubikey.Connect()
+	case yubikey.VirtualReader:
+		// The virtual card starts empty and forgets its keys when closed
+		yk, err = yubikey.Open(yubikey.NewVirtualCard())
+	default:
+		yk, err = yubikey.ConnectReader(p.reader)
+	}
 	if err != nil {
 		return fmt.Errorf("failed to connect to YubiKey: %w", err)
 	}
 
+	yk.ManagementKey = p.managementKey
 	p.yubikey = yk
 	p.connected = true
 	return nil
@@ -74,9 +126,10 @@ func (p *YubiKeyProvider) GenerateKey(slot Slot, algorithm string, bits int) err
 	// Convert Provider Slot to YubiKey PIVSlot
 	pivSlot := yubikey.PIVSlot(slot)
 
-	// For now, just pass through to the YubiKey
-	// In a real implementation, we'd handle the algorithm and bits parameters
-	return p.yubikey.GenerateKey(pivSlot)
+	return p.withManagementKey(func() error {
+		_, err := p.yubikey.GenerateKey(pivSlot, algorithm, bits)
+		return err
+	})
 }
 
 // GetPublicKey retrieves the public key from a slot
@@ -88,7 +141,8 @@ func (p *YubiKeyProvider) GetPublicKey(slot Slot) (crypto.PublicKey, error) {
 	// Convert Provider Slot to YubiKey PIVSlot
 	pivSlot := yubikey.PIVSlot(slot)
 
-	return p.yubikey.GetPublicKey(pivSlot)
+	pub, err := p.yubikey.GetPublicKey(pivSlot)
+	return pub, yubikeyError(err)
 }
 
 // Sign signs data using the private key in the specified slot
@@ -100,9 +154,27 @@ func (p *YubiKeyProvider) Sign(slot Slot, digest []byte, opts crypto.SignerOpts)
 	// Convert Provider Slot to YubiKey PIVSlot
 	pivSlot := yubikey.PIVSlot(slot)
 
-	// The YubiKey.Sign method doesn't take SignerOpts, so ignore it for now
-	// In a real implementation, we'd handle the SignerOpts appropriately
-	return p.yubikey.Sign(pivSlot, digest)
+	var sig []byte
+	err := p.withPIN(func() error {
+		var err error
+		sig, err = p.yubikey.Sign(pivSlot, digest, opts)
+		return err
+	})
+	return sig, err
+}
+
+// ImportKey imports an existing private key into the specified slot
+func (p *YubiKeyProvider) ImportKey(slot Slot, key crypto.PrivateKey) error {
+	if !p.connected || p.yubikey == nil {
+		return ErrNotConnected
+	}
+
+	// Convert Provider Slot to YubiKey PIVSlot
+	pivSlot := yubikey.PIVSlot(slot)
+
+	return p.withManagementKey(func() error {
+		return p.yubikey.ImportKey(pivSlot, key)
+	})
 }
 
 // ImportCertificate imports a certificate into a slot
@@ -114,7 +186,9 @@ func (p *YubiKeyProvider) ImportCertificate(slot Slot, cert *x509.Certificate) e
 	// Convert Provider Slot to YubiKey PIVSlot
 	pivSlot := yubikey.PIVSlot(slot)
 
-	return p.yubikey.ImportCertificate(pivSlot, cert)
+	return p.withManagementKey(func() error {
+		return p.yubikey.ImportCertificate(pivSlot, cert)
+	})
 }
 
 // GetCertificate retrieves a certificate from a slot
@@ -126,7 +200,227 @@ func (p *YubiKeyProvider) GetCertificate(slot Slot) (*x509.Certificate, error) {
 	// Convert Provider Slot to YubiKey PIVSlot
 	pivSlot := yubikey.PIVSlot(slot)
 
-	return p.yubikey.GetCertificate(pivSlot)
+	cert, err := p.yubikey.GetCertificate(pivSlot)
+	return cert, yubikeyError(err)
+}
+
+// ListSlots reports the YubiKey slots that hold a key or a certificate.
+// The card does not record when keys were created; the policies and
+// origin need firmware 5.3 or later.
+func (p *YubiKeyProvider) ListSlots() ([]SlotInfo, error) {
+	if !p.connected || p.yubikey == nil {
+		return nil, ErrNotConnected
+	}
+
+	slots, err := p.yubikey.Slots()
+	if err != nil {
+		return nil, yubikeyError(err)
+	}
+	infos := make([]SlotInfo, 0, len(slots))
+	for _, s := range slots {
+		info := SlotInfo{Slot: Slot(s.Slot), HasKey: s.HasKey, Certificate: s.Certificate}
+		if s.HasKey {
+			info.Algorithm, info.Bits = keyParameters(s.PublicKey)
+		}
+		if s.HasKey && s.Algorithm != 0 {
+			info.PINPolicy = yubikey.PINPolicyName(s.PINPolicy)
+			info.TouchPolicy = yubikey.TouchPolicyName(s.TouchPolicy)
+			info.Origin = "imported"
+			if s.Generated {
+				info.Origin = "generated"
+			}
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+// DeleteKey removes the key and the certificate of a slot
+func (p *YubiKeyProvider) DeleteKey(slot Slot) error {
+	if !p.connected || p.yubikey == nil {
+		return ErrNotConnected
+	}
+
+	return p.withManagementKey(func() error {
+		return p.yubikey.DeleteKey(yubikey.PIVSlot(slot))
+	})
+}
+
+// AttestKey returns the YubiKey's attestation of a key generated in a slot
+// and the device attestation certificate
+func (p *YubiKeyProvider) AttestKey(slot Slot) ([]*x509.Certificate, error) {
+	if !p.connected || p.yubikey == nil {
+		return nil, ErrNotConnected
+	}
+
+	cert, err := p.yubikey.Attest(yubikey.PIVSlot(slot))
+	if err != nil {
+		return nil, yubikeyError(err)
+	}
+	device, err := p.yubikey.AttestationCertificate()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read attestation certificate: %w", yubikeyError(err))
+	}
+	return []*x509.Certificate{cert, device}, nil
+}
+
+// withPIN runs a private key operation, asking for the PIN when the key
+// needs one. The PIN is presented for this operation only; the card
+// forgets it when the next operation selects the PIV applet.
+func (p *YubiKeyProvider) withPIN(fn func() error) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	defer func() { p.yubikey.PIN = "" }()
+
+	p.yubikey.PIN = p.pin
+	retry := false
+	for {
+		err := fn()
+		var pinErr *yubikey.PINError
+		switch {
+		case errors.Is(err, yubikey.ErrPINRequired):
+		case errors.As(err, &pinErr):
+			retry = true
+		default:
+			return yubikeyError(err)
+		}
+		if p.credentials == nil {
+			return yubikeyError(err)
+		}
+
+		retries := -1
+		if pinErr != nil {
+			retries = pinErr.Retries
+		}
+		pin, cerr := p.credentials(CredentialRequest{Kind: CredentialPIN, Provider: p.name, Retries: retries, Retry: retry})
+		if cerr != nil {
+			if errors.Is(cerr, ErrCredentialUnavailable) {
+				return fmt.Errorf("%w: %w", yubikeyError(err), cerr)
+			}
+			return cerr
+		}
+		p.yubikey.PIN = pin
+	}
+}
+
+// withManagementKey runs an operation that needs the management key,
+// asking for it when the configured or default key is rejected
+func (p *YubiKeyProvider) withManagementKey(fn func() error) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.yubikey.ManagementKey = p.managementKey
+	defer func() { p.yubikey.ManagementKey = p.managementKey }()
+
+	retry := false
+	for {
+		err := fn()
+		if !errors.Is(err, yubikey.ErrWrongManagementKey) || p.credentials == nil {
+			return yubikeyError(err)
+		}
+
+		value, cerr := p.credentials(CredentialRequest{Kind: CredentialManagementKey, Provider: p.name, Retries: -1, Retry: retry})
+		if cerr != nil {
+			if errors.Is(cerr, ErrCredentialUnavailable) {
+				return fmt.Errorf("%w: %w", err, cerr)
+			}
+			return cerr
+		}
+		key, herr := hex.DecodeString(value)
+		if herr != nil {
+			return fmt.Errorf("invalid management key: %w", herr)
+		}
+		p.yubikey.ManagementKey = key
+		retry = true
+	}
+}
+
+// PINRetries returns the attempts left for the PIN and the PUK
+func (p *YubiKeyProvider) PINRetries() (int, int, error) {
+	if !p.connected || p.yubikey == nil {
+		return 0, 0, ErrNotConnected
+	}
+	pin, puk, err := p.yubikey.PINRetries()
+	return pin, puk, yubikeyError(err)
+}
+
+// VerifyPIN checks a PIN against the YubiKey
+func (p *YubiKeyProvider) VerifyPIN(pin string) error {
+	if !p.connected || p.yubikey == nil {
+		return ErrNotConnected
+	}
+	return yubikeyError(p.yubikey.VerifyPIN(pin))
+}
+
+// ChangePIN replaces the PIN
+func (p *YubiKeyProvider) ChangePIN(oldPIN, newPIN string) error {
+	if !p.connected || p.yubikey == nil {
+		return ErrNotConnected
+	}
+	return yubikeyError(p.yubikey.ChangePIN(oldPIN, newPIN))
+}
+
+// ChangePUK replaces the PUK
+func (p *YubiKeyProvider) ChangePUK(oldPUK, newPUK string) error {
+	if !p.connected || p.yubikey == nil {
+		return ErrNotConnected
+	}
+	return yubikeyError(p.yubikey.ChangePUK(oldPUK, newPUK))
+}
+
+// UnblockPIN sets a new PIN using the PUK
+func (p *YubiKeyProvider) UnblockPIN(puk, newPIN string) error {
+	if !p.connected || p.yubikey == nil {
+		return ErrNotConnected
+	}
+	return yubikeyError(p.yubikey.UnblockPIN(puk, newPIN))
+}
+
+// ChangeManagementKey replaces the management key, keeping its algorithm
+func (p *YubiKeyProvider) ChangeManagementKey(newKey string) error {
+	if !p.connected || p.yubikey == nil {
+		return ErrNotConnected
+	}
+	key, err := hex.DecodeString(newKey)
+	if err != nil {
+		return fmt.Errorf("invalid management key: %w", err)
+	}
+	return p.withManagementKey(func() error {
+		if err := p.yubikey.SetManagementKey(0, key); err != nil {
+			return err
+		}
+		p.managementKey = key
+		return nil
+	})
+}
+
+// yubikeyError maps errors of the yubikey package to provider errors
+func yubikeyError(err error) error {
+	var pinErr *yubikey.PINError
+	switch {
+	case err == nil:
+		return nil
+	case errors.As(err, &pinErr):
+		kind := CredentialPIN
+		if pinErr.PUK {
+			kind = CredentialPUK
+		}
+		return &CredentialError{Kind: kind, Retries: pinErr.Retries}
+	case errors.Is(err, yubikey.ErrPINBlocked):
+		return &CredentialError{Kind: CredentialPIN}
+	case errors.Is(err, yubikey.ErrPUKBlocked):
+		return &CredentialError{Kind: CredentialPUK}
+	case errors.Is(err, yubikey.ErrKeyNotFound):
+		return ErrKeyNotFound
+	case errors.Is(err, yubikey.ErrCertNotFound):
+		return ErrCertNotFound
+	case errors.Is(err, yubikey.ErrNotConnected):
+		return ErrNotConnected
+	case errors.Is(err, yubikey.ErrUnsupportedAlgorithm):
+		return fmt.Errorf("%w: %v", ErrInvalidAlgorithm, err)
+	case errors.Is(err, yubikey.ErrUnsupportedFirmware):
+		return fmt.Errorf("%w: %v", ErrOperationNotSupported, err)
+	}
+	return err
 }
 
 // IsHardware returns true for YubiKey-based provider
@@ -136,5 +430,16 @@ func (p *YubiKeyProvider) IsHardware() bool {
 
 // Register the provider
 func init() {
-	RegisterProvider(YubiKeyProviderType, NewYubiKeyProvider)
+	Register(Registration{
+		Type:        YubiKeyProviderType,
+		Description: "Keys in the PIV applet of a YubiKey",
+		Options: []Option{
+			{Name: "reader", Type: OptionString, Description: "PC/SC reader name, or \"virtual\" for the virtual test card"},
+			{Name: "pin", Type: OptionString, Description: "PIN to unlock signing", Secret: true},
+			{Name: "management_key", Type: OptionString, Description: "Hex encoded management key", Secret: true},
+		},
+		Factory:  NewYubiKeyProvider,
+		Detect:   IsYubiKeyPresent,
+		Priority: 10,
+	})
 }
@@ -0,0 +1,277 @@
+// Package signer implements pica-signer, a daemon that owns the CA keys and
+// signs on behalf of hosts that must never hold them. Clients use the
+// remote crypto provider; the daemon enforces a policy on what may be
+// signed and logs every signature.
+package signer
+
+import (
+	gocrypto "crypto"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strconv"
+
+	"github.com/billchurch/PiCA/internal/audit"
+	"github.com/billchurch/PiCA/internal/crypto"
+)
+
+// DefaultHashes are the digests a policy allows when it names none
+var DefaultHashes = []string{"SHA-256", "SHA-384", "SHA-512"}
+
+// Policy limits what clients of the signer may do
+type Policy struct {
+	// Slots are the hex slots whose keys may sign, e.g. "82"
+	Slots []string `json:"slots"`
+	// Hashes are the digest algorithms accepted, by the names of
+	// crypto.Hash, e.g. "SHA-256"; DefaultHashes when empty
+	Hashes []string `json:"hashes,omitempty"`
+	// Clients are the common names of the TLS client certificates allowed
+	// to connect; any certificate the client CA issued when empty. Unix
+	// socket clients are governed by the socket's permissions.
+	Clients []string `json:"clients,omitempty"`
+	// AllowKeyManagement permits generating and deleting keys and
+	// importing certificates
+	AllowKeyManagement bool `json:"allow_key_management,omitempty"`
+
+	slots  map[crypto.Slot]bool
+	hashes map[gocrypto.Hash]bool
+}
+
+// LoadPolicy reads a policy file
+func LoadPolicy(filename string) (*Policy, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read signer policy: %w", err)
+	}
+	p := &Policy{}
+	if err := json.Unmarshal(data, p); err != nil {
+		return nil, fmt.Errorf("failed to parse signer policy: %w", err)
+	}
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// DefaultPolicy allows signing with the keys of the given slots and the
+// default hashes
+func DefaultPolicy(slots ...crypto.Slot) *Policy {
+	p := &Policy{}
+	for _, slot := range slots {
+		p.Slots = append(p.Slots, fmt.Sprintf("%X", int(slot)))
+	}
+	if err := p.Validate(); err != nil {
+		panic(err)
+	}
+	return p
+}
+
+// Validate parses the slots and hashes of the policy
+func (p *Policy) Validate() error {
+	if len(p.Slots) == 0 {
+		return errors.New("signer policy allows no slots")
+	}
+	p.slots = make(map[crypto.Slot]bool)
+	for _, s := range p.Slots {
+		slot, err := strconv.ParseInt(s, 16, 64)
+		if err != nil {
+			return fmt.Errorf("invalid slot %q in signer policy (must be hex)", s)
+		}
+		p.slots[crypto.Slot(slot)] = true
+	}
+
+	hashes := p.Hashes
+	if len(hashes) == 0 {
+		hashes = DefaultHashes
+	}
+	p.hashes = make(map[gocrypto.Hash]bool)
+	for _, name := range hashes {
+		h, ok := hashByName(name)
+		if !ok {
+			return fmt.Errorf("unknown hash %q in signer policy", name)
+		}
+		p.hashes[h] = true
+	}
+	return nil
+}
+
+// hashByName finds a hash function by the name crypto.Hash.String returns
+func hashByName(name string) (gocrypto.Hash, bool) {
+	for h := gocrypto.MD4; h <= gocrypto.BLAKE2b_512; h++ {
+		if h.String() == name && h.Available() {
+			return h, true
+		}
+	}
+	return 0, false
+}
+
+// Check returns an error wrapping crypto.ErrNotPermitted when the policy
+// refuses a request of a client. client is the common name of a TLS
+// client certificate; remote is false for Unix socket clients, which the
+// Clients list does not restrict.
+func (p *Policy) Check(client string, remote bool, req *crypto.PluginRequest) error {
+	if remote && len(p.Clients) > 0 && (client == "" || !contains(p.Clients, client)) {
+		return fmt.Errorf("%w: client %q", crypto.ErrNotPermitted, client)
+	}
+
+	switch req.Method {
+	case crypto.PluginHello, crypto.PluginPublicKey, crypto.PluginCertificate, crypto.PluginListSlots:
+		// Public information
+		return nil
+
+	case crypto.PluginSign:
+		if !p.slots[req.Slot] {
+			return fmt.Errorf("%w: signing with slot %X", crypto.ErrNotPermitted, int(req.Slot))
+		}
+		h, ok := hashByName(req.Hash)
+		if !ok || !p.hashes[h] {
+			return fmt.Errorf("%w: digest algorithm %q", crypto.ErrNotPermitted, req.Hash)
+		}
+		if len(req.Digest) != h.Size() {
+			return fmt.Errorf("%w: %d byte digest for %s", crypto.ErrNotPermitted, len(req.Digest), h)
+		}
+		return nil
+
+	case crypto.PluginGenerateKey, crypto.PluginImportCertificate, crypto.PluginDeleteKey, crypto.PluginImportKey:
+		if !p.AllowKeyManagement {
+			return fmt.Errorf("%w: %s", crypto.ErrNotPermitted, req.Method)
+		}
+		return nil
+	}
+	return fmt.Errorf("%w: %s", crypto.ErrNotPermitted, req.Method)
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Server serves a provider to remote providers under a policy
+type Server struct {
+	Provider crypto.Provider
+	Policy   *Policy
+
+	plugin *crypto.PluginServer
+}
+
+// NewServer creates a signer for a connected provider
+func NewServer(provider crypto.Provider, policy *Policy) *Server {
+	s := &Server{Provider: provider, Policy: policy}
+	s.plugin = crypto.NewPluginServer(provider)
+	s.plugin.Authorize = func(conn net.Conn, req *crypto.PluginRequest) error {
+		client, remote := clientName(conn)
+		return s.Policy.Check(client, remote, req)
+	}
+	s.plugin.Signed = s.logSignature
+	return s
+}
+
+// Serve answers clients until the listener is closed
+func (s *Server) Serve(l net.Listener) error {
+	return s.plugin.Serve(l)
+}
+
+// logSignature logs a signature or a refused sign request and records it
+// in the audit log
+func (s *Server) logSignature(conn net.Conn, req *crypto.PluginRequest, err error) {
+	client, remote := clientName(conn)
+	if !remote {
+		client = "unix"
+	}
+	digest := hex.EncodeToString(req.Digest)
+	details := map[string]interface{}{
+		"slot":   fmt.Sprintf("%X", int(req.Slot)),
+		"hash":   req.Hash,
+		"digest": digest,
+		"client": client,
+	}
+
+	if err != nil {
+		log.Printf("Refused signature with slot %X for %s: %v", int(req.Slot), client, err)
+		details["error"] = err.Error()
+		audit.Record(audit.Event{Type: audit.EventSignRefused, Details: details})
+		return
+	}
+	log.Printf("Signed %s digest %s with slot %X for %s", req.Hash, digest, int(req.Slot), client)
+	audit.Record(audit.Event{Type: audit.EventSignature, Details: details})
+}
+
+// clientName returns the common name of a TLS client's certificate, which
+// may be empty, and whether the connection is a TLS one
+func clientName(conn net.Conn) (string, bool) {
+	tlsConn, ok := conn.(*tls.Conn)
+	if !ok {
+		return "", false
+	}
+	state := tlsConn.ConnectionState()
+	if len(state.PeerCertificates) == 0 {
+		return "", true
+	}
+	return state.PeerCertificates[0].Subject.CommonName, true
+}
+
+// Listen listens on a signer address: "unix:/path" or a TCP host:port,
+// which requires a TLS configuration. A stale socket is replaced and the
+// new one is only accessible to its owner and group.
+func Listen(addr string, tlsConfig *tls.Config) (net.Listener, error) {
+	network, address := crypto.SplitRemoteAddress(addr)
+	if network == "unix" {
+		if err := os.Remove(address); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("failed to remove stale socket: %w", err)
+		}
+		l, err := net.Listen(network, address)
+		if err != nil {
+			return nil, err
+		}
+		if err := os.Chmod(address, 0660); err != nil {
+			l.Close()
+			return nil, err
+		}
+		return l, nil
+	}
+
+	if tlsConfig == nil {
+		return nil, fmt.Errorf("listening on %s requires a TLS certificate, key and client CA", address)
+	}
+	l, err := net.Listen(network, address)
+	if err != nil {
+		return nil, err
+	}
+	return tls.NewListener(l, tlsConfig), nil
+}
+
+// ServerTLSConfig builds the server side of mutual TLS: clients must
+// present a certificate issued by the client CA
+func ServerTLSConfig(certFile, keyFile, clientCAFile string) (*tls.Config, error) {
+	if certFile == "" || keyFile == "" || clientCAFile == "" {
+		return nil, errors.New("mutual TLS needs a certificate, a key and a client CA")
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load signer certificate: %w", err)
+	}
+	pem, err := os.ReadFile(clientCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read client CA: %w", err)
+	}
+	clientCAs := x509.NewCertPool()
+	if !clientCAs.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates in client CA file %s", clientCAFile)
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientCAs:    clientCAs,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
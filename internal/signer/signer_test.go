@@ -0,0 +1,273 @@
+package signer
+
+import (
+	gocrypto "crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/billchurch/PiCA/internal/audit"
+	"github.com/billchurch/PiCA/internal/crypto"
+)
+
+// newTestServer creates a signer for a software provider with keys in
+// SlotCA1 and SlotCA2, under a policy that only allows SlotCA1
+func newTestServer(t *testing.T) *Server {
+	t.Helper()
+	backend, err := crypto.NewSoftwareProvider(map[string]interface{}{"directory": t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := backend.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	for _, slot := range []crypto.Slot{crypto.SlotCA1, crypto.SlotCA2} {
+		if err := backend.GenerateKey(slot, "ECDSA", 256); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return NewServer(backend, DefaultPolicy(crypto.SlotCA1))
+}
+
+func TestSignerPolicy(t *testing.T) {
+	auditLog, err := audit.Open(filepath.Join(t.TempDir(), "audit.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	audit.SetDefault(auditLog)
+	defer audit.SetDefault(nil)
+
+	server := newTestServer(t)
+	addr := "unix:" + filepath.Join(t.TempDir(), "signer.sock")
+	l, err := Listen(addr, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go server.Serve(l)
+
+	p, err := crypto.OpenProvider(crypto.RemoteProviderType, map[string]interface{}{"address": addr})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	if p.Type() != crypto.RemoteProviderType {
+		t.Errorf("Expected the remote provider type, got %s", p.Type())
+	}
+
+	pub, err := p.GetPublicKey(crypto.SlotCA1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest := sha256.Sum256([]byte("to be signed"))
+	sig, err := p.Sign(crypto.SlotCA1, digest[:], gocrypto.SHA256)
+	if err != nil {
+		t.Fatalf("Allowed signature refused: %v", err)
+	}
+	if !ecdsa.VerifyASN1(pub.(*ecdsa.PublicKey), digest[:], sig) {
+		t.Error("Remote signature does not verify")
+	}
+
+	sha1Digest := sha1.Sum([]byte("to be signed"))
+	refused := []struct {
+		name string
+		fn   func() error
+	}{
+		{"slot outside the policy", func() error {
+			_, err := p.Sign(crypto.SlotCA2, digest[:], gocrypto.SHA256)
+			return err
+		}},
+		{"hash outside the policy", func() error {
+			_, err := p.Sign(crypto.SlotCA1, sha1Digest[:], gocrypto.SHA1)
+			return err
+		}},
+		{"digest of the wrong length", func() error {
+			_, err := p.Sign(crypto.SlotCA1, digest[:20], gocrypto.SHA256)
+			return err
+		}},
+		{"key generation", func() error { return p.GenerateKey(crypto.SlotCA2, "ECDSA", 384) }},
+		{"key deletion", func() error { return p.DeleteKey(crypto.SlotCA1) }},
+	}
+	for _, r := range refused {
+		if err := r.fn(); !errors.Is(err, crypto.ErrNotPermitted) {
+			t.Errorf("%s: expected ErrNotPermitted, got %v", r.name, err)
+		}
+	}
+
+	// The key survived the refused deletion
+	if _, err := p.GetPublicKey(crypto.SlotCA1); err != nil {
+		t.Errorf("Key gone after a refused deletion: %v", err)
+	}
+
+	// Every sign request is in the audit log
+	events, err := audit.Read(auditLog.Path())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var signed, refusedCount int
+	for _, e := range events {
+		switch e.Type {
+		case audit.EventSignature:
+			signed++
+			if e.Details["slot"] != "82" || e.Details["client"] != "unix" {
+				t.Errorf("Unexpected signature event: %+v", e.Details)
+			}
+		case audit.EventSignRefused:
+			refusedCount++
+		}
+	}
+	if signed != 1 || refusedCount != 3 {
+		t.Errorf("Expected 1 signature and 3 refusals in the audit log, got %d and %d", signed, refusedCount)
+	}
+}
+
+// issue creates a certificate signed by parent, or a self-signed CA when
+// parent is nil, and writes it and its key as PEM files
+func issue(t *testing.T, dir, name string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey, template *x509.Certificate) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template.SerialNumber = big.NewInt(time.Now().UnixNano())
+	if len(template.Subject.Organization) == 0 {
+		template.Subject = pkix.Name{CommonName: name}
+	}
+	template.NotBefore = time.Now().Add(-time.Minute)
+	template.NotAfter = time.Now().Add(time.Hour)
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+	writePEM(t, filepath.Join(dir, name+".pem"), "CERTIFICATE", der)
+	writePEM(t, filepath.Join(dir, name+".key"), "EC PRIVATE KEY", keyDER)
+	return cert, key
+}
+
+func writePEM(t *testing.T, filename, blockType string, der []byte) {
+	t.Helper()
+	if err := os.WriteFile(filename, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSignerMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca, caKey := issue(t, dir, "ca", nil, nil, &x509.Certificate{
+		IsCA: true, BasicConstraintsValid: true, KeyUsage: x509.KeyUsageCertSign,
+	})
+	issue(t, dir, "signer", ca, caKey, &x509.Certificate{
+		DNSNames:    []string{"localhost"},
+		IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	})
+	for _, client := range []string{"pica-web", "intruder"} {
+		issue(t, dir, client, ca, caKey, &x509.Certificate{
+			ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		})
+	}
+	// A client certificate without common name
+	issue(t, dir, "nameless", ca, caKey, &x509.Certificate{
+		Subject:     pkix.Name{Organization: []string{"PiCA Test"}},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	// A client certificate from another CA
+	other, otherKey := issue(t, dir, "other-ca", nil, nil, &x509.Certificate{
+		IsCA: true, BasicConstraintsValid: true, KeyUsage: x509.KeyUsageCertSign,
+	})
+	issue(t, dir, "stranger", other, otherKey, &x509.Certificate{
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+
+	server := newTestServer(t)
+	server.Policy.Clients = []string{"pica-web"}
+	tlsConfig, err := ServerTLSConfig(filepath.Join(dir, "signer.pem"), filepath.Join(dir, "signer.key"), filepath.Join(dir, "ca.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := Listen("127.0.0.1:0", tlsConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go server.Serve(l)
+
+	open := func(client string) (crypto.Provider, error) {
+		return crypto.OpenProvider(crypto.RemoteProviderType, map[string]interface{}{
+			"address": l.Addr().String(),
+			"ca":      filepath.Join(dir, "ca.pem"),
+			"cert":    filepath.Join(dir, client+".pem"),
+			"key":     filepath.Join(dir, client+".key"),
+		})
+	}
+
+	p, err := open("pica-web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	digest := sha256.Sum256([]byte("over TLS"))
+	if _, err := p.Sign(crypto.SlotCA1, digest[:], gocrypto.SHA256); err != nil {
+		t.Errorf("Signature over mutual TLS failed: %v", err)
+	}
+
+	// A client the policy does not name is refused even its hello
+	if _, err := open("intruder"); !errors.Is(err, crypto.ErrNotPermitted) {
+		t.Errorf("Expected ErrNotPermitted for a client outside the policy, got %v", err)
+	}
+	if _, err := open("nameless"); !errors.Is(err, crypto.ErrNotPermitted) {
+		t.Errorf("Expected ErrNotPermitted for a client certificate without common name, got %v", err)
+	}
+	// A certificate from another CA fails the handshake
+	if _, err := open("stranger"); err == nil {
+		t.Error("Expected a client certificate from another CA to be rejected")
+	}
+
+	// TCP requires TLS
+	if _, err := crypto.NewRemoteProvider(map[string]interface{}{"address": l.Addr().String()}); err == nil {
+		t.Error("Expected an error for a TCP address without TLS options")
+	}
+	if _, err := Listen("127.0.0.1:0", nil); err == nil {
+		t.Error("Expected an error listening on TCP without TLS")
+	}
+}
+
+func TestLoadPolicy(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "policy.json")
+	os.WriteFile(file, []byte(`{"slots": ["82", "84"], "hashes": ["SHA-384"]}`), 0600)
+	p, err := LoadPolicy(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest := make([]byte, 48)
+	if err := p.Check("", false, &crypto.PluginRequest{Method: crypto.PluginSign, Slot: crypto.SlotSSH, Digest: digest, Hash: "SHA-384"}); err != nil {
+		t.Errorf("Allowed request refused: %v", err)
+	}
+	if err := p.Check("", false, &crypto.PluginRequest{Method: crypto.PluginSign, Slot: crypto.SlotSSH, Digest: digest[:32], Hash: "SHA-256"}); err == nil {
+		t.Error("Expected SHA-256 to be refused")
+	}
+
+	for _, bad := range []string{`{"slots": []}`, `{"slots": ["zz"]}`, `{"slots": ["82"], "hashes": ["SHA-999"]}`} {
+		os.WriteFile(file, []byte(bad), 0600)
+		if _, err := LoadPolicy(file); err == nil {
+			t.Errorf("Expected an error for policy %s", bad)
+		}
+	}
+}
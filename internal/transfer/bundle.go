@@ -0,0 +1,226 @@
+// Package transfer implements signed bundles used to move requests and
+// certificates between an air-gapped root CA and its subordinate CAs on
+// removable media.
+package transfer
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// Bundle kinds
+const (
+	// KindSubCARequest carries a sub CA CSR to the root CA
+	KindSubCARequest = "subca-request"
+	// KindSubCAResponse carries the signed sub CA certificate and a fresh
+	// root CRL back to the sub CA
+	KindSubCAResponse = "subca-response"
+)
+
+// Well-known file names inside bundles
+const (
+	FileCSR         = "request.csr"
+	FileRequestJSON = "request.json"
+	FileCertificate = "certificate.pem"
+	FileIssuer      = "issuer.pem"
+	FileCRL         = "issuer.crl"
+)
+
+// BundleVersion is the current bundle format version
+const BundleVersion = 1
+
+// Bundle is a set of files with per-file digests and a signature over the
+// whole manifest
+type Bundle struct {
+	Version   int               `json:"version"`
+	Kind      string            `json:"kind"`
+	CreatedAt time.Time         `json:"createdAt"`
+	Comment   string            `json:"comment,omitempty"`
+	Files     map[string][]byte `json:"files"`
+	Digests   map[string]string `json:"digests"`
+	Signature []byte            `json:"signature,omitempty"`
+}
+
+// New creates an empty bundle of the given kind
+func New(kind, comment string) *Bundle {
+	return &Bundle{
+		Version:   BundleVersion,
+		Kind:      kind,
+		CreatedAt: time.Now().UTC(),
+		Comment:   comment,
+		Files:     make(map[string][]byte),
+		Digests:   make(map[string]string),
+	}
+}
+
+// AddFile adds a file and records its SHA-256 digest
+func (b *Bundle) AddFile(name string, data []byte) {
+	sum := sha256.Sum256(data)
+	b.Files[name] = data
+	b.Digests[name] = hex.EncodeToString(sum[:])
+}
+
+// File returns the contents of a file after checking it is present
+func (b *Bundle) File(name string) ([]byte, error) {
+	data, ok := b.Files[name]
+	if !ok {
+		return nil, fmt.Errorf("bundle does not contain %s", name)
+	}
+	return data, nil
+}
+
+// Names returns the sorted file names in the bundle
+func (b *Bundle) Names() []string {
+	names := make([]string, 0, len(b.Files))
+	for name := range b.Files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Fingerprint returns the SHA-256 digest of the signed manifest, suitable
+// for reading aloud during a ceremony
+func (b *Bundle) Fingerprint() (string, error) {
+	digest, err := b.manifestDigest()
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(digest), nil
+}
+
+// Sign signs the bundle manifest with the given signer
+func (b *Bundle) Sign(signer crypto.Signer) error {
+	digest, err := b.manifestDigest()
+	if err != nil {
+		return err
+	}
+
+	sig, err := signer.Sign(rand.Reader, digest, crypto.SHA256)
+	if err != nil {
+		return fmt.Errorf("failed to sign bundle: %w", err)
+	}
+	b.Signature = sig
+	return nil
+}
+
+// Verify checks every file digest and the manifest signature against the
+// expected public key
+func (b *Bundle) Verify(pub crypto.PublicKey) error {
+	if b.Version != BundleVersion {
+		return fmt.Errorf("unsupported bundle version: %d", b.Version)
+	}
+
+	for name, data := range b.Files {
+		expected, ok := b.Digests[name]
+		if !ok {
+			return fmt.Errorf("no digest recorded for %s", name)
+		}
+		sum := sha256.Sum256(data)
+		if hex.EncodeToString(sum[:]) != expected {
+			return fmt.Errorf("digest mismatch for %s", name)
+		}
+	}
+	for name := range b.Digests {
+		if _, ok := b.Files[name]; !ok {
+			return fmt.Errorf("bundle is missing %s", name)
+		}
+	}
+
+	if len(b.Signature) == 0 {
+		return errors.New("bundle is not signed")
+	}
+
+	digest, err := b.manifestDigest()
+	if err != nil {
+		return err
+	}
+
+	switch key := pub.(type) {
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(key, digest, b.Signature) {
+			return errors.New("bundle signature verification failed")
+		}
+	case *rsa.PublicKey:
+		if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest, b.Signature); err != nil {
+			return fmt.Errorf("bundle signature verification failed: %w", err)
+		}
+	default:
+		return fmt.Errorf("unsupported public key type: %T", pub)
+	}
+
+	return nil
+}
+
+// VerifyWithCertificate verifies the bundle against a certificate's key
+func (b *Bundle) VerifyWithCertificate(cert *x509.Certificate) error {
+	return b.Verify(cert.PublicKey)
+}
+
+// manifestDigest hashes the bundle metadata and file digests. The files
+// themselves are covered through their digests.
+func (b *Bundle) manifestDigest() ([]byte, error) {
+	manifest := struct {
+		Version   int               `json:"version"`
+		Kind      string            `json:"kind"`
+		CreatedAt time.Time         `json:"createdAt"`
+		Comment   string            `json:"comment"`
+		Digests   map[string]string `json:"digests"`
+	}{b.Version, b.Kind, b.CreatedAt, b.Comment, b.Digests}
+
+	// encoding/json sorts map keys, so the encoding is deterministic
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode bundle manifest: %w", err)
+	}
+	sum := sha256.Sum256(data)
+	return sum[:], nil
+}
+
+// Write saves the bundle to a file, typically on removable media
+func Write(filename string, b *Bundle) error {
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		return fmt.Errorf("failed to create bundle directory: %w", err)
+	}
+
+	data, err := json.MarshalIndent(b, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode bundle: %w", err)
+	}
+
+	if err := os.WriteFile(filename, data, 0644); err != nil {
+		return fmt.Errorf("failed to write bundle: %w", err)
+	}
+	return nil
+}
+
+// Read loads a bundle from a file and checks its kind. The caller must still
+// call Verify with the expected signer key.
+func Read(filename, kind string) (*Bundle, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read bundle: %w", err)
+	}
+
+	var b Bundle
+	if err := json.Unmarshal(data, &b); err != nil {
+		return nil, fmt.Errorf("failed to parse bundle: %w", err)
+	}
+
+	if b.Kind != kind {
+		return nil, fmt.Errorf("unexpected bundle kind %q (want %q)", b.Kind, kind)
+	}
+	return &b, nil
+}
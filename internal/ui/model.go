@@ -52,6 +52,9 @@ const (
 	rootCAPage page = iota
 	subCAPage
 	certManagementPage
+	templatesPage
+	sshPage
+	slotsPage
 )
 
 type Model struct {
@@ -65,6 +68,9 @@ type Model struct {
 	subCAModel     pages.SubCAModel
 	certManageRoot pages.CertManageModel
 	certManageSub  pages.CertManageModel
+	templates      pages.TemplatesModel
+	ssh            pages.SSHModel
+	slots          pages.SlotsModel
 	config         *config.Config // Add configuration
 }
 
@@ -97,6 +103,9 @@ func NewModelWithConfig(cfg *config.Config) Model {
 		subCAModel:     pages.NewSubCAModel(styles),
 		certManageRoot: pages.NewCertManageModel(styles, ca.RootCA),
 		certManageSub:  pages.NewCertManageModel(styles, ca.SubCA),
+		templates:      pages.NewTemplatesModelWithConfig(styles, cfg),
+		ssh:            pages.NewSSHModelWithConfig(styles, cfg),
+		slots:          pages.NewSlotsModelWithConfig(styles, cfg),
 		config:         cfg,
 	}
 }
@@ -107,6 +116,9 @@ func (m Model) Init() tea.Cmd {
 		m.subCAModel.Init(),
 		m.certManageRoot.Init(),
 		m.certManageSub.Init(),
+		m.templates.Init(),
+		m.ssh.Init(),
+		m.slots.Init(),
 	)
 }
 
@@ -119,7 +131,7 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		case key.Matches(msg, m.keys.Quit):
 			return m, tea.Quit
 		case key.Matches(msg, m.keys.Tab):
-			m.currentPage = (m.currentPage + 1) % 3
+			m.currentPage = (m.currentPage + 1) % 6
 		}
 
 	case tea.WindowSizeMsg:
@@ -149,6 +161,18 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			newModel, cmd = m.certManageSub.Update(msg)
 			m.certManageSub = newModel.(pages.CertManageModel)
 		}
+	case templatesPage:
+		var newModel tea.Model
+		newModel, cmd = m.templates.Update(msg)
+		m.templates = newModel.(pages.TemplatesModel)
+	case sshPage:
+		var newModel tea.Model
+		newModel, cmd = m.ssh.Update(msg)
+		m.ssh = newModel.(pages.SSHModel)
+	case slotsPage:
+		var newModel tea.Model
+		newModel, cmd = m.slots.Update(msg)
+		m.slots = newModel.(pages.SlotsModel)
 	}
 	cmds = append(cmds, cmd)
 
@@ -169,6 +193,12 @@ func (m Model) View() string {
 		} else {
 			content = m.certManageSub.View()
 		}
+	case templatesPage:
+		content = m.templates.View()
+	case sshPage:
+		content = m.ssh.View()
+	case slotsPage:
+		content = m.slots.View()
 	}
 
 	help := m.help.View(m.keys)
@@ -2,7 +2,6 @@ package pages
 
 import (
 	"fmt"
-	"os"
 	"strconv"
 	"strings"
 
@@ -10,6 +9,7 @@ import (
 	"github.com/billchurch/PiCA/internal/ca/commands"
 	"github.com/billchurch/PiCA/internal/config"
 	"github.com/billchurch/PiCA/internal/crypto"
+	"github.com/charmbracelet/bubbles/key"
 	"github.com/charmbracelet/bubbles/list"
 	"github.com/charmbracelet/bubbles/textinput"
 	tea "github.com/charmbracelet/bubbletea"
@@ -117,7 +117,7 @@ func NewCertManageModelWithConfig(styles Styles, caType ca.CAType, cfg *config.C
 
 // setupSignInputs sets up inputs for signing a certificate
 func (m *CertManageModel) setupSignInputs() {
-	m.inputs = make([]textinput.Model, 4)
+	m.inputs = make([]textinput.Model, 5)
 	var t textinput.Model
 
 	t = textinput.New()
@@ -142,9 +142,13 @@ func (m *CertManageModel) setupSignInputs() {
 	m.inputs[1] = t
 
 	t = textinput.New()
-	t.Placeholder = "Profile (e.g., server, client)"
+	t.Placeholder = "Profile or template (e.g., server, client)"
 	t.CharLimit = 100
 	t.Width = 50
+	// Suggest the CA's templates; right arrow accepts as tab cycles inputs
+	t.SetSuggestions(TemplateNames(m.config))
+	t.ShowSuggestions = true
+	t.KeyMap.AcceptSuggestion = key.NewBinding(key.WithKeys("right"))
 	// Use profile from config or default
 	if m.config.CAProfile != "" && m.config.CAProfile != "subca" {
 		t.SetValue(m.config.CAProfile)
@@ -167,12 +171,14 @@ func (m *CertManageModel) setupSignInputs() {
 	}
 	m.inputs[3] = t
 
+	m.inputs[4] = newPINInput()
+
 	m.focusIndex = 0
 }
 
 // setupRevokeInputs sets up inputs for revoking a certificate
 func (m *CertManageModel) setupRevokeInputs() {
-	m.inputs = make([]textinput.Model, 3)
+	m.inputs = make([]textinput.Model, 4)
 	var t textinput.Model
 
 	t = textinput.New()
@@ -203,6 +209,8 @@ func (m *CertManageModel) setupRevokeInputs() {
 	}
 	m.inputs[2] = t
 
+	m.inputs[3] = newPINInput()
+
 	m.focusIndex = 0
 }
 
@@ -277,12 +285,7 @@ func (m CertManageModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				// Create provider using configuration or environment
 				var provider crypto.Provider
 				var err error
-				if m.config.ProviderType != "" {
-					// Force specific provider type
-					os.Setenv("PICA_PROVIDER", m.config.ProviderType)
-				}
-				
-				provider, err = crypto.CreateDefaultProvider()
+				provider, err = m.config.OpenProvider()
 				if err != nil {
 					m.message = fmt.Sprintf("Error creating provider: %s", err)
 					return m, nil
@@ -321,6 +324,8 @@ func (m CertManageModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 					provider,            // Provider
 					keySlot,             // YubiKey slot
 				)
+				cmd.Credentials = formCredentials(m.inputs[4].Value())
+				m.inputs[4].Reset()
 
 				err = cmd.Execute()
 				if err != nil {
@@ -335,12 +340,7 @@ func (m CertManageModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				// Create provider using configuration or environment
 				var provider crypto.Provider
 				var err error
-				if m.config.ProviderType != "" {
-					// Force specific provider type
-					os.Setenv("PICA_PROVIDER", m.config.ProviderType)
-				}
-				
-				provider, err = crypto.CreateDefaultProvider()
+				provider, err = m.config.OpenProvider()
 				if err != nil {
 					m.message = fmt.Sprintf("Error creating provider: %s", err)
 					return m, nil
@@ -378,6 +378,8 @@ func (m CertManageModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 					provider,            // Provider
 					keySlot,             // YubiKey slot
 				)
+				cmd.Credentials = formCredentials(m.inputs[3].Value())
+				m.inputs[3].Reset()
 
 				err = cmd.Execute()
 				if err != nil {
@@ -0,0 +1,27 @@
+package pages
+
+import (
+	"github.com/billchurch/PiCA/internal/crypto"
+	"github.com/charmbracelet/bubbles/textinput"
+)
+
+// newPINInput creates the masked input for the PIN of a hardware provider
+func newPINInput() textinput.Model {
+	t := textinput.New()
+	t.Placeholder = "YubiKey PIN (hardware providers only)"
+	t.EchoMode = textinput.EchoPassword
+	t.EchoCharacter = '•'
+	t.CharLimit = 8
+	t.Width = 50
+	return t
+}
+
+// formCredentials supplies the PIN typed into a form, falling back to the
+// environment. A rejected PIN is not tried again; the error reports the
+// retries left instead.
+func formCredentials(pin string) crypto.CredentialCallback {
+	return crypto.ChainCredentials(
+		crypto.StaticCredentials(map[crypto.CredentialKind]string{crypto.CredentialPIN: pin}),
+		crypto.EnvCredentials(),
+	)
+}
@@ -2,7 +2,6 @@ package pages
 
 import (
 	"fmt"
-	"os"
 	"strconv"
 	"strings"
 
@@ -40,7 +39,7 @@ func NewRootCAModelWithConfig(styles Styles, cfg *config.Config) RootCAModel {
 
 	m := RootCAModel{
 		styles:  styles,
-		inputs:  make([]textinput.Model, 3),
+		inputs:  make([]textinput.Model, 4),
 		message: "",
 		config:  cfg,
 	}
@@ -85,6 +84,8 @@ func NewRootCAModelWithConfig(styles Styles, cfg *config.Config) RootCAModel {
 	}
 	m.inputs[2] = t
 
+	m.inputs[3] = newPINInput()
+
 	return m
 }
 
@@ -131,12 +132,7 @@ func (m RootCAModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				// Create provider using configuration or environment
 				var provider crypto.Provider
 				var err error
-				if m.config.ProviderType != "" {
-					// Force specific provider type
-					os.Setenv("PICA_PROVIDER", m.config.ProviderType)
-				}
-				
-				provider, err = crypto.CreateDefaultProvider()
+				provider, err = m.config.OpenProvider()
 				if err != nil {
 					m.message = fmt.Sprintf("Error creating provider: %s", err)
 					return m, nil
@@ -164,6 +160,12 @@ func (m RootCAModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				
 				// Set provider
 				cmd.Provider = provider
+				cmd.Credentials = formCredentials(m.inputs[3].Value())
+				m.inputs[3].Reset()
+				if cmd.AttestationRoots, err = ca.LoadAttestationRoots(m.config.AttestationRoots); err != nil {
+					m.message = fmt.Sprintf("Error: %s", err)
+					return m, nil
+				}
 
 				err = cmd.Execute()
 				if err != nil {
@@ -0,0 +1,202 @@
+package pages
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/billchurch/PiCA/internal/ca/commands"
+	"github.com/billchurch/PiCA/internal/config"
+	"github.com/billchurch/PiCA/internal/crypto"
+	"github.com/charmbracelet/bubbles/list"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// SlotItem represents a provider slot in the inventory list
+type SlotItem struct {
+	info crypto.SlotInfo
+}
+
+// FilterValue implements list.Item interface
+func (i SlotItem) FilterValue() string { return fmt.Sprintf("%X", int(i.info.Slot)) }
+
+// Title implements list.Item interface
+func (i SlotItem) Title() string {
+	title := fmt.Sprintf("Slot %X: %s", int(i.info.Slot), commands.SlotKey(i.info))
+	if cert := commands.SlotCertificate(i.info); cert != "" {
+		title += " - " + cert
+	}
+	return title
+}
+
+// Description implements list.Item interface
+func (i SlotItem) Description() string {
+	s := i.info
+	var parts []string
+	if !s.Created.IsZero() {
+		parts = append(parts, "Created: "+s.Created.Format("2006-01-02 15:04"))
+	}
+	if s.Origin != "" {
+		parts = append(parts, "Origin: "+s.Origin)
+	}
+	if s.PINPolicy != "" {
+		parts = append(parts, "PIN: "+s.PINPolicy)
+	}
+	if s.TouchPolicy != "" {
+		parts = append(parts, "Touch: "+s.TouchPolicy)
+	}
+	if s.Certificate != nil {
+		parts = append(parts, "Expires: "+s.Certificate.NotAfter.Format("2006-01-02"))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// SlotsModel represents the key inventory page, which shows what the
+// configured provider's slots hold
+type SlotsModel struct {
+	width   int
+	height  int
+	styles  Styles
+	message string
+	list    list.Model
+	loaded  bool
+	// deleting is the slot awaiting a second press of "d"
+	deleting *crypto.Slot
+	config   *config.Config
+}
+
+// NewSlotsModelWithConfig creates a new SlotsModel with configuration
+func NewSlotsModelWithConfig(styles Styles, cfg *config.Config) SlotsModel {
+	// Use default config if none provided
+	if cfg == nil {
+		cfg = config.DefaultConfig()
+	}
+
+	m := SlotsModel{
+		styles: styles,
+		config: cfg,
+	}
+	m.list = list.New(nil, list.NewDefaultDelegate(), 0, 0)
+	m.list.Title = "Key Inventory"
+	m.list.SetFilteringEnabled(false)
+	return m
+}
+
+// withProvider runs fn with an open provider
+func (m *SlotsModel) withProvider(fn func(crypto.Provider) error) error {
+	provider, err := m.config.OpenProvider()
+	if err != nil {
+		return fmt.Errorf("error creating crypto provider: %w", err)
+	}
+	defer provider.Close()
+	return fn(provider)
+}
+
+// reload reads the slots of the provider. The provider is only opened on
+// request, so a hardware token is not touched when the page is not used.
+func (m *SlotsModel) reload() {
+	err := m.withProvider(func(p crypto.Provider) error {
+		slots, err := p.ListSlots()
+		if err != nil {
+			return err
+		}
+		items := make([]list.Item, len(slots))
+		for i, s := range slots {
+			items[i] = SlotItem{info: s}
+		}
+		m.list.Title = "Key Inventory of " + p.Name()
+		m.list.SetItems(items)
+		return nil
+	})
+	if err != nil {
+		m.message = fmt.Sprintf("Error: %s", err)
+		return
+	}
+	m.loaded = true
+}
+
+// Init initializes the model
+func (m SlotsModel) Init() tea.Cmd {
+	return nil
+}
+
+// Update updates the model based on messages
+func (m SlotsModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.KeyMsg:
+		switch msg.String() {
+		case "r":
+			m.message = ""
+			m.deleting = nil
+			m.reload()
+			return m, nil
+		case "d":
+			item, ok := m.list.SelectedItem().(SlotItem)
+			if !ok {
+				return m, nil
+			}
+			slot := item.info.Slot
+			if m.deleting == nil || *m.deleting != slot {
+				m.deleting = &slot
+				m.message = fmt.Sprintf("Press d again to delete the key and certificate in slot %X. This cannot be undone.", int(slot))
+				if cert := item.info.Certificate; cert != nil && cert.IsCA {
+					m.message = fmt.Sprintf("Slot %X holds the key of CA %q. Press d again to delete it. This cannot be undone.",
+						int(slot), cert.Subject.CommonName)
+				}
+				return m, nil
+			}
+			m.deleting = nil
+			if err := m.withProvider(func(p crypto.Provider) error { return p.DeleteKey(slot) }); err != nil {
+				m.message = fmt.Sprintf("Error: %s", err)
+				return m, nil
+			}
+			m.reload()
+			m.message = fmt.Sprintf("Deleted the contents of slot %X", int(slot))
+			return m, nil
+		case "esc":
+			m.deleting = nil
+			m.message = ""
+			return m, nil
+		}
+
+	case tea.WindowSizeMsg:
+		m.width = msg.Width
+		m.height = msg.Height
+		m.list.SetSize(msg.Width-4, msg.Height-10)
+	}
+
+	var cmd tea.Cmd
+	m.list, cmd = m.list.Update(msg)
+	return m, cmd
+}
+
+// View renders the UI
+func (m SlotsModel) View() string {
+	var b strings.Builder
+
+	b.WriteString(m.styles.titleStyle.Render("Key Inventory"))
+	b.WriteString("\n\n")
+
+	switch {
+	case !m.loaded:
+		b.WriteString(m.styles.infoStyle.Render("Press r to read the slots of the configured provider"))
+		b.WriteString("\n\n")
+	case len(m.list.Items()) == 0:
+		b.WriteString(m.styles.infoStyle.Render("No keys or certificates in the provider"))
+		b.WriteString("\n\n")
+	default:
+		b.WriteString(m.list.View())
+		b.WriteString("\n\n")
+	}
+	b.WriteString("[r] Reload  [d] Delete  [esc] Cancel")
+
+	if m.message != "" {
+		b.WriteString("\n\n")
+		if strings.HasPrefix(m.message, "Error") || m.deleting != nil {
+			b.WriteString(m.styles.errorStyle.Render(m.message))
+		} else {
+			b.WriteString(m.styles.messageStyle.Render(m.message))
+		}
+	}
+
+	return b.String()
+}
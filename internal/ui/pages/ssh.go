@@ -0,0 +1,348 @@
+package pages
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/billchurch/PiCA/internal/ca"
+	"github.com/billchurch/PiCA/internal/config"
+	"github.com/billchurch/PiCA/internal/crypto"
+	"github.com/charmbracelet/bubbles/list"
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+	"golang.org/x/crypto/ssh"
+)
+
+// SSHMode is the current view of the SSH page
+type SSHMode int
+
+const (
+	SSHModeList SSHMode = iota
+	SSHModeSign
+)
+
+// SSH signing form fields
+const (
+	sshFieldKeyFile = iota
+	sshFieldPrincipals
+	sshFieldProfile
+	sshFieldKeyID
+	sshFieldValidity
+	sshFieldCount
+)
+
+// SSHItem represents an issued SSH certificate in a list
+type SSHItem struct {
+	record *ca.CertificateRecord
+}
+
+// FilterValue implements list.Item interface
+func (i SSHItem) FilterValue() string { return i.record.Subject }
+
+// Title implements list.Item interface
+func (i SSHItem) Title() string {
+	return fmt.Sprintf("%s (%s)", i.record.Subject, i.record.Metadata["type"])
+}
+
+// Description implements list.Item interface
+func (i SSHItem) Description() string {
+	r := i.record
+	return fmt.Sprintf("Serial: %s, Principals: %s, Expires: %s, Status: %s",
+		r.SerialNumber, r.Metadata["principals"], r.NotAfter.Format("2006-01-02 15:04"), r.Status)
+}
+
+// SSHModel represents the SSH certificate authority page
+type SSHModel struct {
+	width      int
+	height     int
+	styles     Styles
+	mode       SSHMode
+	inputs     []textinput.Model
+	focusIndex int
+	message    string
+	list       list.Model
+	config     *config.Config
+}
+
+// NewSSHModel creates a new SSHModel without configuration
+func NewSSHModel(styles Styles) SSHModel {
+	return NewSSHModelWithConfig(styles, nil)
+}
+
+// NewSSHModelWithConfig creates a new SSHModel with configuration
+func NewSSHModelWithConfig(styles Styles, cfg *config.Config) SSHModel {
+	// Use default config if none provided
+	if cfg == nil {
+		cfg = config.DefaultConfig()
+	}
+
+	m := SSHModel{
+		styles: styles,
+		mode:   SSHModeList,
+		config: cfg,
+	}
+	m.list = list.New(nil, list.NewDefaultDelegate(), 0, 0)
+	m.list.Title = "SSH Certificates"
+	m.list.SetFilteringEnabled(false)
+	m.reload()
+	return m
+}
+
+// sshCA returns the configured SSH CA using provider for its key
+func sshCA(cfg *config.Config, provider crypto.Provider) *ca.SSHCA {
+	slot := crypto.SlotSSH
+	if slotVal, err := strconv.ParseInt(cfg.SSHKeySlot, 16, 64); err == nil {
+		slot = crypto.Slot(slotVal)
+	}
+	c := ca.NewSSHCA(provider, slot, cfg.SSHConfigFile, ca.SSHDatabaseDir(cfg.DatabaseDir))
+	c.KRLFile = cfg.SSHKRLFile
+	return c
+}
+
+// withSSHCA runs fn with the SSH CA and an open provider
+func (m *SSHModel) withSSHCA(fn func(*ca.SSHCA) error) error {
+	provider, err := m.config.OpenProvider()
+	if err != nil {
+		return fmt.Errorf("error creating crypto provider: %w", err)
+	}
+	defer provider.Close()
+	return fn(sshCA(m.config, provider))
+}
+
+// reload refreshes the certificate list
+func (m *SSHModel) reload() {
+	store, err := sshCA(m.config, nil).Store()
+	if err != nil {
+		m.message = fmt.Sprintf("Error: %s", err)
+		return
+	}
+	records := store.List()
+	items := make([]list.Item, 0, len(records))
+	// Newest first
+	for i := len(records) - 1; i >= 0; i-- {
+		items = append(items, SSHItem{record: records[i]})
+	}
+	m.list.SetItems(items)
+}
+
+// setupInputs sets up the signing form
+func (m *SSHModel) setupInputs() {
+	placeholders := [sshFieldCount]string{
+		sshFieldKeyFile:    "Public key file (e.g., ~/.ssh/id_ed25519.pub)",
+		sshFieldPrincipals: "Principals (user names or host names, comma-separated)",
+		sshFieldProfile:    "Profile (default profile when empty)",
+		sshFieldKeyID:      "Key ID (first principal when empty)",
+		sshFieldValidity:   "Validity (profile's when empty, e.g., 8h)",
+	}
+
+	m.inputs = make([]textinput.Model, sshFieldCount)
+	for i := range m.inputs {
+		in := textinput.New()
+		in.Placeholder = placeholders[i]
+		in.CharLimit = 200
+		in.Width = 60
+		m.inputs[i] = in
+	}
+	if cfg, err := sshCA(m.config, nil).Config(); err == nil {
+		m.inputs[sshFieldProfile].SetValue(cfg.Default)
+	}
+	m.focusIndex = sshFieldKeyFile
+	m.inputs[m.focusIndex].Focus()
+}
+
+// sign issues a certificate for the form and saves it next to the key
+func (m *SSHModel) sign() error {
+	value := func(i int) string { return strings.TrimSpace(m.inputs[i].Value()) }
+
+	keyFile := value(sshFieldKeyFile)
+	if strings.HasPrefix(keyFile, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			keyFile = home + keyFile[1:]
+		}
+	}
+	data, err := os.ReadFile(keyFile)
+	if err != nil {
+		return fmt.Errorf("error reading public key: %w", err)
+	}
+
+	req := &ca.SSHRequest{
+		PublicKey:  string(data),
+		KeyID:      value(sshFieldKeyID),
+		Principals: splitFields(value(sshFieldPrincipals)),
+		Profile:    value(sshFieldProfile),
+		Validity:   value(sshFieldValidity),
+	}
+	certFile := strings.TrimSuffix(keyFile, ".pub") + "-cert.pub"
+	return m.withSSHCA(func(c *ca.SSHCA) error {
+		cert, err := c.Sign(req)
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(certFile, ssh.MarshalAuthorizedKey(cert), 0644); err != nil {
+			return fmt.Errorf("error writing certificate: %w", err)
+		}
+		m.message = fmt.Sprintf("Issued certificate %s to %s", ca.FormatSSHSerial(cert.Serial), certFile)
+		return nil
+	})
+}
+
+// selected returns the highlighted certificate record, if any
+func (m *SSHModel) selected() *ca.CertificateRecord {
+	item, ok := m.list.SelectedItem().(SSHItem)
+	if !ok {
+		return nil
+	}
+	return item.record
+}
+
+// Init initializes the model
+func (m SSHModel) Init() tea.Cmd {
+	return nil
+}
+
+// Update updates the model based on messages
+func (m SSHModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	var cmds []tea.Cmd
+
+	switch msg := msg.(type) {
+	case tea.KeyMsg:
+		switch m.mode {
+		case SSHModeList:
+			switch msg.String() {
+			case "s":
+				m.mode = SSHModeSign
+				m.message = ""
+				m.setupInputs()
+				return m, textinput.Blink
+			case "x":
+				if r := m.selected(); r != nil && r.Status != ca.StatusRevoked {
+					err := m.withSSHCA(func(c *ca.SSHCA) error {
+						_, err := c.Revoke(r.SerialNumber, "")
+						return err
+					})
+					m.message = fmt.Sprintf("Revoked SSH certificate %s", r.SerialNumber)
+					if err != nil {
+						m.message = fmt.Sprintf("Error: %s", err)
+					}
+					m.reload()
+				}
+				return m, nil
+			case "k":
+				if m.config.SSHKRLFile == "" {
+					m.message = "Error: no KRL file configured (--ssh-krl-file)"
+					return m, nil
+				}
+				m.message = fmt.Sprintf("Published KRL to %s", m.config.SSHKRLFile)
+				if err := m.withSSHCA(func(c *ca.SSHCA) error { return c.PublishKRL() }); err != nil {
+					m.message = fmt.Sprintf("Error: %s", err)
+				}
+				return m, nil
+			case "r":
+				m.message = ""
+				m.reload()
+				return m, nil
+			}
+
+		case SSHModeSign:
+			switch msg.String() {
+			case "esc":
+				m.mode = SSHModeList
+				m.message = ""
+				return m, nil
+			case "up", "down", "shift+tab":
+				if msg.String() == "up" || msg.String() == "shift+tab" {
+					m.focusIndex--
+				} else {
+					m.focusIndex++
+				}
+				if m.focusIndex < 0 {
+					m.focusIndex = len(m.inputs) - 1
+				} else if m.focusIndex >= len(m.inputs) {
+					m.focusIndex = 0
+				}
+				for i := range m.inputs {
+					if i == m.focusIndex {
+						cmds = append(cmds, m.inputs[i].Focus())
+					} else {
+						m.inputs[i].Blur()
+					}
+				}
+				return m, tea.Batch(cmds...)
+			case "enter":
+				if err := m.sign(); err != nil {
+					m.message = fmt.Sprintf("Error: %s", err)
+					return m, nil
+				}
+				m.mode = SSHModeList
+				m.reload()
+				return m, nil
+			}
+		}
+
+	case tea.WindowSizeMsg:
+		m.width = msg.Width
+		m.height = msg.Height
+		m.list.SetSize(msg.Width-4, msg.Height-10)
+	}
+
+	var cmd tea.Cmd
+	switch m.mode {
+	case SSHModeList:
+		m.list, cmd = m.list.Update(msg)
+		cmds = append(cmds, cmd)
+	case SSHModeSign:
+		for i := range m.inputs {
+			m.inputs[i], cmd = m.inputs[i].Update(msg)
+			cmds = append(cmds, cmd)
+		}
+	}
+
+	return m, tea.Batch(cmds...)
+}
+
+// View renders the UI
+func (m SSHModel) View() string {
+	var b strings.Builder
+
+	b.WriteString(m.styles.titleStyle.Render("SSH Certificate Authority"))
+	b.WriteString("\n\n")
+
+	switch m.mode {
+	case SSHModeList:
+		if m.config.SSHConfigFile == "" {
+			b.WriteString(m.styles.infoStyle.Render("Set --ssh-config to issue SSH certificates"))
+			b.WriteString("\n\n")
+		}
+		if len(m.list.Items()) == 0 {
+			b.WriteString(m.styles.infoStyle.Render("No SSH certificates issued yet"))
+			b.WriteString("\n\n")
+		} else {
+			b.WriteString(m.list.View())
+			b.WriteString("\n\n")
+		}
+		b.WriteString("[s] Sign key  [x] Revoke  [k] Publish KRL  [r] Reload")
+
+	case SSHModeSign:
+		b.WriteString("Sign an SSH public key:\n\n")
+		for i, input := range m.inputs {
+			b.WriteString(input.View())
+			if i < len(m.inputs)-1 {
+				b.WriteString("\n")
+			}
+		}
+		b.WriteString("\n\n[enter] Sign  [esc] Cancel")
+	}
+
+	if m.message != "" {
+		b.WriteString("\n\n")
+		if strings.HasPrefix(m.message, "Error") {
+			b.WriteString(m.styles.errorStyle.Render(m.message))
+		} else {
+			b.WriteString(m.styles.messageStyle.Render(m.message))
+		}
+	}
+
+	return b.String()
+}
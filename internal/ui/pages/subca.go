@@ -2,7 +2,6 @@ package pages
 
 import (
 	"fmt"
-	"os"
 	"strconv"
 	"strings"
 
@@ -40,7 +39,7 @@ func NewSubCAModelWithConfig(styles Styles, cfg *config.Config) SubCAModel {
 
 	m := SubCAModel{
 		styles:  styles,
-		inputs:  make([]textinput.Model, 6),
+		inputs:  make([]textinput.Model, 7),
 		message: "",
 		config:  cfg,
 	}
@@ -121,6 +120,8 @@ func NewSubCAModelWithConfig(styles Styles, cfg *config.Config) SubCAModel {
 	}
 	m.inputs[5] = t
 
+	m.inputs[6] = newPINInput()
+
 	return m
 }
 
@@ -167,12 +168,7 @@ func (m SubCAModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				// Create provider using configuration or environment
 				var provider crypto.Provider
 				var err error
-				if m.config.ProviderType != "" {
-					// Force specific provider type
-					os.Setenv("PICA_PROVIDER", m.config.ProviderType)
-				}
-				
-				provider, err = crypto.CreateDefaultProvider()
+				provider, err = m.config.OpenProvider()
 				if err != nil {
 					m.message = fmt.Sprintf("Error creating provider: %s", err)
 					return m, nil
@@ -205,6 +201,12 @@ func (m SubCAModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				cmd.RootCACertFile = m.inputs[3].Value()
 				cmd.RootCAConfigFile = m.inputs[4].Value()
 				cmd.Profile = m.inputs[5].Value()
+				cmd.Credentials = formCredentials(m.inputs[6].Value())
+				m.inputs[6].Reset()
+				if cmd.AttestationRoots, err = ca.LoadAttestationRoots(m.config.AttestationRoots); err != nil {
+					m.message = fmt.Sprintf("Error: %s", err)
+					return m, nil
+				}
 
 				// Execute the command
 				err = cmd.Execute()
@@ -0,0 +1,547 @@
+package pages
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/billchurch/PiCA/internal/ca"
+	"github.com/billchurch/PiCA/internal/config"
+	"github.com/charmbracelet/bubbles/list"
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// TemplateMode is the current view of the templates page
+type TemplateMode int
+
+const (
+	TemplateModeList TemplateMode = iota
+	TemplateModeEdit
+	TemplateModeHistory
+)
+
+// Template form fields
+const (
+	templateFieldName = iota
+	templateFieldDescription
+	templateFieldKeyUsages
+	templateFieldExtKeyUsages
+	templateFieldValidity
+	templateFieldOrganization
+	templateFieldUnit
+	templateFieldCountry
+	templateFieldSANTypes
+	templateFieldPolicies
+	templateFieldComment
+	templateFieldCount
+)
+
+// TemplateItem represents a template or template version in a list
+type TemplateItem struct {
+	template *ca.Template
+	history  bool
+}
+
+// FilterValue implements list.Item interface
+func (i TemplateItem) FilterValue() string { return i.template.Name }
+
+// Title implements list.Item interface
+func (i TemplateItem) Title() string {
+	if i.history {
+		return fmt.Sprintf("Version %d", i.template.Version)
+	}
+	return i.template.Name
+}
+
+// Description implements list.Item interface
+func (i TemplateItem) Description() string {
+	t := i.template
+	if i.history {
+		return fmt.Sprintf("%s, Validity: %s %s", t.Updated.Format("2006-01-02 15:04"), t.Validity, t.Comment)
+	}
+	usages := append(append([]string{}, t.KeyUsages...), t.ExtKeyUsages...)
+	return fmt.Sprintf("v%d, Validity: %s, Usages: %s", t.Version, t.Validity, strings.Join(usages, ", "))
+}
+
+// TemplatesModel represents the certificate template management page
+type TemplatesModel struct {
+	width      int
+	height     int
+	styles     Styles
+	mode       TemplateMode
+	inputs     []textinput.Model
+	focusIndex int
+	editing    *ca.Template
+	message    string
+	list       list.Model
+	history    list.Model
+	config     *config.Config
+}
+
+// NewTemplatesModel creates a new TemplatesModel without configuration
+func NewTemplatesModel(styles Styles) TemplatesModel {
+	return NewTemplatesModelWithConfig(styles, nil)
+}
+
+// NewTemplatesModelWithConfig creates a new TemplatesModel with configuration
+func NewTemplatesModelWithConfig(styles Styles, cfg *config.Config) TemplatesModel {
+	// Use default config if none provided
+	if cfg == nil {
+		cfg = config.DefaultConfig()
+	}
+
+	m := TemplatesModel{
+		styles: styles,
+		mode:   TemplateModeList,
+		config: cfg,
+	}
+	m.list = list.New(nil, list.NewDefaultDelegate(), 0, 0)
+	m.list.Title = "Certificate Templates"
+	m.list.SetFilteringEnabled(false)
+	m.history = list.New(nil, list.NewDefaultDelegate(), 0, 0)
+	m.history.SetFilteringEnabled(false)
+	m.reload()
+	return m
+}
+
+// templateCA returns the CA whose templates are managed
+func templateCA(cfg *config.Config) *ca.CA {
+	caType := ca.SubCA
+	configFile := cfg.CAConfigFile
+	if cfg.CAType == "root" {
+		caType = ca.RootCA
+	}
+	if configFile == "" {
+		name := "sub-ca-config.json"
+		if caType == ca.RootCA {
+			name = "root-ca-config.json"
+		}
+		configFile = fmt.Sprintf("%s/cfssl/%s", cfg.ConfigDir, name)
+	}
+
+	caInstance := ca.NewCA(caType, configFile, "", cfg.CACertFile)
+	caInstance.DatabaseDir = cfg.DatabaseDir
+	return caInstance
+}
+
+// TemplateNames returns the names of the templates defined for the
+// configured CA, for suggestions in other pages
+func TemplateNames(cfg *config.Config) []string {
+	store, err := templateCA(cfg).Templates()
+	if err != nil {
+		return nil
+	}
+	templates, err := store.List()
+	if err != nil {
+		return nil
+	}
+	names := make([]string, 0, len(templates))
+	for _, t := range templates {
+		names = append(names, t.Name)
+	}
+	return names
+}
+
+// store opens the template store of the configured CA
+func (m *TemplatesModel) store() (*ca.TemplateStore, error) {
+	return templateCA(m.config).Templates()
+}
+
+// reload refreshes the template list
+func (m *TemplatesModel) reload() {
+	store, err := m.store()
+	if err != nil {
+		m.message = fmt.Sprintf("Error: %s", err)
+		return
+	}
+	templates, err := store.List()
+	if err != nil {
+		m.message = fmt.Sprintf("Error: %s", err)
+		return
+	}
+	items := make([]list.Item, len(templates))
+	for i, t := range templates {
+		items[i] = TemplateItem{template: t}
+	}
+	m.list.SetItems(items)
+}
+
+// selected returns the highlighted template, if any
+func (m *TemplatesModel) selected() *ca.Template {
+	item, ok := m.list.SelectedItem().(TemplateItem)
+	if !ok {
+		return nil
+	}
+	return item.template
+}
+
+// setupInputs sets up the template form, filled from t when editing
+func (m *TemplatesModel) setupInputs(t *ca.Template) {
+	placeholders := [templateFieldCount]string{
+		templateFieldName:         "Name (e.g., web-server)",
+		templateFieldDescription:  "Description",
+		templateFieldKeyUsages:    "Key usages (e.g., digital signature, key encipherment)",
+		templateFieldExtKeyUsages: "Extended key usages (e.g., server auth)",
+		templateFieldValidity:     "Validity (e.g., 2160h)",
+		templateFieldOrganization: "Default organization (O)",
+		templateFieldUnit:         "Default organizational unit (OU)",
+		templateFieldCountry:      "Default country (C)",
+		templateFieldSANTypes:     "SAN types, a SAN is required when set (e.g., dns, ip)",
+		templateFieldPolicies:     "Policy OIDs (comma-separated)",
+		templateFieldComment:      "Change comment",
+	}
+
+	m.inputs = make([]textinput.Model, templateFieldCount)
+	for i := range m.inputs {
+		in := textinput.New()
+		in.Placeholder = placeholders[i]
+		in.CharLimit = 200
+		in.Width = 60
+		m.inputs[i] = in
+	}
+
+	if t == nil {
+		m.inputs[templateFieldValidity].SetValue("8760h")
+	} else {
+		m.inputs[templateFieldName].SetValue(t.Name)
+		m.inputs[templateFieldDescription].SetValue(t.Description)
+		m.inputs[templateFieldKeyUsages].SetValue(strings.Join(t.KeyUsages, ", "))
+		m.inputs[templateFieldExtKeyUsages].SetValue(strings.Join(t.ExtKeyUsages, ", "))
+		m.inputs[templateFieldValidity].SetValue(t.Validity)
+		if t.Subject != nil {
+			m.inputs[templateFieldOrganization].SetValue(t.Subject.Organization)
+			m.inputs[templateFieldUnit].SetValue(t.Subject.OrganizationalUnit)
+			m.inputs[templateFieldCountry].SetValue(t.Subject.Country)
+		}
+		if t.SANs != nil && t.SANs.Required {
+			m.inputs[templateFieldSANTypes].SetValue(strings.Join(t.SANs.Types, ", "))
+		}
+		m.inputs[templateFieldPolicies].SetValue(strings.Join(t.Policies, ", "))
+	}
+
+	// The name of an existing template cannot change
+	m.focusIndex = templateFieldName
+	if t != nil {
+		m.focusIndex = templateFieldDescription
+	}
+	m.inputs[m.focusIndex].Focus()
+	m.editing = t
+}
+
+// formTemplate builds a template from the form, keeping the fields of the
+// edited template that the form does not show
+func (m *TemplatesModel) formTemplate() *ca.Template {
+	t := &ca.Template{}
+	if m.editing != nil {
+		*t = *m.editing
+	}
+	value := func(i int) string { return strings.TrimSpace(m.inputs[i].Value()) }
+
+	t.Name = value(templateFieldName)
+	t.Description = value(templateFieldDescription)
+	t.KeyUsages = splitFields(value(templateFieldKeyUsages))
+	t.ExtKeyUsages = splitFields(value(templateFieldExtKeyUsages))
+	t.Validity = value(templateFieldValidity)
+	t.Policies = splitFields(value(templateFieldPolicies))
+	t.Comment = value(templateFieldComment)
+
+	subject := ca.SubjectDefaults{}
+	if t.Subject != nil {
+		subject = *t.Subject
+	}
+	subject.Organization = value(templateFieldOrganization)
+	subject.OrganizationalUnit = value(templateFieldUnit)
+	subject.Country = value(templateFieldCountry)
+	t.Subject = nil
+	if subject != (ca.SubjectDefaults{}) {
+		t.Subject = &subject
+	}
+
+	if types := splitFields(value(templateFieldSANTypes)); len(types) > 0 {
+		sans := ca.SANRequirements{}
+		if t.SANs != nil {
+			sans = *t.SANs
+		}
+		sans.Required = true
+		sans.Types = types
+		t.SANs = &sans
+	} else if t.SANs != nil {
+		sans := *t.SANs
+		sans.Required = false
+		sans.Types = nil
+		t.SANs = &sans
+	}
+	return t
+}
+
+// save stores the form as a new template version and applies it
+func (m *TemplatesModel) save() error {
+	store, err := m.store()
+	if err != nil {
+		return err
+	}
+	t := m.formTemplate()
+	if m.editing == nil {
+		if _, err := store.Get(t.Name); err == nil {
+			return fmt.Errorf("template %q already exists", t.Name)
+		} else if !errors.Is(err, ca.ErrTemplateNotFound) {
+			return err
+		}
+	}
+	saved, err := store.Save(t)
+	if err != nil {
+		return err
+	}
+	if err := templateCA(m.config).ApplyTemplates(); err != nil {
+		return err
+	}
+	m.message = fmt.Sprintf("Saved template %s version %d", saved.Name, saved.Version)
+	return nil
+}
+
+// Init initializes the model
+func (m TemplatesModel) Init() tea.Cmd {
+	return nil
+}
+
+// Update updates the model based on messages
+func (m TemplatesModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	var cmds []tea.Cmd
+
+	switch msg := msg.(type) {
+	case tea.KeyMsg:
+		switch m.mode {
+		case TemplateModeList:
+			switch msg.String() {
+			case "n":
+				m.mode = TemplateModeEdit
+				m.message = ""
+				m.setupInputs(nil)
+				return m, textinput.Blink
+			case "e":
+				if t := m.selected(); t != nil {
+					m.mode = TemplateModeEdit
+					m.message = ""
+					m.setupInputs(t)
+					return m, textinput.Blink
+				}
+			case "d":
+				if t := m.selected(); t != nil {
+					m.message = fmt.Sprintf("Deleted template %s (history is kept)", t.Name)
+					if err := m.deleteTemplate(t.Name); err != nil {
+						m.message = fmt.Sprintf("Error: %s", err)
+					}
+					m.reload()
+				}
+				return m, nil
+			case "h":
+				if t := m.selected(); t != nil {
+					if err := m.loadHistory(t.Name); err != nil {
+						m.message = fmt.Sprintf("Error: %s", err)
+						return m, nil
+					}
+					m.mode = TemplateModeHistory
+					m.message = ""
+				}
+				return m, nil
+			case "r":
+				m.message = ""
+				m.reload()
+				return m, nil
+			}
+
+		case TemplateModeEdit:
+			switch msg.String() {
+			case "esc":
+				m.mode = TemplateModeList
+				m.message = ""
+				return m, nil
+			case "up", "down", "shift+tab":
+				if msg.String() == "up" || msg.String() == "shift+tab" {
+					m.focusIndex--
+				} else {
+					m.focusIndex++
+				}
+				first := templateFieldName
+				if m.editing != nil {
+					first = templateFieldDescription
+				}
+				if m.focusIndex < first {
+					m.focusIndex = len(m.inputs) - 1
+				} else if m.focusIndex >= len(m.inputs) {
+					m.focusIndex = first
+				}
+				for i := range m.inputs {
+					if i == m.focusIndex {
+						cmds = append(cmds, m.inputs[i].Focus())
+					} else {
+						m.inputs[i].Blur()
+					}
+				}
+				return m, tea.Batch(cmds...)
+			case "enter":
+				if err := m.save(); err != nil {
+					m.message = fmt.Sprintf("Error: %s", err)
+					return m, nil
+				}
+				m.mode = TemplateModeList
+				m.reload()
+				return m, nil
+			}
+
+		case TemplateModeHistory:
+			switch msg.String() {
+			case "esc":
+				m.mode = TemplateModeList
+				m.message = ""
+				return m, nil
+			case "enter":
+				if item, ok := m.history.SelectedItem().(TemplateItem); ok {
+					if err := m.rollback(item.template); err != nil {
+						m.message = fmt.Sprintf("Error: %s", err)
+						return m, nil
+					}
+					m.mode = TemplateModeList
+					m.reload()
+				}
+				return m, nil
+			}
+		}
+
+	case tea.WindowSizeMsg:
+		m.width = msg.Width
+		m.height = msg.Height
+		m.list.SetSize(msg.Width-4, msg.Height-10)
+		m.history.SetSize(msg.Width-4, msg.Height-10)
+	}
+
+	var cmd tea.Cmd
+	switch m.mode {
+	case TemplateModeList:
+		m.list, cmd = m.list.Update(msg)
+		cmds = append(cmds, cmd)
+	case TemplateModeEdit:
+		for i := range m.inputs {
+			m.inputs[i], cmd = m.inputs[i].Update(msg)
+			cmds = append(cmds, cmd)
+		}
+	case TemplateModeHistory:
+		m.history, cmd = m.history.Update(msg)
+		cmds = append(cmds, cmd)
+	}
+
+	return m, tea.Batch(cmds...)
+}
+
+// deleteTemplate removes a template and its profile
+func (m *TemplatesModel) deleteTemplate(name string) error {
+	store, err := m.store()
+	if err != nil {
+		return err
+	}
+	if err := store.Delete(name); err != nil {
+		return err
+	}
+	return templateCA(m.config).ApplyTemplates()
+}
+
+// loadHistory fills the history list with the versions of a template
+func (m *TemplatesModel) loadHistory(name string) error {
+	store, err := m.store()
+	if err != nil {
+		return err
+	}
+	versions, err := store.Versions(name)
+	if err != nil {
+		return err
+	}
+	items := make([]list.Item, 0, len(versions))
+	for i := len(versions) - 1; i >= 0; i-- {
+		items = append(items, TemplateItem{template: versions[i], history: true})
+	}
+	m.history.Title = "History of " + name
+	m.history.SetItems(items)
+	m.history.Select(0)
+	return nil
+}
+
+// rollback restores a template version as the newest version
+func (m *TemplatesModel) rollback(t *ca.Template) error {
+	store, err := m.store()
+	if err != nil {
+		return err
+	}
+	saved, err := store.Rollback(t.Name, t.Version)
+	if err != nil {
+		return err
+	}
+	if err := templateCA(m.config).ApplyTemplates(); err != nil {
+		return err
+	}
+	m.message = fmt.Sprintf("Restored version %d of template %s as version %d", t.Version, t.Name, saved.Version)
+	return nil
+}
+
+// View renders the UI
+func (m TemplatesModel) View() string {
+	var b strings.Builder
+
+	b.WriteString(m.styles.titleStyle.Render("Certificate Templates"))
+	b.WriteString("\n\n")
+
+	switch m.mode {
+	case TemplateModeList:
+		if len(m.list.Items()) == 0 {
+			b.WriteString(m.styles.infoStyle.Render("No templates defined yet"))
+			b.WriteString("\n\n")
+		} else {
+			b.WriteString(m.list.View())
+			b.WriteString("\n\n")
+		}
+		b.WriteString("[n] New  [e] Edit  [d] Delete  [h] History  [r] Reload")
+
+	case TemplateModeEdit:
+		if m.editing == nil {
+			b.WriteString("New template:\n\n")
+		} else {
+			b.WriteString(fmt.Sprintf("Edit template %s (version %d):\n\n", m.editing.Name, m.editing.Version))
+		}
+		for i, input := range m.inputs {
+			if i == templateFieldName && m.editing != nil {
+				continue
+			}
+			b.WriteString(input.View())
+			if i < len(m.inputs)-1 {
+				b.WriteString("\n")
+			}
+		}
+		b.WriteString("\n\n[enter] Save  [esc] Cancel")
+
+	case TemplateModeHistory:
+		b.WriteString(m.history.View())
+		b.WriteString("\n\n[enter] Roll back to version  [esc] Back")
+	}
+
+	if m.message != "" {
+		b.WriteString("\n\n")
+		if strings.HasPrefix(m.message, "Error") {
+			b.WriteString(m.styles.errorStyle.Render(m.message))
+		} else {
+			b.WriteString(m.styles.messageStyle.Render(m.message))
+		}
+	}
+
+	return b.String()
+}
+
+// splitFields splits a comma-separated form value
+func splitFields(val string) []string {
+	var fields []string
+	for _, f := range strings.Split(val, ",") {
+		if f = strings.TrimSpace(f); f != "" {
+			fields = append(fields, f)
+		}
+	}
+	return fields
+}
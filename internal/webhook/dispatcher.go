@@ -0,0 +1,196 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Default retry settings
+const (
+	DefaultMaxAttempts  = 10
+	DefaultBaseDelay    = 30 * time.Second
+	DefaultMaxDelay     = 6 * time.Hour
+	DefaultPollInterval = 5 * time.Second
+)
+
+// Dispatcher fans events out to subscriptions and retries failed deliveries
+// with exponential backoff
+type Dispatcher struct {
+	Subscriptions []Subscription
+	Queue         *Queue
+	Client        *http.Client
+	MaxAttempts   int
+	BaseDelay     time.Duration
+	MaxDelay      time.Duration
+	PollInterval  time.Duration
+
+	wake chan struct{}
+}
+
+// NewDispatcher creates a dispatcher with default retry settings
+func NewDispatcher(subs []Subscription, queue *Queue) *Dispatcher {
+	return &Dispatcher{
+		Subscriptions: subs,
+		Queue:         queue,
+		Client:        &http.Client{Timeout: 15 * time.Second},
+		MaxAttempts:   DefaultMaxAttempts,
+		BaseDelay:     DefaultBaseDelay,
+		MaxDelay:      DefaultMaxDelay,
+		PollInterval:  DefaultPollInterval,
+		wake:          make(chan struct{}, 1),
+	}
+}
+
+// Publish queues an event for every matching subscription. It is safe to call
+// on a nil dispatcher, in which case the event is dropped.
+func (d *Dispatcher) Publish(eventType EventType, data map[string]interface{}) error {
+	if d == nil {
+		return nil
+	}
+
+	event := NewEvent(eventType, data)
+
+	var deliveries []*Delivery
+	for _, sub := range d.Subscriptions {
+		if !sub.Matches(eventType) {
+			continue
+		}
+		deliveries = append(deliveries, &Delivery{
+			ID:           newID(),
+			Subscription: sub.Name,
+			Event:        event,
+			NextAttempt:  event.Timestamp,
+		})
+	}
+
+	if len(deliveries) == 0 {
+		return nil
+	}
+
+	if err := d.Queue.Add(deliveries...); err != nil {
+		return fmt.Errorf("failed to queue webhook deliveries: %w", err)
+	}
+
+	// Nudge the run loop so deliveries go out immediately
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+
+	return nil
+}
+
+// Run processes the queue until the context is cancelled
+func (d *Dispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		d.ProcessDue(time.Now())
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-d.wake:
+		}
+	}
+}
+
+// ProcessDue attempts every delivery that is due at the given time
+func (d *Dispatcher) ProcessDue(now time.Time) {
+	for _, delivery := range d.Queue.Due(now) {
+		sub, ok := d.subscription(delivery.Subscription)
+		if !ok {
+			// The subscription was removed from the configuration
+			log.Printf("Dropping webhook delivery %s for unknown subscription %s", delivery.ID, delivery.Subscription)
+			d.Queue.Remove(delivery.ID)
+			continue
+		}
+
+		err := d.send(sub, delivery)
+		if err == nil {
+			d.Queue.Remove(delivery.ID)
+			continue
+		}
+
+		delivery.Attempts++
+		delivery.LastError = err.Error()
+		if delivery.Attempts >= d.MaxAttempts {
+			log.Printf("Giving up on webhook delivery %s to %s after %d attempts: %v",
+				delivery.ID, sub.Name, delivery.Attempts, err)
+			d.Queue.Remove(delivery.ID)
+			continue
+		}
+
+		delivery.NextAttempt = now.Add(d.backoff(delivery.Attempts))
+		log.Printf("Webhook delivery %s to %s failed (attempt %d), retrying at %s: %v",
+			delivery.ID, sub.Name, delivery.Attempts, delivery.NextAttempt.Format(time.RFC3339), err)
+		if err := d.Queue.Update(delivery); err != nil {
+			log.Printf("Error persisting webhook delivery %s: %v", delivery.ID, err)
+		}
+	}
+}
+
+// backoff returns the delay before the given retry attempt
+func (d *Dispatcher) backoff(attempts int) time.Duration {
+	delay := d.BaseDelay
+	for i := 1; i < attempts; i++ {
+		delay *= 2
+		if delay >= d.MaxDelay {
+			return d.MaxDelay
+		}
+	}
+	return delay
+}
+
+// send performs a single HTTP delivery
+func (d *Dispatcher) send(sub Subscription, delivery *Delivery) error {
+	body, err := json.Marshal(delivery.Event)
+	if err != nil {
+		return fmt.Errorf("failed to encode event: %w", err)
+	}
+
+	timestamp := time.Now()
+	req, err := http.NewRequest(http.MethodPost, sub.URL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "PiCA-Webhook/1.0")
+	req.Header.Set(HeaderEvent, string(delivery.Event.Type))
+	req.Header.Set(HeaderDelivery, delivery.ID)
+	req.Header.Set(HeaderTimestamp, strconv.FormatInt(timestamp.Unix(), 10))
+	if sub.Secret != "" {
+		req.Header.Set(HeaderSignature, Sign(sub.Secret, timestamp, body))
+	}
+
+	resp, err := d.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+	return nil
+}
+
+// subscription looks up a subscription by name
+func (d *Dispatcher) subscription(name string) (Subscription, bool) {
+	for _, sub := range d.Subscriptions {
+		if sub.Name == name {
+			return sub, true
+		}
+	}
+	return Subscription{}, false
+}
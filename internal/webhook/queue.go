@@ -0,0 +1,133 @@
+package webhook
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Delivery is a single pending attempt to send an event to a subscription
+type Delivery struct {
+	ID           string    `json:"id"`
+	Subscription string    `json:"subscription"`
+	Event        Event     `json:"event"`
+	Attempts     int       `json:"attempts"`
+	NextAttempt  time.Time `json:"nextAttempt"`
+	LastError    string    `json:"lastError,omitempty"`
+}
+
+// Queue is a delivery queue persisted to a JSON file so that pending
+// deliveries survive restarts
+type Queue struct {
+	filename   string
+	deliveries map[string]*Delivery
+	mutex      sync.Mutex
+}
+
+// OpenQueue opens (or creates) the queue stored in the given directory
+func OpenQueue(dir string) (*Queue, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create queue directory: %w", err)
+	}
+
+	q := &Queue{
+		filename:   filepath.Join(dir, "queue.json"),
+		deliveries: make(map[string]*Delivery),
+	}
+
+	data, err := os.ReadFile(q.filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return q, nil
+		}
+		return nil, fmt.Errorf("failed to read queue: %w", err)
+	}
+
+	var deliveries []*Delivery
+	if err := json.Unmarshal(data, &deliveries); err != nil {
+		return nil, fmt.Errorf("failed to parse queue: %w", err)
+	}
+	for _, d := range deliveries {
+		q.deliveries[d.ID] = d
+	}
+
+	return q, nil
+}
+
+// Add adds deliveries to the queue and persists it
+func (q *Queue) Add(deliveries ...*Delivery) error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	for _, d := range deliveries {
+		q.deliveries[d.ID] = d
+	}
+	return q.save()
+}
+
+// Update persists a modified delivery
+func (q *Queue) Update(d *Delivery) error {
+	return q.Add(d)
+}
+
+// Remove removes a delivery from the queue and persists it
+func (q *Queue) Remove(id string) error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	delete(q.deliveries, id)
+	return q.save()
+}
+
+// Due returns copies of the deliveries whose next attempt is at or before now,
+// oldest first
+func (q *Queue) Due(now time.Time) []*Delivery {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	var due []*Delivery
+	for _, d := range q.deliveries {
+		if !d.NextAttempt.After(now) {
+			c := *d
+			due = append(due, &c)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool {
+		return due[i].Event.Timestamp.Before(due[j].Event.Timestamp)
+	})
+	return due
+}
+
+// Len returns the number of pending deliveries
+func (q *Queue) Len() int {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	return len(q.deliveries)
+}
+
+// save writes the queue to disk atomically. Callers must hold the mutex.
+func (q *Queue) save() error {
+	deliveries := make([]*Delivery, 0, len(q.deliveries))
+	for _, d := range q.deliveries {
+		deliveries = append(deliveries, d)
+	}
+
+	data, err := json.MarshalIndent(deliveries, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode queue: %w", err)
+	}
+
+	tmp := q.filename + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("failed to write queue: %w", err)
+	}
+	if err := os.Rename(tmp, q.filename); err != nil {
+		return fmt.Errorf("failed to replace queue: %w", err)
+	}
+	return nil
+}
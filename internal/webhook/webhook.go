@@ -0,0 +1,136 @@
+// Package webhook delivers certificate lifecycle events to external systems
+// such as inventories and CMDBs using HMAC-signed JSON payloads.
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+// EventType identifies a certificate lifecycle event
+type EventType string
+
+const (
+	// EventIssued is published after a certificate has been signed
+	EventIssued EventType = "issued"
+	// EventRevoked is published after a certificate has been revoked
+	EventRevoked EventType = "revoked"
+	// EventCRLPublished is published after a new CRL has been written
+	EventCRLPublished EventType = "crl-published"
+	// EventRequestPending is published when a CSR has been received
+	EventRequestPending EventType = "request-pending"
+)
+
+// Header names used on every delivery
+const (
+	HeaderEvent     = "X-PiCA-Event"
+	HeaderDelivery  = "X-PiCA-Delivery"
+	HeaderTimestamp = "X-PiCA-Timestamp"
+	HeaderSignature = "X-PiCA-Signature"
+)
+
+// Event is a single lifecycle event
+type Event struct {
+	ID        string                 `json:"id"`
+	Type      EventType              `json:"type"`
+	Timestamp time.Time              `json:"timestamp"`
+	Data      map[string]interface{} `json:"data"`
+}
+
+// NewEvent creates a new event with a random ID and the current time
+func NewEvent(eventType EventType, data map[string]interface{}) Event {
+	return Event{
+		ID:        newID(),
+		Type:      eventType,
+		Timestamp: time.Now().UTC(),
+		Data:      data,
+	}
+}
+
+// Subscription describes a single webhook endpoint
+type Subscription struct {
+	Name   string      `json:"name"`
+	URL    string      `json:"url"`
+	Secret string      `json:"secret"`
+	Events []EventType `json:"events"`
+}
+
+// Matches reports whether the subscription wants the given event type.
+// A subscription without an event filter receives every event.
+func (s Subscription) Matches(eventType EventType) bool {
+	if len(s.Events) == 0 {
+		return true
+	}
+	for _, e := range s.Events {
+		if e == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// LoadSubscriptions reads webhook subscriptions from a JSON file
+func LoadSubscriptions(filename string) ([]Subscription, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read webhook file: %w", err)
+	}
+
+	var file struct {
+		Webhooks []Subscription `json:"webhooks"`
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse webhook file: %w", err)
+	}
+
+	for i, sub := range file.Webhooks {
+		if sub.URL == "" {
+			return nil, fmt.Errorf("webhook %d has no URL", i)
+		}
+		if sub.Name == "" {
+			file.Webhooks[i].Name = sub.URL
+		}
+		for _, e := range sub.Events {
+			switch e {
+			case EventIssued, EventRevoked, EventCRLPublished, EventRequestPending:
+			default:
+				return nil, fmt.Errorf("webhook %s has unknown event type: %s", sub.Name, e)
+			}
+		}
+	}
+
+	return file.Webhooks, nil
+}
+
+// Sign computes the signature header value for a payload. The signed message
+// is the decimal Unix timestamp, a period and the raw request body, so that
+// receivers can reject replayed deliveries.
+func Sign(secret string, timestamp time.Time, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp.Unix(), 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks a signature header value produced by Sign
+func Verify(secret string, timestamp time.Time, body []byte, signature string) bool {
+	expected := Sign(secret, timestamp, body)
+	return hmac.Equal([]byte(expected), []byte(signature))
+}
+
+// newID returns a random hex identifier
+func newID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 16)
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,115 @@
+package webhook
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSignAndVerify(t *testing.T) {
+	ts := time.Unix(1700000000, 0)
+	body := []byte(`{"type":"issued"}`)
+
+	sig := Sign("secret", ts, body)
+	if !Verify("secret", ts, body, sig) {
+		t.Errorf("Expected signature to verify")
+	}
+	if Verify("other", ts, body, sig) {
+		t.Errorf("Expected signature with wrong secret to fail")
+	}
+	if Verify("secret", ts.Add(time.Second), body, sig) {
+		t.Errorf("Expected signature with wrong timestamp to fail")
+	}
+}
+
+func TestDispatcherDeliversAndRetries(t *testing.T) {
+	var mu sync.Mutex
+	calls := 0
+	var received Event
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		calls++
+
+		body, _ := io.ReadAll(r.Body)
+		unix, _ := strconv.ParseInt(r.Header.Get(HeaderTimestamp), 10, 64)
+		if !Verify("s3cret", time.Unix(unix, 0), body, r.Header.Get(HeaderSignature)) {
+			t.Errorf("Delivery signature did not verify")
+		}
+
+		// Fail the first attempt to exercise the retry path
+		if calls == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		json.Unmarshal(body, &received)
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	queue, err := OpenQueue(dir)
+	if err != nil {
+		t.Fatalf("Failed to open queue: %v", err)
+	}
+
+	subs := []Subscription{
+		{Name: "cmdb", URL: srv.URL, Secret: "s3cret", Events: []EventType{EventIssued}},
+		{Name: "crl-only", URL: srv.URL, Events: []EventType{EventCRLPublished}},
+	}
+	d := NewDispatcher(subs, queue)
+	d.BaseDelay = time.Minute
+
+	if err := d.Publish(EventIssued, map[string]interface{}{"serialNumber": "01"}); err != nil {
+		t.Fatalf("Failed to publish: %v", err)
+	}
+	if queue.Len() != 1 {
+		t.Fatalf("Expected 1 queued delivery, got %d", queue.Len())
+	}
+
+	now := time.Now()
+	d.ProcessDue(now)
+	if queue.Len() != 1 {
+		t.Fatalf("Expected failed delivery to stay queued")
+	}
+
+	// The queue must survive a restart
+	reopened, err := OpenQueue(dir)
+	if err != nil {
+		t.Fatalf("Failed to reopen queue: %v", err)
+	}
+	if reopened.Len() != 1 {
+		t.Fatalf("Expected persisted delivery after reopen, got %d", reopened.Len())
+	}
+	d.Queue = reopened
+
+	// Not yet due
+	d.ProcessDue(now.Add(30 * time.Second))
+	if calls != 1 {
+		t.Errorf("Expected backoff to delay retry, got %d calls", calls)
+	}
+
+	d.ProcessDue(now.Add(2 * time.Minute))
+	if reopened.Len() != 0 {
+		t.Errorf("Expected queue to be empty after successful retry")
+	}
+	if received.Type != EventIssued || received.Data["serialNumber"] != "01" {
+		t.Errorf("Unexpected event received: %+v", received)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	d := &Dispatcher{BaseDelay: time.Second, MaxDelay: 10 * time.Second}
+
+	expected := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 10 * time.Second}
+	for i, want := range expected {
+		if got := d.backoff(i + 1); got != want {
+			t.Errorf("Attempt %d: expected %s, got %s", i+1, want, got)
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package yubikey
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Card exchanges APDUs with a smart card. PC/SC readers and the virtual
+// card implement it.
+type Card interface {
+	// Transmit sends a command APDU and returns the response APDU,
+	// including the status word
+	Transmit(command []byte) ([]byte, error)
+
+	// Begin and End bracket a sequence of commands that other
+	// applications must not interleave with
+	Begin() error
+	End() error
+
+	// Close releases the card
+	Close() error
+}
+
+// Instructions of the PIV applet and the YubiKey extensions to it
+const (
+	insVerify             = 0x20
+	insChangeReference    = 0x24
+	insResetRetry         = 0x2C
+	insGenerateAsymmetric = 0x47
+	insGeneralAuth        = 0x87
+	insSelect             = 0xA4
+	insGetResponse        = 0xC0
+	insGetData            = 0xCB
+	insPutData            = 0xDB
+	insMoveKey            = 0xF6
+	insMetadata           = 0xF7
+	insGetSerial          = 0xF8
+	insAttest             = 0xF9
+	insGetVersion         = 0xFD
+	insImportKey          = 0xFE
+	insSetManagementKey   = 0xFF
+)
+
+// Status words
+const (
+	swSuccess                = 0x9000
+	swBytesRemaining         = 0x6100
+	swVerifyFailed           = 0x63C0
+	swWrongLength            = 0x6700
+	swSecurityNotSatisfied   = 0x6982
+	swAuthMethodBlocked      = 0x6983
+	swConditionsNotSatisfied = 0x6985
+	swIncorrectData          = 0x6A80
+	swFileNotFound           = 0x6A82
+	swReferenceNotFound      = 0x6A88
+	swWrongP1P2              = 0x6B00
+	swInsNotSupported        = 0x6D00
+	swClassNotSupported      = 0x6E00
+)
+
+// claChaining marks every command of a chain but the last
+const claChaining = 0x10
+
+// maxAPDUData is the most data a short APDU carries
+const maxAPDUData = 0xFF
+
+// pivAID selects the PIV applet
+var pivAID = []byte{0xA0, 0x00, 0x00, 0x03, 0x08}
+
+// apdu is a command APDU
+type apdu struct {
+	cla, ins, p1, p2 byte
+	data             []byte
+}
+
+// bytes encodes the command as a short APDU
+func (a apdu) bytes() []byte {
+	b := make([]byte, 0, 5+len(a.data))
+	b = append(b, a.cla, a.ins, a.p1, a.p2, byte(len(a.data)))
+	return append(b, a.data...)
+}
+
+// StatusError is returned when the card answers with an error status word
+type StatusError struct {
+	SW uint16
+}
+
+func (e *StatusError) Error() string {
+	var msg string
+	switch {
+	case e.SW&0xFFF0 == swVerifyFailed:
+		msg = fmt.Sprintf("verification failed, %d retries left", e.SW&0x0F)
+	case e.SW == swWrongLength:
+		msg = "wrong length"
+	case e.SW == swSecurityNotSatisfied:
+		msg = "security status not satisfied"
+	case e.SW == swAuthMethodBlocked:
+		msg = "authentication method blocked"
+	case e.SW == swConditionsNotSatisfied:
+		msg = "conditions of use not satisfied"
+	case e.SW == swIncorrectData:
+		msg = "incorrect data"
+	case e.SW == swFileNotFound:
+		msg = "data object or application not found"
+	case e.SW == swReferenceNotFound:
+		msg = "referenced data not found"
+	case e.SW == swWrongP1P2:
+		msg = "incorrect parameters"
+	case e.SW == swInsNotSupported:
+		msg = "instruction not supported"
+	case e.SW == swClassNotSupported:
+		msg = "class not supported"
+	default:
+		msg = "card error"
+	}
+	return fmt.Sprintf("%s (SW %04X)", msg, e.SW)
+}
+
+// isStatus reports whether err is a StatusError with the given status word
+func isStatus(err error, sw uint16) bool {
+	var se *StatusError
+	return errors.As(err, &se) && se.SW == sw
+}
+
+// splitResponse separates a response APDU into data and status word
+func splitResponse(resp []byte) ([]byte, uint16, error) {
+	if len(resp) < 2 {
+		return nil, 0, fmt.Errorf("response too short: %d bytes", len(resp))
+	}
+	n := len(resp) - 2
+	return resp[:n], uint16(resp[n])<<8 | uint16(resp[n+1]), nil
+}
+
+// transmit sends a command to the card. Data longer than a short APDU is
+// sent as a command chain and long responses are collected with GET
+// RESPONSE.
+func transmit(card Card, cmd apdu) ([]byte, error) {
+	data := cmd.data
+	for len(data) > maxAPDUData {
+		chunk := apdu{cla: cmd.cla | claChaining, ins: cmd.ins, p1: cmd.p1, p2: cmd.p2, data: data[:maxAPDUData]}
+		resp, err := card.Transmit(chunk.bytes())
+		if err != nil {
+			return nil, err
+		}
+		if _, sw, err := splitResponse(resp); err != nil {
+			return nil, err
+		} else if sw != swSuccess {
+			return nil, &StatusError{SW: sw}
+		}
+		data = data[maxAPDUData:]
+	}
+
+	last := cmd
+	last.data = data
+	resp, err := card.Transmit(last.bytes())
+	if err != nil {
+		return nil, err
+	}
+
+	var out []byte
+	for {
+		body, sw, err := splitResponse(resp)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, body...)
+		if sw&0xFF00 != swBytesRemaining {
+			if sw != swSuccess {
+				return nil, &StatusError{SW: sw}
+			}
+			return out, nil
+		}
+		if resp, err = card.Transmit(apdu{ins: insGetResponse}.bytes()); err != nil {
+			return nil, err
+		}
+	}
+}
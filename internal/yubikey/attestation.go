@@ -0,0 +1,217 @@
+package yubikey
+
+import (
+	"crypto"
+	"crypto/x509"
+	"embed"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"io/fs"
+	"strings"
+	"sync"
+)
+
+// Extensions of slot attestation certificates
+var (
+	oidFirmware   = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 41482, 3, 3}
+	oidSerial     = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 41482, 3, 7}
+	oidPolicy     = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 41482, 3, 8}
+	oidFormFactor = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 41482, 3, 9}
+)
+
+var (
+	// ErrNotAttestable is returned for keys that were imported rather than
+	// generated on the card
+	ErrNotAttestable = errors.New("key cannot be attested")
+
+	// ErrInvalidAttestation is returned when an attestation does not verify
+	ErrInvalidAttestation = errors.New("invalid attestation")
+
+	// ErrNoAttestationRoots is returned along with ErrInvalidAttestation
+	// when no root is trusted at all, e.g. in a build without the Yubico
+	// root and without attestation_roots
+	ErrNoAttestationRoots = errors.New("no attestation roots are trusted; set attestation_roots to the Yubico PIV attestation root")
+)
+
+// Attestation is what a slot attestation certificate states about a key
+// generated on a YubiKey
+type Attestation struct {
+	Slot        PIVSlot
+	Serial      uint32
+	Version     string
+	FormFactor  byte
+	PINPolicy   byte
+	TouchPolicy byte
+	PublicKey   crypto.PublicKey
+}
+
+// Attest returns the attestation certificate of a key generated in a slot,
+// signed by the card's attestation key
+func (yk *YubiKey) Attest(slot PIVSlot) (*x509.Certificate, error) {
+	if !slot.isKeySlot() || slot == SlotAttestation {
+		return nil, fmt.Errorf("invalid PIV slot %X", int(slot))
+	}
+
+	var cert *x509.Certificate
+	err := yk.locked(func() error {
+		resp, err := transmit(yk.card, apdu{ins: insAttest, p1: byte(slot)})
+		switch {
+		case isStatus(err, swReferenceNotFound):
+			return ErrKeyNotFound
+		case isStatus(err, swIncorrectData):
+			return fmt.Errorf("%w: slot %X holds an imported key", ErrNotAttestable, int(slot))
+		case err != nil:
+			return fmt.Errorf("failed to attest key: %w", err)
+		}
+		cert, err = x509.ParseCertificate(resp)
+		return err
+	})
+	return cert, err
+}
+
+// AttestationCertificate returns the card's attestation certificate, which
+// signs slot attestations and is itself issued by Yubico
+func (yk *YubiKey) AttestationCertificate() (*x509.Certificate, error) {
+	return yk.GetCertificate(SlotAttestation)
+}
+
+// VerifyAttestation checks that a slot attestation certificate was signed
+// by a device attestation certificate that chains to one of the roots, and
+// returns what it states about the key. intermediates starts with the
+// device certificate; newer firmware adds Yubico intermediates after it. A
+// nil roots pool uses the bundled Yubico roots.
+func VerifyAttestation(leaf *x509.Certificate, intermediates []*x509.Certificate, roots *x509.CertPool) (*Attestation, error) {
+	if len(intermediates) == 0 {
+		return nil, fmt.Errorf("%w: no device attestation certificate", ErrInvalidAttestation)
+	}
+	if roots == nil {
+		roots = YubicoRoots()
+	}
+
+	// Device attestation certificates are not marked as CAs, so the slot
+	// certificate's signature is checked directly
+	device := intermediates[0]
+	if err := device.CheckSignature(leaf.SignatureAlgorithm, leaf.RawTBSCertificate, leaf.Signature); err != nil {
+		return nil, fmt.Errorf("%w: slot certificate not signed by the device: %w", ErrInvalidAttestation, err)
+	}
+
+	pool := x509.NewCertPool()
+	for _, cert := range intermediates[1:] {
+		pool.AddCert(cert)
+	}
+	if _, err := device.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: pool,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	}); err != nil {
+		if roots.Equal(x509.NewCertPool()) {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidAttestation, ErrNoAttestationRoots)
+		}
+		return nil, fmt.Errorf("%w: device certificate: %w", ErrInvalidAttestation, err)
+	}
+
+	return parseAttestation(leaf)
+}
+
+// parseAttestation reads the YubiKey extensions of a slot attestation
+// certificate
+func parseAttestation(cert *x509.Certificate) (*Attestation, error) {
+	a := &Attestation{PublicKey: cert.PublicKey}
+
+	var slot int
+	if _, err := fmt.Sscanf(strings.TrimPrefix(cert.Subject.CommonName, "YubiKey PIV Attestation "), "%x", &slot); err == nil {
+		a.Slot = PIVSlot(slot)
+	}
+
+	seen := make(map[string]bool)
+	for _, ext := range cert.Extensions {
+		switch {
+		case ext.Id.Equal(oidFirmware):
+			if len(ext.Value) != 3 {
+				return nil, fmt.Errorf("%w: malformed firmware version", ErrInvalidAttestation)
+			}
+			a.Version = fmt.Sprintf("%d.%d.%d", ext.Value[0], ext.Value[1], ext.Value[2])
+		case ext.Id.Equal(oidSerial):
+			var serial int64
+			if rest, err := asn1.Unmarshal(ext.Value, &serial); err != nil || len(rest) > 0 || serial < 0 || serial > 1<<32-1 {
+				return nil, fmt.Errorf("%w: malformed serial number", ErrInvalidAttestation)
+			}
+			a.Serial = uint32(serial)
+		case ext.Id.Equal(oidPolicy):
+			if len(ext.Value) != 2 {
+				return nil, fmt.Errorf("%w: malformed key policy", ErrInvalidAttestation)
+			}
+			a.PINPolicy, a.TouchPolicy = ext.Value[0], ext.Value[1]
+		case ext.Id.Equal(oidFormFactor):
+			if len(ext.Value) != 1 {
+				return nil, fmt.Errorf("%w: malformed form factor", ErrInvalidAttestation)
+			}
+			a.FormFactor = ext.Value[0]
+		default:
+			continue
+		}
+		seen[ext.Id.String()] = true
+	}
+
+	// The firmware and policy are present on every YubiKey 4.3 and later
+	if !seen[oidFirmware.String()] || !seen[oidPolicy.String()] {
+		return nil, fmt.Errorf("%w: not a YubiKey slot attestation", ErrInvalidAttestation)
+	}
+	return a, nil
+}
+
+// PINPolicyName returns the name of a PIN policy
+func PINPolicyName(policy byte) string {
+	switch policy {
+	case PINPolicyDefault:
+		return "default"
+	case PINPolicyNever:
+		return "never"
+	case PINPolicyOnce:
+		return "once"
+	case PINPolicyAlways:
+		return "always"
+	}
+	return fmt.Sprintf("unknown (%d)", policy)
+}
+
+// TouchPolicyName returns the name of a touch policy
+func TouchPolicyName(policy byte) string {
+	switch policy {
+	case TouchPolicyDefault:
+		return "default"
+	case TouchPolicyNever:
+		return "never"
+	case TouchPolicyAlways:
+		return "always"
+	case TouchPolicyCached:
+		return "cached"
+	}
+	return fmt.Sprintf("unknown (%d)", policy)
+}
+
+// roots holds the Yubico attestation root certificates as PEM files
+//
+//go:embed roots
+var roots embed.FS
+
+var (
+	yubicoRoots     *x509.CertPool
+	yubicoRootsOnce sync.Once
+)
+
+// YubicoRoots returns the bundled Yubico attestation roots, the PEM files
+// of the roots directory
+func YubicoRoots() *x509.CertPool {
+	yubicoRootsOnce.Do(func() {
+		yubicoRoots = x509.NewCertPool()
+		files, _ := fs.Glob(roots, "roots/*.pem")
+		for _, name := range files {
+			if data, err := roots.ReadFile(name); err == nil {
+				yubicoRoots.AppendCertsFromPEM(data)
+			}
+		}
+	})
+	return yubicoRoots.Clone()
+}
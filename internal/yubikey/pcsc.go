@@ -0,0 +1,308 @@
+package yubikey
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+)
+
+// PC/SC access talks to pcscd, the pcsc-lite daemon, over its Unix socket
+// using the daemon's client protocol, so no C library is needed. This
+// covers Linux, where PiCA runs on the Raspberry Pi.
+
+// defaultPCSCSocket is where pcscd listens unless PCSCLITE_CSOCK_NAME says
+// otherwise
+const defaultPCSCSocket = "/run/pcscd/pcscd.comm"
+
+// Client protocol version spoken to pcscd
+const (
+	pcscProtocolMajor = 4
+	pcscProtocolMinor = 4
+)
+
+// pcscd commands
+const (
+	pcscEstablishContext = 0x01
+	pcscReleaseContext   = 0x02
+	pcscConnect          = 0x04
+	pcscDisconnect       = 0x06
+	pcscBeginTransaction = 0x07
+	pcscEndTransaction   = 0x08
+	pcscTransmit         = 0x09
+	pcscVersion          = 0x11
+	pcscGetReadersState  = 0x12
+)
+
+// PC/SC constants
+const (
+	pcscScopeSystem       = 0x0002
+	pcscShareShared       = 0x0002
+	pcscProtocolT0        = 0x0001
+	pcscProtocolT1        = 0x0002
+	pcscLeaveCard         = 0x0000
+	pcscMaxReaderName     = 128
+	pcscMaxReaders        = 16
+	pcscMaxATR            = 33
+	pcscMaxBuffer         = 264
+	pcscReaderStateSize   = pcscMaxReaderName + 4*3 + pcscMaxATR + 3 + 4*2
+	pcscIORequestSize     = 8
+	pcscSuccess           = 0x00000000
+	pcscNoSmartcard       = 0x8010000C
+	pcscReaderUnavailable = 0x80100017
+)
+
+// pcscError is a PC/SC return code
+type pcscError uint32
+
+func (e pcscError) Error() string {
+	switch uint32(e) {
+	case pcscNoSmartcard:
+		return "no smart card in reader"
+	case pcscReaderUnavailable:
+		return "reader unavailable"
+	}
+	return fmt.Sprintf("PC/SC error %08X", uint32(e))
+}
+
+// pcscConn is a connection to pcscd with an established context
+type pcscConn struct {
+	conn    net.Conn
+	context uint32
+}
+
+// dialPCSC connects to pcscd and establishes a context
+func dialPCSC() (*pcscConn, error) {
+	path := os.Getenv("PCSCLITE_CSOCK_NAME")
+	if path == "" {
+		path = defaultPCSCSocket
+	}
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to pcscd (is it running?): %w", err)
+	}
+	c := &pcscConn{conn: conn}
+
+	// version: major, minor, rv
+	resp, err := c.call(pcscVersion, []uint32{pcscProtocolMajor, pcscProtocolMinor, 0}, nil, 0)
+	if err == nil {
+		err = rv(resp[2])
+	}
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("pcscd protocol version check failed: %w", err)
+	}
+
+	// establish: scope, context, rv
+	resp, err = c.call(pcscEstablishContext, []uint32{pcscScopeSystem, 0, 0}, nil, 0)
+	if err == nil {
+		err = rv(resp[2])
+	}
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to establish PC/SC context: %w", err)
+	}
+	c.context = resp[1]
+	return c, nil
+}
+
+// rv converts a PC/SC return value to an error
+func rv(code uint32) error {
+	if code == pcscSuccess {
+		return nil
+	}
+	return pcscError(code)
+}
+
+// call sends a command with a message of 32-bit fields, optionally
+// preceded by raw bytes, and reads back the same number of fields
+func (c *pcscConn) call(command uint32, fields []uint32, raw []byte, rawOffset int) ([]uint32, error) {
+	msg := encodeFields(fields, raw, rawOffset)
+	if err := c.send(command, msg); err != nil {
+		return nil, err
+	}
+	reply := make([]byte, len(msg))
+	if _, err := io.ReadFull(c.conn, reply); err != nil {
+		return nil, fmt.Errorf("failed to read pcscd reply: %w", err)
+	}
+	return decodeFields(reply, len(fields), len(raw), rawOffset), nil
+}
+
+// send writes a message header and message. Payload that follows the
+// message, such as a command APDU, is not counted in the header.
+func (c *pcscConn) send(command uint32, msg []byte, payload ...byte) error {
+	header := make([]byte, 8, 8+len(msg)+len(payload))
+	binary.NativeEndian.PutUint32(header, uint32(len(msg)))
+	binary.NativeEndian.PutUint32(header[4:], command)
+	header = append(append(header, msg...), payload...)
+	if _, err := c.conn.Write(header); err != nil {
+		return fmt.Errorf("failed to write to pcscd: %w", err)
+	}
+	return nil
+}
+
+// encodeFields lays out 32-bit fields in native byte order with raw bytes
+// inserted before field rawOffset, matching the C structures of pcsc-lite
+func encodeFields(fields []uint32, raw []byte, rawOffset int) []byte {
+	msg := make([]byte, 0, 4*len(fields)+len(raw))
+	for i, f := range fields {
+		if i == rawOffset {
+			msg = append(msg, raw...)
+		}
+		msg = binary.NativeEndian.AppendUint32(msg, f)
+	}
+	return msg
+}
+
+// decodeFields reverses encodeFields, skipping the raw bytes
+func decodeFields(msg []byte, n, rawLen, rawOffset int) []uint32 {
+	fields := make([]uint32, n)
+	off := 0
+	for i := range fields {
+		if i == rawOffset {
+			off += rawLen
+		}
+		fields[i] = binary.NativeEndian.Uint32(msg[off:])
+		off += 4
+	}
+	return fields
+}
+
+// readers lists the readers known to pcscd
+func (c *pcscConn) readers() ([]string, error) {
+	if err := c.send(pcscGetReadersState, nil); err != nil {
+		return nil, err
+	}
+	states := make([]byte, pcscMaxReaders*pcscReaderStateSize)
+	if _, err := io.ReadFull(c.conn, states); err != nil {
+		return nil, fmt.Errorf("failed to read reader states: %w", err)
+	}
+
+	var names []string
+	for i := 0; i < pcscMaxReaders; i++ {
+		name := states[i*pcscReaderStateSize : i*pcscReaderStateSize+pcscMaxReaderName]
+		if n := bytes.IndexByte(name, 0); n > 0 {
+			names = append(names, string(name[:n]))
+		}
+	}
+	return names, nil
+}
+
+// close releases the context and the connection
+func (c *pcscConn) close() error {
+	c.call(pcscReleaseContext, []uint32{c.context, 0}, nil, 0)
+	return c.conn.Close()
+}
+
+// Readers lists the PC/SC readers attached to the system
+func Readers() ([]string, error) {
+	c, err := dialPCSC()
+	if err != nil {
+		return nil, err
+	}
+	defer c.close()
+	return c.readers()
+}
+
+// pcscCard is a card in a PC/SC reader
+type pcscCard struct {
+	conn     *pcscConn
+	handle   uint32
+	protocol uint32
+	mutex    sync.Mutex
+}
+
+// connectPCSC connects to the card in a reader
+func connectPCSC(reader string) (*pcscCard, error) {
+	if len(reader) >= pcscMaxReaderName {
+		return nil, errors.New("reader name too long")
+	}
+	c, err := dialPCSC()
+	if err != nil {
+		return nil, err
+	}
+
+	// connect: context, reader name, share mode, protocols, card, active protocol, rv
+	name := make([]byte, pcscMaxReaderName)
+	copy(name, reader)
+	resp, err := c.call(pcscConnect, []uint32{c.context, pcscShareShared, pcscProtocolT0 | pcscProtocolT1, 0, 0, 0}, name, 1)
+	if err == nil {
+		err = rv(resp[5])
+	}
+	if err != nil {
+		c.close()
+		return nil, fmt.Errorf("failed to connect to %s: %w", reader, err)
+	}
+	return &pcscCard{conn: c, handle: resp[3], protocol: resp[4]}, nil
+}
+
+// Transmit implements Card
+func (p *pcscCard) Transmit(command []byte) ([]byte, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	// transmit: card, send protocol, send PCI length, send length, receive
+	// protocol, receive PCI length, receive length, rv; the command follows
+	fields := []uint32{p.handle, p.protocol, pcscIORequestSize, uint32(len(command)), 0, 0, pcscMaxBuffer, 0}
+	msg := encodeFields(fields, nil, -1)
+	if err := p.conn.send(pcscTransmit, msg, command...); err != nil {
+		return nil, err
+	}
+
+	reply := make([]byte, len(msg))
+	if _, err := io.ReadFull(p.conn.conn, reply); err != nil {
+		return nil, fmt.Errorf("failed to read pcscd reply: %w", err)
+	}
+	resp := decodeFields(reply, len(fields), 0, -1)
+	if err := rv(resp[7]); err != nil {
+		return nil, err
+	}
+	if resp[6] > pcscMaxBuffer {
+		return nil, errors.New("pcscd response too long")
+	}
+	data := make([]byte, resp[6])
+	if _, err := io.ReadFull(p.conn.conn, data); err != nil {
+		return nil, fmt.Errorf("failed to read card response: %w", err)
+	}
+	return data, nil
+}
+
+// Begin implements Card
+func (p *pcscCard) Begin() error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	// begin: card, rv
+	resp, err := p.conn.call(pcscBeginTransaction, []uint32{p.handle, 0}, nil, -1)
+	if err != nil {
+		return err
+	}
+	return rv(resp[1])
+}
+
+// End implements Card
+func (p *pcscCard) End() error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	// end: card, disposition, rv
+	resp, err := p.conn.call(pcscEndTransaction, []uint32{p.handle, pcscLeaveCard, 0}, nil, -1)
+	if err != nil {
+		return err
+	}
+	return rv(resp[2])
+}
+
+// Close implements Card
+func (p *pcscCard) Close() error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	// disconnect: card, disposition, rv
+	p.conn.call(pcscDisconnect, []uint32{p.handle, pcscLeaveCard, 0}, nil, -1)
+	return p.conn.close()
+}
@@ -0,0 +1,117 @@
+package yubikey
+
+import (
+	"crypto"
+	"crypto/x509"
+	"errors"
+	"fmt"
+)
+
+// SlotInfo describes what a key slot of the card holds
+type SlotInfo struct {
+	Slot PIVSlot
+	// HasKey is false for slots that only hold a certificate
+	HasKey bool
+	// Algorithm, the policies and Generated come from the key metadata.
+	// Algorithm is zero on firmware before 5.3, which has none; HasKey
+	// and PublicKey then rely on the certificate.
+	Algorithm   byte
+	PINPolicy   byte
+	TouchPolicy byte
+	Generated   bool
+	PublicKey   crypto.PublicKey
+	Certificate *x509.Certificate
+}
+
+// KeySlots returns the slots that can hold a generated or imported key
+func KeySlots() []PIVSlot {
+	slots := []PIVSlot{SlotAuthentication, SlotSignature, SlotKeyManagement, SlotCardAuth}
+	for s := slotRetiredFirst; s <= slotRetiredLast; s++ {
+		slots = append(slots, s)
+	}
+	return slots
+}
+
+// Slots reports the key slots that hold a key or a certificate
+func (yk *YubiKey) Slots() ([]SlotInfo, error) {
+	var slots []SlotInfo
+	err := yk.locked(func() error {
+		for _, slot := range KeySlots() {
+			info := SlotInfo{Slot: slot}
+
+			md, err := yk.metadata(slot)
+			noMetadata := isStatus(err, swInsNotSupported)
+			switch {
+			case err == nil:
+				info.HasKey = true
+				info.Algorithm = md.algorithm
+				info.PINPolicy, info.TouchPolicy = md.pinPolicy, md.touchPolicy
+				info.Generated = md.origin == originGenerated
+				info.PublicKey = md.publicKey
+			case isStatus(err, swReferenceNotFound), noMetadata:
+			default:
+				return fmt.Errorf("failed to read metadata of slot %X: %w", int(slot), err)
+			}
+
+			cert, err := yk.certificate(slot)
+			if err != nil && !errors.Is(err, ErrCertNotFound) {
+				return fmt.Errorf("slot %X: %w", int(slot), err)
+			}
+			info.Certificate = cert
+
+			// Without metadata a certificate is the only sign of a key
+			if cert != nil && noMetadata {
+				info.HasKey = true
+				info.PublicKey = cert.PublicKey
+			}
+			if info.HasKey || cert != nil {
+				slots = append(slots, info)
+			}
+		}
+		return nil
+	})
+	return slots, err
+}
+
+// DeleteKey removes the key of a slot together with its certificate.
+// Deleting keys needs firmware 5.7 or later; older cards can only
+// overwrite them.
+func (yk *YubiKey) DeleteKey(slot PIVSlot) error {
+	if !slot.isKeySlot() || slot == SlotAttestation {
+		return fmt.Errorf("invalid PIV slot %X", int(slot))
+	}
+	object, err := slot.certificateObject()
+	if err != nil {
+		return err
+	}
+
+	return yk.locked(func() error {
+		if err := yk.authenticate(); err != nil {
+			return err
+		}
+
+		hasKey := true
+		_, err := transmit(yk.card, apdu{ins: insMoveKey, p1: 0xFF, p2: byte(slot)})
+		switch {
+		case isStatus(err, swReferenceNotFound):
+			hasKey = false
+		case isStatus(err, swInsNotSupported):
+			return fmt.Errorf("%w: deleting keys needs firmware 5.7 or later", ErrUnsupportedFirmware)
+		case err != nil:
+			return fmt.Errorf("failed to delete key: %w", err)
+		}
+		delete(yk.publicKeys, slot)
+
+		if _, err := yk.certificate(slot); errors.Is(err, ErrCertNotFound) {
+			if !hasKey {
+				return ErrKeyNotFound
+			}
+			return nil
+		}
+		if _, err := transmit(yk.card, apdu{ins: insPutData, p1: 0x3F, p2: 0xFF,
+			data: append(tlv(0x5C, object), tlv(0x53, nil)...)}); err != nil {
+			return fmt.Errorf("failed to delete certificate: %w", err)
+		}
+		return nil
+	})
+}
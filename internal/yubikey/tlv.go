@@ -0,0 +1,118 @@
+package yubikey
+
+import (
+	"errors"
+)
+
+// errMalformedTLV is returned for BER-TLV data that cannot be parsed
+var errMalformedTLV = errors.New("malformed TLV data")
+
+// appendTLV appends a BER-TLV element to dst. Tags are written as given, so
+// two-byte tags such as 0x7F49 are passed whole.
+func appendTLV(dst []byte, tag uint32, value ...[]byte) []byte {
+	n := 0
+	for _, v := range value {
+		n += len(v)
+	}
+
+	switch {
+	case tag > 0xFFFF:
+		dst = append(dst, byte(tag>>16), byte(tag>>8), byte(tag))
+	case tag > 0xFF:
+		dst = append(dst, byte(tag>>8), byte(tag))
+	default:
+		dst = append(dst, byte(tag))
+	}
+
+	switch {
+	case n < 0x80:
+		dst = append(dst, byte(n))
+	case n <= 0xFF:
+		dst = append(dst, 0x81, byte(n))
+	default:
+		dst = append(dst, 0x82, byte(n>>8), byte(n))
+	}
+
+	for _, v := range value {
+		dst = append(dst, v...)
+	}
+	return dst
+}
+
+// tlv builds a single BER-TLV element
+func tlv(tag uint32, value ...[]byte) []byte {
+	return appendTLV(nil, tag, value...)
+}
+
+// parseTLV reads the first BER-TLV element of data
+func parseTLV(data []byte) (tag uint32, value, rest []byte, err error) {
+	if len(data) < 2 {
+		return 0, nil, nil, errMalformedTLV
+	}
+
+	tag = uint32(data[0])
+	i := 1
+	if data[0]&0x1F == 0x1F {
+		// Subsequent tag bytes have the high bit set until the last one
+		for {
+			if i >= len(data) {
+				return 0, nil, nil, errMalformedTLV
+			}
+			tag = tag<<8 | uint32(data[i])
+			i++
+			if data[i-1]&0x80 == 0 {
+				break
+			}
+		}
+	}
+
+	if i >= len(data) {
+		return 0, nil, nil, errMalformedTLV
+	}
+	length := int(data[i])
+	i++
+	if length&0x80 != 0 {
+		octets := length & 0x7F
+		if octets == 0 || octets > 3 || i+octets > len(data) {
+			return 0, nil, nil, errMalformedTLV
+		}
+		length = 0
+		for _, b := range data[i : i+octets] {
+			length = length<<8 | int(b)
+		}
+		i += octets
+	}
+
+	if i+length > len(data) {
+		return 0, nil, nil, errMalformedTLV
+	}
+	return tag, data[i : i+length], data[i+length:], nil
+}
+
+// parseTLVs reads a sequence of BER-TLV elements into a map by tag. Later
+// elements with the same tag replace earlier ones.
+func parseTLVs(data []byte) (map[uint32][]byte, error) {
+	elements := make(map[uint32][]byte)
+	for len(data) > 0 {
+		tag, value, rest, err := parseTLV(data)
+		if err != nil {
+			return nil, err
+		}
+		elements[tag] = value
+		data = rest
+	}
+	return elements, nil
+}
+
+// unwrapTLV returns the value of data when it is a single element with the
+// given tag
+func unwrapTLV(data []byte, want uint32) ([]byte, error) {
+	tag, value, _, err := parseTLV(data)
+	if err != nil {
+		return nil, err
+	}
+	if tag != want {
+		return nil, errMalformedTLV
+	}
+	return value, nil
+}
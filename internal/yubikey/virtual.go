@@ -0,0 +1,745 @@
+package yubikey
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/subtle"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+)
+
+// VirtualReader is the reader name that selects the built-in virtual card
+const VirtualReader = "virtual"
+
+// virtualKey is a private key held by the virtual card
+type virtualKey struct {
+	signer    crypto.Signer
+	algorithm byte
+	pinPolicy byte
+	origin    byte
+}
+
+// VirtualCard is an in-memory PIV applet that behaves like a YubiKey 5.
+// It backs tests and lets PiCA run its hardware code paths without a
+// device; keys live only as long as the card.
+type VirtualCard struct {
+	// Serial and Version are reported by the YubiKey instructions
+	Serial  uint32
+	Version [3]byte
+
+	mutex         sync.Mutex
+	selected      bool
+	pin, puk      []byte
+	pinRetries    int
+	pukRetries    int
+	pinVerified   bool
+	managementKey []byte
+	managementAlg byte
+	authenticated bool
+	witness       []byte
+	keys          map[byte]*virtualKey
+	objects       map[string][]byte
+	// attestation is the device attestation certificate, created with
+	// the attestation key on first use
+	attestation *x509.Certificate
+
+	// chain collects chained command data; pending holds response data
+	// not yet fetched with GET RESPONSE
+	chain   []byte
+	pending []byte
+}
+
+// NewVirtualCard returns a virtual card in its factory state: default
+// PIN, PUK and management key and no keys
+func NewVirtualCard() *VirtualCard {
+	return &VirtualCard{
+		Serial:        10000001,
+		Version:       [3]byte{5, 4, 3},
+		pin:           []byte(DefaultPIN),
+		puk:           []byte(DefaultPUK),
+		pinRetries:    3,
+		pukRetries:    3,
+		managementKey: append([]byte(nil), DefaultManagementKey...),
+		managementAlg: ManagementKey3DES,
+		keys:          make(map[byte]*virtualKey),
+		objects:       make(map[string][]byte),
+	}
+}
+
+// Begin implements Card
+func (v *VirtualCard) Begin() error { return nil }
+
+// End implements Card
+func (v *VirtualCard) End() error { return nil }
+
+// Close implements Card
+func (v *VirtualCard) Close() error { return nil }
+
+// Transmit implements Card
+func (v *VirtualCard) Transmit(command []byte) ([]byte, error) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	if len(command) < 4 {
+		return status(swWrongLength), nil
+	}
+	cmd := apdu{cla: command[0], ins: command[1], p1: command[2], p2: command[3]}
+	if len(command) > 5 {
+		n := int(command[4])
+		if len(command) < 5+n {
+			return status(swWrongLength), nil
+		}
+		cmd.data = command[5 : 5+n]
+	}
+
+	if cmd.ins == insGetResponse {
+		return v.respond(nil), nil
+	}
+	v.pending = nil
+
+	// Collect command chains and process the whole command at the end
+	if cmd.cla&claChaining != 0 {
+		v.chain = append(v.chain, cmd.data...)
+		return status(swSuccess), nil
+	}
+	if v.chain != nil {
+		cmd.data = append(v.chain, cmd.data...)
+		v.chain = nil
+	}
+	cmd.cla &^= claChaining
+
+	data, sw := v.process(cmd)
+	if sw != swSuccess {
+		return status(sw), nil
+	}
+	return v.respond(data), nil
+}
+
+// respond returns up to 256 bytes of response data, keeping the rest for
+// GET RESPONSE
+func (v *VirtualCard) respond(data []byte) []byte {
+	if data == nil {
+		data = v.pending
+	}
+	if len(data) <= 256 {
+		v.pending = nil
+		return append(append([]byte(nil), data...), status(swSuccess)...)
+	}
+	v.pending = data[256:]
+	remaining := len(v.pending)
+	if remaining > 255 {
+		remaining = 0
+	}
+	return append(append([]byte(nil), data[:256]...), status(swBytesRemaining|uint16(remaining))...)
+}
+
+// status encodes a status word
+func status(sw uint16) []byte {
+	return []byte{byte(sw >> 8), byte(sw)}
+}
+
+// process executes a complete command
+func (v *VirtualCard) process(cmd apdu) ([]byte, uint16) {
+	if cmd.ins == insSelect {
+		if cmd.p1 != 0x04 || !bytes.HasPrefix(cmd.data, pivAID) {
+			v.selected = false
+			return nil, swFileNotFound
+		}
+		// Selecting the applet resets its security status
+		v.selected = true
+		v.pinVerified = false
+		v.authenticated = false
+		return tlv(0x61, tlv(0x4F, pivAID[len(pivAID)-4:])), swSuccess
+	}
+	if !v.selected {
+		return nil, swInsNotSupported
+	}
+
+	switch cmd.ins {
+	case insGetVersion:
+		return v.Version[:], swSuccess
+	case insGetSerial:
+		return binary.BigEndian.AppendUint32(nil, v.Serial), swSuccess
+	case insVerify:
+		return v.verify(cmd)
+	case insChangeReference:
+		return v.changeReference(cmd)
+	case insResetRetry:
+		return v.resetRetry(cmd)
+	case insGeneralAuth:
+		if cmd.p2 == slotManagementKey {
+			return v.authenticate(cmd)
+		}
+		return v.sign(cmd)
+	case insGenerateAsymmetric:
+		return v.generate(cmd)
+	case insImportKey:
+		return v.importKey(cmd)
+	case insPutData:
+		return v.putData(cmd)
+	case insGetData:
+		return v.getData(cmd)
+	case insMetadata:
+		return v.metadata(cmd)
+	case insSetManagementKey:
+		return v.setManagementKey(cmd)
+	case insAttest:
+		return v.attest(cmd)
+	case insMoveKey:
+		return v.deleteKey(cmd)
+	}
+	return nil, swInsNotSupported
+}
+
+// verify checks the PIN; without data it reports the verification state
+func (v *VirtualCard) verify(cmd apdu) ([]byte, uint16) {
+	if cmd.p2 != slotPIN {
+		return nil, swReferenceNotFound
+	}
+	if len(cmd.data) == 0 {
+		if v.pinVerified {
+			return nil, swSuccess
+		}
+		return nil, swVerifyFailed | uint16(v.pinRetries)
+	}
+	if v.pinRetries == 0 {
+		return nil, swAuthMethodBlocked
+	}
+	if !checkPIN(v.pin, cmd.data) {
+		v.pinRetries--
+		v.pinVerified = false
+		return nil, swVerifyFailed | uint16(v.pinRetries)
+	}
+	v.pinRetries = 3
+	v.pinVerified = true
+	return nil, swSuccess
+}
+
+// checkPIN compares a padded PIN with the stored one
+func checkPIN(stored, padded []byte) bool {
+	if len(padded) != 8 {
+		return false
+	}
+	want := bytes.Repeat([]byte{0xFF}, 8)
+	copy(want, stored)
+	return subtle.ConstantTimeCompare(want, padded) == 1
+}
+
+// changeReference changes the PIN or PUK given the current one
+func (v *VirtualCard) changeReference(cmd apdu) ([]byte, uint16) {
+	if len(cmd.data) != 16 {
+		return nil, swIncorrectData
+	}
+	value, retries := &v.pin, &v.pinRetries
+	switch cmd.p2 {
+	case slotPIN:
+	case slotPUK:
+		value, retries = &v.puk, &v.pukRetries
+	default:
+		return nil, swReferenceNotFound
+	}
+	if *retries == 0 {
+		return nil, swAuthMethodBlocked
+	}
+	if !checkPIN(*value, cmd.data[:8]) {
+		*retries--
+		return nil, swVerifyFailed | uint16(*retries)
+	}
+	*retries = 3
+	*value = bytes.TrimRight(append([]byte(nil), cmd.data[8:]...), "\xff")
+	return nil, swSuccess
+}
+
+// resetRetry unblocks the PIN with the PUK
+func (v *VirtualCard) resetRetry(cmd apdu) ([]byte, uint16) {
+	if cmd.p2 != slotPIN || len(cmd.data) != 16 {
+		return nil, swIncorrectData
+	}
+	if v.pukRetries == 0 {
+		return nil, swAuthMethodBlocked
+	}
+	if !checkPIN(v.puk, cmd.data[:8]) {
+		v.pukRetries--
+		return nil, swVerifyFailed | uint16(v.pukRetries)
+	}
+	v.pukRetries = 3
+	v.pin = bytes.TrimRight(append([]byte(nil), cmd.data[8:]...), "\xff")
+	v.pinRetries = 3
+	return nil, swSuccess
+}
+
+// authenticate runs the management key challenge-response
+func (v *VirtualCard) authenticate(cmd apdu) ([]byte, uint16) {
+	if cmd.p1 != v.managementAlg {
+		return nil, swIncorrectData
+	}
+	block, err := managementCipher(v.managementAlg, v.managementKey)
+	if err != nil {
+		return nil, swIncorrectData
+	}
+	size := block.BlockSize()
+
+	inner, err := unwrapTLV(cmd.data, 0x7C)
+	if err != nil {
+		return nil, swIncorrectData
+	}
+	elements, err := parseTLVs(inner)
+	if err != nil {
+		return nil, swIncorrectData
+	}
+
+	// Step one: send an encrypted witness
+	if w, ok := elements[0x80]; ok && len(w) == 0 {
+		v.witness = make([]byte, size)
+		rand.Read(v.witness)
+		encrypted := make([]byte, size)
+		block.Encrypt(encrypted, v.witness)
+		return tlv(0x7C, tlv(0x80, encrypted)), swSuccess
+	}
+
+	// Step two: check the decrypted witness and answer the challenge
+	witness, challenge := elements[0x80], elements[0x81]
+	expected := v.witness
+	v.witness = nil
+	if expected == nil || len(challenge) != size || subtle.ConstantTimeCompare(witness, expected) != 1 {
+		v.authenticated = false
+		return nil, swSecurityNotSatisfied
+	}
+	v.authenticated = true
+	response := make([]byte, size)
+	block.Encrypt(response, challenge)
+	return tlv(0x7C, tlv(0x82, response)), swSuccess
+}
+
+// defaultPINPolicy is the PIN policy of a slot whose key did not set one
+func defaultPINPolicy(slot byte) byte {
+	switch PIVSlot(slot) {
+	case SlotSignature:
+		return PINPolicyAlways
+	case SlotCardAuth:
+		return PINPolicyNever
+	}
+	return PINPolicyOnce
+}
+
+// sign signs with a slot's key
+func (v *VirtualCard) sign(cmd apdu) ([]byte, uint16) {
+	key, ok := v.keys[cmd.p2]
+	if !ok {
+		return nil, swReferenceNotFound
+	}
+	if cmd.p1 != key.algorithm {
+		return nil, swIncorrectData
+	}
+	if key.pinPolicy != PINPolicyNever && !v.pinVerified {
+		return nil, swSecurityNotSatisfied
+	}
+
+	inner, err := unwrapTLV(cmd.data, 0x7C)
+	if err != nil {
+		return nil, swIncorrectData
+	}
+	elements, err := parseTLVs(inner)
+	if err != nil {
+		return nil, swIncorrectData
+	}
+	input, ok := elements[0x81]
+	if !ok {
+		return nil, swIncorrectData
+	}
+
+	var sig []byte
+	switch k := key.signer.(type) {
+	case *ecdsa.PrivateKey:
+		if len(input) != (k.Curve.Params().BitSize+7)/8 {
+			return nil, swIncorrectData
+		}
+		if sig, err = ecdsa.SignASN1(rand.Reader, k, input); err != nil {
+			return nil, swIncorrectData
+		}
+	case *rsa.PrivateKey:
+		if len(input) != k.Size() {
+			return nil, swIncorrectData
+		}
+		// Raw RSA: the host applies the padding
+		m := new(big.Int).SetBytes(input)
+		if m.Cmp(k.N) >= 0 {
+			return nil, swIncorrectData
+		}
+		sig = new(big.Int).Exp(m, k.D, k.N).FillBytes(make([]byte, k.Size()))
+	}
+
+	if key.pinPolicy == PINPolicyAlways {
+		v.pinVerified = false
+	}
+	return tlv(0x7C, tlv(0x82, sig)), swSuccess
+}
+
+// generate creates a key in a slot
+func (v *VirtualCard) generate(cmd apdu) ([]byte, uint16) {
+	if !v.authenticated {
+		return nil, swSecurityNotSatisfied
+	}
+	if !PIVSlot(cmd.p2).isKeySlot() || PIVSlot(cmd.p2) == SlotAttestation {
+		return nil, swWrongP1P2
+	}
+	inner, err := unwrapTLV(cmd.data, 0xAC)
+	if err != nil {
+		return nil, swIncorrectData
+	}
+	elements, err := parseTLVs(inner)
+	if err != nil || len(elements[0x80]) != 1 {
+		return nil, swIncorrectData
+	}
+	alg := elements[0x80][0]
+	pinPolicy := defaultPINPolicy(cmd.p2)
+	if p := elements[0xAA]; len(p) == 1 && p[0] != PINPolicyDefault {
+		pinPolicy = p[0]
+	}
+
+	var signer crypto.Signer
+	switch alg {
+	case AlgorithmECCP256:
+		signer, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case AlgorithmECCP384:
+		signer, err = ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	case AlgorithmRSA1024, AlgorithmRSA2048, AlgorithmRSA3072, AlgorithmRSA4096:
+		bits := map[byte]int{AlgorithmRSA1024: 1024, AlgorithmRSA2048: 2048, AlgorithmRSA3072: 3072, AlgorithmRSA4096: 4096}[alg]
+		signer, err = rsa.GenerateKey(rand.Reader, bits)
+	default:
+		return nil, swIncorrectData
+	}
+	if err != nil {
+		return nil, swConditionsNotSatisfied
+	}
+
+	v.keys[cmd.p2] = &virtualKey{signer: signer, algorithm: alg, pinPolicy: pinPolicy, origin: originGenerated}
+	return tlv(0x7F49, encodePublicKey(signer.Public())), swSuccess
+}
+
+// encodePublicKey encodes a public key the way GENERATE ASYMMETRIC returns it
+func encodePublicKey(pub crypto.PublicKey) []byte {
+	switch k := pub.(type) {
+	case *ecdsa.PublicKey:
+		size := (k.Curve.Params().BitSize + 7) / 8
+		point := append([]byte{0x04}, k.X.FillBytes(make([]byte, size))...)
+		point = append(point, k.Y.FillBytes(make([]byte, size))...)
+		return tlv(0x86, point)
+	case *rsa.PublicKey:
+		return append(tlv(0x81, k.N.Bytes()), tlv(0x82, big.NewInt(int64(k.E)).Bytes())...)
+	}
+	return nil
+}
+
+// importKey stores an existing private key in a slot
+func (v *VirtualCard) importKey(cmd apdu) ([]byte, uint16) {
+	if !v.authenticated {
+		return nil, swSecurityNotSatisfied
+	}
+	if !PIVSlot(cmd.p2).isKeySlot() {
+		return nil, swWrongP1P2
+	}
+	elements, err := parseTLVs(cmd.data)
+	if err != nil {
+		return nil, swIncorrectData
+	}
+
+	var signer crypto.Signer
+	switch cmd.p1 {
+	case AlgorithmECCP256, AlgorithmECCP384:
+		curve := elliptic.P256()
+		if cmd.p1 == AlgorithmECCP384 {
+			curve = elliptic.P384()
+		}
+		signer, err = ecdsaKeyFromScalar(curve, elements[0x06])
+	case AlgorithmRSA1024, AlgorithmRSA2048, AlgorithmRSA3072, AlgorithmRSA4096:
+		signer, err = rsaKeyFromPrimes(elements[0x01], elements[0x02])
+	default:
+		return nil, swIncorrectData
+	}
+	if err != nil {
+		return nil, swIncorrectData
+	}
+
+	pinPolicy := defaultPINPolicy(cmd.p2)
+	if p := elements[0xAA]; len(p) == 1 && p[0] != PINPolicyDefault {
+		pinPolicy = p[0]
+	}
+	v.keys[cmd.p2] = &virtualKey{signer: signer, algorithm: cmd.p1, pinPolicy: pinPolicy, origin: originImported}
+	return nil, swSuccess
+}
+
+// ecdsaKeyFromScalar rebuilds an ECDSA key from its private scalar
+func ecdsaKeyFromScalar(curve elliptic.Curve, scalar []byte) (*ecdsa.PrivateKey, error) {
+	if len(scalar) != (curve.Params().BitSize+7)/8 {
+		return nil, errors.New("wrong scalar length")
+	}
+	d := new(big.Int).SetBytes(scalar)
+	if d.Sign() == 0 || d.Cmp(curve.Params().N) >= 0 {
+		return nil, errors.New("invalid scalar")
+	}
+	key := &ecdsa.PrivateKey{D: d}
+	key.Curve = curve
+	key.X, key.Y = curve.ScalarBaseMult(scalar)
+	return key, nil
+}
+
+// rsaKeyFromPrimes rebuilds an RSA key with exponent 65537 from its primes
+func rsaKeyFromPrimes(pb, qb []byte) (*rsa.PrivateKey, error) {
+	p, q := new(big.Int).SetBytes(pb), new(big.Int).SetBytes(qb)
+	if p.Sign() == 0 || q.Sign() == 0 {
+		return nil, errors.New("missing primes")
+	}
+	one := big.NewInt(1)
+	phi := new(big.Int).Mul(new(big.Int).Sub(p, one), new(big.Int).Sub(q, one))
+	e := big.NewInt(65537)
+	d := new(big.Int).ModInverse(e, phi)
+	if d == nil {
+		return nil, errors.New("invalid primes")
+	}
+	key := &rsa.PrivateKey{
+		PublicKey: rsa.PublicKey{N: new(big.Int).Mul(p, q), E: 65537},
+		D:         d,
+		Primes:    []*big.Int{p, q},
+	}
+	if err := key.Validate(); err != nil {
+		return nil, err
+	}
+	key.Precompute()
+	return key, nil
+}
+
+// putData stores a data object
+func (v *VirtualCard) putData(cmd apdu) ([]byte, uint16) {
+	if !v.authenticated {
+		return nil, swSecurityNotSatisfied
+	}
+	if cmd.p1 != 0x3F || cmd.p2 != 0xFF {
+		return nil, swWrongP1P2
+	}
+	_, id, rest, err := parseTLV(cmd.data)
+	if err != nil {
+		return nil, swIncorrectData
+	}
+	value, err := unwrapTLV(rest, 0x53)
+	if err != nil {
+		return nil, swIncorrectData
+	}
+	if len(value) == 0 {
+		delete(v.objects, string(id))
+		return nil, swSuccess
+	}
+	v.objects[string(id)] = append([]byte(nil), rest...)
+	return nil, swSuccess
+}
+
+// getData returns a data object
+func (v *VirtualCard) getData(cmd apdu) ([]byte, uint16) {
+	if cmd.p1 != 0x3F || cmd.p2 != 0xFF {
+		return nil, swWrongP1P2
+	}
+	id, err := unwrapTLV(cmd.data, 0x5C)
+	if err != nil {
+		return nil, swIncorrectData
+	}
+	if object, _ := SlotAttestation.certificateObject(); bytes.Equal(id, object) {
+		if _, _, err := v.attestationKey(); err != nil {
+			return nil, swConditionsNotSatisfied
+		}
+	}
+	object, ok := v.objects[string(id)]
+	if !ok {
+		return nil, swFileNotFound
+	}
+	return object, swSuccess
+}
+
+// metadata describes a key slot, the management key, the PIN or the PUK
+func (v *VirtualCard) metadata(cmd apdu) ([]byte, uint16) {
+	switch cmd.p2 {
+	case slotManagementKey:
+		return append(tlv(0x01, []byte{v.managementAlg}), tlv(0x02, []byte{PINPolicyNever, 0x01})...), swSuccess
+	case slotPIN, slotPUK:
+		retries := v.pinRetries
+		if cmd.p2 == slotPUK {
+			retries = v.pukRetries
+		}
+		return append(tlv(0x01, []byte{0xFF}), tlv(0x06, []byte{3, byte(retries)})...), swSuccess
+	}
+
+	key, ok := v.keys[cmd.p2]
+	if !ok {
+		return nil, swReferenceNotFound
+	}
+	md := tlv(0x01, []byte{key.algorithm})
+	md = appendTLV(md, 0x02, []byte{key.pinPolicy, 0x01})
+	md = appendTLV(md, 0x03, []byte{key.origin})
+	return appendTLV(md, 0x04, encodePublicKey(key.signer.Public())), swSuccess
+}
+
+// deleteKey removes a key the way MOVE KEY does with the "delete" target.
+// Like a YubiKey it needs firmware 5.7; moving keys is not implemented.
+func (v *VirtualCard) deleteKey(cmd apdu) ([]byte, uint16) {
+	if bytes.Compare(v.Version[:], []byte{5, 7, 0}) < 0 {
+		return nil, swInsNotSupported
+	}
+	if !v.authenticated {
+		return nil, swSecurityNotSatisfied
+	}
+	if cmd.p1 != 0xFF || !PIVSlot(cmd.p2).isKeySlot() || PIVSlot(cmd.p2) == SlotAttestation {
+		return nil, swWrongP1P2
+	}
+	if _, ok := v.keys[cmd.p2]; !ok {
+		return nil, swReferenceNotFound
+	}
+	delete(v.keys, cmd.p2)
+	return nil, swSuccess
+}
+
+// setManagementKey replaces the management key
+func (v *VirtualCard) setManagementKey(cmd apdu) ([]byte, uint16) {
+	if !v.authenticated {
+		return nil, swSecurityNotSatisfied
+	}
+	if cmd.p1 != 0xFF || (cmd.p2 != 0xFF && cmd.p2 != 0xFE) {
+		return nil, swWrongP1P2
+	}
+	if len(cmd.data) < 3 || cmd.data[1] != slotManagementKey || int(cmd.data[2]) != len(cmd.data)-3 {
+		return nil, swIncorrectData
+	}
+	alg, key := cmd.data[0], cmd.data[3:]
+	if _, err := managementCipher(alg, key); err != nil {
+		return nil, swIncorrectData
+	}
+	v.managementAlg = alg
+	v.managementKey = append([]byte(nil), key...)
+	return nil, swSuccess
+}
+
+// virtualRoot is the attestation root of all virtual cards
+var virtualRoot struct {
+	once sync.Once
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	err  error
+}
+
+// VirtualAttestationRoot returns the root the attestation certificates of
+// virtual cards chain to. It is created once per process; trust it only in
+// tests.
+func VirtualAttestationRoot() (*x509.Certificate, error) {
+	virtualRoot.once.Do(func() {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			virtualRoot.err = err
+			return
+		}
+		template := &x509.Certificate{
+			SerialNumber:          big.NewInt(1),
+			Subject:               pkix.Name{CommonName: "PiCA Virtual PIV Root CA"},
+			NotBefore:             time.Now().Add(-time.Hour),
+			NotAfter:              time.Now().AddDate(10, 0, 0),
+			KeyUsage:              x509.KeyUsageCertSign,
+			BasicConstraintsValid: true,
+			IsCA:                  true,
+		}
+		der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+		if err != nil {
+			virtualRoot.err = err
+			return
+		}
+		virtualRoot.cert, virtualRoot.err = x509.ParseCertificate(der)
+		virtualRoot.key = key
+	})
+	return virtualRoot.cert, virtualRoot.err
+}
+
+// attestationKey returns the device attestation certificate and key,
+// creating them in slot F9 on first use
+func (v *VirtualCard) attestationKey() (*x509.Certificate, crypto.Signer, error) {
+	if v.attestation != nil {
+		if key, ok := v.keys[byte(SlotAttestation)]; ok {
+			return v.attestation, key.signer, nil
+		}
+	}
+
+	root, err := VirtualAttestationRoot()
+	if err != nil {
+		return nil, nil, err
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber: new(big.Int).SetUint64(uint64(v.Serial)),
+		Subject:      pkix.Name{CommonName: "Yubico PIV Attestation"},
+		NotBefore:    root.NotBefore,
+		NotAfter:     root.NotAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, root, &key.PublicKey, virtualRoot.key)
+	if err != nil {
+		return nil, nil, err
+	}
+	if v.attestation, err = x509.ParseCertificate(der); err != nil {
+		return nil, nil, err
+	}
+
+	object, _ := SlotAttestation.certificateObject()
+	v.objects[string(object)] = tlv(0x53, append(tlv(0x70, der), tlv(0x71, []byte{0x00})...))
+	v.keys[byte(SlotAttestation)] = &virtualKey{signer: key, algorithm: AlgorithmECCP256,
+		pinPolicy: PINPolicyNever, origin: originGenerated}
+	return v.attestation, key, nil
+}
+
+// attest issues the attestation certificate of a generated key
+func (v *VirtualCard) attest(cmd apdu) ([]byte, uint16) {
+	key, ok := v.keys[cmd.p1]
+	if !ok || PIVSlot(cmd.p1) == SlotAttestation {
+		return nil, swReferenceNotFound
+	}
+	if key.origin != originGenerated {
+		return nil, swIncorrectData
+	}
+	device, signer, err := v.attestationKey()
+	if err != nil {
+		return nil, swConditionsNotSatisfied
+	}
+
+	serial, err := asn1.Marshal(int64(v.Serial))
+	if err != nil {
+		return nil, swConditionsNotSatisfied
+	}
+	certSerial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 63))
+	if err != nil {
+		return nil, swConditionsNotSatisfied
+	}
+	template := &x509.Certificate{
+		SerialNumber: certSerial,
+		Subject:      pkix.Name{CommonName: fmt.Sprintf("YubiKey PIV Attestation %02x", cmd.p1)},
+		NotBefore:    device.NotBefore,
+		NotAfter:     device.NotAfter,
+		ExtraExtensions: []pkix.Extension{
+			{Id: oidFirmware, Value: v.Version[:]},
+			{Id: oidSerial, Value: serial},
+			{Id: oidPolicy, Value: []byte{key.pinPolicy, TouchPolicyNever}},
+			{Id: oidFormFactor, Value: []byte{0x03}},
+		},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, device, key.signer.Public(), signer)
+	if err != nil {
+		return nil, swConditionsNotSatisfied
+	}
+	return der, swSuccess
+}
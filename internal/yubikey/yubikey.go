@@ -1,9 +1,24 @@
 package yubikey
 
 import (
+	"bytes"
 	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/subtle"
 	"crypto/x509"
+	"encoding/binary"
 	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"sync"
 )
 
 // PIVSlot represents a slot in a YubiKey PIV applet
@@ -17,71 +32,890 @@ const (
 	// 0x82-0x95 are retirement slots that can be used for CA keys
 	SlotCA1 PIVSlot = 0x82
 	SlotCA2 PIVSlot = 0x83
+	// SlotAttestation holds the key that attests keys generated on the card
+	SlotAttestation PIVSlot = 0xF9
+
+	slotRetiredFirst PIVSlot = 0x82
+	slotRetiredLast  PIVSlot = 0x95
+
+	// Key references that are not key slots
+	slotPIN           = 0x80
+	slotPUK           = 0x81
+	slotManagementKey = 0x9B
+)
+
+// isKeySlot reports whether a slot can hold a private key
+func (s PIVSlot) isKeySlot() bool {
+	switch s {
+	case SlotAuthentication, SlotSignature, SlotCardAuth, SlotKeyManagement, SlotAttestation:
+		return true
+	}
+	return s >= slotRetiredFirst && s <= slotRetiredLast
+}
+
+// certificateObject returns the data object holding a slot's certificate
+func (s PIVSlot) certificateObject() ([]byte, error) {
+	switch {
+	case s == SlotAuthentication:
+		return []byte{0x5F, 0xC1, 0x05}, nil
+	case s == SlotSignature:
+		return []byte{0x5F, 0xC1, 0x0A}, nil
+	case s == SlotKeyManagement:
+		return []byte{0x5F, 0xC1, 0x0B}, nil
+	case s == SlotCardAuth:
+		return []byte{0x5F, 0xC1, 0x01}, nil
+	case s == SlotAttestation:
+		return []byte{0x5F, 0xFF, 0x01}, nil
+	case s >= slotRetiredFirst && s <= slotRetiredLast:
+		return []byte{0x5F, 0xC1, byte(0x0D + s - slotRetiredFirst)}, nil
+	}
+	return nil, fmt.Errorf("invalid PIV slot %X", int(s))
+}
+
+// Key algorithms of the PIV applet
+const (
+	AlgorithmRSA1024 byte = 0x06
+	AlgorithmRSA2048 byte = 0x07
+	AlgorithmRSA3072 byte = 0x05
+	AlgorithmRSA4096 byte = 0x16
+	AlgorithmECCP256 byte = 0x11
+	AlgorithmECCP384 byte = 0x14
+)
+
+// Management key algorithms
+const (
+	ManagementKey3DES   byte = 0x03
+	ManagementKeyAES128 byte = 0x08
+	ManagementKeyAES192 byte = 0x0A
+	ManagementKeyAES256 byte = 0x0C
+)
+
+// PIN policies of a key slot
+const (
+	PINPolicyDefault byte = 0x00
+	PINPolicyNever   byte = 0x01
+	PINPolicyOnce    byte = 0x02
+	PINPolicyAlways  byte = 0x03
+)
+
+// Touch policies of a key slot
+const (
+	TouchPolicyDefault byte = 0x00
+	TouchPolicyNever   byte = 0x01
+	TouchPolicyAlways  byte = 0x02
+	TouchPolicyCached  byte = 0x03
+)
+
+// Key origins reported in key metadata
+const (
+	originGenerated byte = 0x01
+	originImported  byte = 0x02
+)
+
+// Factory defaults of the PIV applet
+const (
+	DefaultPIN = "123456"
+	DefaultPUK = "12345678"
+)
+
+// DefaultManagementKey is the management key a new YubiKey ships with
+var DefaultManagementKey = []byte{
+	0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08,
+	0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08,
+	0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08,
+}
+
+var (
+	// ErrNotConnected is returned for operations on a closed YubiKey
+	ErrNotConnected = errors.New("YubiKey not connected")
+
+	// ErrKeyNotFound is returned when a slot holds no key
+	ErrKeyNotFound = errors.New("no key in slot")
+
+	// ErrCertNotFound is returned when a slot holds no certificate
+	ErrCertNotFound = errors.New("no certificate in slot")
+
+	// ErrPINRequired is returned when a key needs the PIN and none is set
+	ErrPINRequired = errors.New("PIN required")
+
+	// ErrPINBlocked is returned when the PIN has no retries left
+	ErrPINBlocked = errors.New("PIN blocked")
+
+	// ErrPUKBlocked is returned when the PUK has no retries left
+	ErrPUKBlocked = errors.New("PUK blocked")
+
+	// ErrWrongManagementKey is returned when management key authentication fails
+	ErrWrongManagementKey = errors.New("wrong management key")
+
+	// ErrUnsupportedAlgorithm is returned for key types the PIV applet lacks
+	ErrUnsupportedAlgorithm = errors.New("unsupported key algorithm")
+
+	// ErrUnsupportedFirmware is returned for instructions the card's
+	// firmware is too old for
+	ErrUnsupportedFirmware = errors.New("not supported by this YubiKey firmware")
 )
 
+// PINError is returned when the card rejects a PIN or PUK
+type PINError struct {
+	Retries int
+	PUK     bool
+}
+
+func (e *PINError) Error() string {
+	if e.PUK {
+		return fmt.Sprintf("wrong PUK, %d retries left", e.Retries)
+	}
+	return fmt.Sprintf("wrong PIN, %d retries left", e.Retries)
+}
+
 // YubiKey represents a YubiKey device
 type YubiKey struct {
 	SerialNumber uint32
+	Version      string
 	Connected    bool
+
+	// PIN unlocks private key operations. Without it signing fails for
+	// slots whose PIN policy requires one.
+	PIN string
+	// ManagementKey authorizes key generation and data object updates;
+	// DefaultManagementKey when nil
+	ManagementKey []byte
+	// ManagementKeyAlgorithm is read from the card when zero
+	ManagementKeyAlgorithm byte
+
+	card       Card
+	mutex      sync.Mutex
+	publicKeys map[PIVSlot]crypto.PublicKey
 }
 
-// Connect establishes a connection to a YubiKey
+// Connect establishes a connection to the first YubiKey found over PC/SC
 func Connect() (*YubiKey, error) {
-	// This would use the appropriate YubiKey library
-	// For now, just return a placeholder
-	return &YubiKey{
-		SerialNumber: 0,
-		Connected:    false,
-	}, errors.New("YubiKey integration not yet implemented")
+	readers, err := Readers()
+	if err != nil {
+		return nil, err
+	}
+	for _, reader := range readers {
+		if strings.Contains(strings.ToLower(reader), "yubico") {
+			return ConnectReader(reader)
+		}
+	}
+	return nil, errors.New("no YubiKey found")
+}
+
+// ConnectReader establishes a connection to the card in a PC/SC reader
+func ConnectReader(reader string) (*YubiKey, error) {
+	card, err := connectPCSC(reader)
+	if err != nil {
+		return nil, err
+	}
+	yk, err := Open(card)
+	if err != nil {
+		card.Close()
+		return nil, err
+	}
+	return yk, nil
+}
+
+// Open selects the PIV applet on a card and reads its serial number and
+// firmware version
+func Open(card Card) (*YubiKey, error) {
+	yk := &YubiKey{
+		card:       card,
+		Connected:  true,
+		publicKeys: make(map[PIVSlot]crypto.PublicKey),
+	}
+
+	err := yk.tx(func() error {
+		version, err := transmit(card, apdu{ins: insGetVersion})
+		if err != nil {
+			return fmt.Errorf("failed to read firmware version: %w", err)
+		}
+		if len(version) == 3 {
+			yk.Version = fmt.Sprintf("%d.%d.%d", version[0], version[1], version[2])
+		}
+		// Cards before YubiKey 5 have no serial number instruction
+		if serial, err := transmit(card, apdu{ins: insGetSerial}); err == nil && len(serial) == 4 {
+			yk.SerialNumber = binary.BigEndian.Uint32(serial)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return yk, nil
 }
 
 // Close closes the connection to the YubiKey
 func (yk *YubiKey) Close() error {
+	yk.mutex.Lock()
+	defer yk.mutex.Unlock()
+
+	if !yk.Connected {
+		return nil
+	}
 	yk.Connected = false
-	return nil
+	return yk.card.Close()
 }
 
-// GenerateKey generates a new key pair in the specified slot
-func (yk *YubiKey) GenerateKey(slot PIVSlot) error {
+// tx runs fn with exclusive access to the card and the PIV applet selected
+func (yk *YubiKey) tx(fn func() error) error {
+	if yk.card == nil {
+		return ErrNotConnected
+	}
+	if err := yk.card.Begin(); err != nil {
+		return fmt.Errorf("failed to begin card transaction: %w", err)
+	}
+	defer yk.card.End()
+
+	if _, err := transmit(yk.card, apdu{ins: insSelect, p1: 0x04, data: pivAID}); err != nil {
+		return fmt.Errorf("failed to select PIV applet: %w", err)
+	}
+	return fn()
+}
+
+// locked runs fn in a card transaction while holding the YubiKey's lock
+func (yk *YubiKey) locked(fn func() error) error {
+	yk.mutex.Lock()
+	defer yk.mutex.Unlock()
+
 	if !yk.Connected {
-		return errors.New("YubiKey not connected")
+		return ErrNotConnected
+	}
+	return yk.tx(fn)
+}
+
+// VerifyPIN checks a PIN against the card
+func (yk *YubiKey) VerifyPIN(pin string) error {
+	return yk.locked(func() error { return yk.verifyPIN(pin) })
+}
+
+// verifyPIN presents a PIN within a transaction
+func (yk *YubiKey) verifyPIN(pin string) error {
+	data, err := encodePIN(pin)
+	if err != nil {
+		return err
+	}
+	_, err = transmit(yk.card, apdu{ins: insVerify, p2: slotPIN, data: data})
+	return pinError(err, false)
+}
+
+// PINRetries returns the attempts left for the PIN and the PUK. The PUK
+// count is -1 on firmware without metadata support.
+func (yk *YubiKey) PINRetries() (pin, puk int, err error) {
+	err = yk.locked(func() error {
+		pin, puk = -1, -1
+		if md, err := yk.metadata(slotPIN); err == nil {
+			pin = md.retries
+			if md, err := yk.metadata(slotPUK); err == nil {
+				puk = md.retries
+			}
+			return nil
+		} else if !isStatus(err, swInsNotSupported) {
+			return err
+		}
+
+		// VERIFY without data reports the PIN retries without using one
+		_, err := transmit(yk.card, apdu{ins: insVerify, p2: slotPIN})
+		var se *StatusError
+		switch {
+		case err == nil:
+			pin = 3
+		case errors.As(err, &se) && se.SW&0xFFF0 == swVerifyFailed:
+			pin = int(se.SW & 0x0F)
+		case isStatus(err, swAuthMethodBlocked):
+			pin = 0
+		default:
+			return err
+		}
+		return nil
+	})
+	return pin, puk, err
+}
+
+// ChangePIN replaces the PIN
+func (yk *YubiKey) ChangePIN(oldPIN, newPIN string) error {
+	return yk.changeReference(insChangeReference, slotPIN, oldPIN, newPIN)
+}
+
+// ChangePUK replaces the PUK
+func (yk *YubiKey) ChangePUK(oldPUK, newPUK string) error {
+	return yk.changeReference(insChangeReference, slotPUK, oldPUK, newPUK)
+}
+
+// UnblockPIN sets a new PIN using the PUK, resetting the PIN retries
+func (yk *YubiKey) UnblockPIN(puk, newPIN string) error {
+	return yk.changeReference(insResetRetry, slotPIN, puk, newPIN)
+}
+
+// changeReference sends the current and the new PIN or PUK with one of
+// the instructions that replace them
+func (yk *YubiKey) changeReference(ins, reference byte, current, value string) error {
+	cur, err := encodePIN(current)
+	if err != nil {
+		return err
+	}
+	val, err := encodePIN(value)
+	if err != nil {
+		return err
+	}
+	// RESET RETRY and changing the PUK present the PUK
+	puk := ins == insResetRetry || reference == slotPUK
+	return yk.locked(func() error {
+		_, err := transmit(yk.card, apdu{ins: ins, p2: reference, data: append(cur, val...)})
+		return pinError(err, puk)
+	})
+}
+
+// SetManagementKey replaces the management key. The current key, from
+// ManagementKey, authorizes the change. An algorithm of zero keeps the
+// current one.
+func (yk *YubiKey) SetManagementKey(alg byte, key []byte) error {
+	return yk.locked(func() error {
+		if err := yk.authenticate(); err != nil {
+			return err
+		}
+		if alg == 0 {
+			alg = ManagementKey3DES
+			if md, err := yk.metadata(slotManagementKey); err == nil && md.algorithm != 0 {
+				alg = md.algorithm
+			}
+		}
+		if want := managementKeySizes[alg]; want == 0 {
+			return fmt.Errorf("%w: management key algorithm %02X", ErrUnsupportedAlgorithm, alg)
+		} else if len(key) != want {
+			return fmt.Errorf("new management key must be %d bytes", want)
+		}
+		data := append([]byte{alg, slotManagementKey, byte(len(key))}, key...)
+		if _, err := transmit(yk.card, apdu{ins: insSetManagementKey, p1: 0xFF, p2: 0xFF, data: data}); err != nil {
+			return fmt.Errorf("failed to set management key: %w", err)
+		}
+		yk.ManagementKey = append([]byte(nil), key...)
+		yk.ManagementKeyAlgorithm = alg
+		return nil
+	})
+}
+
+// encodePIN pads a PIN or PUK to the 8 bytes the applet expects
+func encodePIN(pin string) ([]byte, error) {
+	if len(pin) < 6 || len(pin) > 8 {
+		return nil, errors.New("PIN must be 6 to 8 characters")
+	}
+	data := bytes.Repeat([]byte{0xFF}, 8)
+	copy(data, pin)
+	return data, nil
+}
+
+// pinError maps the status words of PIN or PUK verification to errors
+func pinError(err error, puk bool) error {
+	var se *StatusError
+	if !errors.As(err, &se) {
+		return err
+	}
+	blocked := ErrPINBlocked
+	if puk {
+		blocked = ErrPUKBlocked
+	}
+	switch {
+	case se.SW&0xFFF0 == swVerifyFailed:
+		if se.SW&0x0F == 0 {
+			return blocked
+		}
+		return &PINError{Retries: int(se.SW & 0x0F), PUK: puk}
+	case se.SW == swAuthMethodBlocked:
+		return blocked
+	}
+	return err
+}
+
+// authenticate proves knowledge of the management key within a transaction
+func (yk *YubiKey) authenticate() error {
+	key := yk.ManagementKey
+	if key == nil {
+		key = DefaultManagementKey
+	}
+	alg := yk.ManagementKeyAlgorithm
+	if alg == 0 {
+		alg = ManagementKey3DES
+		// YubiKey 5.4 and later report it; 5.7 ships with an AES key
+		if md, err := yk.metadata(slotManagementKey); err == nil && md.algorithm != 0 {
+			alg = md.algorithm
+		}
+	}
+	block, err := managementCipher(alg, key)
+	if err != nil {
+		return err
+	}
+	size := block.BlockSize()
+
+	// The card sends an encrypted witness, which we decrypt and return
+	// together with a challenge for the card to encrypt
+	resp, err := transmit(yk.card, apdu{ins: insGeneralAuth, p1: alg, p2: slotManagementKey, data: tlv(0x7C, tlv(0x80, nil))})
+	if err != nil {
+		return fmt.Errorf("failed to start management key authentication: %w", err)
+	}
+	witness, err := dynamicAuthValue(resp, 0x80, size)
+	if err != nil {
+		return err
+	}
+	plain := make([]byte, size)
+	block.Decrypt(plain, witness)
+
+	challenge := make([]byte, size)
+	if _, err := rand.Read(challenge); err != nil {
+		return err
+	}
+	resp, err = transmit(yk.card, apdu{ins: insGeneralAuth, p1: alg, p2: slotManagementKey,
+		data: tlv(0x7C, tlv(0x80, plain), tlv(0x81, challenge))})
+	if err != nil {
+		if isStatus(err, swSecurityNotSatisfied) {
+			return ErrWrongManagementKey
+		}
+		return fmt.Errorf("management key authentication failed: %w", err)
+	}
+	response, err := dynamicAuthValue(resp, 0x82, size)
+	if err != nil {
+		return err
+	}
+	expected := make([]byte, size)
+	block.Encrypt(expected, challenge)
+	if subtle.ConstantTimeCompare(response, expected) != 1 {
+		return errors.New("card failed management key authentication")
+	}
+	return nil
+}
+
+// managementKeySizes are the key lengths of the management key algorithms
+var managementKeySizes = map[byte]int{ManagementKey3DES: 24, ManagementKeyAES128: 16, ManagementKeyAES192: 24, ManagementKeyAES256: 32}
+
+// managementCipher returns the block cipher of a management key
+func managementCipher(alg byte, key []byte) (cipher.Block, error) {
+	want := managementKeySizes[alg]
+	if want == 0 {
+		return nil, fmt.Errorf("%w: management key algorithm %02X", ErrUnsupportedAlgorithm, alg)
+	}
+	if len(key) != want {
+		return nil, fmt.Errorf("%w: key must be %d bytes", ErrWrongManagementKey, want)
+	}
+	if alg == ManagementKey3DES {
+		return des.NewTripleDESCipher(key)
+	}
+	return aes.NewCipher(key)
+}
+
+// dynamicAuthValue extracts an element of a dynamic authentication
+// template (tag 7C)
+func dynamicAuthValue(resp []byte, tag uint32, size int) ([]byte, error) {
+	inner, err := unwrapTLV(resp, 0x7C)
+	if err != nil {
+		return nil, err
+	}
+	elements, err := parseTLVs(inner)
+	if err != nil {
+		return nil, err
+	}
+	value, ok := elements[tag]
+	if !ok || (size > 0 && len(value) != size) {
+		return nil, errMalformedTLV
+	}
+	return value, nil
+}
+
+// keyAlgorithm maps a provider algorithm name and size to a PIV algorithm
+func keyAlgorithm(algorithm string, bits int) (byte, error) {
+	switch strings.ToUpper(algorithm) {
+	case "ECDSA", "EC", "ECC":
+		switch bits {
+		case 256:
+			return AlgorithmECCP256, nil
+		case 384:
+			return AlgorithmECCP384, nil
+		}
+	case "RSA":
+		switch bits {
+		case 1024:
+			return AlgorithmRSA1024, nil
+		case 2048:
+			return AlgorithmRSA2048, nil
+		case 3072:
+			return AlgorithmRSA3072, nil
+		case 4096:
+			return AlgorithmRSA4096, nil
+		}
+	}
+	return 0, fmt.Errorf("%w: %s %d", ErrUnsupportedAlgorithm, algorithm, bits)
+}
+
+// publicKeyAlgorithm returns the PIV algorithm of a public key
+func publicKeyAlgorithm(pub crypto.PublicKey) (byte, error) {
+	switch k := pub.(type) {
+	case *ecdsa.PublicKey:
+		return keyAlgorithm("ECDSA", k.Curve.Params().BitSize)
+	case *rsa.PublicKey:
+		return keyAlgorithm("RSA", k.N.BitLen())
+	}
+	return 0, fmt.Errorf("%w: %T", ErrUnsupportedAlgorithm, pub)
+}
+
+// GenerateKey generates a new key pair in the specified slot and returns
+// its public key
+func (yk *YubiKey) GenerateKey(slot PIVSlot, algorithm string, bits int) (crypto.PublicKey, error) {
+	if !slot.isKeySlot() {
+		return nil, fmt.Errorf("invalid PIV slot %X", int(slot))
+	}
+	alg, err := keyAlgorithm(algorithm, bits)
+	if err != nil {
+		return nil, err
+	}
+
+	var pub crypto.PublicKey
+	err = yk.locked(func() error {
+		if err := yk.authenticate(); err != nil {
+			return err
+		}
+		resp, err := transmit(yk.card, apdu{ins: insGenerateAsymmetric, p2: byte(slot),
+			data: tlv(0xAC, tlv(0x80, []byte{alg}))})
+		if err != nil {
+			return fmt.Errorf("failed to generate key: %w", err)
+		}
+		inner, err := unwrapTLV(resp, 0x7F49)
+		if err != nil {
+			return err
+		}
+		if pub, err = parsePublicKey(alg, inner); err != nil {
+			return err
+		}
+		yk.publicKeys[slot] = pub
+		return nil
+	})
+	return pub, err
+}
+
+// parsePublicKey decodes the public key elements returned by the card
+func parsePublicKey(alg byte, data []byte) (crypto.PublicKey, error) {
+	elements, err := parseTLVs(data)
+	if err != nil {
+		return nil, err
+	}
+
+	switch alg {
+	case AlgorithmECCP256, AlgorithmECCP384:
+		curve, ecdhCurve := elliptic.P256(), ecdh.P256()
+		if alg == AlgorithmECCP384 {
+			curve, ecdhCurve = elliptic.P384(), ecdh.P384()
+		}
+		point := elements[0x86]
+		// Validates that the point is on the curve
+		if _, err := ecdhCurve.NewPublicKey(point); err != nil {
+			return nil, fmt.Errorf("invalid EC public key: %w", err)
+		}
+		size := (len(point) - 1) / 2
+		return &ecdsa.PublicKey{
+			Curve: curve,
+			X:     new(big.Int).SetBytes(point[1 : 1+size]),
+			Y:     new(big.Int).SetBytes(point[1+size:]),
+		}, nil
+
+	case AlgorithmRSA1024, AlgorithmRSA2048, AlgorithmRSA3072, AlgorithmRSA4096:
+		modulus, exponent := elements[0x81], elements[0x82]
+		e := new(big.Int).SetBytes(exponent)
+		if len(modulus) == 0 || !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return nil, errors.New("invalid RSA public key")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(modulus), E: int(e.Int64())}, nil
+	}
+	return nil, fmt.Errorf("%w: %02X", ErrUnsupportedAlgorithm, alg)
+}
+
+// keyMetadata describes a key reference as reported by GET METADATA
+type keyMetadata struct {
+	algorithm   byte
+	pinPolicy   byte
+	touchPolicy byte
+	origin      byte
+	publicKey   crypto.PublicKey
+	// retries left for the PIN and PUK
+	retries int
+}
+
+// metadata reads the metadata of a key reference within a transaction.
+// Firmware before YubiKey 5.3 answers with "instruction not supported".
+func (yk *YubiKey) metadata(slot PIVSlot) (*keyMetadata, error) {
+	resp, err := transmit(yk.card, apdu{ins: insMetadata, p2: byte(slot)})
+	if err != nil {
+		return nil, err
+	}
+	elements, err := parseTLVs(resp)
+	if err != nil {
+		return nil, err
+	}
+
+	md := &keyMetadata{}
+	if v := elements[0x01]; len(v) == 1 {
+		md.algorithm = v[0]
+	}
+	if v := elements[0x02]; len(v) == 2 {
+		md.pinPolicy, md.touchPolicy = v[0], v[1]
+	}
+	if v := elements[0x03]; len(v) == 1 {
+		md.origin = v[0]
 	}
-	// This would use the appropriate YubiKey library to generate keys
-	return errors.New("YubiKey key generation not yet implemented")
+	if v := elements[0x06]; len(v) == 2 {
+		md.retries = int(v[1])
+	}
+	if v, ok := elements[0x04]; ok {
+		if md.publicKey, err = parsePublicKey(md.algorithm, v); err != nil {
+			return nil, err
+		}
+	}
+	return md, nil
 }
 
-// GetPublicKey retrieves the public key from a slot
+// GetPublicKey retrieves the public key from a slot. It comes from the key
+// metadata where the firmware supports it and from the slot's certificate
+// otherwise.
 func (yk *YubiKey) GetPublicKey(slot PIVSlot) (crypto.PublicKey, error) {
-	if !yk.Connected {
-		return nil, errors.New("YubiKey not connected")
+	if !slot.isKeySlot() {
+		return nil, fmt.Errorf("invalid PIV slot %X", int(slot))
 	}
-	// This would use the appropriate YubiKey library
-	return nil, errors.New("YubiKey public key retrieval not yet implemented")
+
+	var pub crypto.PublicKey
+	err := yk.locked(func() error {
+		var err error
+		pub, err = yk.publicKey(slot)
+		return err
+	})
+	return pub, err
 }
 
-// Sign signs data using the private key in the specified slot
-func (yk *YubiKey) Sign(slot PIVSlot, digest []byte) ([]byte, error) {
-	if !yk.Connected {
-		return nil, errors.New("YubiKey not connected")
+// publicKey looks up the public key of a slot within a transaction
+func (yk *YubiKey) publicKey(slot PIVSlot) (crypto.PublicKey, error) {
+	if pub, ok := yk.publicKeys[slot]; ok {
+		return pub, nil
+	}
+
+	md, err := yk.metadata(slot)
+	switch {
+	case err == nil && md.publicKey != nil:
+		yk.publicKeys[slot] = md.publicKey
+		return md.publicKey, nil
+	case isStatus(err, swReferenceNotFound):
+		return nil, ErrKeyNotFound
+	case err != nil && !isStatus(err, swInsNotSupported):
+		return nil, fmt.Errorf("failed to read key metadata: %w", err)
+	}
+
+	cert, err := yk.certificate(slot)
+	if err != nil {
+		if errors.Is(err, ErrCertNotFound) {
+			return nil, ErrKeyNotFound
+		}
+		return nil, err
+	}
+	return cert.PublicKey, nil
+}
+
+// Sign signs a digest using the private key in the specified slot. ECDSA
+// signatures are ASN.1 encoded; RSA keys sign with PKCS #1 v1.5.
+func (yk *YubiKey) Sign(slot PIVSlot, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	if !slot.isKeySlot() {
+		return nil, fmt.Errorf("invalid PIV slot %X", int(slot))
+	}
+
+	var sig []byte
+	err := yk.locked(func() error {
+		pub, err := yk.publicKey(slot)
+		if err != nil {
+			return err
+		}
+		alg, err := publicKeyAlgorithm(pub)
+		if err != nil {
+			return err
+		}
+
+		var input []byte
+		switch k := pub.(type) {
+		case *ecdsa.PublicKey:
+			input = ecdsaInput(digest, (k.Curve.Params().BitSize+7)/8)
+		case *rsa.PublicKey:
+			if _, ok := opts.(*rsa.PSSOptions); ok {
+				return fmt.Errorf("%w: RSA-PSS", ErrUnsupportedAlgorithm)
+			}
+			if input, err = pkcs1v15Pad(opts.HashFunc(), digest, k.Size()); err != nil {
+				return err
+			}
+		}
+
+		// Slots with the "always" PIN policy need it before every signature
+		if yk.PIN != "" {
+			if err := yk.verifyPIN(yk.PIN); err != nil {
+				return err
+			}
+		}
+
+		resp, err := transmit(yk.card, apdu{ins: insGeneralAuth, p1: alg, p2: byte(slot),
+			data: tlv(0x7C, tlv(0x82, nil), tlv(0x81, input))})
+		if err != nil {
+			if isStatus(err, swSecurityNotSatisfied) && yk.PIN == "" {
+				return ErrPINRequired
+			}
+			return fmt.Errorf("failed to sign: %w", err)
+		}
+		sig, err = dynamicAuthValue(resp, 0x82, 0)
+		return err
+	})
+	return sig, err
+}
+
+// ecdsaInput fits a digest to the size of the curve the way ECDSA does:
+// longer digests are truncated, shorter ones keep their integer value
+func ecdsaInput(digest []byte, size int) []byte {
+	if len(digest) >= size {
+		return digest[:size]
+	}
+	input := make([]byte, size)
+	copy(input[size-len(digest):], digest)
+	return input
+}
+
+// digestInfoPrefixes are the DER DigestInfo headers of PKCS #1 v1.5
+var digestInfoPrefixes = map[crypto.Hash][]byte{
+	crypto.SHA1:   {0x30, 0x21, 0x30, 0x09, 0x06, 0x05, 0x2b, 0x0e, 0x03, 0x02, 0x1a, 0x05, 0x00, 0x04, 0x14},
+	crypto.SHA224: {0x30, 0x2d, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x04, 0x05, 0x00, 0x04, 0x1c},
+	crypto.SHA256: {0x30, 0x31, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x01, 0x05, 0x00, 0x04, 0x20},
+	crypto.SHA384: {0x30, 0x41, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x02, 0x05, 0x00, 0x04, 0x30},
+	crypto.SHA512: {0x30, 0x51, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x03, 0x05, 0x00, 0x04, 0x40},
+}
+
+// pkcs1v15Pad builds the EMSA-PKCS1-v1_5 encoding of a digest, which the
+// card then raises to the private exponent
+func pkcs1v15Pad(hash crypto.Hash, digest []byte, size int) ([]byte, error) {
+	prefix, ok := digestInfoPrefixes[hash]
+	if !ok && hash != 0 {
+		return nil, fmt.Errorf("%w: hash %s", ErrUnsupportedAlgorithm, hash)
+	}
+	if hash != 0 && len(digest) != hash.Size() {
+		return nil, errors.New("digest length does not match the hash")
+	}
+	t := len(prefix) + len(digest)
+	if size < t+11 {
+		return nil, errors.New("RSA key too short for the digest")
+	}
+
+	em := make([]byte, size)
+	em[1] = 0x01
+	for i := 2; i < size-t-1; i++ {
+		em[i] = 0xFF
+	}
+	copy(em[size-t:], prefix)
+	copy(em[size-len(digest):], digest)
+	return em, nil
+}
+
+// ImportKey imports an existing private key into the specified slot
+func (yk *YubiKey) ImportKey(slot PIVSlot, key crypto.PrivateKey) error {
+	if !slot.isKeySlot() {
+		return fmt.Errorf("invalid PIV slot %X", int(slot))
+	}
+
+	var alg byte
+	var data []byte
+	switch k := key.(type) {
+	case *ecdsa.PrivateKey:
+		var err error
+		if alg, err = publicKeyAlgorithm(&k.PublicKey); err != nil {
+			return err
+		}
+		size := (k.Curve.Params().BitSize + 7) / 8
+		data = tlv(0x06, k.D.FillBytes(make([]byte, size)))
+	case *rsa.PrivateKey:
+		var err error
+		if alg, err = publicKeyAlgorithm(&k.PublicKey); err != nil {
+			return err
+		}
+		if len(k.Primes) != 2 {
+			return fmt.Errorf("%w: multi-prime RSA", ErrUnsupportedAlgorithm)
+		}
+		k.Precompute()
+		size := k.Size() / 2
+		for i, v := range []*big.Int{k.Primes[0], k.Primes[1], k.Precomputed.Dp, k.Precomputed.Dq, k.Precomputed.Qinv} {
+			data = appendTLV(data, uint32(0x01+i), v.FillBytes(make([]byte, size)))
+		}
+	default:
+		return fmt.Errorf("%w: %T", ErrUnsupportedAlgorithm, key)
 	}
-	// This would use the appropriate YubiKey library
-	return nil, errors.New("YubiKey signing not yet implemented")
+
+	return yk.locked(func() error {
+		if err := yk.authenticate(); err != nil {
+			return err
+		}
+		if _, err := transmit(yk.card, apdu{ins: insImportKey, p1: alg, p2: byte(slot), data: data}); err != nil {
+			return fmt.Errorf("failed to import key: %w", err)
+		}
+		delete(yk.publicKeys, slot)
+		return nil
+	})
 }
 
 // ImportCertificate imports a certificate into a slot
 func (yk *YubiKey) ImportCertificate(slot PIVSlot, cert *x509.Certificate) error {
-	if !yk.Connected {
-		return errors.New("YubiKey not connected")
+	object, err := slot.certificateObject()
+	if err != nil {
+		return err
 	}
-	// This would use the appropriate YubiKey library
-	return errors.New("YubiKey certificate import not yet implemented")
+
+	// Certificate, uncompressed certificate info and error detection code
+	value := tlv(0x53, tlv(0x70, cert.Raw), tlv(0x71, []byte{0x00}), tlv(0xFE, nil))
+	return yk.locked(func() error {
+		if err := yk.authenticate(); err != nil {
+			return err
+		}
+		if _, err := transmit(yk.card, apdu{ins: insPutData, p1: 0x3F, p2: 0xFF,
+			data: append(tlv(0x5C, object), value...)}); err != nil {
+			return fmt.Errorf("failed to store certificate: %w", err)
+		}
+		return nil
+	})
 }
 
 // GetCertificate retrieves a certificate from a slot
 func (yk *YubiKey) GetCertificate(slot PIVSlot) (*x509.Certificate, error) {
-	if !yk.Connected {
-		return nil, errors.New("YubiKey not connected")
+	var cert *x509.Certificate
+	err := yk.locked(func() error {
+		var err error
+		cert, err = yk.certificate(slot)
+		return err
+	})
+	return cert, err
+}
+
+// certificate reads a slot's certificate within a transaction
+func (yk *YubiKey) certificate(slot PIVSlot) (*x509.Certificate, error) {
+	object, err := slot.certificateObject()
+	if err != nil {
+		return nil, err
+	}
+	resp, err := transmit(yk.card, apdu{ins: insGetData, p1: 0x3F, p2: 0xFF, data: tlv(0x5C, object)})
+	if err != nil {
+		if isStatus(err, swFileNotFound) {
+			return nil, ErrCertNotFound
+		}
+		return nil, fmt.Errorf("failed to read certificate: %w", err)
+	}
+
+	value, err := unwrapTLV(resp, 0x53)
+	if err != nil {
+		return nil, err
+	}
+	elements, err := parseTLVs(value)
+	if err != nil {
+		return nil, err
+	}
+	der, ok := elements[0x70]
+	if !ok || len(der) == 0 {
+		return nil, ErrCertNotFound
+	}
+	if info := elements[0x71]; len(info) > 0 && info[0] != 0 {
+		return nil, errors.New("compressed certificates are not supported")
 	}
-	// This would use the appropriate YubiKey library
-	return nil, errors.New("YubiKey certificate retrieval not yet implemented")
+	return x509.ParseCertificate(der)
 }
@@ -0,0 +1,626 @@
+package yubikey
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"io"
+	"io/fs"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// openVirtual returns a YubiKey backed by a new virtual card
+func openVirtual(t *testing.T) (*YubiKey, *VirtualCard) {
+	t.Helper()
+	card := NewVirtualCard()
+	yk, err := Open(card)
+	if err != nil {
+		t.Fatalf("Failed to open virtual card: %v", err)
+	}
+	t.Cleanup(func() { yk.Close() })
+	return yk, card
+}
+
+// verifySignature checks a signature over digest with a public key
+func verifySignature(t *testing.T, pub crypto.PublicKey, digest, sig []byte) {
+	t.Helper()
+	switch k := pub.(type) {
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(k, digest, sig) {
+			t.Errorf("ECDSA signature does not verify")
+		}
+	case *rsa.PublicKey:
+		if err := rsa.VerifyPKCS1v15(k, crypto.SHA256, digest, sig); err != nil {
+			t.Errorf("RSA signature does not verify: %v", err)
+		}
+	default:
+		t.Fatalf("Unexpected public key type %T", pub)
+	}
+}
+
+func TestOpen(t *testing.T) {
+	yk, _ := openVirtual(t)
+	if yk.Version != "5.4.3" {
+		t.Errorf("Expected version 5.4.3, got %s", yk.Version)
+	}
+	if yk.SerialNumber != 10000001 {
+		t.Errorf("Expected serial 10000001, got %d", yk.SerialNumber)
+	}
+
+	yk.Close()
+	if _, err := yk.GetPublicKey(SlotCA1); !errors.Is(err, ErrNotConnected) {
+		t.Errorf("Expected ErrNotConnected after close, got %v", err)
+	}
+}
+
+func TestGenerateKeyAndSign(t *testing.T) {
+	tests := []struct {
+		algorithm string
+		bits      int
+	}{
+		{"ECDSA", 256},
+		{"ECDSA", 384},
+		{"RSA", 2048},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.algorithm, func(t *testing.T) {
+			yk, card := openVirtual(t)
+			yk.PIN = DefaultPIN
+
+			pub, err := yk.GenerateKey(SlotCA1, tt.algorithm, tt.bits)
+			if err != nil {
+				t.Fatalf("Failed to generate key: %v", err)
+			}
+			switch k := pub.(type) {
+			case *ecdsa.PublicKey:
+				if k.Curve.Params().BitSize != tt.bits {
+					t.Errorf("Expected a %d bit curve, got %d", tt.bits, k.Curve.Params().BitSize)
+				}
+			case *rsa.PublicKey:
+				if k.N.BitLen() != tt.bits {
+					t.Errorf("Expected a %d bit modulus, got %d", tt.bits, k.N.BitLen())
+				}
+			}
+
+			digest := sha256.Sum256([]byte("PiCA"))
+			sig, err := yk.Sign(SlotCA1, digest[:], crypto.SHA256)
+			if err != nil {
+				t.Fatalf("Failed to sign: %v", err)
+			}
+			verifySignature(t, pub, digest[:], sig)
+
+			// A fresh session reads the public key from the key metadata
+			other, err := Open(card)
+			if err != nil {
+				t.Fatalf("Failed to reopen card: %v", err)
+			}
+			got, err := other.GetPublicKey(SlotCA1)
+			if err != nil {
+				t.Fatalf("Failed to read public key: %v", err)
+			}
+			if !got.(interface{ Equal(crypto.PublicKey) bool }).Equal(pub) {
+				t.Errorf("Public key from metadata does not match the generated key")
+			}
+		})
+	}
+}
+
+func TestGenerateKeyUnsupported(t *testing.T) {
+	yk, _ := openVirtual(t)
+	if _, err := yk.GenerateKey(SlotCA1, "ECDSA", 521); !errors.Is(err, ErrUnsupportedAlgorithm) {
+		t.Errorf("Expected ErrUnsupportedAlgorithm for P-521, got %v", err)
+	}
+	if _, err := yk.GenerateKey(PIVSlot(0x70), "ECDSA", 256); err == nil {
+		t.Errorf("Expected an error for an invalid slot")
+	}
+}
+
+func TestKeyNotFound(t *testing.T) {
+	yk, _ := openVirtual(t)
+	if _, err := yk.GetPublicKey(SlotCA2); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("Expected ErrKeyNotFound, got %v", err)
+	}
+	if _, err := yk.Sign(SlotCA2, make([]byte, 32), crypto.SHA256); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("Expected ErrKeyNotFound when signing, got %v", err)
+	}
+}
+
+func TestPINPolicy(t *testing.T) {
+	yk, _ := openVirtual(t)
+	digest := sha256.Sum256([]byte("PiCA"))
+
+	// The signature slot needs the PIN for every signature
+	if _, err := yk.GenerateKey(SlotSignature, "ECDSA", 256); err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	if _, err := yk.Sign(SlotSignature, digest[:], crypto.SHA256); !errors.Is(err, ErrPINRequired) {
+		t.Errorf("Expected ErrPINRequired, got %v", err)
+	}
+	yk.PIN = DefaultPIN
+	for i := 0; i < 2; i++ {
+		if _, err := yk.Sign(SlotSignature, digest[:], crypto.SHA256); err != nil {
+			t.Fatalf("Failed to sign with PIN: %v", err)
+		}
+	}
+
+	// The card authentication slot never needs it
+	yk.PIN = ""
+	if _, err := yk.GenerateKey(SlotCardAuth, "ECDSA", 256); err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	if _, err := yk.Sign(SlotCardAuth, digest[:], crypto.SHA256); err != nil {
+		t.Errorf("Expected signing without PIN to succeed, got %v", err)
+	}
+}
+
+func TestWrongPIN(t *testing.T) {
+	yk, _ := openVirtual(t)
+
+	for _, want := range []int{2, 1} {
+		var pinErr *PINError
+		if err := yk.VerifyPIN("654321"); !errors.As(err, &pinErr) || pinErr.Retries != want {
+			t.Fatalf("Expected PINError with %d retries, got %v", want, err)
+		}
+	}
+	if err := yk.VerifyPIN(DefaultPIN); err != nil {
+		t.Fatalf("Expected the right PIN to reset the counter, got %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		yk.VerifyPIN("654321")
+	}
+	if err := yk.VerifyPIN("654321"); !errors.Is(err, ErrPINBlocked) {
+		t.Errorf("Expected ErrPINBlocked on the last retry, got %v", err)
+	}
+	if err := yk.VerifyPIN(DefaultPIN); !errors.Is(err, ErrPINBlocked) {
+		t.Errorf("Expected a blocked PIN to stay blocked, got %v", err)
+	}
+	if err := yk.VerifyPIN("12345"); err == nil {
+		t.Errorf("Expected a short PIN to be rejected")
+	}
+}
+
+func TestWrongManagementKey(t *testing.T) {
+	yk, _ := openVirtual(t)
+	yk.ManagementKey = bytes.Repeat([]byte{0x42}, 24)
+	if _, err := yk.GenerateKey(SlotCA1, "ECDSA", 256); !errors.Is(err, ErrWrongManagementKey) {
+		t.Errorf("Expected ErrWrongManagementKey, got %v", err)
+	}
+
+	yk.ManagementKey = DefaultManagementKey[:16]
+	if _, err := yk.GenerateKey(SlotCA1, "ECDSA", 256); err == nil {
+		t.Errorf("Expected an error for a management key of the wrong length")
+	}
+}
+
+func TestImportKey(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, key := range []crypto.Signer{ecKey, rsaKey} {
+		yk, _ := openVirtual(t)
+		yk.PIN = DefaultPIN
+		if err := yk.ImportKey(SlotCA2, key); err != nil {
+			t.Fatalf("Failed to import %T: %v", key, err)
+		}
+		digest := sha256.Sum256([]byte("imported"))
+		sig, err := yk.Sign(SlotCA2, digest[:], crypto.SHA256)
+		if err != nil {
+			t.Fatalf("Failed to sign with imported %T: %v", key, err)
+		}
+		verifySignature(t, key.Public(), digest[:], sig)
+	}
+}
+
+func TestCertificate(t *testing.T) {
+	yk, _ := openVirtual(t)
+	if _, err := yk.GetCertificate(SlotCA1); !errors.Is(err, ErrCertNotFound) {
+		t.Errorf("Expected ErrCertNotFound, got %v", err)
+	}
+
+	// An RSA certificate is larger than one APDU in either direction
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "PiCA Test CA", Organization: []string{strings.Repeat("PiCA ", 20)}},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	if len(cert.Raw) <= 2*maxAPDUData {
+		t.Fatalf("Test certificate too small to need chaining: %d bytes", len(cert.Raw))
+	}
+
+	if err := yk.ImportCertificate(SlotCA1, cert); err != nil {
+		t.Fatalf("Failed to import certificate: %v", err)
+	}
+	got, err := yk.GetCertificate(SlotCA1)
+	if err != nil {
+		t.Fatalf("Failed to read certificate: %v", err)
+	}
+	if !got.Equal(cert) {
+		t.Errorf("Certificate read back does not match")
+	}
+
+}
+
+// fakePCSCD serves the pcsc-lite client protocol for a virtual card in a
+// reader called "Yubico YubiKey OTP+FIDO+CCID 00 00"
+func fakePCSCD(t *testing.T, card *VirtualCard) {
+	t.Helper()
+	// Unix socket paths are short, so avoid the long test directory
+	dir, err := os.MkdirTemp("", "pcsc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	path := filepath.Join(dir, "pcscd.comm")
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+	t.Setenv("PCSCLITE_CSOCK_NAME", path)
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go servePCSC(conn, card)
+		}
+	}()
+}
+
+// servePCSC answers the commands of one client connection
+func servePCSC(conn net.Conn, card *VirtualCard) {
+	defer conn.Close()
+	for {
+		header := make([]byte, 8)
+		if _, err := io.ReadFull(conn, header); err != nil {
+			return
+		}
+		size := binary.NativeEndian.Uint32(header)
+		command := binary.NativeEndian.Uint32(header[4:])
+		msg := make([]byte, size)
+		if _, err := io.ReadFull(conn, msg); err != nil {
+			return
+		}
+		field := func(i int) uint32 { return binary.NativeEndian.Uint32(msg[4*i:]) }
+		setField := func(i int, v uint32) { binary.NativeEndian.PutUint32(msg[4*i:], v) }
+
+		var payload []byte
+		switch command {
+		case pcscVersion:
+			setField(2, pcscSuccess)
+		case pcscEstablishContext:
+			setField(1, 0x1234)
+			setField(2, pcscSuccess)
+		case pcscReleaseContext, pcscBeginTransaction:
+			setField(1, pcscSuccess)
+		case pcscEndTransaction, pcscDisconnect:
+			setField(2, pcscSuccess)
+		case pcscGetReadersState:
+			msg = make([]byte, pcscMaxReaders*pcscReaderStateSize)
+			copy(msg, "Yubico YubiKey OTP+FIDO+CCID 00 00")
+		case pcscConnect:
+			// The reader name precedes the share mode
+			if !strings.HasPrefix(string(msg[4:]), "Yubico") {
+				binary.NativeEndian.PutUint32(msg[4+pcscMaxReaderName+16:], pcscReaderUnavailable)
+				break
+			}
+			off := 4 + pcscMaxReaderName
+			binary.NativeEndian.PutUint32(msg[off+8:], 0x5678)
+			binary.NativeEndian.PutUint32(msg[off+12:], pcscProtocolT1)
+			binary.NativeEndian.PutUint32(msg[off+16:], pcscSuccess)
+		case pcscTransmit:
+			apdu := make([]byte, field(3))
+			if _, err := io.ReadFull(conn, apdu); err != nil {
+				return
+			}
+			resp, _ := card.Transmit(apdu)
+			setField(6, uint32(len(resp)))
+			setField(7, pcscSuccess)
+			payload = resp
+		default:
+			return
+		}
+		if _, err := conn.Write(append(msg, payload...)); err != nil {
+			return
+		}
+	}
+}
+
+func TestPCSC(t *testing.T) {
+	card := NewVirtualCard()
+	fakePCSCD(t, card)
+
+	readers, err := Readers()
+	if err != nil {
+		t.Fatalf("Failed to list readers: %v", err)
+	}
+	if len(readers) != 1 || !strings.HasPrefix(readers[0], "Yubico") {
+		t.Fatalf("Unexpected readers: %v", readers)
+	}
+
+	yk, err := Connect()
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer yk.Close()
+	if yk.SerialNumber != card.Serial {
+		t.Errorf("Expected serial %d, got %d", card.Serial, yk.SerialNumber)
+	}
+
+	yk.PIN = DefaultPIN
+	pub, err := yk.GenerateKey(SlotCA1, "RSA", 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate key over PC/SC: %v", err)
+	}
+	digest := sha256.Sum256([]byte("PC/SC"))
+	sig, err := yk.Sign(SlotCA1, digest[:], crypto.SHA256)
+	if err != nil {
+		t.Fatalf("Failed to sign over PC/SC: %v", err)
+	}
+	verifySignature(t, pub, digest[:], sig)
+
+	if _, err := ConnectReader("Other Reader"); err == nil {
+		t.Errorf("Expected an error for an unknown reader")
+	}
+}
+
+func TestChangePINAndUnblock(t *testing.T) {
+	yk, _ := openVirtual(t)
+
+	if pin, puk, err := yk.PINRetries(); err != nil || pin != 3 || puk != 3 {
+		t.Fatalf("Expected 3 PIN and PUK retries, got %d, %d, %v", pin, puk, err)
+	}
+	if err := yk.ChangePIN(DefaultPIN, "24681357"); err != nil {
+		t.Fatalf("Failed to change PIN: %v", err)
+	}
+	if err := yk.VerifyPIN(DefaultPIN); err == nil {
+		t.Errorf("Expected the old PIN to be rejected")
+	}
+
+	// Block the PIN, then unblock it with the PUK
+	for i := 0; i < 2; i++ {
+		yk.VerifyPIN(DefaultPIN)
+	}
+	if pin, _, _ := yk.PINRetries(); pin != 0 {
+		t.Errorf("Expected a blocked PIN, got %d retries", pin)
+	}
+	var pinErr *PINError
+	if err := yk.UnblockPIN("87654321", "11223344"); !errors.As(err, &pinErr) || !pinErr.PUK || pinErr.Retries != 2 {
+		t.Errorf("Expected a PUK error with 2 retries, got %v", err)
+	}
+	if err := yk.UnblockPIN(DefaultPUK, "11223344"); err != nil {
+		t.Fatalf("Failed to unblock PIN: %v", err)
+	}
+	if err := yk.VerifyPIN("11223344"); err != nil {
+		t.Errorf("Expected the new PIN to verify, got %v", err)
+	}
+
+	if err := yk.ChangePUK(DefaultPUK, "99887766"); err != nil {
+		t.Fatalf("Failed to change PUK: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		yk.ChangePUK(DefaultPUK, "12341234")
+	}
+	if err := yk.UnblockPIN("99887766", "11223344"); !errors.Is(err, ErrPUKBlocked) {
+		t.Errorf("Expected ErrPUKBlocked, got %v", err)
+	}
+}
+
+func TestSetManagementKey(t *testing.T) {
+	yk, card := openVirtual(t)
+	key := bytes.Repeat([]byte{0x5A}, 32)
+	if err := yk.SetManagementKey(ManagementKeyAES256, key); err != nil {
+		t.Fatalf("Failed to set management key: %v", err)
+	}
+	if _, err := yk.GenerateKey(SlotCA1, "ECDSA", 256); err != nil {
+		t.Errorf("Expected the new key to authenticate, got %v", err)
+	}
+
+	// A new session learns the algorithm from the card
+	other, err := Open(card)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := other.GenerateKey(SlotCA1, "ECDSA", 256); !errors.Is(err, ErrWrongManagementKey) {
+		t.Errorf("Expected the default key to be rejected, got %v", err)
+	}
+	other.ManagementKey = key
+	if _, err := other.GenerateKey(SlotCA1, "ECDSA", 256); err != nil {
+		t.Errorf("Expected the new AES key to authenticate, got %v", err)
+	}
+}
+
+func TestAttestation(t *testing.T) {
+	yk, card := openVirtual(t)
+	pub, err := yk.GenerateKey(SlotSignature, "ECDSA", 384)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+
+	cert, err := yk.Attest(SlotSignature)
+	if err != nil {
+		t.Fatalf("Failed to attest key: %v", err)
+	}
+	device, err := yk.AttestationCertificate()
+	if err != nil {
+		t.Fatalf("Failed to read attestation certificate: %v", err)
+	}
+
+	root, err := VirtualAttestationRoot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(root)
+	a, err := VerifyAttestation(cert, []*x509.Certificate{device}, roots)
+	if err != nil {
+		t.Fatalf("Failed to verify attestation: %v", err)
+	}
+	if a.Slot != SlotSignature || a.Serial != card.Serial || a.Version != "5.4.3" ||
+		a.PINPolicy != PINPolicyAlways || a.TouchPolicy != TouchPolicyNever {
+		t.Errorf("Unexpected attestation: %+v", a)
+	}
+	if !pub.(*ecdsa.PublicKey).Equal(a.PublicKey) {
+		t.Error("Attestation is for a different key")
+	}
+
+	// The bundled Yubico roots do not trust the virtual card
+	if _, err := VerifyAttestation(cert, []*x509.Certificate{device}, YubicoRoots()); !errors.Is(err, ErrInvalidAttestation) {
+		t.Errorf("Expected ErrInvalidAttestation, got %v", err)
+	}
+	// An empty pool says so instead of failing like an untrusted device
+	if _, err := VerifyAttestation(cert, []*x509.Certificate{device}, x509.NewCertPool()); !errors.Is(err, ErrNoAttestationRoots) {
+		t.Errorf("Expected ErrNoAttestationRoots, got %v", err)
+	}
+
+	// Only keys generated on the card can be attested
+	if _, err := yk.Attest(SlotCA1); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("Expected ErrKeyNotFound, got %v", err)
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := yk.ImportKey(SlotCA1, key); err != nil {
+		t.Fatalf("Failed to import key: %v", err)
+	}
+	if _, err := yk.Attest(SlotCA1); !errors.Is(err, ErrNotAttestable) {
+		t.Errorf("Expected ErrNotAttestable, got %v", err)
+	}
+}
+
+func TestSlotsAndDeleteKey(t *testing.T) {
+	card := NewVirtualCard()
+	card.Version = [3]byte{5, 7, 1}
+	yk, err := Open(card)
+	if err != nil {
+		t.Fatalf("Failed to open virtual card: %v", err)
+	}
+	defer yk.Close()
+
+	if _, err := yk.GenerateKey(SlotCA1, "ECDSA", 384); err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := yk.ImportKey(SlotCA2, key); err != nil {
+		t.Fatalf("Failed to import key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "PiCA Test CA"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	if err := yk.ImportCertificate(SlotCA2, cert); err != nil {
+		t.Fatalf("Failed to import certificate: %v", err)
+	}
+
+	slots, err := yk.Slots()
+	if err != nil {
+		t.Fatalf("Failed to list slots: %v", err)
+	}
+	if len(slots) != 2 {
+		t.Fatalf("Expected 2 slots, got %+v", slots)
+	}
+	if s := slots[0]; s.Slot != SlotCA1 || !s.HasKey || s.Algorithm != AlgorithmECCP384 || !s.Generated || s.Certificate != nil {
+		t.Errorf("Unexpected slot: %+v", s)
+	}
+	if s := slots[1]; s.Slot != SlotCA2 || s.Algorithm != AlgorithmECCP256 || s.Generated || s.Certificate == nil {
+		t.Errorf("Unexpected slot: %+v", s)
+	}
+
+	if err := yk.DeleteKey(SlotCA2); err != nil {
+		t.Fatalf("Failed to delete key: %v", err)
+	}
+	if _, err := yk.GetPublicKey(SlotCA2); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("Expected ErrKeyNotFound after deleting, got %v", err)
+	}
+	if _, err := yk.GetCertificate(SlotCA2); !errors.Is(err, ErrCertNotFound) {
+		t.Errorf("Expected ErrCertNotFound after deleting, got %v", err)
+	}
+	if err := yk.DeleteKey(SlotCA2); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("Expected ErrKeyNotFound for an empty slot, got %v", err)
+	}
+
+	// Firmware before 5.7 cannot delete keys
+	old, _ := openVirtual(t)
+	if _, err := old.GenerateKey(SlotCA1, "ECDSA", 256); err != nil {
+		t.Fatal(err)
+	}
+	if err := old.DeleteKey(SlotCA1); !errors.Is(err, ErrUnsupportedFirmware) {
+		t.Errorf("Expected ErrUnsupportedFirmware, got %v", err)
+	}
+}
+
+func TestYubicoRoots(t *testing.T) {
+	files, err := fs.Glob(roots, "roots/*.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 {
+		t.Skip("the Yubico PIV attestation root is not bundled yet; see roots/README.md")
+	}
+
+	// Every bundled file must be a CA certificate, since YubicoRoots skips
+	// anything it cannot parse
+	for _, name := range files {
+		data, _ := roots.ReadFile(name)
+		block, _ := pem.Decode(data)
+		if block == nil {
+			t.Fatalf("%s is not PEM encoded", name)
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			t.Fatalf("Failed to parse %s: %v", name, err)
+		}
+		if !cert.IsCA {
+			t.Errorf("%s is not a CA certificate", name)
+		}
+	}
+	if len(YubicoRoots().Subjects()) == 0 {
+		t.Error("Expected the bundled Yubico roots to be trusted")
+	}
+}
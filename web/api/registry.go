@@ -0,0 +1,91 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/billchurch/PiCA/internal/ca"
+	"github.com/billchurch/PiCA/internal/crypto"
+)
+
+// DefaultCAName is the name used for the CA of a single-CA server
+const DefaultCAName = "default"
+
+// CAEntry is one CA served by pica-web. Every CA has its own signing
+// config, certificate, key slot, CRL and storage directories.
+type CAEntry struct {
+	Name    string
+	CA      *ca.CA
+	Slot    crypto.Slot
+	CertDir string
+	CSRDir  string
+
+	// CRLPublisher, when set, is refreshed after every revocation
+	CRLPublisher *ca.CRLPublisher
+}
+
+// Registry holds the CAs served by one pica-web instance
+type Registry struct {
+	entries map[string]*CAEntry
+	order   []string
+	// Default is the CA answering the legacy /api/... endpoints
+	Default string
+	mutex   sync.RWMutex
+}
+
+// NewRegistry creates an empty CA registry
+func NewRegistry() *Registry {
+	return &Registry{entries: make(map[string]*CAEntry)}
+}
+
+// Add registers a CA. The first CA added becomes the default unless a
+// default has been set explicitly.
+func (r *Registry) Add(entry *CAEntry) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if entry.Name == "" || entry.CA == nil {
+		return errors.New("a CA entry needs a name and a CA")
+	}
+	if _, ok := r.entries[entry.Name]; ok {
+		return fmt.Errorf("CA %q is already registered", entry.Name)
+	}
+
+	r.entries[entry.Name] = entry
+	r.order = append(r.order, entry.Name)
+	if r.Default == "" {
+		r.Default = entry.Name
+	}
+	return nil
+}
+
+// Get returns a CA by name
+func (r *Registry) Get(name string) (*CAEntry, bool) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	entry, ok := r.entries[name]
+	return entry, ok
+}
+
+// DefaultCA returns the CA used by the legacy endpoints
+func (r *Registry) DefaultCA() (*CAEntry, bool) {
+	r.mutex.RLock()
+	name := r.Default
+	r.mutex.RUnlock()
+
+	return r.Get(name)
+}
+
+// Entries returns the registered CAs in registration order
+func (r *Registry) Entries() []*CAEntry {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	entries := make([]*CAEntry, 0, len(r.order))
+	for _, name := range r.order {
+		entries = append(entries, r.entries[name])
+	}
+	return entries
+}
@@ -2,8 +2,10 @@ package api
 
 import (
 	"crypto/x509"
+	"encoding/hex"
 	"encoding/json"
 	"encoding/pem"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
@@ -14,6 +16,7 @@ import (
 	"github.com/billchurch/PiCA/internal/ca"
 	"github.com/billchurch/PiCA/internal/ca/commands"
 	"github.com/billchurch/PiCA/internal/crypto"
+	"github.com/billchurch/PiCA/internal/webhook"
 	"github.com/billchurch/PiCA/internal/yubikey"
 )
 
@@ -23,6 +26,26 @@ type Server struct {
 	YubiKeySlot yubikey.PIVSlot
 	CertDir     string
 	CSRDir      string
+	Webhooks    *webhook.Dispatcher
+
+	// CRLPublisher, when set, is refreshed after every revocation
+	CRLPublisher *ca.CRLPublisher
+
+	// SSH, when set, issues OpenSSH certificates under /api/ssh
+	SSH *ca.SSHCA
+
+	// TSA, when set, answers RFC 3161 time-stamp requests under /tsa
+	TSA *ca.TSA
+
+	// Credentials, when set, caches the PIN entered through /api/unlock
+	// for the hardware providers
+	Credentials *crypto.CredentialCache
+	// unlockGuard slows down clients guessing the PIN
+	unlockGuard unlockGuard
+
+	// Registry, when set, replaces the single CA above and serves several
+	// CAs under /api/v1/cas/{name}/...
+	Registry *Registry
 }
 
 // NewServer creates a new API server
@@ -35,28 +58,260 @@ func NewServer(ca *ca.CA, slot yubikey.PIVSlot, certDir, csrDir string) *Server
 	}
 }
 
+// NewServerWithRegistry creates an API server for several CAs
+func NewServerWithRegistry(registry *Registry) *Server {
+	return &Server{Registry: registry}
+}
+
 // StartServer starts the API server
 func (s *Server) StartServer(addr string) error {
-	// Ensure directories exist
-	if err := os.MkdirAll(s.CertDir, 0755); err != nil {
-		return fmt.Errorf("error creating certificate directory: %w", err)
+	if err := s.prepare(); err != nil {
+		return err
 	}
-	if err := os.MkdirAll(s.CSRDir, 0755); err != nil {
-		return fmt.Errorf("error creating CSR directory: %w", err)
-	}
-
-	// Set up routes
-	http.HandleFunc("/api/health", s.handleHealth)
-	http.HandleFunc("/api/submit-csr", s.handleSubmitCSR)
-	http.HandleFunc("/api/certificates", s.handleListCertificates)
-	http.HandleFunc("/api/certificate/", s.handleGetCertificate)
-	http.HandleFunc("/api/revoke", s.handleRevokeCertificate)
 
 	// Start the server
 	log.Printf("Starting API server on %s", addr)
 	return http.ListenAndServe(addr, nil)
 }
 
+// StartServerTLS starts the API server with HTTPS
+func (s *Server) StartServerTLS(addr, certFile, keyFile string) error {
+	if err := s.prepare(); err != nil {
+		return err
+	}
+
+	log.Printf("Starting API server on %s (HTTPS)", addr)
+	return http.ListenAndServeTLS(addr, certFile, keyFile, nil)
+}
+
+// prepare creates the storage directories and registers the routes
+func (s *Server) prepare() error {
+	// Ensure directories exist
+	for _, entry := range s.entries() {
+		if err := os.MkdirAll(entry.CertDir, 0755); err != nil {
+			return fmt.Errorf("error creating certificate directory: %w", err)
+		}
+		if err := os.MkdirAll(entry.CSRDir, 0755); err != nil {
+			return fmt.Errorf("error creating CSR directory: %w", err)
+		}
+	}
+
+	s.RegisterRoutes(http.DefaultServeMux)
+	return nil
+}
+
+// RegisterRoutes adds the API routes to mux
+func (s *Server) RegisterRoutes(mux *http.ServeMux) {
+	// Legacy routes act on the default CA
+	mux.HandleFunc("/api/health", s.handleHealth)
+	mux.HandleFunc("/api/submit-csr", s.withDefaultCA(s.handleSubmitCSR))
+	mux.HandleFunc("/api/certificates", s.withDefaultCA(s.handleListCertificates))
+	mux.HandleFunc("/api/certificate/", s.withDefaultCA(s.handleGetCertificate))
+	mux.HandleFunc("/api/revoke", s.withDefaultCA(s.handleRevokeCertificate))
+	mux.HandleFunc("/crl", s.withDefaultCA(s.handleCRL))
+	mux.HandleFunc("/crl/", s.withDefaultCA(s.handleCRL))
+	mux.HandleFunc("/api/chains", s.withDefaultCA(s.handleChains))
+	mux.HandleFunc("/api/policy/test", s.withDefaultCA(s.handlePolicyTest))
+	mux.HandleFunc("/api/templates", s.withDefaultCA(s.handleTemplates))
+	mux.HandleFunc("/api/templates/", s.withDefaultCA(s.handleTemplates))
+
+	// SSH certificate authority
+	mux.HandleFunc("/api/ssh/", s.handleSSH)
+	mux.HandleFunc("/ssh/krl", s.handleSSHKRL)
+
+	// Time-stamping authority
+	mux.HandleFunc("/tsa", s.handleTSA)
+
+	// Unlocking hardware providers
+	mux.HandleFunc("/api/unlock", s.handleUnlock)
+
+	// CA-scoped routes
+	mux.HandleFunc("/api/v1/cas", s.handleCAs)
+	mux.HandleFunc("/api/v1/cas/", s.handleCAs)
+}
+
+// caHandler is a handler acting on one CA; path is the request path
+// relative to the CA (e.g. "certificates/0A1B")
+type caHandler func(w http.ResponseWriter, r *http.Request, entry *CAEntry, path string)
+
+// withDefaultCA adapts a CA handler to a legacy route
+func (s *Server) withDefaultCA(h caHandler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		entry, ok := s.lookupCA("")
+		if !ok {
+			http.Error(w, "No default CA configured", http.StatusNotFound)
+			return
+		}
+
+		// Map the legacy paths onto the CA-relative ones
+		path := strings.TrimPrefix(r.URL.Path, "/")
+		path = strings.TrimPrefix(path, "api/")
+		path = strings.Replace(path, "certificate/", "certificates/", 1)
+		h(w, r, entry, path)
+	}
+}
+
+// lookupCA returns a CA by name, or the default CA when name is empty
+func (s *Server) lookupCA(name string) (*CAEntry, bool) {
+	if s.Registry != nil {
+		if name == "" {
+			return s.Registry.DefaultCA()
+		}
+		return s.Registry.Get(name)
+	}
+
+	if s.CA == nil || (name != "" && name != DefaultCAName) {
+		return nil, false
+	}
+	return &CAEntry{
+		Name:         DefaultCAName,
+		CA:           s.CA,
+		Slot:         crypto.FromYubiKeySlot(s.YubiKeySlot),
+		CertDir:      s.CertDir,
+		CSRDir:       s.CSRDir,
+		CRLPublisher: s.CRLPublisher,
+	}, true
+}
+
+// entries returns every CA served by this server
+func (s *Server) entries() []*CAEntry {
+	if s.Registry != nil {
+		return s.Registry.Entries()
+	}
+	if entry, ok := s.lookupCA(""); ok {
+		return []*CAEntry{entry}
+	}
+	return nil
+}
+
+// CAInfo describes a CA served by pica-web
+type CAInfo struct {
+	Name        string `json:"name"`
+	Default     bool   `json:"default"`
+	Subject     string `json:"subject,omitempty"`
+	Issuer      string `json:"issuer,omitempty"`
+	NotAfter    string `json:"notAfter,omitempty"`
+	Certificate string `json:"certificate,omitempty"`
+}
+
+// handleCAs serves /api/v1/cas and the CA-scoped routes below it
+func (s *Server) handleCAs(w http.ResponseWriter, r *http.Request) {
+	rest := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/v1/cas"), "/")
+	if rest == "" {
+		s.handleListCAs(w, r)
+		return
+	}
+
+	name, path, _ := strings.Cut(rest, "/")
+	entry, ok := s.lookupCA(name)
+	if !ok {
+		http.Error(w, fmt.Sprintf("CA %q not found", name), http.StatusNotFound)
+		return
+	}
+
+	switch {
+	case path == "":
+		s.handleCAInfo(w, r, entry, path)
+	case path == "submit-csr":
+		s.handleSubmitCSR(w, r, entry, path)
+	case path == "certificates":
+		s.handleListCertificates(w, r, entry, path)
+	case strings.HasPrefix(path, "certificates/"):
+		s.handleGetCertificate(w, r, entry, path)
+	case path == "revoke":
+		s.handleRevokeCertificate(w, r, entry, path)
+	case path == "crl", strings.HasPrefix(path, "crl/"):
+		s.handleCRL(w, r, entry, path)
+	case path == "chains":
+		s.handleChains(w, r, entry, path)
+	case path == "policy/test":
+		s.handlePolicyTest(w, r, entry, path)
+	case path == "templates", strings.HasPrefix(path, "templates/"):
+		s.handleTemplates(w, r, entry, path)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// handleListCAs lists the CAs served by this instance
+func (s *Server) handleListCAs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	defaultCA, _ := s.lookupCA("")
+	cas := []CAInfo{}
+	for _, entry := range s.entries() {
+		info := caInfo(entry)
+		info.Default = defaultCA != nil && entry.Name == defaultCA.Name
+		info.Certificate = ""
+		cas = append(cas, info)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"cas": cas,
+	})
+}
+
+// handleCAInfo returns a CA's details and certificate
+func (s *Server) handleCAInfo(w http.ResponseWriter, r *http.Request, entry *CAEntry, _ string) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	info := caInfo(entry)
+	if defaultCA, ok := s.lookupCA(""); ok {
+		info.Default = entry.Name == defaultCA.Name
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(info)
+}
+
+// caInfo describes the certificate an entry currently issues with
+func caInfo(entry *CAEntry) CAInfo {
+	info := CAInfo{Name: entry.Name}
+	cert, _, err := entry.CA.ActiveIssuer()
+	if err != nil {
+		return info
+	}
+	info.Certificate = string(ca.EncodeChain([]*x509.Certificate{cert}))
+	info.Subject = cert.Subject.String()
+	info.Issuer = cert.Issuer.String()
+	info.NotAfter = cert.NotAfter.Format("2006-01-02")
+	return info
+}
+
+// handleChains returns the CA's issuing chains. After a rekey or renewal
+// there is one chain per valid CA certificate and link certificate, so
+// clients can pick the one ending at the root they trust.
+func (s *Server) handleChains(w http.ResponseWriter, r *http.Request, entry *CAEntry, _ string) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	chains, err := entry.CA.Chains()
+	if err != nil {
+		log.Printf("Error loading CA chains: %v", err)
+		http.Error(w, "Failed to load CA chains", http.StatusInternalServerError)
+		return
+	}
+
+	pemChains := []string{}
+	for _, chain := range chains {
+		pemChains = append(pemChains, string(ca.EncodeChain(chain)))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"chains": pemChains,
+	})
+}
+
 // handleHealth handles health check requests
 func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
@@ -74,10 +329,12 @@ func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
 type CSRRequest struct {
 	CSR     string `json:"csr"`
 	Profile string `json:"profile"`
+	// Attestation is an optional PEM YubiKey attestation of the CSR's key
+	Attestation string `json:"attestation,omitempty"`
 }
 
 // handleSubmitCSR handles CSR submission
-func (s *Server) handleSubmitCSR(w http.ResponseWriter, r *http.Request) {
+func (s *Server) handleSubmitCSR(w http.ResponseWriter, r *http.Request, entry *CAEntry, path string) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
@@ -110,28 +367,63 @@ func (s *Server) handleSubmitCSR(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Reject requests the profile's issuance policy does not allow
+	result, err := entry.CA.EvaluatePolicy([]byte(req.CSR), req.Profile)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error evaluating issuance policy: %s", err), http.StatusBadRequest)
+		return
+	}
+	if !result.Allowed {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(result)
+		return
+	}
+
 	// Save CSR to file
 	csrFilename := fmt.Sprintf("%s.csr", csr.Subject.CommonName)
-	csrPath := filepath.Join(s.CSRDir, csrFilename)
+	csrPath := filepath.Join(entry.CSRDir, csrFilename)
 	if err := os.WriteFile(csrPath, []byte(req.CSR), 0644); err != nil {
 		http.Error(w, fmt.Sprintf("Error saving CSR: %s", err), http.StatusInternalServerError)
 		return
 	}
 
+	s.publish(webhook.EventRequestPending, map[string]interface{}{
+		"ca":      entry.Name,
+		"subject": csr.Subject.CommonName,
+		"profile": req.Profile,
+	})
+
 	// Generate certificate path
 	certFilename := fmt.Sprintf("%s.crt", csr.Subject.CommonName)
-	certPath := filepath.Join(s.CertDir, certFilename)
+	certPath := filepath.Join(entry.CertDir, certFilename)
 
 	// Sign the certificate
 	cmd := commands.NewSignCommand(
-		s.CA,
+		entry.CA,
 		csrPath,
 		certPath,
 		req.Profile,
-		crypto.FromYubiKeySlot(s.YubiKeySlot),
+		entry.Slot,
 	)
+	cmd.Attestation = []byte(req.Attestation)
 
 	if err := cmd.Execute(); err != nil {
+		var lintErr *ca.LintError
+		if errors.As(err, &lintErr) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"error": err.Error(),
+				"lint":  lintErr.Report,
+			})
+			return
+		}
+		var attestationErr *ca.AttestationError
+		if errors.As(err, &attestationErr) {
+			http.Error(w, attestationErr.Error(), http.StatusForbidden)
+			return
+		}
 		http.Error(w, fmt.Sprintf("Error signing certificate: %s", err), http.StatusInternalServerError)
 		return
 	}
@@ -143,12 +435,51 @@ func (s *Server) handleSubmitCSR(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if block, _ := pem.Decode(certData); block != nil {
+		if cert, err := x509.ParseCertificate(block.Bytes); err == nil {
+			s.publish(webhook.EventIssued, map[string]interface{}{
+				"ca":           entry.Name,
+				"subject":      cert.Subject.CommonName,
+				"serialNumber": fmt.Sprintf("%X", cert.SerialNumber),
+				"notBefore":    cert.NotBefore,
+				"notAfter":     cert.NotAfter,
+				"dnsNames":     cert.DNSNames,
+				"profile":      req.Profile,
+			})
+		}
+	}
+
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{
+	json.NewEncoder(w).Encode(map[string]interface{}{
 		"certificate": string(certData),
+		"lint":        cmd.LintReport,
 	})
 }
 
+// handlePolicyTest evaluates a CSR against a profile's issuance policy
+// without signing it
+func (s *Server) handlePolicyTest(w http.ResponseWriter, r *http.Request, entry *CAEntry, path string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req CSRRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("Error parsing request: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	result, err := entry.CA.EvaluatePolicy([]byte(req.CSR), req.Profile)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error evaluating issuance policy: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
 // CertificateInfo represents certificate information
 type CertificateInfo struct {
 	Subject      string `json:"subject"`
@@ -159,14 +490,14 @@ type CertificateInfo struct {
 }
 
 // handleListCertificates handles certificate listing
-func (s *Server) handleListCertificates(w http.ResponseWriter, r *http.Request) {
+func (s *Server) handleListCertificates(w http.ResponseWriter, r *http.Request, entry *CAEntry, path string) {
 	if r.Method != http.MethodGet {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
 	// Read certificates from the certificate directory
-	files, err := os.ReadDir(s.CertDir)
+	files, err := os.ReadDir(entry.CertDir)
 	if err != nil {
 		log.Printf("Error reading certificate directory: %v", err)
 		http.Error(w, "Failed to list certificates", http.StatusInternalServerError)
@@ -185,7 +516,7 @@ func (s *Server) handleListCertificates(w http.ResponseWriter, r *http.Request)
 		}
 
 		// Read and parse certificate
-		certPath := filepath.Join(s.CertDir, fileName)
+		certPath := filepath.Join(entry.CertDir, fileName)
 		certData, err := os.ReadFile(certPath)
 		if err != nil {
 			log.Printf("Error reading certificate file %s: %v", fileName, err)
@@ -218,7 +549,7 @@ func (s *Server) handleListCertificates(w http.ResponseWriter, r *http.Request)
 		certs = append(certs, certInfo)
 	}
 
-	log.Printf("Found %d certificates in %s", len(certs), s.CertDir)
+	log.Printf("Found %d certificates in %s", len(certs), entry.CertDir)
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
@@ -227,21 +558,21 @@ func (s *Server) handleListCertificates(w http.ResponseWriter, r *http.Request)
 }
 
 // handleGetCertificate handles certificate retrieval
-func (s *Server) handleGetCertificate(w http.ResponseWriter, r *http.Request) {
+func (s *Server) handleGetCertificate(w http.ResponseWriter, r *http.Request, entry *CAEntry, path string) {
 	if r.Method != http.MethodGet {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
 	// Extract serial number from path
-	serialNumber := r.URL.Path[len("/api/certificate/"):]
+	serialNumber := strings.TrimPrefix(path, "certificates/")
 	if serialNumber == "" {
 		http.Error(w, "Serial number required", http.StatusBadRequest)
 		return
 	}
 
 	// Read all certificates and find the one with the matching serial number
-	files, err := os.ReadDir(s.CertDir)
+	files, err := os.ReadDir(entry.CertDir)
 	if err != nil {
 		log.Printf("Error reading certificate directory: %v", err)
 		http.Error(w, "Failed to access certificates", http.StatusInternalServerError)
@@ -257,7 +588,7 @@ func (s *Server) handleGetCertificate(w http.ResponseWriter, r *http.Request) {
 			continue
 		}
 
-		certPath := filepath.Join(s.CertDir, file.Name())
+		certPath := filepath.Join(entry.CertDir, file.Name())
 		certData, err := os.ReadFile(certPath)
 		if err != nil {
 			continue
@@ -318,7 +649,7 @@ type RevokeRequest struct {
 }
 
 // handleRevokeCertificate handles certificate revocation
-func (s *Server) handleRevokeCertificate(w http.ResponseWriter, r *http.Request) {
+func (s *Server) handleRevokeCertificate(w http.ResponseWriter, r *http.Request, entry *CAEntry, path string) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
@@ -333,10 +664,10 @@ func (s *Server) handleRevokeCertificate(w http.ResponseWriter, r *http.Request)
 
 	// Revoke the certificate
 	cmd := commands.NewRevokeCommand(
-		s.CA,
+		entry.CA,
 		req.SerialNumber,
 		req.Reason,
-		crypto.FromYubiKeySlot(s.YubiKeySlot),
+		entry.Slot,
 	)
 
 	if err := cmd.Execute(); err != nil {
@@ -344,9 +675,77 @@ func (s *Server) handleRevokeCertificate(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	s.publish(webhook.EventRevoked, map[string]interface{}{
+		"ca":           entry.Name,
+		"serialNumber": req.SerialNumber,
+		"reason":       req.Reason,
+	})
+
+	// Publish the revocation right away instead of waiting for the schedule
+	if entry.CRLPublisher != nil {
+		if err := entry.CRLPublisher.Refresh(); err != nil {
+			log.Printf("Error refreshing CRL after revocation: %v", err)
+		}
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{
 		"status":  "success",
 		"message": "Certificate revoked successfully",
 	})
 }
+
+// handleCRL serves the published CRL, the delta CRL or the CRL of an earlier
+// key as a distribution point
+func (s *Server) handleCRL(w http.ResponseWriter, r *http.Request, entry *CAEntry, path string) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if entry.CA.CRLFile == "" {
+		http.Error(w, "CRL publishing is not configured", http.StatusNotFound)
+		return
+	}
+
+	crlFile := entry.CA.CRLFile
+	switch keyID := strings.TrimPrefix(path, "crl/"); {
+	case path == "crl":
+	case keyID == "delta":
+		crlFile = ca.DeltaCRLFile(entry.CA.CRLFile)
+	default:
+		// CRLs signed by earlier keys, named by their key identifier
+		id, err := hex.DecodeString(keyID)
+		if err != nil || len(id) == 0 {
+			http.NotFound(w, r)
+			return
+		}
+		crlFile = ca.KeyCRLFile(entry.CA.CRLFile, id)
+	}
+
+	crlData, err := os.ReadFile(crlFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			http.Error(w, "CRL not yet published", http.StatusNotFound)
+			return
+		}
+		log.Printf("Error reading CRL file: %v", err)
+		http.Error(w, "Failed to read CRL", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/pkix-crl")
+	if crl, err := x509.ParseRevocationList(crlData); err == nil {
+		// Let caches hold the CRL until it is superseded
+		w.Header().Set("Expires", crl.NextUpdate.UTC().Format(http.TimeFormat))
+		w.Header().Set("Last-Modified", crl.ThisUpdate.UTC().Format(http.TimeFormat))
+	}
+	w.Write(crlData)
+}
+
+// publish sends a lifecycle event to the configured webhooks, if any
+func (s *Server) publish(eventType webhook.EventType, data map[string]interface{}) {
+	if err := s.Webhooks.Publish(eventType, data); err != nil {
+		log.Printf("Error publishing %s event: %v", eventType, err)
+	}
+}
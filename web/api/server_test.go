@@ -0,0 +1,591 @@
+package api
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cloudflare/cfssl/csr"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/billchurch/PiCA/internal/ca"
+	"github.com/billchurch/PiCA/internal/crypto"
+	"github.com/billchurch/PiCA/internal/yubikey"
+)
+
+const testSigningConfig = `{
+	"signing": {
+		"default": {"expiry": "8760h"},
+		"profiles": {
+			"server": {"usages": ["signing", "key encipherment", "server auth"], "expiry": "8760h"},
+			"timestamping": {"usages": ["digital signature", "timestamping"], "expiry": "8760h"}
+		}
+	}
+}`
+
+// newTestEntry creates a software-backed CA with its own storage below dir
+func newTestEntry(t *testing.T, dir, name string) *CAEntry {
+	t.Helper()
+	provider, err := crypto.NewSoftwareProvider(map[string]interface{}{"directory": filepath.Join(dir, name, "keys")})
+	if err != nil {
+		t.Fatalf("Failed to create provider: %v", err)
+	}
+	return newTestEntryWithProvider(t, dir, name, provider)
+}
+
+// newTestEntryWithProvider creates a CA whose key is in provider
+func newTestEntryWithProvider(t *testing.T, dir, name string, provider crypto.Provider) *CAEntry {
+	t.Helper()
+	dir = filepath.Join(dir, name)
+
+	if err := provider.Connect(); err != nil {
+		t.Fatalf("Failed to connect provider: %v", err)
+	}
+	t.Cleanup(func() { provider.Close() })
+
+	req := &csr.CertificateRequest{
+		CN:         name + " CA",
+		Names:      []csr.Name{{C: "US", O: "PiCA Test"}},
+		KeyRequest: &csr.KeyRequest{A: "ecdsa", S: 256},
+	}
+	certFile := filepath.Join(dir, "ca.pem")
+	if err := ca.GenerateRootCA(req, provider, crypto.SlotCA2, certFile, 24*time.Hour); err != nil {
+		t.Fatalf("Failed to generate CA: %v", err)
+	}
+	configFile := filepath.Join(dir, "ca-config.json")
+	if err := os.WriteFile(configFile, []byte(testSigningConfig), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+
+	c := ca.NewCAWithProvider(ca.SubCA, configFile, "", certFile, provider, crypto.SlotCA2)
+	c.DatabaseDir = filepath.Join(dir, "db")
+	c.CRLFile = filepath.Join(dir, "ca.crl")
+
+	entry := &CAEntry{
+		Name:    name,
+		CA:      c,
+		Slot:    crypto.SlotCA2,
+		CertDir: filepath.Join(dir, "certs"),
+		CSRDir:  filepath.Join(dir, "csrs"),
+	}
+	for _, d := range []string{entry.CertDir, entry.CSRDir} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			t.Fatalf("Failed to create %s: %v", d, err)
+		}
+	}
+	return entry
+}
+
+// testCSR returns a PEM-encoded CSR for the given common name
+func testCSR(t *testing.T, cn string) string {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: cn},
+		DNSNames: []string{cn},
+	}, key)
+	if err != nil {
+		t.Fatalf("Failed to create CSR: %v", err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der}))
+}
+
+func TestServerMultipleCAs(t *testing.T) {
+	dir := t.TempDir()
+
+	registry := NewRegistry()
+	registry.Default = "users"
+	for _, name := range []string{"servers", "users"} {
+		if err := registry.Add(newTestEntry(t, dir, name)); err != nil {
+			t.Fatalf("Failed to add CA %s: %v", name, err)
+		}
+	}
+
+	mux := http.NewServeMux()
+	NewServerWithRegistry(registry).RegisterRoutes(mux)
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	getJSON := func(path string, v interface{}) int {
+		t.Helper()
+		resp, err := http.Get(ts.URL + path)
+		if err != nil {
+			t.Fatalf("GET %s failed: %v", path, err)
+		}
+		defer resp.Body.Close()
+		if v != nil && resp.StatusCode == http.StatusOK {
+			if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+				t.Fatalf("Failed to decode %s: %v", path, err)
+			}
+		}
+		return resp.StatusCode
+	}
+	submit := func(path, cn string) *x509.Certificate {
+		t.Helper()
+		body, _ := json.Marshal(CSRRequest{CSR: testCSR(t, cn), Profile: "server"})
+		resp, err := http.Post(ts.URL+path, "application/json", bytes.NewReader(body))
+		if err != nil {
+			t.Fatalf("POST %s failed: %v", path, err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("POST %s returned %d", path, resp.StatusCode)
+		}
+		var result map[string]string
+		json.NewDecoder(resp.Body).Decode(&result)
+		block, _ := pem.Decode([]byte(result["certificate"]))
+		if block == nil {
+			t.Fatalf("No certificate returned by %s", path)
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			t.Fatalf("Failed to parse certificate: %v", err)
+		}
+		return cert
+	}
+
+	var list struct {
+		CAs []CAInfo `json:"cas"`
+	}
+	getJSON("/api/v1/cas", &list)
+	if len(list.CAs) != 2 || list.CAs[0].Name != "servers" || list.CAs[1].Name != "users" {
+		t.Fatalf("Unexpected CA list: %+v", list.CAs)
+	}
+	if list.CAs[0].Default || !list.CAs[1].Default {
+		t.Errorf("Expected users to be the default CA: %+v", list.CAs)
+	}
+
+	// Each CA signs with its own key
+	cert := submit("/api/v1/cas/servers/submit-csr", "web.example.com")
+	if cert.Issuer.CommonName != "servers CA" {
+		t.Errorf("Scoped request issued by %q", cert.Issuer.CommonName)
+	}
+	cert = submit("/api/submit-csr", "alice.example.com")
+	if cert.Issuer.CommonName != "users CA" {
+		t.Errorf("Legacy request issued by %q, expected the default CA", cert.Issuer.CommonName)
+	}
+
+	// Storage is namespaced per CA
+	var certs struct {
+		Certificates []CertificateInfo `json:"certificates"`
+	}
+	getJSON("/api/v1/cas/servers/certificates", &certs)
+	if len(certs.Certificates) != 1 || certs.Certificates[0].Subject != "web.example.com" {
+		t.Errorf("Unexpected servers certificates: %+v", certs.Certificates)
+	}
+	serial := certs.Certificates[0].SerialNumber
+	if code := getJSON("/api/v1/cas/servers/certificates/"+serial, nil); code != http.StatusOK {
+		t.Errorf("Expected certificate lookup to succeed, got %d", code)
+	}
+	if code := getJSON("/api/v1/cas/users/certificates/"+serial, nil); code != http.StatusNotFound {
+		t.Errorf("Expected certificate of another CA to be hidden, got %d", code)
+	}
+
+	// CRLs are served per CA
+	servers, _ := registry.Get("servers")
+	if _, err := servers.CA.GenerateCRL(time.Hour); err != nil {
+		t.Fatalf("Failed to generate CRL: %v", err)
+	}
+	resp, err := http.Get(ts.URL + "/api/v1/cas/servers/crl")
+	if err != nil {
+		t.Fatalf("GET CRL failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "application/pkix-crl" {
+		t.Errorf("Unexpected CRL response: %d %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	if code := getJSON("/crl", nil); code != http.StatusNotFound {
+		t.Errorf("Expected the default CA to have no CRL yet, got %d", code)
+	}
+
+	if code := getJSON("/api/v1/cas/unknown/certificates", nil); code != http.StatusNotFound {
+		t.Errorf("Expected unknown CA to return 404, got %d", code)
+	}
+}
+
+func TestServerIssuancePolicy(t *testing.T) {
+	entry := newTestEntry(t, t.TempDir(), "servers")
+	policyConfig := `{
+	"signing": {
+		"default": {"expiry": "8760h"},
+		"profiles": {
+			"server": {
+				"usages": ["signing", "key encipherment", "server auth"],
+				"expiry": "8760h",
+				"pica": {"policy": {"allowed_domains": ["example.com"]}}
+			}
+		}
+	}
+}`
+	if err := os.WriteFile(entry.CA.ConfigFile, []byte(policyConfig), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+
+	registry := NewRegistry()
+	if err := registry.Add(entry); err != nil {
+		t.Fatalf("Failed to add CA: %v", err)
+	}
+	mux := http.NewServeMux()
+	NewServerWithRegistry(registry).RegisterRoutes(mux)
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	post := func(path, cn string) (int, *ca.PolicyResult) {
+		t.Helper()
+		body, _ := json.Marshal(CSRRequest{CSR: testCSR(t, cn), Profile: "server"})
+		resp, err := http.Post(ts.URL+path, "application/json", bytes.NewReader(body))
+		if err != nil {
+			t.Fatalf("POST %s failed: %v", path, err)
+		}
+		defer resp.Body.Close()
+		var result ca.PolicyResult
+		json.NewDecoder(resp.Body).Decode(&result)
+		return resp.StatusCode, &result
+	}
+
+	code, result := post("/api/v1/cas/servers/policy/test", "web.example.org")
+	if code != http.StatusOK || result.Allowed || len(result.Violations) == 0 ||
+		result.Violations[0].Rule != "allowed_domains" {
+		t.Errorf("Unexpected dry-run result: %d %+v", code, result)
+	}
+	if code, result := post("/api/policy/test", "web.example.com"); code != http.StatusOK || !result.Allowed {
+		t.Errorf("Expected request to be allowed: %d %+v", code, result)
+	}
+
+	code, result = post("/api/v1/cas/servers/submit-csr", "web.example.org")
+	if code != http.StatusForbidden || result.Allowed || len(result.Violations) == 0 {
+		t.Errorf("Expected rejected submission, got %d %+v", code, result)
+	}
+	if files, _ := os.ReadDir(entry.CertDir); len(files) != 0 {
+		t.Errorf("Rejected request produced certificates: %v", files)
+	}
+}
+
+func TestServerTemplates(t *testing.T) {
+	entry := newTestEntry(t, t.TempDir(), "servers")
+	registry := NewRegistry()
+	if err := registry.Add(entry); err != nil {
+		t.Fatalf("Failed to add CA: %v", err)
+	}
+	mux := http.NewServeMux()
+	NewServerWithRegistry(registry).RegisterRoutes(mux)
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	do := func(method, path, body string) (int, []byte) {
+		t.Helper()
+		req, _ := http.NewRequest(method, ts.URL+path, strings.NewReader(body))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s %s failed: %v", method, path, err)
+		}
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, data
+	}
+
+	base := "/api/v1/cas/servers/templates"
+	web := `{"name": "web", "key_usages": ["digital signature"], "ext_key_usages": ["server auth"], "validity": "2160h"}`
+	if code, body := do(http.MethodPost, base, web); code != http.StatusCreated {
+		t.Fatalf("Create failed: %d %s", code, body)
+	}
+	if code, _ := do(http.MethodPost, base, web); code != http.StatusConflict {
+		t.Errorf("Expected conflict for duplicate template, got %d", code)
+	}
+	if code, body := do(http.MethodPut, base+"/web", `{"validity": "720h", "ext_key_usages": ["server auth"]}`); code != http.StatusOK {
+		t.Fatalf("Update failed: %d %s", code, body)
+	}
+
+	code, body := do(http.MethodGet, "/api/templates/web/versions", "")
+	var versions []ca.Template
+	json.Unmarshal(body, &versions)
+	if code != http.StatusOK || len(versions) != 2 {
+		t.Errorf("Unexpected versions: %d %s", code, body)
+	}
+
+	// The template is signable right away
+	req, _ := json.Marshal(CSRRequest{CSR: testCSR(t, "www.example.com"), Profile: "web"})
+	if code, body := do(http.MethodPost, "/api/v1/cas/servers/submit-csr", string(req)); code != http.StatusOK {
+		t.Errorf("Signing with template failed: %d %s", code, body)
+	}
+
+	if code, body := do(http.MethodPost, base+"/web/rollback", `{"version": 1}`); code != http.StatusOK ||
+		!strings.Contains(string(body), `"2160h"`) {
+		t.Errorf("Rollback failed: %d %s", code, body)
+	}
+	if code, body := do(http.MethodGet, base+"/export", ""); code != http.StatusOK || !strings.Contains(string(body), `"web"`) {
+		t.Errorf("Export failed: %d %s", code, body)
+	}
+	if code, _ := do(http.MethodDelete, base+"/web", ""); code != http.StatusNoContent {
+		t.Errorf("Delete failed: %d", code)
+	}
+	if code, _ := do(http.MethodGet, base+"/web", ""); code != http.StatusNotFound {
+		t.Errorf("Expected deleted template to be gone, got %d", code)
+	}
+}
+
+func TestServerSSH(t *testing.T) {
+	dir := t.TempDir()
+	entry := newTestEntry(t, dir, "servers")
+	registry := NewRegistry()
+	if err := registry.Add(entry); err != nil {
+		t.Fatalf("Failed to add CA: %v", err)
+	}
+	server := NewServerWithRegistry(registry)
+	mux := http.NewServeMux()
+	server.RegisterRoutes(mux)
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	do := func(method, path, body string) (int, []byte) {
+		t.Helper()
+		req, _ := http.NewRequest(method, ts.URL+path, strings.NewReader(body))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s %s failed: %v", method, path, err)
+		}
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, data
+	}
+
+	if code, _ := do(http.MethodGet, "/api/ssh/ca.pub", ""); code != http.StatusNotFound {
+		t.Errorf("Expected 404 without an SSH CA, got %d", code)
+	}
+
+	configFile := filepath.Join(dir, "ssh.json")
+	profiles := `{"default": "user", "profiles": {"user": {"type": "user", "validity": "8h", "allowed_principals": ["*"]}}}`
+	if err := os.WriteFile(configFile, []byte(profiles), 0644); err != nil {
+		t.Fatalf("Failed to write SSH config: %v", err)
+	}
+	server.SSH = ca.NewSSHCA(entry.CA.Provider, crypto.SlotSSH, configFile, filepath.Join(dir, "ssh-db"))
+	if _, err := server.SSH.GenerateKey("ECDSA", 256); err != nil {
+		t.Fatalf("Failed to generate SSH CA key: %v", err)
+	}
+
+	code, body := do(http.MethodGet, "/api/ssh/ca.pub", "")
+	if code != http.StatusOK || !strings.HasPrefix(string(body), "ecdsa-sha2-nistp256 ") {
+		t.Errorf("Unexpected CA key: %d %s", code, body)
+	}
+
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	sshKey, _ := ssh.NewPublicKey(&key.PublicKey)
+	req, _ := json.Marshal(ca.SSHRequest{PublicKey: string(ssh.MarshalAuthorizedKey(sshKey)), Principals: []string{"alice"}})
+	code, body = do(http.MethodPost, "/api/ssh/sign", string(req))
+	var issued SSHCertificateResponse
+	json.Unmarshal(body, &issued)
+	if code != http.StatusOK || issued.KeyID != "alice" || !strings.HasPrefix(issued.Certificate, ssh.CertAlgoECDSA256v01) {
+		t.Fatalf("Sign failed: %d %s", code, body)
+	}
+
+	bad, _ := json.Marshal(ca.SSHRequest{PublicKey: string(ssh.MarshalAuthorizedKey(sshKey)), Principals: []string{"alice"}, Profile: "host"})
+	if code, _ := do(http.MethodPost, "/api/ssh/sign", string(bad)); code != http.StatusNotFound {
+		t.Errorf("Expected 404 for an unknown profile, got %d", code)
+	}
+
+	revoke := `{"serialNumber": "` + issued.SerialNumber + `", "reason": "keyCompromise"}`
+	if code, body := do(http.MethodPost, "/api/ssh/revoke", revoke); code != http.StatusOK {
+		t.Errorf("Revoke failed: %d %s", code, body)
+	}
+	code, body = do(http.MethodGet, "/api/ssh/certificates", "")
+	if code != http.StatusOK || !strings.Contains(string(body), `"Revoked"`) {
+		t.Errorf("Unexpected certificates: %d %s", code, body)
+	}
+
+	code, body = do(http.MethodGet, "/ssh/krl", "")
+	if code != http.StatusOK || !bytes.HasPrefix(body, []byte("SSHKRL\n")) {
+		t.Errorf("Unexpected KRL: %d %q", code, body)
+	}
+}
+
+func TestServerTSA(t *testing.T) {
+	dir := t.TempDir()
+	entry := newTestEntry(t, dir, "servers")
+	registry := NewRegistry()
+	if err := registry.Add(entry); err != nil {
+		t.Fatalf("Failed to add CA: %v", err)
+	}
+	server := NewServerWithRegistry(registry)
+	mux := http.NewServeMux()
+	server.RegisterRoutes(mux)
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	digest := sha256.Sum256([]byte("document"))
+	type messageImprint struct {
+		HashAlgorithm pkix.AlgorithmIdentifier
+		HashedMessage []byte
+	}
+	query, err := asn1.Marshal(struct {
+		Version        int
+		MessageImprint messageImprint
+	}{1, messageImprint{pkix.AlgorithmIdentifier{Algorithm: asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}}, digest[:]}})
+	if err != nil {
+		t.Fatalf("Failed to encode request: %v", err)
+	}
+
+	post := func(contentType string, body []byte) (int, string, []byte) {
+		t.Helper()
+		resp, err := http.Post(ts.URL+"/tsa", contentType, bytes.NewReader(body))
+		if err != nil {
+			t.Fatalf("POST /tsa failed: %v", err)
+		}
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, resp.Header.Get("Content-Type"), data
+	}
+
+	if code, _, _ := post(ca.TimeStampQueryType, query); code != http.StatusNotFound {
+		t.Errorf("Expected 404 without a TSA, got %d", code)
+	}
+
+	certFile := filepath.Join(dir, "tsa.pem")
+	req := &csr.CertificateRequest{CN: "Test TSA", KeyRequest: &csr.KeyRequest{A: "ecdsa", S: 256}}
+	if _, err := entry.CA.IssueTimeStampingCertificate(req, entry.CA.Provider, crypto.SlotTSA, certFile, true); err != nil {
+		t.Fatalf("Failed to issue TSA certificate: %v", err)
+	}
+	server.TSA, err = ca.NewTSA(entry.CA.Provider, crypto.SlotTSA, certFile, "1.3.6.1.4.1.99999.1", filepath.Join(dir, "tsa"))
+	if err != nil {
+		t.Fatalf("Failed to create TSA: %v", err)
+	}
+
+	if code, _, _ := post("application/octet-stream", query); code != http.StatusUnsupportedMediaType {
+		t.Errorf("Expected 415 for the wrong content type, got %d", code)
+	}
+
+	var status struct {
+		Status struct{ Status int }
+	}
+	code, contentType, body := post(ca.TimeStampQueryType, query)
+	if code != http.StatusOK || contentType != ca.TimeStampReplyType {
+		t.Fatalf("Unexpected response: %d %s", code, contentType)
+	}
+	if _, err := asn1.Unmarshal(body, &status); err != nil || status.Status.Status != 0 {
+		t.Errorf("Expected a granted response, got %d (%v)", status.Status.Status, err)
+	}
+
+	// Rejections are still time-stamp responses
+	code, _, body = post(ca.TimeStampQueryType, []byte("not a request"))
+	if _, err := asn1.Unmarshal(body, &status); code != http.StatusOK || err != nil || status.Status.Status != 2 {
+		t.Errorf("Expected a rejection response, got %d status %d (%v)", code, status.Status.Status, err)
+	}
+
+	tokens, err := ca.TimeStampTokens(server.TSA.DatabaseDir)
+	if err != nil || len(tokens) != 1 {
+		t.Errorf("Expected one logged token, got %d (%v)", len(tokens), err)
+	}
+}
+
+func TestServerUnlock(t *testing.T) {
+	t.Setenv("PICA_YUBIKEY_PIN", "")
+	t.Setenv("PICA_YUBIKEY_PIN_FILE", "")
+	provider, err := crypto.NewYubiKeyProvider(map[string]interface{}{"reader": yubikey.VirtualReader})
+	if err != nil {
+		t.Fatalf("Failed to create provider: %v", err)
+	}
+	cache := crypto.NewCredentialCache(time.Minute)
+	crypto.SetCredentials(provider, cache.Callback)
+
+	// Creating the CA signs with the key, so unlock while setting up
+	cache.Unlock(crypto.CredentialPIN, yubikey.DefaultPIN)
+	registry := NewRegistry()
+	if err := registry.Add(newTestEntryWithProvider(t, t.TempDir(), "servers", provider)); err != nil {
+		t.Fatalf("Failed to add CA: %v", err)
+	}
+	cache.Lock()
+
+	server := NewServerWithRegistry(registry)
+	server.Credentials = cache
+	mux := http.NewServeMux()
+	server.RegisterRoutes(mux)
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	submit := func() int {
+		t.Helper()
+		body, _ := json.Marshal(CSRRequest{CSR: testCSR(t, "www.example.com"), Profile: "server"})
+		resp, err := http.Post(ts.URL+"/api/submit-csr", "application/json", bytes.NewReader(body))
+		if err != nil {
+			t.Fatalf("POST /api/submit-csr failed: %v", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	unlock := func(method, pin string) (int, map[string]interface{}) {
+		t.Helper()
+		body, _ := json.Marshal(UnlockRequest{PIN: pin})
+		req, _ := http.NewRequest(method, ts.URL+"/api/unlock", bytes.NewReader(body))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s /api/unlock failed: %v", method, err)
+		}
+		defer resp.Body.Close()
+		var result map[string]interface{}
+		json.NewDecoder(resp.Body).Decode(&result)
+		return resp.StatusCode, result
+	}
+
+	if code := submit(); code != http.StatusInternalServerError {
+		t.Errorf("Expected signing to fail while locked, got %d", code)
+	}
+	if code, result := unlock(http.MethodGet, ""); code != http.StatusOK || result["unlocked"] != false || result["pinRetries"] != 3.0 {
+		t.Errorf("Unexpected status while locked: %d %v", code, result)
+	}
+
+	code, result := unlock(http.MethodPost, "000000")
+	if code != http.StatusForbidden || result["pinRetries"] != 2.0 {
+		t.Errorf("Expected 403 with 2 retries for a wrong PIN, got %d %v", code, result)
+	}
+	// The client has to wait before trying again
+	if code, _ := unlock(http.MethodPost, yubikey.DefaultPIN); code != http.StatusTooManyRequests {
+		t.Errorf("Expected 429 right after a wrong PIN, got %d", code)
+	}
+	server.unlockGuard.reset("127.0.0.1")
+	code, result = unlock(http.MethodPost, yubikey.DefaultPIN)
+	if code != http.StatusOK || result["unlocked"] != true || result["expires"] == nil || result["pinRetries"] != 3.0 {
+		t.Errorf("Unexpected status after unlocking: %d %v", code, result)
+	}
+	if code := submit(); code != http.StatusOK {
+		t.Errorf("Expected signing to succeed once unlocked, got %d", code)
+	}
+
+	if code, result := unlock(http.MethodDelete, ""); code != http.StatusOK || result["unlocked"] != false {
+		t.Errorf("Unexpected status after locking: %d %v", code, result)
+	}
+	if code := submit(); code != http.StatusInternalServerError {
+		t.Errorf("Expected signing to fail after locking, got %d", code)
+	}
+
+	// The last PIN attempt is never spent remotely
+	for i := 0; i < 2; i++ {
+		server.unlockGuard.reset("127.0.0.1")
+		unlock(http.MethodPost, "000000")
+	}
+	server.unlockGuard.reset("127.0.0.1")
+	code, result = unlock(http.MethodPost, yubikey.DefaultPIN)
+	if code != http.StatusForbidden || result["pinRetries"] != 1.0 {
+		t.Errorf("Expected 403 with 1 retry left, got %d %v", code, result)
+	}
+	if retries, _, _ := provider.(crypto.PINManager).PINRetries(); retries != 1 {
+		t.Errorf("Expected the PIN not to be tried, %d retries left", retries)
+	}
+}
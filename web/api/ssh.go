@@ -0,0 +1,209 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/billchurch/PiCA/internal/ca"
+	"github.com/billchurch/PiCA/internal/webhook"
+)
+
+// SSHCertificateResponse is returned for an issued SSH certificate
+type SSHCertificateResponse struct {
+	// Certificate is in authorized_keys format, ready to save as
+	// id_<type>-cert.pub
+	Certificate  string    `json:"certificate"`
+	SerialNumber string    `json:"serialNumber"`
+	KeyID        string    `json:"keyId"`
+	Principals   []string  `json:"principals"`
+	NotBefore    time.Time `json:"notBefore"`
+	NotAfter     time.Time `json:"notAfter"`
+}
+
+// handleSSH serves the SSH CA routes:
+//
+//	/api/ssh/ca.pub         GET CA public key
+//	/api/ssh/profiles       GET profiles
+//	/api/ssh/sign           POST SSHRequest
+//	/api/ssh/certificates   GET issued certificates
+//	/api/ssh/revoke         POST {"serialNumber": ..., "reason": ...}
+//	/api/ssh/krl            GET key revocation list
+func (s *Server) handleSSH(w http.ResponseWriter, r *http.Request) {
+	if s.SSH == nil {
+		http.Error(w, "SSH CA is not configured", http.StatusNotFound)
+		return
+	}
+
+	switch strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/ssh"), "/") {
+	case "ca.pub":
+		s.handleSSHPublicKey(w, r)
+	case "profiles":
+		s.handleSSHProfiles(w, r)
+	case "sign":
+		s.handleSSHSign(w, r)
+	case "certificates":
+		s.handleSSHCertificates(w, r)
+	case "revoke":
+		s.handleSSHRevoke(w, r)
+	case "krl":
+		s.handleSSHKRL(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// handleSSHPublicKey serves the CA key for TrustedUserCAKeys and
+// @cert-authority lines
+func (s *Server) handleSSHPublicKey(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	key, err := s.SSH.PublicKey()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error reading SSH CA key: %s", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write(ssh.MarshalAuthorizedKey(key))
+}
+
+// handleSSHProfiles lists the SSH profiles
+func (s *Server) handleSSHProfiles(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	cfg, err := s.SSH.Config()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error loading SSH profiles: %s", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(cfg)
+}
+
+// handleSSHSign issues an SSH certificate
+func (s *Server) handleSSHSign(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req ca.SSHRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("Error parsing request: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	cert, err := s.SSH.Sign(&req)
+	if err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, ca.ErrSSHProfileNotFound) {
+			status = http.StatusNotFound
+		}
+		http.Error(w, fmt.Sprintf("Error signing SSH certificate: %s", err), status)
+		return
+	}
+
+	resp := SSHCertificateResponse{
+		Certificate:  string(ssh.MarshalAuthorizedKey(cert)),
+		SerialNumber: ca.FormatSSHSerial(cert.Serial),
+		KeyID:        cert.KeyId,
+		Principals:   cert.ValidPrincipals,
+		NotBefore:    time.Unix(int64(cert.ValidAfter), 0).UTC(),
+		NotAfter:     time.Unix(int64(cert.ValidBefore), 0).UTC(),
+	}
+	s.publish(webhook.EventIssued, map[string]interface{}{
+		"ca":           "ssh",
+		"subject":      resp.KeyID,
+		"serialNumber": resp.SerialNumber,
+		"notBefore":    resp.NotBefore,
+		"notAfter":     resp.NotAfter,
+		"principals":   resp.Principals,
+		"profile":      req.Profile,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// handleSSHCertificates lists the issued SSH certificates
+func (s *Server) handleSSHCertificates(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	store, err := s.SSH.Store()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error opening SSH certificate database: %s", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(store.List())
+}
+
+// handleSSHRevoke revokes an SSH certificate and republishes the KRL
+func (s *Server) handleSSHRevoke(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req RevokeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("Error parsing request: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	record, err := s.SSH.Revoke(req.SerialNumber, req.Reason)
+	if record == nil {
+		http.Error(w, fmt.Sprintf("Error revoking SSH certificate: %s", err), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		// The revocation is recorded; the next publication picks it up
+		log.Printf("Error publishing KRL after revocation: %v", err)
+	}
+
+	s.publish(webhook.EventRevoked, map[string]interface{}{
+		"ca":           "ssh",
+		"serialNumber": record.SerialNumber,
+		"reason":       req.Reason,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"status":  "success",
+		"message": "SSH certificate revoked successfully",
+	})
+}
+
+// handleSSHKRL serves the key revocation list for sshd's RevokedKeys
+func (s *Server) handleSSHKRL(w http.ResponseWriter, r *http.Request) {
+	if s.SSH == nil {
+		http.Error(w, "SSH CA is not configured", http.StatusNotFound)
+		return
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	krl, err := s.SSH.KRL()
+	if err != nil {
+		log.Printf("Error generating KRL: %v", err)
+		http.Error(w, "Failed to generate KRL", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", `attachment; filename="ssh.krl"`)
+	w.Write(krl)
+}
@@ -0,0 +1,214 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/billchurch/PiCA/internal/ca"
+)
+
+// RollbackRequest selects the template version to restore
+type RollbackRequest struct {
+	Version int `json:"version"`
+}
+
+// handleTemplates serves the template routes of a CA:
+//
+//	templates                         GET list, POST create
+//	templates/export                  GET cfssl config
+//	templates/import                  POST cfssl config
+//	templates/{name}                  GET, PUT, DELETE
+//	templates/{name}/versions         GET history
+//	templates/{name}/versions/{v}     GET one version
+//	templates/{name}/rollback         POST {"version": v}
+func (s *Server) handleTemplates(w http.ResponseWriter, r *http.Request, entry *CAEntry, path string) {
+	store, err := entry.CA.Templates()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error opening templates: %s", err), http.StatusInternalServerError)
+		return
+	}
+
+	rest := strings.Trim(strings.TrimPrefix(path, "templates"), "/")
+	name, sub, _ := strings.Cut(rest, "/")
+
+	switch {
+	case rest == "":
+		switch r.Method {
+		case http.MethodGet:
+			templates, err := store.List()
+			if err != nil {
+				templateError(w, err)
+				return
+			}
+			if templates == nil {
+				templates = []*ca.Template{}
+			}
+			writeTemplateJSON(w, http.StatusOK, templates)
+		case http.MethodPost:
+			s.saveTemplate(w, r, entry, store, "")
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+
+	case rest == "export":
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		data, err := entry.CA.ExportTemplates()
+		if err != nil {
+			templateError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(data)
+
+	case rest == "import":
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error reading request: %s", err), http.StatusBadRequest)
+			return
+		}
+		saved, err := entry.CA.ImportTemplates(data, r.URL.Query().Get("comment"))
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error importing templates: %s", err), http.StatusBadRequest)
+			return
+		}
+		writeTemplateJSON(w, http.StatusOK, saved)
+
+	case sub == "":
+		switch r.Method {
+		case http.MethodGet:
+			t, err := store.Get(name)
+			if err != nil {
+				templateError(w, err)
+				return
+			}
+			writeTemplateJSON(w, http.StatusOK, t)
+		case http.MethodPut:
+			s.saveTemplate(w, r, entry, store, name)
+		case http.MethodDelete:
+			if err := store.Delete(name); err != nil {
+				templateError(w, err)
+				return
+			}
+			if err := entry.CA.ApplyTemplates(); err != nil {
+				templateError(w, err)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+
+	case sub == "versions" || strings.HasPrefix(sub, "versions/"):
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if v := strings.TrimPrefix(sub, "versions/"); v != sub {
+			version, err := strconv.Atoi(v)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("Invalid version %q", v), http.StatusBadRequest)
+				return
+			}
+			t, err := store.Version(name, version)
+			if err != nil {
+				templateError(w, err)
+				return
+			}
+			writeTemplateJSON(w, http.StatusOK, t)
+			return
+		}
+		versions, err := store.Versions(name)
+		if err != nil {
+			templateError(w, err)
+			return
+		}
+		writeTemplateJSON(w, http.StatusOK, versions)
+
+	case sub == "rollback":
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var req RollbackRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, fmt.Sprintf("Error parsing request: %s", err), http.StatusBadRequest)
+			return
+		}
+		t, err := store.Rollback(name, req.Version)
+		if err != nil {
+			templateError(w, err)
+			return
+		}
+		if err := entry.CA.ApplyTemplates(); err != nil {
+			templateError(w, err)
+			return
+		}
+		writeTemplateJSON(w, http.StatusOK, t)
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// saveTemplate creates a template (POST) or a new version of the named
+// template (PUT) and applies the templates to the CA's config
+func (s *Server) saveTemplate(w http.ResponseWriter, r *http.Request, entry *CAEntry, store *ca.TemplateStore, name string) {
+	var t ca.Template
+	if err := json.NewDecoder(r.Body).Decode(&t); err != nil {
+		http.Error(w, fmt.Sprintf("Error parsing request: %s", err), http.StatusBadRequest)
+		return
+	}
+	if name != "" {
+		if t.Name != "" && t.Name != name {
+			http.Error(w, "Template name does not match the URL", http.StatusBadRequest)
+			return
+		}
+		t.Name = name
+	} else if _, err := store.Get(t.Name); err == nil {
+		http.Error(w, fmt.Sprintf("Template %q already exists", t.Name), http.StatusConflict)
+		return
+	}
+
+	saved, err := store.Save(&t)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid template: %s", err), http.StatusBadRequest)
+		return
+	}
+	if err := entry.CA.ApplyTemplates(); err != nil {
+		templateError(w, err)
+		return
+	}
+
+	status := http.StatusOK
+	if name == "" {
+		status = http.StatusCreated
+	}
+	writeTemplateJSON(w, status, saved)
+}
+
+// templateError maps template store errors to HTTP status codes
+func templateError(w http.ResponseWriter, err error) {
+	if errors.Is(err, ca.ErrTemplateNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+}
+
+func writeTemplateJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,59 @@
+package api
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/billchurch/PiCA/internal/ca"
+)
+
+// maxTimeStampQuery bounds the size of a time-stamp request; a request
+// carries little more than a digest
+const maxTimeStampQuery = 64 << 10
+
+// handleTSA answers RFC 3161 time-stamp requests posted as
+// application/timestamp-query
+func (s *Server) handleTSA(w http.ResponseWriter, r *http.Request) {
+	if s.TSA == nil {
+		http.Error(w, "Time-stamping authority is not configured", http.StatusNotFound)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if ct := r.Header.Get("Content-Type"); ct != ca.TimeStampQueryType {
+		http.Error(w, "Content-Type must be "+ca.TimeStampQueryType, http.StatusUnsupportedMediaType)
+		return
+	}
+
+	query, err := io.ReadAll(io.LimitReader(r.Body, maxTimeStampQuery+1))
+	if err != nil {
+		http.Error(w, "Error reading request", http.StatusBadRequest)
+		return
+	}
+	if len(query) > maxTimeStampQuery {
+		http.Error(w, "Request too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	// Rejections are answered with a TimeStampResp like granted requests
+	resp, token, err := s.TSA.Respond(query, r.RemoteAddr)
+	if resp == nil {
+		log.Printf("Error time-stamping request: %v", err)
+		http.Error(w, "Failed to time-stamp request", http.StatusInternalServerError)
+		return
+	}
+	var rejection *ca.TSARejection
+	if err != nil && !errors.As(err, &rejection) {
+		log.Printf("Error time-stamping request: %v", err)
+	}
+	if token != nil {
+		log.Printf("Issued time-stamp token %s for %s", token.SerialNumber, r.RemoteAddr)
+	}
+
+	w.Header().Set("Content-Type", ca.TimeStampReplyType)
+	w.Write(resp)
+}
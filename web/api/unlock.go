@@ -0,0 +1,225 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/billchurch/PiCA/internal/crypto"
+)
+
+// UnlockRequest is the body of POST /api/unlock
+type UnlockRequest struct {
+	PIN           string `json:"pin"`
+	ManagementKey string `json:"managementKey,omitempty"`
+}
+
+// UnlockStatus describes whether pica-web holds the PIN of its hardware
+// providers
+type UnlockStatus struct {
+	Unlocked bool       `json:"unlocked"`
+	Expires  *time.Time `json:"expires,omitempty"`
+	// PINRetries is the lowest PIN retry count of the hardware providers
+	PINRetries *int `json:"pinRetries,omitempty"`
+}
+
+// handleUnlock serves /api/unlock: GET reports the status, POST checks the
+// PIN against every PIN-protected provider and caches it, DELETE forgets it.
+// The API does not authenticate clients, so this route must only be
+// reachable through an authenticating proxy or from the operator's network.
+func (s *Server) handleUnlock(w http.ResponseWriter, r *http.Request) {
+	if s.Credentials == nil {
+		http.Error(w, "Credential cache is not configured", http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		if !s.verifyUnlock(w, r) {
+			return
+		}
+	case http.MethodDelete:
+		s.Credentials.Lock()
+		log.Printf("Locked by %s", r.RemoteAddr)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var status UnlockStatus
+	var expires time.Time
+	if status.Unlocked, expires = s.Credentials.Unlocked(crypto.CredentialPIN); status.Unlocked && !expires.IsZero() {
+		status.Expires = &expires
+	}
+	for _, m := range s.pinManagers() {
+		if pin, _, err := m.PINRetries(); err == nil && pin >= 0 && (status.PINRetries == nil || pin < *status.PINRetries) {
+			status.PINRetries = &pin
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
+
+// verifyUnlock checks the PIN of a POST /api/unlock and caches it, writing
+// the error response and returning false when it is refused
+func (s *Server) verifyUnlock(w http.ResponseWriter, r *http.Request) bool {
+	var req UnlockRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("Error parsing request: %s", err), http.StatusBadRequest)
+		return false
+	}
+	if req.PIN == "" {
+		http.Error(w, "PIN is required", http.StatusBadRequest)
+		return false
+	}
+
+	managers := s.pinManagers()
+	if len(managers) == 0 {
+		http.Error(w, "No provider needs a PIN", http.StatusBadRequest)
+		return false
+	}
+
+	// One attempt at a time, so the retry counters read below stay current
+	s.unlockGuard.mutex.Lock()
+	defer s.unlockGuard.mutex.Unlock()
+
+	client := clientHost(r)
+	if wait := s.unlockGuard.wait(client, time.Now()); wait > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(wait.Round(time.Second)/time.Second)))
+		http.Error(w, "Too many failed unlock attempts, try again later", http.StatusTooManyRequests)
+		return false
+	}
+
+	// Never spend the last attempt remotely: a blocked PIN needs the PUK
+	for _, m := range managers {
+		if pin, _, err := m.PINRetries(); err == nil && pin >= 0 && pin <= unlockMinRetries {
+			log.Printf("Unlock refused for %s: %d PIN retries left", r.RemoteAddr, pin)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusForbidden)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"error":      "too few PIN retries left to verify remotely; reset the retry counter on the CA host with `pica pin`",
+				"pinRetries": pin,
+			})
+			return false
+		}
+	}
+
+	for _, m := range managers {
+		if err := m.VerifyPIN(req.PIN); err != nil {
+			var credErr *crypto.CredentialError
+			if errors.As(err, &credErr) {
+				s.unlockGuard.fail(client, time.Now())
+				log.Printf("Unlock rejected for %s: %v", r.RemoteAddr, err)
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusForbidden)
+				json.NewEncoder(w).Encode(map[string]interface{}{
+					"error":      err.Error(),
+					"pinRetries": credErr.Retries,
+				})
+				return false
+			}
+			http.Error(w, fmt.Sprintf("Error verifying PIN: %s", err), http.StatusInternalServerError)
+			return false
+		}
+	}
+	s.unlockGuard.reset(client)
+
+	s.Credentials.Unlock(crypto.CredentialPIN, req.PIN)
+	if req.ManagementKey != "" {
+		s.Credentials.Unlock(crypto.CredentialManagementKey, req.ManagementKey)
+	}
+	log.Printf("Unlocked by %s", r.RemoteAddr)
+	return true
+}
+
+// Unlock attempt limits
+const (
+	// unlockMinRetries is the PIN retry count at which remote unlocking stops
+	unlockMinRetries = 1
+	unlockBackoff    = 5 * time.Second
+	unlockMaxBackoff = 15 * time.Minute
+)
+
+// unlockGuard delays unlock attempts from clients that sent a wrong PIN,
+// doubling the delay with every further failure
+type unlockGuard struct {
+	mutex    sync.Mutex
+	failures map[string]int
+	blocked  map[string]time.Time
+}
+
+// wait returns how long client must wait before its next attempt
+func (g *unlockGuard) wait(client string, now time.Time) time.Duration {
+	if until, ok := g.blocked[client]; ok && now.Before(until) {
+		return until.Sub(now)
+	}
+	return 0
+}
+
+// fail records a wrong PIN from client
+func (g *unlockGuard) fail(client string, now time.Time) {
+	if g.failures == nil {
+		g.failures = make(map[string]int)
+		g.blocked = make(map[string]time.Time)
+	}
+	g.failures[client]++
+	delay := unlockBackoff
+	for i := 1; i < g.failures[client] && delay < unlockMaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > unlockMaxBackoff {
+		delay = unlockMaxBackoff
+	}
+	g.blocked[client] = now.Add(delay)
+}
+
+// reset forgets the failures of client
+func (g *unlockGuard) reset(client string) {
+	delete(g.failures, client)
+	delete(g.blocked, client)
+}
+
+// clientHost returns the address of the client without its port
+func clientHost(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// pinManagers returns the distinct PIN-protected providers of the served
+// CAs, the SSH CA and the TSA
+func (s *Server) pinManagers() []crypto.PINManager {
+	var providers []crypto.Provider
+	for _, entry := range s.entries() {
+		providers = append(providers, entry.CA.Provider)
+	}
+	if s.SSH != nil {
+		providers = append(providers, s.SSH.Provider)
+	}
+	if s.TSA != nil {
+		providers = append(providers, s.TSA.Provider)
+	}
+
+	var managers []crypto.PINManager
+	seen := make(map[crypto.Provider]bool)
+	for _, p := range providers {
+		if p == nil || seen[p] || !p.IsHardware() {
+			continue
+		}
+		seen[p] = true
+		if m, ok := p.(crypto.PINManager); ok {
+			managers = append(managers, m)
+		}
+	}
+	return managers
+}